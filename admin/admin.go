@@ -19,16 +19,107 @@ import (
 
 	"gopherbin/admin/common"
 	"gopherbin/admin/sql"
+	"gopherbin/audit"
 	"gopherbin/config"
 )
 
-// GetUserManager returns a common.UserManager based on the selected database type
-func GetUserManager(dbCfg config.Database) (common.UserManager, error) {
+// GetUserManager returns a common.UserManager based on the selected database type.
+// New passwords must follow policyCfg, and changes to users are recorded through
+// recorder. Users may also be authenticated against the optional directories.
+func GetUserManager(dbCfg config.Database, policyCfg config.PasswordPolicy, recorder audit.Recorder, directories ...common.Directory) (common.UserManager, error) {
 	dbBackend := dbCfg.DbBackend
 	switch dbBackend {
 	case config.MySQLBackend, config.SQLiteBackend:
-		return sql.NewUserManager(dbCfg)
+		return sql.NewUserManager(dbCfg, policyCfg, recorder, directories...)
 	default:
 		return nil, fmt.Errorf("no user manager available for db backend %s", dbBackend)
 	}
 }
+
+// GetAPITokenManager returns a common.APITokenManager based on the selected database type
+func GetAPITokenManager(dbCfg config.Database) (common.APITokenManager, error) {
+	dbBackend := dbCfg.DbBackend
+	switch dbBackend {
+	case config.MySQLBackend, config.SQLiteBackend:
+		return sql.NewAPITokenManager(dbCfg)
+	default:
+		return nil, fmt.Errorf("no API token manager available for db backend %s", dbBackend)
+	}
+}
+
+// GetClientCertificateManager returns a common.ClientCertificateManager based on the selected database type
+func GetClientCertificateManager(dbCfg config.Database, cfg config.TLSConfig) (common.ClientCertificateManager, error) {
+	dbBackend := dbCfg.DbBackend
+	switch dbBackend {
+	case config.MySQLBackend, config.SQLiteBackend:
+		return sql.NewClientCertificateManager(dbCfg, cfg)
+	default:
+		return nil, fmt.Errorf("no client certificate manager available for db backend %s", dbBackend)
+	}
+}
+
+// GetTwoFactorManager returns a common.TwoFactorManager based on the selected database type
+func GetTwoFactorManager(dbCfg config.Database, cfg config.TwoFactor) (common.TwoFactorManager, error) {
+	dbBackend := dbCfg.DbBackend
+	switch dbBackend {
+	case config.MySQLBackend, config.SQLiteBackend:
+		return sql.NewTwoFactorManager(dbCfg, cfg)
+	default:
+		return nil, fmt.Errorf("no two-factor manager available for db backend %s", dbBackend)
+	}
+}
+
+// GetSessionManager returns a common.SessionManager based on the selected database type
+func GetSessionManager(dbCfg config.Database) (common.SessionManager, error) {
+	dbBackend := dbCfg.DbBackend
+	switch dbBackend {
+	case config.MySQLBackend, config.SQLiteBackend:
+		return sql.NewSessionManager(dbCfg)
+	default:
+		return nil, fmt.Errorf("no session manager available for db backend %s", dbBackend)
+	}
+}
+
+// GetLoginThrottler returns a common.LoginThrottler based on the selected database type
+func GetLoginThrottler(dbCfg config.Database, cfg config.LoginThrottle) (common.LoginThrottler, error) {
+	dbBackend := dbCfg.DbBackend
+	switch dbBackend {
+	case config.MySQLBackend, config.SQLiteBackend:
+		return sql.NewLoginThrottler(dbCfg, cfg)
+	default:
+		return nil, fmt.Errorf("no login throttler available for db backend %s", dbBackend)
+	}
+}
+
+// GetRegistrationManager returns a common.RegistrationManager based on the selected database type
+func GetRegistrationManager(dbCfg config.Database, cfg config.Registration, policyCfg config.PasswordPolicy, recorder audit.Recorder) (common.RegistrationManager, error) {
+	dbBackend := dbCfg.DbBackend
+	switch dbBackend {
+	case config.MySQLBackend, config.SQLiteBackend:
+		return sql.NewRegistrationManager(dbCfg, cfg, policyCfg, recorder)
+	default:
+		return nil, fmt.Errorf("no registration manager available for db backend %s", dbBackend)
+	}
+}
+
+// GetSigningKeyManager returns a common.SigningKeyManager based on the selected database type
+func GetSigningKeyManager(dbCfg config.Database) (common.SigningKeyManager, error) {
+	dbBackend := dbCfg.DbBackend
+	switch dbBackend {
+	case config.MySQLBackend, config.SQLiteBackend:
+		return sql.NewSigningKeyManager(dbCfg)
+	default:
+		return nil, fmt.Errorf("no signing key manager available for db backend %s", dbBackend)
+	}
+}
+
+// GetAuditLog returns a common.AuditLog based on the selected database type
+func GetAuditLog(dbCfg config.Database) (common.AuditLog, error) {
+	dbBackend := dbCfg.DbBackend
+	switch dbBackend {
+	case config.MySQLBackend, config.SQLiteBackend:
+		return sql.NewAuditLog(dbCfg)
+	default:
+		return nil, fmt.Errorf("no audit log available for db backend %s", dbBackend)
+	}
+}
@@ -16,22 +16,159 @@ package common
 
 import (
 	"context"
+	"crypto/x509"
+	"io"
+	"time"
 
 	"gopherbin/params"
 )
 
+// ExternalIdentity describes a user authenticated by an external
+// identity provider, such as an OpenID Connect provider.
+type ExternalIdentity struct {
+	// Provider is the name of the identity provider, for example oidc.
+	Provider string
+	// Subject uniquely identifies the user within the provider.
+	Subject string
+	// Username is the preferred username. If it is taken, a numeric
+	// suffix is added when the user is provisioned.
+	Username      string
+	Email         string
+	EmailVerified bool
+	FullName      string
+	// IsAdmin, if set, overrides the admin flag of the user on every
+	// login. Superusers are never demoted.
+	IsAdmin *bool
+	// Enabled, if set, overrides the enabled flag of the user on every
+	// login. Disabled users are not logged in.
+	Enabled *bool
+	// ManagedTeams lists the teams whose members are managed by the
+	// provider. The user is added to the ones listed in Teams, and
+	// removed from the others.
+	ManagedTeams []string
+	Teams        []string
+	// LinkByEmail allows a user logging in for the first time to be
+	// linked to an existing local account with the same email address,
+	// provided the email address has been verified by the provider.
+	LinkByEmail bool
+	// LinkOnly rejects users logging in for the first time, instead of
+	// creating an account for them, unless they can be linked to an
+	// existing account.
+	LinkOnly bool
+}
+
+// Directory authenticates users with a username and password against an
+// external user directory, such as LDAP.
+type Directory interface {
+	// Name is the auth provider recorded for users of this directory.
+	Name() string
+	// Authenticate checks the credentials of a user and returns the
+	// identity of the user. It returns ErrInvalidCredentials if the
+	// credentials are wrong, and ErrUserNotFound if the user does not
+	// exist in the directory.
+	Authenticate(ctx context.Context, username, password string) (ExternalIdentity, error)
+}
+
+// NewSession describes a session created when a user logs in
+type NewSession struct {
+	// ID is the ID of the JWT token issued for the session.
+	ID        string
+	ExpiresAt time.Time
+	IP        string
+	UserAgent string
+}
+
+// AuditLogFilter narrows down the events returned from the audit log.
+// Empty fields match any event. Since is inclusive, Until is exclusive.
+type AuditLogFilter struct {
+	Actions []string
+	// ActorID is 0 to match anonymous actors, such as someone failing
+	// to log in.
+	ActorID    *uint
+	TargetType string
+	TargetID   string
+	IP         string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+// UserFilter narrows down the users returned when listing users. Empty
+// fields match any user.
+type UserFilter struct {
+	// Query matches a substring of the username, email address or full
+	// name, regardless of case.
+	Query   string
+	Enabled *bool
+	IsAdmin *bool
+	// LastLoginSince matches users that logged in at or after the given
+	// time.
+	LastLoginSince *time.Time
+	// LastLoginBefore matches users that did not log in since the given
+	// time, including users that never logged in.
+	LastLoginBefore *time.Time
+}
+
+// DeleteUserOptions changes what happens to the pastes, teams and shares
+// of a deleted user
+type DeleteUserOptions struct {
+	// TransferTo is the username or email address of the user that gets
+	// the pastes, teams and shares of the deleted user. They are deleted
+	// along with the user if TransferTo is empty.
+	TransferTo string
+	// DryRun reports what would be deleted or transferred, without
+	// changing anything.
+	DryRun bool
+}
+
+// SigningKey is a key used to sign login tokens
+type SigningKey struct {
+	// ID is sent as the kid header of tokens signed with the key.
+	ID        string
+	Algorithm string
+	// PrivateKey is PKCS #8 encoded for Ed25519 and RSA keys, and holds
+	// the raw key for HMAC keys.
+	PrivateKey []byte
+	CreatedAt  time.Time
+}
+
 // UserManager defines an interface for user management
 type UserManager interface {
 	Create(ctx context.Context, user params.NewUserParams) (params.Users, error)
 	Get(ctx context.Context, userID uint) (params.Users, error)
+	// GetByEmail returns the user with the given email address. Only
+	// admins may look up users by email.
+	GetByEmail(ctx context.Context, email string) (params.Users, error)
 	Update(ctx context.Context, userID uint, update params.UpdateUserPayload) (params.Users, error)
-	List(ctx context.Context, page int64, results int64) (paste params.UserListResult, err error)
-	Delete(ctx context.Context, userID uint) error
+	// List returns a page of the users matching filter. Only admins may
+	// list users.
+	List(ctx context.Context, filter UserFilter, page int64, results int64) (paste params.UserListResult, err error)
+	// Details returns the user identified by userID along with their
+	// paste count, storage used and teams. The sessions of the user are
+	// not filled in. Only admins may fetch the details of users.
+	Details(ctx context.Context, userID uint) (params.UserDetails, error)
+	// Delete deletes the user identified by userID, and either deletes or
+	// transfers their pastes, teams and shares, as set in opts.
+	Delete(ctx context.Context, userID uint, opts DeleteUserOptions) (params.UserDeletionReport, error)
+	// Profile returns the account details of the user in the context,
+	// along with their paste count, storage used and teams.
+	Profile(ctx context.Context) (params.Profile, error)
+	// ChangePassword sets a new password for the user in the context. It
+	// returns ErrWrongPassword if currentPassword is not the password of
+	// the user.
+	ChangePassword(ctx context.Context, currentPassword, newPassword string) (params.Users, error)
 	Enable(ctx context.Context, userID uint) error
 	Disable(ctx context.Context, userID uint) error
 	Authenticate(ctx context.Context, info params.PasswordLoginParams) (context.Context, error)
+	// AuthenticateExternal returns a context for a user authenticated by an
+	// external identity provider. Users logging in for the first time are
+	// provisioned automatically.
+	AuthenticateExternal(ctx context.Context, identity ExternalIdentity) (context.Context, error)
 	HasSuperUser() bool
 	CreateSuperUser(user params.NewUserParams) (params.Users, error)
+	// CheckPassword returns a validation error if password does not
+	// follow the password policy, when set for the user identified by
+	// userID.
+	CheckPassword(userID uint, password string) error
 	// ValidateToken will check if the token identified by tokenID has been
 	// blacklisted. This is needed to handle logouts when using JWT...
 	ValidateToken(tokenID string) error
@@ -40,3 +177,164 @@ type UserManager interface {
 	// CleanTokens will delete expired tokens from blacklist
 	CleanTokens() error
 }
+
+// APITokenManager defines an interface for managing personal API tokens
+type APITokenManager interface {
+	// Create creates a new token owned by the user in the context. The
+	// returned value is the only place the token itself is available.
+	Create(ctx context.Context, token params.NewAPITokenParams) (params.APIToken, error)
+	// List returns the tokens owned by userID. Users may list their own
+	// tokens, admins may list the tokens of any user.
+	List(ctx context.Context, userID uint) ([]params.APIToken, error)
+	// Revoke deletes the token identified by tokenID, owned by userID.
+	Revoke(ctx context.Context, userID uint, tokenID uint) error
+	// Authenticate looks up the owner of the token and returns a context
+	// populated with the user details.
+	Authenticate(ctx context.Context, token string) (context.Context, error)
+}
+
+// ClientCertificateManager defines an interface for managing the TLS
+// client certificates users authenticate with
+type ClientCertificateManager interface {
+	// Create registers cert for the user in the context. The caller must
+	// have verified that the user holds the private key of cert, for
+	// example because it was presented on the request connection.
+	Create(ctx context.Context, cert *x509.Certificate, info params.NewClientCertificateParams) (params.ClientCertificate, error)
+	// List returns the certificates registered by userID. Users may list
+	// their own certificates, admins may list those of any user.
+	List(ctx context.Context, userID uint) ([]params.ClientCertificate, error)
+	// Delete deletes the certificate identified by certID, registered by
+	// userID.
+	Delete(ctx context.Context, userID uint, certID uint) error
+	// Authenticate maps a verified client certificate to a user and
+	// returns a context populated with the user details.
+	Authenticate(ctx context.Context, cert *x509.Certificate) (context.Context, error)
+}
+
+// TwoFactorManager defines an interface for managing TOTP two-factor
+// authentication. Codes passed to it may be generated by an
+// authenticator app, or be one of the recovery codes of the user.
+type TwoFactorManager interface {
+	// Status returns the two-factor settings of userID. Users may see
+	// their own settings, admins may see those of any user.
+	Status(ctx context.Context, userID uint) (params.TwoFactorStatus, error)
+	// Enroll generates a new TOTP secret for the user in the context.
+	// Two-factor authentication is enabled once the secret is confirmed.
+	Enroll(ctx context.Context) (params.TwoFactorEnrollment, error)
+	// Confirm enables two-factor authentication for the user in the
+	// context, if code was generated with the enrolled secret. It returns
+	// a new set of recovery codes.
+	Confirm(ctx context.Context, code string) (params.RecoveryCodes, error)
+	// Disable disables two-factor authentication for the user in the
+	// context. A valid code is required.
+	Disable(ctx context.Context, code string) error
+	// RegenerateRecoveryCodes replaces the recovery codes of the user in
+	// the context. A valid code is required.
+	RegenerateRecoveryCodes(ctx context.Context, code string) (params.RecoveryCodes, error)
+	// Reset disables two-factor authentication for userID, without
+	// requiring a code. Only admins may reset two-factor authentication.
+	Reset(ctx context.Context, userID uint) error
+	// Verify checks a code of userID while logging in. Recovery codes
+	// are consumed. It returns ErrInvalidTwoFactorCode if the code is
+	// wrong or has already been used.
+	Verify(ctx context.Context, userID uint, code string) error
+}
+
+// SessionManager defines an interface for managing the sessions created
+// when users log in. Revoking a session logs out the token issued for it.
+type SessionManager interface {
+	// Create records a new session for the user in the context, and
+	// the time they last logged in.
+	Create(ctx context.Context, session NewSession) error
+	// Touch checks that the session identified by sessionID belongs to
+	// the user in the context and has neither expired nor been revoked,
+	// and updates the time it was last seen.
+	Touch(ctx context.Context, sessionID string) error
+	// List returns the active sessions of userID. Users may list their
+	// own sessions, admins may list the sessions of any user.
+	List(ctx context.Context, userID uint) ([]params.Session, error)
+	// Revoke deletes the session identified by sessionID, owned by userID.
+	Revoke(ctx context.Context, userID uint, sessionID string) error
+	// RevokeAll deletes all sessions of userID, logging them out
+	// everywhere. It returns the number of sessions revoked.
+	RevokeAll(ctx context.Context, userID uint) (int64, error)
+	// CleanSessions deletes expired sessions.
+	CleanSessions() error
+}
+
+// LoginThrottler defines an interface for slowing down and stopping
+// password guessing. Failed logins are counted per account and per
+// client address. The login may be a username or an email address.
+type LoginThrottler interface {
+	// Check returns a TooManyRequestsError if logging in as login, or
+	// from ip, is not allowed yet.
+	Check(ctx context.Context, login, ip string) error
+	// Failed records a failed login as login, from ip.
+	Failed(ctx context.Context, login, ip string) error
+	// CheckAddress returns a TooManyRequestsError if ip is locked out.
+	// It guards requests that are not logins to an account, but may
+	// be abused the same way, like registering.
+	CheckAddress(ctx context.Context, ip string) error
+	// FailedFromAddress records a failure from ip, without counting it
+	// against any account.
+	FailedFromAddress(ctx context.Context, ip string) error
+	// Succeeded forgets the failed logins of the account, after a
+	// successful login.
+	Succeeded(ctx context.Context, login string) error
+	// Unlock forgets the failed logins of userID, unlocking the account.
+	// Only admins may unlock accounts.
+	Unlock(ctx context.Context, userID uint) error
+	// CleanLoginAttempts deletes failed logins that are no longer
+	// relevant.
+	CleanLoginAttempts() error
+}
+
+// RegistrationManager defines an interface for users registering their
+// own accounts, and for the invite codes and approvals gating it.
+type RegistrationManager interface {
+	// Register creates an account. Depending on the registration mode,
+	// an invite code may be required. Accounts registered without one
+	// stay disabled until an admin approves them.
+	Register(ctx context.Context, registration params.RegisterParams) (params.Users, error)
+	// CreateInvite creates a new invite code. The returned value is the
+	// only place the code itself is available. Only admins may create
+	// invites.
+	CreateInvite(ctx context.Context, invite params.NewInviteParams) (params.Invite, error)
+	// ListInvites returns all invites, including used up and expired
+	// ones. Only admins may list invites.
+	ListInvites(ctx context.Context) ([]params.Invite, error)
+	// DeleteInvite deletes the invite identified by inviteID. Users that
+	// already registered with it are not affected.
+	DeleteInvite(ctx context.Context, inviteID uint) error
+	// ListPending returns the users awaiting approval.
+	ListPending(ctx context.Context, page int64, results int64) (params.UserListResult, error)
+	// Approve enables the account of a user awaiting approval.
+	Approve(ctx context.Context, userID uint) (params.Users, error)
+	// Reject deletes the account of a user awaiting approval.
+	Reject(ctx context.Context, userID uint) error
+}
+
+// SigningKeyManager defines an interface for storing the keys used to
+// sign login tokens, so all instances of gopherbin share them.
+type SigningKeyManager interface {
+	// List returns all signing keys, newest first.
+	List() ([]SigningKey, error)
+	// Create stores a new signing key.
+	Create(key SigningKey) error
+	// Prune deletes the keys replaced by a newer key before the given
+	// time. Tokens signed with those keys have expired.
+	Prune(before time.Time) error
+}
+
+// AuditLog defines an interface for storing and querying the audit log.
+// Events are only ever added, never changed or deleted.
+type AuditLog interface {
+	// Write stores an audit event.
+	Write(event params.AuditEvent) error
+	// List returns the events matching filter, newest first. Only admins
+	// may query the audit log.
+	List(ctx context.Context, filter AuditLogFilter, page int64, results int64) (params.AuditLogListResult, error)
+	// Export writes the events matching filter to w as JSON lines,
+	// oldest first. Only admins may export the audit log.
+	Export(ctx context.Context, filter AuditLogFilter, w io.Writer) error
+}
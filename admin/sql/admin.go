@@ -18,10 +18,13 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"gopherbin/admin/common"
+	"gopherbin/audit"
 	"gopherbin/auth"
+	"gopherbin/auth/passwords"
 	"gopherbin/config"
 	gErrors "gopherbin/errors"
 	"gopherbin/models"
@@ -33,21 +36,62 @@ import (
 	"gorm.io/gorm"
 )
 
-// NewUserManager returns a new *UserManager
-func NewUserManager(dbCfg config.Database) (common.UserManager, error) {
+// NewUserManager returns a new *UserManager. New passwords must follow
+// policyCfg. Changes to users are recorded in the audit log through
+// recorder, which may be nil. Users that do not exist locally are looked
+// up in the directories, if any.
+func NewUserManager(dbCfg config.Database, policyCfg config.PasswordPolicy, recorder audit.Recorder, directories ...common.Directory) (common.UserManager, error) {
 	db, err := util.NewDBConn(dbCfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "connecting to database")
 	}
+	policy, err := passwords.NewPolicy(policyCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading password policy")
+	}
+	if recorder == nil {
+		recorder = audit.Discard
+	}
 	return &userManager{
-		conn: db,
+		conn:        db,
+		policy:      policy,
+		recorder:    recorder,
+		directories: directories,
 	}, nil
 }
 
 // UserManager defined functions that handle the
 // creation and updating of users
 type userManager struct {
-	conn *gorm.DB
+	conn        *gorm.DB
+	policy      *passwords.Policy
+	recorder    audit.Recorder
+	directories []common.Directory
+}
+
+// record records action on the user identified by userID in the audit
+// log
+func (u *userManager) record(ctx context.Context, action string, userID uint, details map[string]string) {
+	u.recorder.Record(ctx, params.AuditEvent{
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   fmt.Sprintf("%d", userID),
+		Details:    details,
+	})
+}
+
+// checkPassword checks password against the password policy. The
+// details of the user it is set for may not be part of the password.
+func (u *userManager) checkPassword(password string, user models.Users) error {
+	return u.policy.Check(password, passwords.UserInputs(user.Username, user.Email, user.FullName))
+}
+
+func (u *userManager) CheckPassword(userID uint, password string) error {
+	modelUser, err := u.getUser(userID)
+	if err != nil {
+		return errors.Wrap(err, "fetching user")
+	}
+	return u.checkPassword(password, modelUser)
 }
 
 func (u *userManager) HasSuperUser() bool {
@@ -60,8 +104,24 @@ func (u *userManager) HasSuperUser() bool {
 }
 
 func (u *userManager) newUserParamsToSQL(user params.NewUserParams) (models.Users, error) {
-	if err := user.Validate(); err != nil {
-		return models.Users{}, gErrors.NewBadRequestError("error validating parameters: %s", err)
+	// Report the password policy violations along with any other
+	// invalid fields.
+	var fields []gErrors.FieldError
+	for _, err := range []error{
+		user.Validate(),
+		u.checkPassword(user.Password, models.Users{Username: user.Username, Email: user.Email, FullName: user.FullName}),
+	} {
+		if err == nil {
+			continue
+		}
+		badReq, ok := err.(*gErrors.BadRequestError)
+		if !ok {
+			return models.Users{}, errors.Wrap(err, "validating parameters")
+		}
+		fields = append(fields, badReq.Fields()...)
+	}
+	if len(fields) > 0 {
+		return models.Users{}, errors.Wrap(gErrors.NewValidationError(fields...), "validating parameters")
 	}
 	// When creating a new user only 3 fields are ever used:
 	// Email, FullName and Password. The ID is generated from the email
@@ -81,30 +141,48 @@ func (u *userManager) newUserParamsToSQL(user params.NewUserParams) (models.User
 		IsSuperUser: false,
 		Enabled:     user.Enabled,
 	}
+	if err := rotateSecurityStamp(&newUser); err != nil {
+		return models.Users{}, err
+	}
 	return newUser, nil
 }
 
+// rotateSecurityStamp sets a new security stamp on usr. Once saved, all
+// tokens issued to the user before are rejected.
+func rotateSecurityStamp(usr *models.Users) error {
+	stamp, err := auth.NewSecurityStamp()
+	if err != nil {
+		return errors.Wrap(err, "generating security stamp")
+	}
+	usr.SecurityStamp = stamp
+	return nil
+}
+
 func (u *userManager) sqlUserToParams(user models.Users) params.Users {
 	return params.Users{
-		ID:          user.ID,
-		FullName:    user.FullName,
-		Email:       user.Email,
-		Username:    user.Username,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.UpdatedAt,
-		Enabled:     user.Enabled,
-		IsAdmin:     user.IsAdmin,
-		IsSuperUser: user.IsSuperUser,
+		ID:              user.ID,
+		FullName:        user.FullName,
+		Email:           user.Email,
+		Username:        user.Username,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+		Enabled:         user.Enabled,
+		IsAdmin:         user.IsAdmin,
+		IsSuperUser:     user.IsSuperUser,
+		AuthProvider:    user.AuthProvider,
+		SecurityStamp:   user.SecurityStamp,
+		PendingApproval: user.PendingApproval,
+		LastLoginAt:     user.LastLoginAt,
 	}
 }
 
 func (u *userManager) Authenticate(ctx context.Context, info params.PasswordLoginParams) (context.Context, error) {
 	if info.Username == "" {
-		return ctx, gErrors.ErrUnauthorized
+		return ctx, gErrors.ErrInvalidCredentials
 	}
 
 	if info.Password == "" {
-		return ctx, gErrors.ErrUnauthorized
+		return ctx, gErrors.ErrInvalidCredentials
 	}
 
 	isEmail := util.IsValidEmail(info.Username)
@@ -117,22 +195,28 @@ func (u *userManager) Authenticate(ctx context.Context, info params.PasswordLogi
 	}
 
 	if err != nil {
-		if err == gErrors.ErrNotFound {
-			return ctx, gErrors.NewUnauthorizedError("invalid username or password")
+		if errors.Is(err, gErrors.ErrNotFound) {
+			return u.authenticateWithDirectories(ctx, u.directories, info)
 		}
 		return ctx, err
 	}
+	if dir := u.directory(modelUser.AuthProvider); dir != nil {
+		return u.authenticateWithDirectories(ctx, []common.Directory{dir}, info)
+	}
+	if modelUser.PendingApproval {
+		return ctx, gErrors.WithCode(gErrors.NewUnauthorizedError("account is awaiting approval"), gErrors.CodeApprovalPending)
+	}
 	if !modelUser.Enabled {
-		return ctx, gErrors.NewUnauthorizedError("user is disabled")
+		return ctx, gErrors.WithCode(gErrors.NewUnauthorizedError("user is disabled"), gErrors.CodeUserDisabled)
 	}
 	// If the user has an empty password saved in the
 	// database, it is implicitly disabled. This should not happen,
 	// but an extra check can't hurt.
 	if modelUser.Password == "" {
-		return ctx, gErrors.ErrUnauthorized
+		return ctx, gErrors.ErrInvalidCredentials
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(modelUser.Password), []byte(info.Password)); err != nil {
-		return ctx, gErrors.ErrUnauthorized
+		return ctx, gErrors.ErrInvalidCredentials
 	}
 	userParams := u.sqlUserToParams(modelUser)
 	return auth.PopulateContext(ctx, userParams), nil
@@ -147,35 +231,28 @@ func (u *userManager) Create(ctx context.Context, user params.NewUserParams) (pa
 		return params.Users{}, gErrors.ErrUnauthorized
 	}
 
-	if user.FullName == "" || len(user.FullName) > 255 {
-		return params.Users{}, gErrors.NewBadRequestError("invalid full name")
-	}
-
-	if user.Email == "" || !util.IsValidEmail(user.Email) {
-		return params.Users{}, gErrors.NewBadRequestError("invalid email")
-	}
-
-	if user.Username == "" || !util.IsAlphanumeric(user.Username) {
-		return params.Users{}, gErrors.NewBadRequestError("invalid username")
-	}
-
 	newUser, err := u.newUserParamsToSQL(user)
 	if err != nil {
 		return params.Users{}, errors.Wrap(err, "fetching user object")
 	}
 	_, err = u.getUserByEmail(newUser.Email)
 	if err != nil {
-		if err != gErrors.ErrNotFound {
+		if !errors.Is(err, gErrors.ErrNotFound) {
 			return params.Users{}, errors.Wrap(err, "fetching user")
 		}
 	} else {
-		return params.Users{}, gErrors.ErrDuplicateEntity
+		return params.Users{}, gErrors.WithCode(gErrors.NewDuplicateUserError("email address already in use"), gErrors.CodeEmailInUse)
 	}
 
 	err = u.conn.Create(&newUser).Error
 	if err != nil {
 		return params.Users{}, errors.Wrap(err, "creating new user")
 	}
+	u.record(ctx, audit.ActionUserCreate, newUser.ID, map[string]string{
+		"username": newUser.Username,
+		"email":    newUser.Email,
+		"is_admin": fmt.Sprintf("%t", newUser.IsAdmin),
+	})
 	return u.sqlUserToParams(newUser), nil
 }
 
@@ -197,6 +274,11 @@ func (u *userManager) CreateSuperUser(user params.NewUserParams) (params.Users,
 	if err != nil {
 		return params.Users{}, errors.Wrap(err, "creating new user")
 	}
+	u.record(context.Background(), audit.ActionUserCreate, newUser.ID, map[string]string{
+		"username":      newUser.Username,
+		"email":         newUser.Email,
+		"is_super_user": "true",
+	})
 	return u.sqlUserToParams(newUser), nil
 }
 
@@ -212,7 +294,51 @@ func (u *userManager) Get(ctx context.Context, userID uint) (params.Users, error
 	return u.sqlUserToParams(modelUser), nil
 }
 
-func (u *userManager) List(ctx context.Context, page int64, results int64) (paste params.UserListResult, err error) {
+func (u *userManager) GetByEmail(ctx context.Context, email string) (params.Users, error) {
+	if !auth.IsAdmin(ctx) {
+		return params.Users{}, gErrors.ErrUnauthorized
+	}
+	modelUser, err := u.getUserByEmail(email)
+	if err != nil {
+		return params.Users{}, errors.Wrap(err, "fetching user form DB")
+	}
+	return u.sqlUserToParams(modelUser), nil
+}
+
+// likePattern returns a LIKE pattern, escaped with "!", matching any
+// value containing substr. Wildcards in substr are matched literally.
+// Backslashes are not used for escaping, as MySQL treats them as escapes
+// in string literals.
+func likePattern(substr string) string {
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(substr))
+	return "%" + escaped + "%"
+}
+
+// filtered returns a query for the users matching filter
+func (u *userManager) filtered(filter common.UserFilter) *gorm.DB {
+	q := u.conn.Model(&models.Users{})
+	if filter.Query != "" {
+		pattern := likePattern(filter.Query)
+		q = q.Where(
+			"lower(username) LIKE ? ESCAPE '!' OR lower(email) LIKE ? ESCAPE '!' OR lower(full_name) LIKE ? ESCAPE '!'",
+			pattern, pattern, pattern)
+	}
+	if filter.Enabled != nil {
+		q = q.Where("enabled = ?", *filter.Enabled)
+	}
+	if filter.IsAdmin != nil {
+		q = q.Where("is_admin = ?", *filter.IsAdmin)
+	}
+	if filter.LastLoginSince != nil {
+		q = q.Where("last_login_at >= ?", *filter.LastLoginSince)
+	}
+	if filter.LastLoginBefore != nil {
+		q = q.Where("last_login_at IS NULL OR last_login_at < ?", *filter.LastLoginBefore)
+	}
+	return q
+}
+
+func (u *userManager) List(ctx context.Context, filter common.UserFilter, page int64, results int64) (paste params.UserListResult, err error) {
 	if !auth.IsAdmin(ctx) {
 		return params.UserListResult{}, gErrors.ErrUnauthorized
 	}
@@ -228,17 +354,17 @@ func (u *userManager) List(ctx context.Context, page int64, results int64) (past
 	var cnt int64
 	startFrom := (page - 1) * results
 
-	cntQ := u.conn.Model(&models.Users{}).Count(&cnt)
+	cntQ := u.filtered(filter).Count(&cnt)
 	if cntQ.Error != nil {
 		return params.UserListResult{}, errors.Wrap(cntQ.Error, "counting results")
 	}
 
-	resQ := u.conn.Offset(int(startFrom)).Limit(int(results)).Find(&userResults)
+	resQ := u.filtered(filter).Order("id").Offset(int(startFrom)).Limit(int(results)).Find(&userResults)
 	if resQ.Error != nil {
 		if errors.Is(resQ.Error, gorm.ErrRecordNotFound) {
 			return params.UserListResult{}, gErrors.ErrNotFound
 		}
-		return params.UserListResult{}, errors.Wrap(resQ.Error, "fetching pastes from database")
+		return params.UserListResult{}, errors.Wrap(resQ.Error, "fetching users from database")
 	}
 	asParams := make([]params.Users, len(userResults))
 	for idx, val := range userResults {
@@ -254,6 +380,124 @@ func (u *userManager) List(ctx context.Context, page int64, results int64) (past
 	}, nil
 }
 
+func sqlUserToTeamMember(user models.Users) params.TeamMember {
+	return params.TeamMember{
+		Username: user.Username,
+		Email:    user.Email,
+		FullName: user.FullName,
+	}
+}
+
+// userUsage returns the paste count and storage used by userID
+func (u *userManager) userUsage(userID uint) (pasteCount int64, storageUsed int64, err error) {
+	var usage struct {
+		PasteCount  int64
+		StorageUsed int64
+	}
+	q := u.conn.Model(&models.Paste{}).
+		Select("count(*) as paste_count, coalesce(sum(length(`data`)), 0) as storage_used").
+		Where("owner_id = ?", userID).
+		Scan(&usage)
+	if q.Error != nil {
+		return 0, 0, errors.Wrap(q.Error, "counting pastes")
+	}
+	return usage.PasteCount, usage.StorageUsed, nil
+}
+
+// userTeams returns the teams userID owns or is a member of
+func (u *userManager) userTeams(userID uint) ([]params.Teams, error) {
+	var teams []models.Teams
+	q := u.conn.Preload("Owner").
+		Where("owner_id = ? OR id IN (?)", userID,
+			u.conn.Table("team_users").Select("teams_id").Where("users_id = ?", userID)).
+		Order("id").
+		Find(&teams)
+	if q.Error != nil {
+		return nil, errors.Wrap(q.Error, "fetching teams")
+	}
+	asParams := make([]params.Teams, len(teams))
+	for idx, team := range teams {
+		asParams[idx] = params.Teams{
+			ID:    team.ID,
+			Name:  team.Name,
+			Owner: sqlUserToTeamMember(team.Owner),
+		}
+	}
+	return asParams, nil
+}
+
+func (u *userManager) Details(ctx context.Context, userID uint) (params.UserDetails, error) {
+	if !auth.IsAdmin(ctx) {
+		return params.UserDetails{}, gErrors.ErrUnauthorized
+	}
+	usr, err := u.getUser(userID)
+	if err != nil {
+		return params.UserDetails{}, errors.Wrap(err, "fetching user from db")
+	}
+	pasteCount, storageUsed, err := u.userUsage(userID)
+	if err != nil {
+		return params.UserDetails{}, err
+	}
+	teams, err := u.userTeams(userID)
+	if err != nil {
+		return params.UserDetails{}, err
+	}
+
+	return params.UserDetails{
+		User:        u.sqlUserToParams(usr),
+		PasteCount:  pasteCount,
+		StorageUsed: storageUsed,
+		Teams:       teams,
+		Sessions:    []params.Session{},
+	}, nil
+}
+
+func (u *userManager) Profile(ctx context.Context) (params.Profile, error) {
+	userID := auth.UserID(ctx)
+	if userID == 0 {
+		return params.Profile{}, gErrors.ErrUnauthorized
+	}
+	usr, err := u.getUser(userID)
+	if err != nil {
+		return params.Profile{}, errors.Wrap(err, "fetching user from db")
+	}
+	pasteCount, storageUsed, err := u.userUsage(userID)
+	if err != nil {
+		return params.Profile{}, err
+	}
+	teams, err := u.userTeams(userID)
+	if err != nil {
+		return params.Profile{}, err
+	}
+
+	return params.Profile{
+		User:        u.sqlUserToParams(usr),
+		PasteCount:  pasteCount,
+		StorageUsed: storageUsed,
+		Teams:       teams,
+	}, nil
+}
+
+func (u *userManager) ChangePassword(ctx context.Context, currentPassword, newPassword string) (params.Users, error) {
+	userID := auth.UserID(ctx)
+	if userID == 0 {
+		return params.Users{}, gErrors.ErrUnauthorized
+	}
+	usr, err := u.getUser(userID)
+	if err != nil {
+		return params.Users{}, errors.Wrap(err, "fetching user from db")
+	}
+	// Users of an external identity provider manage their password
+	// there.
+	if usr.AuthProvider != "" {
+		return params.Users{}, gErrors.NewBadRequestError("the password of this account is managed by %s", usr.AuthProvider)
+	}
+	if usr.Password == "" || bcrypt.CompareHashAndPassword([]byte(usr.Password), []byte(currentPassword)) != nil {
+		return params.Users{}, gErrors.ErrWrongPassword
+	}
+	return u.Update(ctx, userID, params.UpdateUserPayload{Password: &newPassword})
+}
+
 func (u *userManager) Update(ctx context.Context, userID uint, update params.UpdateUserPayload) (params.Users, error) {
 	if err := update.Validate(); err != nil {
 		return params.Users{}, errors.Wrap(err, "validating params")
@@ -276,23 +520,25 @@ func (u *userManager) Update(ctx context.Context, userID uint, update params.Upd
 		return params.Users{}, gErrors.ErrUnauthorized
 	}
 
+	// Tokens issued to the user are invalidated if the password
+	// changes, or if the user loses privileges.
+	rotateStamp := false
+	// changed lists the fields recorded in the audit log
+	var changed []string
+
 	// Only superusers may create administrators
 	if update.IsAdmin != nil {
 		if isSuper {
+			rotateStamp = rotateStamp || (tmpUser.IsAdmin && !*update.IsAdmin)
+			if tmpUser.IsAdmin != *update.IsAdmin {
+				changed = append(changed, "is_admin")
+			}
 			tmpUser.IsAdmin = *update.IsAdmin
 		} else {
 			return params.Users{}, gErrors.NewUnauthorizedError("you are not authorized to perform this action")
 		}
 	}
 
-	if update.Password != nil {
-		hashed, err := util.PaswsordToBcrypt(*update.Password)
-		if err != nil {
-			return params.Users{}, errors.Wrap(err, "updating password")
-		}
-		tmpUser.Password = hashed
-	}
-
 	if update.Email != nil && *update.Email != tmpUser.Email {
 		_, err = u.getUserByEmail(*update.Email)
 		if err != nil {
@@ -300,13 +546,15 @@ func (u *userManager) Update(ctx context.Context, userID uint, update params.Upd
 				return params.Users{}, errors.Wrap(err, "updating email")
 			}
 			tmpUser.Email = *update.Email
+			changed = append(changed, "email")
 		} else {
-			return params.Users{}, gErrors.NewDuplicateUserError("email address already in use")
+			return params.Users{}, gErrors.WithCode(gErrors.NewDuplicateUserError("email address already in use"), gErrors.CodeEmailInUse)
 		}
 	}
 
 	if update.FullName != nil && *update.FullName != tmpUser.FullName {
 		tmpUser.FullName = *update.FullName
+		changed = append(changed, "full_name")
 	}
 
 	if update.Enabled != nil && *update.Enabled != tmpUser.Enabled {
@@ -314,6 +562,10 @@ func (u *userManager) Update(ctx context.Context, userID uint, update params.Upd
 			return params.Users{}, gErrors.NewBadRequestError("you may not enable/disable your own account")
 		}
 		tmpUser.Enabled = *update.Enabled
+		// Enabling a user that registered approves the account.
+		tmpUser.PendingApproval = tmpUser.PendingApproval && !tmpUser.Enabled
+		rotateStamp = rotateStamp || !tmpUser.Enabled
+		changed = append(changed, "enabled")
 	}
 
 	if update.Username != nil {
@@ -322,7 +574,11 @@ func (u *userManager) Update(ctx context.Context, userID uint, update params.Upd
 				return params.Users{}, gErrors.NewBadRequestError("username is already set")
 			}
 			if !util.IsAlphanumeric(*update.Username) {
-				return params.Users{}, gErrors.NewBadRequestError("username must be alphanumeric")
+				return params.Users{}, gErrors.NewValidationError(gErrors.FieldError{
+					Field:   "username",
+					Code:    gErrors.CodeInvalidUsername,
+					Message: "username must be alphanumeric",
+				})
 			}
 			_, err := u.getUserByUsername(*update.Username)
 			if err != nil {
@@ -330,20 +586,46 @@ func (u *userManager) Update(ctx context.Context, userID uint, update params.Upd
 					return params.Users{}, errors.Wrap(err, "looking up user")
 				}
 			} else {
-				return params.Users{}, errors.Wrap(gErrors.ErrDuplicateEntity, "updating username")
+				return params.Users{}, gErrors.WithCode(gErrors.NewDuplicateUserError("username already in use"), gErrors.CodeUsernameInUse)
 			}
 			tmpUser.Username = *update.Username
+			changed = append(changed, "username")
+		}
+	}
+
+	// The password is checked last, against the updated user details.
+	if update.Password != nil {
+		if err := u.checkPassword(*update.Password, tmpUser); err != nil {
+			return params.Users{}, errors.Wrap(err, "validating params")
+		}
+		hashed, err := util.PaswsordToBcrypt(*update.Password)
+		if err != nil {
+			return params.Users{}, errors.Wrap(err, "updating password")
+		}
+		tmpUser.Password = hashed
+		rotateStamp = true
+		changed = append(changed, "password")
+	}
+	if rotateStamp {
+		if err := rotateSecurityStamp(&tmpUser); err != nil {
+			return params.Users{}, err
 		}
 	}
-	// TODO: When we update the user for any reason, it will invalidate
-	// all login tokens. Add a separate field as witness instead of UpdatedAt,
-	// which will only update when the password is reset, or when any other
-	// operation that should invalidate a token, happens.
 	tmpUser.UpdatedAt = time.Now()
 	q := u.conn.Save(&tmpUser)
 	if q.Error != nil {
 		return params.Users{}, errors.Wrap(q.Error, "saving user to database")
 	}
+	if len(changed) > 0 {
+		details := map[string]string{"fields": strings.Join(changed, ",")}
+		if update.IsAdmin != nil {
+			details["is_admin"] = fmt.Sprintf("%t", tmpUser.IsAdmin)
+		}
+		if update.Enabled != nil {
+			details["enabled"] = fmt.Sprintf("%t", tmpUser.Enabled)
+		}
+		u.record(ctx, audit.ActionUserUpdate, tmpUser.ID, details)
+	}
 	return u.sqlUserToParams(tmpUser), nil
 }
 
@@ -352,7 +634,7 @@ func (u *userManager) getUserByEmail(email string) (models.Users, error) {
 	q := u.conn.Where("email = ?", email).First(&tmpUser)
 	if q.Error != nil {
 		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-			return models.Users{}, gErrors.ErrNotFound
+			return models.Users{}, gErrors.ErrUserNotFound
 		}
 		return models.Users{}, errors.Wrap(q.Error, "fetching user from database")
 	}
@@ -364,7 +646,7 @@ func (u *userManager) getUserByUsername(username string) (models.Users, error) {
 	q := u.conn.Where("username = ?", username).First(&tmpUser)
 	if q.Error != nil {
 		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-			return models.Users{}, gErrors.ErrNotFound
+			return models.Users{}, gErrors.ErrUserNotFound
 		}
 		return models.Users{}, errors.Wrap(q.Error, "fetching user from database")
 	}
@@ -376,7 +658,7 @@ func (u *userManager) getUser(userID uint) (models.Users, error) {
 	q := u.conn.Where("id = ?", userID).First(&tmpUser)
 	if q.Error != nil {
 		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-			return models.Users{}, gErrors.ErrNotFound
+			return models.Users{}, gErrors.ErrUserNotFound
 		}
 		return models.Users{}, errors.Wrap(q.Error, "fetching user from database")
 	}
@@ -420,13 +702,34 @@ func (u *userManager) CleanTokens() error {
 	return nil
 }
 
-func (u *userManager) setEnabledFlag(userID uint, enabled bool) error {
+// setEnabledFlag enables or disables the user identified by userID. Admins
+// may not change their own account or the superuser, and only a superuser
+// may change an admin.
+func (u *userManager) setEnabledFlag(ctx context.Context, userID uint, enabled bool) error {
+	if !auth.IsAdmin(ctx) {
+		return gErrors.ErrUnauthorized
+	}
+	if userID == auth.UserID(ctx) {
+		return gErrors.NewBadRequestError("you may not enable/disable your own account")
+	}
 	usr, err := u.getUser(userID)
 	if err != nil {
 		return errors.Wrap(err, "fetching user from db")
 	}
+	if usr.IsSuperUser {
+		return gErrors.NewUnauthorizedError("the superuser may not be enabled or disabled")
+	}
+	if usr.IsAdmin && !auth.IsSuperUser(ctx) {
+		return gErrors.NewUnauthorizedError("only a superuser may enable or disable an admin")
+	}
 	usr.Enabled = enabled
+	usr.PendingApproval = usr.PendingApproval && !enabled
 	usr.UpdatedAt = time.Now()
+	if !enabled {
+		if err := rotateSecurityStamp(&usr); err != nil {
+			return err
+		}
+	}
 	err = u.conn.Save(&usr).Error
 	if err != nil {
 		return errors.Wrap(err, "saving user to database")
@@ -435,46 +738,214 @@ func (u *userManager) setEnabledFlag(userID uint, enabled bool) error {
 }
 
 func (u *userManager) Enable(ctx context.Context, userID uint) error {
-	isAdmin := auth.IsAdmin(ctx)
-	if !isAdmin {
-		return gErrors.ErrUnauthorized
+	if err := u.setEnabledFlag(ctx, userID, true); err != nil {
+		return err
 	}
-	return u.setEnabledFlag(userID, true)
+	u.record(ctx, audit.ActionUserEnable, userID, nil)
+	return nil
 }
 
 func (u *userManager) Disable(ctx context.Context, userID uint) error {
-	isAdmin := auth.IsAdmin(ctx)
-	if !isAdmin {
-		return gErrors.ErrUnauthorized
+	if err := u.setEnabledFlag(ctx, userID, false); err != nil {
+		return err
+	}
+	u.record(ctx, audit.ActionUserDisable, userID, nil)
+	return nil
+}
+
+// errDryRun rolls back the transaction deleting a user in a dry run
+var errDryRun = errors.New("dry run")
+
+// getUserByUsernameOrEmail returns the user identified by login, which may
+// be a username or an email address
+func (u *userManager) getUserByUsernameOrEmail(login string) (models.Users, error) {
+	if util.IsValidEmail(login) {
+		return u.getUserByEmail(login)
 	}
-	return u.setEnabledFlag(userID, false)
+	return u.getUserByUsername(login)
 }
 
-func (u *userManager) Delete(ctx context.Context, userID uint) error {
+func (u *userManager) Delete(ctx context.Context, userID uint, opts common.DeleteUserOptions) (params.UserDeletionReport, error) {
 	isAdmin := auth.IsAdmin(ctx)
 	if !isAdmin {
-		return gErrors.ErrUnauthorized
+		return params.UserDeletionReport{}, gErrors.ErrUnauthorized
 	}
 	isSuperUser := auth.IsSuperUser(ctx)
 	currentUserID := auth.UserID(ctx)
 	if userID == currentUserID {
-		return gErrors.NewConflictError("you may not delete your own account")
+		return params.UserDeletionReport{}, gErrors.NewConflictError("you may not delete your own account")
 	}
 
 	usr, err := u.getUser(userID)
 	if err != nil {
-		return errors.Wrap(err, "fetching user from db")
+		return params.UserDeletionReport{}, errors.Wrap(err, "fetching user from db")
 	}
 	if usr.IsSuperUser {
-		return gErrors.NewUnauthorizedError("the superuser may not be deleted")
+		return params.UserDeletionReport{}, gErrors.NewUnauthorizedError("the superuser may not be deleted")
 	}
 
 	if usr.IsAdmin && !isSuperUser {
-		return gErrors.NewUnauthorizedError("only a superuser may delete an admin")
+		return params.UserDeletionReport{}, gErrors.NewUnauthorizedError("only a superuser may delete an admin")
+	}
+
+	report := params.UserDeletionReport{
+		DryRun: opts.DryRun,
+		User:   u.sqlUserToParams(usr),
+	}
+	var target models.Users
+	if opts.TransferTo != "" {
+		target, err = u.getUserByUsernameOrEmail(opts.TransferTo)
+		if err != nil {
+			if errors.Is(err, gErrors.ErrNotFound) {
+				return params.UserDeletionReport{}, gErrors.NewValidationError(gErrors.FieldError{
+					Field:   "transfer_to",
+					Code:    gErrors.CodeUserNotFound,
+					Message: fmt.Sprintf("user %s not found", opts.TransferTo),
+				})
+			}
+			return params.UserDeletionReport{}, errors.Wrap(err, "fetching user from db")
+		}
+		if target.ID == usr.ID {
+			return params.UserDeletionReport{}, gErrors.NewValidationError(gErrors.FieldError{
+				Field:   "transfer_to",
+				Code:    gErrors.CodeValidationFailed,
+				Message: "the pastes of a user may not be transferred to themselves",
+			})
+		}
+		if target.PendingApproval {
+			return params.UserDeletionReport{}, gErrors.NewValidationError(gErrors.FieldError{
+				Field:   "transfer_to",
+				Code:    gErrors.CodeApprovalPending,
+				Message: fmt.Sprintf("user %s is pending approval", opts.TransferTo),
+			})
+		}
+		if !target.Enabled {
+			return params.UserDeletionReport{}, gErrors.NewValidationError(gErrors.FieldError{
+				Field:   "transfer_to",
+				Code:    gErrors.CodeUserDisabled,
+				Message: fmt.Sprintf("user %s is disabled", opts.TransferTo),
+			})
+		}
+		targetParams := u.sqlUserToParams(target)
+		report.TransferTo = &targetParams
+	}
+
+	// A dry run does all the work, and rolls it back, so the report is
+	// exactly what deleting the user would do.
+	err = u.conn.Transaction(func(tx *gorm.DB) error {
+		if target.ID != 0 {
+			if err := transferUserData(tx, usr.ID, target.ID, &report); err != nil {
+				return err
+			}
+		} else if err := deleteUserData(tx, usr.ID, &report); err != nil {
+			return err
+		}
+		q := tx.Table("team_users").Where("users_id = ?", usr.ID).Count(&report.TeamMembershipsRemoved)
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "counting team memberships")
+		}
+		if opts.DryRun {
+			return errDryRun
+		}
+		if err := tx.Delete(&usr).Error; err != nil {
+			return errors.Wrap(err, "deleting user")
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return params.UserDeletionReport{}, err
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+
+	details := map[string]string{
+		"username":           usr.Username,
+		"email":              usr.Email,
+		"pastes_deleted":     fmt.Sprintf("%d", report.PastesDeleted),
+		"pastes_transferred": fmt.Sprintf("%d", report.PastesTransferred),
+		"teams_deleted":      fmt.Sprintf("%d", report.TeamsDeleted),
+		"teams_transferred":  fmt.Sprintf("%d", report.TeamsTransferred),
+	}
+	if target.ID != 0 {
+		details["transfer_to"] = target.Username
+	}
+	u.record(ctx, audit.ActionUserDelete, usr.ID, details)
+	return report, nil
+}
+
+// deleteUserData deletes the teams of the user identified by userID, and
+// counts the pastes, teams and shares deleted along with them. Deleting a
+// team deletes its pastes, including those posted by other users. The
+// pastes and shares of the user are deleted along with the user.
+func deleteUserData(tx *gorm.DB, userID uint, report *params.UserDeletionReport) error {
+	ownedTeams := tx.Model(&models.Teams{}).Select("id").Where("owner_id = ?", userID)
+	q := tx.Model(&models.Paste{}).Where("owner_id = ? OR team_id IN (?)", userID, ownedTeams).Count(&report.PastesDeleted)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "counting pastes")
+	}
+	q = tx.Table("paste_users").Where("users_id = ?", userID).Count(&report.SharesRemoved)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "counting shares")
+	}
+	// Teams do not cascade when their owner is deleted.
+	q = tx.Where("owner_id = ?", userID).Delete(&models.Teams{})
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "deleting teams")
+	}
+	report.TeamsDeleted = q.RowsAffected
+	return nil
+}
+
+// transferUserData makes the user identified by toID the owner of the
+// pastes and teams of the user identified by fromID, and shares the
+// pastes shared with fromID with toID instead. Shares and team
+// memberships made redundant by the transfer are removed.
+func transferUserData(tx *gorm.DB, fromID, toID uint, report *params.UserDeletionReport) error {
+	// The new owner no longer needs the pastes and teams to be shared
+	// with them.
+	q := tx.Exec("DELETE FROM paste_users WHERE users_id = ? AND paste_id IN (SELECT id FROM pastes WHERE owner_id = ?)", toID, fromID)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "removing shares with the new owner")
+	}
+	q = tx.Exec("DELETE FROM team_users WHERE users_id = ? AND teams_id IN (SELECT id FROM teams WHERE owner_id = ?)", toID, fromID)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "removing team memberships of the new owner")
+	}
+
+	q = tx.Model(&models.Paste{}).Where("owner_id = ?", fromID).UpdateColumn("owner_id", toID)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "transferring pastes")
+	}
+	report.PastesTransferred = q.RowsAffected
+	q = tx.Model(&models.Teams{}).Where("owner_id = ?", fromID).UpdateColumn("owner_id", toID)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "transferring teams")
+	}
+	report.TeamsTransferred = q.RowsAffected
+
+	// Pastes the new owner can already see are no longer shared. MySQL
+	// does not allow a subquery on the table being deleted from, so the
+	// pastes are looked up first.
+	var redundant []uint
+	q = tx.Table("paste_users").
+		Where("users_id = ?", fromID).
+		Where("paste_id IN (SELECT id FROM pastes WHERE owner_id = ?) OR paste_id IN (SELECT paste_id FROM paste_users WHERE users_id = ?)", toID, toID).
+		Pluck("paste_id", &redundant)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "fetching shares")
+	}
+	if len(redundant) > 0 {
+		q = tx.Exec("DELETE FROM paste_users WHERE users_id = ? AND paste_id IN (?)", fromID, redundant)
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "removing shares")
+		}
+		report.SharesRemoved = q.RowsAffected
 	}
-	q := u.conn.Delete(&usr)
+	q = tx.Exec("UPDATE paste_users SET users_id = ? WHERE users_id = ?", toID, fromID)
 	if q.Error != nil {
-		return errors.Wrap(q.Error, "deleting user")
+		return errors.Wrap(q.Error, "transferring shares")
 	}
+	report.SharesTransferred = q.RowsAffected
 	return nil
 }
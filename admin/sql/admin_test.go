@@ -2,7 +2,12 @@ package sql_test
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,8 +16,10 @@ import (
 	"gopherbin/auth"
 	"gopherbin/config"
 	gErrors "gopherbin/errors"
+	"gopherbin/models"
 	"gopherbin/params"
 	pasteSQL "gopherbin/paste/sql"
+	"gopherbin/util"
 
 	pkgErrors "github.com/pkg/errors"
 )
@@ -33,10 +40,10 @@ func newAdminFixture(t *testing.T) (adminCommon.UserManager, context.Context) {
 	t.Helper()
 	dbCfg := testDBConfig(t)
 
-	if _, err := pasteSQL.NewPaster(dbCfg); err != nil {
+	if _, err := pasteSQL.NewPaster(dbCfg, nil); err != nil {
 		t.Fatalf("migrations: %v", err)
 	}
-	mgr, err := adminSQL.NewUserManager(dbCfg)
+	mgr, err := adminSQL.NewUserManager(dbCfg, config.PasswordPolicy{}, nil)
 	if err != nil {
 		t.Fatalf("NewUserManager: %v", err)
 	}
@@ -67,10 +74,10 @@ func isDuplicate(err error) bool {
 
 func TestHasSuperUser_FalseOnFreshDB(t *testing.T) {
 	dbCfg := testDBConfig(t)
-	if _, err := pasteSQL.NewPaster(dbCfg); err != nil {
+	if _, err := pasteSQL.NewPaster(dbCfg, nil); err != nil {
 		t.Fatalf("migrations: %v", err)
 	}
-	mgr, err := adminSQL.NewUserManager(dbCfg)
+	mgr, err := adminSQL.NewUserManager(dbCfg, config.PasswordPolicy{}, nil)
 	if err != nil {
 		t.Fatalf("NewUserManager: %v", err)
 	}
@@ -262,7 +269,7 @@ func TestUserGet_NonAdminCannotViewOthers(t *testing.T) {
 
 func TestUserList_RequiresAdmin(t *testing.T) {
 	mgr, _ := newAdminFixture(t)
-	_, err := mgr.List(context.Background(), 1, 10)
+	_, err := mgr.List(context.Background(), adminCommon.UserFilter{}, 1, 10)
 	if !isUnauthorized(err) {
 		t.Fatalf("expected UnauthorizedError, got %v", err)
 	}
@@ -270,7 +277,7 @@ func TestUserList_RequiresAdmin(t *testing.T) {
 
 func TestUserList_ReturnsUsers(t *testing.T) {
 	mgr, superCtx := newAdminFixture(t)
-	res, err := mgr.List(superCtx, 1, 10)
+	res, err := mgr.List(superCtx, adminCommon.UserFilter{}, 1, 10)
 	if err != nil {
 		t.Fatalf("List: %v", err)
 	}
@@ -280,6 +287,177 @@ func TestUserList_ReturnsUsers(t *testing.T) {
 	}
 }
 
+func TestUserList_Filters(t *testing.T) {
+	mgr, superCtx := newAdminFixture(t)
+	for _, user := range []params.NewUserParams{
+		{Email: "jane.doe@example.com", Username: "jdoe", FullName: "Jane Doe", Enabled: true, IsAdmin: true},
+		{Email: "john@corp.example", Username: "jsmith", FullName: "John Smith", Enabled: true},
+		{Email: "bob_100@example.com", Username: "bob", FullName: "Robert Doe", Enabled: false},
+	} {
+		user.Password = testPassword
+		if _, err := mgr.Create(superCtx, user); err != nil {
+			t.Fatalf("Create %s: %v", user.Username, err)
+		}
+	}
+	enabled, disabled, admin := true, false, true
+
+	for name, tc := range map[string]struct {
+		filter adminCommon.UserFilter
+		want   string
+	}{
+		"username":      {adminCommon.UserFilter{Query: "SMITH"}, "[jsmith]"},
+		"email":         {adminCommon.UserFilter{Query: "corp.example"}, "[jsmith]"},
+		"full name":     {adminCommon.UserFilter{Query: "doe"}, "[jdoe bob]"},
+		"literal _":     {adminCommon.UserFilter{Query: "_1"}, "[bob]"},
+		"literal %":     {adminCommon.UserFilter{Query: "%"}, "[]"},
+		"enabled":       {adminCommon.UserFilter{Query: "doe", Enabled: &enabled}, "[jdoe]"},
+		"disabled":      {adminCommon.UserFilter{Enabled: &disabled}, "[bob]"},
+		"admin":         {adminCommon.UserFilter{IsAdmin: &admin}, "[superadmin jdoe]"},
+		"combined":      {adminCommon.UserFilter{Query: "j", IsAdmin: &admin, Enabled: &enabled}, "[jdoe]"},
+		"no match":      {adminCommon.UserFilter{Query: "nobody"}, "[]"},
+		"empty filters": {adminCommon.UserFilter{}, "[superadmin jdoe jsmith bob]"},
+	} {
+		res, err := mgr.List(superCtx, tc.filter, 1, 10)
+		if err != nil {
+			t.Fatalf("%s: List: %v", name, err)
+		}
+		var usernames []string
+		for _, user := range res.Users {
+			usernames = append(usernames, user.Username)
+		}
+		if got := fmt.Sprint(usernames); got != tc.want {
+			t.Errorf("%s: want %s, got %s", name, tc.want, got)
+		}
+	}
+}
+
+func TestUserList_LastLogin(t *testing.T) {
+	f := newTokenFixture(t)
+	sessions, err := adminSQL.NewSessionManager(f.dbCfg)
+	if err != nil {
+		t.Fatalf("NewSessionManager: %v", err)
+	}
+	before := time.Now().UTC().Add(-time.Minute)
+	if err := sessions.Create(f.userCtx, adminCommon.NewSession{ID: "session-1", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Create session: %v", err)
+	}
+	user, err := f.users.Get(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if user.LastLoginAt == nil || user.LastLoginAt.Before(before) {
+		t.Fatalf("want the last login to be set, got %v", user.LastLoginAt)
+	}
+
+	res, err := f.users.List(f.superCtx, adminCommon.UserFilter{LastLoginSince: &before}, 1, 10)
+	if err != nil || len(res.Users) != 1 || res.Users[0].ID != f.user.ID {
+		t.Errorf("since: want only the user that logged in, got %+v (%v)", res.Users, err)
+	}
+	res, err = f.users.List(f.superCtx, adminCommon.UserFilter{LastLoginBefore: &before}, 1, 10)
+	if err != nil || len(res.Users) != 1 || res.Users[0].Username != "superadmin" {
+		t.Errorf("before: want only the user that never logged in, got %+v (%v)", res.Users, err)
+	}
+}
+
+func TestUserDetails(t *testing.T) {
+	f := newTokenFixture(t)
+	paster, err := pasteSQL.NewPaster(f.dbCfg, nil)
+	if err != nil {
+		t.Fatalf("NewPaster: %v", err)
+	}
+	teams, err := pasteSQL.NewTeamManager(f.dbCfg, nil)
+	if err != nil {
+		t.Fatalf("NewTeamManager: %v", err)
+	}
+	for _, data := range []string{"hello", "hello, world"} {
+		if _, err := paster.Create(f.userCtx, []byte(data), "paste.txt", "text", "", nil, false, "", nil, nil, nil); err != nil {
+			t.Fatalf("Create paste: %v", err)
+		}
+	}
+	if _, err := teams.Create(f.userCtx, "owned"); err != nil {
+		t.Fatalf("Create team: %v", err)
+	}
+	if _, err := teams.Create(f.superCtx, "joined"); err != nil {
+		t.Fatalf("Create team: %v", err)
+	}
+	if _, err := teams.AddMember(f.superCtx, "joined", f.user.Username); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+	if _, err := teams.Create(f.superCtx, "other"); err != nil {
+		t.Fatalf("Create team: %v", err)
+	}
+
+	details, err := f.users.Details(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Details: %v", err)
+	}
+	if details.User.ID != f.user.ID || details.PasteCount != 2 || details.StorageUsed != int64(len("hello")+len("hello, world")) {
+		t.Errorf("unexpected details: %+v", details)
+	}
+	var names []string
+	for _, team := range details.Teams {
+		names = append(names, team.Name+"/"+team.Owner.Username)
+	}
+	if got := fmt.Sprint(names); got != "[owned/ci joined/superadmin]" {
+		t.Errorf("unexpected teams: %s", got)
+	}
+
+	empty, err := f.users.Details(f.superCtx, f.user.ID+100)
+	if err == nil {
+		t.Errorf("want an error for a missing user, got %+v", empty)
+	}
+	if _, err := f.users.Details(f.userCtx, f.user.ID); !isUnauthorized(err) {
+		t.Errorf("want UnauthorizedError for a regular user, got %v", err)
+	}
+}
+
+func TestUserProfile(t *testing.T) {
+	f := newTokenFixture(t)
+	paster, err := pasteSQL.NewPaster(f.dbCfg, nil)
+	if err != nil {
+		t.Fatalf("NewPaster: %v", err)
+	}
+	if _, err := paster.Create(f.userCtx, []byte("hello"), "paste.txt", "text", "", nil, false, "", nil, nil, nil); err != nil {
+		t.Fatalf("Create paste: %v", err)
+	}
+
+	profile, err := f.users.Profile(f.userCtx)
+	if err != nil {
+		t.Fatalf("Profile: %v", err)
+	}
+	if profile.User.ID != f.user.ID || profile.PasteCount != 1 || profile.StorageUsed != int64(len("hello")) || len(profile.Teams) != 0 {
+		t.Errorf("unexpected profile: %+v", profile)
+	}
+	if _, err := f.users.Profile(context.Background()); !isUnauthorized(err) {
+		t.Errorf("want UnauthorizedError anonymously, got %v", err)
+	}
+}
+
+func TestUserChangePassword(t *testing.T) {
+	f := newTokenFixture(t)
+	newPassword := "Another-Correct-Horse-Battery-Staple-2024!"
+	if _, err := f.users.ChangePassword(f.userCtx, "wrong", newPassword); gErrors.Code(err) != gErrors.CodeWrongPassword {
+		t.Fatalf("wrong current password: want %s, got %v", gErrors.CodeWrongPassword, err)
+	}
+	if _, err := f.users.ChangePassword(f.userCtx, testPassword, "password"); passwordFieldCode(err) != gErrors.CodePasswordTooWeak {
+		t.Fatalf("weak password: want %s, got %v", gErrors.CodePasswordTooWeak, err)
+	}
+	updated, err := f.users.ChangePassword(f.userCtx, testPassword, newPassword)
+	if err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if updated.SecurityStamp == f.user.SecurityStamp {
+		t.Error("expected the security stamp to be rotated")
+	}
+	login := params.PasswordLoginParams{Username: f.user.Username, Password: newPassword}
+	if _, err := f.users.Authenticate(context.Background(), login); err != nil {
+		t.Errorf("Authenticate with new password: %v", err)
+	}
+	if _, err := f.users.ChangePassword(context.Background(), newPassword, testPassword); !isUnauthorized(err) {
+		t.Errorf("want UnauthorizedError anonymously, got %v", err)
+	}
+}
+
 // ── Enable / Disable ─────────────────────────────────────────────────────────
 
 func TestUserEnable_AdminCanToggle(t *testing.T) {
@@ -318,6 +496,39 @@ func TestUserEnable_RequiresAdmin(t *testing.T) {
 	}
 }
 
+func TestUserEnable_Guards(t *testing.T) {
+	mgr, superCtx := newAdminFixture(t)
+	adminUser, err := mgr.Create(superCtx, params.NewUserParams{
+		Email: "a@example.com", Username: "auser", FullName: "A", Password: testPassword, Enabled: true, IsAdmin: true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	other, err := mgr.Create(superCtx, params.NewUserParams{
+		Email: "o@example.com", Username: "ouser", FullName: "O", Password: testPassword, Enabled: true, IsAdmin: true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	adminCtx, err := mgr.Authenticate(context.Background(), params.PasswordLoginParams{Username: "auser", Password: testPassword})
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	if err := mgr.Disable(adminCtx, adminUser.ID); err == nil {
+		t.Error("expected error when disabling your own account")
+	}
+	if err := mgr.Disable(adminCtx, auth.UserID(superCtx)); !isUnauthorized(err) {
+		t.Errorf("disabling the superuser: want UnauthorizedError, got %v", err)
+	}
+	if err := mgr.Disable(adminCtx, other.ID); !isUnauthorized(err) {
+		t.Errorf("admin disabling an admin: want UnauthorizedError, got %v", err)
+	}
+	if err := mgr.Disable(superCtx, other.ID); err != nil {
+		t.Errorf("superuser disabling an admin: %v", err)
+	}
+}
+
 // ── Delete ───────────────────────────────────────────────────────────────────
 
 func TestUserDelete_AdminCanDeleteRegularUser(t *testing.T) {
@@ -328,7 +539,7 @@ func TestUserDelete_AdminCanDeleteRegularUser(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Create: %v", err)
 	}
-	if err := mgr.Delete(superCtx, u.ID); err != nil {
+	if _, err := mgr.Delete(superCtx, u.ID, adminCommon.DeleteUserOptions{}); err != nil {
 		t.Fatalf("Delete: %v", err)
 	}
 }
@@ -336,7 +547,7 @@ func TestUserDelete_AdminCanDeleteRegularUser(t *testing.T) {
 func TestUserDelete_CannotDeleteSelf(t *testing.T) {
 	mgr, superCtx := newAdminFixture(t)
 	superID := auth.UserID(superCtx)
-	err := mgr.Delete(superCtx, superID)
+	_, err := mgr.Delete(superCtx, superID, adminCommon.DeleteUserOptions{})
 	if err == nil {
 		t.Fatal("expected error when deleting own account")
 	}
@@ -355,11 +566,169 @@ func TestUserDelete_CannotDeleteSuperUser(t *testing.T) {
 	adminCtx := auth.PopulateContext(context.Background(), admin)
 
 	superID := auth.UserID(superCtx)
-	if err := mgr.Delete(adminCtx, superID); err == nil {
+	if _, err := mgr.Delete(adminCtx, superID, adminCommon.DeleteUserOptions{}); err == nil {
 		t.Fatal("expected error when deleting superuser")
 	}
 }
 
+// ── Password policy ──────────────────────────────────────────────────────────
+
+func passwordFieldCode(err error) string {
+	badReq, ok := pkgErrors.Cause(err).(*gErrors.BadRequestError)
+	if !ok {
+		return ""
+	}
+	for _, field := range badReq.Fields() {
+		if field.Field == "password" {
+			return field.Code
+		}
+	}
+	return ""
+}
+
+func TestPasswordPolicy_AppliesToAllPasswords(t *testing.T) {
+	dbCfg := testDBConfig(t)
+	if _, err := pasteSQL.NewPaster(dbCfg, nil); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	breached := filepath.Join(t.TempDir(), "breached.txt")
+	sum := sha1.Sum([]byte("Another-Correct-Horse-Battery-Staple-2024!"))
+	if err := os.WriteFile(breached, []byte(strings.ToUpper(hex.EncodeToString(sum[:]))+":42\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := adminSQL.NewUserManager(dbCfg, config.PasswordPolicy{MinLength: 12, BreachedPasswordsFile: breached}, nil)
+	if err != nil {
+		t.Fatalf("NewUserManager: %v", err)
+	}
+
+	// First run
+	superParams := params.NewUserParams{Email: "super@example.com", Username: "superadmin", FullName: "Super Admin", Password: "Xq7!vB2#"}
+	if _, err := mgr.CreateSuperUser(superParams); passwordFieldCode(err) != gErrors.CodePasswordTooShort {
+		t.Fatalf("CreateSuperUser: want %s, got %v", gErrors.CodePasswordTooShort, err)
+	}
+	superParams.Password = testPassword
+	super, err := mgr.CreateSuperUser(superParams)
+	if err != nil {
+		t.Fatalf("CreateSuperUser: %v", err)
+	}
+	superCtx := auth.PopulateContext(context.Background(), super)
+
+	// Created by an admin. The details of the user are not accepted as
+	// a password, and are reported along with other invalid fields.
+	_, err = mgr.Create(superCtx, params.NewUserParams{
+		Email: "quarvelintosh@example.com", Username: "qbrimdale", FullName: "", Password: "Quarvelintosh-Brimdale", Enabled: true,
+	})
+	if passwordFieldCode(err) != gErrors.CodePasswordTooWeak {
+		t.Fatalf("Create: want %s, got %v", gErrors.CodePasswordTooWeak, err)
+	}
+	if badReq, ok := pkgErrors.Cause(err).(*gErrors.BadRequestError); !ok || len(badReq.Fields()) != 2 {
+		t.Errorf("Create: want password and full name fields, got %v", err)
+	}
+	u, err := mgr.Create(superCtx, params.NewUserParams{
+		Email: "quarvelintosh@example.com", Username: "qbrimdale", FullName: "Quarvelintosh Brimdale", Password: testPassword, Enabled: true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// Changed by the user
+	userCtx := auth.PopulateContext(context.Background(), u)
+	password := "Another-Correct-Horse-Battery-Staple-2024!"
+	if _, err := mgr.Update(userCtx, u.ID, params.UpdateUserPayload{Password: &password}); passwordFieldCode(err) != gErrors.CodePasswordBreached {
+		t.Fatalf("Update: want %s, got %v", gErrors.CodePasswordBreached, err)
+	}
+	if err := mgr.CheckPassword(u.ID, "Quarvelintosh-Brimdale"); passwordFieldCode(err) != gErrors.CodePasswordTooWeak {
+		t.Errorf("CheckPassword: want %s, got %v", gErrors.CodePasswordTooWeak, err)
+	}
+	password = "Yet-Another-Correct-Horse-Battery-Staple-2024!"
+	if _, err := mgr.Update(userCtx, u.ID, params.UpdateUserPayload{Password: &password}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+}
+
+// ── Security stamp ───────────────────────────────────────────────────────────
+
+func TestSecurityStamp_Rotation(t *testing.T) {
+	mgr, superCtx := newAdminFixture(t)
+	u, err := mgr.Create(superCtx, params.NewUserParams{
+		Email: "stamp@example.com", Username: "stampuser", FullName: "Stamp", Password: testPassword, Enabled: true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if u.SecurityStamp == "" {
+		t.Fatal("expected new users to get a security stamp")
+	}
+
+	yes, no := true, false
+	name, password := "Renamed", "Another-Correct-Horse-Battery-Staple-2024!"
+	steps := []struct {
+		name   string
+		update params.UpdateUserPayload
+		rotate bool
+	}{
+		{"rename", params.UpdateUserPayload{FullName: &name}, false},
+		{"promote", params.UpdateUserPayload{IsAdmin: &yes}, false},
+		{"demote", params.UpdateUserPayload{IsAdmin: &no}, true},
+		{"change password", params.UpdateUserPayload{Password: &password}, true},
+		{"disable", params.UpdateUserPayload{Enabled: &no}, true},
+		{"enable", params.UpdateUserPayload{Enabled: &yes}, false},
+	}
+	stamp := u.SecurityStamp
+	for _, step := range steps {
+		updated, err := mgr.Update(superCtx, u.ID, step.update)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if rotated := updated.SecurityStamp != stamp; rotated != step.rotate {
+			t.Errorf("%s: want rotated=%v, got %v", step.name, step.rotate, rotated)
+		}
+		stamp = updated.SecurityStamp
+	}
+
+	if err := mgr.Disable(superCtx, u.ID); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	got, err := mgr.Get(superCtx, u.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.SecurityStamp == stamp {
+		t.Error("expected Disable() to rotate the security stamp")
+	}
+}
+
+func TestSecurityStamp_Migration(t *testing.T) {
+	f := newTokenFixture(t)
+	db, err := util.NewDBConn(f.dbCfg)
+	if err != nil {
+		t.Fatalf("NewDBConn: %v", err)
+	}
+	// Users created before security stamps were introduced have none.
+	if err := db.Model(&models.Users{}).Where("id = ?", f.user.ID).UpdateColumn("security_stamp", "").Error; err != nil {
+		t.Fatalf("clearing stamp: %v", err)
+	}
+	before, err := f.users.Get(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if _, err := pasteSQL.NewPaster(f.dbCfg, nil); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	after, err := f.users.Get(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if after.SecurityStamp == "" {
+		t.Fatal("expected migration to set a security stamp")
+	}
+	// Tokens issued before the migration are checked against updated_at.
+	if !after.UpdatedAt.Equal(before.UpdatedAt) {
+		t.Errorf("migration changed updated_at from %s to %s", before.UpdatedAt, after.UpdatedAt)
+	}
+}
+
 // ── Token blacklist ───────────────────────────────────────────────────────────
 
 func TestValidateToken_NotBlacklisted(t *testing.T) {
@@ -0,0 +1,183 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+
+	"gopherbin/admin/common"
+	"gopherbin/auth"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/params"
+	"gopherbin/util"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// auditExportBatchSize is the number of events fetched at a time while
+// exporting the audit log
+const auditExportBatchSize = 500
+
+// NewAuditLog returns a new AuditLog
+func NewAuditLog(dbCfg config.Database) (common.AuditLog, error) {
+	db, err := util.NewDBConn(dbCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to database")
+	}
+	return &auditLog{
+		conn: db,
+	}, nil
+}
+
+type auditLog struct {
+	conn *gorm.DB
+}
+
+func sqlAuditLogToParams(entry models.AuditLog) params.AuditEvent {
+	event := params.AuditEvent{
+		ID:         entry.ID,
+		Time:       entry.CreatedAt,
+		Action:     entry.Action,
+		ActorID:    entry.ActorID,
+		Actor:      entry.Actor,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		IP:         entry.IP,
+		RequestID:  entry.RequestID,
+	}
+	if len(entry.Details) > 0 {
+		// Details are only ever written by Write, and always decode.
+		_ = json.Unmarshal(entry.Details, &event.Details)
+	}
+	return event
+}
+
+func (a *auditLog) Write(event params.AuditEvent) error {
+	entry := models.AuditLog{
+		CreatedAt:  event.Time,
+		Action:     event.Action,
+		ActorID:    event.ActorID,
+		Actor:      event.Actor,
+		TargetType: event.TargetType,
+		TargetID:   event.TargetID,
+		IP:         event.IP,
+		RequestID:  event.RequestID,
+	}
+	if len(event.Details) > 0 {
+		details, err := json.Marshal(event.Details)
+		if err != nil {
+			return errors.Wrap(err, "encoding details")
+		}
+		entry.Details = details
+	}
+	if err := a.conn.Create(&entry).Error; err != nil {
+		return errors.Wrap(err, "saving audit event")
+	}
+	return nil
+}
+
+// filtered returns a query for the events matching filter
+func (a *auditLog) filtered(filter common.AuditLogFilter) *gorm.DB {
+	q := a.conn.Model(&models.AuditLog{})
+	if len(filter.Actions) > 0 {
+		q = q.Where("action in ?", filter.Actions)
+	}
+	if filter.ActorID != nil {
+		q = q.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.TargetType != "" {
+		q = q.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		q = q.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.IP != "" {
+		q = q.Where("ip = ?", filter.IP)
+	}
+	if filter.Since != nil {
+		q = q.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		q = q.Where("created_at < ?", *filter.Until)
+	}
+	return q
+}
+
+func (a *auditLog) List(ctx context.Context, filter common.AuditLogFilter, page int64, results int64) (params.AuditLogListResult, error) {
+	if !auth.IsAdmin(ctx) {
+		return params.AuditLogListResult{}, gErrors.ErrUnauthorized
+	}
+	if page == 0 {
+		page = 1
+	}
+	if results == 0 {
+		results = 1
+	}
+
+	var cnt int64
+	if err := a.filtered(filter).Count(&cnt).Error; err != nil {
+		return params.AuditLogListResult{}, errors.Wrap(err, "counting results")
+	}
+	var entries []models.AuditLog
+	startFrom := (page - 1) * results
+	resQ := a.filtered(filter).Order("id desc").Offset(int(startFrom)).Limit(int(results)).Find(&entries)
+	if resQ.Error != nil {
+		return params.AuditLogListResult{}, errors.Wrap(resQ.Error, "fetching audit events")
+	}
+	events := make([]params.AuditEvent, len(entries))
+	for idx, entry := range entries {
+		events[idx] = sqlAuditLogToParams(entry)
+	}
+	totalPages := int64(math.Ceil(float64(cnt) / float64(results)))
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	return params.AuditLogListResult{
+		TotalPages: totalPages,
+		Events:     events,
+	}, nil
+}
+
+func (a *auditLog) Export(ctx context.Context, filter common.AuditLogFilter, w io.Writer) error {
+	if !auth.IsAdmin(ctx) {
+		return gErrors.ErrUnauthorized
+	}
+	enc := json.NewEncoder(w)
+	// Events are fetched in batches, keyed on the ID, so exporting a
+	// large audit log does not load it in memory.
+	var lastID uint
+	for {
+		var entries []models.AuditLog
+		q := a.filtered(filter).Where("id > ?", lastID).Order("id asc").Limit(auditExportBatchSize).Find(&entries)
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "fetching audit events")
+		}
+		for _, entry := range entries {
+			if err := enc.Encode(sqlAuditLogToParams(entry)); err != nil {
+				return errors.Wrap(err, "writing audit events")
+			}
+		}
+		if len(entries) < auditExportBatchSize {
+			return nil
+		}
+		lastID = entries[len(entries)-1].ID
+	}
+}
@@ -0,0 +1,219 @@
+package sql_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	adminCommon "gopherbin/admin/common"
+	adminSQL "gopherbin/admin/sql"
+	"gopherbin/audit"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/params"
+)
+
+type auditFixture struct {
+	tokenFixture
+	log adminCommon.AuditLog
+}
+
+func newAuditFixture(t *testing.T) auditFixture {
+	t.Helper()
+	f := newTokenFixture(t)
+	log, err := adminSQL.NewAuditLog(f.dbCfg)
+	if err != nil {
+		t.Fatalf("NewAuditLog: %v", err)
+	}
+	return auditFixture{tokenFixture: f, log: log}
+}
+
+func (f auditFixture) write(t *testing.T, event params.AuditEvent) {
+	t.Helper()
+	if err := f.log.Write(event); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+func actions(events []params.AuditEvent) []string {
+	ret := make([]string, len(events))
+	for idx, event := range events {
+		ret[idx] = event.Action
+	}
+	return ret
+}
+
+func TestAuditLog_List(t *testing.T) {
+	f := newAuditFixture(t)
+	start := time.Now().UTC().Add(-time.Hour)
+	f.write(t, params.AuditEvent{
+		Time:       start,
+		Action:     audit.ActionLogin,
+		ActorID:    f.user.ID,
+		Actor:      f.user.Username,
+		IP:         "192.0.2.1",
+		RequestID:  "req-1",
+		Details:    map[string]string{"method": "password"},
+		TargetType: audit.TargetUser,
+		TargetID:   fmt.Sprintf("%d", f.user.ID),
+	})
+	f.write(t, params.AuditEvent{
+		Time:       start.Add(time.Minute),
+		Action:     audit.ActionPasteCreate,
+		ActorID:    f.user.ID,
+		Actor:      f.user.Username,
+		IP:         "192.0.2.1",
+		TargetType: audit.TargetPaste,
+		TargetID:   "abc",
+	})
+	f.write(t, params.AuditEvent{
+		Time:    start.Add(2 * time.Minute),
+		Action:  audit.ActionLoginFailed,
+		IP:      "198.51.100.7",
+		Details: map[string]string{"login": "ci"},
+	})
+
+	all, err := f.log.List(f.superCtx, adminCommon.AuditLogFilter{}, 1, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if got := fmt.Sprint(actions(all.Events)); got != "[auth.login_failed paste.create auth.login]" {
+		t.Fatalf("want newest first, got %s", got)
+	}
+	login := all.Events[2]
+	if login.Details["method"] != "password" || login.RequestID != "req-1" || login.Actor != "ci" || !login.Time.Equal(start) {
+		t.Errorf("unexpected login event: %+v", login)
+	}
+
+	anonymous := uint(0)
+	since := start.Add(30 * time.Second)
+	until := start.Add(90 * time.Second)
+	for name, tc := range map[string]struct {
+		filter adminCommon.AuditLogFilter
+		want   string
+	}{
+		"actions": {adminCommon.AuditLogFilter{Actions: []string{audit.ActionLogin, audit.ActionLoginFailed}}, "[auth.login_failed auth.login]"},
+		"actor":   {adminCommon.AuditLogFilter{ActorID: &f.user.ID}, "[paste.create auth.login]"},
+		"anon":    {adminCommon.AuditLogFilter{ActorID: &anonymous}, "[auth.login_failed]"},
+		"target":  {adminCommon.AuditLogFilter{TargetType: audit.TargetPaste, TargetID: "abc"}, "[paste.create]"},
+		"ip":      {adminCommon.AuditLogFilter{IP: "198.51.100.7"}, "[auth.login_failed]"},
+		"range":   {adminCommon.AuditLogFilter{Since: &since, Until: &until}, "[paste.create]"},
+	} {
+		res, err := f.log.List(f.superCtx, tc.filter, 1, 10)
+		if err != nil {
+			t.Fatalf("%s: List: %v", name, err)
+		}
+		if got := fmt.Sprint(actions(res.Events)); got != tc.want {
+			t.Errorf("%s: want %s, got %s", name, tc.want, got)
+		}
+	}
+
+	page, err := f.log.List(f.superCtx, adminCommon.AuditLogFilter{}, 2, 2)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if page.TotalPages != 2 || fmt.Sprint(actions(page.Events)) != "[auth.login]" {
+		t.Errorf("unexpected second page: %+v", page)
+	}
+}
+
+func TestAuditLog_AdminOnly(t *testing.T) {
+	f := newAuditFixture(t)
+	if _, err := f.log.List(f.userCtx, adminCommon.AuditLogFilter{}, 1, 10); err != gErrors.ErrUnauthorized {
+		t.Errorf("List as regular user: want ErrUnauthorized, got %v", err)
+	}
+	if _, err := f.log.List(context.Background(), adminCommon.AuditLogFilter{}, 1, 10); err != gErrors.ErrUnauthorized {
+		t.Errorf("List anonymously: want ErrUnauthorized, got %v", err)
+	}
+	var buf bytes.Buffer
+	if err := f.log.Export(f.userCtx, adminCommon.AuditLogFilter{}, &buf); err != gErrors.ErrUnauthorized {
+		t.Errorf("Export as regular user: want ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestAuditLog_Export(t *testing.T) {
+	f := newAuditFixture(t)
+	// More events than fit in a single batch.
+	total := 1234
+	for i := 0; i < total; i++ {
+		f.write(t, params.AuditEvent{
+			Action:   audit.ActionPasteView,
+			TargetID: fmt.Sprintf("%d", i),
+		})
+	}
+	f.write(t, params.AuditEvent{Action: audit.ActionLogout})
+
+	var buf bytes.Buffer
+	filter := adminCommon.AuditLogFilter{Actions: []string{audit.ActionPasteView}}
+	if err := f.log.Export(f.superCtx, filter, &buf); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	dec := json.NewDecoder(&buf)
+	count := 0
+	for dec.More() {
+		var event params.AuditEvent
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("decoding line %d: %v", count, err)
+		}
+		if event.Action != audit.ActionPasteView || event.TargetID != fmt.Sprintf("%d", count) {
+			t.Fatalf("line %d: unexpected event %+v", count, event)
+		}
+		count++
+	}
+	if count != total {
+		t.Errorf("want %d events, got %d", total, count)
+	}
+}
+
+type recordingSink struct {
+	events []params.AuditEvent
+}
+
+func (s *recordingSink) Write(event params.AuditEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestUserManager_RecordsEvents(t *testing.T) {
+	f := newTokenFixture(t)
+	sink := &recordingSink{}
+	users, err := adminSQL.NewUserManager(f.dbCfg, config.PasswordPolicy{}, audit.NewRecorder(sink))
+	if err != nil {
+		t.Fatalf("NewUserManager: %v", err)
+	}
+	created, err := users.Create(f.superCtx, params.NewUserParams{
+		Email:    "audited@example.com",
+		Username: "audited",
+		FullName: "Audited User",
+		Password: testPassword,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	admin := true
+	if _, err := users.Update(f.superCtx, created.ID, params.UpdateUserPayload{IsAdmin: &admin}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := users.Disable(f.superCtx, created.ID); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if _, err := users.Delete(f.superCtx, created.ID, adminCommon.DeleteUserOptions{}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	want := "[user.create user.update user.disable user.delete]"
+	if got := fmt.Sprint(actions(sink.events)); got != want {
+		t.Fatalf("want %s, got %s", want, got)
+	}
+	for _, event := range sink.events {
+		if event.Actor != "superadmin" || event.TargetType != audit.TargetUser || event.TargetID != fmt.Sprintf("%d", created.ID) {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	}
+	if update := sink.events[1]; update.Details["fields"] != "is_admin" || update.Details["is_admin"] != "true" {
+		t.Errorf("unexpected update details: %+v", update.Details)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package sql
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopherbin/admin/common"
+	"gopherbin/auth"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/params"
+	"gopherbin/util"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// NewClientCertificateManager returns a new ClientCertificateManager.
+// Client certificates are mapped to users as set in the TLS config.
+func NewClientCertificateManager(dbCfg config.Database, cfg config.TLSConfig) (common.ClientCertificateManager, error) {
+	db, err := util.NewDBConn(dbCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to database")
+	}
+	mappedScopes := cfg.ClientCertScopes
+	if len(mappedScopes) == 0 {
+		mappedScopes = defaultMappedCertScopes
+	}
+	for _, scope := range mappedScopes {
+		if !auth.IsValidScope(scope) {
+			return nil, fmt.Errorf("invalid client_cert_scopes: unknown scope %q", scope)
+		}
+	}
+	return &clientCertificateManager{
+		conn:         db,
+		mapping:      cfg.UserMapping(),
+		mappedScopes: mappedScopes,
+	}, nil
+}
+
+// defaultMappedCertScopes are the scopes of certificates mapped by cn or
+// email, unless configured otherwise. Anyone the CA issues a certificate
+// to gets them, without the user having registered the certificate, so
+// they grant no access to the account or to administration.
+var defaultMappedCertScopes = []string{
+	auth.ScopePasteRead,
+	auth.ScopePasteWrite,
+	auth.ScopeShare,
+	auth.ScopeTeams,
+}
+
+type clientCertificateManager struct {
+	conn    *gorm.DB
+	mapping []config.ClientCertMapping
+	// mappedScopes are the scopes of certificates mapped by cn or email
+	mappedScopes []string
+}
+
+var errNoCertificateUser = gErrors.WithCode(gErrors.NewUnauthorizedError("no user matches the client certificate"), gErrors.CodeInvalidCertificate)
+
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *clientCertificateManager) sqlToParams(cert models.ClientCertificate) params.ClientCertificate {
+	return params.ClientCertificate{
+		ID:          cert.ID,
+		Name:        cert.Name,
+		Fingerprint: cert.Fingerprint,
+		UserID:      cert.UserID,
+		Scopes:      strings.Fields(cert.Scopes),
+		CreatedAt:   cert.CreatedAt,
+		LastUsedAt:  cert.LastUsedAt,
+	}
+}
+
+func (c *clientCertificateManager) Create(ctx context.Context, cert *x509.Certificate, info params.NewClientCertificateParams) (params.ClientCertificate, error) {
+	userID := auth.UserID(ctx)
+	if userID == 0 {
+		return params.ClientCertificate{}, gErrors.ErrUnauthorized
+	}
+	if !auth.HasScope(ctx, auth.ScopeAccount) {
+		return params.ClientCertificate{}, auth.MissingScopeError(auth.ScopeAccount)
+	}
+	if cert == nil {
+		return params.ClientCertificate{}, gErrors.NewValidationError(gErrors.FieldError{
+			Field:   "certificate",
+			Code:    gErrors.CodeRequired,
+			Message: "present the certificate to register as the TLS client certificate of the request",
+		})
+	}
+	if err := info.Validate(); err != nil {
+		return params.ClientCertificate{}, errors.Wrap(err, "validating certificate")
+	}
+	scopes, err := auth.ResolveScopes(ctx, info.Scopes)
+	if err != nil {
+		return params.ClientCertificate{}, errors.Wrap(err, "validating scopes")
+	}
+	fingerprint := certificateFingerprint(cert)
+
+	var existing models.ClientCertificate
+	q := c.conn.Where("fingerprint = ?", fingerprint).First(&existing)
+	if q.Error == nil {
+		return params.ClientCertificate{}, gErrors.WithCode(gErrors.NewDuplicateUserError("certificate is already registered"), gErrors.CodeCertificateInUse)
+	}
+	if !errors.Is(q.Error, gorm.ErrRecordNotFound) {
+		return params.ClientCertificate{}, errors.Wrap(q.Error, "fetching certificate")
+	}
+
+	newCert := models.ClientCertificate{
+		Name:        strings.TrimSpace(info.Name),
+		Fingerprint: fingerprint,
+		UserID:      userID,
+		Scopes:      strings.Join(scopes, " "),
+	}
+	if err := c.conn.Create(&newCert).Error; err != nil {
+		return params.ClientCertificate{}, errors.Wrap(err, "creating certificate")
+	}
+	return c.sqlToParams(newCert), nil
+}
+
+func (c *clientCertificateManager) List(ctx context.Context, userID uint) ([]params.ClientCertificate, error) {
+	if userID != auth.UserID(ctx) && !auth.IsAdmin(ctx) {
+		return nil, gErrors.ErrUnauthorized
+	}
+	var certs []models.ClientCertificate
+	q := c.conn.Where("user_id = ?", userID).Order("id desc").Find(&certs)
+	if q.Error != nil {
+		return nil, errors.Wrap(q.Error, "fetching certificates")
+	}
+	ret := make([]params.ClientCertificate, len(certs))
+	for idx, val := range certs {
+		ret[idx] = c.sqlToParams(val)
+	}
+	return ret, nil
+}
+
+func (c *clientCertificateManager) Delete(ctx context.Context, userID uint, certID uint) error {
+	if userID != auth.UserID(ctx) && !auth.IsAdmin(ctx) {
+		return gErrors.ErrUnauthorized
+	}
+	q := c.conn.Where("id = ? and user_id = ?", certID, userID).Delete(&models.ClientCertificate{})
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "deleting certificate")
+	}
+	if q.RowsAffected == 0 {
+		return gErrors.ErrCertificateNotFound
+	}
+	return nil
+}
+
+// lookup returns the user cert maps to using mapping, and the scopes
+// granted to the certificate.
+func (c *clientCertificateManager) lookup(cert *x509.Certificate, mapping config.ClientCertMapping) (models.Users, []string, error) {
+	var user models.Users
+	switch mapping {
+	case config.ClientCertMapFingerprint:
+		var certInfo models.ClientCertificate
+		q := c.conn.Preload("User").Where("fingerprint = ?", certificateFingerprint(cert)).First(&certInfo)
+		if q.Error != nil {
+			if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+				return user, nil, errNoCertificateUser
+			}
+			return user, nil, errors.Wrap(q.Error, "fetching certificate")
+		}
+		now := time.Now().UTC()
+		if certInfo.LastUsedAt == nil || now.Sub(*certInfo.LastUsedAt) >= lastUsedResolution {
+			q = c.conn.Model(&certInfo).Update("last_used_at", now)
+			if q.Error != nil {
+				return user, nil, errors.Wrap(q.Error, "updating last used timestamp")
+			}
+		}
+		return certInfo.User, strings.Fields(certInfo.Scopes), nil
+	case config.ClientCertMapCommonName:
+		if cert.Subject.CommonName == "" {
+			return user, nil, errNoCertificateUser
+		}
+		q := c.conn.Where("username = ?", cert.Subject.CommonName).First(&user)
+		if q.Error != nil {
+			if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+				return user, nil, errNoCertificateUser
+			}
+			return user, nil, errors.Wrap(q.Error, "fetching user")
+		}
+		return user, c.mappedScopes, nil
+	case config.ClientCertMapEmail:
+		if len(cert.EmailAddresses) == 0 {
+			return user, nil, errNoCertificateUser
+		}
+		q := c.conn.Where("email in ?", cert.EmailAddresses).Order("id").First(&user)
+		if q.Error != nil {
+			if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+				return user, nil, errNoCertificateUser
+			}
+			return user, nil, errors.Wrap(q.Error, "fetching user")
+		}
+		return user, c.mappedScopes, nil
+	}
+	return user, nil, errNoCertificateUser
+}
+
+func (c *clientCertificateManager) Authenticate(ctx context.Context, cert *x509.Certificate) (context.Context, error) {
+	for _, mapping := range c.mapping {
+		user, scopes, err := c.lookup(cert, mapping)
+		if err != nil {
+			if errors.Is(err, errNoCertificateUser) {
+				continue
+			}
+			return ctx, err
+		}
+		if !user.Enabled {
+			return ctx, gErrors.WithCode(gErrors.NewUnauthorizedError("user is disabled"), gErrors.CodeUserDisabled)
+		}
+		ctx = auth.SetScopes(ctx, scopes)
+		return auth.PopulateContext(ctx, params.Users{
+			ID:            user.ID,
+			CreatedAt:     user.CreatedAt,
+			UpdatedAt:     user.UpdatedAt,
+			Email:         user.Email,
+			Username:      user.Username,
+			FullName:      user.FullName,
+			Enabled:       user.Enabled,
+			IsAdmin:       user.IsAdmin,
+			IsSuperUser:   user.IsSuperUser,
+			SecurityStamp: user.SecurityStamp,
+		}), nil
+	}
+	return ctx, errNoCertificateUser
+}
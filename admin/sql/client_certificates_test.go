@@ -0,0 +1,240 @@
+package sql_test
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+
+	adminCommon "gopherbin/admin/common"
+	adminSQL "gopherbin/admin/sql"
+	"gopherbin/auth"
+	"gopherbin/config"
+	"gopherbin/config/certtest"
+	gErrors "gopherbin/errors"
+	"gopherbin/params"
+
+	pkgErrors "github.com/pkg/errors"
+)
+
+func newClientCertificateManager(t *testing.T, f tokenFixture, mapping ...config.ClientCertMapping) adminCommon.ClientCertificateManager {
+	t.Helper()
+	mgr, err := adminSQL.NewClientCertificateManager(f.dbCfg, config.TLSConfig{ClientCertMapping: mapping})
+	if err != nil {
+		t.Fatalf("NewClientCertificateManager: %v", err)
+	}
+	return mgr
+}
+
+func issueClientCert(t *testing.T, commonName string, emails ...string) *certtest.Certificate {
+	t.Helper()
+	ca, err := certtest.NewCA("Gopherbin test CA")
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	cert, err := ca.IssueClient(commonName, emails...)
+	if err != nil {
+		t.Fatalf("IssueClient: %v", err)
+	}
+	return cert
+}
+
+// ── Create ───────────────────────────────────────────────────────────────────
+
+func TestClientCertificateCreate(t *testing.T) {
+	f := newTokenFixture(t)
+	mgr := newClientCertificateManager(t, f)
+	cert := issueClientCert(t, "ci")
+
+	created, err := mgr.Create(f.userCtx, cert.Cert, params.NewClientCertificateParams{
+		Name:   "ci runner",
+		Scopes: []string{auth.ScopePasteWrite},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if created.Fingerprint != cert.Fingerprint() || created.UserID != f.user.ID {
+		t.Errorf("unexpected certificate %+v", created)
+	}
+
+	// The same certificate can not be registered twice.
+	_, err = mgr.Create(f.superCtx, cert.Cert, params.NewClientCertificateParams{Name: "duplicate"})
+	if !isDuplicate(err) || gErrors.Code(err) != gErrors.CodeCertificateInUse {
+		t.Fatalf("want certificate in use, got %v", err)
+	}
+
+	list, err := mgr.List(f.userCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != created.ID {
+		t.Fatalf("want the registered certificate, got %+v", list)
+	}
+	if _, err := mgr.List(f.userCtx, f.user.ID+100); !isUnauthorized(err) {
+		t.Errorf("want unauthorized listing another user, got %v", err)
+	}
+}
+
+func TestClientCertificateCreate_Validation(t *testing.T) {
+	f := newTokenFixture(t)
+	mgr := newClientCertificateManager(t, f)
+	cert := issueClientCert(t, "ci")
+	cases := map[string]struct {
+		cert *x509.Certificate
+		info params.NewClientCertificateParams
+	}{
+		"missing name": {cert.Cert, params.NewClientCertificateParams{}},
+		// Only the certificate presented on the request connection is
+		// registered, so the caller holds its private key.
+		"no certificate presented": {nil, params.NewClientCertificateParams{Name: "ci"}},
+	}
+	for name, tc := range cases {
+		_, err := mgr.Create(f.userCtx, tc.cert, tc.info)
+		if _, ok := pkgErrors.Cause(err).(*gErrors.BadRequestError); !ok {
+			t.Errorf("%s: want BadRequestError, got %v", name, err)
+		}
+	}
+}
+
+func TestClientCertificateCreate_RequiresAccountScope(t *testing.T) {
+	f := newTokenFixture(t)
+	mgr := newClientCertificateManager(t, f)
+	ctx := auth.SetScopes(f.userCtx, []string{auth.ScopePasteRead})
+	_, err := mgr.Create(ctx, issueClientCert(t, "ci").Cert, params.NewClientCertificateParams{Name: "ci"})
+	if gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Fatalf("want insufficient scope, got %v", err)
+	}
+}
+
+// ── Delete ───────────────────────────────────────────────────────────────────
+
+func TestClientCertificateDelete(t *testing.T) {
+	f := newTokenFixture(t)
+	mgr := newClientCertificateManager(t, f)
+	cert := issueClientCert(t, "ci")
+	created, err := mgr.Create(f.userCtx, cert.Cert, params.NewClientCertificateParams{Name: "ci"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := mgr.Delete(f.superCtx, f.user.ID, created.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := mgr.Delete(f.userCtx, f.user.ID, created.ID); !isNotFound(err) {
+		t.Errorf("want not found deleting twice, got %v", err)
+	}
+	if _, err := mgr.Authenticate(context.Background(), cert.Cert); !isUnauthorized(err) {
+		t.Errorf("want deleted certificate rejected, got %v", err)
+	}
+}
+
+// ── Authenticate ─────────────────────────────────────────────────────────────
+
+func TestClientCertificateAuthenticate_Fingerprint(t *testing.T) {
+	f := newTokenFixture(t)
+	mgr := newClientCertificateManager(t, f)
+	cert := issueClientCert(t, "superadmin", "super@example.com")
+	if _, err := mgr.Create(f.userCtx, cert.Cert, params.NewClientCertificateParams{
+		Name:   "ci",
+		Scopes: []string{auth.ScopePasteRead, auth.ScopePasteWrite},
+	}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// The subject names another user, only the fingerprint counts.
+	ctx, err := mgr.Authenticate(context.Background(), cert.Cert)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if auth.UserID(ctx) != f.user.ID {
+		t.Errorf("want user %d, got %d", f.user.ID, auth.UserID(ctx))
+	}
+	if !auth.HasScope(ctx, auth.ScopePasteWrite) || auth.HasScope(ctx, auth.ScopeAccount) {
+		t.Errorf("unexpected scopes %v", auth.Scopes(ctx))
+	}
+	list, err := mgr.List(f.userCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].LastUsedAt == nil {
+		t.Errorf("want last used timestamp set, got %+v", list)
+	}
+
+	if _, err := mgr.Authenticate(context.Background(), issueClientCert(t, "ci").Cert); !isUnauthorized(err) {
+		t.Errorf("want unregistered certificate rejected, got %v", err)
+	}
+}
+
+func TestClientCertificateAuthenticate_Subject(t *testing.T) {
+	f := newTokenFixture(t)
+	mgr := newClientCertificateManager(t, f, config.ClientCertMapCommonName, config.ClientCertMapEmail)
+
+	ctx, err := mgr.Authenticate(context.Background(), issueClientCert(t, "ci").Cert)
+	if err != nil {
+		t.Fatalf("Authenticate by CN: %v", err)
+	}
+	if auth.UserID(ctx) != f.user.ID || !auth.HasScope(ctx, auth.ScopePasteWrite) {
+		t.Errorf("want user %d with the paste scopes, got %d with %v", f.user.ID, auth.UserID(ctx), auth.Scopes(ctx))
+	}
+	// Certificates the user did not register grant no access to the
+	// account or to administration.
+	for _, scope := range []string{auth.ScopeAccount, auth.ScopeTokens, auth.ScopeAdminUsers, auth.ScopeAdminAudit} {
+		if auth.HasScope(ctx, scope) {
+			t.Errorf("want no %s scope by default, got %v", scope, auth.Scopes(ctx))
+		}
+	}
+
+	ctx, err = mgr.Authenticate(context.Background(), issueClientCert(t, "runner-01", "ci@example.com").Cert)
+	if err != nil {
+		t.Fatalf("Authenticate by email: %v", err)
+	}
+	if auth.UserID(ctx) != f.user.ID {
+		t.Errorf("want user %d, got %d", f.user.ID, auth.UserID(ctx))
+	}
+
+	if _, err := mgr.Authenticate(context.Background(), issueClientCert(t, "nobody", "nobody@example.com").Cert); !isUnauthorized(err) {
+		t.Errorf("want unknown subject rejected, got %v", err)
+	}
+
+	// Fingerprints are not used unless configured.
+	cert := issueClientCert(t, "runner-02")
+	if _, err := mgr.Create(f.userCtx, cert.Cert, params.NewClientCertificateParams{Name: "ci"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := mgr.Authenticate(context.Background(), cert.Cert); !isUnauthorized(err) {
+		t.Errorf("want fingerprint ignored, got %v", err)
+	}
+}
+
+func TestClientCertificateAuthenticate_SubjectScopes(t *testing.T) {
+	f := newTokenFixture(t)
+	mgr, err := adminSQL.NewClientCertificateManager(f.dbCfg, config.TLSConfig{
+		ClientCertMapping: []config.ClientCertMapping{config.ClientCertMapCommonName},
+		ClientCertScopes:  []string{auth.ScopePasteRead},
+	})
+	if err != nil {
+		t.Fatalf("NewClientCertificateManager: %v", err)
+	}
+	ctx, err := mgr.Authenticate(context.Background(), issueClientCert(t, "ci").Cert)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if scopes := auth.Scopes(ctx); len(scopes) != 1 || scopes[0] != auth.ScopePasteRead {
+		t.Errorf("want the configured scopes, got %v", scopes)
+	}
+
+	_, err = adminSQL.NewClientCertificateManager(f.dbCfg, config.TLSConfig{ClientCertScopes: []string{"root"}})
+	if err == nil {
+		t.Error("want unknown scopes rejected")
+	}
+}
+
+func TestClientCertificateAuthenticate_DisabledUser(t *testing.T) {
+	f := newTokenFixture(t)
+	mgr := newClientCertificateManager(t, f, config.ClientCertMapCommonName)
+	if err := f.users.Disable(f.superCtx, f.user.ID); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	_, err := mgr.Authenticate(context.Background(), issueClientCert(t, "ci").Cert)
+	if !isUnauthorized(err) || gErrors.Code(err) != gErrors.CodeUserDisabled {
+		t.Fatalf("want user disabled, got %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package sql_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	adminCommon "gopherbin/admin/common"
+	"gopherbin/auth"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/params"
+	pasteSQL "gopherbin/paste/sql"
+	"gopherbin/util"
+
+	"gorm.io/gorm"
+)
+
+// deleteFixture holds the pastes, teams and shares of the ci user, the
+// heir they may be transferred to, and a bystander.
+type deleteFixture struct {
+	tokenFixture
+	db           *gorm.DB
+	heir         params.Users
+	heirCtx      context.Context
+	bystanderCtx context.Context
+	pastes       map[string]string
+}
+
+func newDeleteFixture(t *testing.T) deleteFixture {
+	t.Helper()
+	f := deleteFixture{tokenFixture: newTokenFixture(t), pastes: map[string]string{}}
+	db, err := util.NewDBConn(f.dbCfg)
+	if err != nil {
+		t.Fatalf("NewDBConn: %v", err)
+	}
+	f.db = db
+	newUser := func(username string) (params.Users, context.Context) {
+		user, err := f.users.Create(f.superCtx, params.NewUserParams{
+			Email:    username + "@example.com",
+			Username: username,
+			FullName: username,
+			Password: testPassword,
+			Enabled:  true,
+		})
+		if err != nil {
+			t.Fatalf("Create %s: %v", username, err)
+		}
+		return user, auth.PopulateContext(context.Background(), user)
+	}
+	f.heir, f.heirCtx = newUser("heir")
+	_, f.bystanderCtx = newUser("bystander")
+
+	paster, err := pasteSQL.NewPaster(f.dbCfg, nil)
+	if err != nil {
+		t.Fatalf("NewPaster: %v", err)
+	}
+	teams, err := pasteSQL.NewTeamManager(f.dbCfg, nil)
+	if err != nil {
+		t.Fatalf("NewTeamManager: %v", err)
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = teams.Create(f.userCtx, "runbooks")
+	must(err)
+	_, err = teams.AddMember(f.userCtx, "runbooks", "heir")
+	must(err)
+	_, err = teams.AddMember(f.userCtx, "runbooks", "bystander")
+	must(err)
+	_, err = teams.Create(f.superCtx, "admins")
+	must(err)
+	_, err = teams.AddMember(f.superCtx, "admins", "ci")
+	must(err)
+
+	var runbooks models.Teams
+	must(db.Where("name = ?", "runbooks").First(&runbooks).Error)
+	create := func(ctx context.Context, name string, inTeam bool, shareWith ...string) {
+		t.Helper()
+		pst, err := paster.Create(ctx, []byte(name), name, "text", "", nil, false, "", nil, nil, nil)
+		must(err)
+		f.pastes[name] = pst.PasteID
+		if inTeam {
+			must(db.Model(&models.Paste{}).Where("paste_id = ?", pst.PasteID).Update("team_id", runbooks.ID).Error)
+		}
+		for _, user := range shareWith {
+			_, err := paster.ShareWithUser(ctx, pst.PasteID, user)
+			must(err)
+		}
+	}
+	// Pastes of ci
+	create(f.userCtx, "shared-with-heir", false, "heir")
+	create(f.userCtx, "shared-with-bystander", false, "bystander")
+	create(f.userCtx, "team-runbook", true)
+	// Pastes of others
+	create(f.bystanderCtx, "bystander-runbook", true)
+	create(f.superCtx, "super-shared", false, "ci")
+	create(f.heirCtx, "heir-shared", false, "ci")
+	create(f.bystanderCtx, "bystander-shared", false, "ci", "heir")
+	return f
+}
+
+// owners returns the owner of every paste, by name
+func (f deleteFixture) owners(t *testing.T) map[string]uint {
+	t.Helper()
+	var pastes []models.Paste
+	if err := f.db.Find(&pastes).Error; err != nil {
+		t.Fatal(err)
+	}
+	owners := map[string]uint{}
+	for _, pst := range pastes {
+		owners[pst.Name] = pst.OwnerID
+	}
+	return owners
+}
+
+// sharedWith returns the names of the pastes shared with userID
+func (f deleteFixture) sharedWith(t *testing.T, userID uint) string {
+	t.Helper()
+	var names []string
+	q := f.db.Table("pastes").
+		Joins("JOIN paste_users ON paste_users.paste_id = pastes.id").
+		Where("paste_users.users_id = ?", userID).
+		Pluck("pastes.name", &names)
+	if q.Error != nil {
+		t.Fatal(q.Error)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestUserDelete_DryRun(t *testing.T) {
+	f := newDeleteFixture(t)
+	report, err := f.users.Delete(f.superCtx, f.user.ID, adminCommon.DeleteUserOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	want := params.UserDeletionReport{
+		DryRun:                 true,
+		User:                   report.User,
+		PastesDeleted:          4,
+		TeamsDeleted:           1,
+		SharesRemoved:          3,
+		TeamMembershipsRemoved: 1,
+	}
+	if report.User.ID != f.user.ID || report != want {
+		t.Errorf("want %+v, got %+v", want, report)
+	}
+	if _, err := f.users.Get(f.superCtx, f.user.ID); err != nil {
+		t.Errorf("want the user kept in a dry run, got %v", err)
+	}
+	if owners := f.owners(t); len(owners) != 7 || owners["team-runbook"] != f.user.ID {
+		t.Errorf("want the pastes kept in a dry run, got %v", owners)
+	}
+
+	report, err = f.users.Delete(f.superCtx, f.user.ID, adminCommon.DeleteUserOptions{DryRun: true, TransferTo: "heir@example.com"})
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if report.TransferTo == nil || report.TransferTo.ID != f.heir.ID || report.PastesTransferred != 3 || report.PastesDeleted != 0 {
+		t.Errorf("unexpected transfer report: %+v", report)
+	}
+	if owners := f.owners(t); owners["team-runbook"] != f.user.ID {
+		t.Errorf("want the pastes kept in a dry run, got %v", owners)
+	}
+	if got := f.sharedWith(t, f.user.ID); got != "bystander-shared,heir-shared,super-shared" {
+		t.Errorf("want the shares kept in a dry run, got %s", got)
+	}
+
+	// Pastes may only be transferred to enabled users, dry run or not.
+	pending := models.Users{Username: "pending", Email: "pending@example.com", PendingApproval: true}
+	if err := f.db.Create(&pending).Error; err != nil {
+		t.Fatalf("creating pending user: %v", err)
+	}
+	if err := f.users.Disable(f.superCtx, f.heir.ID); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	for transferTo, code := range map[string]string{
+		"heir":    gErrors.CodeUserDisabled,
+		"pending": gErrors.CodeApprovalPending,
+	} {
+		for _, dryRun := range []bool{true, false} {
+			_, err := f.users.Delete(f.superCtx, f.user.ID, adminCommon.DeleteUserOptions{DryRun: dryRun, TransferTo: transferTo})
+			badReq, ok := err.(*gErrors.BadRequestError)
+			if !ok || len(badReq.Fields()) != 1 || badReq.Fields()[0].Code != code {
+				t.Errorf("%s (dry run %v): want a %s field error, got %T: %v", transferTo, dryRun, code, err, err)
+			}
+		}
+	}
+	if _, err := f.users.Get(f.superCtx, f.user.ID); err != nil {
+		t.Errorf("want the user kept, got %v", err)
+	}
+}
+
+func TestUserDelete_WithoutTransfer(t *testing.T) {
+	f := newDeleteFixture(t)
+	if _, err := f.users.Delete(f.superCtx, f.user.ID, adminCommon.DeleteUserOptions{}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	owners := f.owners(t)
+	if len(owners) != 3 {
+		t.Errorf("want the pastes of ci and their team deleted, got %v", owners)
+	}
+	if _, ok := owners["bystander-runbook"]; ok {
+		t.Error("want the pastes of the team of ci deleted")
+	}
+}
+
+func TestUserDelete_Transfer(t *testing.T) {
+	f := newDeleteFixture(t)
+	report, err := f.users.Delete(f.superCtx, f.user.ID, adminCommon.DeleteUserOptions{TransferTo: "heir"})
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	want := params.UserDeletionReport{
+		User:                   report.User,
+		TransferTo:             report.TransferTo,
+		PastesTransferred:      3,
+		TeamsTransferred:       1,
+		SharesRemoved:          2,
+		SharesTransferred:      1,
+		TeamMembershipsRemoved: 1,
+	}
+	if report != want || report.TransferTo == nil || report.TransferTo.Username != "heir" {
+		t.Errorf("want %+v, got %+v", want, report)
+	}
+	if _, err := f.users.Get(f.superCtx, f.user.ID); !isNotFound(err) {
+		t.Errorf("want the user deleted, got %v", err)
+	}
+
+	owners := f.owners(t)
+	if len(owners) != 7 {
+		t.Fatalf("want no paste deleted, got %v", owners)
+	}
+	for _, name := range []string{"shared-with-heir", "shared-with-bystander", "team-runbook", "heir-shared"} {
+		if owners[name] != f.heir.ID {
+			t.Errorf("%s: want owned by heir, got %d", name, owners[name])
+		}
+	}
+	if got := f.sharedWith(t, f.heir.ID); got != "bystander-shared,super-shared" {
+		t.Errorf("unexpected pastes shared with heir: %s", got)
+	}
+
+	var team models.Teams
+	if err := f.db.Preload("Members").Where("name = ?", "runbooks").First(&team).Error; err != nil {
+		t.Fatal(err)
+	}
+	if team.OwnerID != f.heir.ID || len(team.Members) != 1 || team.Members[0].Username != "bystander" {
+		t.Errorf("want the team owned by heir, with bystander as member, got %+v", team)
+	}
+
+	// The transferred pastes are usable by their new owner.
+	paster, err := pasteSQL.NewPaster(f.dbCfg, nil)
+	if err != nil {
+		t.Fatalf("NewPaster: %v", err)
+	}
+	for _, name := range []string{"team-runbook", "bystander-runbook", "super-shared"} {
+		if _, err := paster.Get(f.heirCtx, f.pastes[name]); err != nil {
+			t.Errorf("%s: want heir to have access, got %v", name, err)
+		}
+	}
+}
+
+func TestUserDelete_InvalidTransfer(t *testing.T) {
+	f := newDeleteFixture(t)
+	for _, transferTo := range []string{"nobody", "ci"} {
+		_, err := f.users.Delete(f.superCtx, f.user.ID, adminCommon.DeleteUserOptions{TransferTo: transferTo})
+		badReq, ok := err.(*gErrors.BadRequestError)
+		if !ok || len(badReq.Fields()) != 1 || badReq.Fields()[0].Field != "transfer_to" {
+			t.Errorf("%s: want a transfer_to field error, got %T: %v", transferTo, err, err)
+		}
+	}
+	if _, err := f.users.Get(f.superCtx, f.user.ID); err != nil {
+		t.Errorf("want the user kept, got %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package sql
+
+import (
+	"context"
+	"fmt"
+
+	"gopherbin/admin/common"
+	"gopherbin/auth"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/params"
+	"gopherbin/util"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// maxUsernameSuffix limits the number of usernames we try when the
+// preferred username of an external user is taken.
+const maxUsernameSuffix = 100
+
+// getUserByExternalID returns the user provisioned by, or the local user
+// linked to, the identity subject of provider.
+func (u *userManager) getUserByExternalID(provider, subject string) (models.Users, error) {
+	var tmpUser models.Users
+	q := u.conn.Where("auth_provider = ? and external_id = ?", provider, subject).First(&tmpUser)
+	if q.Error == nil {
+		return tmpUser, nil
+	}
+	if !errors.Is(q.Error, gorm.ErrRecordNotFound) {
+		return models.Users{}, errors.Wrap(q.Error, "fetching user from database")
+	}
+	var link models.ExternalIdentity
+	q = u.conn.Preload("User").Where("provider = ? and subject = ?", provider, subject).First(&link)
+	if q.Error != nil {
+		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+			return models.Users{}, gErrors.ErrUserNotFound
+		}
+		return models.Users{}, errors.Wrap(q.Error, "fetching external identity from database")
+	}
+	return link.User, nil
+}
+
+// linkExternalIdentity links the local account usr to identity. An
+// account is linked to at most one identity of each provider.
+func (u *userManager) linkExternalIdentity(usr models.Users, identity common.ExternalIdentity) error {
+	var count int64
+	q := u.conn.Model(&models.ExternalIdentity{}).Where("user_id = ? and provider = ?", usr.ID, identity.Provider).Count(&count)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "fetching external identities")
+	}
+	if count > 0 {
+		return gErrors.WithCode(
+			gErrors.NewConflictError("email address %s is already used by another account", identity.Email),
+			gErrors.CodeEmailInUse)
+	}
+	link := models.ExternalIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		UserID:   usr.ID,
+	}
+	if err := u.conn.Create(&link).Error; err != nil {
+		return errors.Wrap(err, "linking user")
+	}
+	return nil
+}
+
+// availableUsername returns username, or username followed by a number
+// if it is already taken.
+func (u *userManager) availableUsername(username string) (string, error) {
+	if username == "" {
+		username = "user"
+	}
+	for idx := 1; idx <= maxUsernameSuffix; idx++ {
+		candidate := username
+		if idx > 1 {
+			suffix := fmt.Sprintf("%d", idx)
+			if len(candidate)+len(suffix) > util.MaxUsernameLength {
+				candidate = candidate[:util.MaxUsernameLength-len(suffix)]
+			}
+			candidate += suffix
+		}
+		_, err := u.getUserByUsername(candidate)
+		if errors.Is(err, gErrors.ErrNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", gErrors.WithCode(gErrors.NewConflictError("no available username for %s", username), gErrors.CodeUsernameInUse)
+}
+
+// linkOrCreateExternalUser returns the user identified by identity. Users
+// are linked to an existing local account by email address, if allowed,
+// or are created otherwise. Linked accounts stay local accounts, so their
+// password and email address are still managed by gopherbin.
+func (u *userManager) linkOrCreateExternalUser(identity common.ExternalIdentity) (models.Users, error) {
+	usr, err := u.getUserByEmail(identity.Email)
+	if err == nil {
+		if !identity.LinkByEmail || !identity.EmailVerified || usr.AuthProvider != "" {
+			return models.Users{}, gErrors.WithCode(
+				gErrors.NewConflictError("email address %s is already used by another account", identity.Email),
+				gErrors.CodeEmailInUse)
+		}
+		if err := u.linkExternalIdentity(usr, identity); err != nil {
+			return models.Users{}, err
+		}
+		return usr, nil
+	}
+	if !errors.Is(err, gErrors.ErrNotFound) {
+		return models.Users{}, err
+	}
+	if identity.LinkOnly {
+		return models.Users{}, gErrors.NewUnauthorizedError(fmt.Sprintf("no account exists for %s", identity.Email))
+	}
+
+	username, err := u.availableUsername(identity.Username)
+	if err != nil {
+		return models.Users{}, err
+	}
+	fullName := identity.FullName
+	if fullName == "" {
+		fullName = username
+	}
+	subject := identity.Subject
+	usr = models.Users{
+		Username:     username,
+		FullName:     fullName,
+		Email:        identity.Email,
+		Enabled:      true,
+		AuthProvider: identity.Provider,
+		ExternalID:   &subject,
+	}
+	if identity.IsAdmin != nil {
+		usr.IsAdmin = *identity.IsAdmin
+	}
+	if err := rotateSecurityStamp(&usr); err != nil {
+		return models.Users{}, err
+	}
+	if err := u.conn.Create(&usr).Error; err != nil {
+		return models.Users{}, errors.Wrap(err, "creating user")
+	}
+	return usr, nil
+}
+
+func (u *userManager) AuthenticateExternal(ctx context.Context, identity common.ExternalIdentity) (context.Context, error) {
+	if identity.Provider == "" || identity.Subject == "" {
+		return ctx, gErrors.ErrInvalidCredentials
+	}
+	if !util.IsValidEmail(identity.Email) {
+		return ctx, gErrors.WithCode(
+			gErrors.NewUnauthorizedError("the identity provider did not supply a valid email address"),
+			gErrors.CodeInvalidEmail)
+	}
+
+	usr, err := u.getUserByExternalID(identity.Provider, identity.Subject)
+	if err != nil {
+		if !errors.Is(err, gErrors.ErrNotFound) {
+			return ctx, err
+		}
+		usr, err = u.linkOrCreateExternalUser(identity)
+		if err != nil {
+			return ctx, err
+		}
+	}
+
+	// Keep the user in sync with the identity provider. Tokens issued
+	// to the user are invalidated if the user loses privileges.
+	changed, rotateStamp := false, false
+	if identity.Enabled != nil && *identity.Enabled != usr.Enabled && !usr.IsSuperUser {
+		usr.Enabled = *identity.Enabled
+		changed = true
+		rotateStamp = rotateStamp || !usr.Enabled
+	}
+	if identity.FullName != "" && identity.FullName != usr.FullName {
+		usr.FullName = identity.FullName
+		changed = true
+	}
+	if identity.IsAdmin != nil && *identity.IsAdmin != usr.IsAdmin && !usr.IsSuperUser {
+		usr.IsAdmin = *identity.IsAdmin
+		changed = true
+		rotateStamp = rotateStamp || !usr.IsAdmin
+	}
+	// The email address of linked local accounts is managed by the user.
+	if usr.AuthProvider == identity.Provider && identity.Email != usr.Email {
+		if _, err := u.getUserByEmail(identity.Email); errors.Is(err, gErrors.ErrNotFound) {
+			usr.Email = identity.Email
+			changed = true
+		}
+	}
+	if rotateStamp {
+		if err := rotateSecurityStamp(&usr); err != nil {
+			return ctx, err
+		}
+	}
+	if changed {
+		if err := u.conn.Save(&usr).Error; err != nil {
+			return ctx, errors.Wrap(err, "updating user")
+		}
+	}
+	if !usr.Enabled {
+		return ctx, gErrors.WithCode(gErrors.NewUnauthorizedError("user is disabled"), gErrors.CodeUserDisabled)
+	}
+	if err := u.syncTeams(usr, identity); err != nil {
+		return ctx, errors.Wrap(err, "syncing teams")
+	}
+	return auth.PopulateContext(ctx, u.sqlUserToParams(usr)), nil
+}
+
+// syncTeams adds usr to, or removes it from, the teams managed by the
+// identity provider. Only teams created by the provider are managed. A
+// team of the same name created by a user is never taken over.
+func (u *userManager) syncTeams(usr models.Users, identity common.ExternalIdentity) error {
+	wanted := map[string]bool{}
+	for _, name := range identity.Teams {
+		wanted[name] = true
+	}
+	for _, name := range identity.ManagedTeams {
+		var team models.Teams
+		err := u.conn.Preload("Members").Where("name = ?", name).First(&team).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if !wanted[name] {
+				continue
+			}
+			team, err = u.createManagedTeam(name, identity.Provider)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "fetching team %s", name)
+		}
+		if team.ManagedBy != identity.Provider {
+			continue
+		}
+
+		isMember := false
+		for _, member := range team.Members {
+			if member.ID == usr.ID {
+				isMember = true
+				break
+			}
+		}
+		switch {
+		case wanted[name] && !isMember:
+			err = u.conn.Model(&team).Association("Members").Append(&usr)
+		case !wanted[name] && isMember:
+			err = u.conn.Model(&team).Association("Members").Delete(&usr)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "updating members of team %s", name)
+		}
+	}
+	return nil
+}
+
+// createManagedTeam creates a team managed by provider. The team is owned
+// by the super user.
+func (u *userManager) createManagedTeam(name, provider string) (models.Teams, error) {
+	var owner models.Users
+	if err := u.conn.Where("is_super_user = ?", true).First(&owner).Error; err != nil {
+		return models.Teams{}, errors.Wrap(err, "fetching super user")
+	}
+	team := models.Teams{
+		Name:      name,
+		OwnerID:   owner.ID,
+		ManagedBy: provider,
+	}
+	if err := u.conn.Create(&team).Error; err != nil {
+		return models.Teams{}, errors.Wrap(err, "creating team")
+	}
+	return team, nil
+}
+
+// directory returns the directory users of provider are authenticated
+// against, or nil if there is none.
+func (u *userManager) directory(provider string) common.Directory {
+	if provider == "" {
+		return nil
+	}
+	for _, dir := range u.directories {
+		if dir.Name() == provider {
+			return dir
+		}
+	}
+	return nil
+}
+
+// authenticateWithDirectories checks the credentials in info against
+// directories, in order. Users are provisioned or updated using the
+// identity returned by the first directory the user exists in.
+func (u *userManager) authenticateWithDirectories(ctx context.Context, directories []common.Directory, info params.PasswordLoginParams) (context.Context, error) {
+	for _, dir := range directories {
+		identity, err := dir.Authenticate(ctx, info.Username, info.Password)
+		if err != nil {
+			if errors.Is(err, gErrors.ErrUserNotFound) {
+				continue
+			}
+			return ctx, err
+		}
+		return u.AuthenticateExternal(ctx, identity)
+	}
+	return ctx, gErrors.ErrInvalidCredentials
+}
@@ -0,0 +1,549 @@
+package sql_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	adminCommon "gopherbin/admin/common"
+	adminSQL "gopherbin/admin/sql"
+	"gopherbin/auth"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/params"
+	pasteCommon "gopherbin/paste/common"
+	pasteSQL "gopherbin/paste/sql"
+)
+
+func externalIdentity(subject, username, email string) adminCommon.ExternalIdentity {
+	return adminCommon.ExternalIdentity{
+		Provider:      "oidc",
+		Subject:       subject,
+		Username:      username,
+		Email:         email,
+		EmailVerified: true,
+		FullName:      "External User",
+	}
+}
+
+func boolPtr(val bool) *bool {
+	return &val
+}
+
+// ── AuthenticateExternal ─────────────────────────────────────────────────────
+
+func TestAuthenticateExternal_ProvisionsUser(t *testing.T) {
+	mgr, superCtx := newAdminFixture(t)
+
+	ctx, err := mgr.AuthenticateExternal(context.Background(), externalIdentity("sub-1", "alice", "alice@example.com"))
+	if err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	userID := auth.UserID(ctx)
+	if userID == 0 || auth.IsAdmin(ctx) || !auth.IsEnabled(ctx) {
+		t.Fatalf("unexpected context: id=%d admin=%v enabled=%v", userID, auth.IsAdmin(ctx), auth.IsEnabled(ctx))
+	}
+	usr, err := mgr.Get(superCtx, userID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if usr.Username != "alice" || usr.Email != "alice@example.com" || usr.FullName != "External User" || usr.AuthProvider != "oidc" {
+		t.Errorf("unexpected user: %+v", usr)
+	}
+
+	// Logging in again returns the same user.
+	ctx, err = mgr.AuthenticateExternal(context.Background(), externalIdentity("sub-1", "alice", "alice@example.com"))
+	if err != nil {
+		t.Fatalf("second AuthenticateExternal: %v", err)
+	}
+	if auth.UserID(ctx) != userID {
+		t.Errorf("want user %d, got %d", userID, auth.UserID(ctx))
+	}
+
+	// External users have no password.
+	if _, err := mgr.Authenticate(context.Background(), params.PasswordLoginParams{
+		Username: "alice",
+		Password: "",
+	}); !isUnauthorized(err) {
+		t.Errorf("expected password login to fail, got %v", err)
+	}
+}
+
+func TestAuthenticateExternal_UsernameTaken(t *testing.T) {
+	mgr, superCtx := newAdminFixture(t)
+
+	ctx, err := mgr.AuthenticateExternal(context.Background(), externalIdentity("sub-1", "superadmin", "other@example.com"))
+	if err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	usr, err := mgr.Get(superCtx, auth.UserID(ctx))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if usr.Username != "superadmin2" {
+		t.Errorf("want username superadmin2, got %q", usr.Username)
+	}
+
+	long := strings.Repeat("a", 64)
+	if _, err := mgr.AuthenticateExternal(context.Background(), externalIdentity("sub-2", long, "long1@example.com")); err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	ctx, err = mgr.AuthenticateExternal(context.Background(), externalIdentity("sub-3", long, "long2@example.com"))
+	if err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	usr, _ = mgr.Get(superCtx, auth.UserID(ctx))
+	if usr.Username != strings.Repeat("a", 63)+"2" {
+		t.Errorf("unexpected username %q", usr.Username)
+	}
+}
+
+func TestAuthenticateExternal_LinkByEmail(t *testing.T) {
+	mgr, superCtx := newAdminFixture(t)
+	local, err := mgr.Create(superCtx, params.NewUserParams{
+		Email:    "bob@example.com",
+		Username: "bob",
+		FullName: "Bob",
+		Password: testPassword,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	identity := externalIdentity("sub-bob", "bobby", "bob@example.com")
+	_, err = mgr.AuthenticateExternal(context.Background(), identity)
+	if gErrors.Code(err) != gErrors.CodeEmailInUse {
+		t.Fatalf("expected email_in_use without link_by_email, got %v", err)
+	}
+
+	identity.LinkByEmail = true
+	identity.EmailVerified = false
+	_, err = mgr.AuthenticateExternal(context.Background(), identity)
+	if gErrors.Code(err) != gErrors.CodeEmailInUse {
+		t.Fatalf("expected email_in_use for unverified email, got %v", err)
+	}
+
+	identity.EmailVerified = true
+	ctx, err := mgr.AuthenticateExternal(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	if auth.UserID(ctx) != local.ID {
+		t.Fatalf("want linked user %d, got %d", local.ID, auth.UserID(ctx))
+	}
+	// The account stays a local account, so the local password keeps
+	// working and can still be changed.
+	usr, err := mgr.Get(superCtx, local.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if usr.AuthProvider != "" {
+		t.Errorf("want linked account to stay local, got provider %q", usr.AuthProvider)
+	}
+	if _, err := mgr.Authenticate(context.Background(), params.PasswordLoginParams{
+		Username: "bob",
+		Password: testPassword,
+	}); err != nil {
+		t.Errorf("password login after linking: %v", err)
+	}
+	newPassword := "Another-Correct-Horse-Battery-Staple-2024!"
+	if _, err := mgr.ChangePassword(ctx, testPassword, newPassword); err != nil {
+		t.Fatalf("ChangePassword after linking: %v", err)
+	}
+
+	// The link is used for later logins, even after the local email
+	// address changed.
+	newEmail := "robert@example.com"
+	if _, err := mgr.Update(superCtx, local.ID, params.UpdateUserPayload{Email: &newEmail}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	ctx, err = mgr.AuthenticateExternal(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateExternal after linking: %v", err)
+	}
+	if auth.UserID(ctx) != local.ID {
+		t.Fatalf("want linked user %d, got %d", local.ID, auth.UserID(ctx))
+	}
+	if usr, _ := mgr.Get(superCtx, local.ID); usr.Email != newEmail {
+		t.Errorf("want local email %q kept, got %q", newEmail, usr.Email)
+	}
+
+	// An account linked to one identity is not linked to another.
+	second := externalIdentity("sub-other", "bob", newEmail)
+	second.LinkByEmail = true
+	_, err = mgr.AuthenticateExternal(context.Background(), second)
+	if gErrors.Code(err) != gErrors.CodeEmailInUse {
+		t.Fatalf("expected email_in_use for second identity, got %v", err)
+	}
+
+	// Deleting the account removes the link.
+	if _, err := mgr.Delete(superCtx, local.ID, adminCommon.DeleteUserOptions{}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	ctx, err = mgr.AuthenticateExternal(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateExternal after delete: %v", err)
+	}
+	if auth.UserID(ctx) == local.ID {
+		t.Error("expected a new account after the linked account was deleted")
+	}
+}
+
+func TestAuthenticateExternal_LinkOnly(t *testing.T) {
+	mgr, superCtx := newAdminFixture(t)
+	local, err := mgr.Create(superCtx, params.NewUserParams{
+		Email:    "carol@example.com",
+		Username: "carol",
+		FullName: "Carol",
+		Password: testPassword,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	identity := externalIdentity("sub-dave", "dave", "dave@example.com")
+	identity.LinkByEmail = true
+	identity.LinkOnly = true
+	if _, err := mgr.AuthenticateExternal(context.Background(), identity); !isUnauthorized(err) {
+		t.Fatalf("expected unknown user to be rejected, got %v", err)
+	}
+	if _, err := mgr.GetByEmail(superCtx, "dave@example.com"); !isNotFound(err) {
+		t.Errorf("expected no account to be created, got %v", err)
+	}
+
+	identity = externalIdentity("sub-carol", "carol", "carol@example.com")
+	identity.LinkByEmail = true
+	identity.LinkOnly = true
+	ctx, err := mgr.AuthenticateExternal(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	if auth.UserID(ctx) != local.ID {
+		t.Errorf("want linked user %d, got %d", local.ID, auth.UserID(ctx))
+	}
+}
+
+func TestAuthenticateExternal_SyncsUser(t *testing.T) {
+	mgr, superCtx := newAdminFixture(t)
+
+	identity := externalIdentity("sub-1", "carol", "carol@example.com")
+	identity.IsAdmin = boolPtr(true)
+	ctx, err := mgr.AuthenticateExternal(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	if !auth.IsAdmin(ctx) {
+		t.Fatal("expected admin group to grant admin")
+	}
+
+	identity.IsAdmin = boolPtr(false)
+	identity.FullName = "Carol Jones"
+	identity.Email = "carol.jones@example.com"
+	ctx, err = mgr.AuthenticateExternal(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	if auth.IsAdmin(ctx) {
+		t.Error("expected admin to be revoked")
+	}
+	usr, _ := mgr.Get(superCtx, auth.UserID(ctx))
+	if usr.FullName != "Carol Jones" || usr.Email != "carol.jones@example.com" {
+		t.Errorf("user not updated: %+v", usr)
+	}
+
+	// Without admin groups, the admin flag is managed locally.
+	identity.IsAdmin = nil
+	if _, err := mgr.Update(superCtx, usr.ID, params.UpdateUserPayload{IsAdmin: boolPtr(true)}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	ctx, err = mgr.AuthenticateExternal(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	if !auth.IsAdmin(ctx) {
+		t.Error("expected locally granted admin to be kept")
+	}
+}
+
+func TestAuthenticateExternal_DisabledUser(t *testing.T) {
+	mgr, superCtx := newAdminFixture(t)
+
+	identity := externalIdentity("sub-1", "dave", "dave@example.com")
+	ctx, err := mgr.AuthenticateExternal(context.Background(), identity)
+	if err != nil {
+		t.Fatalf("AuthenticateExternal: %v", err)
+	}
+	if err := mgr.Disable(superCtx, auth.UserID(ctx)); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	_, err = mgr.AuthenticateExternal(context.Background(), identity)
+	if !isUnauthorized(err) || gErrors.Code(err) != gErrors.CodeUserDisabled {
+		t.Fatalf("expected user_disabled, got %v", err)
+	}
+}
+
+func TestAuthenticateExternal_InvalidIdentity(t *testing.T) {
+	mgr, _ := newAdminFixture(t)
+
+	cases := map[string]adminCommon.ExternalIdentity{
+		"no subject":    externalIdentity("", "eve", "eve@example.com"),
+		"invalid email": externalIdentity("sub-1", "eve", "not-an-email"),
+	}
+	for name, identity := range cases {
+		_, err := mgr.AuthenticateExternal(context.Background(), identity)
+		if !errors.Is(err, gErrors.ErrUnauthorized) {
+			t.Errorf("%s: expected UnauthorizedError, got %v", name, err)
+		}
+	}
+}
+
+// ── Directories ──────────────────────────────────────────────────────────────
+
+type directoryUser struct {
+	password string
+	identity adminCommon.ExternalIdentity
+}
+
+// stubDirectory is a directory holding a fixed set of users
+type stubDirectory struct {
+	users map[string]*directoryUser
+	calls int
+}
+
+func (d *stubDirectory) Name() string {
+	return "ldap"
+}
+
+func (d *stubDirectory) Authenticate(ctx context.Context, username, password string) (adminCommon.ExternalIdentity, error) {
+	d.calls++
+	usr, ok := d.users[username]
+	if !ok {
+		return adminCommon.ExternalIdentity{}, gErrors.ErrUserNotFound
+	}
+	if usr.password != password {
+		return adminCommon.ExternalIdentity{}, gErrors.ErrInvalidCredentials
+	}
+	return usr.identity, nil
+}
+
+func newDirectoryFixture(t *testing.T) (adminCommon.UserManager, pasteCommon.TeamManager, *stubDirectory, context.Context) {
+	t.Helper()
+	dbCfg := testDBConfig(t)
+	if _, err := pasteSQL.NewPaster(dbCfg, nil); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	enabled := true
+	identity := externalIdentity("uid=frank,ou=people,dc=example,dc=com", "frank", "frank@example.com")
+	identity.Provider = "ldap"
+	identity.Enabled = &enabled
+	identity.ManagedTeams = []string{"devs", "ops"}
+	identity.Teams = []string{"devs"}
+	dir := &stubDirectory{users: map[string]*directoryUser{
+		"frank": {password: "frank-secret", identity: identity},
+	}}
+	mgr, err := adminSQL.NewUserManager(dbCfg, config.PasswordPolicy{}, nil, dir)
+	if err != nil {
+		t.Fatalf("NewUserManager: %v", err)
+	}
+	teams, err := pasteSQL.NewTeamManager(dbCfg, nil)
+	if err != nil {
+		t.Fatalf("NewTeamManager: %v", err)
+	}
+	super, err := mgr.CreateSuperUser(params.NewUserParams{
+		Email:    "super@example.com",
+		Username: "superadmin",
+		FullName: "Super Admin",
+		Password: testPassword,
+	})
+	if err != nil {
+		t.Fatalf("CreateSuperUser: %v", err)
+	}
+	return mgr, teams, dir, auth.PopulateContext(context.Background(), super)
+}
+
+func isTeamMember(t *testing.T, teams pasteCommon.TeamManager, ctx context.Context, team, username string) bool {
+	t.Helper()
+	members, err := teams.ListMembers(ctx, team)
+	if err != nil {
+		t.Fatalf("ListMembers(%s): %v", team, err)
+	}
+	for _, member := range members {
+		if member.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAuthenticate_Directory(t *testing.T) {
+	mgr, _, dir, superCtx := newDirectoryFixture(t)
+
+	ctx, err := mgr.Authenticate(context.Background(), params.PasswordLoginParams{
+		Username: "frank",
+		Password: "frank-secret",
+	})
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	usr, err := mgr.Get(superCtx, auth.UserID(ctx))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if usr.Username != "frank" || usr.AuthProvider != "ldap" || !usr.Enabled {
+		t.Errorf("unexpected user: %+v", usr)
+	}
+
+	// Once provisioned, users are still authenticated by the directory.
+	_, err = mgr.Authenticate(context.Background(), params.PasswordLoginParams{
+		Username: "frank@example.com",
+		Password: "frank-secret",
+	})
+	if !isUnauthorized(err) {
+		t.Errorf("expected the directory to reject an unknown login, got %v", err)
+	}
+	_, err = mgr.Authenticate(context.Background(), params.PasswordLoginParams{
+		Username: "frank",
+		Password: "wrong",
+	})
+	if !isUnauthorized(err) {
+		t.Errorf("expected wrong password to be rejected, got %v", err)
+	}
+	_, err = mgr.Authenticate(context.Background(), params.PasswordLoginParams{
+		Username: "nobody",
+		Password: "secret",
+	})
+	if !errors.Is(err, gErrors.ErrInvalidCredentials) {
+		t.Errorf("expected unknown user to be rejected, got %v", err)
+	}
+	if dir.calls != 4 {
+		t.Errorf("want 4 directory calls, got %d", dir.calls)
+	}
+}
+
+func TestAuthenticate_LocalUsersSkipDirectory(t *testing.T) {
+	mgr, _, dir, _ := newDirectoryFixture(t)
+	// A local user shadows a directory user with the same username.
+	dir.users["superadmin"] = &directoryUser{
+		password: "directory-secret",
+		identity: externalIdentity("uid=superadmin", "superadmin", "other@example.com"),
+	}
+
+	if _, err := mgr.Authenticate(context.Background(), params.PasswordLoginParams{
+		Username: "superadmin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	_, err := mgr.Authenticate(context.Background(), params.PasswordLoginParams{
+		Username: "superadmin",
+		Password: "directory-secret",
+	})
+	if !isUnauthorized(err) {
+		t.Errorf("expected directory password to be rejected, got %v", err)
+	}
+	if dir.calls != 0 {
+		t.Errorf("expected the directory not to be used, got %d calls", dir.calls)
+	}
+}
+
+func TestAuthenticate_DirectorySyncsEnabled(t *testing.T) {
+	mgr, _, dir, superCtx := newDirectoryFixture(t)
+	login := params.PasswordLoginParams{Username: "frank", Password: "frank-secret"}
+
+	ctx, err := mgr.Authenticate(context.Background(), login)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	userID := auth.UserID(ctx)
+
+	disabled := false
+	dir.users["frank"].identity.Enabled = &disabled
+	_, err = mgr.Authenticate(context.Background(), login)
+	if gErrors.Code(err) != gErrors.CodeUserDisabled {
+		t.Fatalf("expected user_disabled, got %v", err)
+	}
+	if usr, _ := mgr.Get(superCtx, userID); usr.Enabled {
+		t.Error("expected user to be disabled locally")
+	}
+
+	enabled := true
+	dir.users["frank"].identity.Enabled = &enabled
+	if _, err := mgr.Authenticate(context.Background(), login); err != nil {
+		t.Fatalf("Authenticate after re-enabling: %v", err)
+	}
+	if usr, _ := mgr.Get(superCtx, userID); !usr.Enabled {
+		t.Error("expected user to be enabled locally")
+	}
+}
+
+func TestAuthenticate_DirectorySyncsTeams(t *testing.T) {
+	mgr, teams, dir, superCtx := newDirectoryFixture(t)
+	login := params.PasswordLoginParams{Username: "frank", Password: "frank-secret"}
+
+	if _, err := mgr.Authenticate(context.Background(), login); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !isTeamMember(t, teams, superCtx, "devs", "frank") {
+		t.Error("expected frank to be added to devs")
+	}
+	// Teams the user is not a member of are not created.
+	if _, err := teams.Get(superCtx, "ops"); !errors.Is(err, gErrors.ErrNotFound) {
+		t.Errorf("ops: want ErrNotFound, got %v", err)
+	}
+
+	// Unmanaged teams are left alone.
+	if _, err := teams.Create(superCtx, "friends"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := teams.AddMember(superCtx, "friends", "frank"); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+
+	dir.users["frank"].identity.Teams = []string{"ops"}
+	if _, err := mgr.Authenticate(context.Background(), login); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if isTeamMember(t, teams, superCtx, "devs", "frank") {
+		t.Error("expected frank to be removed from devs")
+	}
+	if !isTeamMember(t, teams, superCtx, "ops", "frank") {
+		t.Error("expected frank to be added to ops")
+	}
+	if !isTeamMember(t, teams, superCtx, "friends", "frank") {
+		t.Error("expected frank to stay in friends")
+	}
+}
+
+func TestAuthenticate_DirectoryLeavesUserTeams(t *testing.T) {
+	mgr, teams, dir, superCtx := newDirectoryFixture(t)
+	login := params.PasswordLoginParams{Username: "frank", Password: "frank-secret"}
+
+	// Teams created by users are not taken over by the directory, even
+	// if they have the name of a managed team.
+	if _, err := teams.Create(superCtx, "devs"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := mgr.Authenticate(context.Background(), login); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if isTeamMember(t, teams, superCtx, "devs", "frank") {
+		t.Error("expected frank not to be added to a team created by a user")
+	}
+
+	if _, err := teams.Create(superCtx, "ops"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := teams.AddMember(superCtx, "ops", "frank"); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+	dir.users["frank"].identity.Teams = nil
+	if _, err := mgr.Authenticate(context.Background(), login); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !isTeamMember(t, teams, superCtx, "ops", "frank") {
+		t.Error("expected frank to stay in a team created by a user")
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopherbin/admin/common"
+	"gopherbin/auth"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/util"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxSubjectLength is the size of the subject column of login attempts.
+const maxSubjectLength = 255
+
+// NewLoginThrottler returns a new LoginThrottler
+func NewLoginThrottler(dbCfg config.Database, cfg config.LoginThrottle) (common.LoginThrottler, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validating login throttle config")
+	}
+	db, err := util.NewDBConn(dbCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to database")
+	}
+	return &loginThrottler{
+		conn: db,
+		cfg:  cfg,
+	}, nil
+}
+
+type loginThrottler struct {
+	conn *gorm.DB
+	cfg  config.LoginThrottle
+}
+
+func userSubject(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+func ipSubject(ip string) string {
+	return truncate("ip:"+ip, maxSubjectLength)
+}
+
+// accountSubject returns the subject failed logins as login are counted
+// under. Failures for existing users are counted per user, whether they
+// log in with their username or their email address. Unknown logins are
+// counted as well, so lockouts do not reveal which accounts exist.
+func (l *loginThrottler) accountSubject(login string) (string, error) {
+	var user models.Users
+	q := l.conn.Select("id").Where("username = ? or email = ?", login, login).First(&user)
+	if q.Error == nil {
+		return userSubject(user.ID), nil
+	}
+	if !errors.Is(q.Error, gorm.ErrRecordNotFound) {
+		return "", errors.Wrap(q.Error, "fetching user")
+	}
+	return truncate("login:"+strings.ToLower(login), maxSubjectLength), nil
+}
+
+// delay returns how long clients have to wait after the last of failures
+// failed logins before trying again.
+func (l *loginThrottler) delay(failures int) time.Duration {
+	if failures < l.cfg.FreeAttempts {
+		return 0
+	}
+	delay, maxDelay := l.cfg.BaseDelayDuration(), l.cfg.MaxDelayDuration()
+	for idx := l.cfg.FreeAttempts; idx < failures; idx++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return delay
+}
+
+func (l *loginThrottler) Check(ctx context.Context, login, ip string) error {
+	if l.cfg.Disable {
+		return nil
+	}
+	account, err := l.accountSubject(login)
+	if err != nil {
+		return err
+	}
+	var attempts []models.LoginAttempt
+	q := l.conn.Where("subject in ?", []string{account, ipSubject(ip)}).Find(&attempts)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "fetching login attempts")
+	}
+
+	now := time.Now().UTC()
+	var wait time.Duration
+	for _, attempt := range attempts {
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+			remaining := attempt.LockedUntil.Sub(now)
+			if attempt.Subject == account {
+				err := gErrors.NewTooManyRequestsError(remaining, "account is locked after too many failed logins")
+				return gErrors.WithCode(err, gErrors.CodeAccountLocked)
+			}
+			if remaining > wait {
+				wait = remaining
+			}
+			continue
+		}
+		// Addresses are only locked out, without backoff, so failed
+		// logins of a few users behind the same NAT do not slow down
+		// everyone else.
+		if attempt.Subject != account || now.Sub(attempt.LastFailureAt) >= l.cfg.ResetAfterDuration() {
+			continue
+		}
+		if remaining := attempt.LastFailureAt.Add(l.delay(attempt.Failures)).Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	if wait > 0 {
+		err := gErrors.NewTooManyRequestsError(wait, "too many failed logins, try again in %s", wait.Round(time.Second))
+		return gErrors.WithCode(err, gErrors.CodeLoginThrottled)
+	}
+	return nil
+}
+
+// recordFailure counts a failed login for subject, and locks the subject
+// once threshold failures were counted.
+func (l *loginThrottler) recordFailure(subject string, threshold int) error {
+	now := time.Now().UTC()
+	return l.conn.Transaction(func(tx *gorm.DB) error {
+		q := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginAttempt{
+			Subject:       subject,
+			LastFailureAt: now,
+		})
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "creating login attempt")
+		}
+		// Count the failure with a single statement, so failures recorded
+		// concurrently by other instances are not lost. Failures older
+		// than the reset interval start a new count.
+		q = tx.Exec(
+			"UPDATE login_attempts SET failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END, last_failure_at = ? WHERE subject = ?",
+			now.Add(-l.cfg.ResetAfterDuration()), now, subject)
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "counting failed login")
+		}
+
+		var attempt models.LoginAttempt
+		if err := tx.Where("subject = ?", subject).First(&attempt).Error; err != nil {
+			return errors.Wrap(err, "fetching login attempt")
+		}
+		if attempt.Failures < threshold {
+			return nil
+		}
+		// Start counting again once the lockout ends, so clients get a
+		// few attempts before being locked out again.
+		q = tx.Model(&attempt).Updates(map[string]interface{}{
+			"failures":     0,
+			"locked_until": now.Add(l.cfg.LockoutPeriod()),
+		})
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "locking login")
+		}
+		return nil
+	})
+}
+
+func (l *loginThrottler) Failed(ctx context.Context, login, ip string) error {
+	if l.cfg.Disable {
+		return nil
+	}
+	account, err := l.accountSubject(login)
+	if err != nil {
+		return err
+	}
+	if err := l.recordFailure(account, l.cfg.LockoutThreshold); err != nil {
+		return errors.Wrap(err, "recording failed login for account")
+	}
+	if err := l.recordFailure(ipSubject(ip), l.cfg.IPLockoutThreshold); err != nil {
+		return errors.Wrap(err, "recording failed login for address")
+	}
+	return nil
+}
+
+func (l *loginThrottler) CheckAddress(ctx context.Context, ip string) error {
+	if l.cfg.Disable {
+		return nil
+	}
+	var attempt models.LoginAttempt
+	q := l.conn.Where("subject = ?", ipSubject(ip)).First(&attempt)
+	if q.Error != nil {
+		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return errors.Wrap(q.Error, "fetching login attempts")
+	}
+	now := time.Now().UTC()
+	if attempt.LockedUntil == nil || !attempt.LockedUntil.After(now) {
+		return nil
+	}
+	wait := attempt.LockedUntil.Sub(now)
+	err := gErrors.NewTooManyRequestsError(wait, "too many failed attempts, try again in %s", wait.Round(time.Second))
+	return gErrors.WithCode(err, gErrors.CodeLoginThrottled)
+}
+
+func (l *loginThrottler) FailedFromAddress(ctx context.Context, ip string) error {
+	if l.cfg.Disable {
+		return nil
+	}
+	if err := l.recordFailure(ipSubject(ip), l.cfg.IPLockoutThreshold); err != nil {
+		return errors.Wrap(err, "recording failure for address")
+	}
+	return nil
+}
+
+func (l *loginThrottler) Succeeded(ctx context.Context, login string) error {
+	if l.cfg.Disable {
+		return nil
+	}
+	account, err := l.accountSubject(login)
+	if err != nil {
+		return err
+	}
+	q := l.conn.Where("subject = ? and (locked_until is null or locked_until < ?)", account, time.Now().UTC()).Delete(&models.LoginAttempt{})
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "deleting login attempts")
+	}
+	return nil
+}
+
+func (l *loginThrottler) Unlock(ctx context.Context, userID uint) error {
+	if !auth.IsAdmin(ctx) {
+		return gErrors.ErrUnauthorized
+	}
+	q := l.conn.Where("subject = ?", userSubject(userID)).Delete(&models.LoginAttempt{})
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "deleting login attempts")
+	}
+	return nil
+}
+
+func (l *loginThrottler) CleanLoginAttempts() error {
+	now := time.Now().UTC()
+	q := l.conn.Where(
+		"last_failure_at < ? and (locked_until is null or locked_until < ?)",
+		now.Add(-l.cfg.ResetAfterDuration()), now).Delete(&models.LoginAttempt{})
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "pruning login attempts")
+	}
+	return nil
+}
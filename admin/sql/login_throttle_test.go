@@ -0,0 +1,246 @@
+package sql_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	adminCommon "gopherbin/admin/common"
+	adminSQL "gopherbin/admin/sql"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/util"
+)
+
+const (
+	testIP      = "192.0.2.1"
+	otherTestIP = "198.51.100.7"
+)
+
+func newLoginThrottler(t *testing.T, f tokenFixture, cfg config.LoginThrottle) adminCommon.LoginThrottler {
+	t.Helper()
+	throttler, err := adminSQL.NewLoginThrottler(f.dbCfg, cfg)
+	if err != nil {
+		t.Fatalf("NewLoginThrottler: %v", err)
+	}
+	return throttler
+}
+
+func failLogins(t *testing.T, throttler adminCommon.LoginThrottler, login, ip string, count int) {
+	t.Helper()
+	for idx := 0; idx < count; idx++ {
+		if err := throttler.Failed(context.Background(), login, ip); err != nil {
+			t.Fatalf("Failed: %v", err)
+		}
+	}
+}
+
+// checkThrottled asserts that err is a TooManyRequestsError with the given
+// code, asking the client to wait for about retryAfter.
+func checkThrottled(t *testing.T, err error, code string, retryAfter time.Duration) {
+	t.Helper()
+	var tooMany *gErrors.TooManyRequestsError
+	if !errors.As(err, &tooMany) {
+		t.Fatalf("expected TooManyRequestsError, got %v", err)
+	}
+	if got := gErrors.Code(err); got != code {
+		t.Errorf("want code %q, got %q", code, got)
+	}
+	if got := tooMany.RetryAfter(); got > retryAfter || got < retryAfter-time.Minute {
+		t.Errorf("want retry after about %s, got %s", retryAfter, got)
+	}
+}
+
+// ── Backoff ──────────────────────────────────────────────────────────────────
+
+func TestLoginThrottle_Backoff(t *testing.T) {
+	f := newTokenFixture(t)
+	throttler := newLoginThrottler(t, f, config.LoginThrottle{
+		FreeAttempts: 2,
+		BaseDelay:    "1h",
+		MaxDelay:     "3h",
+	})
+	ctx := context.Background()
+
+	failLogins(t, throttler, "ci", testIP, 2)
+	checkThrottled(t, throttler.Check(ctx, "ci", testIP), gErrors.CodeLoginThrottled, time.Hour)
+	// Failures are counted per account, whichever login or address
+	// is used.
+	checkThrottled(t, throttler.Check(ctx, "ci@example.com", otherTestIP), gErrors.CodeLoginThrottled, time.Hour)
+
+	failLogins(t, throttler, "ci@example.com", testIP, 1)
+	checkThrottled(t, throttler.Check(ctx, "ci", testIP), gErrors.CodeLoginThrottled, 2*time.Hour)
+	failLogins(t, throttler, "ci", testIP, 2)
+	checkThrottled(t, throttler.Check(ctx, "ci", testIP), gErrors.CodeLoginThrottled, 3*time.Hour)
+
+	// Other accounts are not affected.
+	if err := throttler.Check(ctx, "superadmin", testIP); err != nil {
+		t.Errorf("expected other account to be allowed, got %v", err)
+	}
+}
+
+func TestLoginThrottle_SucceededResets(t *testing.T) {
+	f := newTokenFixture(t)
+	throttler := newLoginThrottler(t, f, config.LoginThrottle{FreeAttempts: 1, BaseDelay: "1h", MaxDelay: "1h"})
+	ctx := context.Background()
+
+	failLogins(t, throttler, "ci", testIP, 1)
+	if err := throttler.Check(ctx, "ci", testIP); err == nil {
+		t.Fatal("expected login to be throttled")
+	}
+	if err := throttler.Succeeded(ctx, "ci@example.com"); err != nil {
+		t.Fatalf("Succeeded: %v", err)
+	}
+	if err := throttler.Check(ctx, "ci", testIP); err != nil {
+		t.Errorf("expected login to be allowed after a successful login, got %v", err)
+	}
+}
+
+// ── Lockout ──────────────────────────────────────────────────────────────────
+
+func TestLoginThrottle_Lockout(t *testing.T) {
+	f := newTokenFixture(t)
+	throttler := newLoginThrottler(t, f, config.LoginThrottle{
+		FreeAttempts:     100,
+		LockoutThreshold: 3,
+		LockoutDuration:  "1h",
+	})
+	ctx := context.Background()
+
+	failLogins(t, throttler, "ci", testIP, 2)
+	if err := throttler.Check(ctx, "ci", testIP); err != nil {
+		t.Fatalf("expected login to be allowed below the threshold, got %v", err)
+	}
+	failLogins(t, throttler, "ci", testIP, 1)
+	checkThrottled(t, throttler.Check(ctx, "ci", otherTestIP), gErrors.CodeAccountLocked, time.Hour)
+
+	// A lockout is not lifted by a successful login racing with it.
+	if err := throttler.Succeeded(ctx, "ci"); err != nil {
+		t.Fatalf("Succeeded: %v", err)
+	}
+	checkThrottled(t, throttler.Check(ctx, "ci", testIP), gErrors.CodeAccountLocked, time.Hour)
+
+	if err := throttler.Unlock(f.userCtx, f.user.ID); !isUnauthorized(err) {
+		t.Errorf("expected non-admins to be refused, got %v", err)
+	}
+	if err := throttler.Unlock(f.superCtx, f.user.ID); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	if err := throttler.Check(ctx, "ci", testIP); err != nil {
+		t.Errorf("expected unlocked account to be allowed, got %v", err)
+	}
+}
+
+func TestLoginThrottle_UnknownLogins(t *testing.T) {
+	f := newTokenFixture(t)
+	throttler := newLoginThrottler(t, f, config.LoginThrottle{LockoutThreshold: 2, LockoutDuration: "1h"})
+	ctx := context.Background()
+
+	// Unknown logins are locked like existing accounts, so lockouts do
+	// not reveal which accounts exist.
+	failLogins(t, throttler, "nobody", testIP, 2)
+	checkThrottled(t, throttler.Check(ctx, "Nobody", testIP), gErrors.CodeAccountLocked, time.Hour)
+}
+
+func TestLoginThrottle_IPLockout(t *testing.T) {
+	f := newTokenFixture(t)
+	throttler := newLoginThrottler(t, f, config.LoginThrottle{
+		FreeAttempts:       100,
+		LockoutThreshold:   100,
+		IPLockoutThreshold: 3,
+		LockoutDuration:    "1h",
+	})
+	ctx := context.Background()
+
+	failLogins(t, throttler, "alice", testIP, 1)
+	failLogins(t, throttler, "bob", testIP, 1)
+	if err := throttler.Check(ctx, "ci", testIP); err != nil {
+		t.Fatalf("expected login to be allowed below the threshold, got %v", err)
+	}
+	failLogins(t, throttler, "carol", testIP, 1)
+	checkThrottled(t, throttler.Check(ctx, "ci", testIP), gErrors.CodeLoginThrottled, time.Hour)
+	if err := throttler.Check(ctx, "ci", otherTestIP); err != nil {
+		t.Errorf("expected other addresses to be allowed, got %v", err)
+	}
+}
+
+func TestLoginThrottle_AddressOnly(t *testing.T) {
+	f := newTokenFixture(t)
+	throttler := newLoginThrottler(t, f, config.LoginThrottle{
+		FreeAttempts:       1,
+		LockoutThreshold:   1,
+		IPLockoutThreshold: 2,
+		LockoutDuration:    "1h",
+	})
+	ctx := context.Background()
+
+	for idx := 0; idx < 2; idx++ {
+		if err := throttler.CheckAddress(ctx, testIP); err != nil {
+			t.Fatalf("expected address to be allowed below the threshold, got %v", err)
+		}
+		if err := throttler.FailedFromAddress(ctx, testIP); err != nil {
+			t.Fatalf("FailedFromAddress: %v", err)
+		}
+	}
+	checkThrottled(t, throttler.CheckAddress(ctx, testIP), gErrors.CodeLoginThrottled, time.Hour)
+	checkThrottled(t, throttler.Check(ctx, "ci", testIP), gErrors.CodeLoginThrottled, time.Hour)
+	if err := throttler.CheckAddress(ctx, otherTestIP); err != nil {
+		t.Errorf("expected other addresses to be allowed, got %v", err)
+	}
+	// No account was charged for the failures.
+	if err := throttler.Check(ctx, "ci", otherTestIP); err != nil {
+		t.Errorf("expected account to be allowed, got %v", err)
+	}
+}
+
+// ── Reset / Clean ────────────────────────────────────────────────────────────
+
+func TestLoginThrottle_ResetAfter(t *testing.T) {
+	f := newTokenFixture(t)
+	throttler := newLoginThrottler(t, f, config.LoginThrottle{
+		FreeAttempts:     1,
+		BaseDelay:        "1ms",
+		LockoutThreshold: 2,
+		ResetAfter:       "50ms",
+	})
+	ctx := context.Background()
+
+	failLogins(t, throttler, "ci", testIP, 1)
+	time.Sleep(100 * time.Millisecond)
+	if err := throttler.Check(ctx, "ci", testIP); err != nil {
+		t.Fatalf("expected old failures to be forgotten, got %v", err)
+	}
+	// The old failure does not count towards the lockout.
+	failLogins(t, throttler, "ci", testIP, 1)
+	time.Sleep(10 * time.Millisecond)
+	if err := throttler.Check(ctx, "ci", testIP); err != nil {
+		t.Errorf("expected account not to be locked, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if err := throttler.CleanLoginAttempts(); err != nil {
+		t.Fatalf("CleanLoginAttempts: %v", err)
+	}
+	db, err := util.NewDBConn(f.dbCfg)
+	if err != nil {
+		t.Fatalf("NewDBConn: %v", err)
+	}
+	var count int64
+	if err := db.Model(&models.LoginAttempt{}).Count(&count).Error; err != nil {
+		t.Fatalf("counting login attempts: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("want old login attempts removed, %d left", count)
+	}
+}
+
+func TestLoginThrottle_Disabled(t *testing.T) {
+	f := newTokenFixture(t)
+	throttler := newLoginThrottler(t, f, config.LoginThrottle{Disable: true, LockoutThreshold: 1})
+	failLogins(t, throttler, "ci", testIP, 5)
+	if err := throttler.Check(context.Background(), "ci", testIP); err != nil {
+		t.Errorf("expected disabled throttle to allow logins, got %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gopherbin/admin/common"
+	"gopherbin/audit"
+	"gopherbin/auth"
+	"gopherbin/auth/passwords"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/params"
+	"gopherbin/util"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// inviteCodeLength is the number of random characters in an invite
+	// code.
+	inviteCodeLength = 24
+	// invitePrefixLength is the number of characters of the code we
+	// store in clear text, so admins can tell invites apart.
+	invitePrefixLength = 6
+)
+
+var (
+	errRegistrationDisabled = gErrors.WithCode(gErrors.NewNotFoundError("registration is not enabled"), gErrors.CodeRegistrationDisabled)
+	errInviteRequired       = gErrors.NewValidationError(gErrors.FieldError{
+		Field:   "invite_code",
+		Code:    gErrors.CodeRequired,
+		Message: "an invite code is required",
+	})
+	errInvalidInvite = gErrors.NewValidationError(gErrors.FieldError{
+		Field:   "invite_code",
+		Code:    gErrors.CodeInvalidInvite,
+		Message: "invalid or expired invite code",
+	})
+	errNotPending = gErrors.WithCode(gErrors.NewConflictError("user is not awaiting approval"), gErrors.CodeNotPending)
+	// errAccountExists does not say whether the username or the email
+	// address is taken, so registering can not be used to find out
+	// which addresses have an account.
+	errAccountExists = gErrors.WithCode(gErrors.NewDuplicateUserError("username or email address already in use"), gErrors.CodeAccountExists)
+)
+
+// NewRegistrationManager returns a new RegistrationManager. Passwords of
+// registering users must follow policyCfg. Registrations, approvals and
+// changes to invites are recorded through recorder, which may be nil.
+func NewRegistrationManager(dbCfg config.Database, cfg config.Registration, policyCfg config.PasswordPolicy, recorder audit.Recorder) (common.RegistrationManager, error) {
+	db, err := util.NewDBConn(dbCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to database")
+	}
+	policy, err := passwords.NewPolicy(policyCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading password policy")
+	}
+	if recorder == nil {
+		recorder = audit.Discard
+	}
+	return &registrationManager{
+		conn:  db,
+		users: &userManager{conn: db, policy: policy, recorder: recorder},
+		cfg:   cfg,
+	}, nil
+}
+
+type registrationManager struct {
+	conn  *gorm.DB
+	users *userManager
+	cfg   config.Registration
+}
+
+func (r *registrationManager) sqlToParams(invite models.Invite) params.Invite {
+	return params.Invite{
+		ID:        invite.ID,
+		Prefix:    invite.Prefix,
+		Note:      invite.Note,
+		CreatedBy: invite.CreatedByID,
+		MaxUses:   invite.MaxUses,
+		Uses:      invite.Uses,
+		Team:      invite.Team.Name,
+		CreatedAt: invite.CreatedAt,
+		ExpiresAt: invite.ExpiresAt,
+	}
+}
+
+func (r *registrationManager) Register(ctx context.Context, registration params.RegisterParams) (params.Users, error) {
+	if !r.cfg.Enabled() {
+		return params.Users{}, errRegistrationDisabled
+	}
+	if err := registration.Validate(); err != nil {
+		return params.Users{}, errors.Wrap(err, "validating parameters")
+	}
+	code := strings.TrimSpace(registration.InviteCode)
+	if code == "" && r.cfg.Mode != config.RegistrationOpen {
+		return params.Users{}, errInviteRequired
+	}
+
+	if _, err := r.users.getUserByEmail(registration.Email); err == nil {
+		return params.Users{}, errAccountExists
+	} else if !errors.Is(err, gErrors.ErrNotFound) {
+		return params.Users{}, errors.Wrap(err, "fetching user")
+	}
+	if _, err := r.users.getUserByUsername(registration.Username); err == nil {
+		return params.Users{}, errAccountExists
+	} else if !errors.Is(err, gErrors.ErrNotFound) {
+		return params.Users{}, errors.Wrap(err, "fetching user")
+	}
+
+	// Users with an invite are trusted by the admin that issued it.
+	// Everyone else waits for an admin to approve their account.
+	newUser, err := r.users.newUserParamsToSQL(params.NewUserParams{
+		Email:    registration.Email,
+		Username: registration.Username,
+		FullName: registration.FullName,
+		Password: registration.Password,
+		Enabled:  code != "",
+	})
+	if err != nil {
+		return params.Users{}, errors.Wrap(err, "fetching user object")
+	}
+	newUser.PendingApproval = code == ""
+
+	err = r.conn.Transaction(func(tx *gorm.DB) error {
+		var invite models.Invite
+		if code != "" {
+			var err error
+			if invite, err = r.useInvite(tx, code); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&newUser).Error; err != nil {
+			return errors.Wrap(err, "creating new user")
+		}
+		if invite.TeamID != nil {
+			team := models.Teams{ID: *invite.TeamID}
+			if err := tx.Model(&team).Association("Members").Append(&newUser); err != nil {
+				return errors.Wrap(err, "adding user to team")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return params.Users{}, err
+	}
+	details := map[string]string{
+		"username": newUser.Username,
+		"email":    newUser.Email,
+	}
+	if code != "" {
+		details["invite"] = code[:min(len(code), invitePrefixLength)]
+	}
+	r.users.record(ctx, audit.ActionUserRegister, newUser.ID, details)
+	return r.users.sqlUserToParams(newUser), nil
+}
+
+// useInvite counts a use of the invite with the given code. The count is
+// checked and updated in a single statement, so concurrent registrations
+// can not use an invite more often than allowed.
+func (r *registrationManager) useInvite(tx *gorm.DB, code string) (models.Invite, error) {
+	var invite models.Invite
+	q := tx.Where("code_hash = ?", hashAPIToken(code)).First(&invite)
+	if q.Error != nil {
+		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+			return models.Invite{}, errInvalidInvite
+		}
+		return models.Invite{}, errors.Wrap(q.Error, "fetching invite")
+	}
+	if invite.ExpiresAt != nil && invite.ExpiresAt.Before(time.Now()) {
+		return models.Invite{}, errInvalidInvite
+	}
+	q = tx.Model(&models.Invite{}).
+		Where("id = ? and uses < max_uses", invite.ID).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if q.Error != nil {
+		return models.Invite{}, errors.Wrap(q.Error, "updating invite")
+	}
+	if q.RowsAffected == 0 {
+		return models.Invite{}, errInvalidInvite
+	}
+	return invite, nil
+}
+
+func (r *registrationManager) CreateInvite(ctx context.Context, invite params.NewInviteParams) (params.Invite, error) {
+	if !auth.IsAdmin(ctx) {
+		return params.Invite{}, gErrors.ErrUnauthorized
+	}
+	if err := invite.Validate(); err != nil {
+		return params.Invite{}, errors.Wrap(err, "validating invite")
+	}
+
+	var team models.Teams
+	if invite.Team != "" {
+		q := r.conn.Where("name = ?", invite.Team).First(&team)
+		if q.Error != nil {
+			if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+				return params.Invite{}, gErrors.ErrTeamNotFound
+			}
+			return params.Invite{}, errors.Wrap(q.Error, "fetching team")
+		}
+	}
+
+	code, err := util.GetRandomString(inviteCodeLength)
+	if err != nil {
+		return params.Invite{}, errors.Wrap(err, "generating invite code")
+	}
+	var expires *time.Time
+	if invite.ExpiresAt != nil {
+		tm := invite.ExpiresAt.UTC()
+		expires = &tm
+	}
+	maxUses := invite.MaxUses
+	if maxUses == 0 {
+		maxUses = 1
+	}
+	newInvite := models.Invite{
+		Prefix:      code[:invitePrefixLength],
+		CodeHash:    hashAPIToken(code),
+		CreatedByID: auth.UserID(ctx),
+		Note:        strings.TrimSpace(invite.Note),
+		MaxUses:     maxUses,
+		ExpiresAt:   expires,
+	}
+	if team.ID != 0 {
+		newInvite.TeamID = &team.ID
+	}
+	if err := r.conn.Omit("Team", "CreatedBy").Create(&newInvite).Error; err != nil {
+		return params.Invite{}, errors.Wrap(err, "creating invite")
+	}
+	newInvite.Team = team
+	r.users.recorder.Record(ctx, params.AuditEvent{
+		Action:     audit.ActionInviteCreate,
+		TargetType: audit.TargetInvite,
+		TargetID:   fmt.Sprintf("%d", newInvite.ID),
+		Details: map[string]string{
+			"prefix":   newInvite.Prefix,
+			"max_uses": fmt.Sprintf("%d", newInvite.MaxUses),
+			"team":     team.Name,
+		},
+	})
+	ret := r.sqlToParams(newInvite)
+	ret.Code = code
+	return ret, nil
+}
+
+func (r *registrationManager) ListInvites(ctx context.Context) ([]params.Invite, error) {
+	if !auth.IsAdmin(ctx) {
+		return nil, gErrors.ErrUnauthorized
+	}
+	var invites []models.Invite
+	q := r.conn.Preload("Team").Order("id desc").Find(&invites)
+	if q.Error != nil {
+		return nil, errors.Wrap(q.Error, "fetching invites")
+	}
+	ret := make([]params.Invite, len(invites))
+	for idx, val := range invites {
+		ret[idx] = r.sqlToParams(val)
+	}
+	return ret, nil
+}
+
+func (r *registrationManager) DeleteInvite(ctx context.Context, inviteID uint) error {
+	if !auth.IsAdmin(ctx) {
+		return gErrors.ErrUnauthorized
+	}
+	q := r.conn.Where("id = ?", inviteID).Delete(&models.Invite{})
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "deleting invite")
+	}
+	if q.RowsAffected == 0 {
+		return gErrors.ErrInviteNotFound
+	}
+	r.users.recorder.Record(ctx, params.AuditEvent{
+		Action:     audit.ActionInviteDelete,
+		TargetType: audit.TargetInvite,
+		TargetID:   fmt.Sprintf("%d", inviteID),
+	})
+	return nil
+}
+
+func (r *registrationManager) ListPending(ctx context.Context, page int64, results int64) (params.UserListResult, error) {
+	if !auth.IsAdmin(ctx) {
+		return params.UserListResult{}, gErrors.ErrUnauthorized
+	}
+	if page == 0 {
+		page = 1
+	}
+	if results == 0 {
+		results = 1
+	}
+
+	var cnt int64
+	pending := r.conn.Model(&models.Users{}).Where("pending_approval = ?", true)
+	if err := pending.Count(&cnt).Error; err != nil {
+		return params.UserListResult{}, errors.Wrap(err, "counting results")
+	}
+	var userResults []models.Users
+	q := r.conn.Where("pending_approval = ?", true).
+		Order("id").
+		Offset(int((page - 1) * results)).
+		Limit(int(results)).
+		Find(&userResults)
+	if q.Error != nil {
+		return params.UserListResult{}, errors.Wrap(q.Error, "fetching users from database")
+	}
+	asParams := make([]params.Users, len(userResults))
+	for idx, val := range userResults {
+		asParams[idx] = r.users.sqlUserToParams(val)
+	}
+	totalPages := int64(math.Ceil(float64(cnt) / float64(results)))
+	if totalPages == 0 {
+		totalPages = 1
+	}
+	return params.UserListResult{
+		TotalPages: totalPages,
+		Users:      asParams,
+	}, nil
+}
+
+func (r *registrationManager) getPendingUser(ctx context.Context, userID uint) (models.Users, error) {
+	if !auth.IsAdmin(ctx) {
+		return models.Users{}, gErrors.ErrUnauthorized
+	}
+	usr, err := r.users.getUser(userID)
+	if err != nil {
+		return models.Users{}, errors.Wrap(err, "fetching user from db")
+	}
+	if !usr.PendingApproval {
+		return models.Users{}, errNotPending
+	}
+	return usr, nil
+}
+
+func (r *registrationManager) Approve(ctx context.Context, userID uint) (params.Users, error) {
+	usr, err := r.getPendingUser(ctx, userID)
+	if err != nil {
+		return params.Users{}, err
+	}
+	usr.Enabled = true
+	usr.PendingApproval = false
+	usr.UpdatedAt = time.Now()
+	if err := r.conn.Save(&usr).Error; err != nil {
+		return params.Users{}, errors.Wrap(err, "saving user to database")
+	}
+	r.users.record(ctx, audit.ActionUserApprove, usr.ID, nil)
+	return r.users.sqlUserToParams(usr), nil
+}
+
+func (r *registrationManager) Reject(ctx context.Context, userID uint) error {
+	usr, err := r.getPendingUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := r.conn.Delete(&usr).Error; err != nil {
+		return errors.Wrap(err, "deleting user")
+	}
+	r.users.record(ctx, audit.ActionUserReject, usr.ID, map[string]string{
+		"username": usr.Username,
+		"email":    usr.Email,
+	})
+	return nil
+}
@@ -0,0 +1,305 @@
+package sql_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	adminCommon "gopherbin/admin/common"
+	adminSQL "gopherbin/admin/sql"
+	"gopherbin/auth"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/params"
+	pasteSQL "gopherbin/paste/sql"
+)
+
+type registrationFixture struct {
+	tokenFixture
+	registrations adminCommon.RegistrationManager
+}
+
+func newRegistrationFixture(t *testing.T, mode config.RegistrationMode) registrationFixture {
+	t.Helper()
+	f := newTokenFixture(t)
+	registrations, err := adminSQL.NewRegistrationManager(f.dbCfg, config.Registration{Mode: mode}, config.PasswordPolicy{}, nil)
+	if err != nil {
+		t.Fatalf("NewRegistrationManager: %v", err)
+	}
+	return registrationFixture{tokenFixture: f, registrations: registrations}
+}
+
+func (f registrationFixture) invite(t *testing.T, invite params.NewInviteParams) string {
+	t.Helper()
+	created, err := f.registrations.CreateInvite(f.superCtx, invite)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	return created.Code
+}
+
+func registration(username, code string) params.RegisterParams {
+	return params.RegisterParams{
+		Email:      username + "@example.com",
+		Username:   username,
+		FullName:   "New User",
+		Password:   testPassword,
+		InviteCode: code,
+	}
+}
+
+func (f registrationFixture) login(username string) error {
+	_, err := f.users.Authenticate(context.Background(), params.PasswordLoginParams{
+		Username: username,
+		Password: testPassword,
+	})
+	return err
+}
+
+// ── Register ─────────────────────────────────────────────────────────────────
+
+func TestRegister_Disabled(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationDisabled)
+	_, err := f.registrations.Register(context.Background(), registration("jane", ""))
+	if gErrors.Code(err) != gErrors.CodeRegistrationDisabled {
+		t.Fatalf("want %s, got %v", gErrors.CodeRegistrationDisabled, err)
+	}
+}
+
+func TestRegister_InviteRequired(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationInvite)
+	if _, err := f.registrations.Register(context.Background(), registration("jane", "")); err == nil {
+		t.Fatal("expected registering without an invite to fail")
+	}
+	if _, err := f.registrations.Register(context.Background(), registration("jane", "not-a-code")); gErrors.Code(err) != gErrors.CodeValidationFailed {
+		t.Fatalf("want %s for unknown invite, got %v", gErrors.CodeValidationFailed, err)
+	}
+
+	code := f.invite(t, params.NewInviteParams{})
+	user, err := f.registrations.Register(context.Background(), registration("jane", code))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if !user.Enabled || user.PendingApproval || user.IsAdmin {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if err := f.login("jane"); err != nil {
+		t.Errorf("login: %v", err)
+	}
+}
+
+func TestRegister_InviteUses(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationInvite)
+	single := f.invite(t, params.NewInviteParams{})
+	if _, err := f.registrations.Register(context.Background(), registration("jane", single)); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if _, err := f.registrations.Register(context.Background(), registration("john", single)); err == nil {
+		t.Error("expected single use invite to be rejected the second time")
+	}
+
+	// Concurrent registrations can not use an invite more often than
+	// allowed.
+	limited := f.invite(t, params.NewInviteParams{MaxUses: 2})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	registered := 0
+	for _, username := range []string{"alice", "bob", "carol", "dave"} {
+		wg.Add(1)
+		go func(username string) {
+			defer wg.Done()
+			if _, err := f.registrations.Register(context.Background(), registration(username, limited)); err == nil {
+				mu.Lock()
+				registered++
+				mu.Unlock()
+			}
+		}(username)
+	}
+	wg.Wait()
+	if registered != 2 {
+		t.Errorf("want 2 registrations, got %d", registered)
+	}
+
+	invites, err := f.registrations.ListInvites(f.superCtx)
+	if err != nil {
+		t.Fatalf("ListInvites: %v", err)
+	}
+	if len(invites) != 2 || invites[0].Uses != 2 || invites[0].MaxUses != 2 {
+		t.Errorf("unexpected invites %+v", invites)
+	}
+}
+
+func TestRegister_ExpiredInvite(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationInvite)
+	expires := time.Now().Add(50 * time.Millisecond)
+	code := f.invite(t, params.NewInviteParams{ExpiresAt: &expires})
+	time.Sleep(100 * time.Millisecond)
+	if _, err := f.registrations.Register(context.Background(), registration("jane", code)); err == nil {
+		t.Fatal("expected expired invite to be rejected")
+	}
+}
+
+func TestRegister_InviteTeam(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationInvite)
+	teams, err := pasteSQL.NewTeamManager(f.dbCfg, nil)
+	if err != nil {
+		t.Fatalf("NewTeamManager: %v", err)
+	}
+	if _, err := teams.Create(f.superCtx, "devs"); err != nil {
+		t.Fatalf("creating team: %v", err)
+	}
+	if _, err := f.registrations.CreateInvite(f.superCtx, params.NewInviteParams{Team: "missing"}); gErrors.Code(err) != gErrors.CodeTeamNotFound {
+		t.Fatalf("want %s, got %v", gErrors.CodeTeamNotFound, err)
+	}
+	code := f.invite(t, params.NewInviteParams{Team: "devs"})
+	if _, err := f.registrations.Register(context.Background(), registration("jane", code)); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if !isTeamMember(t, teams, f.superCtx, "devs", "jane") {
+		t.Error("expected user to be added to the team of the invite")
+	}
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationOpen)
+	taken := registration("ci", "")
+	taken.Email = "someone@example.com"
+	_, usernameErr := f.registrations.Register(context.Background(), taken)
+	if gErrors.Code(usernameErr) != gErrors.CodeAccountExists {
+		t.Errorf("username taken: want %s, got %v", gErrors.CodeAccountExists, usernameErr)
+	}
+	taken = registration("someone", "")
+	taken.Email = "ci@example.com"
+	_, emailErr := f.registrations.Register(context.Background(), taken)
+	if gErrors.Code(emailErr) != gErrors.CodeAccountExists {
+		t.Errorf("email taken: want %s, got %v", gErrors.CodeAccountExists, emailErr)
+	}
+	// Both look the same, so registering does not reveal which email
+	// addresses have an account.
+	if usernameErr == nil || emailErr == nil || usernameErr.Error() != emailErr.Error() {
+		t.Errorf("want the same error for both, got %v and %v", usernameErr, emailErr)
+	}
+}
+
+// ── Approval ─────────────────────────────────────────────────────────────────
+
+func TestRegister_OpenRequiresApproval(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationOpen)
+	user, err := f.registrations.Register(context.Background(), registration("jane", ""))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if user.Enabled || !user.PendingApproval {
+		t.Fatalf("want pending user, got %+v", user)
+	}
+	if err := f.login("jane"); gErrors.Code(err) != gErrors.CodeApprovalPending {
+		t.Fatalf("want %s, got %v", gErrors.CodeApprovalPending, err)
+	}
+
+	// Users with an invite skip the approval.
+	code := f.invite(t, params.NewInviteParams{})
+	if invited, err := f.registrations.Register(context.Background(), registration("john", code)); err != nil || !invited.Enabled {
+		t.Fatalf("Register with invite: %+v, %v", invited, err)
+	}
+
+	pending, err := f.registrations.ListPending(f.superCtx, 1, 10)
+	if err != nil {
+		t.Fatalf("ListPending: %v", err)
+	}
+	if len(pending.Users) != 1 || pending.Users[0].ID != user.ID {
+		t.Fatalf("unexpected pending users %+v", pending.Users)
+	}
+
+	approved, err := f.registrations.Approve(f.superCtx, user.ID)
+	if err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if !approved.Enabled || approved.PendingApproval {
+		t.Errorf("unexpected approved user %+v", approved)
+	}
+	if err := f.login("jane"); err != nil {
+		t.Errorf("login after approval: %v", err)
+	}
+	if _, err := f.registrations.Approve(f.superCtx, user.ID); gErrors.Code(err) != gErrors.CodeNotPending {
+		t.Errorf("want %s approving twice, got %v", gErrors.CodeNotPending, err)
+	}
+}
+
+func TestReject(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationOpen)
+	user, err := f.registrations.Register(context.Background(), registration("jane", ""))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := f.registrations.Reject(f.superCtx, f.user.ID); gErrors.Code(err) != gErrors.CodeNotPending {
+		t.Errorf("want %s rejecting an active user, got %v", gErrors.CodeNotPending, err)
+	}
+	if err := f.registrations.Reject(f.superCtx, user.ID); err != nil {
+		t.Fatalf("Reject: %v", err)
+	}
+	if _, err := f.users.Get(f.superCtx, user.ID); !isNotFound(err) {
+		t.Errorf("expected rejected user to be deleted, got %v", err)
+	}
+}
+
+func TestEnablePendingUserApproves(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationOpen)
+	user, err := f.registrations.Register(context.Background(), registration("jane", ""))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := f.users.Enable(f.superCtx, user.ID); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	pending, err := f.registrations.ListPending(f.superCtx, 1, 10)
+	if err != nil {
+		t.Fatalf("ListPending: %v", err)
+	}
+	if len(pending.Users) != 0 {
+		t.Errorf("expected no pending users, got %+v", pending.Users)
+	}
+}
+
+// ── Admin only ───────────────────────────────────────────────────────────────
+
+func TestRegistration_AdminOnly(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationOpen)
+	user, err := f.registrations.Register(context.Background(), registration("jane", ""))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	unauthorized := func(name string, err error) {
+		t.Helper()
+		if gErrors.Code(err) != gErrors.CodeUnauthorized {
+			t.Errorf("%s: want %s, got %v", name, gErrors.CodeUnauthorized, err)
+		}
+	}
+	_, err = f.registrations.CreateInvite(f.userCtx, params.NewInviteParams{})
+	unauthorized("CreateInvite", err)
+	_, err = f.registrations.ListInvites(f.userCtx)
+	unauthorized("ListInvites", err)
+	unauthorized("DeleteInvite", f.registrations.DeleteInvite(f.userCtx, 1))
+	_, err = f.registrations.ListPending(f.userCtx, 1, 10)
+	unauthorized("ListPending", err)
+	_, err = f.registrations.Approve(f.userCtx, user.ID)
+	unauthorized("Approve", err)
+	unauthorized("Reject", f.registrations.Reject(auth.PopulateContext(context.Background(), user), user.ID))
+}
+
+func TestDeleteInvite(t *testing.T) {
+	f := newRegistrationFixture(t, config.RegistrationInvite)
+	created, err := f.registrations.CreateInvite(f.superCtx, params.NewInviteParams{Note: "for jane"})
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	if err := f.registrations.DeleteInvite(f.superCtx, created.ID); err != nil {
+		t.Fatalf("DeleteInvite: %v", err)
+	}
+	if err := f.registrations.DeleteInvite(f.superCtx, created.ID); gErrors.Code(err) != gErrors.CodeInviteNotFound {
+		t.Errorf("want %s, got %v", gErrors.CodeInviteNotFound, err)
+	}
+	if _, err := f.registrations.Register(context.Background(), registration("jane", created.Code)); err == nil {
+		t.Error("expected deleted invite to be rejected")
+	}
+}
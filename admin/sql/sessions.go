@@ -0,0 +1,223 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package sql
+
+import (
+	"context"
+	"time"
+
+	"gopherbin/admin/common"
+	"gopherbin/auth"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/params"
+	"gopherbin/util"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxIPLength is the length of the longest textual IPv6 address.
+	maxIPLength        = 45
+	maxUserAgentLength = 255
+)
+
+// NewSessionManager returns a new SessionManager
+func NewSessionManager(dbCfg config.Database) (common.SessionManager, error) {
+	db, err := util.NewDBConn(dbCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to database")
+	}
+	return &sessionManager{
+		conn: db,
+	}, nil
+}
+
+type sessionManager struct {
+	conn *gorm.DB
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length]
+}
+
+func (s *sessionManager) sqlToParams(ctx context.Context, session models.Session) params.Session {
+	return params.Session{
+		ID:         session.ID,
+		UserID:     session.UserID,
+		CreatedAt:  session.CreatedAt,
+		ExpiresAt:  session.ExpiresAt,
+		LastSeenAt: session.LastSeenAt,
+		IP:         session.IP,
+		UserAgent:  session.UserAgent,
+		Current:    auth.AuthMethod(ctx) == auth.AuthMethodJWT && auth.JWTClaim(ctx).TokenID == session.ID,
+	}
+}
+
+func (s *sessionManager) Create(ctx context.Context, session common.NewSession) error {
+	userID := auth.UserID(ctx)
+	if userID == 0 {
+		return gErrors.ErrUnauthorized
+	}
+	if session.ID == "" {
+		return gErrors.NewBadRequestError("missing session ID")
+	}
+	now := time.Now().UTC()
+	newSession := models.Session{
+		ID:         session.ID,
+		CreatedAt:  now,
+		UserID:     userID,
+		ExpiresAt:  session.ExpiresAt.UTC(),
+		LastSeenAt: now,
+		IP:         truncate(session.IP, maxIPLength),
+		UserAgent:  truncate(session.UserAgent, maxUserAgentLength),
+	}
+	return s.conn.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newSession).Error; err != nil {
+			return errors.Wrap(err, "creating session")
+		}
+		q := tx.Model(&models.Users{}).Where("id = ?", userID).UpdateColumn("last_login_at", now)
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "updating last login timestamp")
+		}
+		return nil
+	})
+}
+
+func (s *sessionManager) Touch(ctx context.Context, sessionID string) error {
+	userID := auth.UserID(ctx)
+	if userID == 0 || sessionID == "" {
+		return gErrors.ErrUnauthorized
+	}
+	var session models.Session
+	q := s.conn.Where("id = ? and user_id = ?", sessionID, userID).First(&session)
+	if q.Error != nil {
+		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+			return gErrors.ErrUnauthorized
+		}
+		return errors.Wrap(q.Error, "fetching session")
+	}
+	now := time.Now().UTC()
+	if !session.ExpiresAt.After(now) {
+		return gErrors.ErrUnauthorized
+	}
+	if now.Sub(session.LastSeenAt) >= lastUsedResolution {
+		q = s.conn.Model(&session).Update("last_seen_at", now)
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "updating last seen timestamp")
+		}
+	}
+	return nil
+}
+
+// checkAccess returns an error unless the user in ctx may manage the
+// sessions of userID. Users manage their own sessions, admins those of
+// regular users, and only a superuser those of other admins.
+func (s *sessionManager) checkAccess(ctx context.Context, userID uint) error {
+	if userID == auth.UserID(ctx) {
+		return nil
+	}
+	if !auth.IsAdmin(ctx) {
+		return gErrors.ErrUnauthorized
+	}
+	var user models.Users
+	if err := s.conn.Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return gErrors.ErrUserNotFound
+		}
+		return errors.Wrap(err, "fetching user")
+	}
+	if (user.IsAdmin || user.IsSuperUser) && !auth.IsSuperUser(ctx) {
+		return gErrors.NewUnauthorizedError("only a superuser may manage the sessions of an admin")
+	}
+	return nil
+}
+
+func (s *sessionManager) List(ctx context.Context, userID uint) ([]params.Session, error) {
+	if err := s.checkAccess(ctx, userID); err != nil {
+		return nil, err
+	}
+	var sessions []models.Session
+	q := s.conn.Where("user_id = ? and expires_at > ?", userID, time.Now().UTC()).Order("created_at desc").Find(&sessions)
+	if q.Error != nil {
+		return nil, errors.Wrap(q.Error, "fetching sessions")
+	}
+	ret := make([]params.Session, len(sessions))
+	for idx, val := range sessions {
+		ret[idx] = s.sqlToParams(ctx, val)
+	}
+	return ret, nil
+}
+
+func (s *sessionManager) Revoke(ctx context.Context, userID uint, sessionID string) error {
+	if err := s.checkAccess(ctx, userID); err != nil {
+		return err
+	}
+	q := s.conn.Where("id = ? and user_id = ?", sessionID, userID).Delete(&models.Session{})
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "deleting session")
+	}
+	if q.RowsAffected == 0 {
+		return gErrors.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *sessionManager) RevokeAll(ctx context.Context, userID uint) (int64, error) {
+	if err := s.checkAccess(ctx, userID); err != nil {
+		return 0, err
+	}
+	stamp, err := auth.NewSecurityStamp()
+	if err != nil {
+		return 0, errors.Wrap(err, "generating security stamp")
+	}
+	var revoked int64
+	err = s.conn.Transaction(func(tx *gorm.DB) error {
+		q := tx.Where("user_id = ?", userID).Delete(&models.Session{})
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "deleting sessions")
+		}
+		revoked = q.RowsAffected
+		// Rotating the security stamp also invalidates pre-auth tokens
+		// of users that have not entered their two-factor code yet.
+		// Tokens issued before security stamps were introduced are
+		// invalidated by the new update time.
+		q = tx.Model(&models.Users{}).Where("id = ?", userID).UpdateColumns(map[string]interface{}{
+			"security_stamp": stamp,
+			"updated_at":     time.Now(),
+		})
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "rotating security stamp")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+func (s *sessionManager) CleanSessions() error {
+	err := s.conn.Where("expires_at < ?", time.Now().UTC()).Delete(&models.Session{}).Error
+	if err != nil {
+		return errors.Wrap(err, "pruning sessions")
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+package sql_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	adminCommon "gopherbin/admin/common"
+	adminSQL "gopherbin/admin/sql"
+	"gopherbin/auth"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/params"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+type sessionFixture struct {
+	tokenFixture
+	sessions adminCommon.SessionManager
+}
+
+func newSessionFixture(t *testing.T) sessionFixture {
+	t.Helper()
+	f := newTokenFixture(t)
+	sessions, err := adminSQL.NewSessionManager(f.dbCfg)
+	if err != nil {
+		t.Fatalf("NewSessionManager: %v", err)
+	}
+	return sessionFixture{tokenFixture: f, sessions: sessions}
+}
+
+func (f sessionFixture) create(t *testing.T, ctx context.Context, id string, ttl time.Duration) {
+	t.Helper()
+	err := f.sessions.Create(ctx, adminCommon.NewSession{
+		ID:        id,
+		ExpiresAt: time.Now().Add(ttl),
+		IP:        "192.0.2.1",
+		UserAgent: "gopherbin-cli",
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+}
+
+// currentSession returns ctx as the JWT middleware would set it up for a
+// request made with the token of sessionID.
+func currentSession(ctx context.Context, sessionID string) context.Context {
+	ctx = auth.SetAuthMethod(ctx, auth.AuthMethodJWT)
+	return auth.SetJWTClaim(ctx, auth.JWTClaims{TokenID: sessionID})
+}
+
+// ── Create / Touch ───────────────────────────────────────────────────────────
+
+func TestSessionTouch(t *testing.T) {
+	f := newSessionFixture(t)
+	f.create(t, f.userCtx, "session-1", time.Hour)
+	f.create(t, f.userCtx, "session-2", -time.Minute)
+
+	if err := f.sessions.Touch(f.userCtx, "session-1"); err != nil {
+		t.Fatalf("Touch: %v", err)
+	}
+	if err := f.sessions.Touch(f.userCtx, "session-2"); err == nil {
+		t.Error("expected expired session to be rejected")
+	}
+	if err := f.sessions.Touch(f.userCtx, "missing"); err == nil {
+		t.Error("expected unknown session to be rejected")
+	}
+	// Sessions are bound to the user they were created for.
+	if err := f.sessions.Touch(f.superCtx, "session-1"); err == nil {
+		t.Error("expected session of another user to be rejected")
+	}
+}
+
+func TestSessionCreate_Anonymous(t *testing.T) {
+	f := newSessionFixture(t)
+	err := f.sessions.Create(context.Background(), adminCommon.NewSession{ID: "anon", ExpiresAt: time.Now().Add(time.Hour)})
+	if err == nil {
+		t.Fatal("expected error creating a session without a user")
+	}
+}
+
+// ── List ─────────────────────────────────────────────────────────────────────
+
+func TestSessionList(t *testing.T) {
+	f := newSessionFixture(t)
+	f.create(t, f.userCtx, "session-1", time.Hour)
+	f.create(t, f.userCtx, "session-2", time.Hour)
+	f.create(t, f.userCtx, "expired", -time.Minute)
+	f.create(t, f.superCtx, "other", time.Hour)
+
+	list, err := f.sessions.List(currentSession(f.userCtx, "session-2"), f.user.ID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("want 2 active sessions, got %+v", list)
+	}
+	for _, session := range list {
+		if session.UserID != f.user.ID || session.IP != "192.0.2.1" || session.UserAgent != "gopherbin-cli" {
+			t.Errorf("unexpected session %+v", session)
+		}
+		if session.Current != (session.ID == "session-2") {
+			t.Errorf("session %s: want current=%v", session.ID, session.ID == "session-2")
+		}
+	}
+}
+
+func TestSessionList_OtherUsers(t *testing.T) {
+	f := newSessionFixture(t)
+	f.create(t, f.userCtx, "session-1", time.Hour)
+
+	if _, err := f.sessions.List(f.userCtx, auth.UserID(f.superCtx)); err == nil {
+		t.Error("expected error listing the sessions of another user")
+	}
+	list, err := f.sessions.List(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("List as admin: %v", err)
+	}
+	if len(list) != 1 || list[0].Current {
+		t.Errorf("want 1 session, not current, got %+v", list)
+	}
+}
+
+func TestSessions_AdminsOnlyBySuperUser(t *testing.T) {
+	f := newSessionFixture(t)
+	admin, err := f.users.Create(f.superCtx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+		Enabled:  true,
+		IsAdmin:  true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	adminCtx := auth.SetScopes(auth.PopulateContext(context.Background(), admin), auth.AllScopes())
+	superID := auth.UserID(f.superCtx)
+	f.create(t, f.superCtx, "super", time.Hour)
+	f.create(t, adminCtx, "admin", time.Hour)
+	f.create(t, f.userCtx, "user", time.Hour)
+
+	if _, err := f.sessions.List(adminCtx, superID); !isUnauthorized(err) {
+		t.Errorf("admin listing the superuser sessions: expected unauthorized, got %v", err)
+	}
+	if err := f.sessions.Revoke(adminCtx, superID, "super"); !isUnauthorized(err) {
+		t.Errorf("admin revoking a superuser session: expected unauthorized, got %v", err)
+	}
+	if _, err := f.sessions.RevokeAll(adminCtx, superID); !isUnauthorized(err) {
+		t.Errorf("admin revoking all superuser sessions: expected unauthorized, got %v", err)
+	}
+	super, err := f.users.Get(f.superCtx, superID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if super.SecurityStamp != auth.SecurityStamp(f.superCtx) {
+		t.Error("security stamp of the superuser was rotated")
+	}
+	if err := f.sessions.Touch(f.superCtx, "super"); err != nil {
+		t.Errorf("superuser session should still be valid: %v", err)
+	}
+
+	// Admins still manage their own sessions and those of regular users.
+	if _, err := f.sessions.List(adminCtx, admin.ID); err != nil {
+		t.Errorf("admin listing own sessions: %v", err)
+	}
+	if err := f.sessions.Revoke(adminCtx, f.user.ID, "user"); err != nil {
+		t.Errorf("admin revoking a user session: %v", err)
+	}
+	if _, err := f.sessions.RevokeAll(f.superCtx, admin.ID); err != nil {
+		t.Errorf("superuser revoking admin sessions: %v", err)
+	}
+}
+
+// ── Revoke ───────────────────────────────────────────────────────────────────
+
+func TestSessionRevoke(t *testing.T) {
+	f := newSessionFixture(t)
+	f.create(t, f.userCtx, "session-1", time.Hour)
+	f.create(t, f.userCtx, "session-2", time.Hour)
+
+	if err := f.sessions.Revoke(f.userCtx, f.user.ID, "session-1"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if err := f.sessions.Touch(f.userCtx, "session-1"); err == nil {
+		t.Error("expected revoked session to be rejected")
+	}
+	if err := f.sessions.Touch(f.userCtx, "session-2"); err != nil {
+		t.Errorf("other session should still be valid: %v", err)
+	}
+	if err := f.sessions.Revoke(f.userCtx, f.user.ID, "session-1"); gErrors.Code(err) != gErrors.CodeSessionNotFound {
+		t.Errorf("want %s, got %v", gErrors.CodeSessionNotFound, err)
+	}
+	// Admins may revoke the sessions of other users, users may not.
+	if err := f.sessions.Revoke(f.userCtx, auth.UserID(f.superCtx), "session-2"); err == nil {
+		t.Error("expected error revoking the session of another user")
+	}
+	if err := f.sessions.Revoke(f.superCtx, f.user.ID, "session-2"); err != nil {
+		t.Fatalf("Revoke as admin: %v", err)
+	}
+}
+
+func TestSessionRevokeAll(t *testing.T) {
+	f := newSessionFixture(t)
+	f.create(t, f.userCtx, "session-1", time.Hour)
+	f.create(t, f.userCtx, "session-2", time.Hour)
+	f.create(t, f.superCtx, "other", time.Hour)
+
+	if _, err := f.sessions.RevokeAll(f.userCtx, auth.UserID(f.superCtx)); err == nil {
+		t.Error("expected error revoking the sessions of another user")
+	}
+	revoked, err := f.sessions.RevokeAll(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("RevokeAll: %v", err)
+	}
+	if revoked != 2 {
+		t.Errorf("want 2 sessions revoked, got %d", revoked)
+	}
+	// Pre-auth tokens are invalidated by rotating the security stamp.
+	user, err := f.users.Get(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if user.SecurityStamp == f.user.SecurityStamp {
+		t.Error("expected RevokeAll to rotate the security stamp")
+	}
+	if err := f.sessions.Touch(f.userCtx, "session-1"); err == nil {
+		t.Error("expected revoked session to be rejected")
+	}
+	if err := f.sessions.Touch(f.superCtx, "other"); err != nil {
+		t.Errorf("sessions of other users should not be revoked: %v", err)
+	}
+}
+
+// ── JWT middleware ───────────────────────────────────────────────────────────
+
+// serveWithToken runs the JWT middleware, backed by the SQL managers, for
+// a request authenticated with token, and returns the response status.
+func (f sessionFixture) serveWithToken(t *testing.T, keys *auth.KeyRing, token string) int {
+	t.Helper()
+	mw, err := auth.NewjwtMiddleware(f.users, f.sessions, keys)
+	if err != nil {
+		t.Fatalf("NewjwtMiddleware: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestJWTMiddleware_SQLSessions(t *testing.T) {
+	f := newSessionFixture(t)
+	store, err := adminSQL.NewSigningKeyManager(f.dbCfg)
+	if err != nil {
+		t.Fatalf("NewSigningKeyManager: %v", err)
+	}
+	jwtCfg := config.JWTAuth{Secret: "test-jwt-secret"}
+	if err := jwtCfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	keys, err := auth.NewKeyRing(store, jwtCfg)
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	user, err := f.users.Get(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	expires := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	sign := func(tokenID string) string {
+		t.Helper()
+		signed, err := keys.Sign(auth.JWTClaims{
+			UserID:           user.ID,
+			TokenID:          tokenID,
+			SecurityStamp:    user.SecurityStamp,
+			RegisteredClaims: jwt.RegisteredClaims{Issuer: auth.LoginTokenIssuer, ExpiresAt: expires},
+		})
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return signed
+	}
+	// legacy returns a token as issued before sessions were introduced.
+	legacy := func(expiresAt *jwt.NumericDate) string {
+		t.Helper()
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.JWTClaims{
+			UserID:           user.ID,
+			TokenID:          "legacy",
+			UpdatedAt:        user.UpdatedAt.String(),
+			RegisteredClaims: jwt.RegisteredClaims{Issuer: auth.LoginTokenIssuer, ExpiresAt: expiresAt},
+		}).SignedString([]byte(jwtCfg.Secret))
+		if err != nil {
+			t.Fatalf("sign legacy token: %v", err)
+		}
+		return signed
+	}
+
+	f.create(t, f.userCtx, "session-1", time.Hour)
+	if code := f.serveWithToken(t, keys, sign("session-1")); code != http.StatusOK {
+		t.Errorf("token with a session: want 200, got %d", code)
+	}
+	if code := f.serveWithToken(t, keys, sign("no-session")); code != http.StatusUnauthorized {
+		t.Errorf("token without a session: want 401, got %d", code)
+	}
+	if err := f.sessions.Revoke(f.userCtx, f.user.ID, "session-1"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if code := f.serveWithToken(t, keys, sign("session-1")); code != http.StatusUnauthorized {
+		t.Errorf("revoked session: want 401, got %d", code)
+	}
+
+	// Tokens issued before sessions were introduced are accepted until
+	// they expire.
+	if code := f.serveWithToken(t, keys, legacy(expires)); code != http.StatusOK {
+		t.Errorf("legacy token: want 200, got %d", code)
+	}
+	if code := f.serveWithToken(t, keys, legacy(jwt.NewNumericDate(time.Now().Add(-time.Minute)))); code != http.StatusUnauthorized {
+		t.Errorf("expired legacy token: want 401, got %d", code)
+	}
+	if code := f.serveWithToken(t, keys, legacy(nil)); code != http.StatusUnauthorized {
+		t.Errorf("legacy token without expiry: want 401, got %d", code)
+	}
+}
+
+func TestJWTMiddleware_SQLLegacyTokens(t *testing.T) {
+	// Tokens issued before security stamps were introduced are checked
+	// against the update time of the user instead.
+	f := newSessionFixture(t)
+	store, err := adminSQL.NewSigningKeyManager(f.dbCfg)
+	if err != nil {
+		t.Fatalf("NewSigningKeyManager: %v", err)
+	}
+	jwtCfg := config.JWTAuth{Secret: "test-jwt-secret"}
+	if err := jwtCfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	keys, err := auth.NewKeyRing(store, jwtCfg)
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	legacy := func(updatedAt string) string {
+		t.Helper()
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.JWTClaims{
+			UserID:    f.user.ID,
+			TokenID:   "legacy",
+			UpdatedAt: updatedAt,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    auth.LoginTokenIssuer,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}).SignedString([]byte(jwtCfg.Secret))
+		if err != nil {
+			t.Fatalf("sign legacy token: %v", err)
+		}
+		return signed
+	}
+	user, err := f.users.Get(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	token := legacy(user.UpdatedAt.String())
+
+	if code := f.serveWithToken(t, keys, token); code != http.StatusOK {
+		t.Errorf("legacy token: want 200, got %d", code)
+	}
+	if code := f.serveWithToken(t, keys, legacy(user.UpdatedAt.Add(-time.Hour).String())); code != http.StatusUnauthorized {
+		t.Errorf("stale legacy token: want 401, got %d", code)
+	}
+	// Logging out everywhere also logs out legacy tokens.
+	if _, err := f.sessions.RevokeAll(f.userCtx, f.user.ID); err != nil {
+		t.Fatalf("RevokeAll: %v", err)
+	}
+	if code := f.serveWithToken(t, keys, token); code != http.StatusUnauthorized {
+		t.Errorf("legacy token after RevokeAll: want 401, got %d", code)
+	}
+}
+
+// ── CleanSessions ────────────────────────────────────────────────────────────
+
+func TestCleanSessions(t *testing.T) {
+	f := newSessionFixture(t)
+	f.create(t, f.userCtx, "active", time.Hour)
+	f.create(t, f.userCtx, "expired", -time.Minute)
+
+	if err := f.sessions.CleanSessions(); err != nil {
+		t.Fatalf("CleanSessions: %v", err)
+	}
+	list, err := f.sessions.List(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != "active" {
+		t.Errorf("want only the active session, got %+v", list)
+	}
+	// The expired session is gone, so a new session may reuse its ID.
+	f.create(t, f.userCtx, "expired", time.Hour)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package sql
+
+import (
+	"time"
+
+	"gopherbin/admin/common"
+	"gopherbin/config"
+	"gopherbin/models"
+	"gopherbin/util"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// NewSigningKeyManager returns a new SigningKeyManager
+func NewSigningKeyManager(dbCfg config.Database) (common.SigningKeyManager, error) {
+	db, err := util.NewDBConn(dbCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to database")
+	}
+	return &signingKeyManager{
+		conn: db,
+	}, nil
+}
+
+type signingKeyManager struct {
+	conn *gorm.DB
+}
+
+func (s *signingKeyManager) List() ([]common.SigningKey, error) {
+	var keys []models.SigningKey
+	if err := s.conn.Order("created_at desc").Find(&keys).Error; err != nil {
+		return nil, errors.Wrap(err, "fetching signing keys")
+	}
+	ret := make([]common.SigningKey, len(keys))
+	for idx, key := range keys {
+		ret[idx] = common.SigningKey{
+			ID:         key.ID,
+			Algorithm:  key.Algorithm,
+			PrivateKey: key.PrivateKey,
+			CreatedAt:  key.CreatedAt,
+		}
+	}
+	return ret, nil
+}
+
+func (s *signingKeyManager) Create(key common.SigningKey) error {
+	if key.ID == "" || len(key.PrivateKey) == 0 {
+		return errors.New("missing signing key ID or private key")
+	}
+	newKey := models.SigningKey{
+		ID:         key.ID,
+		CreatedAt:  key.CreatedAt,
+		Algorithm:  key.Algorithm,
+		PrivateKey: key.PrivateKey,
+	}
+	if err := s.conn.Create(&newKey).Error; err != nil {
+		return errors.Wrap(err, "creating signing key")
+	}
+	return nil
+}
+
+func (s *signingKeyManager) Prune(before time.Time) error {
+	// The newest key created before the cutoff may still have signed
+	// tokens that have not expired. Only the keys it replaced are old
+	// enough to delete.
+	var keys []models.SigningKey
+	q := s.conn.Select("id", "created_at").Where("created_at < ?", before).Order("created_at desc").Limit(1).Find(&keys)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "fetching signing keys")
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	err := s.conn.Where("created_at < ?", keys[0].CreatedAt).Delete(&models.SigningKey{}).Error
+	if err != nil {
+		return errors.Wrap(err, "pruning signing keys")
+	}
+	return nil
+}
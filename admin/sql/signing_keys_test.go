@@ -0,0 +1,110 @@
+package sql_test
+
+import (
+	"testing"
+	"time"
+
+	adminCommon "gopherbin/admin/common"
+	adminSQL "gopherbin/admin/sql"
+)
+
+func newSigningKeyManager(t *testing.T, f tokenFixture) adminCommon.SigningKeyManager {
+	t.Helper()
+	keys, err := adminSQL.NewSigningKeyManager(f.dbCfg)
+	if err != nil {
+		t.Fatalf("NewSigningKeyManager: %v", err)
+	}
+	return keys
+}
+
+func signingKeyIDs(t *testing.T, keys adminCommon.SigningKeyManager) []string {
+	t.Helper()
+	list, err := keys.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ids := make([]string, len(list))
+	for idx, key := range list {
+		ids[idx] = key.ID
+	}
+	return ids
+}
+
+// ── Signing keys ─────────────────────────────────────────────────────────────
+
+func TestSigningKeys_CreateAndList(t *testing.T) {
+	f := newTokenFixture(t)
+	keys := newSigningKeyManager(t, f)
+	now := time.Now().UTC()
+
+	for id, age := range map[string]time.Duration{
+		"old":    2 * time.Hour,
+		"new":    0,
+		"middle": time.Hour,
+	} {
+		err := keys.Create(adminCommon.SigningKey{
+			ID:         id,
+			Algorithm:  "EdDSA",
+			PrivateKey: []byte("key-" + id),
+			CreatedAt:  now.Add(-age),
+		})
+		if err != nil {
+			t.Fatalf("Create %s: %v", id, err)
+		}
+	}
+	list, err := keys.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 3 || list[0].ID != "new" || list[1].ID != "middle" || list[2].ID != "old" {
+		t.Fatalf("want keys newest first, got %v", signingKeyIDs(t, keys))
+	}
+	if list[0].Algorithm != "EdDSA" || string(list[0].PrivateKey) != "key-new" {
+		t.Errorf("key not stored as created: %+v", list[0])
+	}
+
+	if err := keys.Create(adminCommon.SigningKey{ID: "empty"}); err == nil {
+		t.Error("expected error creating a key without a private key")
+	}
+}
+
+func TestSigningKeys_Prune(t *testing.T) {
+	f := newTokenFixture(t)
+	keys := newSigningKeyManager(t, f)
+	now := time.Now().UTC()
+
+	for id, age := range map[string]time.Duration{
+		"oldest":  72 * time.Hour,
+		"older":   48 * time.Hour,
+		"retired": 36 * time.Hour,
+		"active":  time.Hour,
+	} {
+		err := keys.Create(adminCommon.SigningKey{
+			ID:         id,
+			Algorithm:  "HS256",
+			PrivateKey: []byte(id),
+			CreatedAt:  now.Add(-age),
+		})
+		if err != nil {
+			t.Fatalf("Create %s: %v", id, err)
+		}
+	}
+
+	// Nothing was replaced before the cutoff.
+	if err := keys.Prune(now.Add(-100 * time.Hour)); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if ids := signingKeyIDs(t, keys); len(ids) != 4 {
+		t.Fatalf("want all 4 keys kept, got %v", ids)
+	}
+
+	// "retired" was replaced after the cutoff, so tokens it signed may
+	// still be valid. The keys before it are deleted.
+	if err := keys.Prune(now.Add(-24 * time.Hour)); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	ids := signingKeyIDs(t, keys)
+	if len(ids) != 2 || ids[0] != "active" || ids[1] != "retired" {
+		t.Errorf("want [active retired], got %v", ids)
+	}
+}
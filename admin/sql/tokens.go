@@ -0,0 +1,208 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package sql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"gopherbin/admin/common"
+	"gopherbin/auth"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/params"
+	"gopherbin/util"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiTokenLength is the number of random characters following the
+	// token prefix.
+	apiTokenLength = 40
+	// displayPrefixLength is the number of characters of the token we
+	// store in clear text, so users can tell their tokens apart.
+	displayPrefixLength = len(auth.APITokenPrefix) + 8
+	// lastUsedResolution limits how often the last used timestamp of
+	// a token is written to the database.
+	lastUsedResolution = time.Minute
+)
+
+// NewAPITokenManager returns a new APITokenManager
+func NewAPITokenManager(dbCfg config.Database) (common.APITokenManager, error) {
+	db, err := util.NewDBConn(dbCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to database")
+	}
+	return &apiTokenManager{
+		conn: db,
+	}, nil
+}
+
+type apiTokenManager struct {
+	conn *gorm.DB
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenScopes returns the scopes of token. Tokens created before scopes
+// were introduced have none stored, and get all scopes.
+func tokenScopes(token models.APIToken) []string {
+	scopes := strings.Fields(token.Scopes)
+	if len(scopes) == 0 {
+		return auth.AllScopes()
+	}
+	return scopes
+}
+
+func (a *apiTokenManager) sqlToParams(token models.APIToken) params.APIToken {
+	return params.APIToken{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		UserID:     token.UserID,
+		Scopes:     tokenScopes(token),
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+	}
+}
+
+func (a *apiTokenManager) Create(ctx context.Context, token params.NewAPITokenParams) (params.APIToken, error) {
+	userID := auth.UserID(ctx)
+	if userID == 0 {
+		return params.APIToken{}, gErrors.ErrUnauthorized
+	}
+	if !auth.HasScope(ctx, auth.ScopeTokens) {
+		return params.APIToken{}, auth.MissingScopeError(auth.ScopeTokens)
+	}
+	if err := token.Validate(); err != nil {
+		return params.APIToken{}, errors.Wrap(err, "validating token")
+	}
+	scopes, err := auth.ResolveScopes(ctx, token.Scopes)
+	if err != nil {
+		return params.APIToken{}, errors.Wrap(err, "validating scopes")
+	}
+
+	secret, err := util.GetRandomString(apiTokenLength)
+	if err != nil {
+		return params.APIToken{}, errors.Wrap(err, "generating token")
+	}
+	secret = auth.APITokenPrefix + secret
+
+	var expires *time.Time
+	if token.ExpiresAt != nil {
+		tm := token.ExpiresAt.UTC()
+		expires = &tm
+	}
+	newToken := models.APIToken{
+		Name:      strings.TrimSpace(token.Name),
+		Prefix:    secret[:displayPrefixLength],
+		TokenHash: hashAPIToken(secret),
+		UserID:    userID,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expires,
+	}
+	if err := a.conn.Create(&newToken).Error; err != nil {
+		return params.APIToken{}, errors.Wrap(err, "creating token")
+	}
+	ret := a.sqlToParams(newToken)
+	ret.Token = secret
+	return ret, nil
+}
+
+func (a *apiTokenManager) List(ctx context.Context, userID uint) ([]params.APIToken, error) {
+	if userID != auth.UserID(ctx) && !auth.IsAdmin(ctx) {
+		return nil, gErrors.ErrUnauthorized
+	}
+	var tokens []models.APIToken
+	q := a.conn.Where("user_id = ?", userID).Order("id desc").Find(&tokens)
+	if q.Error != nil {
+		return nil, errors.Wrap(q.Error, "fetching tokens")
+	}
+	ret := make([]params.APIToken, len(tokens))
+	for idx, val := range tokens {
+		ret[idx] = a.sqlToParams(val)
+	}
+	return ret, nil
+}
+
+func (a *apiTokenManager) Revoke(ctx context.Context, userID uint, tokenID uint) error {
+	if userID != auth.UserID(ctx) && !auth.IsAdmin(ctx) {
+		return gErrors.ErrUnauthorized
+	}
+	q := a.conn.Where("id = ? and user_id = ?", tokenID, userID).Delete(&models.APIToken{})
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "deleting token")
+	}
+	if q.RowsAffected == 0 {
+		return gErrors.ErrTokenNotFound
+	}
+	return nil
+}
+
+func (a *apiTokenManager) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	invalidToken := gErrors.WithCode(gErrors.NewUnauthorizedError("invalid API token"), gErrors.CodeInvalidToken)
+	if !strings.HasPrefix(token, auth.APITokenPrefix) {
+		return ctx, invalidToken
+	}
+
+	var tokenInfo models.APIToken
+	q := a.conn.Preload("User").Where("token_hash = ?", hashAPIToken(token)).First(&tokenInfo)
+	if q.Error != nil {
+		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+			return ctx, invalidToken
+		}
+		return ctx, errors.Wrap(q.Error, "fetching token")
+	}
+
+	now := time.Now().UTC()
+	if tokenInfo.ExpiresAt != nil && !tokenInfo.ExpiresAt.After(now) {
+		return ctx, gErrors.WithCode(gErrors.NewUnauthorizedError("API token has expired"), gErrors.CodeInvalidToken)
+	}
+	if !tokenInfo.User.Enabled {
+		return ctx, gErrors.WithCode(gErrors.NewUnauthorizedError("user is disabled"), gErrors.CodeUserDisabled)
+	}
+
+	if tokenInfo.LastUsedAt == nil || now.Sub(*tokenInfo.LastUsedAt) >= lastUsedResolution {
+		q = a.conn.Model(&tokenInfo).Update("last_used_at", now)
+		if q.Error != nil {
+			return ctx, errors.Wrap(q.Error, "updating last used timestamp")
+		}
+	}
+
+	user := tokenInfo.User
+	ctx = auth.SetScopes(ctx, tokenScopes(tokenInfo))
+	return auth.PopulateContext(ctx, params.Users{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		Username:      user.Username,
+		FullName:      user.FullName,
+		Enabled:       user.Enabled,
+		IsAdmin:       user.IsAdmin,
+		IsSuperUser:   user.IsSuperUser,
+		SecurityStamp: user.SecurityStamp,
+	}), nil
+}
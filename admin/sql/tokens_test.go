@@ -0,0 +1,276 @@
+package sql_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	adminCommon "gopherbin/admin/common"
+	adminSQL "gopherbin/admin/sql"
+	"gopherbin/auth"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/params"
+	pasteSQL "gopherbin/paste/sql"
+
+	pkgErrors "github.com/pkg/errors"
+)
+
+type tokenFixture struct {
+	dbCfg    config.Database
+	users    adminCommon.UserManager
+	tokens   adminCommon.APITokenManager
+	superCtx context.Context
+	userCtx  context.Context
+	user     params.Users
+}
+
+// newTokenFixture creates a fresh DB with a superuser and a regular user,
+// and returns contexts for both of them.
+func newTokenFixture(t *testing.T) tokenFixture {
+	t.Helper()
+	dbCfg := testDBConfig(t)
+	if _, err := pasteSQL.NewPaster(dbCfg, nil); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	users, err := adminSQL.NewUserManager(dbCfg, config.PasswordPolicy{}, nil)
+	if err != nil {
+		t.Fatalf("NewUserManager: %v", err)
+	}
+	tokens, err := adminSQL.NewAPITokenManager(dbCfg)
+	if err != nil {
+		t.Fatalf("NewAPITokenManager: %v", err)
+	}
+	super, err := users.CreateSuperUser(params.NewUserParams{
+		Email:    "super@example.com",
+		Username: "superadmin",
+		FullName: "Super Admin",
+		Password: testPassword,
+	})
+	if err != nil {
+		t.Fatalf("CreateSuperUser: %v", err)
+	}
+	// Contexts are given all scopes, as the auth middleware would for
+	// a user that logged in with a password.
+	superCtx := auth.SetScopes(auth.PopulateContext(context.Background(), super), auth.AllScopes())
+	user, err := users.Create(superCtx, params.NewUserParams{
+		Email:    "ci@example.com",
+		Username: "ci",
+		FullName: "CI Bot",
+		Password: testPassword,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return tokenFixture{
+		dbCfg:    dbCfg,
+		users:    users,
+		tokens:   tokens,
+		superCtx: superCtx,
+		userCtx:  auth.SetScopes(auth.PopulateContext(context.Background(), user), auth.AllScopes()),
+		user:     user,
+	}
+}
+
+func isNotFound(err error) bool {
+	_, ok := pkgErrors.Cause(err).(*gErrors.NotFoundError)
+	return ok
+}
+
+// ── Create ───────────────────────────────────────────────────────────────────
+
+func TestAPITokenCreate(t *testing.T) {
+	f := newTokenFixture(t)
+	token, err := f.tokens.Create(f.userCtx, params.NewAPITokenParams{Name: "ci"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !strings.HasPrefix(token.Token, auth.APITokenPrefix) {
+		t.Errorf("token %q does not start with %q", token.Token, auth.APITokenPrefix)
+	}
+	if !strings.HasPrefix(token.Token, token.Prefix) || len(token.Prefix) >= len(token.Token) {
+		t.Errorf("prefix %q is not a strict prefix of the token", token.Prefix)
+	}
+	if token.UserID != f.user.ID {
+		t.Errorf("want owner %d, got %d", f.user.ID, token.UserID)
+	}
+
+	list, err := f.tokens.List(f.userCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].Token != "" {
+		t.Fatalf("want 1 token without the secret, got %+v", list)
+	}
+}
+
+func TestAPITokenCreate_Validation(t *testing.T) {
+	f := newTokenFixture(t)
+	past := time.Now().Add(-time.Hour)
+	_, err := f.tokens.Create(f.userCtx, params.NewAPITokenParams{ExpiresAt: &past})
+	bad, ok := pkgErrors.Cause(err).(*gErrors.BadRequestError)
+	if !ok {
+		t.Fatalf("want BadRequestError, got %T: %v", err, err)
+	}
+	if len(bad.Fields()) != 2 {
+		t.Errorf("want 2 field errors, got %+v", bad.Fields())
+	}
+}
+
+func TestAPITokenCreate_Anonymous(t *testing.T) {
+	f := newTokenFixture(t)
+	_, err := f.tokens.Create(context.Background(), params.NewAPITokenParams{Name: "anon"})
+	if !isUnauthorized(err) {
+		t.Fatalf("want UnauthorizedError, got %v", err)
+	}
+}
+
+// ── Authenticate ─────────────────────────────────────────────────────────────
+
+func TestAPITokenAuthenticate(t *testing.T) {
+	f := newTokenFixture(t)
+	token, err := f.tokens.Create(f.userCtx, params.NewAPITokenParams{Name: "ci"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	ctx, err := f.tokens.Authenticate(context.Background(), token.Token)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if auth.UserID(ctx) != f.user.ID || !auth.IsEnabled(ctx) {
+		t.Errorf("context not populated with the token owner")
+	}
+
+	list, err := f.tokens.List(f.userCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if list[0].LastUsedAt == nil {
+		t.Error("expected last used timestamp to be set")
+	}
+}
+
+func TestAPITokenAuthenticate_Invalid(t *testing.T) {
+	f := newTokenFixture(t)
+	for _, token := range []string{"", "not-a-token", auth.APITokenPrefix + "unknown"} {
+		_, err := f.tokens.Authenticate(context.Background(), token)
+		if gErrors.Code(err) != gErrors.CodeInvalidToken {
+			t.Errorf("token %q: want %s, got %v", token, gErrors.CodeInvalidToken, err)
+		}
+	}
+}
+
+func TestAPITokenAuthenticate_Expired(t *testing.T) {
+	f := newTokenFixture(t)
+	expires := time.Now().Add(time.Second)
+	token, err := f.tokens.Create(f.userCtx, params.NewAPITokenParams{Name: "short", ExpiresAt: &expires})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	time.Sleep(time.Until(expires) + 10*time.Millisecond)
+	if _, err := f.tokens.Authenticate(context.Background(), token.Token); !isUnauthorized(err) {
+		t.Fatalf("want UnauthorizedError for expired token, got %v", err)
+	}
+}
+
+func TestAPITokenAuthenticate_DisabledUser(t *testing.T) {
+	f := newTokenFixture(t)
+	token, err := f.tokens.Create(f.userCtx, params.NewAPITokenParams{Name: "ci"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := f.users.Disable(f.superCtx, f.user.ID); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	_, err = f.tokens.Authenticate(context.Background(), token.Token)
+	if gErrors.Code(err) != gErrors.CodeUserDisabled {
+		t.Fatalf("want %s, got %v", gErrors.CodeUserDisabled, err)
+	}
+}
+
+// ── List and revoke ──────────────────────────────────────────────────────────
+
+func TestAPITokenListOtherUser(t *testing.T) {
+	f := newTokenFixture(t)
+	if _, err := f.tokens.Create(f.superCtx, params.NewAPITokenParams{Name: "admin"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	superID := auth.UserID(f.superCtx)
+	if _, err := f.tokens.List(f.userCtx, superID); !isUnauthorized(err) {
+		t.Fatalf("want UnauthorizedError listing another user's tokens, got %v", err)
+	}
+	if _, err := f.tokens.List(f.superCtx, f.user.ID); err != nil {
+		t.Fatalf("admin List: %v", err)
+	}
+}
+
+func TestAPITokenRevoke(t *testing.T) {
+	f := newTokenFixture(t)
+	token, err := f.tokens.Create(f.userCtx, params.NewAPITokenParams{Name: "ci"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	superID := auth.UserID(f.superCtx)
+	if err := f.tokens.Revoke(f.superCtx, superID, token.ID); !isNotFound(err) {
+		t.Fatalf("want NotFoundError revoking with the wrong owner, got %v", err)
+	}
+	if err := f.tokens.Revoke(f.userCtx, f.user.ID, token.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := f.tokens.Authenticate(context.Background(), token.Token); !isUnauthorized(err) {
+		t.Fatalf("want UnauthorizedError for revoked token, got %v", err)
+	}
+	if err := f.tokens.Revoke(f.userCtx, f.user.ID, token.ID); gErrors.Code(err) != gErrors.CodeTokenNotFound {
+		t.Fatalf("want %s, got %v", gErrors.CodeTokenNotFound, err)
+	}
+}
+
+func TestAPITokenRevokeByAdmin(t *testing.T) {
+	f := newTokenFixture(t)
+	token, err := f.tokens.Create(f.userCtx, params.NewAPITokenParams{Name: "ci"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := f.tokens.Revoke(f.superCtx, f.user.ID, token.ID); err != nil {
+		t.Fatalf("admin Revoke: %v", err)
+	}
+}
+
+// ── Scopes ───────────────────────────────────────────────────────────────────
+
+func TestAPITokenScopes(t *testing.T) {
+	f := newTokenFixture(t)
+	token, err := f.tokens.Create(f.userCtx, params.NewAPITokenParams{
+		Name:   "read-only",
+		Scopes: []string{auth.ScopePasteRead},
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	ctx, err := f.tokens.Authenticate(context.Background(), token.Token)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !auth.HasScope(ctx, auth.ScopePasteRead) || auth.HasScope(ctx, auth.ScopePasteWrite) {
+		t.Errorf("want only %s, got %v", auth.ScopePasteRead, auth.Scopes(ctx))
+	}
+
+	// A token may not be used to create a token with more scopes.
+	limitedCtx := auth.SetScopes(f.userCtx, []string{auth.ScopeTokens})
+	_, err = f.tokens.Create(limitedCtx, params.NewAPITokenParams{
+		Name:   "escalate",
+		Scopes: []string{auth.ScopePasteWrite},
+	})
+	if _, ok := pkgErrors.Cause(err).(*gErrors.ForbiddenError); !ok {
+		t.Fatalf("want ForbiddenError, got %T: %v", err, err)
+	}
+
+	// Tokens can only be created by tokens holding the tokens scope.
+	_, err = f.tokens.Create(ctx, params.NewAPITokenParams{Name: "nope"})
+	if gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Fatalf("want %s, got %v", gErrors.CodeInsufficientScope, err)
+	}
+}
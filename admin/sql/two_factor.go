@@ -0,0 +1,403 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package sql
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"gopherbin/admin/common"
+	"gopherbin/auth"
+	"gopherbin/auth/totp"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/models"
+	"gopherbin/params"
+	"gopherbin/util"
+
+	"github.com/pkg/errors"
+	qrcode "github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes generated at a time
+	recoveryCodeCount = 10
+	// recoveryCodeLength is the number of characters in a recovery code,
+	// not counting the separator.
+	recoveryCodeLength = 10
+	// qrCodeSize is the width and height, in pixels, of enrollment QR codes
+	qrCodeSize = 256
+)
+
+var (
+	errTwoFactorEnabled = gErrors.WithCode(
+		gErrors.NewConflictError("two-factor authentication is already enabled"),
+		gErrors.CodeTwoFactorEnabled)
+	errTwoFactorNotEnabled = gErrors.WithCode(
+		gErrors.NewConflictError("two-factor authentication is not enabled"),
+		gErrors.CodeTwoFactorNotEnabled)
+	errNoEnrollment = gErrors.WithCode(
+		gErrors.NewConflictError("no authenticator is being enrolled"),
+		gErrors.CodeTwoFactorNotEnabled)
+	// errWrongCode is returned when managing two-factor settings with a
+	// wrong code. Unlike ErrInvalidTwoFactorCode, it does not suggest
+	// the session itself is invalid.
+	errWrongCode = gErrors.NewValidationError(gErrors.FieldError{
+		Field:   "code",
+		Code:    gErrors.CodeInvalidTwoFactorCode,
+		Message: "invalid two-factor code",
+	})
+)
+
+// NewTwoFactorManager returns a new TwoFactorManager
+func NewTwoFactorManager(dbCfg config.Database, cfg config.TwoFactor) (common.TwoFactorManager, error) {
+	db, err := util.NewDBConn(dbCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to database")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validating two-factor config")
+	}
+	return &twoFactorManager{
+		conn: db,
+		cfg:  cfg,
+	}, nil
+}
+
+type twoFactorManager struct {
+	conn *gorm.DB
+	cfg  config.TwoFactor
+}
+
+// normalizeRecoveryCode strips the separator and whitespace from a
+// recovery code, so codes are accepted however they were copied.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+func hashRecoveryCode(code string) string {
+	return hashAPIToken(normalizeRecoveryCode(code))
+}
+
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRecoveryCode() (string, error) {
+	data := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(data); err != nil {
+		return "", errors.Wrap(err, "getting random data")
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(data))[:recoveryCodeLength]
+	return code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:], nil
+}
+
+// getTwoFactor returns the two-factor settings of userID, or nil if the
+// user never enrolled an authenticator.
+func getTwoFactor(tx *gorm.DB, userID uint) (*models.TwoFactor, error) {
+	var twoFactor models.TwoFactor
+	q := tx.Where("user_id = ?", userID).First(&twoFactor)
+	if q.Error != nil {
+		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(q.Error, "fetching two-factor settings")
+	}
+	return &twoFactor, nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of userID and stores
+// a new set.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) (params.RecoveryCodes, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return params.RecoveryCodes{}, errors.Wrap(err, "deleting recovery codes")
+	}
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, recoveryCodeCount)
+	for idx := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return params.RecoveryCodes{}, errors.Wrap(err, "generating recovery code")
+		}
+		codes[idx] = code
+		rows[idx] = models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(code),
+		}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return params.RecoveryCodes{}, errors.Wrap(err, "saving recovery codes")
+	}
+	return params.RecoveryCodes{Codes: codes}, nil
+}
+
+// useCode checks code against the settings of a user. TOTP codes may
+// only be used once, and recovery codes are deleted once used.
+func useCode(tx *gorm.DB, twoFactor models.TwoFactor, code string) error {
+	if isTOTPCode(code) {
+		counter, err := totp.Validate(twoFactor.Secret, code, time.Now(), twoFactor.LastCounter)
+		if err != nil {
+			if errors.Is(err, totp.ErrInvalidCode) {
+				return gErrors.ErrInvalidTwoFactorCode
+			}
+			return errors.Wrap(err, "validating code")
+		}
+		// Only record the counter if no concurrent request used the
+		// same, or a later, code.
+		q := tx.Model(&models.TwoFactor{}).
+			Where("user_id = ? and last_counter < ?", twoFactor.UserID, counter).
+			UpdateColumn("last_counter", counter)
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "updating two-factor settings")
+		}
+		if q.RowsAffected == 0 {
+			return gErrors.ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	q := tx.Where("user_id = ? and code_hash = ?", twoFactor.UserID, hashRecoveryCode(code)).
+		Delete(&models.RecoveryCode{})
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "using recovery code")
+	}
+	if q.RowsAffected == 0 {
+		return gErrors.ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// wrongCode turns the error returned when logging in with a wrong code
+// into the one returned when managing two-factor settings.
+func wrongCode(err error) error {
+	if errors.Is(err, gErrors.ErrInvalidTwoFactorCode) {
+		return errWrongCode
+	}
+	return err
+}
+
+func (t *twoFactorManager) Status(ctx context.Context, userID uint) (params.TwoFactorStatus, error) {
+	if userID != auth.UserID(ctx) && !auth.IsAdmin(ctx) {
+		return params.TwoFactorStatus{}, gErrors.ErrUnauthorized
+	}
+	twoFactor, err := getTwoFactor(t.conn, userID)
+	if err != nil {
+		return params.TwoFactorStatus{}, err
+	}
+	if twoFactor == nil || twoFactor.ConfirmedAt == nil {
+		return params.TwoFactorStatus{}, nil
+	}
+	var codesLeft int64
+	if err := t.conn.Model(&models.RecoveryCode{}).Where("user_id = ?", userID).Count(&codesLeft).Error; err != nil {
+		return params.TwoFactorStatus{}, errors.Wrap(err, "counting recovery codes")
+	}
+	return params.TwoFactorStatus{
+		Enabled:           true,
+		EnabledAt:         twoFactor.ConfirmedAt,
+		RecoveryCodesLeft: int(codesLeft),
+	}, nil
+}
+
+func (t *twoFactorManager) Enroll(ctx context.Context) (params.TwoFactorEnrollment, error) {
+	userID := auth.UserID(ctx)
+	if userID == 0 {
+		return params.TwoFactorEnrollment{}, gErrors.ErrUnauthorized
+	}
+	if !auth.HasScope(ctx, auth.ScopeAccount) {
+		return params.TwoFactorEnrollment{}, auth.MissingScopeError(auth.ScopeAccount)
+	}
+
+	var user models.Users
+	if err := t.conn.Where("id = ?", userID).First(&user).Error; err != nil {
+		return params.TwoFactorEnrollment{}, errors.Wrap(err, "fetching user")
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return params.TwoFactorEnrollment{}, errors.Wrap(err, "generating secret")
+	}
+	uri := totp.URI(t.cfg.Issuer, user.Username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		return params.TwoFactorEnrollment{}, errors.Wrap(err, "generating QR code")
+	}
+
+	err = t.conn.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := getTwoFactor(tx, userID)
+		if err != nil {
+			return err
+		}
+		if twoFactor != nil && twoFactor.ConfirmedAt != nil {
+			return errTwoFactorEnabled
+		}
+		// Enrolling again replaces an unconfirmed secret.
+		return tx.Save(&models.TwoFactor{
+			UserID:    userID,
+			CreatedAt: time.Now(),
+			Secret:    secret,
+		}).Error
+	})
+	if err != nil {
+		return params.TwoFactorEnrollment{}, errors.Wrap(err, "saving two-factor settings")
+	}
+	return params.TwoFactorEnrollment{
+		Secret: secret,
+		URI:    uri,
+		QRCode: png,
+	}, nil
+}
+
+func (t *twoFactorManager) Confirm(ctx context.Context, code string) (params.RecoveryCodes, error) {
+	userID := auth.UserID(ctx)
+	if userID == 0 {
+		return params.RecoveryCodes{}, gErrors.ErrUnauthorized
+	}
+	if !auth.HasScope(ctx, auth.ScopeAccount) {
+		return params.RecoveryCodes{}, auth.MissingScopeError(auth.ScopeAccount)
+	}
+	if !isTOTPCode(code) {
+		return params.RecoveryCodes{}, errWrongCode
+	}
+
+	var codes params.RecoveryCodes
+	err := t.conn.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := getTwoFactor(tx, userID)
+		if err != nil {
+			return err
+		}
+		if twoFactor == nil {
+			return errNoEnrollment
+		}
+		if twoFactor.ConfirmedAt != nil {
+			return errTwoFactorEnabled
+		}
+		if err := useCode(tx, *twoFactor, code); err != nil {
+			return wrongCode(err)
+		}
+		if err := tx.Model(twoFactor).UpdateColumn("confirmed_at", time.Now().UTC()).Error; err != nil {
+			return errors.Wrap(err, "enabling two-factor authentication")
+		}
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return params.RecoveryCodes{}, err
+	}
+	return codes, nil
+}
+
+// withConfirmedCode runs fn in a transaction, once code has been checked
+// against the two-factor settings of the user in the context.
+func (t *twoFactorManager) withConfirmedCode(ctx context.Context, code string, fn func(tx *gorm.DB, userID uint) error) error {
+	userID := auth.UserID(ctx)
+	if userID == 0 {
+		return gErrors.ErrUnauthorized
+	}
+	if !auth.HasScope(ctx, auth.ScopeAccount) {
+		return auth.MissingScopeError(auth.ScopeAccount)
+	}
+	return t.conn.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := getTwoFactor(tx, userID)
+		if err != nil {
+			return err
+		}
+		if twoFactor == nil || twoFactor.ConfirmedAt == nil {
+			return errTwoFactorNotEnabled
+		}
+		if err := useCode(tx, *twoFactor, code); err != nil {
+			return wrongCode(err)
+		}
+		return fn(tx, userID)
+	})
+}
+
+func deleteTwoFactor(tx *gorm.DB, userID uint) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return errors.Wrap(err, "deleting recovery codes")
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.TwoFactor{}).Error; err != nil {
+		return errors.Wrap(err, "deleting two-factor settings")
+	}
+	return nil
+}
+
+func (t *twoFactorManager) Disable(ctx context.Context, code string) error {
+	return t.withConfirmedCode(ctx, code, deleteTwoFactor)
+}
+
+func (t *twoFactorManager) RegenerateRecoveryCodes(ctx context.Context, code string) (params.RecoveryCodes, error) {
+	var codes params.RecoveryCodes
+	err := t.withConfirmedCode(ctx, code, func(tx *gorm.DB, userID uint) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return params.RecoveryCodes{}, err
+	}
+	return codes, nil
+}
+
+func (t *twoFactorManager) Reset(ctx context.Context, userID uint) error {
+	if !auth.IsAdmin(ctx) {
+		return gErrors.ErrUnauthorized
+	}
+	if userID == auth.UserID(ctx) {
+		return gErrors.NewConflictError("you may not reset your own two-factor authentication, disable it instead")
+	}
+	var user models.Users
+	if err := t.conn.Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return gErrors.ErrUserNotFound
+		}
+		return errors.Wrap(err, "fetching user")
+	}
+	if (user.IsAdmin || user.IsSuperUser) && !auth.IsSuperUser(ctx) {
+		return gErrors.NewUnauthorizedError("only a superuser may reset the two-factor authentication of an admin")
+	}
+	return t.conn.Transaction(func(tx *gorm.DB) error {
+		return deleteTwoFactor(tx, userID)
+	})
+}
+
+func (t *twoFactorManager) Verify(ctx context.Context, userID uint, code string) error {
+	return t.conn.Transaction(func(tx *gorm.DB) error {
+		twoFactor, err := getTwoFactor(tx, userID)
+		if err != nil {
+			return err
+		}
+		if twoFactor == nil || twoFactor.ConfirmedAt == nil {
+			return gErrors.ErrInvalidTwoFactorCode
+		}
+		return useCode(tx, *twoFactor, code)
+	})
+}
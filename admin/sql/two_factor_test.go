@@ -0,0 +1,353 @@
+package sql_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	adminCommon "gopherbin/admin/common"
+	adminSQL "gopherbin/admin/sql"
+	"gopherbin/auth"
+	"gopherbin/auth/totp"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/params"
+
+	pkgErrors "github.com/pkg/errors"
+)
+
+type twoFactorFixture struct {
+	tokenFixture
+	twoFactor adminCommon.TwoFactorManager
+}
+
+func newTwoFactorFixture(t *testing.T) twoFactorFixture {
+	t.Helper()
+	f := newTokenFixture(t)
+	twoFactor, err := adminSQL.NewTwoFactorManager(f.dbCfg, config.TwoFactor{})
+	if err != nil {
+		t.Fatalf("NewTwoFactorManager: %v", err)
+	}
+	return twoFactorFixture{tokenFixture: f, twoFactor: twoFactor}
+}
+
+// enable enrolls and confirms an authenticator for the user in ctx. It
+// returns the secret and the recovery codes.
+func (f twoFactorFixture) enable(t *testing.T, ctx context.Context) (string, []string) {
+	t.Helper()
+	enrollment, err := f.twoFactor.Enroll(ctx)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	codes, err := f.twoFactor.Confirm(ctx, totpCode(t, enrollment.Secret, 0))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	return enrollment.Secret, codes.Codes
+}
+
+// totpCode returns the code for secret, offset periods from now. Codes
+// may only be used once, so tests that need several codes use the next
+// period, which is still accepted.
+func totpCode(t *testing.T, secret string, offset int) string {
+	t.Helper()
+	code, err := totp.Code(secret, time.Now().Add(time.Duration(offset)*totp.Period))
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	return code
+}
+
+func isInvalidCode(err error) bool {
+	return gErrors.Code(pkgErrors.Cause(err)) == gErrors.CodeInvalidTwoFactorCode
+}
+
+func isConflict(err error) bool {
+	_, ok := pkgErrors.Cause(err).(*gErrors.ConflictError)
+	return ok
+}
+
+func isForbidden(err error) bool {
+	_, ok := pkgErrors.Cause(err).(*gErrors.ForbiddenError)
+	return ok
+}
+
+func isBadRequest(err error) bool {
+	_, ok := pkgErrors.Cause(err).(*gErrors.BadRequestError)
+	return ok
+}
+
+// ── Enrollment ───────────────────────────────────────────────────────────────
+
+func TestTwoFactorEnroll(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	enrollment, err := f.twoFactor.Enroll(f.userCtx)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	if enrollment.Secret == "" {
+		t.Fatal("no secret returned")
+	}
+	wantPrefix := "otpauth://totp/" + config.DefaultTwoFactorIssuer + ":" + f.user.Username + "?"
+	if !strings.HasPrefix(enrollment.URI, wantPrefix) || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Errorf("unexpected URI %s", enrollment.URI)
+	}
+	if !bytes.HasPrefix(enrollment.QRCode, []byte("\x89PNG")) {
+		t.Error("QR code is not a PNG image")
+	}
+
+	// Two-factor authentication is not enabled until confirmed.
+	status, err := f.twoFactor.Status(f.userCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.Enabled {
+		t.Error("two-factor authentication enabled before being confirmed")
+	}
+	if err := f.twoFactor.Verify(context.Background(), f.user.ID, totpCode(t, enrollment.Secret, 0)); !isInvalidCode(err) {
+		t.Errorf("Verify before confirming: expected invalid code, got %v", err)
+	}
+}
+
+func TestTwoFactorConfirm(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	first, err := f.twoFactor.Enroll(f.userCtx)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	// Enrolling again replaces the secret.
+	second, err := f.twoFactor.Enroll(f.userCtx)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	if first.Secret == second.Secret {
+		t.Fatal("enrolling again returned the same secret")
+	}
+	if _, err := f.twoFactor.Confirm(f.userCtx, totpCode(t, first.Secret, 0)); !isBadRequest(err) {
+		t.Errorf("Confirm with the replaced secret: expected bad request, got %v", err)
+	}
+	if _, err := f.twoFactor.Confirm(f.userCtx, "abcde-fghij"); !isBadRequest(err) {
+		t.Errorf("Confirm with a recovery code: expected bad request, got %v", err)
+	}
+
+	codes, err := f.twoFactor.Confirm(f.userCtx, totpCode(t, second.Secret, 0))
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if len(codes.Codes) != 10 {
+		t.Errorf("got %d recovery codes, want 10", len(codes.Codes))
+	}
+	status, err := f.twoFactor.Status(f.userCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if !status.Enabled || status.EnabledAt == nil || status.RecoveryCodesLeft != 10 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	if _, err := f.twoFactor.Enroll(f.userCtx); !isConflict(err) {
+		t.Errorf("Enroll when enabled: expected conflict, got %v", err)
+	}
+	if _, err := f.twoFactor.Confirm(f.userCtx, totpCode(t, second.Secret, 1)); !isConflict(err) {
+		t.Errorf("Confirm when enabled: expected conflict, got %v", err)
+	}
+}
+
+func TestTwoFactorConfirm_NoEnrollment(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	if _, err := f.twoFactor.Confirm(f.userCtx, "123456"); !isConflict(err) {
+		t.Errorf("expected conflict, got %v", err)
+	}
+}
+
+func TestTwoFactor_RequiresAccountScope(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	ctx := auth.SetScopes(f.userCtx, []string{auth.ScopePasteRead})
+	if _, err := f.twoFactor.Enroll(ctx); !isForbidden(err) {
+		t.Errorf("Enroll: expected forbidden, got %v", err)
+	}
+	if _, err := f.twoFactor.Confirm(ctx, "123456"); !isForbidden(err) {
+		t.Errorf("Confirm: expected forbidden, got %v", err)
+	}
+	if err := f.twoFactor.Disable(ctx, "123456"); !isForbidden(err) {
+		t.Errorf("Disable: expected forbidden, got %v", err)
+	}
+}
+
+// ── Verify ───────────────────────────────────────────────────────────────────
+
+func TestTwoFactorVerify(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	secret, _ := f.enable(t, f.userCtx)
+	ctx := context.Background()
+
+	// The code used to confirm the enrollment may not be used again.
+	if err := f.twoFactor.Verify(ctx, f.user.ID, totpCode(t, secret, 0)); !isInvalidCode(err) {
+		t.Errorf("replayed code: expected invalid code, got %v", err)
+	}
+	next := totpCode(t, secret, 1)
+	if err := f.twoFactor.Verify(ctx, f.user.ID, next); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := f.twoFactor.Verify(ctx, f.user.ID, next); !isInvalidCode(err) {
+		t.Errorf("replayed code: expected invalid code, got %v", err)
+	}
+	if err := f.twoFactor.Verify(ctx, f.user.ID, "000000"); !isInvalidCode(err) {
+		t.Errorf("wrong code: expected invalid code, got %v", err)
+	}
+	if err := f.twoFactor.Verify(ctx, auth.UserID(f.superCtx), totpCode(t, secret, 0)); !isInvalidCode(err) {
+		t.Errorf("user without 2FA: expected invalid code, got %v", err)
+	}
+}
+
+func TestTwoFactorVerify_RecoveryCodes(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	_, codes := f.enable(t, f.userCtx)
+	ctx := context.Background()
+
+	// Recovery codes are accepted however they are typed.
+	if err := f.twoFactor.Verify(ctx, f.user.ID, " "+strings.ToUpper(codes[0])+" "); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := f.twoFactor.Verify(ctx, f.user.ID, codes[0]); !isInvalidCode(err) {
+		t.Errorf("used recovery code: expected invalid code, got %v", err)
+	}
+	if err := f.twoFactor.Verify(ctx, f.user.ID, strings.ReplaceAll(codes[1], "-", "")); err != nil {
+		t.Fatalf("Verify without separator: %v", err)
+	}
+	status, err := f.twoFactor.Status(f.userCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.RecoveryCodesLeft != len(codes)-2 {
+		t.Errorf("recovery codes left = %d, want %d", status.RecoveryCodesLeft, len(codes)-2)
+	}
+}
+
+func TestTwoFactorRegenerateRecoveryCodes(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	secret, oldCodes := f.enable(t, f.userCtx)
+
+	if _, err := f.twoFactor.RegenerateRecoveryCodes(f.userCtx, "000000"); !isBadRequest(err) {
+		t.Errorf("wrong code: expected bad request, got %v", err)
+	}
+	newCodes, err := f.twoFactor.RegenerateRecoveryCodes(f.userCtx, totpCode(t, secret, 1))
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes: %v", err)
+	}
+	if len(newCodes.Codes) != 10 {
+		t.Errorf("got %d recovery codes, want 10", len(newCodes.Codes))
+	}
+	if err := f.twoFactor.Verify(context.Background(), f.user.ID, oldCodes[0]); !isInvalidCode(err) {
+		t.Errorf("replaced recovery code: expected invalid code, got %v", err)
+	}
+	if err := f.twoFactor.Verify(context.Background(), f.user.ID, newCodes.Codes[0]); err != nil {
+		t.Errorf("new recovery code: %v", err)
+	}
+}
+
+// ── Disable and reset ────────────────────────────────────────────────────────
+
+func TestTwoFactorDisable(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	if err := f.twoFactor.Disable(f.userCtx, "123456"); !isConflict(err) {
+		t.Errorf("Disable when not enabled: expected conflict, got %v", err)
+	}
+
+	_, codes := f.enable(t, f.userCtx)
+	if err := f.twoFactor.Disable(f.userCtx, "aaaaa-aaaaa"); !isBadRequest(err) {
+		t.Errorf("wrong code: expected bad request, got %v", err)
+	}
+	if err := f.twoFactor.Disable(f.userCtx, codes[0]); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	status, err := f.twoFactor.Status(f.userCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.Enabled || status.RecoveryCodesLeft != 0 {
+		t.Errorf("unexpected status after disabling %+v", status)
+	}
+	if err := f.twoFactor.Verify(context.Background(), f.user.ID, codes[1]); !isInvalidCode(err) {
+		t.Errorf("recovery code after disabling: expected invalid code, got %v", err)
+	}
+	// Users may enroll again.
+	f.enable(t, f.userCtx)
+}
+
+func TestTwoFactorReset(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	f.enable(t, f.userCtx)
+
+	if err := f.twoFactor.Reset(f.userCtx, f.user.ID); !isUnauthorized(err) {
+		t.Errorf("Reset by a regular user: expected unauthorized, got %v", err)
+	}
+	if err := f.twoFactor.Reset(f.superCtx, auth.UserID(f.superCtx)); !isConflict(err) {
+		t.Errorf("Reset of own 2FA: expected conflict, got %v", err)
+	}
+	if err := f.twoFactor.Reset(f.superCtx, 9999); !isNotFound(err) {
+		t.Errorf("Reset of missing user: expected not found, got %v", err)
+	}
+	if err := f.twoFactor.Reset(f.superCtx, f.user.ID); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	status, err := f.twoFactor.Status(f.superCtx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.Enabled {
+		t.Error("two-factor authentication still enabled after reset")
+	}
+}
+
+func TestTwoFactorReset_AdminsOnlyBySuperUser(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	admin, err := f.users.Create(f.superCtx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+		Enabled:  true,
+		IsAdmin:  true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	otherAdmin, err := f.users.Create(f.superCtx, params.NewUserParams{
+		Email:    "other@example.com",
+		Username: "other",
+		FullName: "Other Admin",
+		Password: testPassword,
+		Enabled:  true,
+		IsAdmin:  true,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	adminCtx := auth.SetScopes(auth.PopulateContext(context.Background(), admin), auth.AllScopes())
+	otherCtx := auth.SetScopes(auth.PopulateContext(context.Background(), otherAdmin), auth.AllScopes())
+	f.enable(t, otherCtx)
+	f.enable(t, f.userCtx)
+
+	if err := f.twoFactor.Reset(adminCtx, otherAdmin.ID); !isUnauthorized(err) {
+		t.Errorf("admin resetting an admin: expected unauthorized, got %v", err)
+	}
+	if err := f.twoFactor.Reset(adminCtx, f.user.ID); err != nil {
+		t.Errorf("admin resetting a user: %v", err)
+	}
+	if err := f.twoFactor.Reset(f.superCtx, otherAdmin.ID); err != nil {
+		t.Errorf("superuser resetting an admin: %v", err)
+	}
+}
+
+func TestTwoFactorStatus_OtherUsers(t *testing.T) {
+	f := newTwoFactorFixture(t)
+	if _, err := f.twoFactor.Status(f.userCtx, auth.UserID(f.superCtx)); !isUnauthorized(err) {
+		t.Errorf("expected unauthorized, got %v", err)
+	}
+	if _, err := f.twoFactor.Status(f.superCtx, f.user.ID); err != nil {
+		t.Errorf("admin viewing a user: %v", err)
+	}
+}
@@ -16,6 +16,7 @@ package apiserver
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"gopherbin/paste"
 	"log"
@@ -24,10 +25,15 @@ import (
 	"time"
 
 	"gopherbin/admin"
+	adminCommon "gopherbin/admin/common"
 	"gopherbin/apiserver/controllers"
 	"gopherbin/apiserver/routers"
+	"gopherbin/audit"
 	"gopherbin/auth"
+	"gopherbin/auth/ldap"
+	"gopherbin/auth/oidc"
 	"gopherbin/config"
+	"gopherbin/mail"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -36,15 +42,14 @@ import (
 
 // APIServer is the API server worker
 type APIServer struct {
-	listener    net.Listener
-	srv         *http.Server
-	sessCleanup chan struct{}
+	listener net.Listener
+	srv      *http.Server
 }
 
 // Start starts the API server
 func (h *APIServer) Start() error {
 	go func() {
-		if err := h.srv.Serve(h.listener); err != nil {
+		if err := h.srv.Serve(h.listener); err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
 	}()
@@ -58,52 +63,181 @@ func (h *APIServer) Stop() error {
 	if err := h.srv.Shutdown(ctx); err != nil {
 		return fmt.Errorf("failed to shutdown web server: %q", err)
 	}
-	close(h.sessCleanup)
 	return nil
 }
 
+// Addr returns the address the API server is listening on
+func (h *APIServer) Addr() net.Addr {
+	return h.listener.Addr()
+}
+
 // GetAPIServer returns a new API server
 func GetAPIServer(cfg *config.Config) (*APIServer, error) {
-	paster, err := paste.NewPaster(cfg.Database)
+	auditLog, err := admin.GetAuditLog(cfg.Database)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting audit log")
+	}
+	auditSinks := []audit.Sink{auditLog}
+	if cfg.Audit.Syslog.Enable {
+		syslogSink, err := audit.NewSyslogSink(cfg.Audit.Syslog)
+		if err != nil {
+			return nil, errors.Wrap(err, "initializing syslog audit sink")
+		}
+		auditSinks = append(auditSinks, syslogSink)
+	}
+	recorder := audit.NewRecorder(auditSinks...)
+
+	paster, err := paste.NewPaster(cfg.Database, recorder)
 	if err != nil {
 		return nil, errors.Wrap(err, "initializing paster")
 	}
 
-	teamMgr, err := paste.NewTeamManager(cfg.Database)
+	teamMgr, err := paste.NewTeamManager(cfg.Database, recorder)
 	if err != nil {
 		return nil, errors.Wrap(err, "initializing team manager")
 	}
 
-	userMgr, err := admin.GetUserManager(cfg.Database)
+	var directories []adminCommon.Directory
+	if cfg.APIServer.LDAP.Enable {
+		ldapProvider, err := ldap.NewProvider(cfg.APIServer.LDAP)
+		if err != nil {
+			return nil, errors.Wrap(err, "initializing ldap provider")
+		}
+		directories = append(directories, ldapProvider)
+	}
+
+	userMgr, err := admin.GetUserManager(cfg.Database, cfg.Security.PasswordPolicy, recorder, directories...)
 	if err != nil {
 		return nil, errors.Wrap(err, "getting user manager")
 	}
 
-	apiHandler := controllers.NewAPIController(paster, teamMgr, userMgr, cfg.APIServer.JWTAuth)
+	tokenMgr, err := admin.GetAPITokenManager(cfg.Database)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting API token manager")
+	}
 
-	jwtMiddleware, err := auth.NewjwtMiddleware(userMgr, cfg.APIServer.JWTAuth)
+	certMgr, err := admin.GetClientCertificateManager(cfg.Database, cfg.APIServer.TLSConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting client certificate manager")
+	}
+
+	twoFactorMgr, err := admin.GetTwoFactorManager(cfg.Database, cfg.APIServer.TwoFactor)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting two-factor manager")
+	}
+
+	sessionMgr, err := admin.GetSessionManager(cfg.Database)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting session manager")
+	}
+
+	loginThrottler, err := admin.GetLoginThrottler(cfg.Database, cfg.APIServer.LoginThrottle)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting login throttler")
+	}
+
+	registrationMgr, err := admin.GetRegistrationManager(cfg.Database, cfg.APIServer.Registration, cfg.Security.PasswordPolicy, recorder)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting registration manager")
+	}
+
+	var oidcProvider *oidc.Provider
+	if cfg.APIServer.OIDC.Enable {
+		oidcProvider, err = oidc.NewProvider(cfg.APIServer.OIDC, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "initializing oidc provider")
+		}
+	}
+
+	var mailer mail.Sender
+	if cfg.Mail.Enable {
+		mailer, err = mail.NewSender(cfg.Mail)
+		if err != nil {
+			return nil, errors.Wrap(err, "initializing mail sender")
+		}
+	}
+
+	signingKeyMgr, err := admin.GetSigningKeyManager(cfg.Database)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting signing key manager")
+	}
+
+	keyRing, err := auth.NewKeyRing(signingKeyMgr, cfg.APIServer.JWTAuth)
+	if err != nil {
+		return nil, errors.Wrap(err, "initializing signing keys")
+	}
+
+	apiHandler := controllers.NewAPIController(controllers.Deps{
+		Paster:              paster,
+		TeamManager:         teamMgr,
+		UserManager:         userMgr,
+		TokenManager:        tokenMgr,
+		CertManager:         certMgr,
+		TwoFactorManager:    twoFactorMgr,
+		SessionManager:      sessionMgr,
+		LoginThrottler:      loginThrottler,
+		RegistrationManager: registrationMgr,
+		AuditLog:            auditLog,
+		Recorder:            recorder,
+		OIDCProvider:        oidcProvider,
+		Mailer:              mailer,
+		KeyRing:             keyRing,
+		JWTAuth:             cfg.APIServer.JWTAuth,
+		TwoFactor:           cfg.APIServer.TwoFactor,
+		Mail:                cfg.Mail,
+		SessionCookie:       cfg.APIServer.SessionCookie,
+	})
+
+	jwtMiddleware, err := auth.NewjwtMiddleware(userMgr, sessionMgr, keyRing)
 	if err != nil {
 		return nil, errors.Wrap(err, "initializing jwt middleware")
 	}
 
+	apiTokenMiddleware, err := auth.NewAPITokenMiddleware(tokenMgr)
+	if err != nil {
+		return nil, errors.Wrap(err, "initializing API token middleware")
+	}
+	authMiddlewares := []auth.Middleware{apiTokenMiddleware}
+	if cfg.APIServer.ProxyAuth.Enable {
+		proxyAuthMiddleware, err := auth.NewProxyAuthMiddleware(userMgr, twoFactorMgr, cfg.APIServer.ProxyAuth, cfg.APIServer.TwoFactor)
+		if err != nil {
+			return nil, errors.Wrap(err, "initializing proxy auth middleware")
+		}
+		authMiddlewares = append(authMiddlewares, proxyAuthMiddleware)
+	}
+	if cfg.APIServer.UseTLS && cfg.APIServer.TLSConfig.ClientAuthEnabled() {
+		authMiddlewares = append(authMiddlewares, auth.NewClientCertMiddleware(certMgr))
+	}
+	authMiddleware := auth.Chain(append(authMiddlewares, jwtMiddleware)...)
+
 	initMiddleware, err := auth.NewInitRequiredMiddleware(userMgr)
 	if err != nil {
 		return nil, errors.Wrap(err, "initializing init required middleware")
 	}
+
+	forwardingProxies, err := cfg.APIServer.ForwardingProxies()
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing trusted proxies")
+	}
+	requestIDMiddleware, err := auth.NewRequestIDMiddleware(forwardingProxies)
+	if err != nil {
+		return nil, errors.Wrap(err, "initializing request ID middleware")
+	}
 	router := mux.NewRouter()
 	corwMw := mux.CORSMethodMiddleware(router)
 
-	if err := routers.AddAPIURLs(router, apiHandler, jwtMiddleware, initMiddleware); err != nil {
+	if err := routers.AddAPIURLs(router, apiHandler, authMiddleware, initMiddleware); err != nil {
 		return nil, errors.Wrap(err, "setting API urls")
 	}
 
 	router.Use(corwMw)
 	allowedOrigins := handlers.AllowedOrigins(cfg.APIServer.CORSOrigins)
-	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS", "DELETE"})
-	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
+	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "OPTIONS", "DELETE"})
+	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", auth.RequestIDHeader, auth.CSRFHeader})
+	exposedOk := handlers.ExposedHeaders([]string{auth.RequestIDHeader})
 
 	srv := &http.Server{
-		Handler: handlers.CORS(methodsOk, headersOk, allowedOrigins)(router),
+		Handler: requestIDMiddleware.Middleware(handlers.CORS(methodsOk, headersOk, exposedOk, allowedOrigins)(router)),
 	}
 	if cfg.APIServer.UseTLS {
 		tlsCfg, err := cfg.APIServer.TLSConfig.TLSConfig()
@@ -116,6 +250,9 @@ func GetAPIServer(cfg *config.Config) (*APIServer, error) {
 	if err != nil {
 		return nil, err
 	}
+	if srv.TLSConfig != nil {
+		listener = tls.NewListener(listener, srv.TLSConfig)
+	}
 	return &APIServer{
 		srv:      srv,
 		listener: listener,
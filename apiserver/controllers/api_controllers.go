@@ -1,125 +1,212 @@
 package controllers
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	adminCommon "gopherbin/admin/common"
 	"gopherbin/apiserver/responses"
+	"gopherbin/audit"
 	"gopherbin/auth"
+	"gopherbin/auth/oidc"
 	"gopherbin/config"
 	gErrors "gopherbin/errors"
+	"gopherbin/mail"
 	"gopherbin/params"
 	"gopherbin/paste/common"
 	"gopherbin/util"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
+	"github.com/juju/loggo"
 	"github.com/pkg/errors"
 )
 
+var log = loggo.GetLogger("gopherbin.apiserver.controllers")
+
+// Deps holds the dependencies of an APIController
+type Deps struct {
+	Paster              common.Paster
+	TeamManager         common.TeamManager
+	UserManager         adminCommon.UserManager
+	TokenManager        adminCommon.APITokenManager
+	CertManager         adminCommon.ClientCertificateManager
+	TwoFactorManager    adminCommon.TwoFactorManager
+	SessionManager      adminCommon.SessionManager
+	LoginThrottler      adminCommon.LoginThrottler
+	RegistrationManager adminCommon.RegistrationManager
+	AuditLog            adminCommon.AuditLog
+	// Recorder records logins, and admin actions not recorded by the
+	// managers, in the audit log. Defaults to audit.Discard.
+	Recorder audit.Recorder
+	// OIDCProvider may be nil, if OIDC login is disabled.
+	OIDCProvider *oidc.Provider
+	// Mailer may be nil, if mail is disabled.
+	Mailer mail.Sender
+	// KeyRing holds the keys login tokens are signed with.
+	KeyRing       *auth.KeyRing
+	JWTAuth       config.JWTAuth
+	TwoFactor     config.TwoFactor
+	Mail          config.Mail
+	SessionCookie config.SessionCookie
+}
+
 // NewAPIController returns a new APIController
-func NewAPIController(paster common.Paster, teamManager common.TeamManager, mgr adminCommon.UserManager, cfg config.JWTAuth) *APIController {
+func NewAPIController(deps Deps) *APIController {
+	recorder := deps.Recorder
+	if recorder == nil {
+		recorder = audit.Discard
+	}
 	return &APIController{
-		paster:      paster,
-		manager:     mgr,
-		teamManager: teamManager,
-		cfg:         cfg,
+		paster:           deps.Paster,
+		manager:          deps.UserManager,
+		teamManager:      deps.TeamManager,
+		tokenManager:     deps.TokenManager,
+		certManager:      deps.CertManager,
+		twoFactorManager: deps.TwoFactorManager,
+		sessionManager:   deps.SessionManager,
+		loginThrottler:   deps.LoginThrottler,
+		registrations:    deps.RegistrationManager,
+		auditLog:         deps.AuditLog,
+		recorder:         recorder,
+		oidc:             deps.OIDCProvider,
+		mailer:           deps.Mailer,
+		keyRing:          deps.KeyRing,
+		cfg:              deps.JWTAuth,
+		twoFactorCfg:     deps.TwoFactor,
+		mailCfg:          deps.Mail,
+		sessionCfg:       deps.SessionCookie,
 	}
 }
 
 // APIController implements handlers for the REST API
 type APIController struct {
-	paster      common.Paster
-	manager     adminCommon.UserManager
-	teamManager common.TeamManager
-	cfg         config.JWTAuth
+	paster           common.Paster
+	manager          adminCommon.UserManager
+	teamManager      common.TeamManager
+	tokenManager     adminCommon.APITokenManager
+	certManager      adminCommon.ClientCertificateManager
+	twoFactorManager adminCommon.TwoFactorManager
+	sessionManager   adminCommon.SessionManager
+	loginThrottler   adminCommon.LoginThrottler
+	registrations    adminCommon.RegistrationManager
+	auditLog         adminCommon.AuditLog
+	recorder         audit.Recorder
+	oidc             *oidc.Provider
+	mailer           mail.Sender
+	keyRing          *auth.KeyRing
+	cfg              config.JWTAuth
+	twoFactorCfg     config.TwoFactor
+	mailCfg          config.Mail
+	sessionCfg       config.SessionCookie
 }
 
-func handleError(w http.ResponseWriter, err error) {
-	w.Header().Add("Content-Type", "application/json")
+func handleError(ctx context.Context, w http.ResponseWriter, err error) {
 	origErr := errors.Cause(err)
 	apiErr := responses.APIErrorResponse{
-		Details: origErr.Error(),
+		Details:   origErr.Error(),
+		Code:      gErrors.Code(origErr),
+		RequestID: auth.RequestID(ctx),
 	}
 
-	switch origErr.(type) {
+	var status int
+	switch e := origErr.(type) {
 	case *gErrors.NotFoundError:
-		w.WriteHeader(http.StatusNotFound)
+		status = http.StatusNotFound
 		apiErr.Error = "Not Found"
 	case *gErrors.UnauthorizedError:
-		w.WriteHeader(http.StatusUnauthorized)
+		status = http.StatusUnauthorized
 		apiErr.Error = "Not Authorized"
+	case *gErrors.ForbiddenError:
+		status = http.StatusForbidden
+		apiErr.Error = "Forbidden"
 	case *gErrors.BadRequestError:
-		w.WriteHeader(http.StatusBadRequest)
+		status = http.StatusBadRequest
 		apiErr.Error = "Bad Request"
+		apiErr.Fields = e.Fields()
 	case *gErrors.DuplicateUserError, *gErrors.ConflictError:
-		w.WriteHeader(http.StatusConflict)
+		status = http.StatusConflict
 		apiErr.Error = "Conflict"
+	case *gErrors.TooManyRequestsError:
+		status = http.StatusTooManyRequests
+		apiErr.Error = "Too Many Requests"
+		if retryAfter := e.RetryAfter(); retryAfter > 0 {
+			// Retry-After is expressed in whole seconds. Round up, so
+			// clients that honor it do not retry too early.
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		}
 	default:
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Errorf("request %s failed: %+v", apiErr.RequestID, err)
+		status = http.StatusInternalServerError
 		apiErr.Error = "Server error"
+		apiErr.Code = gErrors.CodeInternal
 	}
 
-	json.NewEncoder(w).Encode(apiErr)
+	responses.WriteError(w, status, apiErr)
+}
+
+// tagsFromQuery returns the tags requested through the "tags" query
+// parameter. Tags may be comma separated, or the parameter may be repeated.
+func tagsFromQuery(r *http.Request) []string {
+	var tags []string
+	for _, val := range r.URL.Query()["tags"] {
+		for _, tag := range strings.Split(val, ",") {
+			if strings.TrimSpace(tag) == "" {
+				continue
+			}
+			tags = append(tags, tag)
+		}
+	}
+	return tags
 }
 
 // NotFoundHandler is returned when an invalid URL is acccessed
 func (p *APIController) NotFoundHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println(r.URL.Path)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusNotFound)
-	json.NewEncoder(w).Encode(responses.NotFoundResponse)
+	apiErr := responses.NotFoundResponse
+	apiErr.RequestID = auth.RequestID(r.Context())
+	responses.WriteError(w, http.StatusNotFound, apiErr)
 }
 
 // FirstRunHandler initializez gopherbin
 func (p *APIController) FirstRunHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	if p.manager.HasSuperUser() {
-		err := gErrors.NewConflictError("already initialized")
-		handleError(w, err)
+		err := gErrors.WithCode(gErrors.NewConflictError("already initialized"), gErrors.CodeAlreadyInitialized)
+		handleError(ctx, w, err)
 		return
 	}
 
 	var newUserParams params.NewUserParams
 	if err := json.NewDecoder(r.Body).Decode(&newUserParams); err != nil {
-		handleError(w, gErrors.ErrBadRequest)
+		handleError(ctx, w, gErrors.ErrBadRequest)
 		return
 	}
 
 	newUser, err := p.manager.CreateSuperUser(newUserParams)
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(newUser)
 }
 
-// LoginHandler returns a jwt token
-func (p *APIController) LoginHandler(w http.ResponseWriter, r *http.Request) {
-	var loginInfo params.PasswordLoginParams
-	if err := json.NewDecoder(r.Body).Decode(&loginInfo); err != nil {
-		handleError(w, gErrors.ErrBadRequest)
-		return
-	}
-
-	if err := loginInfo.Validate(); err != nil {
-		handleError(w, err)
-		return
-	}
-	ctx := r.Context()
-	ctx, err := p.manager.Authenticate(ctx, loginInfo)
-	if err != nil {
-		handleError(w, err)
-		return
-	}
+// newJWT returns a signed JWT token for the user in the context, and
+// records the session it was issued for. csrfToken is only set for
+// cookie sessions.
+func (p *APIController) newJWT(ctx context.Context, r *http.Request, scopes []string, csrfToken string) (string, error) {
 	tokenID, err := util.GetRandomString(16)
 	if err != nil {
-		handleError(w, err)
-		return
+		return "", err
 	}
 	expireToken := time.Now().Add(p.cfg.TimeToLive.Duration())
 	expires := &jwt.NumericDate{
@@ -128,480 +215,774 @@ func (p *APIController) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	claims := auth.JWTClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: expires,
-			Issuer:    "gopherbin",
+			Issuer:    auth.LoginTokenIssuer,
 		},
-		UserID:      auth.UserID(ctx),
-		UpdatedAt:   auth.UpdatedAt(ctx),
-		TokenID:     tokenID,
-		IsAdmin:     auth.IsAdmin(ctx),
-		IsSuperUser: auth.IsSuperUser(ctx),
-		FullName:    auth.FullName(ctx),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(p.cfg.Secret))
+		UserID:        auth.UserID(ctx),
+		SecurityStamp: auth.SecurityStamp(ctx),
+		TokenID:       tokenID,
+		IsAdmin:       auth.IsAdmin(ctx),
+		IsSuperUser:   auth.IsSuperUser(ctx),
+		FullName:      auth.FullName(ctx),
+		Scopes:        scopes,
+		CSRFToken:     csrfToken,
+	}
+	signed, err := p.keyRing.Sign(claims)
 	if err != nil {
-		handleError(w, err)
-		return
+		return "", err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(params.JWTResponse{Token: tokenString})
-}
-
-// LogoutHandler will blacklist the token ID
-func (p *APIController) LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	claim := auth.JWTClaim(ctx)
-	err := p.manager.BlacklistToken(claim.TokenID, claim.RegisteredClaims.ExpiresAt.Unix())
-	if err != nil {
-		handleError(w, err)
-		return
+	session := adminCommon.NewSession{
+		ID:        tokenID,
+		ExpiresAt: expireToken,
+		IP:        auth.ClientIP(r.Context()),
+		UserAgent: r.UserAgent(),
+	}
+	if err := p.sessionManager.Create(ctx, session); err != nil {
+		return "", err
 	}
+	return signed, nil
 }
 
-// PasteViewHandler returns details about a single paste
-func (p *APIController) PasteViewHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	pasteID, ok := vars["pasteID"]
+// csrfTokenLength is the number of random characters in a CSRF token
+const csrfTokenLength = 32
 
-	if !ok {
-		w.WriteHeader(http.StatusNotFound)
-		return
+// sessionCookiePath limits the session cookies to the API
+const sessionCookiePath = "/api/v1"
+
+// startSession issues a login token for the user in the context, and sets
+// it in response. If cookie is set and session cookies are enabled, the
+// token is set in an HttpOnly cookie instead, and response gets the CSRF
+// token the browser must send back along with the user that logged in.
+func (p *APIController) startSession(ctx context.Context, w http.ResponseWriter, r *http.Request, scopes []string, cookie bool, response *params.JWTResponse) error {
+	if !cookie || !p.sessionCfg.Enable {
+		token, err := p.newJWT(ctx, r, scopes, "")
+		if err != nil {
+			return err
+		}
+		response.Token = token
+		return nil
 	}
 
-	pasteInfo, err := p.paster.Get(ctx, pasteID)
+	csrfToken, err := util.GetRandomString(csrfTokenLength)
 	if err != nil {
-		handleError(w, err)
-		return
+		return err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(pasteInfo)
-}
-
-// PasteDownloadHandler serves a paste as a downloadable file.
-func (p *APIController) PasteDownloadHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	pasteID, ok := vars["pasteID"]
-
-	if !ok {
-		w.WriteHeader(http.StatusNotFound)
-		return
+	user, err := p.manager.Get(auth.GetAdminContext(), auth.UserID(ctx))
+	if err != nil {
+		return err
 	}
-
-	pasteInfo, err := p.paster.Get(ctx, pasteID)
+	expires := time.Now().Add(p.cfg.TimeToLive.Duration())
+	token, err := p.newJWT(ctx, r, scopes, csrfToken)
 	if err != nil {
-		handleError(w, err)
-		return
+		return err
 	}
+	p.setSessionCookies(w, token, csrfToken, expires)
+	response.CSRFToken = csrfToken
+	response.User = &user
+	return nil
+}
 
-	w.Header().Set("Access-Control-Expose-Headers", "x-suggested-filename, Content-Disposition")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", pasteInfo.Name))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("x-suggested-filename", pasteInfo.Name)
-	w.Write(pasteInfo.Data)
+// setSessionCookies sets the session and CSRF cookies. They are removed
+// if token is empty.
+func (p *APIController) setSessionCookies(w http.ResponseWriter, token, csrfToken string, expires time.Time) {
+	maxAge := 0
+	if token == "" {
+		maxAge = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookieName,
+		Value:    token,
+		Path:     sessionCookiePath,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: p.sessionCfg.SameSiteMode(),
+	})
+	// The web UI reads the CSRF token from this cookie after a reload.
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CSRFCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   true,
+		SameSite: p.sessionCfg.SameSiteMode(),
+	})
 }
 
-// PublicPasteViewHandler returns details about a single public paste
-func (p *APIController) PublicPasteViewHandler(w http.ResponseWriter, r *http.Request) {
+// JWKSHandler returns the public keys that verify login tokens, so other
+// services can verify them
+func (p *APIController) JWKSHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	vars := mux.Vars(r)
-	pasteID, ok := vars["pasteID"]
-
-	if !ok {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-
-	pasteInfo, err := p.paster.GetPublicPaste(ctx, pasteID)
+	keys, err := p.keyRing.JWKS()
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(pasteInfo)
+	json.NewEncoder(w).Encode(keys)
 }
 
-// PasteListHandler returns a list of pastes
-func (p *APIController) PasteListHandler(w http.ResponseWriter, r *http.Request) {
+// LoginHandler returns a jwt token
+func (p *APIController) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	page := r.URL.Query().Get("page")
-	pageInt, _ := strconv.ParseInt(page, 10, 64)
-	maxResultsOpt := r.URL.Query().Get("max_results")
-	maxResults, _ := strconv.ParseInt(maxResultsOpt, 10, 64)
-	if maxResults == 0 {
-		maxResults = 50
+	var loginInfo params.PasswordLoginParams
+	if err := json.NewDecoder(r.Body).Decode(&loginInfo); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
 	}
 
-	res, err := p.paster.List(ctx, pageInt, maxResults)
-	if err != nil {
-		handleError(w, err)
+	if err := loginInfo.Validate(); err != nil {
+		handleError(ctx, w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
-}
-
-// SearchPasteHandler searches for pastes by name
-func (p *APIController) SearchPasteHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "No search query specified",
-		})
+	ip := auth.ClientIP(ctx)
+	if err := p.loginThrottler.Check(ctx, loginInfo.Username, ip); err != nil {
+		p.auditFailedLogin(ctx, loginInfo.Username, loginMethodPassword, err)
+		handleError(ctx, w, err)
 		return
 	}
-
-	page := r.URL.Query().Get("page")
-	pageInt, _ := strconv.ParseInt(page, 10, 64)
-	maxResultsOpt := r.URL.Query().Get("max_results")
-	maxResults, _ := strconv.ParseInt(maxResultsOpt, 10, 64)
-	if maxResults == 0 {
-		maxResults = 50
+	ctx, err := p.manager.Authenticate(ctx, loginInfo)
+	if err != nil {
+		if gErrors.Code(errors.Cause(err)) == gErrors.CodeInvalidCredentials {
+			p.recordFailedLogin(ctx, loginInfo.Username, ip)
+		}
+		p.auditFailedLogin(ctx, loginInfo.Username, loginMethodPassword, err)
+		handleError(ctx, w, err)
+		return
 	}
-
-	res, err := p.paster.Search(ctx, query, pageInt, maxResults)
+	// Logging in with a password grants all scopes, unless the client
+	// asks for a more limited token.
+	scopes, err := auth.ResolveScopes(auth.SetScopes(ctx, auth.AllScopes()), loginInfo.Scopes)
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
-}
 
-// DeletePasteHandler deletes a single paste
-func (p *APIController) DeletePasteHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	pasteID, ok := vars["pasteID"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "No paste ID specified",
-		})
+	response, err := p.completeLogin(ctx, w, r, scopes, loginInfo.Cookie)
+	if err != nil {
+		handleError(ctx, w, err)
 		return
 	}
-	if err := p.paster.Delete(ctx, pasteID); err != nil {
-		handleError(w, err)
-		return
+	if !response.TwoFactorRequired {
+		// Failed logins are only forgotten once the two-factor code
+		// was accepted, so guessing codes is throttled as well.
+		p.recordSuccessfulLogin(ctx, loginInfo.Username)
+		p.auditLogin(ctx, loginMethodPassword)
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
 }
 
-// UserListHandler handles the list of pastes
-func (p *APIController) UserListHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	if !auth.IsSuperUser(ctx) && !auth.IsAdmin(ctx) {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(responses.UnauthorizedResponse)
-		return
+// completeLogin issues the session of the user in the context, once they
+// proved who they are with any login method. Users that enabled two-factor
+// authentication get a pre-auth token instead, and only get the session
+// once they send a code along with it to TwoFactorLoginHandler. Admins
+// that must enable it but have not yet may only enroll an authenticator.
+func (p *APIController) completeLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, scopes []string, cookie bool) (params.JWTResponse, error) {
+	twoFactor, err := p.twoFactorManager.Status(auth.GetAdminContext(), auth.UserID(ctx))
+	if err != nil {
+		return params.JWTResponse{}, err
 	}
-
-	page := r.URL.Query().Get("page")
-	pageInt, _ := strconv.ParseInt(page, 10, 64)
-	maxResultsOpt := r.URL.Query().Get("max_results")
-	maxResults, _ := strconv.ParseInt(maxResultsOpt, 10, 64)
-	if maxResults == 0 {
-		maxResults = 50
+	if twoFactor.Enabled {
+		preAuthToken, err := auth.NewPreAuthToken(ctx, p.cfg.Secret, scopes)
+		if err != nil {
+			return params.JWTResponse{}, err
+		}
+		return params.JWTResponse{TwoFactorRequired: true, PreAuthToken: preAuthToken}, nil
 	}
 
-	res, err := p.manager.List(ctx, pageInt, maxResults)
-	if err != nil {
-		handleError(w, err)
-		return
+	var response params.JWTResponse
+	if p.twoFactorCfg.RequireForAdmins && (auth.IsAdmin(ctx) || auth.IsSuperUser(ctx)) {
+		scopes = []string{auth.ScopeAccount}
+		response.TwoFactorEnrollmentRequired = true
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	if err := p.startSession(ctx, w, r, scopes, cookie, &response); err != nil {
+		return params.JWTResponse{}, err
+	}
+	return response, nil
 }
 
-// CreatePasteHandler creates a new paste
-func (p *APIController) CreatePasteHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// Login methods recorded in the audit log
+const (
+	loginMethodPassword  = "password"
+	loginMethodTwoFactor = "two_factor"
+	loginMethodOIDC      = "oidc"
+	loginMethodProxy     = "proxy"
+)
 
-	var pasteData params.Paste
-	if err := json.NewDecoder(r.Body).Decode(&pasteData); err != nil {
-		fmt.Println(err)
-		handleError(w, gErrors.ErrBadRequest)
-		return
+// auditLogin records the login of the user in the context in the audit
+// log
+func (p *APIController) auditLogin(ctx context.Context, method string) {
+	p.recorder.Record(ctx, params.AuditEvent{
+		Action:     audit.ActionLogin,
+		TargetType: audit.TargetUser,
+		TargetID:   fmt.Sprintf("%d", auth.UserID(ctx)),
+		Details:    map[string]string{"method": method},
+	})
+}
+
+// auditFailedLogin records a failed login as login in the audit log,
+// along with the error code explaining why it failed.
+func (p *APIController) auditFailedLogin(ctx context.Context, login, method string, err error) {
+	p.recorder.Record(ctx, params.AuditEvent{
+		Action: audit.ActionLoginFailed,
+		Details: map[string]string{
+			"login":  login,
+			"method": method,
+			"reason": gErrors.Code(errors.Cause(err)),
+		},
+	})
+}
+
+// auditUserAction records an admin action on the user identified by
+// userID in the audit log
+func (p *APIController) auditUserAction(ctx context.Context, action string, userID uint, details map[string]string) {
+	p.recorder.Record(ctx, params.AuditEvent{
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   fmt.Sprintf("%d", userID),
+		Details:    details,
+	})
+}
+
+// recordFailedLogin counts a failed login towards the login throttle.
+// Errors are only logged, so the client still learns why the login failed.
+func (p *APIController) recordFailedLogin(ctx context.Context, login, ip string) {
+	if err := p.loginThrottler.Failed(ctx, login, ip); err != nil {
+		log.Errorf("request %s: failed to record failed login: %+v", auth.RequestID(ctx), err)
 	}
+}
 
-	pasteInfo, err := p.paster.Create(
-		ctx, pasteData.Data, pasteData.Name,
-		pasteData.Language, pasteData.Description,
-		pasteData.Expires, pasteData.Public, "",
-		pasteData.Metadata, pasteData.MaxAccesses)
-	if err != nil {
-		fmt.Println(err)
-		handleError(w, err)
-		return
+// recordSuccessfulLogin forgets the failed logins of an account.
+func (p *APIController) recordSuccessfulLogin(ctx context.Context, login string) {
+	if err := p.loginThrottler.Succeeded(ctx, login); err != nil {
+		log.Errorf("request %s: failed to reset failed logins: %+v", auth.RequestID(ctx), err)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(pasteInfo)
 }
 
-// UpdatePasteHandler
-func (p *APIController) UpdatePasteHandler(w http.ResponseWriter, r *http.Request) {
+// TwoFactorLoginHandler completes the login of users that have two-factor
+// authentication enabled, and returns a jwt token
+func (p *APIController) TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	vars := mux.Vars(r)
-	pasteID, ok := vars["pasteID"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "No paste ID specified",
-		})
+	var loginInfo params.TwoFactorLoginParams
+	if err := json.NewDecoder(r.Body).Decode(&loginInfo); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
 		return
 	}
-
-	var pasteData params.UpdatePasteParams
-	if err := json.NewDecoder(r.Body).Decode(&pasteData); err != nil {
-		handleError(w, gErrors.ErrBadRequest)
+	if err := loginInfo.Validate(); err != nil {
+		handleError(ctx, w, err)
 		return
 	}
 
-	pasteInfo, err := p.paster.SetPrivacy(ctx, pasteID, pasteData.Public)
+	claims, err := auth.ParsePreAuthToken(loginInfo.PreAuthToken, p.cfg.Secret)
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(pasteInfo)
-}
-
-// SharePasteHandler shares a paste with a user.
-func (p *APIController) SharePasteHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	pasteID, ok := vars["pasteID"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "No paste ID specified",
-		})
+	// A pre-auth token may only be used once, so guessing the code
+	// requires entering the password again for every attempt. Blacklisting
+	// fails if a concurrent request already used the token.
+	if err := p.manager.ValidateToken(claims.ID); err != nil {
+		handleError(ctx, w, auth.ErrInvalidPreAuthToken)
 		return
 	}
-
-	var userID params.UserActionRequest
-	if err := json.NewDecoder(r.Body).Decode(&userID); err != nil {
-		handleError(w, gErrors.ErrBadRequest)
+	if err := p.manager.BlacklistToken(claims.ID, claims.ExpiresAt.Unix()); err != nil {
+		handleError(ctx, w, auth.ErrInvalidPreAuthToken)
 		return
 	}
 
-	targetUser, err := p.paster.ShareWithUser(ctx, pasteID, userID.UserID)
+	user, err := p.manager.Get(auth.GetAdminContext(), claims.UserID())
 	if err != nil {
-		handleError(w, err)
+		if errors.Is(err, gErrors.ErrNotFound) {
+			err = auth.ErrInvalidPreAuthToken
+		}
+		handleError(ctx, w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(targetUser)
-}
-
-// SharePasteHandler shares a paste with a user.
-func (p *APIController) UnsharePasteHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	pasteID, ok := vars["pasteID"]
-	userID, userOK := vars["userID"]
-	if !ok || !userOK {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "User ID or paste ID is missing",
-		})
+	ctx = auth.PopulateContext(ctx, user)
+	if auth.SecurityStamp(ctx) != claims.SecurityStamp {
+		// The password was changed, or the user was logged out
+		// everywhere, since entering the password.
+		handleError(ctx, w, auth.ErrInvalidPreAuthToken)
 		return
 	}
-
-	if err := p.paster.UnshareWithUser(ctx, pasteID, userID); err != nil {
-		handleError(w, err)
+	if !user.Enabled {
+		handleError(ctx, w, gErrors.WithCode(gErrors.NewUnauthorizedError("user is disabled"), gErrors.CodeUserDisabled))
 		return
 	}
-}
-
-// UserListHandler handles the list of pastes
-func (p *APIController) ListSharesHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	pasteID, ok := vars["pasteID"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "No paste ID specified",
-		})
+	ip := auth.ClientIP(ctx)
+	if err := p.loginThrottler.Check(ctx, user.Username, ip); err != nil {
+		p.auditFailedLogin(ctx, user.Username, loginMethodTwoFactor, err)
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.twoFactorManager.Verify(ctx, user.ID, loginInfo.Code); err != nil {
+		if gErrors.Code(errors.Cause(err)) == gErrors.CodeInvalidTwoFactorCode {
+			p.recordFailedLogin(ctx, user.Username, ip)
+		}
+		p.auditFailedLogin(ctx, user.Username, loginMethodTwoFactor, err)
+		handleError(ctx, w, err)
 		return
 	}
+	p.recordSuccessfulLogin(ctx, user.Username)
 
-	res, err := p.paster.ListShares(ctx, pasteID)
-	if err != nil {
-		handleError(w, err)
+	var response params.JWTResponse
+	if err := p.startSession(ctx, w, r, claims.Scopes, loginInfo.Cookie, &response); err != nil {
+		handleError(ctx, w, err)
 		return
 	}
+	p.auditLogin(ctx, loginMethodTwoFactor)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(response)
 }
 
-//
-// Admin user handlers
-//
+var errPasswordResetDisabled = gErrors.NewNotFoundError("password reset by email is not enabled")
 
-// NewUserHandler creates a new user
-func (p *APIController) NewUserHandler(w http.ResponseWriter, r *http.Request) {
+// ForgotPasswordHandler emails a password reset link to the user with the
+// given email address. The response is the same whether or not the address
+// belongs to a user, so it can not be used to find registered addresses.
+func (p *APIController) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-
-	var newUserParams params.NewUserParams
-	if err := json.NewDecoder(r.Body).Decode(&newUserParams); err != nil {
-		handleError(w, gErrors.ErrBadRequest)
+	if p.mailer == nil {
+		handleError(ctx, w, errPasswordResetDisabled)
 		return
 	}
-
-	newUser, err := p.manager.Create(ctx, newUserParams)
-	if err != nil {
-		handleError(w, err)
+	var forgotParams params.ForgotPasswordParams
+	if err := json.NewDecoder(r.Body).Decode(&forgotParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(newUser)
-}
-
-// UpdateUserHandler will update a user
-func (p *APIController) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	userID, ok := vars["userID"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "no user ID specified",
-		})
+	if err := forgotParams.Validate(); err != nil {
+		handleError(ctx, w, err)
 		return
 	}
 
-	userIDInt, err := strconv.ParseUint(userID, 10, 64)
+	user, err := p.manager.GetByEmail(auth.GetAdminContext(), forgotParams.Email)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "invalid user ID",
-		})
+		if errors.Is(err, gErrors.ErrNotFound) {
+			return
+		}
+		handleError(ctx, w, err)
 		return
 	}
-	var updateUserPayload params.UpdateUserPayload
-	if err := json.NewDecoder(r.Body).Decode(&updateUserPayload); err != nil {
-		handleError(w, gErrors.NewBadRequestError("failed to unmarshal request: %v", err))
+	// Users of an external identity provider manage their password
+	// there.
+	if !user.Enabled || user.AuthProvider != "" {
 		return
 	}
 
-	updatedUser, err := p.manager.Update(ctx, uint(userIDInt), updateUserPayload)
+	ttl := p.mailCfg.PasswordResetTimeoutDuration()
+	token, err := auth.NewPasswordResetToken(auth.PopulateContext(ctx, user), p.cfg.Secret, ttl)
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedUser)
+	link := strings.TrimSuffix(p.mailCfg.BaseURL, "/") + "/reset-password?token=" + url.QueryEscape(token)
+	msg := mail.NewPasswordResetMessage(user.Email, user.FullName, user.Username, link, ttl)
+	// The email is sent in the background, so the response time does not
+	// reveal whether the address is registered either.
+	go func() {
+		if err := p.mailer.Send(context.Background(), msg); err != nil {
+			log.Errorf("failed to send password reset email to user %d: %+v", user.ID, err)
+		}
+	}()
 }
 
-// DeleteUserHandler deletes a user
-func (p *APIController) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+// ResetPasswordHandler sets a new password for a user, using the token
+// emailed by ForgotPasswordHandler. All sessions of the user are revoked.
+func (p *APIController) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	vars := mux.Vars(r)
-	userID, ok := vars["userID"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "no user ID specified",
-		})
+	if p.mailer == nil {
+		handleError(ctx, w, errPasswordResetDisabled)
 		return
 	}
-	userIDInt, err := strconv.ParseInt(userID, 10, 64)
+	var resetParams params.ResetPasswordParams
+	if err := json.NewDecoder(r.Body).Decode(&resetParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+	if err := resetParams.Validate(); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+
+	claims, err := auth.ParsePasswordResetToken(resetParams.Token, p.cfg.Secret)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "invalid user ID",
-		})
+		handleError(ctx, w, err)
 		return
 	}
-	err = p.manager.Delete(ctx, uint(userIDInt))
+	if err := p.manager.ValidateToken(claims.ID); err != nil {
+		handleError(ctx, w, auth.ErrInvalidPasswordResetToken)
+		return
+	}
+	user, err := p.manager.Get(auth.GetAdminContext(), claims.UserID())
 	if err != nil {
-		handleError(w, err)
+		if errors.Is(err, gErrors.ErrNotFound) {
+			err = auth.ErrInvalidPasswordResetToken
+		}
+		handleError(ctx, w, err)
+		return
+	}
+	// The security stamp changes along with the password, so the token
+	// stops working once used, or once the password is changed otherwise.
+	if user.SecurityStamp != claims.SecurityStamp || !user.Enabled || user.AuthProvider != "" {
+		handleError(ctx, w, auth.ErrInvalidPasswordResetToken)
+		return
+	}
+	// A password that does not follow the policy does not use up the
+	// token.
+	if err := p.manager.CheckPassword(user.ID, resetParams.Password); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	// Blacklisting fails if a concurrent request already used the token.
+	if err := p.manager.BlacklistToken(claims.ID, claims.ExpiresAt.Unix()); err != nil {
+		handleError(ctx, w, auth.ErrInvalidPasswordResetToken)
 		return
 	}
-}
-
-//
-// Teams handlers
-//
 
-// NewUserHandler creates a new team
-func (p *APIController) NewTeamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx = auth.PopulateContext(ctx, user)
+	if _, err := p.manager.Update(ctx, user.ID, params.UpdateUserPayload{Password: &resetParams.Password}); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if _, err := p.sessionManager.RevokeAll(ctx, user.ID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	// Users that proved they own the email address of the account are
+	// not kept locked out.
+	if err := p.loginThrottler.Unlock(auth.GetAdminContext(), user.ID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+var errEmailChangeDisabled = gErrors.NewNotFoundError("email confirmation is not enabled")
+
+// ConfirmEmailHandler changes the email address of a user, using the token
+// emailed to the new address by UpdateProfileHandler.
+func (p *APIController) ConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	var newTeamParams params.NewTeamParams
+	if p.mailer == nil || !p.mailCfg.ConfirmEmailChange {
+		handleError(ctx, w, errEmailChangeDisabled)
+		return
+	}
+	var confirmParams params.ConfirmEmailParams
+	if err := json.NewDecoder(r.Body).Decode(&confirmParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+	if err := confirmParams.Validate(); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&newTeamParams); err != nil {
-		handleError(w, gErrors.ErrBadRequest)
+	claims, err := auth.ParseEmailChangeToken(confirmParams.Token, p.cfg.Secret)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.manager.ValidateToken(claims.ID); err != nil {
+		handleError(ctx, w, auth.ErrInvalidEmailChangeToken)
+		return
+	}
+	user, err := p.manager.Get(auth.GetAdminContext(), claims.UserID())
+	if err != nil {
+		if errors.Is(err, gErrors.ErrNotFound) {
+			err = auth.ErrInvalidEmailChangeToken
+		}
+		handleError(ctx, w, err)
+		return
+	}
+	if user.SecurityStamp != claims.SecurityStamp || user.Email != claims.PreviousEmail || !user.Enabled || user.AuthProvider != "" {
+		handleError(ctx, w, auth.ErrInvalidEmailChangeToken)
+		return
+	}
+	// Blacklisting fails if a concurrent request already used the token.
+	if err := p.manager.BlacklistToken(claims.ID, claims.ExpiresAt.Unix()); err != nil {
+		handleError(ctx, w, auth.ErrInvalidEmailChangeToken)
 		return
 	}
 
-	newTeam, err := p.teamManager.Create(ctx, newTeamParams.Name)
+	ctx = auth.PopulateContext(ctx, user)
+	if _, err := p.manager.Update(ctx, user.ID, params.UpdateUserPayload{Email: &claims.Email}); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// RegisterHandler creates an account for a user registering themselves
+func (p *APIController) RegisterHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var registerParams params.RegisterParams
+	if err := json.NewDecoder(r.Body).Decode(&registerParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+
+	// Registering with a taken username or email address counts as a
+	// failed login from the client address, so it can not be used to
+	// probe which accounts exist at full speed.
+	ip := auth.ClientIP(ctx)
+	if err := p.loginThrottler.CheckAddress(ctx, ip); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	newUser, err := p.registrations.Register(ctx, registerParams)
 	if err != nil {
-		handleError(w, err)
+		if gErrors.Code(err) == gErrors.CodeAccountExists {
+			if err := p.loginThrottler.FailedFromAddress(ctx, ip); err != nil {
+				log.Errorf("request %s: failed to record failed registration: %+v", auth.RequestID(ctx), err)
+			}
+		}
+		handleError(ctx, w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(newTeam)
+	json.NewEncoder(w).Encode(newUser)
 }
 
-// DeleteTeamHandler deletes a team
-func (p *APIController) DeleteTeamHandler(w http.ResponseWriter, r *http.Request) {
+const (
+	// oidcStateCookie holds the sealed OIDC login state while the user
+	// logs in with the identity provider.
+	oidcStateCookie = "gopherbin_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+	oidcCookiePath  = "/api/v1/auth/oidc/"
+)
+
+var errOIDCDisabled = gErrors.NewNotFoundError("OIDC login is not enabled")
+
+// OIDCLoginHandler redirects the user to the OIDC identity provider
+func (p *APIController) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if p.oidc == nil {
+		handleError(ctx, w, errOIDCDisabled)
+		return
+	}
+	state, err := oidc.NewLoginState()
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if cookie := r.URL.Query().Get("cookie"); cookie != "" {
+		state.Cookie, err = strconv.ParseBool(cookie)
+		if err != nil {
+			handleError(ctx, w, gErrors.NewValidationError(gErrors.FieldError{Field: "cookie", Code: gErrors.CodeValidationFailed, Message: "cookie must be true or false"}))
+			return
+		}
+	}
+	authURL, err := p.oidc.AuthCodeURL(ctx, state)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	sealed, err := state.Seal(p.cfg.Secret, oidcStateTTL)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    sealed,
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   p.oidc.SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler completes the OIDC login the same way LoginHandler
+// completes a password login
+func (p *APIController) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if p.oidc == nil {
+		handleError(ctx, w, errOIDCDisabled)
+		return
+	}
+	// The login state may only be used once.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     oidcCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   p.oidc.SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	if idpErr := query.Get("error"); idpErr != "" {
+		err := gErrors.WithCode(
+			gErrors.NewUnauthorizedError(fmt.Sprintf("the identity provider rejected the login: %s %s", idpErr, query.Get("error_description"))),
+			gErrors.CodeSSOFailed)
+		handleError(ctx, w, err)
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		handleError(ctx, w, gErrors.WithCode(gErrors.NewUnauthorizedError("missing login state, please try again"), gErrors.CodeSSOFailed))
+		return
+	}
+	state, err := oidc.OpenLoginState(cookie.Value, p.cfg.Secret, query.Get("state"))
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+
+	identity, err := p.oidc.Login(ctx, query.Get("code"), state)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	ctx, err = p.manager.AuthenticateExternal(ctx, identity)
+	if err != nil {
+		p.auditFailedLogin(ctx, identity.Username, loginMethodOIDC, err)
+		handleError(ctx, w, err)
+		return
+	}
+	response, err := p.completeLogin(ctx, w, r, auth.AllScopes(), state.Cookie)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if !response.TwoFactorRequired {
+		p.auditLogin(ctx, loginMethodOIDC)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ProxyLoginHandler returns a jwt token for a user authenticated by a
+// trusted reverse proxy, so the web UI needs no separate login. Two-factor
+// authentication is checked the same way as by LoginHandler.
+func (p *APIController) ProxyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if auth.AuthMethod(ctx) != auth.AuthMethodProxy {
+		handleError(ctx, w, gErrors.NewUnauthorizedError("the request was not authenticated by a trusted proxy"))
+		return
+	}
+	// The body is optional.
+	var loginInfo params.ProxyLoginParams
+	if err := json.NewDecoder(r.Body).Decode(&loginInfo); err != nil && err != io.EOF {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+	response, err := p.completeLogin(ctx, w, r, auth.AllScopes(), loginInfo.Cookie)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if !response.TwoFactorRequired {
+		p.auditLogin(ctx, loginMethodProxy)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// LogoutHandler will revoke the session of the token. Cookie sessions
+// must be logged out with POST, so the request carries the CSRF token, and
+// get their cookies cleared.
+func (p *APIController) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if auth.AuthMethod(ctx) != auth.AuthMethodJWT {
+		handleError(ctx, w, gErrors.NewBadRequestError("only sessions obtained by logging in can be logged out, API tokens must be revoked instead"))
+		return
+	}
+	claim := auth.JWTClaim(ctx)
+	cookieSession := claim.CSRFToken != ""
+	if cookieSession && r.Method != http.MethodPost {
+		handleError(ctx, w, gErrors.NewBadRequestError("cookie sessions must be logged out using POST"))
+		return
+	}
+	if err := p.sessionManager.Revoke(ctx, auth.UserID(ctx), claim.TokenID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if cookieSession {
+		// The browser may keep sending the cookie, so the token itself
+		// is rejected from now on as well.
+		if err := p.manager.BlacklistToken(claim.TokenID, claim.ExpiresAt.Unix()); err != nil {
+			handleError(ctx, w, err)
+			return
+		}
+		p.setSessionCookies(w, "", "", time.Unix(0, 0))
+	}
+	p.recorder.Record(ctx, params.AuditEvent{
+		Action:     audit.ActionLogout,
+		TargetType: audit.TargetUser,
+		TargetID:   fmt.Sprintf("%d", auth.UserID(ctx)),
+	})
+}
+
+// PasteViewHandler returns details about a single paste
+func (p *APIController) PasteViewHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
-	teamName, ok := vars["teamName"]
+	pasteID, ok := vars["pasteID"]
+
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "no team name specified",
-		})
+		handleError(ctx, w, gErrors.ErrPasteNotFound)
 		return
 	}
-	err := p.teamManager.Delete(ctx, teamName)
+
+	pasteInfo, err := p.paster.Get(ctx, pasteID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pasteInfo)
+}
+
+// PasteDownloadHandler serves a paste as a downloadable file.
+func (p *APIController) PasteDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	pasteID, ok := vars["pasteID"]
+
+	if !ok {
+		handleError(ctx, w, gErrors.ErrPasteNotFound)
+		return
+	}
+
+	pasteInfo, err := p.paster.Get(ctx, pasteID)
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
+
+	w.Header().Set("Access-Control-Expose-Headers", "x-suggested-filename, Content-Disposition")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", pasteInfo.Name))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("x-suggested-filename", pasteInfo.Name)
+	w.Write(pasteInfo.Data)
 }
 
-// DeleteTeamHandler deletes a team
-func (p *APIController) GetTeamHandler(w http.ResponseWriter, r *http.Request) {
+// PublicPasteViewHandler returns details about a single public paste
+func (p *APIController) PublicPasteViewHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
-	teamName, ok := vars["teamName"]
+	pasteID, ok := vars["pasteID"]
+
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "no team name specified",
-		})
+		handleError(ctx, w, gErrors.ErrPasteNotFound)
 		return
 	}
-	team, err := p.teamManager.Get(ctx, teamName)
+
+	pasteInfo, err := p.paster.GetPublicPaste(ctx, pasteID)
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(team)
+	json.NewEncoder(w).Encode(pasteInfo)
 }
 
-func (p *APIController) ListTeamsHandler(w http.ResponseWriter, r *http.Request) {
+// PasteListHandler returns a list of pastes
+func (p *APIController) PasteListHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	page := r.URL.Query().Get("page")
 	pageInt, _ := strconv.ParseInt(page, 10, 64)
@@ -611,82 +992,1416 @@ func (p *APIController) ListTeamsHandler(w http.ResponseWriter, r *http.Request)
 		maxResults = 50
 	}
 
-	res, err := p.teamManager.List(ctx, pageInt, maxResults)
+	res, err := p.paster.List(ctx, pageInt, maxResults, tagsFromQuery(r))
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
-func (p *APIController) AddTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+// SearchPasteHandler searches for pastes by name
+func (p *APIController) SearchPasteHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	vars := mux.Vars(r)
-	teamName, ok := vars["teamName"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "no team name specified",
-		})
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		handleError(ctx, w, gErrors.NewBadRequestError("no search query specified"))
 		return
 	}
 
-	var addTeamMemberParams params.UserActionRequest
-	if err := json.NewDecoder(r.Body).Decode(&addTeamMemberParams); err != nil {
-		handleError(w, gErrors.ErrBadRequest)
-		return
+	page := r.URL.Query().Get("page")
+	pageInt, _ := strconv.ParseInt(page, 10, 64)
+	maxResultsOpt := r.URL.Query().Get("max_results")
+	maxResults, _ := strconv.ParseInt(maxResultsOpt, 10, 64)
+	if maxResults == 0 {
+		maxResults = 50
 	}
 
-	newMember, err := p.teamManager.AddMember(ctx, teamName, addTeamMemberParams.UserID)
+	res, err := p.paster.Search(ctx, query, pageInt, maxResults, tagsFromQuery(r))
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(newMember)
-
+	json.NewEncoder(w).Encode(res)
 }
 
-func (p *APIController) ListTeamMembersHandler(w http.ResponseWriter, r *http.Request) {
+// DeletePasteHandler deletes a single paste
+func (p *APIController) DeletePasteHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
-	teamName, ok := vars["teamName"]
+	pasteID, ok := vars["pasteID"]
 	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "no team name specified",
-		})
+		handleError(ctx, w, gErrors.NewBadRequestError("no paste ID specified"))
+		return
+	}
+	if err := p.paster.Delete(ctx, pasteID); err != nil {
+		handleError(ctx, w, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
 
-	res, err := p.teamManager.ListMembers(ctx, teamName)
+// userFilterFromQuery parses the user filters in the query string. The
+// enabled and admin filters are booleans, last login times are RFC 3339
+// formatted.
+func userFilterFromQuery(r *http.Request) (adminCommon.UserFilter, error) {
+	query := r.URL.Query()
+	filter := adminCommon.UserFilter{
+		Query: strings.TrimSpace(query.Get("q")),
+	}
+
+	var fields []gErrors.FieldError
+	for _, param := range []struct {
+		name string
+		dest **bool
+	}{{"enabled", &filter.Enabled}, {"admin", &filter.IsAdmin}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			fields = append(fields, gErrors.FieldError{Field: param.name, Code: gErrors.CodeValidationFailed, Message: param.name + " must be true or false"})
+			continue
+		}
+		*param.dest = &b
+	}
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{{"last_login_since", &filter.LastLoginSince}, {"last_login_before", &filter.LastLoginBefore}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		tm, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fields = append(fields, gErrors.FieldError{Field: param.name, Code: gErrors.CodeValidationFailed, Message: param.name + " must be an RFC 3339 timestamp"})
+			continue
+		}
+		*param.dest = &tm
+	}
+	if len(fields) > 0 {
+		return adminCommon.UserFilter{}, gErrors.NewValidationError(fields...)
+	}
+	return filter, nil
+}
+
+// UserListHandler lists the users matching the search and filters in the
+// query string
+func (p *APIController) UserListHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsSuperUser(ctx) && !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	filter, err := userFilterFromQuery(r)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+
+	page := r.URL.Query().Get("page")
+	pageInt, _ := strconv.ParseInt(page, 10, 64)
+	maxResultsOpt := r.URL.Query().Get("max_results")
+	maxResults, _ := strconv.ParseInt(maxResultsOpt, 10, 64)
+	if maxResults == 0 {
+		maxResults = 50
+	}
+
+	res, err := p.manager.List(ctx, filter, pageInt, maxResults)
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
 }
 
-func (p *APIController) RemoveTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+// CreatePasteHandler creates a new paste
+func (p *APIController) CreatePasteHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	vars := mux.Vars(r)
-	teamName, teamOK := vars["teamName"]
-	member, memberOK := vars["member"]
-	if !teamOK || !memberOK {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(responses.APIErrorResponse{
-			Error:   "Bad Request",
-			Details: "no team or member name specified",
-		})
+
+	var pasteData params.Paste
+	if err := json.NewDecoder(r.Body).Decode(&pasteData); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
 		return
 	}
-	err := p.teamManager.RemoveMember(ctx, teamName, member)
+
+	pasteInfo, err := p.paster.Create(
+		ctx, pasteData.Data, pasteData.Name,
+		pasteData.Language, pasteData.Description,
+		pasteData.Expires, pasteData.Public, "",
+		pasteData.Metadata, pasteData.MaxAccesses, pasteData.Tags)
 	if err != nil {
-		handleError(w, err)
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pasteInfo)
+}
+
+// UpdatePasteHandler
+func (p *APIController) UpdatePasteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	pasteID, ok := vars["pasteID"]
+	if !ok {
+		handleError(ctx, w, gErrors.NewBadRequestError("no paste ID specified"))
+		return
+	}
+
+	var pasteData params.UpdatePasteParams
+	if err := json.NewDecoder(r.Body).Decode(&pasteData); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+
+	if pasteData.Public == nil && pasteData.Tags == nil {
+		handleError(ctx, w, gErrors.NewBadRequestError("nothing to update"))
+		return
+	}
+
+	var pasteInfo params.Paste
+	var err error
+	if pasteData.Public != nil {
+		pasteInfo, err = p.paster.SetPrivacy(ctx, pasteID, *pasteData.Public)
+		if err != nil {
+			handleError(ctx, w, err)
+			return
+		}
+	}
+	if pasteData.Tags != nil {
+		pasteInfo, err = p.paster.SetTags(ctx, pasteID, pasteData.Tags)
+		if err != nil {
+			handleError(ctx, w, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pasteInfo)
+}
+
+// TagListHandler returns the tags visible to the user, along with
+// the number of pastes each tag is attached to.
+func (p *APIController) TagListHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	res, err := p.paster.ListTags(ctx)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// SharePasteHandler shares a paste with a user.
+func (p *APIController) SharePasteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	pasteID, ok := vars["pasteID"]
+	if !ok {
+		handleError(ctx, w, gErrors.NewBadRequestError("no paste ID specified"))
+		return
+	}
+
+	var userID params.UserActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&userID); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+
+	targetUser, err := p.paster.ShareWithUser(ctx, pasteID, userID.UserID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(targetUser)
+}
+
+// SharePasteHandler shares a paste with a user.
+func (p *APIController) UnsharePasteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	pasteID, ok := vars["pasteID"]
+	userID, userOK := vars["userID"]
+	if !ok || !userOK {
+		handleError(ctx, w, gErrors.NewBadRequestError("user ID or paste ID is missing"))
+		return
+	}
+
+	if err := p.paster.UnshareWithUser(ctx, pasteID, userID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// UserListHandler handles the list of pastes
+func (p *APIController) ListSharesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	pasteID, ok := vars["pasteID"]
+	if !ok {
+		handleError(ctx, w, gErrors.NewBadRequestError("no paste ID specified"))
+		return
+	}
+
+	res, err := p.paster.ListShares(ctx, pasteID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+//
+// Profile handlers
+//
+
+// ProfileHandler returns the profile of the current user
+func (p *APIController) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	profile, err := p.manager.Profile(ctx)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// UpdateProfileHandler changes the full name and email address of the
+// current user. If email changes must be confirmed, the new address is
+// only set once the user opens the link sent to it.
+func (p *APIController) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var update params.UpdateProfileParams
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+	if err := update.Validate(); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	user, err := p.manager.Get(ctx, auth.UserID(ctx))
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+
+	var pendingEmail string
+	if update.Email != nil && *update.Email != user.Email {
+		// The email address of users of an external identity provider
+		// is updated from the provider.
+		if user.AuthProvider != "" {
+			handleError(ctx, w, gErrors.NewBadRequestError("the email address of this account is managed by %s", user.AuthProvider))
+			return
+		}
+		if p.mailer != nil && p.mailCfg.ConfirmEmailChange {
+			if err := p.sendEmailChangeLink(ctx, user, *update.Email); err != nil {
+				handleError(ctx, w, err)
+				return
+			}
+			pendingEmail = *update.Email
+			update.Email = nil
+		}
+	}
+	if update.FullName != nil || update.Email != nil {
+		payload := params.UpdateUserPayload{FullName: update.FullName, Email: update.Email}
+		if _, err := p.manager.Update(ctx, user.ID, payload); err != nil {
+			handleError(ctx, w, err)
+			return
+		}
+	}
+
+	profile, err := p.manager.Profile(ctx)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	profile.PendingEmail = pendingEmail
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// sendEmailChangeLink emails the link confirming the new email address
+// of user. Addresses already in use are refused right away, rather than
+// when the link is opened.
+func (p *APIController) sendEmailChangeLink(ctx context.Context, user params.Users, email string) error {
+	if _, err := p.manager.GetByEmail(auth.GetAdminContext(), email); err == nil {
+		return gErrors.WithCode(gErrors.NewDuplicateUserError("email address already in use"), gErrors.CodeEmailInUse)
+	} else if !errors.Is(err, gErrors.ErrNotFound) {
+		return err
+	}
+
+	ttl := p.mailCfg.EmailChangeTimeoutDuration()
+	token, err := auth.NewEmailChangeToken(auth.PopulateContext(ctx, user), p.cfg.Secret, user.Email, email, ttl)
+	if err != nil {
+		return err
+	}
+	link := strings.TrimSuffix(p.mailCfg.BaseURL, "/") + "/confirm-email?token=" + url.QueryEscape(token)
+	msg := mail.NewEmailChangeMessage(email, user.FullName, user.Username, link, ttl)
+	if err := p.mailer.Send(ctx, msg); err != nil {
+		return errors.Wrap(err, "sending email confirmation link")
+	}
+	return nil
+}
+
+// ChangePasswordHandler changes the password of the current user, who
+// must send their current password as well. All other sessions of the
+// user are revoked. A client logged in with a session gets a new one in
+// the response, as the session used to make the request is revoked too.
+func (p *APIController) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var change params.ChangePasswordParams
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+	if err := change.Validate(); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	// Guessing the current password is throttled the same way as
+	// logging in, in case someone else got hold of a session.
+	username := auth.Username(ctx)
+	ip := auth.ClientIP(ctx)
+	if err := p.loginThrottler.Check(ctx, username, ip); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	user, err := p.manager.ChangePassword(ctx, change.CurrentPassword, change.NewPassword)
+	if err != nil {
+		if gErrors.Code(errors.Cause(err)) == gErrors.CodeWrongPassword {
+			p.recordFailedLogin(ctx, username, ip)
+		}
+		handleError(ctx, w, err)
+		return
+	}
+	if _, err := p.sessionManager.RevokeAll(ctx, user.ID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+
+	var response params.JWTResponse
+	if auth.AuthMethod(ctx) == auth.AuthMethodJWT {
+		// Revoking the sessions rotated the security stamp again.
+		user, err = p.manager.Get(ctx, user.ID)
+		if err != nil {
+			handleError(ctx, w, err)
+			return
+		}
+		claims := auth.JWTClaim(ctx)
+		ctx = auth.PopulateContext(ctx, user)
+		if err := p.startSession(ctx, w, r, claims.Scopes, claims.CSRFToken != "", &response); err != nil {
+			handleError(ctx, w, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ExportProfileHandler streams a zip archive with the pastes of the
+// current user and a manifest of their metadata
+func (p *APIController) ExportProfileHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	profile, err := p.manager.Profile(ctx)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	p.writeDataExport(ctx, w, profile.User, profile.Teams)
+}
+
+//
+// Admin user handlers
+//
+
+// NewUserHandler creates a new user
+func (p *APIController) NewUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var newUserParams params.NewUserParams
+	if err := json.NewDecoder(r.Body).Decode(&newUserParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+
+	newUser, err := p.manager.Create(ctx, newUserParams)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUser)
+}
+
+// UpdateUserHandler will update a user. Only admins may use it, users
+// change their own details through UpdateProfileHandler and
+// ChangePasswordHandler, which ask for the current password and confirm
+// new email addresses.
+func (p *APIController) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	vars := mux.Vars(r)
+	userID, ok := vars["userID"]
+	if !ok {
+		handleError(ctx, w, gErrors.NewBadRequestError("no user ID specified"))
+		return
+	}
+
+	userIDInt, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		handleError(ctx, w, gErrors.NewBadRequestError("invalid user ID"))
+		return
+	}
+	var updateUserPayload params.UpdateUserPayload
+	if err := json.NewDecoder(r.Body).Decode(&updateUserPayload); err != nil {
+		handleError(ctx, w, gErrors.NewBadRequestError("failed to unmarshal request: %v", err))
+		return
+	}
+
+	updatedUser, err := p.manager.Update(ctx, uint(userIDInt), updateUserPayload)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updatedUser)
+}
+
+// UserDetailsHandler returns the details of a user, along with their
+// active sessions
+func (p *APIController) UserDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	details, err := p.manager.Details(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	sessions, err := p.sessionManager.List(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	details.Sessions = sessions
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
+
+// ExportUserHandler streams the data export of a user, as users get it
+// from ExportProfileHandler
+func (p *APIController) ExportUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	details, err := p.manager.Details(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	p.writeDataExport(ctx, w, details.User, details.Teams)
+}
+
+// EnableUserHandler enables a user
+func (p *APIController) EnableUserHandler(w http.ResponseWriter, r *http.Request) {
+	p.setUserEnabled(w, r, true)
+}
+
+// DisableUserHandler disables a user. Disabled users are logged out
+// everywhere.
+func (p *APIController) DisableUserHandler(w http.ResponseWriter, r *http.Request) {
+	p.setUserEnabled(w, r, false)
+}
+
+// setUserEnabled enables or disables the user in the request path, and
+// returns the updated user
+func (p *APIController) setUserEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	ctx := r.Context()
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if enabled {
+		err = p.manager.Enable(ctx, userID)
+	} else {
+		err = p.manager.Disable(ctx, userID)
+	}
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	user, err := p.manager.Get(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// DeleteUserHandler deletes a user. The transfer_to query parameter names
+// the user that gets their pastes, teams and shares, and dry_run reports
+// what would be deleted or transferred without deleting the user.
+func (p *APIController) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	userID, ok := vars["userID"]
+	if !ok {
+		handleError(ctx, w, gErrors.NewBadRequestError("no user ID specified"))
+		return
+	}
+	userIDInt, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		handleError(ctx, w, gErrors.NewBadRequestError("invalid user ID"))
+		return
+	}
+	opts := adminCommon.DeleteUserOptions{
+		TransferTo: strings.TrimSpace(r.URL.Query().Get("transfer_to")),
+	}
+	if dryRun := r.URL.Query().Get("dry_run"); dryRun != "" {
+		opts.DryRun, err = strconv.ParseBool(dryRun)
+		if err != nil {
+			handleError(ctx, w, gErrors.NewValidationError(gErrors.FieldError{Field: "dry_run", Code: gErrors.CodeValidationFailed, Message: "dry_run must be true or false"}))
+			return
+		}
+	}
+	report, err := p.manager.Delete(ctx, uint(userIDInt), opts)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+//
+// Teams handlers
+//
+
+// NewUserHandler creates a new team
+func (p *APIController) NewTeamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var newTeamParams params.NewTeamParams
+
+	if err := json.NewDecoder(r.Body).Decode(&newTeamParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+
+	newTeam, err := p.teamManager.Create(ctx, newTeamParams.Name)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTeam)
+}
+
+// DeleteTeamHandler deletes a team
+func (p *APIController) DeleteTeamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	teamName, ok := vars["teamName"]
+	if !ok {
+		handleError(ctx, w, gErrors.NewBadRequestError("no team name specified"))
+		return
+	}
+	err := p.teamManager.Delete(ctx, teamName)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// DeleteTeamHandler deletes a team
+func (p *APIController) GetTeamHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	teamName, ok := vars["teamName"]
+	if !ok {
+		handleError(ctx, w, gErrors.NewBadRequestError("no team name specified"))
+		return
+	}
+	team, err := p.teamManager.Get(ctx, teamName)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(team)
+}
+
+func (p *APIController) ListTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	page := r.URL.Query().Get("page")
+	pageInt, _ := strconv.ParseInt(page, 10, 64)
+	maxResultsOpt := r.URL.Query().Get("max_results")
+	maxResults, _ := strconv.ParseInt(maxResultsOpt, 10, 64)
+	if maxResults == 0 {
+		maxResults = 50
+	}
+
+	res, err := p.teamManager.List(ctx, pageInt, maxResults)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (p *APIController) AddTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	teamName, ok := vars["teamName"]
+	if !ok {
+		handleError(ctx, w, gErrors.NewBadRequestError("no team name specified"))
+		return
+	}
+
+	var addTeamMemberParams params.UserActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&addTeamMemberParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+
+	newMember, err := p.teamManager.AddMember(ctx, teamName, addTeamMemberParams.UserID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newMember)
+
+}
+
+func (p *APIController) ListTeamMembersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	teamName, ok := vars["teamName"]
+	if !ok {
+		handleError(ctx, w, gErrors.NewBadRequestError("no team name specified"))
+		return
+	}
+
+	res, err := p.teamManager.ListMembers(ctx, teamName)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (p *APIController) RemoveTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	teamName, teamOK := vars["teamName"]
+	member, memberOK := vars["member"]
+	if !teamOK || !memberOK {
+		handleError(ctx, w, gErrors.NewBadRequestError("no team or member name specified"))
+		return
+	}
+	err := p.teamManager.RemoveMember(ctx, teamName, member)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+//
+// API token handlers
+//
+
+func uintFromVars(r *http.Request, name string) (uint, error) {
+	val, ok := mux.Vars(r)[name]
+	if !ok {
+		return 0, gErrors.NewBadRequestError("no %s specified", name)
+	}
+	parsed, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, gErrors.NewBadRequestError("invalid %s", name)
+	}
+	return uint(parsed), nil
+}
+
+func (p *APIController) writeTokenList(ctx context.Context, w http.ResponseWriter, userID uint) {
+	tokens, err := p.tokenManager.List(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(params.APITokenListResult{Tokens: tokens})
+}
+
+// NewAPITokenHandler creates a personal API token for the current user
+func (p *APIController) NewAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var tokenParams params.NewAPITokenParams
+	if err := json.NewDecoder(r.Body).Decode(&tokenParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+
+	token, err := p.tokenManager.Create(ctx, tokenParams)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token)
+}
+
+// ListAPITokensHandler lists the API tokens of the current user
+func (p *APIController) ListAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p.writeTokenList(ctx, w, auth.UserID(ctx))
+}
+
+// RevokeAPITokenHandler revokes one of the API tokens of the current user
+func (p *APIController) RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	tokenID, err := uintFromVars(r, "tokenID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.tokenManager.Revoke(ctx, auth.UserID(ctx), tokenID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// UserAPITokensHandler lists the API tokens of any user
+func (p *APIController) UserAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if _, err := p.manager.Get(ctx, userID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	p.writeTokenList(ctx, w, userID)
+}
+
+// RevokeUserAPITokenHandler revokes an API token belonging to any user
+func (p *APIController) RevokeUserAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	tokenID, err := uintFromVars(r, "tokenID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.tokenManager.Revoke(ctx, userID, tokenID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+//
+// Client certificate handlers
+//
+
+func (p *APIController) writeCertificateList(ctx context.Context, w http.ResponseWriter, userID uint) {
+	certs, err := p.certManager.List(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(params.ClientCertificateListResult{Certificates: certs})
+}
+
+// NewClientCertificateHandler registers the TLS client certificate the
+// request was made with for the current user. Presenting the certificate
+// proves that the user holds its private key.
+func (p *APIController) NewClientCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var certParams params.NewClientCertificateParams
+	if err := json.NewDecoder(r.Body).Decode(&certParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+
+	var presented *x509.Certificate
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		presented = r.TLS.VerifiedChains[0][0]
+	}
+	cert, err := p.certManager.Create(ctx, presented, certParams)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cert)
+}
+
+// ListClientCertificatesHandler lists the client certificates of the
+// current user
+func (p *APIController) ListClientCertificatesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p.writeCertificateList(ctx, w, auth.UserID(ctx))
+}
+
+// DeleteClientCertificateHandler deletes one of the client certificates
+// of the current user
+func (p *APIController) DeleteClientCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	certID, err := uintFromVars(r, "certID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.certManager.Delete(ctx, auth.UserID(ctx), certID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// UserClientCertificatesHandler lists the client certificates of any user
+func (p *APIController) UserClientCertificatesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if _, err := p.manager.Get(ctx, userID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	p.writeCertificateList(ctx, w, userID)
+}
+
+// DeleteUserClientCertificateHandler deletes a client certificate
+// belonging to any user
+func (p *APIController) DeleteUserClientCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	certID, err := uintFromVars(r, "certID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.certManager.Delete(ctx, userID, certID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+//
+// Session handlers
+//
+
+func (p *APIController) writeSessionList(ctx context.Context, w http.ResponseWriter, userID uint) {
+	sessions, err := p.sessionManager.List(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(params.SessionListResult{Sessions: sessions})
+}
+
+// ListSessionsHandler lists the active sessions of the current user
+func (p *APIController) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	p.writeSessionList(ctx, w, auth.UserID(ctx))
+}
+
+// RevokeSessionHandler revokes one of the sessions of the current user
+func (p *APIController) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sessionID, ok := mux.Vars(r)["sessionID"]
+	if !ok {
+		handleError(ctx, w, gErrors.ErrSessionNotFound)
+		return
+	}
+	if err := p.sessionManager.Revoke(ctx, auth.UserID(ctx), sessionID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// RevokeAllSessionsHandler logs the current user out everywhere,
+// including the session used to make the request
+func (p *APIController) RevokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, err := p.sessionManager.RevokeAll(ctx, auth.UserID(ctx)); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// UserSessionsHandler lists the active sessions of any user
+func (p *APIController) UserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if _, err := p.manager.Get(ctx, userID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	p.writeSessionList(ctx, w, userID)
+}
+
+// RevokeUserSessionHandler revokes a session belonging to any user
+func (p *APIController) RevokeUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	sessionID, ok := mux.Vars(r)["sessionID"]
+	if !ok {
+		handleError(ctx, w, gErrors.ErrSessionNotFound)
+		return
+	}
+	if err := p.sessionManager.Revoke(ctx, userID, sessionID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// RevokeUserSessionsHandler logs any user out everywhere, for example
+// when their account has been compromised
+func (p *APIController) RevokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if _, err := p.manager.Get(ctx, userID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	revoked, err := p.sessionManager.RevokeAll(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	p.auditUserAction(ctx, audit.ActionUserSessionsRevoke, userID, map[string]string{"sessions": fmt.Sprintf("%d", revoked)})
+}
+
+// UnlockUserHandler forgets the failed logins of a user, lifting a lockout
+func (p *APIController) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if _, err := p.manager.Get(ctx, userID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.loginThrottler.Unlock(ctx, userID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	p.auditUserAction(ctx, audit.ActionUserUnlock, userID, nil)
+}
+
+//
+// Audit log handlers
+//
+
+// auditLogFilterFromQuery parses the audit log filters in the query
+// string. Actions may be repeated, or comma separated. Times are RFC 3339
+// formatted.
+func auditLogFilterFromQuery(r *http.Request) (adminCommon.AuditLogFilter, error) {
+	query := r.URL.Query()
+	filter := adminCommon.AuditLogFilter{
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+		IP:         query.Get("ip"),
+	}
+	for _, actions := range query["action"] {
+		for _, action := range strings.Split(actions, ",") {
+			if action = strings.TrimSpace(action); action != "" {
+				filter.Actions = append(filter.Actions, action)
+			}
+		}
+	}
+
+	var fields []gErrors.FieldError
+	if actorID := query.Get("actor_id"); actorID != "" {
+		id, err := strconv.ParseUint(actorID, 10, 64)
+		if err != nil {
+			fields = append(fields, gErrors.FieldError{Field: "actor_id", Code: gErrors.CodeValidationFailed, Message: "actor_id must be a user ID"})
+		} else {
+			uid := uint(id)
+			filter.ActorID = &uid
+		}
+	}
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		tm, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			fields = append(fields, gErrors.FieldError{Field: param.name, Code: gErrors.CodeValidationFailed, Message: param.name + " must be an RFC 3339 timestamp"})
+			continue
+		}
+		*param.dest = &tm
+	}
+	if filter.Since != nil && filter.Until != nil && !filter.Until.After(*filter.Since) {
+		fields = append(fields, gErrors.FieldError{Field: "until", Code: gErrors.CodeValidationFailed, Message: "until must be after since"})
+	}
+	if len(fields) > 0 {
+		return adminCommon.AuditLogFilter{}, gErrors.NewValidationError(fields...)
+	}
+	return filter, nil
+}
+
+// AuditLogHandler lists the audit log events matching the filters in the
+// query string, newest first
+func (p *APIController) AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	filter, err := auditLogFilterFromQuery(r)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	page := r.URL.Query().Get("page")
+	pageInt, _ := strconv.ParseInt(page, 10, 64)
+	maxResultsOpt := r.URL.Query().Get("max_results")
+	maxResults, _ := strconv.ParseInt(maxResultsOpt, 10, 64)
+	if maxResults == 0 {
+		maxResults = 50
+	}
+
+	res, err := p.auditLog.List(ctx, filter, pageInt, maxResults)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// AuditLogExportHandler exports the audit log events matching the filters
+// in the query string as JSON lines, oldest first
+func (p *APIController) AuditLogExportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	filter, err := auditLogFilterFromQuery(r)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=audit-%s.jsonl", time.Now().UTC().Format("20060102T150405Z")))
+	if err := p.auditLog.Export(ctx, filter, w); err != nil {
+		// The response has already started, so the error can not be
+		// sent to the client. The export ends early instead.
+		log.Errorf("request %s: failed to export audit log: %+v", auth.RequestID(ctx), err)
+	}
+}
+
+//
+// Registration handlers
+//
+
+// ListInvitesHandler lists the invite codes
+func (p *APIController) ListInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	invites, err := p.registrations.ListInvites(ctx)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(params.InviteListResult{Invites: invites})
+}
+
+// NewInviteHandler creates a new invite code
+func (p *APIController) NewInviteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var inviteParams params.NewInviteParams
+	if err := json.NewDecoder(r.Body).Decode(&inviteParams); err != nil {
+		handleError(ctx, w, gErrors.ErrBadRequest)
+		return
+	}
+	invite, err := p.registrations.CreateInvite(ctx, inviteParams)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invite)
+}
+
+// DeleteInviteHandler deletes an invite code
+func (p *APIController) DeleteInviteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	inviteID, err := uintFromVars(r, "inviteID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.registrations.DeleteInvite(ctx, inviteID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// PendingUsersHandler lists the users awaiting approval
+func (p *APIController) PendingUsersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	page := r.URL.Query().Get("page")
+	pageInt, _ := strconv.ParseInt(page, 10, 64)
+	maxResultsOpt := r.URL.Query().Get("max_results")
+	maxResults, _ := strconv.ParseInt(maxResultsOpt, 10, 64)
+	if maxResults == 0 {
+		maxResults = 50
+	}
+
+	res, err := p.registrations.ListPending(ctx, pageInt, maxResults)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// ApproveUserHandler enables the account of a user awaiting approval
+func (p *APIController) ApproveUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	user, err := p.registrations.Approve(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// RejectUserHandler deletes the account of a user awaiting approval
+func (p *APIController) RejectUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.registrations.Reject(ctx, userID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+//
+// Two-factor authentication handlers
+//
+
+// decodeTwoFactorCode decodes and validates the two-factor code sent in
+// the request body
+func decodeTwoFactorCode(r *http.Request) (string, error) {
+	var codeParams params.TwoFactorCodeParams
+	if err := json.NewDecoder(r.Body).Decode(&codeParams); err != nil {
+		return "", gErrors.ErrBadRequest
+	}
+	if err := codeParams.Validate(); err != nil {
+		return "", err
+	}
+	return codeParams.Code, nil
+}
+
+// TwoFactorStatusHandler returns the two-factor settings of the current user
+func (p *APIController) TwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	status, err := p.twoFactorManager.Status(ctx, auth.UserID(ctx))
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// EnrollTwoFactorHandler generates a new TOTP secret for the current user
+func (p *APIController) EnrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	enrollment, err := p.twoFactorManager.Enroll(ctx)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// ConfirmTwoFactorHandler enables two-factor authentication for the
+// current user, and returns their recovery codes
+func (p *APIController) ConfirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	code, err := decodeTwoFactorCode(r)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	codes, err := p.twoFactorManager.Confirm(ctx, code)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes)
+}
+
+// DisableTwoFactorHandler disables two-factor authentication for the
+// current user
+func (p *APIController) DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	code, err := decodeTwoFactorCode(r)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.twoFactorManager.Disable(ctx, code); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+}
+
+// RecoveryCodesHandler replaces the recovery codes of the current user
+func (p *APIController) RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	code, err := decodeTwoFactorCode(r)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	codes, err := p.twoFactorManager.RegenerateRecoveryCodes(ctx, code)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes)
+}
+
+// UserTwoFactorHandler returns the two-factor settings of any user
+func (p *APIController) UserTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !auth.IsAdmin(ctx) {
+		handleError(ctx, w, gErrors.ErrUnauthorized)
+		return
+	}
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if _, err := p.manager.Get(ctx, userID); err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	status, err := p.twoFactorManager.Status(ctx, userID)
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// ResetUserTwoFactorHandler disables two-factor authentication for any
+// user, for example after they lost their authenticator and recovery codes
+func (p *APIController) ResetUserTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, err := uintFromVars(r, "userID")
+	if err != nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err := p.twoFactorManager.Reset(ctx, userID); err != nil {
+		handleError(ctx, w, err)
 		return
 	}
+	p.auditUserAction(ctx, audit.ActionUserTwoFactorReset, userID, nil)
 }
@@ -0,0 +1,210 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopherbin/audit"
+	"gopherbin/auth"
+	"gopherbin/params"
+
+	"github.com/pkg/errors"
+)
+
+// languageExtensions maps the highlightjs class of a language to the
+// extension used for the files of a data export
+var languageExtensions = map[string]string{
+	"bash":       ".sh",
+	"c":          ".c",
+	"clojure":    ".clj",
+	"coffee":     ".coffee",
+	"cpp":        ".cpp",
+	"cs":         ".cs",
+	"css":        ".css",
+	"dart":       ".dart",
+	"diff":       ".diff",
+	"dockerfile": ".dockerfile",
+	"elixir":     ".ex",
+	"erlang":     ".erl",
+	"go":         ".go",
+	"golang":     ".go",
+	"groovy":     ".groovy",
+	"haskell":    ".hs",
+	"html":       ".html",
+	"ini":        ".ini",
+	"java":       ".java",
+	"javascript": ".js",
+	"js":         ".js",
+	"json":       ".json",
+	"kotlin":     ".kt",
+	"lua":        ".lua",
+	"makefile":   ".mk",
+	"markdown":   ".md",
+	"nginx":      ".conf",
+	"objectivec": ".m",
+	"perl":       ".pl",
+	"pgsql":      ".sql",
+	"php":        ".php",
+	"plaintext":  ".txt",
+	"powershell": ".ps1",
+	"properties": ".properties",
+	"protobuf":   ".proto",
+	"python":     ".py",
+	"r":          ".r",
+	"ruby":       ".rb",
+	"rust":       ".rs",
+	"scala":      ".scala",
+	"scss":       ".scss",
+	"shell":      ".sh",
+	"sql":        ".sql",
+	"swift":      ".swift",
+	"tex":        ".tex",
+	"text":       ".txt",
+	"typescript": ".ts",
+	"vim":        ".vim",
+	"xml":        ".xml",
+	"yaml":       ".yaml",
+	"yml":        ".yaml",
+}
+
+// languageExtension returns the file extension of a paste language. The
+// language may either be a highlightjs class or one of the names in
+// LanguageMappings. Unknown languages are exported as text files.
+func languageExtension(language string) string {
+	if class, ok := LanguageMappings[language]; ok {
+		language = class
+	}
+	if ext, ok := languageExtensions[strings.ToLower(strings.TrimSpace(language))]; ok {
+		return ext
+	}
+	return ".txt"
+}
+
+// exportFileName returns the path, inside an export archive, of the file
+// holding the contents of paste. Names already present in used get the
+// paste ID appended, so two pastes never share a file.
+func exportFileName(paste params.Paste, used map[string]bool) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, paste.Name)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	if name == "" {
+		name = paste.PasteID
+	}
+	ext := path.Ext(name)
+	if ext == "" {
+		ext = languageExtension(paste.Language)
+		name += ext
+	}
+	file := path.Join("pastes", name)
+	if used[file] {
+		file = path.Join("pastes", fmt.Sprintf("%s-%s%s", strings.TrimSuffix(name, ext), paste.PasteID, ext))
+	}
+	used[file] = true
+	return file
+}
+
+// writeDataExport streams a zip archive with every paste owned by user to
+// w. The contents of each paste go in a file of their own, and the
+// metadata of all of them in manifest.json, which is written last. The
+// response only starts once the first batch of pastes was loaded, so
+// errors up to that point get the usual error response.
+func (p *APIController) writeDataExport(ctx context.Context, w http.ResponseWriter, user params.Users, teams []params.Teams) {
+	manifest := params.DataExport{
+		ExportedAt: time.Now().UTC(),
+		User:       user,
+		Teams:      teams,
+		Pastes:     []params.ExportedPaste{},
+	}
+	used := map[string]bool{}
+	var archive *zip.Writer
+	startArchive := func() {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": fmt.Sprintf("gopherbin-export-%s.zip", user.Username),
+		}))
+		archive = zip.NewWriter(w)
+	}
+	err := p.paster.Export(ctx, user.ID, func(exported params.ExportedPaste) error {
+		if archive == nil {
+			startArchive()
+		}
+		exported.File = exportFileName(exported.Paste, used)
+		f, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     exported.File,
+			Method:   zip.Deflate,
+			Modified: exported.Paste.CreatedAt,
+		})
+		if err != nil {
+			return errors.Wrap(err, "creating paste file")
+		}
+		if _, err := f.Write(exported.Paste.Data); err != nil {
+			return errors.Wrap(err, "writing paste file")
+		}
+		exported.Paste.Data = nil
+		manifest.Pastes = append(manifest.Pastes, exported)
+		return nil
+	})
+	if err != nil && archive == nil {
+		handleError(ctx, w, err)
+		return
+	}
+	if err == nil {
+		if archive == nil {
+			startArchive()
+		}
+		err = writeManifest(archive, manifest)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		// The response has already started, so the error can not be
+		// sent to the client. The archive is left without its central
+		// directory, which makes the truncated download easy to spot.
+		log.Errorf("request %s: failed to export data of user %d: %+v", auth.RequestID(ctx), user.ID, err)
+		return
+	}
+	p.auditUserAction(ctx, audit.ActionUserExport, user.ID, map[string]string{
+		"pastes": fmt.Sprintf("%d", len(manifest.Pastes)),
+	})
+}
+
+// writeManifest adds the manifest of a data export to archive
+func writeManifest(archive *zip.Writer, manifest params.DataExport) error {
+	f, err := archive.Create("manifest.json")
+	if err != nil {
+		return errors.Wrap(err, "creating manifest")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return errors.Wrap(err, "writing manifest")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package openapi holds the OpenAPI 3 specification of the gopherbin REST API.
+//
+// The specification is maintained by hand in openapi.json. When adding a route
+// in routers.AddAPIURLs or a field to one of the structs in the params package,
+// the specification must be updated as well. The tests in this package will fail
+// otherwise.
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// Spec returns the raw OpenAPI specification document.
+func Spec() []byte {
+	ret := make([]byte, len(spec))
+	copy(ret, spec)
+	return ret
+}
+
+// SpecHandler serves the OpenAPI specification document.
+func SpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
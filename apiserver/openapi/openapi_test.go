@@ -0,0 +1,236 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"gopherbin/apiserver/controllers"
+	"gopherbin/apiserver/openapi"
+	"gopherbin/apiserver/routers"
+)
+
+var httpMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// regexpVar matches gorilla path variables that are used to optionally
+// match a trailing slash, such as {login:login\/?}.
+var regexpVar = regexp.MustCompile(`\{[^:}]+:([A-Za-z0-9_.-]+)\\/\?\}`)
+
+type passthrough struct{}
+
+func (passthrough) Middleware(next http.Handler) http.Handler { return next }
+
+type specDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) specDoc {
+	t.Helper()
+	var doc specDoc
+	if err := json.Unmarshal(openapi.Spec(), &doc); err != nil {
+		t.Fatalf("decoding openapi.json: %v", err)
+	}
+	return doc
+}
+
+func normalizePath(tpl string) string {
+	tpl = regexpVar.ReplaceAllString(tpl, "$1")
+	if len(tpl) > 1 {
+		tpl = strings.TrimSuffix(tpl, "/")
+	}
+	return tpl
+}
+
+// registeredRoutes returns all operations registered by routers.AddAPIURLs,
+// as "METHOD path" strings.
+func registeredRoutes(t *testing.T) map[string]bool {
+	t.Helper()
+	router := mux.NewRouter()
+	han := controllers.NewAPIController(controllers.Deps{})
+	if err := routers.AddAPIURLs(router, han, passthrough{}, passthrough{}); err != nil {
+		t.Fatalf("AddAPIURLs: %v", err)
+	}
+
+	ops := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Catch-all handlers (not found, web UI) do not restrict methods.
+			return nil
+		}
+		for _, method := range methods {
+			if method == http.MethodOptions {
+				continue
+			}
+			ops[method+" "+normalizePath(tpl)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking router: %v", err)
+	}
+	return ops
+}
+
+func TestSpecCoversRegisteredRoutes(t *testing.T) {
+	doc := loadSpec(t)
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item {
+			if httpMethods[method] {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	routes := registeredRoutes(t)
+	for op := range routes {
+		if !documented[op] {
+			t.Errorf("route %q is registered in AddAPIURLs but missing from openapi.json", op)
+		}
+	}
+	for op := range documented {
+		if !routes[op] {
+			t.Errorf("operation %q is documented in openapi.json but not registered in AddAPIURLs", op)
+		}
+	}
+}
+
+// jsonFields returns the JSON field names of every struct declared in the
+// Go files of the supplied directory, keyed by struct name.
+func jsonFields(t *testing.T, dir string) map[string][]string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatalf("listing %s: %v", dir, err)
+	}
+
+	fset := token.NewFileSet()
+	ret := map[string][]string{}
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatalf("parsing %s: %v", path, err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			spec, ok := n.(*ast.TypeSpec)
+			if !ok || !spec.Name.IsExported() {
+				return true
+			}
+			st, ok := spec.Type.(*ast.StructType)
+			if !ok {
+				return true
+			}
+			fields := []string{}
+			for _, field := range st.Fields.List {
+				for _, name := range field.Names {
+					if !name.IsExported() {
+						continue
+					}
+					jsonName := name.Name
+					if field.Tag != nil {
+						tag := reflect.StructTag(strings.Trim(field.Tag.Value, "`")).Get("json")
+						if tagName := strings.Split(tag, ",")[0]; tagName != "" {
+							jsonName = tagName
+						}
+					}
+					if jsonName == "-" {
+						continue
+					}
+					fields = append(fields, jsonName)
+				}
+			}
+			sort.Strings(fields)
+			ret[spec.Name.Name] = fields
+			return true
+		})
+	}
+	return ret
+}
+
+func checkSchema(t *testing.T, doc specDoc, name string, fields []string) {
+	t.Helper()
+	schema, ok := doc.Components.Schemas[name]
+	if !ok {
+		t.Errorf("struct %s has no schema in openapi.json", name)
+		return
+	}
+	for _, field := range fields {
+		if _, ok := schema.Properties[field]; !ok {
+			t.Errorf("field %q of %s is missing from its schema in openapi.json", field, name)
+		}
+	}
+	for prop := range schema.Properties {
+		found := false
+		for _, field := range fields {
+			if field == prop {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("property %q of schema %s does not exist on the struct", prop, name)
+		}
+	}
+}
+
+func TestSpecCoversParams(t *testing.T) {
+	doc := loadSpec(t)
+	for name, fields := range jsonFields(t, filepath.Join("..", "..", "params")) {
+		checkSchema(t, doc, name, fields)
+	}
+}
+
+func TestSpecCoversErrorResponse(t *testing.T) {
+	doc := loadSpec(t)
+	structs := jsonFields(t, filepath.Join("..", "responses"))
+	fields, ok := structs["APIErrorResponse"]
+	if !ok {
+		t.Fatal("APIErrorResponse not found in the responses package")
+	}
+	checkSchema(t, doc, "APIErrorResponse", fields)
+}
+
+func TestSpecHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	openapi.SpecHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: want application/json, got %q", ct)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("response is not valid JSON: %v", err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("openapi version: want 3.0.3, got %v", doc["openapi"])
+	}
+}
@@ -14,6 +14,13 @@
 
 package responses
 
+import (
+	"encoding/json"
+	"net/http"
+
+	gErrors "gopherbin/errors"
+)
+
 // ErrorResponse holds any errors generated during
 // a request
 type ErrorResponse struct {
@@ -24,6 +31,14 @@ type ErrorResponse struct {
 type APIErrorResponse struct {
 	Error   string `json:"error"`
 	Details string `json:"details"`
+	// Code is a stable, machine readable error code. See the Code*
+	// constants in the gopherbin/errors package.
+	Code string `json:"code"`
+	// RequestID identifies the request that generated this error. It is
+	// useful when correlating errors with server logs.
+	RequestID string `json:"request_id,omitempty"`
+	// Fields holds details about the fields that failed validation.
+	Fields []gErrors.FieldError `json:"fields,omitempty"`
 }
 
 var (
@@ -31,15 +46,25 @@ var (
 	NotFoundResponse = APIErrorResponse{
 		Error:   "Not Found",
 		Details: "The resource you are looking for was not found",
+		Code:    gErrors.CodeRouteNotFound,
 	}
 	// UnauthorizedResponse is a canned response for unauthorized access
 	UnauthorizedResponse = APIErrorResponse{
 		Error:   "Not Authorized",
 		Details: "You do not have the required permissions to access this resource",
+		Code:    gErrors.CodeUnauthorized,
 	}
 	// InitializationRequired is returned if gopherbin has not beed properly initialized
 	InitializationRequired = APIErrorResponse{
 		Error:   "init_required",
 		Details: "Missing superuser",
+		Code:    gErrors.CodeInitRequired,
 	}
 )
+
+// WriteError sends an error response to the client
+func WriteError(w http.ResponseWriter, status int, apiErr APIErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErr)
+}
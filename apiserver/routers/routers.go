@@ -23,6 +23,7 @@ import (
 	"github.com/gorilla/mux"
 
 	"gopherbin/apiserver/controllers"
+	"gopherbin/apiserver/openapi"
 	"gopherbin/auth"
 	"gopherbin/webui"
 )
@@ -38,6 +39,9 @@ func AddAPIURLs(router *mux.Router, han *controllers.APIController, authMiddlewa
 	firstRunRouter := apiSubRouter.PathPrefix("/first-run").Subrouter()
 	firstRunRouter.Handle("/", log(os.Stdout, http.HandlerFunc(han.FirstRunHandler))).Methods("POST", "OPTIONS")
 
+	// OpenAPI specification
+	apiSubRouter.Handle("/openapi.json", log(os.Stdout, http.HandlerFunc(openapi.SpecHandler))).Methods("GET", "OPTIONS")
+
 	// Public API endpoints
 	publicRouter := apiSubRouter.PathPrefix("/public").Subrouter()
 	publicRouter.Handle("/paste/{pasteID}", log(os.Stdout, http.HandlerFunc(han.PublicPasteViewHandler))).Methods("GET", "OPTIONS")
@@ -45,83 +49,189 @@ func AddAPIURLs(router *mux.Router, han *controllers.APIController, authMiddlewa
 	// Login
 	authRouter := apiSubRouter.PathPrefix("/auth").Subrouter()
 	authRouter.Handle("/{login:login\\/?}", log(os.Stdout, http.HandlerFunc(han.LoginHandler))).Methods("POST", "OPTIONS")
+	authRouter.Handle("/login/{twofactor:2fa\\/?}", log(os.Stdout, http.HandlerFunc(han.TwoFactorLoginHandler))).Methods("POST", "OPTIONS")
+	// Password reset
+	authRouter.Handle("/password/{forgot:forgot\\/?}", log(os.Stdout, http.HandlerFunc(han.ForgotPasswordHandler))).Methods("POST", "OPTIONS")
+	authRouter.Handle("/password/{reset:reset\\/?}", log(os.Stdout, http.HandlerFunc(han.ResetPasswordHandler))).Methods("POST", "OPTIONS")
+	// Email change confirmation
+	authRouter.Handle("/email/{confirm:confirm\\/?}", log(os.Stdout, http.HandlerFunc(han.ConfirmEmailHandler))).Methods("POST", "OPTIONS")
+	// Registration
+	authRouter.Handle("/{register:register\\/?}", log(os.Stdout, http.HandlerFunc(han.RegisterHandler))).Methods("POST", "OPTIONS")
+	// OpenID Connect login
+	authRouter.Handle("/oidc/{login:login\\/?}", log(os.Stdout, http.HandlerFunc(han.OIDCLoginHandler))).Methods("GET", "OPTIONS")
+	authRouter.Handle("/oidc/{callback:callback\\/?}", log(os.Stdout, http.HandlerFunc(han.OIDCCallbackHandler))).Methods("GET", "OPTIONS")
+	// Reverse proxy login
+	authRouter.Handle("/{proxy:proxy\\/?}", log(os.Stdout, authMiddleware.Middleware(http.HandlerFunc(han.ProxyLoginHandler)))).Methods("POST", "OPTIONS")
 	authRouter.Use(initMiddleware.Middleware)
 
 	// Private API endpoints
 	apiRouter := apiSubRouter.PathPrefix("").Subrouter()
 	apiRouter.Use(initMiddleware.Middleware)
 	apiRouter.Use(authMiddleware.Middleware)
-	// Duplicate the route to allow fetching a paste, both with and without a traling slash.
-	// StrictSlashes generates an extra request. There is no good way to match both cases
-	// where you have a trailing slash and one where you don't.
+
+	// Every route, except logout, requires the token used to make the
+	// request to hold a scope.
+	pasteRead := auth.RequireScope(auth.ScopePasteRead).Middleware
+	pasteWrite := auth.RequireScope(auth.ScopePasteWrite).Middleware
+	share := auth.RequireScope(auth.ScopeShare).Middleware
+	teams := auth.RequireScope(auth.ScopeTeams).Middleware
+	tokens := auth.RequireScope(auth.ScopeTokens).Middleware
+	adminUsers := auth.RequireScope(auth.ScopeAdminUsers).Middleware
+	adminAudit := auth.RequireScope(auth.ScopeAdminAudit).Middleware
+	account := auth.RequireScope(auth.ScopeAccount).Middleware
 
 	// Teams handlers
 	// Remove team member
-	apiRouter.Handle("/teams/{teamName}/members/{member}", log(os.Stdout, http.HandlerFunc(han.RemoveTeamMemberHandler))).Methods("DELETE", "OPTIONS")
-	apiRouter.Handle("/teams/{teamName}/members/{member}/", log(os.Stdout, http.HandlerFunc(han.RemoveTeamMemberHandler))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}/members/{member}", log(os.Stdout, teams(http.HandlerFunc(han.RemoveTeamMemberHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}/members/{member}/", log(os.Stdout, teams(http.HandlerFunc(han.RemoveTeamMemberHandler)))).Methods("DELETE", "OPTIONS")
 	// Add team member
-	apiRouter.Handle("/teams/{teamName}/members", log(os.Stdout, http.HandlerFunc(han.AddTeamMemberHandler))).Methods("POST", "OPTIONS")
-	apiRouter.Handle("/teams/{teamName}/members/", log(os.Stdout, http.HandlerFunc(han.AddTeamMemberHandler))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}/members", log(os.Stdout, teams(http.HandlerFunc(han.AddTeamMemberHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}/members/", log(os.Stdout, teams(http.HandlerFunc(han.AddTeamMemberHandler)))).Methods("POST", "OPTIONS")
 	// List team members
-	apiRouter.Handle("/teams/{teamName}/members", log(os.Stdout, http.HandlerFunc(han.ListTeamMembersHandler))).Methods("POST", "OPTIONS")
-	apiRouter.Handle("/teams/{teamName}/members/", log(os.Stdout, http.HandlerFunc(han.ListTeamMembersHandler))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}/members", log(os.Stdout, teams(http.HandlerFunc(han.ListTeamMembersHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}/members/", log(os.Stdout, teams(http.HandlerFunc(han.ListTeamMembersHandler)))).Methods("GET", "OPTIONS")
 	// Get team
-	apiRouter.Handle("/teams/{teamName}", log(os.Stdout, http.HandlerFunc(han.GetTeamHandler))).Methods("GET", "OPTIONS")
-	apiRouter.Handle("/teams/{teamName}/", log(os.Stdout, http.HandlerFunc(han.GetTeamHandler))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}", log(os.Stdout, teams(http.HandlerFunc(han.GetTeamHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}/", log(os.Stdout, teams(http.HandlerFunc(han.GetTeamHandler)))).Methods("GET", "OPTIONS")
 	// Delete team
-	apiRouter.Handle("/teams/{teamName}", log(os.Stdout, http.HandlerFunc(han.DeleteTeamHandler))).Methods("DELETE", "OPTIONS")
-	apiRouter.Handle("/teams/{teamName}/", log(os.Stdout, http.HandlerFunc(han.DeleteTeamHandler))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}", log(os.Stdout, teams(http.HandlerFunc(han.DeleteTeamHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/teams/{teamName}/", log(os.Stdout, teams(http.HandlerFunc(han.DeleteTeamHandler)))).Methods("DELETE", "OPTIONS")
 	// List teams
-	apiRouter.Handle("/teams", log(os.Stdout, http.HandlerFunc(han.ListTeamsHandler))).Methods("GET", "OPTIONS")
-	apiRouter.Handle("/teams/", log(os.Stdout, http.HandlerFunc(han.ListTeamsHandler))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/teams", log(os.Stdout, teams(http.HandlerFunc(han.ListTeamsHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/teams/", log(os.Stdout, teams(http.HandlerFunc(han.ListTeamsHandler)))).Methods("GET", "OPTIONS")
 	// Create teams
-	apiRouter.Handle("/teams", log(os.Stdout, http.HandlerFunc(han.NewTeamHandler))).Methods("POST", "OPTIONS")
-	apiRouter.Handle("/teams/", log(os.Stdout, http.HandlerFunc(han.NewTeamHandler))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/teams", log(os.Stdout, teams(http.HandlerFunc(han.NewTeamHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/teams/", log(os.Stdout, teams(http.HandlerFunc(han.NewTeamHandler)))).Methods("POST", "OPTIONS")
+
+	// Tags handlers
+	// List tags
+	apiRouter.Handle("/tags", log(os.Stdout, pasteRead(http.HandlerFunc(han.TagListHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/tags/", log(os.Stdout, pasteRead(http.HandlerFunc(han.TagListHandler)))).Methods("GET", "OPTIONS")
 
 	// Paste handlers
 	// paste search
-	apiRouter.Handle("/paste/search/", log(os.Stdout, http.HandlerFunc(han.SearchPasteHandler))).Methods("GET", "OPTIONS")
-	apiRouter.Handle("/paste/search", log(os.Stdout, http.HandlerFunc(han.SearchPasteHandler))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/paste/search/", log(os.Stdout, pasteRead(http.HandlerFunc(han.SearchPasteHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/paste/search", log(os.Stdout, pasteRead(http.HandlerFunc(han.SearchPasteHandler)))).Methods("GET", "OPTIONS")
 	// Unshare paste
-	apiRouter.Handle("/paste/{pasteID}/sharing/{userID}", log(os.Stdout, http.HandlerFunc(han.UnsharePasteHandler))).Methods("DELETE", "OPTIONS")
-	apiRouter.Handle("/paste/{pasteID}/sharing/{userID}/", log(os.Stdout, http.HandlerFunc(han.PasteViewHandler))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/sharing/{userID}", log(os.Stdout, share(http.HandlerFunc(han.UnsharePasteHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/sharing/{userID}/", log(os.Stdout, share(http.HandlerFunc(han.UnsharePasteHandler)))).Methods("DELETE", "OPTIONS")
 	// Share paste
-	apiRouter.Handle("/paste/{pasteID}/sharing", log(os.Stdout, http.HandlerFunc(han.SharePasteHandler))).Methods("POST", "OPTIONS")
-	apiRouter.Handle("/paste/{pasteID}/sharing/", log(os.Stdout, http.HandlerFunc(han.SharePasteHandler))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/sharing", log(os.Stdout, share(http.HandlerFunc(han.SharePasteHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/sharing/", log(os.Stdout, share(http.HandlerFunc(han.SharePasteHandler)))).Methods("POST", "OPTIONS")
 	// List shares
-	apiRouter.Handle("/paste/{pasteID}/sharing", log(os.Stdout, http.HandlerFunc(han.ListSharesHandler))).Methods("GET", "OPTIONS")
-	apiRouter.Handle("/paste/{pasteID}/sharing/", log(os.Stdout, http.HandlerFunc(han.ListSharesHandler))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/sharing", log(os.Stdout, share(http.HandlerFunc(han.ListSharesHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/sharing/", log(os.Stdout, share(http.HandlerFunc(han.ListSharesHandler)))).Methods("GET", "OPTIONS")
 	// Get paste
-	apiRouter.Handle("/paste/{pasteID}", log(os.Stdout, http.HandlerFunc(han.PasteViewHandler))).Methods("GET", "OPTIONS")
-	apiRouter.Handle("/paste/{pasteID}/", log(os.Stdout, http.HandlerFunc(han.PasteViewHandler))).Methods("GET", "OPTIONS")
+	// Duplicate the route to allow fetching a paste, both with and without a traling slash.
+	// StrictSlashes generates an extra request. There is no good way to match both cases
+	// where you have a trailing slash and one where you don't.
+	apiRouter.Handle("/paste/{pasteID}", log(os.Stdout, pasteRead(http.HandlerFunc(han.PasteViewHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/", log(os.Stdout, pasteRead(http.HandlerFunc(han.PasteViewHandler)))).Methods("GET", "OPTIONS")
 	// Download paste
-	apiRouter.Handle("/paste/{pasteID}/download", log(os.Stdout, http.HandlerFunc(han.PasteDownloadHandler))).Methods("GET", "OPTIONS")
-	apiRouter.Handle("/paste/{pasteID}/download/", log(os.Stdout, http.HandlerFunc(han.PasteDownloadHandler))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/download", log(os.Stdout, pasteRead(http.HandlerFunc(han.PasteDownloadHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/download/", log(os.Stdout, pasteRead(http.HandlerFunc(han.PasteDownloadHandler)))).Methods("GET", "OPTIONS")
 	// Update paste
-	apiRouter.Handle("/paste/{pasteID}", log(os.Stdout, http.HandlerFunc(han.UpdatePasteHandler))).Methods("PUT", "OPTIONS")
-	apiRouter.Handle("/paste/{pasteID}/", log(os.Stdout, http.HandlerFunc(han.UpdatePasteHandler))).Methods("PUT", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}", log(os.Stdout, pasteWrite(http.HandlerFunc(han.UpdatePasteHandler)))).Methods("PUT", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/", log(os.Stdout, pasteWrite(http.HandlerFunc(han.UpdatePasteHandler)))).Methods("PUT", "OPTIONS")
 	// Delete paste handlers
-	apiRouter.Handle("/paste/{pasteID}", log(os.Stdout, http.HandlerFunc(han.DeletePasteHandler))).Methods("DELETE", "OPTIONS")
-	apiRouter.Handle("/paste/{pasteID}/", log(os.Stdout, http.HandlerFunc(han.DeletePasteHandler))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}", log(os.Stdout, pasteWrite(http.HandlerFunc(han.DeletePasteHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/paste/{pasteID}/", log(os.Stdout, pasteWrite(http.HandlerFunc(han.DeletePasteHandler)))).Methods("DELETE", "OPTIONS")
 	// paste list
-	apiRouter.Handle("/{paste:paste\\/?}", log(os.Stdout, http.HandlerFunc(han.PasteListHandler))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/{paste:paste\\/?}", log(os.Stdout, pasteRead(http.HandlerFunc(han.PasteListHandler)))).Methods("GET", "OPTIONS")
 	// Create paste
-	apiRouter.Handle("/paste/", log(os.Stdout, http.HandlerFunc(han.CreatePasteHandler))).Methods("POST", "OPTIONS")
-	apiRouter.Handle("/paste", log(os.Stdout, http.HandlerFunc(han.CreatePasteHandler))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/paste/", log(os.Stdout, pasteWrite(http.HandlerFunc(han.CreatePasteHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/paste", log(os.Stdout, pasteWrite(http.HandlerFunc(han.CreatePasteHandler)))).Methods("POST", "OPTIONS")
+	// API tokens
+	apiRouter.Handle("/tokens", log(os.Stdout, tokens(http.HandlerFunc(han.ListAPITokensHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/tokens/", log(os.Stdout, tokens(http.HandlerFunc(han.ListAPITokensHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/tokens", log(os.Stdout, tokens(http.HandlerFunc(han.NewAPITokenHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/tokens/", log(os.Stdout, tokens(http.HandlerFunc(han.NewAPITokenHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/tokens/{tokenID}", log(os.Stdout, tokens(http.HandlerFunc(han.RevokeAPITokenHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/tokens/{tokenID}/", log(os.Stdout, tokens(http.HandlerFunc(han.RevokeAPITokenHandler)))).Methods("DELETE", "OPTIONS")
+	// Profile
+	apiRouter.Handle("/{me:me\\/?}", log(os.Stdout, account(http.HandlerFunc(han.ProfileHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/{me:me\\/?}", log(os.Stdout, account(http.HandlerFunc(han.UpdateProfileHandler)))).Methods("PATCH", "OPTIONS")
+	apiRouter.Handle("/me/{password:password\\/?}", log(os.Stdout, account(http.HandlerFunc(han.ChangePasswordHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/me/{export:export\\/?}", log(os.Stdout, account(http.HandlerFunc(han.ExportProfileHandler)))).Methods("GET", "OPTIONS")
+	// Two-factor authentication
+	apiRouter.Handle("/account/{twofactor:2fa\\/?}", log(os.Stdout, account(http.HandlerFunc(han.TwoFactorStatusHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/account/2fa/{enroll:enroll\\/?}", log(os.Stdout, account(http.HandlerFunc(han.EnrollTwoFactorHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/account/2fa/{confirm:confirm\\/?}", log(os.Stdout, account(http.HandlerFunc(han.ConfirmTwoFactorHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/account/2fa/{disable:disable\\/?}", log(os.Stdout, account(http.HandlerFunc(han.DisableTwoFactorHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/account/2fa/{codes:recovery-codes\\/?}", log(os.Stdout, account(http.HandlerFunc(han.RecoveryCodesHandler)))).Methods("POST", "OPTIONS")
+	// Sessions
+	apiRouter.Handle("/account/{sessions:sessions\\/?}", log(os.Stdout, account(http.HandlerFunc(han.ListSessionsHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/account/{sessions:sessions\\/?}", log(os.Stdout, account(http.HandlerFunc(han.RevokeAllSessionsHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/account/sessions/{sessionID}", log(os.Stdout, account(http.HandlerFunc(han.RevokeSessionHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/account/sessions/{sessionID}/", log(os.Stdout, account(http.HandlerFunc(han.RevokeSessionHandler)))).Methods("DELETE", "OPTIONS")
+	// Client certificates
+	apiRouter.Handle("/account/{certificates:certificates\\/?}", log(os.Stdout, account(http.HandlerFunc(han.ListClientCertificatesHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/account/{certificates:certificates\\/?}", log(os.Stdout, account(http.HandlerFunc(han.NewClientCertificateHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/account/certificates/{certID}", log(os.Stdout, account(http.HandlerFunc(han.DeleteClientCertificateHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/account/certificates/{certID}/", log(os.Stdout, account(http.HandlerFunc(han.DeleteClientCertificateHandler)))).Methods("DELETE", "OPTIONS")
 	// logout
-	apiRouter.Handle("/{logout:logout\\/?}", log(os.Stdout, http.HandlerFunc(han.LogoutHandler))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/{logout:logout\\/?}", log(os.Stdout, http.HandlerFunc(han.LogoutHandler))).Methods("GET", "POST", "OPTIONS")
 	// admin routes
-	apiRouter.Handle("/admin/{users:users\\/?}", log(os.Stdout, http.HandlerFunc(han.UserListHandler))).Methods("GET", "OPTIONS")
-	apiRouter.Handle("/admin/{users:users\\/?}", log(os.Stdout, http.HandlerFunc(han.NewUserHandler))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/admin/{users:users\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserListHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/{users:users\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.NewUserHandler)))).Methods("POST", "OPTIONS")
+	// users awaiting approval
+	apiRouter.Handle("/admin/users/{pending:pending\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.PendingUsersHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/{approve:approve\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.ApproveUserHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/{reject:reject\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.RejectUserHandler)))).Methods("POST", "OPTIONS")
+	// user details
+	apiRouter.Handle("/admin/users/{userID}", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserDetailsHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserDetailsHandler)))).Methods("GET", "OPTIONS")
+	// enable and disable users
+	apiRouter.Handle("/admin/users/{userID}/{enable:enable\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.EnableUserHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/{disable:disable\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.DisableUserHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/{export:export\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.ExportUserHandler)))).Methods("GET", "OPTIONS")
 	// update user
-	apiRouter.Handle("/admin/users/{userID}", log(os.Stdout, http.HandlerFunc(han.UpdateUserHandler))).Methods("PUT", "OPTIONS")
-	apiRouter.Handle("/admin/users/{userID}/", log(os.Stdout, http.HandlerFunc(han.UpdateUserHandler))).Methods("PUT", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}", log(os.Stdout, adminUsers(http.HandlerFunc(han.UpdateUserHandler)))).Methods("PUT", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/", log(os.Stdout, adminUsers(http.HandlerFunc(han.UpdateUserHandler)))).Methods("PUT", "OPTIONS")
 	// delete user
-	apiRouter.Handle("/admin/users/{userID}", log(os.Stdout, http.HandlerFunc(han.DeleteUserHandler))).Methods("DELETE", "OPTIONS")
-	apiRouter.Handle("/admin/users/{userID}/", log(os.Stdout, http.HandlerFunc(han.DeleteUserHandler))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}", log(os.Stdout, adminUsers(http.HandlerFunc(han.DeleteUserHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/", log(os.Stdout, adminUsers(http.HandlerFunc(han.DeleteUserHandler)))).Methods("DELETE", "OPTIONS")
+	// list user API tokens
+	apiRouter.Handle("/admin/users/{userID}/tokens", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserAPITokensHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/tokens/", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserAPITokensHandler)))).Methods("GET", "OPTIONS")
+	// revoke user API token
+	apiRouter.Handle("/admin/users/{userID}/tokens/{tokenID}", log(os.Stdout, adminUsers(http.HandlerFunc(han.RevokeUserAPITokenHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/tokens/{tokenID}/", log(os.Stdout, adminUsers(http.HandlerFunc(han.RevokeUserAPITokenHandler)))).Methods("DELETE", "OPTIONS")
+	// user client certificates
+	apiRouter.Handle("/admin/users/{userID}/certificates", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserClientCertificatesHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/certificates/", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserClientCertificatesHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/certificates/{certID}", log(os.Stdout, adminUsers(http.HandlerFunc(han.DeleteUserClientCertificateHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/certificates/{certID}/", log(os.Stdout, adminUsers(http.HandlerFunc(han.DeleteUserClientCertificateHandler)))).Methods("DELETE", "OPTIONS")
+	// user two-factor authentication
+	apiRouter.Handle("/admin/users/{userID}/2fa", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserTwoFactorHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/2fa/", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserTwoFactorHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/2fa", log(os.Stdout, adminUsers(http.HandlerFunc(han.ResetUserTwoFactorHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/2fa/", log(os.Stdout, adminUsers(http.HandlerFunc(han.ResetUserTwoFactorHandler)))).Methods("DELETE", "OPTIONS")
+	// user sessions
+	apiRouter.Handle("/admin/users/{userID}/sessions", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserSessionsHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/sessions/", log(os.Stdout, adminUsers(http.HandlerFunc(han.UserSessionsHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/sessions", log(os.Stdout, adminUsers(http.HandlerFunc(han.RevokeUserSessionsHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/sessions/", log(os.Stdout, adminUsers(http.HandlerFunc(han.RevokeUserSessionsHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/sessions/{sessionID}", log(os.Stdout, adminUsers(http.HandlerFunc(han.RevokeUserSessionHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/sessions/{sessionID}/", log(os.Stdout, adminUsers(http.HandlerFunc(han.RevokeUserSessionHandler)))).Methods("DELETE", "OPTIONS")
+	// login lockout
+	apiRouter.Handle("/admin/users/{userID}/lockout", log(os.Stdout, adminUsers(http.HandlerFunc(han.UnlockUserHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/users/{userID}/lockout/", log(os.Stdout, adminUsers(http.HandlerFunc(han.UnlockUserHandler)))).Methods("DELETE", "OPTIONS")
+	// invites
+	apiRouter.Handle("/admin/{invites:invites\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.ListInvitesHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/{invites:invites\\/?}", log(os.Stdout, adminUsers(http.HandlerFunc(han.NewInviteHandler)))).Methods("POST", "OPTIONS")
+	apiRouter.Handle("/admin/invites/{inviteID}", log(os.Stdout, adminUsers(http.HandlerFunc(han.DeleteInviteHandler)))).Methods("DELETE", "OPTIONS")
+	apiRouter.Handle("/admin/invites/{inviteID}/", log(os.Stdout, adminUsers(http.HandlerFunc(han.DeleteInviteHandler)))).Methods("DELETE", "OPTIONS")
+	// audit log
+	apiRouter.Handle("/admin/{audit:audit\\/?}", log(os.Stdout, adminAudit(http.HandlerFunc(han.AuditLogHandler)))).Methods("GET", "OPTIONS")
+	apiRouter.Handle("/admin/audit/{export:export\\/?}", log(os.Stdout, adminAudit(http.HandlerFunc(han.AuditLogExportHandler)))).Methods("GET", "OPTIONS")
 
 	apiRouter.PathPrefix("/").Handler(log(os.Stdout, http.HandlerFunc(han.NotFoundHandler)))
 
+	// Public keys verifying login tokens, at the well known location
+	// other services look for them.
+	router.Handle("/.well-known/jwks.json", log(os.Stdout, http.HandlerFunc(han.JWKSHandler))).Methods("GET", "OPTIONS")
+
 	router.PathPrefix("/").Handler(log(os.Stdout, gziphandler.GzipHandler(http.HandlerFunc(webui.UIHandler))))
 	return nil
 }
@@ -0,0 +1,124 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package audit records security relevant actions, such as logins, paste
+// deletions or changes to users, in the audit log. Events are recorded
+// through a Recorder shared by the paste and user managers and the API
+// controllers, which hands them to one or more sinks.
+package audit
+
+import (
+	"context"
+	"time"
+
+	"gopherbin/auth"
+	"gopherbin/params"
+
+	"github.com/juju/loggo"
+)
+
+var log = loggo.GetLogger("gopherbin.audit")
+
+// Audited actions
+const (
+	ActionLogin       = "auth.login"
+	ActionLoginFailed = "auth.login_failed"
+	ActionLogout      = "auth.logout"
+
+	ActionPasteCreate  = "paste.create"
+	ActionPasteView    = "paste.view"
+	ActionPasteDelete  = "paste.delete"
+	ActionPastePrivacy = "paste.privacy"
+	ActionPasteShare   = "paste.share"
+	ActionPasteUnshare = "paste.unshare"
+
+	ActionTeamCreate       = "team.create"
+	ActionTeamDelete       = "team.delete"
+	ActionTeamMemberAdd    = "team.member_add"
+	ActionTeamMemberRemove = "team.member_remove"
+
+	ActionUserCreate         = "user.create"
+	ActionUserUpdate         = "user.update"
+	ActionUserDelete         = "user.delete"
+	ActionUserEnable         = "user.enable"
+	ActionUserDisable        = "user.disable"
+	ActionUserRegister       = "user.register"
+	ActionUserApprove        = "user.approve"
+	ActionUserReject         = "user.reject"
+	ActionUserUnlock         = "user.unlock"
+	ActionUserTwoFactorReset = "user.two_factor_reset"
+	ActionUserSessionsRevoke = "user.sessions_revoke"
+	ActionUserExport         = "user.export"
+
+	ActionInviteCreate = "invite.create"
+	ActionInviteDelete = "invite.delete"
+)
+
+// Types of the targets of audited actions
+const (
+	TargetPaste  = "paste"
+	TargetTeam   = "team"
+	TargetUser   = "user"
+	TargetInvite = "invite"
+)
+
+// Recorder records audited actions. Recording never fails the audited
+// action: errors are logged instead.
+type Recorder interface {
+	// Record records event. The actor, client address, request ID and
+	// time are taken from ctx, unless already set on event.
+	Record(ctx context.Context, event params.AuditEvent)
+}
+
+// Sink stores or forwards audit events
+type Sink interface {
+	Write(event params.AuditEvent) error
+}
+
+// Discard is a Recorder which drops all events
+var Discard Recorder = discard{}
+
+type discard struct{}
+
+func (discard) Record(ctx context.Context, event params.AuditEvent) {}
+
+// NewRecorder returns a Recorder which writes events to every sink
+func NewRecorder(sinks ...Sink) Recorder {
+	return &recorder{sinks: sinks}
+}
+
+type recorder struct {
+	sinks []Sink
+}
+
+func (r *recorder) Record(ctx context.Context, event params.AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.ActorID == 0 && event.Actor == "" {
+		event.ActorID = auth.UserID(ctx)
+		event.Actor = auth.Username(ctx)
+	}
+	if event.IP == "" {
+		event.IP = auth.ClientIP(ctx)
+	}
+	if event.RequestID == "" {
+		event.RequestID = auth.RequestID(ctx)
+	}
+	for _, sink := range r.sinks {
+		if err := sink.Write(event); err != nil {
+			log.Errorf("failed to record %s event for request %s: %+v", event.Action, event.RequestID, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+
+	"gopherbin/config"
+	"gopherbin/params"
+
+	"github.com/pkg/errors"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"auth":     syslog.LOG_AUTH,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"daemon":   syslog.LOG_DAEMON,
+	"user":     syslog.LOG_USER,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// NewSyslogSink returns a Sink which forwards events to syslog, as JSON
+// encoded messages with the notice severity.
+func NewSyslogSink(cfg config.AuditSyslog) (Sink, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validating syslog config")
+	}
+	facility, ok := syslogFacilities[cfg.Facility]
+	if !ok {
+		return nil, errors.Errorf("invalid facility %q", cfg.Facility)
+	}
+	writer, err := syslog.Dial(cfg.Network, cfg.Address, facility|syslog.LOG_NOTICE, cfg.Tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to syslog")
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func (s *syslogSink) Write(event params.AuditEvent) error {
+	msg, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "encoding event")
+	}
+	if err := s.writer.Notice(string(msg)); err != nil {
+		return errors.Wrap(err, "writing to syslog")
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	adminCommon "gopherbin/admin/common"
+)
+
+// APITokenPrefix is the prefix of all personal API tokens. It allows the
+// API token middleware to tell API tokens apart from JWT tokens.
+const APITokenPrefix = "gpb_"
+
+// NewAPITokenMiddleware returns a middleware that authenticates requests
+// carrying a personal API token. Requests carrying any other kind of
+// credentials are passed on untouched, so this middleware must be chained
+// in front of another authentication middleware.
+func NewAPITokenMiddleware(manager adminCommon.APITokenManager) (Middleware, error) {
+	return &apiTokenMiddleware{
+		manager: manager,
+	}, nil
+}
+
+type apiTokenMiddleware struct {
+	manager adminCommon.APITokenManager
+}
+
+// Middleware implements the middleware interface
+func (amw *apiTokenMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearerToken := strings.Split(r.Header.Get("authorization"), " ")
+		if len(bearerToken) != 2 || !strings.HasPrefix(bearerToken[1], APITokenPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, err := amw.manager.Authenticate(r.Context(), bearerToken[1])
+		if err != nil || !IsEnabled(ctx) || IsAnonymous(ctx) {
+			invalidAuthResponse(w, r)
+			return
+		}
+		ctx = SetAuthMethod(ctx, AuthMethodAPIToken)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
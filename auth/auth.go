@@ -27,6 +27,7 @@ const (
 	isAdminKey     contextFlags = "is_admin"
 	isSuperUserKey contextFlags = "is_super"
 	fullNameKey    contextFlags = "full_name"
+	usernameKey    contextFlags = "username"
 	// UpdatedAtFlag sets the timestamp when the user was
 	// updated in the context
 	UpdatedAtFlag contextFlags = "updated_at"
@@ -34,6 +35,26 @@ const (
 	UserIDFlag    contextFlags = "user_id"
 	isEnabledFlag contextFlags = "is_enabled"
 	jwtTokenFlag  contextFlags = "jwt_token"
+	requestIDFlag contextFlags = "request_id"
+	authMethodKey contextFlags = "auth_method"
+	scopesKey     contextFlags = "scopes"
+	stampKey      contextFlags = "security_stamp"
+	clientIPKey   contextFlags = "client_ip"
+)
+
+const (
+	// AuthMethodJWT is set in the context when a request was
+	// authenticated using a JWT token obtained by logging in
+	AuthMethodJWT = "jwt"
+	// AuthMethodAPIToken is set in the context when a request was
+	// authenticated using a personal API token
+	AuthMethodAPIToken = "api_token"
+	// AuthMethodProxy is set in the context when a request was
+	// authenticated by a trusted reverse proxy
+	AuthMethodProxy = "proxy"
+	// AuthMethodClientCert is set in the context when a request was
+	// authenticated with a TLS client certificate
+	AuthMethodClientCert = "client_cert"
 )
 
 // PopulateContext sets the appropriate fields in the context, based on
@@ -45,9 +66,25 @@ func PopulateContext(ctx context.Context, user params.Users) context.Context {
 	ctx = SetIsEnabled(ctx, user.Enabled)
 	ctx = SetUpdatedAt(ctx, user.UpdatedAt)
 	ctx = SetFullName(ctx, user.FullName)
+	ctx = SetUsername(ctx, user.Username)
+	ctx = SetSecurityStamp(ctx, user.SecurityStamp)
 	return ctx
 }
 
+// SetSecurityStamp sets the security stamp of the user in the context
+func SetSecurityStamp(ctx context.Context, stamp string) context.Context {
+	return context.WithValue(ctx, stampKey, stamp)
+}
+
+// SecurityStamp returns the security stamp of the user in the context
+func SecurityStamp(ctx context.Context) string {
+	stamp := ctx.Value(stampKey)
+	if stamp == nil {
+		return ""
+	}
+	return stamp.(string)
+}
+
 // SetFullName sets the user full name in the context
 func SetFullName(ctx context.Context, fullName string) context.Context {
 	return context.WithValue(ctx, fullNameKey, fullName)
@@ -62,6 +99,20 @@ func FullName(ctx context.Context) string {
 	return name.(string)
 }
 
+// SetUsername sets the username in the context
+func SetUsername(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, usernameKey, username)
+}
+
+// Username returns the username from context
+func Username(ctx context.Context) string {
+	username := ctx.Value(usernameKey)
+	if username == nil {
+		return ""
+	}
+	return username.(string)
+}
+
 // SetUpdatedAt sets the update stamp for a user in the context
 func SetUpdatedAt(ctx context.Context, tm time.Time) context.Context {
 	return context.WithValue(ctx, UpdatedAtFlag, tm.String())
@@ -90,6 +141,50 @@ func JWTClaim(ctx context.Context) JWTClaims {
 	return jwtClaim.(JWTClaims)
 }
 
+// SetRequestID sets the ID of the request being served in the context
+func SetRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDFlag, requestID)
+}
+
+// RequestID returns the ID of the request being served
+func RequestID(ctx context.Context) string {
+	requestID := ctx.Value(requestIDFlag)
+	if requestID == nil {
+		return ""
+	}
+	return requestID.(string)
+}
+
+// SetClientIP sets the address of the client that sent the request in
+// the context
+func SetClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// ClientIP returns the address of the client that sent the request
+func ClientIP(ctx context.Context) string {
+	ip := ctx.Value(clientIPKey)
+	if ip == nil {
+		return ""
+	}
+	return ip.(string)
+}
+
+// SetAuthMethod sets the method used to authenticate the request
+func SetAuthMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, authMethodKey, method)
+}
+
+// AuthMethod returns the method used to authenticate the request, or
+// an empty string if the request has not been authenticated yet
+func AuthMethod(ctx context.Context) string {
+	method := ctx.Value(authMethodKey)
+	if method == nil {
+		return ""
+	}
+	return method.(string)
+}
+
 // SetIsEnabled sets a flag indicating if account is enabled
 func SetIsEnabled(ctx context.Context, enabled bool) context.Context {
 	return context.WithValue(ctx, isEnabledFlag, enabled)
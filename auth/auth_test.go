@@ -11,12 +11,13 @@ import (
 
 func TestPopulateContext(t *testing.T) {
 	user := params.Users{
-		ID:          42,
-		FullName:    "Test User",
-		IsAdmin:     true,
-		IsSuperUser: false,
-		Enabled:     true,
-		UpdatedAt:   time.Now(),
+		ID:            42,
+		FullName:      "Test User",
+		IsAdmin:       true,
+		IsSuperUser:   false,
+		Enabled:       true,
+		UpdatedAt:     time.Now(),
+		SecurityStamp: "stamp",
 	}
 	ctx := auth.PopulateContext(context.Background(), user)
 
@@ -38,6 +39,9 @@ func TestPopulateContext(t *testing.T) {
 	if auth.UpdatedAt(ctx) == "" {
 		t.Error("UpdatedAt: want non-empty string")
 	}
+	if auth.SecurityStamp(ctx) != "stamp" {
+		t.Errorf("SecurityStamp: want %q, got %q", "stamp", auth.SecurityStamp(ctx))
+	}
 }
 
 func TestIsAnonymous(t *testing.T) {
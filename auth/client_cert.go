@@ -0,0 +1,58 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package auth
+
+import (
+	"net/http"
+
+	adminCommon "gopherbin/admin/common"
+)
+
+// NewClientCertMiddleware returns a middleware that authenticates requests
+// made with a verified TLS client certificate. Requests that carry an
+// Authorization header, or no client certificate, are passed on
+// untouched, so this middleware must be chained in front of another
+// authentication middleware.
+func NewClientCertMiddleware(manager adminCommon.ClientCertificateManager) Middleware {
+	return &clientCertMiddleware{
+		manager: manager,
+	}
+}
+
+type clientCertMiddleware struct {
+	manager adminCommon.ClientCertificateManager
+}
+
+// Middleware implements the middleware interface
+func (amw *clientCertMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if AuthMethod(ctx) != "" || r.Header.Get("Authorization") != "" ||
+			hasSessionCookie(r) || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The TLS server verified the chain against the client CAs,
+		// and checked it against the CRL.
+		ctx, err := amw.manager.Authenticate(ctx, r.TLS.VerifiedChains[0][0])
+		if err != nil || !IsEnabled(ctx) || IsAnonymous(ctx) {
+			invalidAuthResponse(w, r)
+			return
+		}
+		ctx = SetAuthMethod(ctx, AuthMethodClientCert)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package auth
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+
+	gErrors "gopherbin/errors"
+	"gopherbin/util"
+)
+
+// emailChangeIssuer is the issuer of email change tokens. It prevents an
+// email change token from being mistaken for any other token signed with
+// the same secret.
+const emailChangeIssuer = "gopherbin-email-change"
+
+// ErrInvalidEmailChangeToken is returned when an email change token is
+// invalid, expired or has already been used.
+var ErrInvalidEmailChangeToken = gErrors.WithCode(
+	gErrors.NewUnauthorizedError("invalid or expired email confirmation link"),
+	gErrors.CodeInvalidToken)
+
+// EmailChangeClaims holds the claims of an email change token, which is
+// sent to the new email address of a user to confirm they own it. Like
+// password reset tokens, they do not carry the user claim, so they are
+// refused by the JWT middleware.
+type EmailChangeClaims struct {
+	// SecurityStamp is the security stamp of the user when the change
+	// was requested, so changing the password cancels the change.
+	SecurityStamp string `json:"stamp"`
+	// PreviousEmail is the email address of the user when the change
+	// was requested. Once any change is confirmed, the links sent for
+	// other changes stop working.
+	PreviousEmail string `json:"previous_email"`
+	// Email is the new email address.
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the ID of the user the token was issued to
+func (e EmailChangeClaims) UserID() uint {
+	return subjectUserID(e.Subject)
+}
+
+// NewEmailChangeToken returns a token that changes the email address of
+// the user in the context from previousEmail to email, signed with a key
+// derived from secret and valid for ttl.
+func NewEmailChangeToken(ctx context.Context, secret, previousEmail, email string, ttl time.Duration) (string, error) {
+	tokenID, err := util.GetRandomString(16)
+	if err != nil {
+		return "", errors.Wrap(err, "generating token ID")
+	}
+	claims := EmailChangeClaims{
+		SecurityStamp: SecurityStamp(ctx),
+		PreviousEmail: previousEmail,
+		Email:         email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   strconv.FormatUint(uint64(UserID(ctx)), 10),
+			Issuer:    emailChangeIssuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(util.DeriveKey(secret, emailChangeIssuer))
+}
+
+// ParseEmailChangeToken verifies an email change token and returns its
+// claims
+func ParseEmailChangeToken(token, secret string) (EmailChangeClaims, error) {
+	claims := EmailChangeClaims{}
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return util.DeriveKey(secret, emailChangeIssuer), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(emailChangeIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.ID == "" || claims.UserID() == 0 || claims.SecurityStamp == "" || claims.Email == "" {
+		return EmailChangeClaims{}, ErrInvalidEmailChangeToken
+	}
+	return claims, nil
+}
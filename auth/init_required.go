@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"encoding/json"
 	"net/http"
 
 	adminCommon "gopherbin/admin/common"
@@ -24,9 +23,9 @@ func (i *initRequired) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// TODO: Log error details when authentication fails
 		if !i.manager.HasSuperUser() {
-			w.Header().Add("Content-Type", "application/json")
-			w.WriteHeader(http.StatusConflict)
-			json.NewEncoder(w).Encode(responses.InitializationRequired)
+			apiErr := responses.InitializationRequired
+			apiErr.RequestID = RequestID(r.Context())
+			responses.WriteError(w, http.StatusConflict, apiErr)
 			return
 		}
 		ctx := r.Context()
@@ -20,3 +20,19 @@ import "net/http"
 type Middleware interface {
 	Middleware(next http.Handler) http.Handler
 }
+
+type chain []Middleware
+
+// Chain returns a middleware that applies the given middlewares in order,
+// the first one being the outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return chain(middlewares)
+}
+
+// Middleware implements the middleware interface
+func (c chain) Middleware(next http.Handler) http.Handler {
+	for idx := len(c) - 1; idx >= 0; idx-- {
+		next = c[idx].Middleware(next)
+	}
+	return next
+}
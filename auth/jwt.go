@@ -16,9 +16,8 @@ package auth
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/subtle"
 	"fmt"
-	"gopherbin/config"
 	"net/http"
 	"strings"
 
@@ -27,31 +26,70 @@ import (
 	adminCommon "gopherbin/admin/common"
 	"gopherbin/apiserver/responses"
 	gErrors "gopherbin/errors"
+	"gopherbin/util"
 )
 
+const (
+	// SessionCookieName is the name of the cookie holding the login
+	// token of a browser session.
+	SessionCookieName = "gopherbin_session"
+	// CSRFCookieName is the name of the cookie holding the CSRF token
+	// of a browser session. Unlike the session cookie, it is readable
+	// by scripts, so the web UI can echo it back in CSRFHeader.
+	CSRFCookieName = "gopherbin_csrf"
+	// CSRFHeader must carry the CSRF token of the session on every
+	// state changing request authenticated with the session cookie.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// securityStampLength is the number of random characters in a security stamp
+const securityStampLength = 32
+
+// NewSecurityStamp returns a new random security stamp. Tokens carry the
+// security stamp of the user they were issued for, and are rejected once
+// the stamp of the user changes.
+func NewSecurityStamp() (string, error) {
+	return util.GetRandomString(securityStampLength)
+}
+
 // JWTClaims holds JWT claims
 type JWTClaims struct {
-	UserID      uint   `json:"user"`
-	UpdatedAt   string `json:"updated_at"`
+	UserID uint `json:"user"`
+	// SecurityStamp must match the security stamp of the user.
+	SecurityStamp string `json:"stamp,omitempty"`
+	// UpdatedAt is only set in tokens issued before security stamps
+	// were introduced. These tokens are rejected if the user changed
+	// since they were issued.
+	UpdatedAt   string `json:"updated_at,omitempty"`
 	TokenID     string `json:"token_id"`
 	FullName    string `json:"full_name"`
 	IsAdmin     bool   `json:"is_admin"`
 	IsSuperUser bool   `json:"is_superuser"`
+	// Scopes limits what the token may be used for. Tokens issued
+	// before scopes were introduced have none, and get all scopes.
+	Scopes []string `json:"scopes,omitempty"`
+	// CSRFToken is only set in tokens issued for cookie sessions. These
+	// tokens are only accepted from the session cookie.
+	CSRFToken string `json:"csrf,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // jwtMiddleware is the authentication middleware
 // used with gorilla
 type jwtMiddleware struct {
-	manager adminCommon.UserManager
-	cfg     config.JWTAuth
+	manager  adminCommon.UserManager
+	sessions adminCommon.SessionManager
+	keys     *KeyRing
 }
 
-// NewjwtMiddleware returns a populated jwtMiddleware
-func NewjwtMiddleware(manager adminCommon.UserManager, cfg config.JWTAuth) (Middleware, error) {
+// NewjwtMiddleware returns a populated jwtMiddleware. Tokens are verified
+// with the keys in keys, and are only accepted while the session they were
+// issued for exists.
+func NewjwtMiddleware(manager adminCommon.UserManager, sessions adminCommon.SessionManager, keys *KeyRing) (Middleware, error) {
 	return &jwtMiddleware{
-		manager: manager,
-		cfg:     cfg,
+		manager:  manager,
+		sessions: sessions,
+		keys:     keys,
 	}, nil
 }
 
@@ -72,20 +110,76 @@ func (amw *jwtMiddleware) claimsToContext(ctx context.Context, claims *JWTClaims
 	}
 	ctx = PopulateContext(ctx, userInfo)
 
-	if claims.UpdatedAt != UpdatedAt(ctx) {
+	if claims.SecurityStamp == "" {
+		if claims.UpdatedAt != UpdatedAt(ctx) {
+			return ctx, fmt.Errorf("invalid token")
+		}
+		return ctx, nil
+	}
+	if claims.SecurityStamp != SecurityStamp(ctx) {
 		return ctx, fmt.Errorf("invalid token")
 	}
 	return ctx, nil
 }
 
-func invalidAuthResponse(w http.ResponseWriter) {
-	w.WriteHeader(http.StatusUnauthorized)
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(
-		responses.APIErrorResponse{
-			Error:   "Authentication failed",
-			Details: "Invalid authentication token",
-		})
+func invalidAuthResponse(w http.ResponseWriter, r *http.Request) {
+	responses.WriteError(w, http.StatusUnauthorized, responses.APIErrorResponse{
+		Error:     "Authentication failed",
+		Details:   "Invalid authentication token",
+		Code:      gErrors.CodeInvalidToken,
+		RequestID: RequestID(r.Context()),
+	})
+}
+
+func invalidCSRFResponse(w http.ResponseWriter, r *http.Request) {
+	responses.WriteError(w, http.StatusForbidden, responses.APIErrorResponse{
+		Error:     "Forbidden",
+		Details:   "Missing or invalid CSRF token",
+		Code:      gErrors.CodeInvalidCSRFToken,
+		RequestID: RequestID(r.Context()),
+	})
+}
+
+// isSafeMethod returns true for request methods that must not change
+// state, and therefore need no CSRF token.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// sessionToken returns the login token sent with the request, and
+// whether it was read from the session cookie. A bearer token in the
+// Authorization header takes precedence over the cookie.
+func sessionToken(r *http.Request) (string, bool) {
+	if authorizationHeader := r.Header.Get("authorization"); authorizationHeader != "" {
+		bearerToken := strings.Split(authorizationHeader, " ")
+		if len(bearerToken) != 2 {
+			return "", false
+		}
+		return bearerToken[1], false
+	}
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// hasSessionCookie returns true if the request carries a session cookie.
+func hasSessionCookie(r *http.Request) bool {
+	_, err := r.Cookie(SessionCookieName)
+	return err == nil
+}
+
+// predatesSessions returns true if token was issued before sessions were
+// introduced. These tokens are signed with the JWT secret, so they have no
+// kid header, and carry no security stamp.
+func predatesSessions(token *jwt.Token, claims *JWTClaims) bool {
+	_, hasKid := token.Header["kid"]
+	return !hasKid && claims.SecurityStamp == ""
 }
 
 // Middleware implements the middleware interface
@@ -93,51 +187,76 @@ func (amw *jwtMiddleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// TODO: Log error details when authentication fails
 		ctx := r.Context()
-		authorizationHeader := r.Header.Get("authorization")
-		if authorizationHeader == "" {
-			invalidAuthResponse(w)
+		if AuthMethod(ctx) != "" {
+			// Already authenticated by a middleware earlier in the chain.
+			next.ServeHTTP(w, r)
 			return
 		}
-
-		bearerToken := strings.Split(authorizationHeader, " ")
-		if len(bearerToken) != 2 {
-			invalidAuthResponse(w)
+		tokenString, fromCookie := sessionToken(r)
+		if tokenString == "" {
+			invalidAuthResponse(w, r)
 			return
 		}
 
 		claims := &JWTClaims{}
-		token, err := jwt.ParseWithClaims(bearerToken[1], claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("invalid signing method")
-			}
-			return []byte(amw.cfg.Secret), nil
-		})
+		token, err := jwt.ParseWithClaims(tokenString, claims, amw.keys.Keyfunc)
 
 		if err != nil {
-			invalidAuthResponse(w)
+			invalidAuthResponse(w, r)
 			return
 		}
 
 		if !token.Valid {
-			invalidAuthResponse(w)
+			invalidAuthResponse(w, r)
 			return
 		}
 
+		// Cookie sessions and bearer tokens must not be interchangeable,
+		// otherwise a cookie could be used without a CSRF token.
+		if fromCookie != (claims.CSRFToken != "") {
+			invalidAuthResponse(w, r)
+			return
+		}
+		if fromCookie && !isSafeMethod(r.Method) {
+			csrfToken := r.Header.Get(CSRFHeader)
+			if subtle.ConstantTimeCompare([]byte(csrfToken), []byte(claims.CSRFToken)) != 1 {
+				invalidCSRFResponse(w, r)
+				return
+			}
+		}
+
 		ctx, err = amw.claimsToContext(ctx, claims)
 		if err != nil {
-			invalidAuthResponse(w)
+			invalidAuthResponse(w, r)
 			return
 		}
 		if !IsEnabled(ctx) || IsAnonymous(ctx) {
-			invalidAuthResponse(w)
+			invalidAuthResponse(w, r)
 			return
 		}
 
 		if err := amw.manager.ValidateToken(claims.TokenID); err != nil {
-			invalidAuthResponse(w)
+			invalidAuthResponse(w, r)
 			return
 		}
+		if predatesSessions(token, claims) {
+			// There is no session to check, the token is accepted
+			// until it expires.
+			if claims.ExpiresAt == nil {
+				invalidAuthResponse(w, r)
+				return
+			}
+		} else if err := amw.sessions.Touch(ctx, claims.TokenID); err != nil {
+			invalidAuthResponse(w, r)
+			return
+		}
+		scopes := claims.Scopes
+		if len(scopes) == 0 {
+			scopes = AllScopes()
+		}
+		ctx = SetScopes(ctx, scopes)
 		ctx = SetJWTClaim(ctx, *claims)
+		ctx = SetAuthMethod(ctx, AuthMethodJWT)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -0,0 +1,395 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+
+	adminCommon "gopherbin/admin/common"
+	"gopherbin/config"
+	"gopherbin/params"
+	"gopherbin/util"
+)
+
+const (
+	// keysRefreshInterval is how often signing keys are reloaded, to
+	// pick up keys created by other instances sharing the database.
+	keysRefreshInterval = time.Minute
+	// unknownKeyRefreshInterval limits how often keys are reloaded when
+	// a token is signed with a key we have not seen yet.
+	unknownKeyRefreshInterval = time.Second
+	signingKeyIDLength        = 16
+	hmacKeySize               = 32
+	rsaKeySize                = 2048
+	// LoginTokenIssuer is the issuer of login tokens.
+	LoginTokenIssuer = "gopherbin"
+)
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	createdAt time.Time
+	private   interface{}
+	public    interface{}
+}
+
+// KeyRing holds the keys used to sign and verify login tokens. New tokens
+// are signed with the newest key, which is replaced once it is older than
+// the rotation interval. Older keys keep verifying tokens until the
+// tokens they signed have expired.
+type KeyRing struct {
+	store adminCommon.SigningKeyManager
+	cfg   config.JWTAuth
+
+	mux      sync.Mutex
+	keys     map[string]signingKey
+	active   signingKey
+	loadedAt time.Time
+}
+
+// NewKeyRing loads the signing keys from store, and creates a key for the
+// configured algorithm if there is none.
+func NewKeyRing(store adminCommon.SigningKeyManager, cfg config.JWTAuth) (*KeyRing, error) {
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = config.JWTAlgorithmHS256
+	}
+	k := &KeyRing{
+		store: store,
+		cfg:   cfg,
+	}
+	k.mux.Lock()
+	defer k.mux.Unlock()
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	if err := k.rotateIfNeeded(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func newSigningKeyID() (string, error) {
+	return util.GetRandomString(signingKeyIDLength)
+}
+
+func parseSigningKey(key adminCommon.SigningKey) (signingKey, error) {
+	ret := signingKey{
+		id:        key.ID,
+		createdAt: key.CreatedAt,
+	}
+	switch config.JWTAlgorithm(key.Algorithm) {
+	case config.JWTAlgorithmHS256:
+		ret.method = jwt.SigningMethodHS256
+		ret.private = key.PrivateKey
+		ret.public = key.PrivateKey
+		return ret, nil
+	case config.JWTAlgorithmEdDSA:
+		ret.method = jwt.SigningMethodEdDSA
+	case config.JWTAlgorithmRS256:
+		ret.method = jwt.SigningMethodRS256
+	default:
+		return ret, fmt.Errorf("unsupported algorithm %q", key.Algorithm)
+	}
+	private, err := x509.ParsePKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return ret, errors.Wrap(err, "parsing private key")
+	}
+	switch private := private.(type) {
+	case ed25519.PrivateKey:
+		if ret.method != jwt.SigningMethodEdDSA {
+			return ret, fmt.Errorf("unexpected Ed25519 key")
+		}
+		ret.private = private
+		ret.public = private.Public()
+	case *rsa.PrivateKey:
+		if ret.method != jwt.SigningMethodRS256 {
+			return ret, fmt.Errorf("unexpected RSA key")
+		}
+		ret.private = private
+		ret.public = &private.PublicKey
+	default:
+		return ret, fmt.Errorf("unsupported private key type %T", private)
+	}
+	return ret, nil
+}
+
+func generateSigningKey(algorithm config.JWTAlgorithm) (adminCommon.SigningKey, error) {
+	id, err := newSigningKeyID()
+	if err != nil {
+		return adminCommon.SigningKey{}, err
+	}
+	key := adminCommon.SigningKey{
+		ID:        id,
+		Algorithm: string(algorithm),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	var private interface{}
+	switch algorithm {
+	case config.JWTAlgorithmHS256:
+		key.PrivateKey = make([]byte, hmacKeySize)
+		if _, err := rand.Read(key.PrivateKey); err != nil {
+			return key, errors.Wrap(err, "generating HMAC key")
+		}
+		return key, nil
+	case config.JWTAlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case config.JWTAlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeySize)
+	default:
+		return key, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+	if err != nil {
+		return key, errors.Wrap(err, "generating key")
+	}
+	key.PrivateKey, err = x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return key, errors.Wrap(err, "encoding key")
+	}
+	return key, nil
+}
+
+// load replaces the keys in the ring with the ones in the store. Callers
+// must hold k.mux.
+func (k *KeyRing) load() error {
+	stored, err := k.store.List()
+	if err != nil {
+		return errors.Wrap(err, "loading signing keys")
+	}
+	keys := make(map[string]signingKey, len(stored))
+	var active signingKey
+	for _, key := range stored {
+		parsed, err := parseSigningKey(key)
+		if err != nil {
+			return errors.Wrapf(err, "loading signing key %s", key.ID)
+		}
+		keys[parsed.id] = parsed
+		if active.id == "" || parsed.createdAt.After(active.createdAt) {
+			active = parsed
+		}
+	}
+	k.keys = keys
+	k.active = active
+	k.loadedAt = time.Now()
+	return nil
+}
+
+// needsRotation returns true if new tokens should no longer be signed
+// with the active key. Callers must hold k.mux.
+func (k *KeyRing) needsRotation() bool {
+	if k.active.id == "" {
+		return true
+	}
+	if k.active.method.Alg() != string(k.cfg.Algorithm) {
+		return true
+	}
+	return time.Since(k.active.createdAt) >= k.cfg.KeyRotationDuration()
+}
+
+// rotateIfNeeded creates a new signing key if the active one needs to be
+// replaced, and deletes keys that can no longer have signed a valid
+// token. Callers must hold k.mux.
+func (k *KeyRing) rotateIfNeeded() error {
+	if !k.needsRotation() {
+		return nil
+	}
+	// Another instance may have replaced the key already.
+	if err := k.load(); err != nil {
+		return err
+	}
+	if !k.needsRotation() {
+		return nil
+	}
+
+	key, err := generateSigningKey(k.cfg.Algorithm)
+	if err != nil {
+		return errors.Wrap(err, "generating signing key")
+	}
+	if err := k.store.Create(key); err != nil {
+		return errors.Wrap(err, "storing signing key")
+	}
+	parsed, err := parseSigningKey(key)
+	if err != nil {
+		return errors.Wrap(err, "parsing signing key")
+	}
+	k.keys[parsed.id] = parsed
+	k.active = parsed
+
+	// Instances that have not reloaded their keys yet keep signing with
+	// the replaced key for up to keysRefreshInterval.
+	retention := k.cfg.TimeToLive.Duration() + keysRefreshInterval
+	if err := k.store.Prune(time.Now().UTC().Add(-retention)); err != nil {
+		return errors.Wrap(err, "pruning signing keys")
+	}
+	return k.load()
+}
+
+// refresh reloads the keys if they were loaded longer than maxAge ago.
+// Callers must hold k.mux.
+func (k *KeyRing) refresh(maxAge time.Duration) error {
+	if time.Since(k.loadedAt) < maxAge {
+		return nil
+	}
+	return k.load()
+}
+
+// Sign returns a token holding claims, signed with the active key. The ID
+// of the key is sent in the kid header.
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	k.mux.Lock()
+	err := k.refresh(keysRefreshInterval)
+	if err == nil {
+		err = k.rotateIfNeeded()
+	}
+	active := k.active
+	k.mux.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(active.method, claims)
+	token.Header["kid"] = active.id
+	signed, err := token.SignedString(active.private)
+	if err != nil {
+		return "", errors.Wrap(err, "signing token")
+	}
+	return signed, nil
+}
+
+// Keyfunc returns the key used to verify token, based on its kid header.
+// Login tokens issued before signing keys were introduced have no kid,
+// and are verified with the JWT secret until they expire.
+func (k *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, hasKid := token.Header["kid"]
+	if !hasKid {
+		return k.legacyKey(token)
+	}
+	id, ok := kid.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid kid header")
+	}
+
+	k.mux.Lock()
+	key, ok := k.keys[id]
+	if !ok {
+		// The key may have been created by another instance.
+		if err := k.refresh(unknownKeyRefreshInterval); err != nil {
+			k.mux.Unlock()
+			return nil, err
+		}
+		key, ok = k.keys[id]
+	}
+	k.mux.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", id)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("invalid signing method")
+	}
+	return key.public, nil
+}
+
+// legacyDeadline returns the time by which all tokens issued before
+// signing keys were introduced have expired: the time the oldest key was
+// created, plus the time to live of tokens. Keys are only pruned once
+// they are older than that, so pruning moves the deadline further into
+// the past. Callers must hold k.mux.
+func (k *KeyRing) legacyDeadline() time.Time {
+	var oldest time.Time
+	for _, key := range k.keys {
+		if oldest.IsZero() || key.createdAt.Before(oldest) {
+			oldest = key.createdAt
+		}
+	}
+	if oldest.IsZero() {
+		return oldest
+	}
+	return oldest.Add(k.cfg.TimeToLive.Duration())
+}
+
+// legacyKey returns the JWT secret if token is a login token issued
+// before signing keys were introduced, and the deadline for these tokens
+// has not passed.
+func (k *KeyRing) legacyKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("invalid signing method")
+	}
+	claims, ok := token.Claims.(*JWTClaims)
+	if !ok || claims.Issuer != LoginTokenIssuer || len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("token without a kid header is not a login token")
+	}
+	k.mux.Lock()
+	deadline := k.legacyDeadline()
+	k.mux.Unlock()
+	if !time.Now().Before(deadline) || claims.ExpiresAt == nil || claims.ExpiresAt.After(deadline) {
+		return nil, fmt.Errorf("tokens without a kid header are no longer accepted")
+	}
+	return []byte(k.cfg.Secret), nil
+}
+
+// JWKS returns the public keys that verify login tokens. HMAC keys are
+// secret, and are left out.
+func (k *KeyRing) JWKS() (params.JSONWebKeySet, error) {
+	k.mux.Lock()
+	err := k.refresh(keysRefreshInterval)
+	keys := make([]signingKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	k.mux.Unlock()
+	if err != nil {
+		return params.JSONWebKeySet{}, err
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].createdAt.After(keys[j].createdAt)
+	})
+
+	ret := params.JSONWebKeySet{
+		Keys: []params.JSONWebKey{},
+	}
+	for _, key := range keys {
+		jwk := params.JSONWebKey{
+			Kid: key.id,
+			Alg: key.method.Alg(),
+			Use: "sig",
+		}
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+		ret.Keys = append(ret.Keys, jwk)
+	}
+	return ret, nil
+}
@@ -0,0 +1,344 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+
+	"gopherbin/auth"
+	"gopherbin/config"
+	"gopherbin/params"
+)
+
+func signedClaims(userID uint) *auth.JWTClaims {
+	return &auth.JWTClaims{
+		UserID:  userID,
+		TokenID: "tok",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    auth.LoginTokenIssuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func signWith(t *testing.T, keys *auth.KeyRing) (string, string) {
+	t.Helper()
+	signed, err := keys.Sign(signedClaims(1))
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(signed, &auth.JWTClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		t.Fatal("token has no kid header")
+	}
+	return signed, kid
+}
+
+func verifyWith(keys *auth.KeyRing, signed string) error {
+	_, err := jwt.ParseWithClaims(signed, &auth.JWTClaims{}, keys.Keyfunc)
+	return err
+}
+
+// publicKey decodes a key published in the JWKS.
+func publicKey(t *testing.T, jwk params.JSONWebKey) interface{} {
+	t.Helper()
+	decode := func(val string) []byte {
+		data, err := base64.RawURLEncoding.DecodeString(val)
+		if err != nil {
+			t.Fatalf("decoding %q: %v", val, err)
+		}
+		return data
+	}
+	switch jwk.Kty {
+	case "OKP":
+		return ed25519.PublicKey(decode(jwk.X))
+	case "RSA":
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(decode(jwk.N)),
+			E: int(new(big.Int).SetBytes(decode(jwk.E)).Int64()),
+		}
+	}
+	t.Fatalf("unexpected key type %q", jwk.Kty)
+	return nil
+}
+
+// ── Signing and verifying ────────────────────────────────────────────────────
+
+func TestKeyRing_AsymmetricKeysArePublished(t *testing.T) {
+	for _, tc := range []struct {
+		algorithm config.JWTAlgorithm
+		kty       string
+	}{
+		{config.JWTAlgorithmEdDSA, "OKP"},
+		{config.JWTAlgorithmRS256, "RSA"},
+	} {
+		t.Run(string(tc.algorithm), func(t *testing.T) {
+			keys := newKeyRing(t, &mockSigningKeys{}, config.JWTAuth{Secret: testSecret, Algorithm: tc.algorithm})
+			signed, kid := signWith(t, keys)
+			if err := verifyWith(keys, signed); err != nil {
+				t.Fatalf("verifying token: %v", err)
+			}
+
+			set, err := keys.JWKS()
+			if err != nil {
+				t.Fatalf("JWKS: %v", err)
+			}
+			if len(set.Keys) != 1 {
+				t.Fatalf("want 1 published key, got %d", len(set.Keys))
+			}
+			jwk := set.Keys[0]
+			if jwk.Kid != kid || jwk.Kty != tc.kty || jwk.Alg != string(tc.algorithm) || jwk.Use != "sig" {
+				t.Errorf("unexpected key %+v", jwk)
+			}
+
+			// Other services verify tokens with the published key alone.
+			_, err = jwt.Parse(signed, func(*jwt.Token) (interface{}, error) {
+				return publicKey(t, jwk), nil
+			}, jwt.WithValidMethods([]string{string(tc.algorithm)}))
+			if err != nil {
+				t.Errorf("verifying token with published key: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyRing_HMACKeysAreNotPublished(t *testing.T) {
+	keys := newKeyRing(t, &mockSigningKeys{}, config.JWTAuth{Secret: testSecret})
+	signed, _ := signWith(t, keys)
+	if err := verifyWith(keys, signed); err != nil {
+		t.Fatalf("verifying token: %v", err)
+	}
+	set, err := keys.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS: %v", err)
+	}
+	if len(set.Keys) != 0 {
+		t.Errorf("want no published keys, got %+v", set.Keys)
+	}
+	// The generated key is not the JWT secret.
+	if _, err := jwt.Parse(signed, func(*jwt.Token) (interface{}, error) { return []byte(testSecret), nil }); err == nil {
+		t.Error("token signed with a generated key verified with the JWT secret")
+	}
+}
+
+func TestKeyRing_VerifiesLegacyTokens(t *testing.T) {
+	// Tokens issued before signing keys were introduced have no kid, and
+	// are signed with the JWT secret.
+	keys := newKeyRing(t, &mockSigningKeys{}, config.JWTAuth{Secret: testSecret, Algorithm: config.JWTAlgorithmEdDSA})
+	if err := verifyWith(keys, makeJWT(t, *signedClaims(1), testSecret)); err != nil {
+		t.Errorf("verifying legacy token: %v", err)
+	}
+}
+
+func TestKeyRing_RejectsLegacyTokensAfterDeadline(t *testing.T) {
+	// Legacy tokens were issued before the first signing key was
+	// created, so they expire at the latest one time to live later.
+	store := &mockSigningKeys{}
+	cfg := config.JWTAuth{Secret: testSecret}
+	keys := newKeyRing(t, store, cfg)
+	ttl := config.DefaultJWTTTL
+
+	expiresAfterDeadline := *signedClaims(1)
+	expiresAfterDeadline.ExpiresAt = jwt.NewNumericDate(time.Now().Add(ttl + time.Hour))
+	noExpiry := *signedClaims(1)
+	noExpiry.ExpiresAt = nil
+	for name, claims := range map[string]auth.JWTClaims{
+		"expires after the deadline": expiresAfterDeadline,
+		"no expiry":                  noExpiry,
+	} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims)
+		signed, err := token.SignedString([]byte(testSecret))
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+		if err := verifyWith(keys, signed); err == nil {
+			t.Errorf("%s: expected legacy token to be rejected", name)
+		}
+	}
+
+	// Age the first key past the time to live.
+	store.keys[0].CreatedAt = time.Now().Add(-ttl - time.Minute)
+	keys = newKeyRing(t, store, cfg)
+	if err := verifyWith(keys, makeJWT(t, *signedClaims(1), testSecret)); err == nil {
+		t.Error("expected legacy token to be rejected after the deadline")
+	}
+	signed, _ := signWith(t, keys)
+	if err := verifyWith(keys, signed); err != nil {
+		t.Errorf("verifying token signed with a generated key: %v", err)
+	}
+}
+
+func TestKeyRing_RejectsAuxiliaryTokens(t *testing.T) {
+	// Pre-auth, password reset and email change tokens carry no kid and
+	// must never be accepted as login tokens.
+	keys := newKeyRing(t, &mockSigningKeys{}, config.JWTAuth{Secret: testSecret})
+	ctx := auth.PopulateContext(context.Background(), params.Users{ID: 1, SecurityStamp: "stamp"})
+
+	preAuth, err := auth.NewPreAuthToken(ctx, testSecret, nil)
+	if err != nil {
+		t.Fatalf("NewPreAuthToken: %v", err)
+	}
+	reset, err := auth.NewPasswordResetToken(ctx, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("NewPasswordResetToken: %v", err)
+	}
+	emailChange, err := auth.NewEmailChangeToken(ctx, testSecret, "old@example.com", "new@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("NewEmailChangeToken: %v", err)
+	}
+	otherIssuer := *signedClaims(1)
+	otherIssuer.Issuer = "gopherbin-2fa"
+	withAudience := *signedClaims(1)
+	withAudience.Audience = jwt.ClaimStrings{"other"}
+
+	for name, signed := range map[string]string{
+		"pre-auth":       preAuth,
+		"password reset": reset,
+		"email change":   emailChange,
+		"other issuer":   makeJWT(t, otherIssuer, testSecret),
+		"audience":       makeJWT(t, withAudience, testSecret),
+	} {
+		if err := verifyWith(keys, signed); err == nil {
+			t.Errorf("%s: expected token to be rejected", name)
+		}
+	}
+
+	// The auxiliary tokens are not signed with the secret itself.
+	if _, err := jwt.Parse(preAuth, func(*jwt.Token) (interface{}, error) {
+		return []byte(testSecret), nil
+	}); err == nil {
+		t.Error("pre-auth token verified with the JWT secret")
+	}
+	if _, err := auth.ParsePreAuthToken(preAuth, testSecret); err != nil {
+		t.Errorf("ParsePreAuthToken: %v", err)
+	}
+}
+
+func TestKeyRing_RejectsInvalidTokens(t *testing.T) {
+	keys := newKeyRing(t, &mockSigningKeys{}, config.JWTAuth{Secret: testSecret, Algorithm: config.JWTAlgorithmEdDSA})
+	_, kid := signWith(t, keys)
+
+	set, err := keys.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS: %v", err)
+	}
+	public := publicKey(t, set.Keys[0]).(ed25519.PublicKey)
+
+	sign := func(header map[string]interface{}, key interface{}) string {
+		t.Helper()
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, signedClaims(1))
+		for name, val := range header {
+			token.Header[name] = val
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+		return signed
+	}
+
+	for name, signed := range map[string]string{
+		// An HMAC token keyed with the public key of the active key.
+		"algorithm confusion": sign(map[string]interface{}{"kid": kid}, []byte(public)),
+		"unknown kid":         sign(map[string]interface{}{"kid": "unknown"}, []byte(testSecret)),
+		"wrong secret":        makeJWT(t, *signedClaims(1), "other-secret"),
+	} {
+		if err := verifyWith(keys, signed); err == nil {
+			t.Errorf("%s: expected token to be rejected", name)
+		}
+	}
+}
+
+// ── Rotation ─────────────────────────────────────────────────────────────────
+
+func TestKeyRing_RotatesExpiredKeys(t *testing.T) {
+	store := &mockSigningKeys{}
+	cfg := config.JWTAuth{Secret: testSecret, Algorithm: config.JWTAlgorithmEdDSA, KeyRotationInterval: "1h"}
+	keys := newKeyRing(t, store, cfg)
+	oldToken, oldKid := signWith(t, keys)
+
+	// Age the key past the rotation interval.
+	store.keys[0].CreatedAt = time.Now().Add(-2 * time.Hour)
+	keys = newKeyRing(t, store, cfg)
+	if store.created != 2 {
+		t.Fatalf("want a new key to be created, got %d keys created", store.created)
+	}
+	newToken, newKid := signWith(t, keys)
+	if newKid == oldKid {
+		t.Fatal("want new tokens to be signed with the new key")
+	}
+	for _, signed := range []string{oldToken, newToken} {
+		if err := verifyWith(keys, signed); err != nil {
+			t.Errorf("verifying token: %v", err)
+		}
+	}
+	set, err := keys.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS: %v", err)
+	}
+	if len(set.Keys) != 2 || set.Keys[0].Kid != newKid || set.Keys[1].Kid != oldKid {
+		t.Errorf("want both keys published, newest first, got %+v", set.Keys)
+	}
+
+	// Once the tokens signed with the old key have expired, it is deleted
+	// the next time a key is replaced.
+	for idx := range store.keys {
+		store.keys[idx].CreatedAt = store.keys[idx].CreatedAt.Add(-72 * time.Hour)
+	}
+	keys = newKeyRing(t, store, cfg)
+	if store.created != 3 {
+		t.Fatalf("want a new key to be created, got %d keys created", store.created)
+	}
+	stored, _ := store.List()
+	if len(stored) != 2 || stored[1].ID != newKid {
+		t.Errorf("want the oldest key pruned, got %+v", stored)
+	}
+	if err := verifyWith(keys, oldToken); err == nil {
+		t.Error("expected token signed with a pruned key to be rejected")
+	}
+}
+
+func TestKeyRing_RotatesOnAlgorithmChange(t *testing.T) {
+	store := &mockSigningKeys{}
+	keys := newKeyRing(t, store, config.JWTAuth{Secret: testSecret})
+	hmacToken, _ := signWith(t, keys)
+
+	keys = newKeyRing(t, store, config.JWTAuth{Secret: testSecret, Algorithm: config.JWTAlgorithmRS256})
+	if store.created != 2 {
+		t.Fatalf("want a new key to be created, got %d keys created", store.created)
+	}
+	signed, _ := signWith(t, keys)
+	token, _, _ := jwt.NewParser().ParseUnverified(signed, &auth.JWTClaims{})
+	if token.Method.Alg() != "RS256" {
+		t.Errorf("want tokens signed with RS256, got %s", token.Method.Alg())
+	}
+	if err := verifyWith(keys, hmacToken); err != nil {
+		t.Errorf("token signed with the previous key: %v", err)
+	}
+}
+
+func TestKeyRing_LoadsKeysOfOtherInstances(t *testing.T) {
+	store := &mockSigningKeys{}
+	cfg := config.JWTAuth{Secret: testSecret, Algorithm: config.JWTAlgorithmEdDSA}
+	keys := newKeyRing(t, store, cfg)
+	// Another instance replaces the key.
+	store.keys[0].CreatedAt = time.Now().Add(-2 * config.DefaultJWTKeyRotationInterval)
+	other := newKeyRing(t, store, cfg)
+	signed, _ := signWith(t, other)
+
+	// Unknown keys are looked up at most once a second.
+	time.Sleep(time.Second)
+	if err := verifyWith(keys, signed); err != nil {
+		t.Errorf("verifying token signed by another instance: %v", err)
+	}
+}
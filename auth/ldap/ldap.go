@@ -0,0 +1,328 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package ldap authenticates users against an LDAP server or Active
+// Directory.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+
+	adminCommon "gopherbin/admin/common"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+	"gopherbin/util"
+)
+
+// ProviderName is the auth provider recorded for users authenticated
+// using LDAP
+const ProviderName = "ldap"
+
+// NewProvider returns a new LDAP provider
+func NewProvider(cfg config.LDAP) (*Provider, error) {
+	if !cfg.Enable {
+		return nil, fmt.Errorf("ldap is not enabled")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validating ldap config")
+	}
+	serverURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing ldap URL")
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         serverURL.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CACertificate != "" {
+		caCertPEM, err := ioutil.ReadFile(cfg.CACertificate)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading CA certificate")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCertPEM) {
+			return nil, fmt.Errorf("failed to parse CA certificate")
+		}
+	}
+	return &Provider{
+		cfg:       cfg,
+		tlsConfig: tlsConfig,
+	}, nil
+}
+
+// Provider authenticates users against an LDAP server. It implements
+// the common.Directory interface.
+type Provider struct {
+	cfg       config.LDAP
+	tlsConfig *tls.Config
+}
+
+// Name returns the auth provider recorded for LDAP users
+func (p *Provider) Name() string {
+	return ProviderName
+}
+
+func (p *Provider) dial() (*goldap.Conn, error) {
+	timeout := p.cfg.TimeoutDuration()
+	conn, err := goldap.DialURL(p.cfg.URL,
+		goldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		goldap.DialWithTLSConfig(p.tlsConfig))
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to ldap server")
+	}
+	conn.SetTimeout(timeout)
+	if p.cfg.StartTLS {
+		if err := conn.StartTLS(p.tlsConfig); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "starting TLS")
+		}
+	}
+	return conn, nil
+}
+
+// fill replaces %s in a filter or DN template with val
+func fill(template, val string) string {
+	return strings.ReplaceAll(template, "%s", val)
+}
+
+func (p *Provider) userAttributes() []string {
+	attrs := []string{p.cfg.UsernameAttribute, p.cfg.EmailAttribute, p.cfg.FullNameAttribute}
+	if p.cfg.IDAttribute != "" {
+		attrs = append(attrs, p.cfg.IDAttribute)
+	}
+	return attrs
+}
+
+// bindAsUser checks the password of the user identified by dn
+func bindAsUser(conn *goldap.Conn, dn, password string) error {
+	if err := conn.Bind(dn, password); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return gErrors.ErrInvalidCredentials
+		}
+		return errors.Wrap(err, "binding as user")
+	}
+	return nil
+}
+
+// searchUser returns the user matching the user filter under baseDN.
+func (p *Provider) searchUser(conn *goldap.Conn, baseDN string, scope int, username string) (*goldap.Entry, error) {
+	req := goldap.NewSearchRequest(
+		baseDN, scope, goldap.NeverDerefAliases, 2, int(p.cfg.TimeoutDuration().Seconds()), false,
+		fill(p.cfg.UserFilter, goldap.EscapeFilter(username)),
+		p.userAttributes(), nil)
+	result, err := conn.Search(req)
+	if err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultNoSuchObject) {
+			return nil, gErrors.ErrUserNotFound
+		}
+		return nil, errors.Wrap(err, "searching for user")
+	}
+	switch len(result.Entries) {
+	case 0:
+		return nil, gErrors.ErrUserNotFound
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, fmt.Errorf("more than one user matches %q", username)
+	}
+}
+
+// isDisabled returns true if the user identified by dn matches the
+// disabled filter.
+func (p *Provider) isDisabled(conn *goldap.Conn, dn string) (bool, error) {
+	if p.cfg.DisabledFilter == "" {
+		return false, nil
+	}
+	req := goldap.NewSearchRequest(
+		dn, goldap.ScopeBaseObject, goldap.NeverDerefAliases, 1, int(p.cfg.TimeoutDuration().Seconds()), false,
+		p.cfg.DisabledFilter, []string{"1.1"}, nil)
+	result, err := conn.Search(req)
+	if err != nil {
+		return false, errors.Wrap(err, "checking if user is disabled")
+	}
+	return len(result.Entries) > 0, nil
+}
+
+// groups returns the names of the groups the user identified by dn is
+// a member of.
+func (p *Provider) groups(conn *goldap.Conn, dn string) ([]string, error) {
+	if p.cfg.GroupBaseDN == "" {
+		return nil, nil
+	}
+	req := goldap.NewSearchRequest(
+		p.cfg.GroupBaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 0, int(p.cfg.TimeoutDuration().Seconds()), false,
+		fill(p.cfg.GroupFilter, goldap.EscapeFilter(dn)),
+		[]string{p.cfg.GroupNameAttribute}, nil)
+	result, err := conn.Search(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "searching for groups")
+	}
+	var groups []string
+	for _, entry := range result.Entries {
+		if name := entry.GetAttributeValue(p.cfg.GroupNameAttribute); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+func hasGroup(groups []string, name string) bool {
+	for _, group := range groups {
+		if strings.EqualFold(group, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// subject returns the value that uniquely identifies entry
+func (p *Provider) subject(entry *goldap.Entry) string {
+	if p.cfg.IDAttribute == "" {
+		return strings.ToLower(entry.DN)
+	}
+	val := entry.GetRawAttributeValue(p.cfg.IDAttribute)
+	// Active Directory uses binary GUIDs.
+	if !utf8.Valid(val) {
+		return hex.EncodeToString(val)
+	}
+	return string(val)
+}
+
+// identity maps a user entry and its groups to a gopherbin identity
+func (p *Provider) identity(entry *goldap.Entry, enabled bool, groups []string) adminCommon.ExternalIdentity {
+	identity := adminCommon.ExternalIdentity{
+		Provider: ProviderName,
+		Subject:  p.subject(entry),
+		Username: util.UsernameFrom(entry.GetAttributeValue(p.cfg.UsernameAttribute)),
+		Email:    entry.GetAttributeValue(p.cfg.EmailAttribute),
+		// The directory is managed by the same people that manage
+		// gopherbin.
+		EmailVerified: true,
+		FullName:      entry.GetAttributeValue(p.cfg.FullNameAttribute),
+		Enabled:       &enabled,
+	}
+	if !enabled {
+		// Groups are not looked up for disabled users.
+		return identity
+	}
+	if len(p.cfg.AdminGroups) > 0 {
+		isAdmin := false
+		for _, adminGroup := range p.cfg.AdminGroups {
+			if hasGroup(groups, adminGroup) {
+				isAdmin = true
+			}
+		}
+		identity.IsAdmin = &isAdmin
+	}
+	if len(p.cfg.TeamGroups) > 0 {
+		managed := map[string]bool{}
+		member := map[string]bool{}
+		for group, team := range p.cfg.TeamGroups {
+			managed[team] = true
+			if hasGroup(groups, group) {
+				member[team] = true
+			}
+		}
+		for team := range managed {
+			identity.ManagedTeams = append(identity.ManagedTeams, team)
+		}
+		for team := range member {
+			identity.Teams = append(identity.Teams, team)
+		}
+		sort.Strings(identity.ManagedTeams)
+		sort.Strings(identity.Teams)
+	}
+	return identity
+}
+
+// Authenticate checks the credentials of a user by binding as that user,
+// and returns the identity of the user.
+func (p *Provider) Authenticate(ctx context.Context, username, password string) (adminCommon.ExternalIdentity, error) {
+	// An empty password results in an unauthenticated bind, which
+	// succeeds for any DN on most servers.
+	if username == "" || password == "" {
+		return adminCommon.ExternalIdentity{}, gErrors.ErrInvalidCredentials
+	}
+	if err := ctx.Err(); err != nil {
+		return adminCommon.ExternalIdentity{}, err
+	}
+	conn, err := p.dial()
+	if err != nil {
+		return adminCommon.ExternalIdentity{}, err
+	}
+	defer conn.Close()
+
+	var entry *goldap.Entry
+	if p.cfg.BindDN != "" {
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return adminCommon.ExternalIdentity{}, errors.Wrap(err, "binding as service account")
+		}
+		entry, err = p.searchUser(conn, p.cfg.UserBaseDN, goldap.ScopeWholeSubtree, username)
+		if err != nil {
+			return adminCommon.ExternalIdentity{}, err
+		}
+		disabled, err := p.isDisabled(conn, entry.DN)
+		if err != nil {
+			return adminCommon.ExternalIdentity{}, err
+		}
+		if disabled {
+			// Disabled users are rejected by gopherbin without checking
+			// their password, the same way local users are.
+			return p.identity(entry, false, nil), nil
+		}
+		if err := bindAsUser(conn, entry.DN, password); err != nil {
+			return adminCommon.ExternalIdentity{}, err
+		}
+		// Look up groups using the service account, as users may not
+		// be allowed to.
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return adminCommon.ExternalIdentity{}, errors.Wrap(err, "binding as service account")
+		}
+	} else {
+		dn := fill(p.cfg.UserDNTemplate, goldap.EscapeDN(username))
+		if err := bindAsUser(conn, dn, password); err != nil {
+			return adminCommon.ExternalIdentity{}, err
+		}
+		entry, err = p.searchUser(conn, dn, goldap.ScopeBaseObject, username)
+		if err != nil {
+			return adminCommon.ExternalIdentity{}, err
+		}
+		disabled, err := p.isDisabled(conn, entry.DN)
+		if err != nil {
+			return adminCommon.ExternalIdentity{}, err
+		}
+		if disabled {
+			return p.identity(entry, false, nil), nil
+		}
+	}
+
+	groups, err := p.groups(conn, entry.DN)
+	if err != nil {
+		return adminCommon.ExternalIdentity{}, err
+	}
+	return p.identity(entry, true, groups), nil
+}
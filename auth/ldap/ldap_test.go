@@ -0,0 +1,237 @@
+package ldap_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gopherbin/auth/ldap"
+	"gopherbin/auth/ldap/ldaptest"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+)
+
+const (
+	peopleDN  = "ou=people,dc=example,dc=com"
+	groupsDN  = "ou=groups,dc=example,dc=com"
+	serviceDN = "cn=gopherbin,ou=services,dc=example,dc=com"
+	aliceDN   = "uid=alice,ou=people,dc=example,dc=com"
+)
+
+// newDirectory starts an LDAP server holding a service account, two
+// users and a few groups.
+func newDirectory(t *testing.T) *ldaptest.Server {
+	t.Helper()
+	srv := ldaptest.NewServer()
+	t.Cleanup(srv.Close)
+
+	srv.AddEntry(ldaptest.Entry{
+		DN:         serviceDN,
+		Password:   "service-secret",
+		Attributes: map[string][]string{"objectClass": {"person"}, "cn": {"gopherbin"}},
+	})
+	srv.AddEntry(ldaptest.Entry{
+		DN:       aliceDN,
+		Password: "alice-secret",
+		Attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"alice"},
+			"mail":        {"alice@example.com"},
+			"cn":          {"Alice Smith"},
+			"entryUUID":   {"0b5e0d3c-2d4c-4e0a-9c55-1f0f3a3c1a01"},
+		},
+	})
+	srv.AddEntry(ldaptest.Entry{
+		DN:       "uid=bob,ou=people,dc=example,dc=com",
+		Password: "bob-secret",
+		Attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"bob"},
+			"mail":        {"bob@example.com"},
+			"cn":          {"Bob Jones"},
+		},
+	})
+	srv.AddEntry(ldaptest.Entry{
+		DN: "cn=developers," + groupsDN,
+		Attributes: map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"cn":          {"developers"},
+			"member":      {aliceDN, "uid=bob,ou=people,dc=example,dc=com"},
+		},
+	})
+	srv.AddEntry(ldaptest.Entry{
+		DN: "cn=Paste-Admins," + groupsDN,
+		Attributes: map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"cn":          {"Paste-Admins"},
+			"member":      {aliceDN},
+		},
+	})
+	return srv
+}
+
+func serviceAccountConfig(srv *ldaptest.Server) config.LDAP {
+	return config.LDAP{
+		Enable:       true,
+		URL:          srv.URL,
+		BindDN:       serviceDN,
+		BindPassword: "service-secret",
+		UserBaseDN:   peopleDN,
+		UserFilter:   "(&(objectClass=inetOrgPerson)(|(uid=%s)(mail=%s)))",
+		GroupBaseDN:  groupsDN,
+		AdminGroups:  []string{"paste-admins"},
+		TeamGroups: map[string]string{
+			"developers": "devs",
+			"operations": "ops",
+		},
+	}
+}
+
+func newProvider(t *testing.T, cfg config.LDAP) *ldap.Provider {
+	t.Helper()
+	provider, err := ldap.NewProvider(cfg)
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return provider
+}
+
+func TestAuthenticate_ServiceAccount(t *testing.T) {
+	srv := newDirectory(t)
+	provider := newProvider(t, serviceAccountConfig(srv))
+
+	for _, login := range []string{"alice", "alice@example.com"} {
+		identity, err := provider.Authenticate(context.Background(), login, "alice-secret")
+		if err != nil {
+			t.Fatalf("Authenticate(%s): %v", login, err)
+		}
+		if identity.Provider != ldap.ProviderName || identity.Subject != "uid=alice,ou=people,dc=example,dc=com" {
+			t.Errorf("unexpected provider or subject: %+v", identity)
+		}
+		if identity.Username != "alice" || identity.Email != "alice@example.com" || identity.FullName != "Alice Smith" || !identity.EmailVerified {
+			t.Errorf("unexpected user details: %+v", identity)
+		}
+		if identity.Enabled == nil || !*identity.Enabled {
+			t.Errorf("expected enabled user: %+v", identity)
+		}
+		if identity.IsAdmin == nil || !*identity.IsAdmin {
+			t.Errorf("expected admin group to grant admin: %+v", identity)
+		}
+		if !reflect.DeepEqual(identity.ManagedTeams, []string{"devs", "ops"}) || !reflect.DeepEqual(identity.Teams, []string{"devs"}) {
+			t.Errorf("unexpected teams: managed %v, member of %v", identity.ManagedTeams, identity.Teams)
+		}
+	}
+
+	identity, err := provider.Authenticate(context.Background(), "bob", "bob-secret")
+	if err != nil {
+		t.Fatalf("Authenticate(bob): %v", err)
+	}
+	if identity.IsAdmin == nil || *identity.IsAdmin {
+		t.Errorf("expected bob not to be an admin: %+v", identity)
+	}
+}
+
+func TestAuthenticate_UserDNTemplate(t *testing.T) {
+	srv := newDirectory(t)
+	provider := newProvider(t, config.LDAP{
+		Enable:         true,
+		URL:            srv.URL,
+		UserDNTemplate: "uid=%s," + peopleDN,
+		IDAttribute:    "entryUUID",
+	})
+
+	identity, err := provider.Authenticate(context.Background(), "alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Subject != "0b5e0d3c-2d4c-4e0a-9c55-1f0f3a3c1a01" || identity.Username != "alice" {
+		t.Errorf("unexpected identity: %+v", identity)
+	}
+	if identity.IsAdmin != nil || identity.ManagedTeams != nil {
+		t.Errorf("expected groups to be ignored: %+v", identity)
+	}
+
+	_, err = provider.Authenticate(context.Background(), "alice", "wrong")
+	if !errors.Is(err, gErrors.ErrInvalidCredentials) {
+		t.Errorf("wrong password: want ErrInvalidCredentials, got %v", err)
+	}
+	_, err = provider.Authenticate(context.Background(), "nobody", "secret")
+	if !errors.Is(err, gErrors.ErrInvalidCredentials) {
+		t.Errorf("unknown user: want ErrInvalidCredentials, got %v", err)
+	}
+}
+
+func TestAuthenticate_InvalidCredentials(t *testing.T) {
+	srv := newDirectory(t)
+	provider := newProvider(t, serviceAccountConfig(srv))
+
+	_, err := provider.Authenticate(context.Background(), "alice", "wrong")
+	if !errors.Is(err, gErrors.ErrInvalidCredentials) {
+		t.Errorf("wrong password: want ErrInvalidCredentials, got %v", err)
+	}
+	_, err = provider.Authenticate(context.Background(), "nobody", "secret")
+	if !errors.Is(err, gErrors.ErrUserNotFound) {
+		t.Errorf("unknown user: want ErrUserNotFound, got %v", err)
+	}
+
+	// Empty passwords would result in an unauthenticated bind.
+	binds := srv.Binds()
+	_, err = provider.Authenticate(context.Background(), "alice", "")
+	if !errors.Is(err, gErrors.ErrInvalidCredentials) {
+		t.Errorf("empty password: want ErrInvalidCredentials, got %v", err)
+	}
+	if srv.Binds() != binds {
+		t.Error("expected no bind for empty password")
+	}
+}
+
+func TestAuthenticate_FilterInjection(t *testing.T) {
+	srv := newDirectory(t)
+	provider := newProvider(t, serviceAccountConfig(srv))
+
+	_, err := provider.Authenticate(context.Background(), "*", "alice-secret")
+	if !errors.Is(err, gErrors.ErrUserNotFound) {
+		t.Errorf("want ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestAuthenticate_Disabled(t *testing.T) {
+	srv := newDirectory(t)
+	cfg := serviceAccountConfig(srv)
+	cfg.DisabledFilter = "(accountStatus=disabled)"
+	provider := newProvider(t, cfg)
+
+	srv.SetAttribute(aliceDN, "accountStatus", "disabled")
+	identity, err := provider.Authenticate(context.Background(), "alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Enabled == nil || *identity.Enabled {
+		t.Errorf("expected disabled user: %+v", identity)
+	}
+	if identity.IsAdmin != nil || identity.ManagedTeams != nil {
+		t.Errorf("expected groups to be ignored for disabled users: %+v", identity)
+	}
+
+	srv.SetAttribute(aliceDN, "accountStatus", "active")
+	identity, err = provider.Authenticate(context.Background(), "alice", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Enabled == nil || !*identity.Enabled {
+		t.Errorf("expected enabled user: %+v", identity)
+	}
+}
+
+func TestAuthenticate_ServerUnavailable(t *testing.T) {
+	srv := newDirectory(t)
+	cfg := serviceAccountConfig(srv)
+	srv.Close()
+	provider := newProvider(t, cfg)
+
+	_, err := provider.Authenticate(context.Background(), "alice", "alice-secret")
+	if err == nil || errors.Is(err, gErrors.ErrInvalidCredentials) || errors.Is(err, gErrors.ErrNotFound) {
+		t.Fatalf("want connection error, got %v", err)
+	}
+}
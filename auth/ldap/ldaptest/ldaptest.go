@@ -0,0 +1,432 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package ldaptest provides a minimal, in-process LDAP server for use in
+// tests. It supports simple binds and searches using the and, or, not,
+// equality, substring and presence filters.
+package ldaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+// LDAP protocol operations
+const (
+	opBindRequest        = 0
+	opBindResponse       = 1
+	opUnbindRequest      = 2
+	opSearchRequest      = 3
+	opSearchResultEntry  = 4
+	opSearchResultDone   = 5
+	opExtendedRequest    = 23
+	opExtendedResponse   = 24
+	scopeBaseObject      = 0
+	scopeSingleLevel     = 1
+	resultSuccess        = 0
+	resultProtocolError  = 2
+	resultNoSuchObject   = 32
+	resultInvalidCreds   = 49
+	resultInsufficient   = 50
+	resultUnwilling      = 53
+	filterAnd            = 0
+	filterOr             = 1
+	filterNot            = 2
+	filterEqualityMatch  = 3
+	filterSubstrings     = 4
+	filterPresent        = 7
+	substringInitial     = 0
+	substringAny         = 1
+	substringFinal       = 2
+	simpleAuthentication = 0
+)
+
+// Entry is an entry in the directory
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+	// Password is used to authenticate binds as this entry. Binds are
+	// refused if empty.
+	Password string
+}
+
+func (e Entry) values(attr string) []string {
+	for name, vals := range e.Attributes {
+		if strings.EqualFold(name, attr) {
+			return vals
+		}
+	}
+	return nil
+}
+
+// Server is a fake LDAP server
+type Server struct {
+	// URL is the ldap:// URL of the server
+	URL string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mux     sync.Mutex
+	entries []Entry
+	conns   map[net.Conn]bool
+	binds   int
+}
+
+// NewServer starts a new LDAP server on a random local port. The caller
+// must call Close when done.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	srv := &Server{
+		URL:      "ldap://" + listener.Addr().String(),
+		listener: listener,
+		conns:    map[net.Conn]bool{},
+	}
+	srv.wg.Add(1)
+	go srv.serve()
+	return srv
+}
+
+// Close stops the server and closes all connections
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mux.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mux.Unlock()
+	s.wg.Wait()
+}
+
+// AddEntry adds an entry to the directory, replacing any entry with the
+// same DN.
+func (s *Server) AddEntry(entry Entry) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for idx := range s.entries {
+		if normalizeDN(s.entries[idx].DN) == normalizeDN(entry.DN) {
+			s.entries[idx] = entry
+			return
+		}
+	}
+	s.entries = append(s.entries, entry)
+}
+
+// RemoveEntry removes the entry identified by dn
+func (s *Server) RemoveEntry(dn string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for idx := range s.entries {
+		if normalizeDN(s.entries[idx].DN) == normalizeDN(dn) {
+			s.entries = append(s.entries[:idx], s.entries[idx+1:]...)
+			return
+		}
+	}
+}
+
+// SetAttribute replaces the values of an attribute of an entry
+func (s *Server) SetAttribute(dn, attr string, values ...string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, entry := range s.entries {
+		if normalizeDN(entry.DN) == normalizeDN(dn) {
+			for name := range entry.Attributes {
+				if strings.EqualFold(name, attr) {
+					delete(entry.Attributes, name)
+				}
+			}
+			entry.Attributes[attr] = values
+			return
+		}
+	}
+}
+
+// Binds returns the number of bind requests the server received
+func (s *Server) Binds() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.binds
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mux.Lock()
+		s.conns[conn] = true
+		s.mux.Unlock()
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		conn.Close()
+		s.mux.Lock()
+		delete(s.conns, conn)
+		s.mux.Unlock()
+	}()
+
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		var responses []*ber.Packet
+		switch op.Tag {
+		case opBindRequest:
+			code := s.bind(op)
+			bound = code == resultSuccess
+			responses = append(responses, result(opBindResponse, code))
+		case opUnbindRequest:
+			return
+		case opSearchRequest:
+			if !bound {
+				responses = append(responses, result(opSearchResultDone, resultInsufficient))
+			} else {
+				responses = s.search(op)
+			}
+		case opExtendedRequest:
+			responses = append(responses, result(opExtendedResponse, resultUnwilling))
+		default:
+			responses = append(responses, result(opExtendedResponse, resultProtocolError))
+		}
+		for _, response := range responses {
+			envelope := ber.NewSequence("LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+			envelope.AppendChild(response)
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func result(op ber.Tag, code int) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "Result")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return packet
+}
+
+func stringValue(packet *ber.Packet) string {
+	if packet.Data == nil {
+		return ""
+	}
+	return packet.Data.String()
+}
+
+// normalizeDN lowercases dn and removes spaces around separators
+func normalizeDN(dn string) string {
+	parts := strings.Split(strings.ToLower(dn), ",")
+	for idx, part := range parts {
+		rdn := strings.SplitN(part, "=", 2)
+		for i := range rdn {
+			rdn[i] = strings.TrimSpace(rdn[i])
+		}
+		parts[idx] = strings.Join(rdn, "=")
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *Server) bind(op *ber.Packet) int {
+	if len(op.Children) < 3 || op.Children[2].Tag != simpleAuthentication {
+		return resultProtocolError
+	}
+	dn := normalizeDN(stringValue(op.Children[1]))
+	password := stringValue(op.Children[2])
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.binds++
+	for _, entry := range s.entries {
+		if normalizeDN(entry.DN) == dn {
+			if entry.Password != "" && entry.Password == password {
+				return resultSuccess
+			}
+			break
+		}
+	}
+	return resultInvalidCreds
+}
+
+// inScope returns true if dn is within the search scope
+func inScope(dn, base string, scope int64) bool {
+	switch scope {
+	case scopeBaseObject:
+		return dn == base
+	case scopeSingleLevel:
+		idx := strings.Index(dn, ",")
+		return idx >= 0 && dn[idx+1:] == base
+	default:
+		return dn == base || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+func (s *Server) search(op *ber.Packet) []*ber.Packet {
+	if len(op.Children) < 8 {
+		return []*ber.Packet{result(opSearchResultDone, resultProtocolError)}
+	}
+	base := normalizeDN(stringValue(op.Children[0]))
+	scope, _ := op.Children[1].Value.(int64)
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	var attrs []string
+	for _, attr := range op.Children[7].Children {
+		attrs = append(attrs, stringValue(attr))
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	baseExists := base == ""
+	var responses []*ber.Packet
+	for _, entry := range s.entries {
+		dn := normalizeDN(entry.DN)
+		// Entries for the parents of entries are implied.
+		if dn == base || strings.HasSuffix(dn, ","+base) {
+			baseExists = true
+		}
+		if !inScope(dn, base, scope) || !matches(entry, filter) {
+			continue
+		}
+		if sizeLimit > 0 && int64(len(responses)) >= sizeLimit {
+			break
+		}
+		responses = append(responses, searchEntry(entry, attrs))
+	}
+	if !baseExists {
+		return []*ber.Packet{result(opSearchResultDone, resultNoSuchObject)}
+	}
+	return append(responses, result(opSearchResultDone, resultSuccess))
+}
+
+func wanted(attrs []string, name string) bool {
+	if len(attrs) == 0 {
+		return true
+	}
+	for _, attr := range attrs {
+		if attr == "*" || strings.EqualFold(attr, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func searchEntry(entry Entry, attrs []string) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchResultEntry, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "objectName"))
+	attributes := ber.NewSequence("attributes")
+	for name, vals := range entry.Attributes {
+		if !wanted(attrs, name) {
+			continue
+		}
+		attribute := ber.NewSequence("attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, val := range vals {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, val, "value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+	return packet
+}
+
+// matches evaluates a search filter against entry. Values are compared
+// case insensitively. Unsupported filters never match.
+func matches(entry Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case filterAnd:
+		for _, child := range filter.Children {
+			if !matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range filter.Children {
+			if matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return len(filter.Children) == 1 && !matches(entry, filter.Children[0])
+	case filterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		want := stringValue(filter.Children[1])
+		for _, val := range entry.values(stringValue(filter.Children[0])) {
+			if strings.EqualFold(val, want) {
+				return true
+			}
+		}
+		return false
+	case filterSubstrings:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		for _, val := range entry.values(stringValue(filter.Children[0])) {
+			if matchSubstrings(strings.ToLower(val), filter.Children[1].Children) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		attr := stringValue(filter)
+		return strings.EqualFold(attr, "objectClass") || len(entry.values(attr)) > 0
+	}
+	return false
+}
+
+func matchSubstrings(val string, parts []*ber.Packet) bool {
+	for _, part := range parts {
+		sub := strings.ToLower(stringValue(part))
+		switch part.Tag {
+		case substringInitial:
+			if !strings.HasPrefix(val, sub) {
+				return false
+			}
+			val = val[len(sub):]
+		case substringAny:
+			idx := strings.Index(val, sub)
+			if idx < 0 {
+				return false
+			}
+			val = val[idx+len(sub):]
+		case substringFinal:
+			if !strings.HasSuffix(val, sub) {
+				return false
+			}
+		}
+	}
+	return true
+}
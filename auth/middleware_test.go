@@ -2,17 +2,25 @@ package auth_test
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 
 	adminCommon "gopherbin/admin/common"
+	"gopherbin/apiserver/responses"
 	"gopherbin/auth"
 	"gopherbin/config"
+	gErrors "gopherbin/errors"
 	"gopherbin/params"
 )
 
@@ -23,12 +31,17 @@ type mockManager struct {
 	user         params.Users
 	getUserErr   error
 	validateErr  error
+	// identities records the identities passed to AuthenticateExternal
+	identities []adminCommon.ExternalIdentity
 }
 
 func (m *mockManager) HasSuperUser() bool { return m.hasSuperUser }
 func (m *mockManager) Get(_ context.Context, _ uint) (params.Users, error) {
 	return m.user, m.getUserErr
 }
+func (m *mockManager) GetByEmail(_ context.Context, _ string) (params.Users, error) {
+	return m.user, m.getUserErr
+}
 func (m *mockManager) ValidateToken(_ string) error { return m.validateErr }
 func (m *mockManager) Create(_ context.Context, _ params.NewUserParams) (params.Users, error) {
 	return params.Users{}, nil
@@ -36,25 +49,98 @@ func (m *mockManager) Create(_ context.Context, _ params.NewUserParams) (params.
 func (m *mockManager) Update(_ context.Context, _ uint, _ params.UpdateUserPayload) (params.Users, error) {
 	return params.Users{}, nil
 }
-func (m *mockManager) List(_ context.Context, _, _ int64) (params.UserListResult, error) {
+func (m *mockManager) List(_ context.Context, _ adminCommon.UserFilter, _, _ int64) (params.UserListResult, error) {
 	return params.UserListResult{}, nil
 }
-func (m *mockManager) Delete(_ context.Context, _ uint) error  { return nil }
+func (m *mockManager) Details(_ context.Context, _ uint) (params.UserDetails, error) {
+	return params.UserDetails{}, nil
+}
+func (m *mockManager) Delete(_ context.Context, _ uint, _ adminCommon.DeleteUserOptions) (params.UserDeletionReport, error) {
+	return params.UserDeletionReport{}, nil
+}
+func (m *mockManager) Profile(_ context.Context) (params.Profile, error) {
+	return params.Profile{}, nil
+}
+func (m *mockManager) ChangePassword(_ context.Context, _, _ string) (params.Users, error) {
+	return params.Users{}, nil
+}
 func (m *mockManager) Enable(_ context.Context, _ uint) error  { return nil }
 func (m *mockManager) Disable(_ context.Context, _ uint) error { return nil }
 func (m *mockManager) Authenticate(_ context.Context, _ params.PasswordLoginParams) (context.Context, error) {
 	return context.Background(), nil
 }
+func (m *mockManager) AuthenticateExternal(ctx context.Context, identity adminCommon.ExternalIdentity) (context.Context, error) {
+	m.identities = append(m.identities, identity)
+	if m.getUserErr != nil {
+		return ctx, m.getUserErr
+	}
+	return auth.PopulateContext(ctx, m.user), nil
+}
 func (m *mockManager) CreateSuperUser(_ params.NewUserParams) (params.Users, error) {
 	return params.Users{}, nil
 }
+func (m *mockManager) CheckPassword(_ uint, _ string) error   { return nil }
 func (m *mockManager) BlacklistToken(_ string, _ int64) error { return nil }
 func (m *mockManager) CleanTokens() error                     { return nil }
 
 var _ adminCommon.UserManager = (*mockManager)(nil)
 
+type mockSessions struct {
+	touched  []string
+	touchErr error
+}
+
+func (m *mockSessions) Create(_ context.Context, _ adminCommon.NewSession) error { return nil }
+func (m *mockSessions) Touch(_ context.Context, sessionID string) error {
+	m.touched = append(m.touched, sessionID)
+	return m.touchErr
+}
+func (m *mockSessions) List(_ context.Context, _ uint) ([]params.Session, error) { return nil, nil }
+func (m *mockSessions) Revoke(_ context.Context, _ uint, _ string) error         { return nil }
+func (m *mockSessions) RevokeAll(_ context.Context, _ uint) (int64, error)       { return 0, nil }
+func (m *mockSessions) CleanSessions() error                                     { return nil }
+
+var _ adminCommon.SessionManager = (*mockSessions)(nil)
+
+// mockSigningKeys stores signing keys in memory
+type mockSigningKeys struct {
+	keys    []adminCommon.SigningKey
+	created int
+}
+
+func (m *mockSigningKeys) List() ([]adminCommon.SigningKey, error) {
+	keys := append([]adminCommon.SigningKey(nil), m.keys...)
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+func (m *mockSigningKeys) Create(key adminCommon.SigningKey) error {
+	m.keys = append(m.keys, key)
+	m.created++
+	return nil
+}
+func (m *mockSigningKeys) Prune(before time.Time) error {
+	keys, _ := m.List()
+	for idx, key := range keys {
+		if key.CreatedAt.Before(before) {
+			m.keys = keys[:idx+1]
+			return nil
+		}
+	}
+	return nil
+}
+
+var _ adminCommon.SigningKeyManager = (*mockSigningKeys)(nil)
+
+// makeJWT returns a login token signed with secret, like the tokens
+// issued before signing keys were introduced.
 func makeJWT(t *testing.T, claims auth.JWTClaims, secret string) string {
 	t.Helper()
+	if claims.Issuer == "" {
+		claims.Issuer = auth.LoginTokenIssuer
+	}
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims)
 	signed, err := token.SignedString([]byte(secret))
 	if err != nil {
@@ -63,9 +149,27 @@ func makeJWT(t *testing.T, claims auth.JWTClaims, secret string) string {
 	return signed
 }
 
+func newKeyRing(t *testing.T, store adminCommon.SigningKeyManager, cfg config.JWTAuth) *auth.KeyRing {
+	t.Helper()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	keys, err := auth.NewKeyRing(store, cfg)
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	return keys
+}
+
 func newJWTMiddleware(t *testing.T, mgr adminCommon.UserManager) auth.Middleware {
 	t.Helper()
-	mw, err := auth.NewjwtMiddleware(mgr, config.JWTAuth{Secret: testSecret})
+	return newJWTMiddlewareWithSessions(t, mgr, &mockSessions{})
+}
+
+func newJWTMiddlewareWithSessions(t *testing.T, mgr adminCommon.UserManager, sessions adminCommon.SessionManager) auth.Middleware {
+	t.Helper()
+	keys := newKeyRing(t, &mockSigningKeys{}, config.JWTAuth{Secret: testSecret, Algorithm: config.JWTAlgorithmEdDSA})
+	mw, err := auth.NewjwtMiddleware(mgr, sessions, keys)
 	if err != nil {
 		t.Fatalf("NewjwtMiddleware: %v", err)
 	}
@@ -258,3 +362,909 @@ func TestJWTMiddleware_BlacklistedToken(t *testing.T) {
 		t.Errorf("want 401, got %d", rr.Code)
 	}
 }
+
+func TestJWTMiddleware_SecurityStamp(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mgr := &mockManager{user: params.Users{ID: 6, Enabled: true, UpdatedAt: updatedAt, SecurityStamp: "current"}}
+	mw := newJWTMiddleware(t, mgr)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	cases := []struct {
+		name   string
+		claims auth.JWTClaims
+		want   int
+	}{
+		// Changes to the user that do not rotate the stamp, such as a
+		// new name, leave the token valid.
+		{"current stamp", auth.JWTClaims{UserID: 6, TokenID: "tok-6", SecurityStamp: "current", UpdatedAt: "2023"}, http.StatusOK},
+		{"rotated stamp", auth.JWTClaims{UserID: 6, TokenID: "tok-6", SecurityStamp: "previous", UpdatedAt: updatedAt.String()}, http.StatusUnauthorized},
+		// Tokens issued before security stamps were introduced.
+		{"legacy token", auth.JWTClaims{UserID: 6, TokenID: "tok-6", UpdatedAt: updatedAt.String()}, http.StatusOK},
+		{"stale legacy token", auth.JWTClaims{UserID: 6, TokenID: "tok-6", UpdatedAt: "2023"}, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+makeJWT(t, tc.claims, testSecret))
+		mw.Middleware(next).ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("%s: want %d, got %d", tc.name, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestJWTMiddleware_SessionCookie(t *testing.T) {
+	mgr := &mockManager{user: params.Users{ID: 7, Enabled: true, SecurityStamp: "current"}}
+	mw := newJWTMiddleware(t, mgr)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	cookieToken := makeJWT(t, auth.JWTClaims{UserID: 7, TokenID: "tok-7", SecurityStamp: "current", CSRFToken: "csrf-7"}, testSecret)
+	bearerToken := makeJWT(t, auth.JWTClaims{UserID: 7, TokenID: "tok-7", SecurityStamp: "current"}, testSecret)
+	cases := []struct {
+		name   string
+		method string
+		cookie string
+		bearer string
+		csrf   string
+		want   int
+	}{
+		{"safe method", http.MethodGet, cookieToken, "", "", http.StatusOK},
+		{"csrf token", http.MethodPost, cookieToken, "", "csrf-7", http.StatusOK},
+		{"missing csrf token", http.MethodPost, cookieToken, "", "", http.StatusForbidden},
+		{"wrong csrf token", http.MethodDelete, cookieToken, "", "csrf-8", http.StatusForbidden},
+		// Cookie and bearer tokens are not interchangeable.
+		{"bearer token in cookie", http.MethodGet, bearerToken, "", "", http.StatusUnauthorized},
+		{"cookie token as bearer", http.MethodGet, "", cookieToken, "", http.StatusUnauthorized},
+		// The Authorization header wins over the cookie.
+		{"bearer and cookie", http.MethodPost, cookieToken, bearerToken, "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, "/", nil)
+		if tc.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: tc.cookie})
+		}
+		if tc.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.bearer)
+		}
+		if tc.csrf != "" {
+			req.Header.Set(auth.CSRFHeader, tc.csrf)
+		}
+		mw.Middleware(next).ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("%s: want %d, got %d", tc.name, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestJWTMiddleware_Session(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mgr := &mockManager{user: params.Users{ID: 5, Enabled: true, UpdatedAt: updatedAt, SecurityStamp: "current"}}
+	token := makeJWT(t, auth.JWTClaims{
+		UserID:        5,
+		TokenID:       "tok-5",
+		SecurityStamp: "current",
+	}, testSecret)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	sessions := &mockSessions{}
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	newJWTMiddlewareWithSessions(t, mgr, sessions).Middleware(next).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	if len(sessions.touched) != 1 || sessions.touched[0] != "tok-5" {
+		t.Errorf("want session tok-5 touched, got %v", sessions.touched)
+	}
+
+	// Revoked sessions are rejected.
+	sessions = &mockSessions{touchErr: gErrors.ErrUnauthorized}
+	rr = httptest.NewRecorder()
+	newJWTMiddlewareWithSessions(t, mgr, sessions).Middleware(next).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want 401, got %d", rr.Code)
+	}
+
+	// Tokens issued before sessions were introduced have no session.
+	legacy := makeJWT(t, auth.JWTClaims{UserID: 5, TokenID: "tok-legacy", UpdatedAt: updatedAt.String()}, testSecret)
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+legacy)
+	newJWTMiddlewareWithSessions(t, mgr, sessions).Middleware(next).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("legacy token: want 200, got %d", rr.Code)
+	}
+}
+
+// ── Request ID middleware ─────────────────────────────────────────────────────
+
+func newRequestIDMiddleware(t *testing.T) auth.Middleware {
+	t.Helper()
+	mw, err := auth.NewRequestIDMiddleware(nil)
+	if err != nil {
+		t.Fatalf("NewRequestIDMiddleware: %v", err)
+	}
+	return mw
+}
+
+func TestRequestIDMiddleware_GeneratesID(t *testing.T) {
+	var seen string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.RequestID(r.Context())
+	})
+	rr := httptest.NewRecorder()
+	newRequestIDMiddleware(t).Middleware(next).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if seen == "" {
+		t.Fatal("expected a request ID in the context")
+	}
+	if got := rr.Header().Get(auth.RequestIDHeader); got != seen {
+		t.Errorf("header: want %q, got %q", seen, got)
+	}
+}
+
+func TestRequestIDMiddleware_HonorsClientID(t *testing.T) {
+	var seen string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.RequestID(r.Context())
+	})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(auth.RequestIDHeader, "proxy-1234")
+	newRequestIDMiddleware(t).Middleware(next).ServeHTTP(rr, req)
+	if seen != "proxy-1234" {
+		t.Errorf("want %q, got %q", "proxy-1234", seen)
+	}
+}
+
+func TestRequestIDMiddleware_RejectsInvalidClientID(t *testing.T) {
+	var seen string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.RequestID(r.Context())
+	})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(auth.RequestIDHeader, "has spaces")
+	newRequestIDMiddleware(t).Middleware(next).ServeHTTP(rr, req)
+	if seen == "" || seen == "has spaces" {
+		t.Errorf("expected a generated request ID, got %q", seen)
+	}
+}
+
+func TestRequestIDMiddleware_ClientIP(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw, err := auth.NewRequestIDMiddleware([]*net.IPNet{trusted})
+	if err != nil {
+		t.Fatalf("NewRequestIDMiddleware: %v", err)
+	}
+	cases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{"direct", "203.0.113.7:1234", "", "", "203.0.113.7"},
+		{"untrusted forwarded for", "203.0.113.7:1234", "198.51.100.1", "", "203.0.113.7"},
+		{"untrusted real ip", "203.0.113.7:1234", "", "198.51.100.1", "203.0.113.7"},
+		{"trusted forwarded for", "10.0.0.2:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed hop", "10.0.0.2:1234", "192.0.2.9, 198.51.100.1", "", "198.51.100.1"},
+		{"trusted hops", "10.0.0.2:1234", "198.51.100.1, 10.0.0.3", "", "198.51.100.1"},
+		{"trusted real ip", "10.0.0.2:1234", "", "198.51.100.1", "198.51.100.1"},
+		{"trusted no headers", "10.0.0.2:1234", "", "", "10.0.0.2"},
+	}
+	for _, tc := range cases {
+		var seen string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = auth.ClientIP(r.Context())
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		if tc.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if tc.realIP != "" {
+			req.Header.Set("X-Real-IP", tc.realIP)
+		}
+		mw.Middleware(next).ServeHTTP(httptest.NewRecorder(), req)
+		if seen != tc.want {
+			t.Errorf("%s: want %q, got %q", tc.name, tc.want, seen)
+		}
+	}
+}
+
+func TestJWTMiddleware_ErrorEnvelope(t *testing.T) {
+	mw := newJWTMiddleware(t, &mockManager{})
+	handler := newRequestIDMiddleware(t).Middleware(mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(auth.RequestIDHeader, "req-42")
+	handler.ServeHTTP(rr, req)
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: want application/json, got %q", ct)
+	}
+	var apiErr responses.APIErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if apiErr.Code != gErrors.CodeInvalidToken {
+		t.Errorf("code: want %q, got %q", gErrors.CodeInvalidToken, apiErr.Code)
+	}
+	if apiErr.RequestID != "req-42" {
+		t.Errorf("request_id: want %q, got %q", "req-42", apiErr.RequestID)
+	}
+}
+
+// ── API token middleware ──────────────────────────────────────────────────────
+
+const testAPIToken = auth.APITokenPrefix + "valid"
+
+type mockTokenManager struct {
+	user params.Users
+}
+
+func (m *mockTokenManager) Create(_ context.Context, _ params.NewAPITokenParams) (params.APIToken, error) {
+	return params.APIToken{}, nil
+}
+func (m *mockTokenManager) List(_ context.Context, _ uint) ([]params.APIToken, error) {
+	return nil, nil
+}
+func (m *mockTokenManager) Revoke(_ context.Context, _, _ uint) error { return nil }
+func (m *mockTokenManager) Authenticate(ctx context.Context, token string) (context.Context, error) {
+	if token != testAPIToken {
+		return ctx, gErrors.ErrUnauthorized
+	}
+	return auth.PopulateContext(ctx, m.user), nil
+}
+
+var _ adminCommon.APITokenManager = (*mockTokenManager)(nil)
+
+func newAuthChain(t *testing.T, tokens adminCommon.APITokenManager, users adminCommon.UserManager) auth.Middleware {
+	t.Helper()
+	mw, err := auth.NewAPITokenMiddleware(tokens)
+	if err != nil {
+		t.Fatalf("NewAPITokenMiddleware: %v", err)
+	}
+	return auth.Chain(mw, newJWTMiddleware(t, users))
+}
+
+func TestAPITokenMiddleware_ValidToken(t *testing.T) {
+	user := params.Users{ID: 7, Enabled: true}
+	// The user manager would reject any JWT, so the request must be
+	// authenticated by the API token middleware alone.
+	mw := newAuthChain(t, &mockTokenManager{user: user}, &mockManager{getUserErr: gErrors.ErrUnauthorized})
+	var gotUser uint
+	var gotMethod string
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = auth.UserID(r.Context())
+		gotMethod = auth.AuthMethod(r.Context())
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	if gotUser != 7 || gotMethod != auth.AuthMethodAPIToken {
+		t.Errorf("want user 7 authenticated by %q, got user %d by %q", auth.AuthMethodAPIToken, gotUser, gotMethod)
+	}
+}
+
+func TestAPITokenMiddleware_InvalidToken(t *testing.T) {
+	mw := newAuthChain(t, &mockTokenManager{}, &mockManager{})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+auth.APITokenPrefix+"revoked")
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler must not be called")
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want 401, got %d", rr.Code)
+	}
+}
+
+func TestAPITokenMiddleware_DisabledUser(t *testing.T) {
+	mw := newAuthChain(t, &mockTokenManager{user: params.Users{ID: 7}}, &mockManager{})
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler must not be called")
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want 401, got %d", rr.Code)
+	}
+}
+
+func TestAPITokenMiddleware_FallsBackToJWT(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mgr := &mockManager{user: params.Users{ID: 1, Enabled: true, UpdatedAt: updatedAt}}
+	mw := newAuthChain(t, &mockTokenManager{}, mgr)
+	token := makeJWT(t, auth.JWTClaims{
+		UserID:    1,
+		TokenID:   "tok-1",
+		UpdatedAt: updatedAt.String(),
+	}, testSecret)
+	var gotMethod string
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = auth.AuthMethod(r.Context())
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	if gotMethod != auth.AuthMethodJWT {
+		t.Errorf("auth method: want %q, got %q", auth.AuthMethodJWT, gotMethod)
+	}
+}
+
+// ── Proxy auth middleware ────────────────────────────────────────────────────
+
+// mockTwoFactor reports two-factor authentication as enabled for the
+// users in enabled
+type mockTwoFactor struct {
+	adminCommon.TwoFactorManager
+	enabled map[uint]bool
+}
+
+func (m *mockTwoFactor) Status(_ context.Context, userID uint) (params.TwoFactorStatus, error) {
+	return params.TwoFactorStatus{Enabled: m.enabled[userID]}, nil
+}
+
+// newProxyChain returns the proxy auth middleware chained in front of the
+// JWT middleware. httptest requests come from 192.0.2.1.
+func newProxyChain(t *testing.T, mgr *mockManager, cfg config.ProxyAuth) auth.Middleware {
+	t.Helper()
+	return newProxyChainWithTwoFactor(t, mgr, &mockTwoFactor{}, cfg, config.TwoFactor{})
+}
+
+// newProxyChainWithTwoFactor is newProxyChain, using twoFactor and
+// twoFactorCfg to decide the scopes of proxied requests.
+func newProxyChainWithTwoFactor(t *testing.T, mgr *mockManager, twoFactor *mockTwoFactor, cfg config.ProxyAuth, twoFactorCfg config.TwoFactor) auth.Middleware {
+	t.Helper()
+	cfg.Enable = true
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	proxy, err := auth.NewProxyAuthMiddleware(mgr, twoFactor, cfg, twoFactorCfg)
+	if err != nil {
+		t.Fatalf("NewProxyAuthMiddleware: %v", err)
+	}
+	return auth.Chain(proxy, newJWTMiddleware(t, mgr))
+}
+
+func proxyRequest(user, email string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-User", user)
+	req.Header.Set("X-Forwarded-Email", email)
+	return req
+}
+
+func TestProxyAuthMiddleware_TrustedProxy(t *testing.T) {
+	mgr := &mockManager{user: params.Users{ID: 7, Enabled: true}}
+	mw := newProxyChain(t, mgr, config.ProxyAuth{TrustedProxies: []string{"192.0.2.0/24"}})
+	var gotUser uint
+	var gotMethod string
+	var gotScopes []string
+	rr := httptest.NewRecorder()
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = auth.UserID(r.Context())
+		gotMethod = auth.AuthMethod(r.Context())
+		gotScopes = auth.Scopes(r.Context())
+	})).ServeHTTP(rr, proxyRequest("alice@corp", "alice@example.com"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	if gotUser != 7 || gotMethod != auth.AuthMethodProxy {
+		t.Errorf("want user 7 authenticated by %q, got user %d by %q", auth.AuthMethodProxy, gotUser, gotMethod)
+	}
+	if len(gotScopes) != len(auth.AllScopes()) {
+		t.Errorf("want all scopes, got %v", gotScopes)
+	}
+	if len(mgr.identities) != 1 {
+		t.Fatalf("want 1 identity, got %d", len(mgr.identities))
+	}
+	identity := mgr.identities[0]
+	if identity.Provider != auth.ProxyProviderName || identity.Subject != "alice@corp" || identity.Username != "alice" ||
+		identity.Email != "alice@example.com" || !identity.LinkOnly {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+}
+
+func TestProxyAuthMiddleware_TwoFactorScopes(t *testing.T) {
+	for name, tc := range map[string]struct {
+		user       params.Users
+		enabled    bool
+		required   bool
+		wantScopes []string
+	}{
+		"enrolled user": {
+			user:       params.Users{ID: 7, Enabled: true},
+			enabled:    true,
+			wantScopes: []string{},
+		},
+		"admin required to enroll": {
+			user:       params.Users{ID: 7, Enabled: true, IsAdmin: true},
+			required:   true,
+			wantScopes: []string{auth.ScopeAccount},
+		},
+		"user not required to enroll": {
+			user:       params.Users{ID: 7, Enabled: true},
+			required:   true,
+			wantScopes: auth.AllScopes(),
+		},
+	} {
+		mgr := &mockManager{user: tc.user}
+		twoFactor := &mockTwoFactor{enabled: map[uint]bool{7: tc.enabled}}
+		mw := newProxyChainWithTwoFactor(t, mgr, twoFactor,
+			config.ProxyAuth{TrustedProxies: []string{"192.0.2.0/24"}},
+			config.TwoFactor{RequireForAdmins: tc.required})
+		var gotScopes []string
+		rr := httptest.NewRecorder()
+		mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotScopes = auth.Scopes(r.Context())
+		})).ServeHTTP(rr, proxyRequest("alice", "alice@example.com"))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d", name, rr.Code)
+		}
+		if strings.Join(gotScopes, ",") != strings.Join(tc.wantScopes, ",") {
+			t.Errorf("%s: want scopes %v, got %v", name, tc.wantScopes, gotScopes)
+		}
+	}
+}
+
+func TestProxyAuthMiddleware_AutoProvision(t *testing.T) {
+	mgr := &mockManager{user: params.Users{ID: 7, Enabled: true}}
+	mw := newProxyChain(t, mgr, config.ProxyAuth{
+		TrustedProxies: []string{"192.0.2.1/32"},
+		UserHeader:     "X-Auth-Request-User",
+		AutoProvision:  true,
+	})
+	req := proxyRequest("", "alice@example.com")
+	req.Header.Set("X-Auth-Request-User", "alice")
+	rr := httptest.NewRecorder()
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	if len(mgr.identities) != 1 || mgr.identities[0].Subject != "alice" || mgr.identities[0].LinkOnly {
+		t.Errorf("unexpected identities %+v", mgr.identities)
+	}
+}
+
+func TestProxyAuthMiddleware_UntrustedAddress(t *testing.T) {
+	mgr := &mockManager{user: params.Users{ID: 7, Enabled: true}}
+	mw := newProxyChain(t, mgr, config.ProxyAuth{TrustedProxies: []string{"10.0.0.0/8"}})
+	rr := httptest.NewRecorder()
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler must not be called")
+	})).ServeHTTP(rr, proxyRequest("alice", "alice@example.com"))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want 401, got %d", rr.Code)
+	}
+	if len(mgr.identities) != 0 {
+		t.Errorf("headers from untrusted addresses must be ignored, got %+v", mgr.identities)
+	}
+}
+
+func TestProxyAuthMiddleware_RejectedUser(t *testing.T) {
+	for name, mgr := range map[string]*mockManager{
+		"unknown":  {getUserErr: gErrors.ErrUnauthorized},
+		"disabled": {user: params.Users{ID: 7}},
+	} {
+		mw := newProxyChain(t, mgr, config.ProxyAuth{TrustedProxies: []string{"192.0.2.0/24"}})
+		rr := httptest.NewRecorder()
+		mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("%s: next handler must not be called", name)
+		})).ServeHTTP(rr, proxyRequest("alice", "alice@example.com"))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: want 401, got %d", name, rr.Code)
+		}
+	}
+}
+
+func TestProxyAuthMiddleware_FallsBackToJWT(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mgr := &mockManager{user: params.Users{ID: 1, Enabled: true, UpdatedAt: updatedAt}}
+	mw := newProxyChain(t, mgr, config.ProxyAuth{TrustedProxies: []string{"192.0.2.0/24"}})
+	token := makeJWT(t, auth.JWTClaims{
+		UserID:    1,
+		TokenID:   "tok-1",
+		UpdatedAt: updatedAt.String(),
+	}, testSecret)
+	var gotMethod string
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = auth.AuthMethod(r.Context())
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	if gotMethod != auth.AuthMethodJWT {
+		t.Errorf("want %q, got %q", auth.AuthMethodJWT, gotMethod)
+	}
+}
+
+// ── Client certificate middleware ────────────────────────────────────────────
+
+type mockClientCertManager struct {
+	user   params.Users
+	scopes []string
+	err    error
+}
+
+func (m *mockClientCertManager) Create(_ context.Context, _ *x509.Certificate, _ params.NewClientCertificateParams) (params.ClientCertificate, error) {
+	return params.ClientCertificate{}, nil
+}
+func (m *mockClientCertManager) List(_ context.Context, _ uint) ([]params.ClientCertificate, error) {
+	return nil, nil
+}
+func (m *mockClientCertManager) Delete(_ context.Context, _, _ uint) error { return nil }
+func (m *mockClientCertManager) Authenticate(ctx context.Context, _ *x509.Certificate) (context.Context, error) {
+	if m.err != nil {
+		return ctx, m.err
+	}
+	return auth.PopulateContext(auth.SetScopes(ctx, m.scopes), m.user), nil
+}
+
+var _ adminCommon.ClientCertificateManager = (*mockClientCertManager)(nil)
+
+func newClientCertChain(t *testing.T, certs adminCommon.ClientCertificateManager, users adminCommon.UserManager) auth.Middleware {
+	t.Helper()
+	return auth.Chain(auth.NewClientCertMiddleware(certs), newJWTMiddleware(t, users))
+}
+
+// clientCertRequest returns a request made over a TLS connection on which
+// the client presented a verified certificate.
+func clientCertRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	cert := &x509.Certificate{}
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	return req
+}
+
+func TestClientCertMiddleware_ValidCertificate(t *testing.T) {
+	certs := &mockClientCertManager{
+		user:   params.Users{ID: 7, Enabled: true},
+		scopes: []string{auth.ScopePasteWrite},
+	}
+	mw := newClientCertChain(t, certs, &mockManager{getUserErr: gErrors.ErrUnauthorized})
+	var gotUser uint
+	var gotMethod string
+	var gotScopes []string
+	rr := httptest.NewRecorder()
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = auth.UserID(r.Context())
+		gotMethod = auth.AuthMethod(r.Context())
+		gotScopes = auth.Scopes(r.Context())
+	})).ServeHTTP(rr, clientCertRequest())
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	if gotUser != 7 || gotMethod != auth.AuthMethodClientCert {
+		t.Errorf("want user 7 authenticated by %q, got user %d by %q", auth.AuthMethodClientCert, gotUser, gotMethod)
+	}
+	if len(gotScopes) != 1 || gotScopes[0] != auth.ScopePasteWrite {
+		t.Errorf("want the certificate scopes, got %v", gotScopes)
+	}
+}
+
+func TestClientCertMiddleware_RejectedCertificate(t *testing.T) {
+	cases := map[string]*mockClientCertManager{
+		"unknown":  {err: gErrors.ErrUnauthorized},
+		"disabled": {user: params.Users{ID: 7}},
+	}
+	for name, certs := range cases {
+		mw := newClientCertChain(t, certs, &mockManager{})
+		rr := httptest.NewRecorder()
+		mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("%s: next handler must not be called", name)
+		})).ServeHTTP(rr, clientCertRequest())
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: want 401, got %d", name, rr.Code)
+		}
+	}
+}
+
+func TestClientCertMiddleware_WithoutCertificate(t *testing.T) {
+	certs := &mockClientCertManager{user: params.Users{ID: 7, Enabled: true}}
+	mw := newClientCertChain(t, certs, &mockManager{})
+	rr := httptest.NewRecorder()
+	req := clientCertRequest()
+	req.TLS.VerifiedChains = nil
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler must not be called")
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want 401 from the JWT middleware, got %d", rr.Code)
+	}
+}
+
+func TestClientCertMiddleware_AuthorizationHeaderWins(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mgr := &mockManager{user: params.Users{ID: 1, Enabled: true, UpdatedAt: updatedAt}}
+	certs := &mockClientCertManager{user: params.Users{ID: 7, Enabled: true}}
+	mw := newClientCertChain(t, certs, mgr)
+	token := makeJWT(t, auth.JWTClaims{
+		UserID:    1,
+		TokenID:   "tok-1",
+		UpdatedAt: updatedAt.String(),
+	}, testSecret)
+	var gotUser uint
+	var gotMethod string
+	rr := httptest.NewRecorder()
+	req := clientCertRequest()
+	req.Header.Set("Authorization", "Bearer "+token)
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = auth.UserID(r.Context())
+		gotMethod = auth.AuthMethod(r.Context())
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rr.Code)
+	}
+	if gotUser != 1 || gotMethod != auth.AuthMethodJWT {
+		t.Errorf("want user 1 authenticated by %q, got user %d by %q", auth.AuthMethodJWT, gotUser, gotMethod)
+	}
+}
+
+func TestClientCertMiddleware_SessionCookieWins(t *testing.T) {
+	mgr := &mockManager{user: params.Users{ID: 1, Enabled: true, SecurityStamp: "current"}}
+	certs := &mockClientCertManager{user: params.Users{ID: 7, Enabled: true}}
+	mw := newClientCertChain(t, certs, mgr)
+	token := makeJWT(t, auth.JWTClaims{UserID: 1, TokenID: "tok-1", SecurityStamp: "current", CSRFToken: "csrf"}, testSecret)
+	var gotUser uint
+	rr := httptest.NewRecorder()
+	req := clientCertRequest()
+	req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: token})
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = auth.UserID(r.Context())
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || gotUser != 1 {
+		t.Errorf("want user 1 from the session cookie, got %d (status %d)", gotUser, rr.Code)
+	}
+}
+
+// ── Scopes ────────────────────────────────────────────────────────────────────
+
+func TestRequireScope(t *testing.T) {
+	mw := auth.RequireScope(auth.ScopePasteWrite)
+	handler := mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	ctx := auth.SetScopes(context.Background(), []string{auth.ScopePasteWrite})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if rr.Code != http.StatusOK {
+		t.Errorf("with scope: want 200, got %d", rr.Code)
+	}
+
+	ctx = auth.SetScopes(context.Background(), []string{auth.ScopePasteRead})
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("without scope: want 403, got %d", rr.Code)
+	}
+	var apiErr responses.APIErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if apiErr.Code != gErrors.CodeInsufficientScope {
+		t.Errorf("code: want %q, got %q", gErrors.CodeInsufficientScope, apiErr.Code)
+	}
+	if !strings.Contains(apiErr.Details, auth.ScopePasteWrite) {
+		t.Errorf("details %q do not name the missing scope", apiErr.Details)
+	}
+}
+
+func TestResolveScopes(t *testing.T) {
+	ctx := auth.SetScopes(context.Background(), []string{auth.ScopePasteRead, auth.ScopeTokens})
+
+	scopes, err := auth.ResolveScopes(ctx, nil)
+	if err != nil || len(scopes) != 2 {
+		t.Errorf("default: want the scopes of the context, got %v, %v", scopes, err)
+	}
+	scopes, err = auth.ResolveScopes(ctx, []string{auth.ScopeTokens, auth.ScopePasteRead, auth.ScopeTokens})
+	if err != nil {
+		t.Fatalf("ResolveScopes: %v", err)
+	}
+	if fmt.Sprint(scopes) != fmt.Sprint([]string{auth.ScopePasteRead, auth.ScopeTokens}) {
+		t.Errorf("want sorted scopes without duplicates, got %v", scopes)
+	}
+	if _, err := auth.ResolveScopes(ctx, []string{"bogus"}); gErrors.Code(err) != gErrors.CodeValidationFailed {
+		t.Errorf("unknown scope: want %s, got %v", gErrors.CodeValidationFailed, err)
+	}
+	if _, err := auth.ResolveScopes(ctx, []string{auth.ScopeAdminUsers}); gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Errorf("escalation: want %s, got %v", gErrors.CodeInsufficientScope, err)
+	}
+}
+
+func TestJWTMiddleware_Scopes(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mgr := &mockManager{user: params.Users{ID: 1, Enabled: true, UpdatedAt: updatedAt}}
+	mw := newJWTMiddleware(t, mgr)
+
+	cases := []struct {
+		name   string
+		scopes []string
+		want   int
+	}{
+		{"legacy token without scopes", nil, len(auth.AllScopes())},
+		{"limited token", []string{auth.ScopePasteRead}, 1},
+	}
+	for _, tc := range cases {
+		token := makeJWT(t, auth.JWTClaims{
+			UserID:    1,
+			TokenID:   "tok-1",
+			UpdatedAt: updatedAt.String(),
+			Scopes:    tc.scopes,
+		}, testSecret)
+		var got []string
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = auth.Scopes(r.Context())
+		})).ServeHTTP(httptest.NewRecorder(), req)
+		if len(got) != tc.want {
+			t.Errorf("%s: want %d scopes, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+// ── Pre-auth tokens ───────────────────────────────────────────────────────────
+
+func TestPreAuthToken_RoundTrip(t *testing.T) {
+	ctx := auth.PopulateContext(context.Background(), params.Users{ID: 42, Enabled: true, SecurityStamp: "stamp-42"})
+	token, err := auth.NewPreAuthToken(ctx, testSecret, []string{auth.ScopePasteRead})
+	if err != nil {
+		t.Fatalf("NewPreAuthToken: %v", err)
+	}
+
+	claims, err := auth.ParsePreAuthToken(token, testSecret)
+	if err != nil {
+		t.Fatalf("ParsePreAuthToken: %v", err)
+	}
+	if claims.UserID() != 42 || claims.SecurityStamp != "stamp-42" || claims.ID == "" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if len(claims.Scopes) != 1 || claims.Scopes[0] != auth.ScopePasteRead {
+		t.Errorf("unexpected scopes %v", claims.Scopes)
+	}
+	if _, err := auth.ParsePreAuthToken(token, "other-secret"); err == nil {
+		t.Error("expected token signed with another secret to be rejected")
+	}
+}
+
+func TestPreAuthToken_RejectsSessionTokens(t *testing.T) {
+	session := makeJWT(t, auth.JWTClaims{
+		UserID:           1,
+		TokenID:          "tok-1",
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "1", ID: "tok-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}, testSecret)
+	if _, err := auth.ParsePreAuthToken(session, testSecret); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Errorf("want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+}
+
+func TestJWTMiddleware_RejectsPreAuthToken(t *testing.T) {
+	updatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	user := params.Users{ID: 1, Enabled: true, UpdatedAt: updatedAt}
+	mw := newJWTMiddleware(t, &mockManager{user: user})
+	token, err := auth.NewPreAuthToken(auth.PopulateContext(context.Background(), user), testSecret, nil)
+	if err != nil {
+		t.Fatalf("NewPreAuthToken: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	mw.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler called with a pre-auth token")
+	})).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("want 401, got %d", rr.Code)
+	}
+}
+
+// ── Password reset tokens ─────────────────────────────────────────────────────
+
+func TestPasswordResetToken_RoundTrip(t *testing.T) {
+	ctx := auth.PopulateContext(context.Background(), params.Users{ID: 42, Enabled: true, SecurityStamp: "stamp-42"})
+	token, err := auth.NewPasswordResetToken(ctx, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("NewPasswordResetToken: %v", err)
+	}
+	claims, err := auth.ParsePasswordResetToken(token, testSecret)
+	if err != nil {
+		t.Fatalf("ParsePasswordResetToken: %v", err)
+	}
+	if claims.UserID() != 42 || claims.SecurityStamp != "stamp-42" || claims.ID == "" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if _, err := auth.ParsePasswordResetToken(token, "other-secret"); err == nil {
+		t.Error("expected token signed with another secret to be rejected")
+	}
+
+	expired, err := auth.NewPasswordResetToken(ctx, testSecret, -time.Minute)
+	if err != nil {
+		t.Fatalf("NewPasswordResetToken: %v", err)
+	}
+	if _, err := auth.ParsePasswordResetToken(expired, testSecret); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Errorf("expired token: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+}
+
+func TestPasswordResetToken_RejectsOtherTokens(t *testing.T) {
+	ctx := auth.PopulateContext(context.Background(), params.Users{ID: 42, Enabled: true, SecurityStamp: "stamp-42"})
+	preAuth, err := auth.NewPreAuthToken(ctx, testSecret, nil)
+	if err != nil {
+		t.Fatalf("NewPreAuthToken: %v", err)
+	}
+	if _, err := auth.ParsePasswordResetToken(preAuth, testSecret); err == nil {
+		t.Error("expected pre-auth token to be rejected")
+	}
+	reset, err := auth.NewPasswordResetToken(ctx, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("NewPasswordResetToken: %v", err)
+	}
+	if _, err := auth.ParsePreAuthToken(reset, testSecret); err == nil {
+		t.Error("expected password reset token not to be accepted as a pre-auth token")
+	}
+}
+
+// ── Email change tokens ───────────────────────────────────────────────────────
+
+func TestEmailChangeToken_RoundTrip(t *testing.T) {
+	ctx := auth.PopulateContext(context.Background(), params.Users{ID: 42, Enabled: true, SecurityStamp: "stamp-42"})
+	token, err := auth.NewEmailChangeToken(ctx, testSecret, "old@example.com", "new@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("NewEmailChangeToken: %v", err)
+	}
+	claims, err := auth.ParseEmailChangeToken(token, testSecret)
+	if err != nil {
+		t.Fatalf("ParseEmailChangeToken: %v", err)
+	}
+	if claims.UserID() != 42 || claims.SecurityStamp != "stamp-42" || claims.PreviousEmail != "old@example.com" || claims.Email != "new@example.com" || claims.ID == "" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if _, err := auth.ParseEmailChangeToken(token, "other-secret"); err == nil {
+		t.Error("expected token signed with another secret to be rejected")
+	}
+
+	expired, err := auth.NewEmailChangeToken(ctx, testSecret, "old@example.com", "new@example.com", -time.Minute)
+	if err != nil {
+		t.Fatalf("NewEmailChangeToken: %v", err)
+	}
+	if _, err := auth.ParseEmailChangeToken(expired, testSecret); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Errorf("expired token: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+
+	reset, err := auth.NewPasswordResetToken(ctx, testSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("NewPasswordResetToken: %v", err)
+	}
+	if _, err := auth.ParseEmailChangeToken(reset, testSecret); err == nil {
+		t.Error("expected password reset token to be rejected")
+	}
+	if _, err := auth.ParsePasswordResetToken(token, testSecret); err == nil {
+		t.Error("expected email change token not to be accepted as a password reset token")
+	}
+}
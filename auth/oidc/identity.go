@@ -0,0 +1,109 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package oidc
+
+import (
+	"strings"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+
+	adminCommon "gopherbin/admin/common"
+	"gopherbin/util"
+)
+
+func stringClaim(claims jwt.MapClaims, name string) string {
+	val, _ := claims[name].(string)
+	return strings.TrimSpace(val)
+}
+
+// stringsClaim returns a claim holding a list of strings. A single
+// string is also accepted.
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	switch val := claims[name].(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		var ret []string
+		for _, item := range val {
+			if str, ok := item.(string); ok {
+				ret = append(ret, str)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+func emailDomain(email string) string {
+	idx := strings.LastIndex(email, "@")
+	if idx < 0 {
+		return ""
+	}
+	return strings.ToLower(email[idx+1:])
+}
+
+// identity maps the claims of an ID token to a gopherbin identity, using
+// the rules set in the config.
+func (p *Provider) identity(claims jwt.MapClaims) (adminCommon.ExternalIdentity, error) {
+	subject := stringClaim(claims, "sub")
+	if subject == "" {
+		return adminCommon.ExternalIdentity{}, loginFailed("the ID token has no subject")
+	}
+
+	email := stringClaim(claims, p.cfg.EmailClaim)
+	if email == "" {
+		return adminCommon.ExternalIdentity{}, loginFailed("the ID token has no %s claim", p.cfg.EmailClaim)
+	}
+	if len(p.cfg.AllowedEmailDomains) > 0 {
+		allowed := false
+		for _, domain := range p.cfg.AllowedEmailDomains {
+			if strings.EqualFold(emailDomain(email), strings.TrimPrefix(domain, "@")) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return adminCommon.ExternalIdentity{}, loginFailed("logins from %s are not allowed", emailDomain(email))
+		}
+	}
+
+	username := util.UsernameFrom(stringClaim(claims, p.cfg.UsernameClaim))
+	if username == "" {
+		username = util.UsernameFrom(email)
+	}
+	verified, _ := claims["email_verified"].(bool)
+
+	identity := adminCommon.ExternalIdentity{
+		Provider:      ProviderName,
+		Subject:       subject,
+		Username:      username,
+		Email:         email,
+		EmailVerified: verified,
+		FullName:      stringClaim(claims, p.cfg.FullNameClaim),
+		LinkByEmail:   p.cfg.LinkByEmail,
+	}
+	if len(p.cfg.AdminGroups) > 0 {
+		isAdmin := false
+		for _, group := range stringsClaim(claims, p.cfg.GroupsClaim) {
+			for _, adminGroup := range p.cfg.AdminGroups {
+				if group == adminGroup {
+					isAdmin = true
+				}
+			}
+		}
+		identity.IsAdmin = &isAdmin
+	}
+	return identity, nil
+}
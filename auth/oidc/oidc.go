@@ -0,0 +1,304 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package oidc implements the OpenID Connect authorization code flow,
+// with PKCE, used to log in to gopherbin using an external identity
+// provider.
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+
+	adminCommon "gopherbin/admin/common"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+)
+
+const (
+	// ProviderName is the name under which users provisioned through
+	// OIDC are recorded.
+	ProviderName = "oidc"
+	// keysRefreshInterval limits how often the JWKS is fetched when a
+	// token is signed with an unknown key.
+	keysRefreshInterval = time.Minute
+	// maxResponseSize limits the size of responses read from the
+	// identity provider.
+	maxResponseSize = 1 << 20
+)
+
+// signingMethods lists the ID token signing algorithms we accept
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// loginFailed returns the error sent to users when logging in through
+// the identity provider fails.
+func loginFailed(format string, a ...interface{}) error {
+	return gErrors.WithCode(gErrors.NewUnauthorizedError(fmt.Sprintf(format, a...)), gErrors.CodeSSOFailed)
+}
+
+type discoveryDoc struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// NewProvider returns a new Provider. The provider configuration is
+// discovered the first time it is needed, so gopherbin can start even
+// if the identity provider is unreachable. If httpClient is nil,
+// http.DefaultClient is used.
+func NewProvider(cfg config.OIDC, httpClient *http.Client) (*Provider, error) {
+	if !cfg.Enable {
+		return nil, fmt.Errorf("oidc is not enabled")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validating oidc config")
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Provider{
+		cfg:        cfg,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Provider talks to an OpenID Connect identity provider
+type Provider struct {
+	cfg        config.OIDC
+	httpClient *http.Client
+
+	mux         sync.Mutex
+	discovery   *discoveryDoc
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "fetching %s", target)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: unexpected status %s", target, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(out); err != nil {
+		return errors.Wrapf(err, "decoding %s", target)
+	}
+	return nil
+}
+
+// discover returns the provider configuration, fetching it if needed.
+func (p *Provider) discover(ctx context.Context) (*discoveryDoc, error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(p.cfg.IssuerURL, "/")
+	var doc discoveryDoc
+	if err := p.getJSON(ctx, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, errors.Wrap(err, "discovering oidc provider")
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("issuer %q returned by the provider does not match the configured issuer %q", doc.Issuer, p.cfg.IssuerURL)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("incomplete oidc provider configuration")
+	}
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+// codeChallenge returns the S256 PKCE code challenge for verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL users must be sent to in order to log in
+func (p *Provider) AuthCodeURL(ctx context.Context, state LoginState) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing authorization endpoint")
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " "))
+	query.Set("state", state.State)
+	query.Set("nonce", state.Nonce)
+	query.Set("code_challenge", codeChallenge(state.CodeVerifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// exchange trades an authorization code for an ID token
+func (p *Provider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.cfg.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "calling token endpoint")
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tokens); err != nil {
+		return "", errors.Wrap(err, "decoding token response")
+	}
+	if tokens.Error != "" {
+		return "", loginFailed("the identity provider rejected the login: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status from token endpoint: %s", resp.Status)
+	}
+	if tokens.IDToken == "" {
+		return "", loginFailed("the identity provider did not return an ID token")
+	}
+	return tokens.IDToken, nil
+}
+
+// key returns the key identified by kid, refreshing the key set if the
+// key is unknown.
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if key := findKey(p.keys, kid); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set jwkSet
+	if err := p.getJSON(ctx, doc.JWKSURI, &set); err != nil {
+		return nil, errors.Wrap(err, "fetching signing keys")
+	}
+	keys, err := set.publicKeys()
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing signing keys")
+	}
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if key := findKey(p.keys, kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// verify checks the signature and claims of an ID token, and returns
+// its claims.
+func (p *Provider) verify(ctx context.Context, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(doc.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, loginFailed("invalid ID token: %v", err)
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce == "" || tokenNonce != nonce {
+		return nil, loginFailed("invalid ID token: nonce mismatch")
+	}
+	return claims, nil
+}
+
+// Login completes the authorization code flow. It exchanges the code for
+// an ID token, verifies it, and maps its claims to an identity.
+func (p *Provider) Login(ctx context.Context, code string, state LoginState) (adminCommon.ExternalIdentity, error) {
+	if code == "" {
+		return adminCommon.ExternalIdentity{}, loginFailed("missing authorization code")
+	}
+	rawIDToken, err := p.exchange(ctx, code, state.CodeVerifier)
+	if err != nil {
+		return adminCommon.ExternalIdentity{}, err
+	}
+	claims, err := p.verify(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		return adminCommon.ExternalIdentity{}, err
+	}
+	return p.identity(claims)
+}
+
+// SecureCookies returns true if cookies set during the login flow should
+// only be sent over HTTPS. This is the case if the redirect URL uses HTTPS.
+func (p *Provider) SecureCookies() bool {
+	return strings.HasPrefix(p.cfg.RedirectURL, "https://")
+}
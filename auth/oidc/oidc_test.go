@@ -0,0 +1,309 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+
+	"gopherbin/auth/oidc"
+	"gopherbin/auth/oidc/oidctest"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+)
+
+const redirectURL = "http://gopherbin.example.com/api/v1/auth/oidc/callback"
+
+func newProvider(t *testing.T, idp *oidctest.Server, modify func(*config.OIDC)) *oidc.Provider {
+	t.Helper()
+	cfg := config.OIDC{
+		Enable:       true,
+		IssuerURL:    idp.URL,
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+	if modify != nil {
+		modify(&cfg)
+	}
+	provider, err := oidc.NewProvider(cfg, idp.Client())
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	return provider
+}
+
+// authorize sends the user agent to the identity provider and returns
+// the query of the redirect back to gopherbin.
+func authorize(t *testing.T, provider *oidc.Provider, state oidc.LoginState) url.Values {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), state)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	cli := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := cli.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: want 302, got %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing redirect: %v", err)
+	}
+	query := location.Query()
+	if query.Get("state") != state.State {
+		t.Fatalf("want state %q, got %q", state.State, query.Get("state"))
+	}
+	return query
+}
+
+func login(t *testing.T, provider *oidc.Provider) error {
+	t.Helper()
+	state, err := oidc.NewLoginState()
+	if err != nil {
+		t.Fatalf("NewLoginState: %v", err)
+	}
+	_, err = provider.Login(context.Background(), authorize(t, provider, state).Get("code"), state)
+	return err
+}
+
+func expectSSOFailed(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, gErrors.ErrUnauthorized) || gErrors.Code(err) != gErrors.CodeSSOFailed {
+		t.Fatalf("want sso_failed error, got %v", err)
+	}
+}
+
+var alice = map[string]interface{}{
+	"sub":                "alice-id",
+	"preferred_username": "alice.smith",
+	"email":              "alice@example.com",
+	"email_verified":     true,
+	"name":               "Alice Smith",
+	"groups":             []string{"developers", "paste-admins"},
+}
+
+// ── Login ───────────────────────────────────────────────────────────────────
+
+func TestLogin(t *testing.T) {
+	idp := oidctest.NewServer("gopherbin", "s3cret")
+	defer idp.Close()
+	idp.SetUser(alice)
+	provider := newProvider(t, idp, func(cfg *config.OIDC) {
+		cfg.AdminGroups = []string{"paste-admins"}
+		cfg.LinkByEmail = true
+	})
+
+	state, err := oidc.NewLoginState()
+	if err != nil {
+		t.Fatalf("NewLoginState: %v", err)
+	}
+	identity, err := provider.Login(context.Background(), authorize(t, provider, state).Get("code"), state)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if identity.Provider != oidc.ProviderName || identity.Subject != "alice-id" {
+		t.Errorf("unexpected provider or subject: %+v", identity)
+	}
+	if identity.Username != "alicesmith" || identity.Email != "alice@example.com" || identity.FullName != "Alice Smith" {
+		t.Errorf("unexpected user details: %+v", identity)
+	}
+	if !identity.EmailVerified || !identity.LinkByEmail {
+		t.Errorf("expected verified email and link by email: %+v", identity)
+	}
+	if identity.IsAdmin == nil || !*identity.IsAdmin {
+		t.Errorf("expected admin group to grant admin: %+v", identity)
+	}
+}
+
+func TestLogin_AdminGroupsNotConfigured(t *testing.T) {
+	idp := oidctest.NewServer("gopherbin", "")
+	defer idp.Close()
+	idp.SetUser(map[string]interface{}{
+		"sub":   "bob-id",
+		"email": "bob@example.com",
+	})
+	provider := newProvider(t, idp, nil)
+
+	state, _ := oidc.NewLoginState()
+	identity, err := provider.Login(context.Background(), authorize(t, provider, state).Get("code"), state)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if identity.Username != "bob" {
+		t.Errorf("want username derived from email, got %q", identity.Username)
+	}
+	if identity.IsAdmin != nil {
+		t.Errorf("want admin flag left alone, got %v", *identity.IsAdmin)
+	}
+	if identity.EmailVerified {
+		t.Error("want unverified email")
+	}
+}
+
+func TestLogin_DeniedByIdP(t *testing.T) {
+	idp := oidctest.NewServer("gopherbin", "")
+	defer idp.Close()
+	provider := newProvider(t, idp, nil)
+
+	state, _ := oidc.NewLoginState()
+	query := authorize(t, provider, state)
+	if query.Get("error") != "access_denied" || query.Get("code") != "" {
+		t.Fatalf("want access_denied, got %v", query)
+	}
+	_, err := provider.Login(context.Background(), "", state)
+	expectSSOFailed(t, err)
+}
+
+func TestLogin_WrongCodeVerifier(t *testing.T) {
+	idp := oidctest.NewServer("gopherbin", "")
+	defer idp.Close()
+	idp.SetUser(alice)
+	provider := newProvider(t, idp, nil)
+
+	state, _ := oidc.NewLoginState()
+	code := authorize(t, provider, state).Get("code")
+	state.CodeVerifier = "tampered"
+	_, err := provider.Login(context.Background(), code, state)
+	expectSSOFailed(t, err)
+}
+
+func TestLogin_CodeReplay(t *testing.T) {
+	idp := oidctest.NewServer("gopherbin", "")
+	defer idp.Close()
+	idp.SetUser(alice)
+	provider := newProvider(t, idp, nil)
+
+	state, _ := oidc.NewLoginState()
+	code := authorize(t, provider, state).Get("code")
+	if _, err := provider.Login(context.Background(), code, state); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	_, err := provider.Login(context.Background(), code, state)
+	expectSSOFailed(t, err)
+}
+
+func TestLogin_WrongClientSecret(t *testing.T) {
+	idp := oidctest.NewServer("gopherbin", "s3cret")
+	defer idp.Close()
+	idp.SetUser(alice)
+	provider := newProvider(t, idp, func(cfg *config.OIDC) {
+		cfg.ClientSecret = "wrong"
+	})
+	expectSSOFailed(t, login(t, provider))
+}
+
+func TestLogin_InvalidIDToken(t *testing.T) {
+	cases := map[string]func(jwt.MapClaims){
+		"nonce mismatch": func(claims jwt.MapClaims) {
+			claims["nonce"] = "replayed"
+		},
+		"missing nonce": func(claims jwt.MapClaims) {
+			delete(claims, "nonce")
+		},
+		"wrong audience": func(claims jwt.MapClaims) {
+			claims["aud"] = "another-client"
+		},
+		"wrong issuer": func(claims jwt.MapClaims) {
+			claims["iss"] = "https://evil.example.com"
+		},
+		"expired": func(claims jwt.MapClaims) {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		},
+		"missing expiry": func(claims jwt.MapClaims) {
+			delete(claims, "exp")
+		},
+		"missing subject": func(claims jwt.MapClaims) {
+			delete(claims, "sub")
+		},
+		"missing email": func(claims jwt.MapClaims) {
+			delete(claims, "email")
+		},
+	}
+	for name, tamper := range cases {
+		t.Run(name, func(t *testing.T) {
+			idp := oidctest.NewServer("gopherbin", "")
+			defer idp.Close()
+			idp.SetUser(alice)
+			idp.Tamper(tamper)
+			expectSSOFailed(t, login(t, newProvider(t, idp, nil)))
+		})
+	}
+}
+
+func TestLogin_AllowedEmailDomains(t *testing.T) {
+	idp := oidctest.NewServer("gopherbin", "")
+	defer idp.Close()
+	idp.SetUser(alice)
+
+	provider := newProvider(t, idp, func(cfg *config.OIDC) {
+		cfg.AllowedEmailDomains = []string{"corp.example.com"}
+	})
+	expectSSOFailed(t, login(t, provider))
+
+	provider = newProvider(t, idp, func(cfg *config.OIDC) {
+		cfg.AllowedEmailDomains = []string{"corp.example.com", "@Example.com"}
+	})
+	if err := login(t, provider); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+}
+
+func TestLogin_IssuerMismatch(t *testing.T) {
+	idp := oidctest.NewServer("gopherbin", "")
+	defer idp.Close()
+	provider := newProvider(t, idp, func(cfg *config.OIDC) {
+		cfg.IssuerURL = idp.URL + "/other"
+	})
+	state, _ := oidc.NewLoginState()
+	if _, err := provider.AuthCodeURL(context.Background(), state); err == nil {
+		t.Fatal("expected discovery to fail")
+	}
+}
+
+// ── Login state ─────────────────────────────────────────────────────────────
+
+func TestLoginState(t *testing.T) {
+	state, err := oidc.NewLoginState()
+	if err != nil {
+		t.Fatalf("NewLoginState: %v", err)
+	}
+	if state.State == "" || state.State == state.Nonce || state.Nonce == state.CodeVerifier {
+		t.Fatalf("expected distinct random values: %+v", state)
+	}
+	sealed, err := state.Seal("secret", time.Minute)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	opened, err := oidc.OpenLoginState(sealed, "secret", state.State)
+	if err != nil {
+		t.Fatalf("OpenLoginState: %v", err)
+	}
+	if opened != state {
+		t.Errorf("want %+v, got %+v", state, opened)
+	}
+
+	_, err = oidc.OpenLoginState(sealed, "secret", "another-state")
+	expectSSOFailed(t, err)
+	_, err = oidc.OpenLoginState(sealed, "secret", "")
+	expectSSOFailed(t, err)
+	_, err = oidc.OpenLoginState(sealed, "wrong-secret", state.State)
+	expectSSOFailed(t, err)
+
+	expired, _ := state.Seal("secret", -time.Minute)
+	_, err = oidc.OpenLoginState(expired, "secret", state.State)
+	expectSSOFailed(t, err)
+}
@@ -0,0 +1,247 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package oidctest provides a fake OpenID Connect identity provider for
+// use in tests. It serves the discovery document, a JWKS and the
+// authorization and token endpoints of the authorization code flow.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// KeyID is the ID of the key the server signs ID tokens with
+const KeyID = "oidctest-key"
+
+type authRequest struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	claims        map[string]interface{}
+}
+
+// Server is a fake OpenID Connect identity provider
+type Server struct {
+	*httptest.Server
+
+	// ClientID is the only client the server accepts
+	ClientID string
+	// ClientSecret, if set, must be sent by the client
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mux    sync.Mutex
+	claims map[string]interface{}
+	codes  map[string]authRequest
+	// tamper, if set, is called with the ID token claims before the
+	// token is signed.
+	tamper func(jwt.MapClaims)
+}
+
+// NewServer starts a new fake identity provider. The caller must call
+// Close when done.
+func NewServer(clientID, clientSecret string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	srv := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		codes:        map[string]authRequest{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", srv.discovery)
+	mux.HandleFunc("/jwks", srv.jwks)
+	mux.HandleFunc("/authorize", srv.authorize)
+	mux.HandleFunc("/token", srv.token)
+	srv.Server = httptest.NewServer(mux)
+	return srv
+}
+
+// SetUser sets the claims of the user that logs in next. The iss, aud,
+// exp, iat and nonce claims are set by the server.
+func (s *Server) SetUser(claims map[string]interface{}) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.claims = claims
+}
+
+// Tamper registers a function that can alter the claims of ID tokens
+// before they are signed, to test how clients deal with invalid tokens.
+func (s *Server) Tamper(fn func(jwt.MapClaims)) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.tamper = fn
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                           s.URL,
+		"authorization_endpoint":           s.URL + "/authorize",
+		"token_endpoint":                   s.URL + "/token",
+		"jwks_uri":                         s.URL + "/jwks",
+		"response_types_supported":         []string{"code"},
+		"code_challenge_methods_supported": []string{"S256"},
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": KeyID,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize logs in the user set with SetUser without any interaction,
+// and redirects back to the client with an authorization code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") != s.ClientID {
+		http.Error(w, "invalid client", http.StatusBadRequest)
+		return
+	}
+	back := redirectURI.Query()
+	back.Set("state", query.Get("state"))
+
+	s.mux.Lock()
+	claims := s.claims
+	s.mux.Unlock()
+	if claims == nil {
+		back.Set("error", "access_denied")
+		back.Set("error_description", "no user logged in")
+	} else if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		back.Set("error", "invalid_request")
+		back.Set("error_description", "PKCE is required")
+	} else {
+		code := randomString()
+		s.mux.Lock()
+		s.codes[code] = authRequest{
+			clientID:      query.Get("client_id"),
+			redirectURI:   query.Get("redirect_uri"),
+			codeChallenge: query.Get("code_challenge"),
+			nonce:         query.Get("nonce"),
+			claims:        claims,
+		}
+		s.mux.Unlock()
+		back.Set("code", code)
+	}
+	redirectURI.RawQuery = back.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "invalid_request", "unsupported grant")
+		return
+	}
+	if s.ClientSecret != "" {
+		clientID, secret, ok := r.BasicAuth()
+		if !ok || clientID != s.ClientID || secret != s.ClientSecret {
+			tokenError(w, "invalid_client", "client authentication failed")
+			return
+		}
+	}
+
+	s.mux.Lock()
+	code := r.PostForm.Get("code")
+	req, ok := s.codes[code]
+	delete(s.codes, code)
+	tamper := s.tamper
+	s.mux.Unlock()
+	if !ok {
+		tokenError(w, "invalid_grant", "unknown authorization code")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != req.redirectURI || r.PostForm.Get("client_id") != req.clientID {
+		tokenError(w, "invalid_grant", "redirect_uri or client_id mismatch")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != req.codeChallenge {
+		tokenError(w, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for key, val := range req.claims {
+		claims[key] = val
+	}
+	claims["iss"] = s.URL
+	claims["aud"] = s.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	claims["nonce"] = req.nonce
+	if tamper != nil {
+		tamper(claims)
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = KeyID
+	signed, err := idToken.SignedString(s.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func randomString() string {
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package oidc
+
+import (
+	"crypto/subtle"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+
+	"gopherbin/util"
+)
+
+// loginStateIssuer is the issuer of sealed login states. It prevents a
+// sealed state from being mistaken for any other token signed with the
+// same secret.
+const loginStateIssuer = "gopherbin-oidc-state"
+
+// LoginState holds the values that must survive the round trip to the
+// identity provider. It is sealed and stored in a cookie while the user
+// logs in.
+type LoginState struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	// Cookie is set if the login starts a cookie session.
+	Cookie bool `json:"cookie,omitempty"`
+}
+
+type loginStateClaims struct {
+	LoginState
+	jwt.RegisteredClaims
+}
+
+// NewLoginState returns a LoginState with random values
+func NewLoginState() (LoginState, error) {
+	var vals [3]string
+	for idx := range vals {
+		val, err := util.GetRandomString(48)
+		if err != nil {
+			return LoginState{}, errors.Wrap(err, "generating login state")
+		}
+		vals[idx] = val
+	}
+	return LoginState{
+		State:        vals[0],
+		Nonce:        vals[1],
+		CodeVerifier: vals[2],
+	}, nil
+}
+
+// Seal signs the login state using a key derived from secret. The sealed
+// state expires after ttl.
+func (l LoginState) Seal(secret string, ttl time.Duration) (string, error) {
+	claims := loginStateClaims{
+		LoginState: l,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    loginStateIssuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(util.DeriveKey(secret, loginStateIssuer))
+}
+
+// OpenLoginState verifies a sealed login state and checks that it was
+// created for the state returned by the identity provider.
+func OpenLoginState(sealed, secret, state string) (LoginState, error) {
+	claims := &loginStateClaims{}
+	_, err := jwt.ParseWithClaims(sealed, claims, func(token *jwt.Token) (interface{}, error) {
+		return util.DeriveKey(secret, loginStateIssuer), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(loginStateIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return LoginState{}, loginFailed("invalid or expired login state, please try again")
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(claims.State)) != 1 {
+		return LoginState{}, loginFailed("login state mismatch, please try again")
+	}
+	return claims.LoginState, nil
+}
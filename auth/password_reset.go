@@ -0,0 +1,91 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package auth
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+
+	gErrors "gopherbin/errors"
+	"gopherbin/util"
+)
+
+// passwordResetIssuer is the issuer of password reset tokens. It prevents
+// a password reset token from being mistaken for any other token signed
+// with the same secret.
+const passwordResetIssuer = "gopherbin-password-reset"
+
+// ErrInvalidPasswordResetToken is returned when a password reset token is
+// invalid, expired or has already been used.
+var ErrInvalidPasswordResetToken = gErrors.WithCode(
+	gErrors.NewUnauthorizedError("invalid or expired password reset link"),
+	gErrors.CodeInvalidToken)
+
+// PasswordResetClaims holds the claims of a password reset token, which
+// is sent by email to users that forgot their password. Like pre-auth
+// tokens, password reset tokens do not carry the user claim, so they are
+// refused by the JWT middleware.
+type PasswordResetClaims struct {
+	// SecurityStamp is the security stamp of the user when the reset
+	// was requested. Setting the new password rotates the stamp, so the
+	// token can only be used once.
+	SecurityStamp string `json:"stamp"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the ID of the user the token was issued to
+func (p PasswordResetClaims) UserID() uint {
+	return subjectUserID(p.Subject)
+}
+
+// NewPasswordResetToken returns a password reset token for the user in
+// the context, signed with a key derived from secret and valid for ttl.
+func NewPasswordResetToken(ctx context.Context, secret string, ttl time.Duration) (string, error) {
+	tokenID, err := util.GetRandomString(16)
+	if err != nil {
+		return "", errors.Wrap(err, "generating token ID")
+	}
+	claims := PasswordResetClaims{
+		SecurityStamp: SecurityStamp(ctx),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   strconv.FormatUint(uint64(UserID(ctx)), 10),
+			Issuer:    passwordResetIssuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(util.DeriveKey(secret, passwordResetIssuer))
+}
+
+// ParsePasswordResetToken verifies a password reset token and returns
+// its claims
+func ParsePasswordResetToken(token, secret string) (PasswordResetClaims, error) {
+	claims := PasswordResetClaims{}
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return util.DeriveKey(secret, passwordResetIssuer), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(passwordResetIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.ID == "" || claims.UserID() == 0 || claims.SecurityStamp == "" {
+		return PasswordResetClaims{}, ErrInvalidPasswordResetToken
+	}
+	return claims, nil
+}
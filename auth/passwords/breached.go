@@ -0,0 +1,153 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package passwords
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// hashLength is the length of a hex encoded SHA-1 hash
+	hashLength = 2 * sha1.Size
+	// maxLineLength is the longest line read from the list. Lines hold a
+	// hash, optionally followed by a colon and a count.
+	maxLineLength = 128
+)
+
+// BreachedList looks up passwords in a list of SHA-1 hashes of breached
+// passwords, such as the Have I Been Pwned download ordered by hash. The
+// list is sorted, so lookups do a binary search over the file instead of
+// loading it in memory. A BreachedList is safe for concurrent use.
+type BreachedList struct {
+	file *os.File
+	size int64
+}
+
+// OpenBreachedList opens the list of breached password hashes at path
+func OpenBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening breached passwords file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "reading breached passwords file")
+	}
+	list := &BreachedList{file: file, size: info.Size()}
+	if list.size > 0 {
+		line, err := list.lineAt(0)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if _, err := hex.DecodeString(string(line)); err != nil || len(line) != hashLength {
+			file.Close()
+			return nil, errors.New("breached passwords file does not hold SHA-1 hashes")
+		}
+	}
+	return list, nil
+}
+
+// Close closes the underlying file
+func (b *BreachedList) Close() error {
+	return b.file.Close()
+}
+
+// Contains returns true if the SHA-1 hash of password is in the list
+func (b *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := bytes.ToUpper([]byte(hex.EncodeToString(sum[:])))
+
+	// Find the first line, starting at or after an offset, whose hash
+	// is not smaller than the target. Lines are found by scanning
+	// forward to the next newline, so the search converges on the start
+	// of a line.
+	low, high := int64(0), b.size
+	for low < high {
+		mid := low + (high-low)/2
+		start, err := b.lineStart(mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= b.size {
+			high = mid
+			continue
+		}
+		line, err := b.lineAt(start)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Compare(bytes.ToUpper(line), target) < 0 {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	start, err := b.lineStart(low)
+	if err != nil || start >= b.size {
+		return false, err
+	}
+	line, err := b.lineAt(start)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(bytes.ToUpper(line), target), nil
+}
+
+// lineStart returns the offset of the first line beginning at or after
+// offset. It returns the size of the file if there is no such line.
+func (b *BreachedList) lineStart(offset int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, maxLineLength)
+	for pos := offset - 1; pos < b.size; pos += int64(len(buf)) {
+		n, err := b.file.ReadAt(buf, pos)
+		if err != nil && err != io.EOF {
+			return 0, errors.Wrap(err, "reading breached passwords file")
+		}
+		if idx := bytes.IndexByte(buf[:n], '\n'); idx >= 0 {
+			return pos + int64(idx) + 1, nil
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return b.size, nil
+}
+
+// lineAt returns the hash on the line starting at offset
+func (b *BreachedList) lineAt(offset int64) ([]byte, error) {
+	buf := make([]byte, maxLineLength)
+	n, err := b.file.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "reading breached passwords file")
+	}
+	line := buf[:n]
+	if idx := bytes.IndexByte(line, '\n'); idx >= 0 {
+		line = line[:idx]
+	}
+	if idx := bytes.IndexByte(line, ':'); idx >= 0 {
+		line = line[:idx]
+	}
+	return bytes.TrimSpace(line), nil
+}
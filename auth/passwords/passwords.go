@@ -0,0 +1,110 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package passwords checks new passwords against the configured password
+// policy: a minimum length, a minimum zxcvbn strength score and, optionally,
+// a list of passwords known to have been leaked in breaches.
+package passwords
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+
+	zxcvbn "github.com/nbutton23/zxcvbn-go"
+	"github.com/pkg/errors"
+)
+
+// Policy checks passwords against a config.PasswordPolicy
+type Policy struct {
+	minScore  int
+	minLength int
+	breached  *BreachedList
+}
+
+// NewPolicy returns a new *Policy. The breached passwords file, if any,
+// stays open for as long as the policy is used.
+func NewPolicy(cfg config.PasswordPolicy) (*Policy, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validating password policy")
+	}
+	policy := &Policy{
+		minScore:  cfg.MinimumScore(),
+		minLength: cfg.MinimumLength(),
+	}
+	if cfg.BreachedPasswordsFile != "" {
+		breached, err := OpenBreachedList(cfg.BreachedPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.breached = breached
+	}
+	return policy, nil
+}
+
+// UserInputs returns the details of a user that should not be guessable
+// from their password: the username, the email address and its local
+// part, and the words of the full name.
+func UserInputs(username, email, fullName string) []string {
+	inputs := []string{}
+	for _, value := range []string{username, email} {
+		if value != "" {
+			inputs = append(inputs, strings.ToLower(value))
+		}
+	}
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		inputs = append(inputs, strings.ToLower(email[:at]))
+	}
+	for _, word := range strings.Fields(fullName) {
+		inputs = append(inputs, strings.ToLower(word))
+	}
+	return inputs
+}
+
+// Check returns a validation error for the password field if password
+// does not follow the policy. The userInputs, usually returned by
+// UserInputs, are penalized when scoring the strength of the password.
+func (p *Policy) Check(password string, userInputs []string) error {
+	if length := utf8.RuneCountInString(password); length < p.minLength {
+		return gErrors.NewValidationError(gErrors.FieldError{
+			Field:   "password",
+			Code:    gErrors.CodePasswordTooShort,
+			Message: fmt.Sprintf("the password must be at least %d characters long", p.minLength),
+		})
+	}
+	if zxcvbn.PasswordStrength(password, userInputs).Score < p.minScore {
+		return gErrors.NewValidationError(gErrors.FieldError{
+			Field:   "password",
+			Code:    gErrors.CodePasswordTooWeak,
+			Message: "the password is too weak, please use a stronger password",
+		})
+	}
+	if p.breached != nil {
+		found, err := p.breached.Contains(password)
+		if err != nil {
+			return errors.Wrap(err, "checking breached passwords")
+		}
+		if found {
+			return gErrors.NewValidationError(gErrors.FieldError{
+				Field:   "password",
+				Code:    gErrors.CodePasswordBreached,
+				Message: "the password has appeared in a data breach, please use a different password",
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package passwords_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopherbin/auth/passwords"
+	"gopherbin/config"
+	gErrors "gopherbin/errors"
+)
+
+const strongPassword = "Correct-Horse-Battery-Staple-G0pherbin-2024!"
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writeBreachedList writes the hashes of the passwords, along with some
+// filler, in the format of the Have I Been Pwned download ordered by hash.
+func writeBreachedList(t *testing.T, breached ...string) string {
+	t.Helper()
+	var lines []string
+	for _, password := range breached {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(password), len(password)))
+	}
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf("%s:%d", sha1Hex(fmt.Sprintf("filler-%d", i)), i))
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func fieldCode(t *testing.T, err error) string {
+	t.Helper()
+	badReq, ok := err.(*gErrors.BadRequestError)
+	if !ok || len(badReq.Fields()) != 1 || badReq.Fields()[0].Field != "password" {
+		t.Fatalf("want a password field error, got %T: %v", err, err)
+	}
+	return badReq.Fields()[0].Code
+}
+
+func TestBreachedList_Contains(t *testing.T) {
+	breached := []string{"hunter2", "Summer2024!", "correcthorse", "filler-1000"}
+	list, err := passwords.OpenBreachedList(writeBreachedList(t, breached...))
+	if err != nil {
+		t.Fatalf("OpenBreachedList: %v", err)
+	}
+	defer list.Close()
+
+	for _, password := range append(breached, "filler-0", "filler-999") {
+		if found, err := list.Contains(password); err != nil || !found {
+			t.Errorf("%s: want found, got %v (%v)", password, found, err)
+		}
+	}
+	for _, password := range []string{strongPassword, "", "hunter3", "filler-1001"} {
+		if found, err := list.Contains(password); err != nil || found {
+			t.Errorf("%s: want not found, got %v (%v)", password, found, err)
+		}
+	}
+}
+
+func TestBreachedList_LowerCaseHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hashes.txt")
+	lines := []string{strings.ToLower(sha1Hex("hunter2")), strings.ToLower(sha1Hex("letmein"))}
+	sort.Strings(lines)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := passwords.OpenBreachedList(path)
+	if err != nil {
+		t.Fatalf("OpenBreachedList: %v", err)
+	}
+	defer list.Close()
+	for _, password := range []string{"hunter2", "letmein"} {
+		if found, err := list.Contains(password); err != nil || !found {
+			t.Errorf("%s: want found, got %v (%v)", password, found, err)
+		}
+	}
+}
+
+func TestOpenBreachedList_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwords.txt")
+	if err := os.WriteFile(path, []byte("hunter2\nletmein\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := passwords.OpenBreachedList(path); err == nil {
+		t.Error("expected error for a list of plain text passwords")
+	}
+	if _, err := passwords.OpenBreachedList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected error for a missing file")
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy, err := passwords.NewPolicy(config.PasswordPolicy{
+		BreachedPasswordsFile: writeBreachedList(t, strongPassword),
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	if code := fieldCode(t, policy.Check("Xy9!", nil)); code != gErrors.CodePasswordTooShort {
+		t.Errorf("short password: want %s, got %s", gErrors.CodePasswordTooShort, code)
+	}
+	if code := fieldCode(t, policy.Check("password", nil)); code != gErrors.CodePasswordTooWeak {
+		t.Errorf("weak password: want %s, got %s", gErrors.CodePasswordTooWeak, code)
+	}
+	if code := fieldCode(t, policy.Check(strongPassword, nil)); code != gErrors.CodePasswordBreached {
+		t.Errorf("breached password: want %s, got %s", gErrors.CodePasswordBreached, code)
+	}
+	if err := policy.Check("Another-Correct-Horse-Battery-Staple-2024!", nil); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+}
+
+func TestPolicy_Check_UserInputs(t *testing.T) {
+	policy, err := passwords.NewPolicy(config.PasswordPolicy{})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	password := "Quarvelintosh-Brimdale"
+	if err := policy.Check(password, nil); err != nil {
+		t.Fatalf("expected valid without user inputs, got %v", err)
+	}
+	inputs := passwords.UserInputs("qbrimdale", "quarvelintosh@example.com", "Quarvelintosh Brimdale")
+	if code := fieldCode(t, policy.Check(password, inputs)); code != gErrors.CodePasswordTooWeak {
+		t.Errorf("password made of the user name: want %s, got %s", gErrors.CodePasswordTooWeak, code)
+	}
+}
+
+func TestPolicy_Check_Configurable(t *testing.T) {
+	score := 0
+	policy, err := passwords.NewPolicy(config.PasswordPolicy{MinScore: &score, MinLength: 4})
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	if err := policy.Check("password", nil); err != nil {
+		t.Errorf("expected valid with min score 0, got %v", err)
+	}
+	if code := fieldCode(t, policy.Check("abc", nil)); code != gErrors.CodePasswordTooShort {
+		t.Errorf("want %s, got %s", gErrors.CodePasswordTooShort, code)
+	}
+}
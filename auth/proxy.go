@@ -0,0 +1,131 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	adminCommon "gopherbin/admin/common"
+	"gopherbin/config"
+	"gopherbin/util"
+)
+
+// ProxyProviderName is the auth provider recorded for users
+// authenticated by a reverse proxy.
+const ProxyProviderName = "proxy"
+
+// NewProxyAuthMiddleware returns a middleware that authenticates requests
+// using the user and email headers set by an authenticating reverse proxy.
+// The headers are only trusted on requests coming from one of the trusted
+// proxies. Other requests are passed on untouched, so this middleware must
+// be chained in front of another authentication middleware. The headers
+// only prove the first factor, so twoFactor and twoFactorCfg limit the
+// scopes of users that enabled, or must enable, two-factor authentication.
+func NewProxyAuthMiddleware(manager adminCommon.UserManager, twoFactor adminCommon.TwoFactorManager, cfg config.ProxyAuth, twoFactorCfg config.TwoFactor) (Middleware, error) {
+	networks, err := cfg.TrustedNetworks()
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing trusted proxies")
+	}
+	if cfg.UserHeader == "" {
+		cfg.UserHeader = config.DefaultProxyUserHeader
+	}
+	if cfg.EmailHeader == "" {
+		cfg.EmailHeader = config.DefaultProxyEmailHeader
+	}
+	return &proxyAuthMiddleware{
+		manager:      manager,
+		twoFactor:    twoFactor,
+		cfg:          cfg,
+		twoFactorCfg: twoFactorCfg,
+		networks:     networks,
+	}, nil
+}
+
+type proxyAuthMiddleware struct {
+	manager      adminCommon.UserManager
+	twoFactor    adminCommon.TwoFactorManager
+	cfg          config.ProxyAuth
+	twoFactorCfg config.TwoFactor
+	networks     []*net.IPNet
+}
+
+// isTrusted returns true if the request was made by a trusted proxy. Only
+// the address of the connection counts, as forwarding headers may be set
+// by anyone.
+func (amw *proxyAuthMiddleware) isTrusted(r *http.Request) bool {
+	return containsIP(amw.networks, remoteIP(r))
+}
+
+// Middleware implements the middleware interface
+func (amw *proxyAuthMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := strings.TrimSpace(r.Header.Get(amw.cfg.UserHeader))
+		if AuthMethod(ctx) != "" || user == "" || !amw.isTrusted(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		email := strings.TrimSpace(r.Header.Get(amw.cfg.EmailHeader))
+		identity := adminCommon.ExternalIdentity{
+			Provider: ProxyProviderName,
+			Subject:  user,
+			Username: util.UsernameFrom(user),
+			Email:    email,
+			// The proxy is trusted to have verified the email address,
+			// and to be managed by the same people as gopherbin.
+			EmailVerified: true,
+			LinkByEmail:   true,
+			LinkOnly:      !amw.cfg.AutoProvision,
+		}
+		ctx, err := amw.manager.AuthenticateExternal(ctx, identity)
+		if err != nil || !IsEnabled(ctx) || IsAnonymous(ctx) {
+			invalidAuthResponse(w, r)
+			return
+		}
+		scopes, err := amw.scopes(ctx)
+		if err != nil {
+			invalidAuthResponse(w, r)
+			return
+		}
+		ctx = SetScopes(ctx, scopes)
+		ctx = SetAuthMethod(ctx, AuthMethodProxy)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// scopes returns the scopes granted to the user in ctx. Users that enabled
+// two-factor authentication get none, and must log in through the proxy
+// login endpoint, which asks for a code. Admins that must enable it but
+// have not yet may only enroll an authenticator, as when logging in with
+// a password.
+func (amw *proxyAuthMiddleware) scopes(ctx context.Context) ([]string, error) {
+	status, err := amw.twoFactor.Status(GetAdminContext(), UserID(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching two-factor status")
+	}
+	if status.Enabled {
+		return []string{}, nil
+	}
+	if amw.twoFactorCfg.RequireForAdmins && (IsAdmin(ctx) || IsSuperUser(ctx)) {
+		return []string{ScopeAccount}, nil
+	}
+	return AllScopes(), nil
+}
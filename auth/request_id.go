@@ -0,0 +1,128 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"gopherbin/util"
+)
+
+const (
+	// RequestIDHeader is the header used to send the request ID. If a client
+	// or proxy sets it on the request, the supplied value is used.
+	RequestIDHeader = "X-Request-ID"
+	// maxRequestIDLength limits the size of request IDs supplied by clients
+	maxRequestIDLength = 128
+)
+
+// NewRequestIDMiddleware returns a middleware that assigns an ID to every
+// request. The ID is saved in the request context, and is sent back to the
+// client in the X-Request-ID header and in error responses. The address of
+// the client is saved in the context as well. The X-Forwarded-For and
+// X-Real-IP headers are only used to find the client address on requests
+// coming from one of the trusted proxy networks.
+func NewRequestIDMiddleware(trustedProxies []*net.IPNet) (Middleware, error) {
+	return &requestIDMiddleware{
+		trustedProxies: trustedProxies,
+	}, nil
+}
+
+type requestIDMiddleware struct {
+	trustedProxies []*net.IPNet
+}
+
+// remoteIP returns the address of the connection the request came from
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// containsIP returns true if ip is part of one of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that sent the request. When
+// the request comes from a trusted proxy, X-Forwarded-For is walked from
+// the right, skipping the trusted proxies, as anything to the left of the
+// last untrusted hop may have been set by the client.
+func (rmw *requestIDMiddleware) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !containsIP(rmw.trustedProxies, ip) {
+		if ip == nil {
+			return r.RemoteAddr
+		}
+		return ip.String()
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		if !containsIP(rmw.trustedProxies, hop) {
+			return hop.String()
+		}
+	}
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP.String()
+	}
+	return ip.String()
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range requestID {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware implements the middleware interface
+func (rmw *requestIDMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			var err error
+			requestID, err = util.GetRandomString(24)
+			if err != nil {
+				http.Error(w, "failed to generate request ID", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		ctx := SetRequestID(r.Context(), requestID)
+		ctx = SetClientIP(ctx, rmw.clientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"gopherbin/apiserver/responses"
+	gErrors "gopherbin/errors"
+)
+
+// Scopes limit what a token may be used for. They only ever restrict a
+// token: a scope never grants permissions the user does not have, so a
+// token with the admin:users scope is only useful to admins.
+const (
+	// ScopePasteRead allows listing, searching and viewing pastes and tags
+	ScopePasteRead = "paste:read"
+	// ScopePasteWrite allows creating, updating and deleting pastes
+	ScopePasteWrite = "paste:write"
+	// ScopeShare allows sharing pastes with other users
+	ScopeShare = "share"
+	// ScopeTeams allows managing teams and team members
+	ScopeTeams = "teams"
+	// ScopeTokens allows managing personal API tokens
+	ScopeTokens = "tokens"
+	// ScopeAdminUsers allows managing users and their details
+	ScopeAdminUsers = "admin:users"
+	// ScopeAdminAudit allows querying and exporting the audit log
+	ScopeAdminAudit = "admin:audit"
+	// ScopeAccount allows managing the profile and the security
+	// settings of the account, such as two-factor authentication
+	ScopeAccount = "account"
+)
+
+// AllScopes returns all known scopes. Tokens issued before scopes were
+// introduced are treated as having all of them.
+func AllScopes() []string {
+	return []string{
+		ScopePasteRead,
+		ScopePasteWrite,
+		ScopeShare,
+		ScopeTeams,
+		ScopeTokens,
+		ScopeAdminUsers,
+		ScopeAdminAudit,
+		ScopeAccount,
+	}
+}
+
+// IsValidScope returns true if scope is a known scope
+func IsValidScope(scope string) bool {
+	for _, val := range AllScopes() {
+		if val == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// SetScopes sets the scopes granted to the request in the context
+func SetScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesKey, scopes)
+}
+
+// Scopes returns the scopes granted to the request
+func Scopes(ctx context.Context) []string {
+	scopes := ctx.Value(scopesKey)
+	if scopes == nil {
+		return nil
+	}
+	return scopes.([]string)
+}
+
+// HasScope returns true if the request has been granted scope
+func HasScope(ctx context.Context, scope string) bool {
+	for _, val := range Scopes(ctx) {
+		if val == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingScopeError returns the error sent when a request lacks scope
+func MissingScopeError(scope string) error {
+	return gErrors.WithCode(
+		gErrors.NewForbiddenError("token is missing the %s scope", scope),
+		gErrors.CodeInsufficientScope)
+}
+
+// ResolveScopes validates the scopes requested for a new token against
+// the scopes held by the context. If no scopes are requested, the new
+// token gets all the scopes held by the context. A token may never get
+// scopes the context does not hold.
+func ResolveScopes(ctx context.Context, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return append([]string(nil), Scopes(ctx)...), nil
+	}
+
+	wanted := map[string]bool{}
+	for _, scope := range requested {
+		if !IsValidScope(scope) {
+			return nil, gErrors.NewValidationError(gErrors.FieldError{
+				Field:   "scopes",
+				Code:    gErrors.CodeInvalidScope,
+				Message: "unknown scope " + scope,
+			})
+		}
+		if !HasScope(ctx, scope) {
+			return nil, MissingScopeError(scope)
+		}
+		wanted[scope] = true
+	}
+
+	// Return the scopes in a stable order, without duplicates.
+	var ret []string
+	for _, scope := range AllScopes() {
+		if wanted[scope] {
+			ret = append(ret, scope)
+		}
+	}
+	return ret, nil
+}
+
+// RequireScope returns a middleware that rejects requests which have
+// not been granted scope. It must be applied after the authentication
+// middleware.
+func RequireScope(scope string) Middleware {
+	return scopeMiddleware(scope)
+}
+
+type scopeMiddleware string
+
+// Middleware implements the middleware interface
+func (s scopeMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := string(s)
+		if !HasScope(r.Context(), scope) {
+			responses.WriteError(w, http.StatusForbidden, responses.APIErrorResponse{
+				Error:     "Forbidden",
+				Details:   MissingScopeError(scope).Error(),
+				Code:      gErrors.CodeInsufficientScope,
+				RequestID: RequestID(r.Context()),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
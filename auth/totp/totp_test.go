@@ -0,0 +1,93 @@
+package totp_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopherbin/auth/totp"
+)
+
+// rfcSecret is the SHA1 key used by the test vectors in RFC 6238,
+// "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode_RFC6238Vectors(t *testing.T) {
+	// The RFC lists 8 digit codes, we use the last 6 digits.
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for ts, want := range vectors {
+		got, err := totp.Code(rfcSecret, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", ts, err)
+		}
+		if got != want {
+			t.Errorf("Code(%d) = %s, want %s", ts, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret: %v", err)
+	}
+	now := time.Now()
+	code, err := totp.Code(secret, now)
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+
+	counter, err := totp.Validate(secret, code, now, 0)
+	if err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if counter != totp.Counter(now) {
+		t.Errorf("counter = %d, want %d", counter, totp.Counter(now))
+	}
+
+	t.Run("clock_drift", func(t *testing.T) {
+		if _, err := totp.Validate(secret, code, now.Add(totp.Period), 0); err != nil {
+			t.Errorf("code from the previous period rejected: %v", err)
+		}
+		if _, err := totp.Validate(secret, code, now.Add(3*totp.Period), 0); err == nil {
+			t.Error("expected code from 3 periods ago to be rejected")
+		}
+	})
+	t.Run("replay", func(t *testing.T) {
+		if _, err := totp.Validate(secret, code, now, counter); err == nil {
+			t.Error("expected used code to be rejected")
+		}
+	})
+	t.Run("malformed", func(t *testing.T) {
+		for _, val := range []string{"", "12345", "1234567", "abcdef"} {
+			if _, err := totp.Validate(secret, val, now, 0); err == nil {
+				t.Errorf("expected %q to be rejected", val)
+			}
+		}
+	})
+	t.Run("spaces", func(t *testing.T) {
+		spaced := code[:3] + " " + code[3:]
+		if _, err := totp.Validate(secret, spaced, now, 0); err != nil {
+			t.Errorf("code with a space rejected: %v", err)
+		}
+	})
+}
+
+func TestURI(t *testing.T) {
+	uri := totp.URI("Gopherbin", "jane doe", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/Gopherbin:jane%20doe?") {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	for _, part := range []string{"secret=ABC", "issuer=Gopherbin", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI %s is missing %s", uri, part)
+		}
+	}
+}
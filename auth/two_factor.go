@@ -0,0 +1,107 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package auth
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+
+	gErrors "gopherbin/errors"
+	"gopherbin/util"
+)
+
+const (
+	// preAuthIssuer is the issuer of pre-auth tokens. It prevents a
+	// pre-auth token from being mistaken for any other token signed
+	// with the same secret.
+	preAuthIssuer = "gopherbin-2fa"
+	// PreAuthTTL is how long users have to enter their two-factor
+	// code after entering their password.
+	PreAuthTTL = 5 * time.Minute
+)
+
+// ErrInvalidPreAuthToken is returned when a pre-auth token is invalid,
+// expired or has already been used.
+var ErrInvalidPreAuthToken = gErrors.WithCode(
+	gErrors.NewUnauthorizedError("invalid or expired login, please log in again"),
+	gErrors.CodeInvalidToken)
+
+// PreAuthClaims holds the claims of a pre-auth token. A pre-auth token
+// is issued to users with two-factor authentication enabled once they
+// have entered their password, and is exchanged for a session token
+// together with a two-factor code. Pre-auth tokens do not carry the user
+// claim, so they are refused by the JWT middleware.
+type PreAuthClaims struct {
+	// SecurityStamp is the security stamp of the user when the
+	// password was entered.
+	SecurityStamp string `json:"stamp"`
+	// Scopes are the scopes requested when the password was entered
+	Scopes []string `json:"scopes,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the ID of the user the token was issued to
+func (p PreAuthClaims) UserID() uint {
+	return subjectUserID(p.Subject)
+}
+
+// subjectUserID returns the user ID held in the subject claim of a token
+func subjectUserID(subject string) uint {
+	userID, err := strconv.ParseUint(subject, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(userID)
+}
+
+// NewPreAuthToken returns a pre-auth token for the user in the context,
+// signed with a key derived from secret.
+func NewPreAuthToken(ctx context.Context, secret string, scopes []string) (string, error) {
+	tokenID, err := util.GetRandomString(16)
+	if err != nil {
+		return "", errors.Wrap(err, "generating token ID")
+	}
+	claims := PreAuthClaims{
+		SecurityStamp: SecurityStamp(ctx),
+		Scopes:        scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   strconv.FormatUint(uint64(UserID(ctx)), 10),
+			Issuer:    preAuthIssuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(PreAuthTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(util.DeriveKey(secret, preAuthIssuer))
+}
+
+// ParsePreAuthToken verifies a pre-auth token and returns its claims
+func ParsePreAuthToken(token, secret string) (PreAuthClaims, error) {
+	claims := PreAuthClaims{}
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (interface{}, error) {
+		return util.DeriveKey(secret, preAuthIssuer), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(preAuthIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.ID == "" || claims.UserID() == 0 {
+		return PreAuthClaims{}, ErrInvalidPreAuthToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,305 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopherbin/params"
+)
+
+// ListUsers returns a page of users. This requires admin privileges.
+func (c *Client) ListUsers(ctx context.Context, page, maxResults int64) (params.UserListResult, error) {
+	var ret params.UserListResult
+	if err := c.do(ctx, http.MethodGet, "/admin/users", pageQuery(page, maxResults), nil, &ret); err != nil {
+		return params.UserListResult{}, err
+	}
+	return ret, nil
+}
+
+// UserFilter narrows down the users returned by SearchUsers. Empty fields
+// match any user.
+type UserFilter struct {
+	// Query matches a substring of the username, email address or full
+	// name, regardless of case.
+	Query   string
+	Enabled *bool
+	IsAdmin *bool
+	// LastLoginSince matches users that logged in at or after the given
+	// time.
+	LastLoginSince *time.Time
+	// LastLoginBefore matches users that did not log in since the given
+	// time, including users that never logged in.
+	LastLoginBefore *time.Time
+}
+
+func (f UserFilter) query(query url.Values) url.Values {
+	if f.Query != "" {
+		query.Set("q", f.Query)
+	}
+	if f.Enabled != nil {
+		query.Set("enabled", fmt.Sprintf("%t", *f.Enabled))
+	}
+	if f.IsAdmin != nil {
+		query.Set("admin", fmt.Sprintf("%t", *f.IsAdmin))
+	}
+	if f.LastLoginSince != nil {
+		query.Set("last_login_since", f.LastLoginSince.Format(time.RFC3339))
+	}
+	if f.LastLoginBefore != nil {
+		query.Set("last_login_before", f.LastLoginBefore.Format(time.RFC3339))
+	}
+	return query
+}
+
+// SearchUsers returns a page of the users matching filter. This requires
+// admin privileges.
+func (c *Client) SearchUsers(ctx context.Context, filter UserFilter, page, maxResults int64) (params.UserListResult, error) {
+	var ret params.UserListResult
+	if err := c.do(ctx, http.MethodGet, "/admin/users", filter.query(pageQuery(page, maxResults)), nil, &ret); err != nil {
+		return params.UserListResult{}, err
+	}
+	return ret, nil
+}
+
+// GetUserDetails returns a user along with their paste count, storage
+// used, teams and active sessions. This requires admin privileges.
+func (c *Client) GetUserDetails(ctx context.Context, userID uint) (params.UserDetails, error) {
+	var ret params.UserDetails
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/users/%d", userID), nil, nil, &ret); err != nil {
+		return params.UserDetails{}, err
+	}
+	return ret, nil
+}
+
+// EnableUser enables a user. This requires admin privileges.
+func (c *Client) EnableUser(ctx context.Context, userID uint) (params.Users, error) {
+	var ret params.Users
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/users/%d/enable", userID), nil, nil, &ret); err != nil {
+		return params.Users{}, err
+	}
+	return ret, nil
+}
+
+// DisableUser disables a user, logging them out everywhere. This requires
+// admin privileges.
+func (c *Client) DisableUser(ctx context.Context, userID uint) (params.Users, error) {
+	var ret params.Users
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/users/%d/disable", userID), nil, nil, &ret); err != nil {
+		return params.Users{}, err
+	}
+	return ret, nil
+}
+
+// ExportUserData downloads the data export of a user, the same zip
+// archive users get from ExportData. This requires admin privileges. The
+// caller must close the returned archive.
+func (c *Client) ExportUserData(ctx context.Context, userID uint) (io.ReadCloser, error) {
+	resp, err := c.doRaw(ctx, http.MethodGet, fmt.Sprintf("/admin/users/%d/export", userID), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// CreateUser creates a new user. This requires admin privileges.
+func (c *Client) CreateUser(ctx context.Context, user params.NewUserParams) (params.Users, error) {
+	var ret params.Users
+	if err := c.do(ctx, http.MethodPost, "/admin/users", nil, user, &ret); err != nil {
+		return params.Users{}, err
+	}
+	return ret, nil
+}
+
+// UpdateUser updates a user. This requires admin privileges, users change
+// their own details with UpdateProfile and ChangePassword.
+func (c *Client) UpdateUser(ctx context.Context, userID uint, update params.UpdateUserPayload) (params.Users, error) {
+	var ret params.Users
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/admin/users/%d", userID), nil, update, &ret); err != nil {
+		return params.Users{}, err
+	}
+	return ret, nil
+}
+
+// DeleteUserOptions changes what happens to the pastes, teams and shares
+// of a deleted user
+type DeleteUserOptions struct {
+	// TransferTo is the username or email address of the user that gets
+	// the pastes, teams and shares of the deleted user. They are deleted
+	// along with the user if TransferTo is empty.
+	TransferTo string
+	// DryRun reports what would be deleted or transferred, without
+	// deleting the user.
+	DryRun bool
+}
+
+// DeleteUser deletes a user, and returns what was deleted or transferred
+// along with them. This requires admin privileges.
+func (c *Client) DeleteUser(ctx context.Context, userID uint, opts DeleteUserOptions) (params.UserDeletionReport, error) {
+	query := url.Values{}
+	if opts.TransferTo != "" {
+		query.Set("transfer_to", opts.TransferTo)
+	}
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+	var ret params.UserDeletionReport
+	if err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/users/%d", userID), query, nil, &ret); err != nil {
+		return params.UserDeletionReport{}, err
+	}
+	return ret, nil
+}
+
+// ListUserAPITokens returns the API tokens of a user. This requires admin
+// privileges.
+func (c *Client) ListUserAPITokens(ctx context.Context, userID uint) ([]params.APIToken, error) {
+	var ret params.APITokenListResult
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/users/%d/tokens", userID), nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	return ret.Tokens, nil
+}
+
+// RevokeUserAPIToken revokes an API token of a user. This requires admin
+// privileges.
+func (c *Client) RevokeUserAPIToken(ctx context.Context, userID, tokenID uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/users/%d/tokens/%d", userID, tokenID), nil, nil, nil)
+}
+
+// ListUserClientCertificates returns the client certificates of a user.
+// This requires admin privileges.
+func (c *Client) ListUserClientCertificates(ctx context.Context, userID uint) ([]params.ClientCertificate, error) {
+	var ret params.ClientCertificateListResult
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/users/%d/certificates", userID), nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	return ret.Certificates, nil
+}
+
+// DeleteUserClientCertificate deletes a client certificate of a user.
+// This requires admin privileges.
+func (c *Client) DeleteUserClientCertificate(ctx context.Context, userID, certID uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/users/%d/certificates/%d", userID, certID), nil, nil, nil)
+}
+
+// UserTwoFactorStatus returns the two-factor authentication settings of
+// a user. This requires admin privileges.
+func (c *Client) UserTwoFactorStatus(ctx context.Context, userID uint) (params.TwoFactorStatus, error) {
+	var ret params.TwoFactorStatus
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/users/%d/2fa", userID), nil, nil, &ret); err != nil {
+		return params.TwoFactorStatus{}, err
+	}
+	return ret, nil
+}
+
+// ResetUserTwoFactor disables two-factor authentication for a user. This
+// requires admin privileges.
+func (c *Client) ResetUserTwoFactor(ctx context.Context, userID uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/users/%d/2fa", userID), nil, nil, nil)
+}
+
+// ListUserSessions returns the active sessions of a user. This requires
+// admin privileges.
+func (c *Client) ListUserSessions(ctx context.Context, userID uint) ([]params.Session, error) {
+	var ret params.SessionListResult
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/admin/users/%d/sessions", userID), nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	return ret.Sessions, nil
+}
+
+// RevokeUserSession revokes a session of a user. This requires admin
+// privileges.
+func (c *Client) RevokeUserSession(ctx context.Context, userID uint, sessionID string) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/users/%d/sessions/%s", userID, escape(sessionID)), nil, nil, nil)
+}
+
+// RevokeUserSessions logs a user out everywhere. This requires admin
+// privileges.
+func (c *Client) RevokeUserSessions(ctx context.Context, userID uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/users/%d/sessions", userID), nil, nil, nil)
+}
+
+// UnlockUser forgets the failed logins of a user, lifting a lockout.
+// This requires admin privileges.
+func (c *Client) UnlockUser(ctx context.Context, userID uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/users/%d/lockout", userID), nil, nil, nil)
+}
+
+// AuditLogFilter narrows down the events returned from the audit log.
+// Empty fields match any event.
+type AuditLogFilter struct {
+	Actions []string
+	// ActorID is 0 to match anonymous actors, such as someone failing
+	// to log in.
+	ActorID    *uint
+	TargetType string
+	TargetID   string
+	IP         string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+func (f AuditLogFilter) query(query url.Values) url.Values {
+	if len(f.Actions) > 0 {
+		query.Set("action", strings.Join(f.Actions, ","))
+	}
+	if f.ActorID != nil {
+		query.Set("actor_id", fmt.Sprintf("%d", *f.ActorID))
+	}
+	if f.TargetType != "" {
+		query.Set("target_type", f.TargetType)
+	}
+	if f.TargetID != "" {
+		query.Set("target_id", f.TargetID)
+	}
+	if f.IP != "" {
+		query.Set("ip", f.IP)
+	}
+	if f.Since != nil {
+		query.Set("since", f.Since.Format(time.RFC3339))
+	}
+	if f.Until != nil {
+		query.Set("until", f.Until.Format(time.RFC3339))
+	}
+	return query
+}
+
+// ListAuditEvents returns a page of the audit events matching filter,
+// newest first. This requires admin privileges.
+func (c *Client) ListAuditEvents(ctx context.Context, filter AuditLogFilter, page, maxResults int64) (params.AuditLogListResult, error) {
+	var ret params.AuditLogListResult
+	if err := c.do(ctx, http.MethodGet, "/admin/audit", filter.query(pageQuery(page, maxResults)), nil, &ret); err != nil {
+		return params.AuditLogListResult{}, err
+	}
+	return ret, nil
+}
+
+// ExportAuditLog returns the audit events matching filter as JSON lines,
+// oldest first. This requires admin privileges. The caller must close the
+// returned reader.
+func (c *Client) ExportAuditLog(ctx context.Context, filter AuditLogFilter) (io.ReadCloser, error) {
+	resp, err := c.doRaw(ctx, http.MethodGet, "/admin/audit/export", filter.query(url.Values{}), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
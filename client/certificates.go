@@ -0,0 +1,52 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"gopherbin/params"
+)
+
+// RegisterClientCertificate registers the TLS client certificate presented
+// by the client, which must be built with WithHTTPClient, for the current
+// user. The request must be authenticated by a token, as the certificate
+// is not registered yet. Requests made with the certificate are then
+// authenticated as the user.
+func (c *Client) RegisterClientCertificate(ctx context.Context, cert params.NewClientCertificateParams) (params.ClientCertificate, error) {
+	var ret params.ClientCertificate
+	if err := c.do(ctx, http.MethodPost, "/account/certificates", nil, cert, &ret); err != nil {
+		return params.ClientCertificate{}, err
+	}
+	return ret, nil
+}
+
+// ListClientCertificates returns the client certificates of the current
+// user.
+func (c *Client) ListClientCertificates(ctx context.Context) ([]params.ClientCertificate, error) {
+	var ret params.ClientCertificateListResult
+	if err := c.do(ctx, http.MethodGet, "/account/certificates", nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	return ret.Certificates, nil
+}
+
+// DeleteClientCertificate deletes one of the client certificates of the
+// current user.
+func (c *Client) DeleteClientCertificate(ctx context.Context, certID uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/account/certificates/%d", certID), nil, nil, nil)
+}
@@ -0,0 +1,313 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package client implements a Go client for the gopherbin REST API.
+//
+// Errors returned by the API are decoded into the types defined in the
+// gopherbin/errors package, so callers can use the same type checks the
+// server uses internally:
+//
+//	_, err := cli.GetPaste(ctx, pasteID)
+//	if _, ok := err.(*gErrors.NotFoundError); ok {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopherbin/apiserver/responses"
+	gErrors "gopherbin/errors"
+	"gopherbin/params"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInitRequired is returned when gopherbin has not been initialized
+// yet. The superuser must be created using FirstRun before any other
+// request will succeed.
+var ErrInitRequired = gErrors.WithCode(
+	gErrors.NewConflictError("gopherbin has not been initialized, a superuser must be created first"),
+	gErrors.CodeInitRequired)
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to make requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithToken sets the authentication token sent with every request
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// NewClient returns a new gopherbin API client. The baseURL is the URL
+// gopherbin is reachable at, for example https://paste.example.com.
+func NewClient(baseURL string, opts ...Option) (*Client, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing base URL")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+
+	cli := &Client{
+		baseURL:    parsed,
+		httpClient: &http.Client{},
+	}
+	for _, opt := range opts {
+		opt(cli)
+	}
+	return cli, nil
+}
+
+// Client is a gopherbin API client. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+
+	mux   sync.RWMutex
+	token string
+}
+
+// Token returns the authentication token currently used by the client
+func (c *Client) Token() string {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.token
+}
+
+// SetToken sets the authentication token used by the client
+func (c *Client) SetToken(token string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.token = token
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	u := *c.baseURL
+	u.Path = u.Path + "/api/v1" + path
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// decodeError converts an error response returned by the API into one of
+// the error types defined in gopherbin/errors. The error code sent by the
+// server is preserved, and can be retrieved using gErrors.Code().
+func decodeError(resp *http.Response) error {
+	var apiErr responses.APIErrorResponse
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil || json.Unmarshal(body, &apiErr) != nil {
+		apiErr.Details = strings.TrimSpace(string(body))
+	}
+	details := apiErr.Details
+	if details == "" {
+		details = apiErr.Error
+	}
+
+	var ret error
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		if len(apiErr.Fields) > 0 {
+			ret = gErrors.NewValidationError(apiErr.Fields...)
+		} else {
+			ret = gErrors.NewBadRequestError("%s", details)
+		}
+	case http.StatusUnauthorized:
+		ret = gErrors.NewUnauthorizedError(details)
+	case http.StatusForbidden:
+		ret = gErrors.NewForbiddenError("%s", details)
+	case http.StatusNotFound:
+		ret = gErrors.NewNotFoundError(details)
+	case http.StatusConflict:
+		if apiErr.Code == gErrors.CodeInitRequired {
+			return ErrInitRequired
+		}
+		ret = gErrors.NewConflictError("%s", details)
+	case http.StatusTooManyRequests:
+		var retryAfter time.Duration
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		ret = gErrors.NewTooManyRequestsError(retryAfter, "%s", details)
+	default:
+		return fmt.Errorf("unexpected response from server (%s): %s", resp.Status, details)
+	}
+	if apiErr.Code != "" {
+		ret = gErrors.WithCode(ret, apiErr.Code)
+	}
+	return ret
+}
+
+// doRaw performs a request and returns the response if the server replied
+// with a 2xx status code. The caller must close the response body.
+func (c *Client) doRaw(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding request body")
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, query), reqBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating request")
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := c.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+	return resp, nil
+}
+
+// do performs a request and decodes the JSON response into out, if out
+// is not nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.doRaw(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrap(err, "decoding response")
+	}
+	return nil
+}
+
+func pageQuery(page, maxResults int64) url.Values {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", fmt.Sprintf("%d", page))
+	}
+	if maxResults > 0 {
+		query.Set("max_results", fmt.Sprintf("%d", maxResults))
+	}
+	return query
+}
+
+func escape(segment string) string {
+	return url.PathEscape(segment)
+}
+
+// FirstRun initializes gopherbin by creating the superuser.
+func (c *Client) FirstRun(ctx context.Context, user params.NewUserParams) (params.Users, error) {
+	var ret params.Users
+	if err := c.do(ctx, http.MethodPost, "/first-run/", nil, user, &ret); err != nil {
+		return params.Users{}, err
+	}
+	return ret, nil
+}
+
+// Login authenticates against gopherbin. On success, the returned token
+// is used for all subsequent requests made by this client. The token is
+// limited to the given scopes, or gets all scopes if none are given.
+//
+// If the user has two-factor authentication enabled, no token is returned.
+// Instead, TwoFactorRequired is set and the login must be completed by
+// passing the returned pre-auth token to LoginTwoFactor.
+func (c *Client) Login(ctx context.Context, username, password string, scopes ...string) (params.JWTResponse, error) {
+	var ret params.JWTResponse
+	loginParams := params.PasswordLoginParams{
+		Username: username,
+		Password: password,
+		Scopes:   scopes,
+	}
+	if err := c.do(ctx, http.MethodPost, "/auth/login", nil, loginParams, &ret); err != nil {
+		return params.JWTResponse{}, err
+	}
+	if ret.Token != "" {
+		c.SetToken(ret.Token)
+	}
+	return ret, nil
+}
+
+// LoginTwoFactor completes the login of a user with two-factor
+// authentication enabled. The code may be generated by the authenticator
+// app, or be a recovery code. On success, the returned token is used for
+// all subsequent requests made by this client.
+func (c *Client) LoginTwoFactor(ctx context.Context, preAuthToken, code string) (params.JWTResponse, error) {
+	var ret params.JWTResponse
+	loginParams := params.TwoFactorLoginParams{
+		PreAuthToken: preAuthToken,
+		Code:         code,
+	}
+	if err := c.do(ctx, http.MethodPost, "/auth/login/2fa", nil, loginParams, &ret); err != nil {
+		return params.JWTResponse{}, err
+	}
+	c.SetToken(ret.Token)
+	return ret, nil
+}
+
+// ForgotPassword asks the server to email a password reset link to the
+// user with the given email address. It succeeds whether or not the
+// address belongs to a user.
+func (c *Client) ForgotPassword(ctx context.Context, email string) error {
+	return c.do(ctx, http.MethodPost, "/auth/password/forgot", nil, params.ForgotPasswordParams{Email: email}, nil)
+}
+
+// ResetPassword sets a new password using the token from a password reset
+// email. The user is logged out everywhere and has to log in again.
+func (c *Client) ResetPassword(ctx context.Context, token, password string) error {
+	resetParams := params.ResetPasswordParams{
+		Token:    token,
+		Password: password,
+	}
+	return c.do(ctx, http.MethodPost, "/auth/password/reset", nil, resetParams, nil)
+}
+
+// Logout invalidates the token currently used by the client.
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodGet, "/logout", nil, nil, nil); err != nil {
+		return err
+	}
+	c.SetToken("")
+	return nil
+}
@@ -0,0 +1,2703 @@
+package client_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+
+	"gopherbin/apiserver"
+	"gopherbin/apiserver/responses"
+	"gopherbin/auth"
+	"gopherbin/auth/ldap/ldaptest"
+	"gopherbin/auth/oidc/oidctest"
+	"gopherbin/auth/totp"
+	"gopherbin/client"
+	"gopherbin/config"
+	"gopherbin/config/certtest"
+	gErrors "gopherbin/errors"
+	"gopherbin/mail/mailtest"
+	"gopherbin/params"
+)
+
+const testPassword = "Correct-Horse-Battery-Staple-G0pherbin-2024!"
+
+// newServerFixture starts an API server backed by a fresh sqlite database
+// and returns an unauthenticated client pointed at it.
+func newServerFixture(t *testing.T) (*client.Client, string) {
+	t.Helper()
+	return startServer(t, testConfig(t))
+}
+
+func testConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		APIServer: config.APIServer{
+			Bind: "127.0.0.1",
+			Port: 0,
+			JWTAuth: config.JWTAuth{
+				Secret:     "client-test-secret",
+				TimeToLive: "1h",
+			},
+		},
+		Database: config.Database{
+			DbBackend: config.SQLiteBackend,
+			SQLite:    config.SQLite{DBFile: filepath.Join(t.TempDir(), "test.db")},
+		},
+	}
+}
+
+func startServer(t *testing.T, cfg *config.Config) (*client.Client, string) {
+	t.Helper()
+	srv, err := apiserver.GetAPIServer(cfg)
+	if err != nil {
+		t.Fatalf("GetAPIServer: %v", err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	scheme := "http"
+	if cfg.APIServer.UseTLS {
+		scheme = "https"
+	}
+	baseURL := fmt.Sprintf("%s://%s/", scheme, srv.Addr())
+	cli, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return cli, baseURL
+}
+
+// newAdminFixture initializes the server and returns a client logged in
+// as the superuser.
+func newAdminFixture(t *testing.T) (*client.Client, string, context.Context) {
+	t.Helper()
+	cli, baseURL := newServerFixture(t)
+	ctx := context.Background()
+	_, err := cli.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	})
+	if err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	if _, err := cli.Login(ctx, "admin", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return cli, baseURL, ctx
+}
+
+func TestNewClientInvalidURL(t *testing.T) {
+	if _, err := client.NewClient("ftp://example.com"); err == nil {
+		t.Fatal("expected error for non HTTP URL")
+	}
+}
+
+func TestInitRequired(t *testing.T) {
+	cli, _ := newServerFixture(t)
+	_, err := cli.Login(context.Background(), "admin", testPassword)
+	if err != client.ErrInitRequired {
+		t.Fatalf("want ErrInitRequired, got %v", err)
+	}
+}
+
+func TestLoginLogout(t *testing.T) {
+	cli, _, ctx := newAdminFixture(t)
+	if cli.Token() == "" {
+		t.Fatal("expected token to be set after login")
+	}
+	if err := cli.Logout(ctx); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if cli.Token() != "" {
+		t.Fatal("expected token to be cleared after logout")
+	}
+	if _, err := cli.ListPastes(ctx, 1, 10, nil); err == nil {
+		t.Fatal("expected error after logout")
+	} else if _, ok := err.(*gErrors.UnauthorizedError); !ok {
+		t.Fatalf("want UnauthorizedError, got %T: %v", err, err)
+	}
+}
+
+func TestLoginBadPassword(t *testing.T) {
+	cli, _, ctx := newAdminFixture(t)
+	cli.SetToken("")
+	_, err := cli.Login(ctx, "admin", "wrong")
+	if _, ok := err.(*gErrors.UnauthorizedError); !ok {
+		t.Fatalf("want UnauthorizedError, got %T: %v", err, err)
+	}
+}
+
+// ── Pastes ───────────────────────────────────────────────────────────────────
+
+func TestPasteLifecycle(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+
+	created, err := cli.CreatePaste(ctx, params.Paste{
+		Name:     "hello.go",
+		Language: "go",
+		Data:     []byte("package main"),
+		Tags:     []string{"Go", "example"},
+	})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+
+	got, err := cli.GetPaste(ctx, created.PasteID)
+	if err != nil {
+		t.Fatalf("GetPaste: %v", err)
+	}
+	if got.Name != "hello.go" || string(got.Data) != "package main" {
+		t.Fatalf("unexpected paste: %+v", got)
+	}
+
+	data, err := cli.DownloadPaste(ctx, created.PasteID)
+	if err != nil {
+		t.Fatalf("DownloadPaste: %v", err)
+	}
+	if string(data) != "package main" {
+		t.Fatalf("DownloadPaste: got %q", data)
+	}
+
+	list, err := cli.ListPastes(ctx, 1, 10, []string{"go"})
+	if err != nil {
+		t.Fatalf("ListPastes: %v", err)
+	}
+	if len(list.Pastes) != 1 {
+		t.Fatalf("ListPastes: want 1 paste, got %d", len(list.Pastes))
+	}
+	list, err = cli.ListPastes(ctx, 1, 10, []string{"missing"})
+	if err != nil {
+		t.Fatalf("ListPastes: %v", err)
+	}
+	if len(list.Pastes) != 0 {
+		t.Fatalf("ListPastes with unknown tag: want 0 pastes, got %d", len(list.Pastes))
+	}
+
+	tags, err := cli.ListTags(ctx)
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	if len(tags.Tags) != 2 {
+		t.Fatalf("ListTags: want 2 tags, got %+v", tags.Tags)
+	}
+
+	public := true
+	updated, err := cli.UpdatePaste(ctx, created.PasteID, params.UpdatePasteParams{Public: &public})
+	if err != nil {
+		t.Fatalf("UpdatePaste: %v", err)
+	}
+	if !updated.Public {
+		t.Fatal("expected paste to be public")
+	}
+
+	anon, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := anon.GetPublicPaste(ctx, created.PasteID); err != nil {
+		t.Fatalf("GetPublicPaste: %v", err)
+	}
+
+	if err := cli.DeletePaste(ctx, created.PasteID); err != nil {
+		t.Fatalf("DeletePaste: %v", err)
+	}
+	_, err = cli.GetPaste(ctx, created.PasteID)
+	if _, ok := err.(*gErrors.NotFoundError); !ok {
+		t.Fatalf("want NotFoundError, got %T: %v", err, err)
+	}
+}
+
+func TestUpdatePasteNothingToUpdate(t *testing.T) {
+	cli, _, ctx := newAdminFixture(t)
+	created, err := cli.CreatePaste(ctx, params.Paste{Name: "data.txt", Data: []byte("data")})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	_, err = cli.UpdatePaste(ctx, created.PasteID, params.UpdatePasteParams{})
+	if _, ok := err.(*gErrors.BadRequestError); !ok {
+		t.Fatalf("want BadRequestError, got %T: %v", err, err)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	cli, _, _ := newAdminFixture(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cli.ListPastes(ctx, 1, 10, nil); err == nil {
+		t.Fatal("expected error with cancelled context")
+	}
+}
+
+// ── Sharing and teams ────────────────────────────────────────────────────────
+
+func TestSharingAndTeams(t *testing.T) {
+	cli, _, ctx := newAdminFixture(t)
+
+	user, err := cli.CreateUser(ctx, params.NewUserParams{
+		Email:    "bob@example.com",
+		Username: "bob",
+		FullName: "Bob",
+		Password: testPassword,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	users, err := cli.ListUsers(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(users.Users) != 2 {
+		t.Fatalf("ListUsers: want 2 users, got %d", len(users.Users))
+	}
+
+	paste, err := cli.CreatePaste(ctx, params.Paste{Name: "shared.txt", Data: []byte("shared")})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, err := cli.SharePaste(ctx, paste.PasteID, "bob"); err != nil {
+		t.Fatalf("SharePaste: %v", err)
+	}
+	shares, err := cli.ListShares(ctx, paste.PasteID)
+	if err != nil {
+		t.Fatalf("ListShares: %v", err)
+	}
+	if len(shares.Users) != 1 {
+		t.Fatalf("ListShares: want 1 user, got %d", len(shares.Users))
+	}
+	if err := cli.UnsharePaste(ctx, paste.PasteID, "bob"); err != nil {
+		t.Fatalf("UnsharePaste: %v", err)
+	}
+
+	if _, err := cli.CreateTeam(ctx, "gophers"); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	if _, err := cli.CreateTeam(ctx, "gophers"); err == nil {
+		t.Fatal("expected error creating duplicate team")
+	}
+	if _, err := cli.AddTeamMember(ctx, "gophers", "bob@example.com"); err != nil {
+		t.Fatalf("AddTeamMember: %v", err)
+	}
+	members, err := cli.ListTeamMembers(ctx, "gophers")
+	if err != nil {
+		t.Fatalf("ListTeamMembers: %v", err)
+	}
+	if len(members) == 0 {
+		t.Fatal("ListTeamMembers: expected members")
+	}
+	if err := cli.RemoveTeamMember(ctx, "gophers", "bob"); err != nil {
+		t.Fatalf("RemoveTeamMember: %v", err)
+	}
+	teams, err := cli.ListTeams(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("ListTeams: %v", err)
+	}
+	if len(teams.Teams) != 1 {
+		t.Fatalf("ListTeams: want 1 team, got %d", len(teams.Teams))
+	}
+	if err := cli.DeleteTeam(ctx, "gophers"); err != nil {
+		t.Fatalf("DeleteTeam: %v", err)
+	}
+	if _, err := cli.GetTeam(ctx, "gophers"); err == nil {
+		t.Fatal("expected error fetching deleted team")
+	}
+
+	fullName := "Robert"
+	updated, err := cli.UpdateUser(ctx, user.ID, params.UpdateUserPayload{FullName: &fullName})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if updated.FullName != fullName {
+		t.Fatalf("UpdateUser: want %q, got %q", fullName, updated.FullName)
+	}
+	if _, err := cli.DeleteUser(ctx, user.ID, client.DeleteUserOptions{}); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+}
+
+// ── Error envelope ───────────────────────────────────────────────────────────
+
+func TestErrorCodes(t *testing.T) {
+	cli, _, ctx := newAdminFixture(t)
+
+	_, err := cli.GetPaste(ctx, "doesnotexist")
+	if got := gErrors.Code(err); got != gErrors.CodePasteNotFound {
+		t.Errorf("GetPaste: want code %q, got %q (%v)", gErrors.CodePasteNotFound, got, err)
+	}
+
+	_, err = cli.CreateUser(ctx, params.NewUserParams{
+		Email:    "weak@example.com",
+		Username: "weak",
+		FullName: "Weak",
+		Password: "password",
+	})
+	badReq, ok := err.(*gErrors.BadRequestError)
+	if !ok {
+		t.Fatalf("CreateUser: want BadRequestError, got %T: %v", err, err)
+	}
+	if got := gErrors.Code(err); got != gErrors.CodeValidationFailed {
+		t.Errorf("CreateUser: want code %q, got %q", gErrors.CodeValidationFailed, got)
+	}
+	fields := badReq.Fields()
+	if len(fields) != 1 || fields[0].Field != "password" || fields[0].Code != gErrors.CodePasswordTooWeak {
+		t.Errorf("CreateUser: unexpected fields %+v", fields)
+	}
+}
+
+// ── API tokens ───────────────────────────────────────────────────────────────
+
+func TestAPITokens(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+
+	user, err := cli.CreateUser(ctx, params.NewUserParams{
+		Email:    "ci@example.com",
+		Username: "ci",
+		FullName: "CI Bot",
+		Password: testPassword,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	userCli, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := userCli.Login(ctx, "ci", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	created, err := userCli.CreateAPIToken(ctx, params.NewAPITokenParams{Name: "pipeline"})
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if created.Token == "" {
+		t.Fatal("expected the token to be returned on creation")
+	}
+
+	tokenCli, err := client.NewClient(baseURL, client.WithToken(created.Token))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := tokenCli.CreatePaste(ctx, params.Paste{Name: "build.log", Data: []byte("ok")}); err != nil {
+		t.Fatalf("CreatePaste with API token: %v", err)
+	}
+	if err := tokenCli.Logout(ctx); gErrors.Code(err) != gErrors.CodeBadRequest {
+		t.Fatalf("Logout with API token: want %s, got %v", gErrors.CodeBadRequest, err)
+	}
+
+	tokens, err := cli.ListUserAPITokens(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("ListUserAPITokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != created.ID || tokens[0].LastUsedAt == nil {
+		t.Fatalf("unexpected tokens: %+v", tokens)
+	}
+	if _, err := userCli.ListUserAPITokens(ctx, user.ID); err == nil {
+		t.Fatal("expected regular users to be denied access to the admin endpoint")
+	}
+
+	if err := cli.RevokeUserAPIToken(ctx, user.ID, created.ID); err != nil {
+		t.Fatalf("RevokeUserAPIToken: %v", err)
+	}
+	if _, err := tokenCli.ListPastes(ctx, 1, 10, nil); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Fatalf("revoked token: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+
+	second, err := userCli.CreateAPIToken(ctx, params.NewAPITokenParams{Name: "second"})
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if err := userCli.RevokeAPIToken(ctx, second.ID); err != nil {
+		t.Fatalf("RevokeAPIToken: %v", err)
+	}
+	tokens, err = userCli.ListAPITokens(ctx)
+	if err != nil {
+		t.Fatalf("ListAPITokens: %v", err)
+	}
+	if len(tokens) != 0 {
+		t.Fatalf("want no tokens left, got %+v", tokens)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+
+	readOnly, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := readOnly.Login(ctx, "admin", testPassword, "paste:read", "tokens"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := readOnly.ListPastes(ctx, 1, 10, nil); err != nil {
+		t.Fatalf("ListPastes: %v", err)
+	}
+	_, err = readOnly.CreatePaste(ctx, params.Paste{Name: "nope.txt", Data: []byte("nope")})
+	if _, ok := err.(*gErrors.ForbiddenError); !ok {
+		t.Fatalf("want ForbiddenError, got %T: %v", err, err)
+	}
+	if gErrors.Code(err) != gErrors.CodeInsufficientScope || !strings.Contains(err.Error(), "paste:write") {
+		t.Fatalf("want %s naming paste:write, got %v", gErrors.CodeInsufficientScope, err)
+	}
+	if _, err := readOnly.ListUsers(ctx, 1, 10); gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Fatalf("ListUsers: want %s, got %v", gErrors.CodeInsufficientScope, err)
+	}
+
+	// The read-only session may not mint a token with more scopes.
+	_, err = readOnly.CreateAPIToken(ctx, params.NewAPITokenParams{Name: "escalate", Scopes: []string{"paste:write"}})
+	if gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Fatalf("CreateAPIToken: want %s, got %v", gErrors.CodeInsufficientScope, err)
+	}
+	token, err := readOnly.CreateAPIToken(ctx, params.NewAPITokenParams{Name: "inherit"})
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if len(token.Scopes) != 2 {
+		t.Fatalf("want the scopes of the session, got %v", token.Scopes)
+	}
+
+	if _, err := cli.Login(ctx, "admin", testPassword, "bogus"); gErrors.Code(err) != gErrors.CodeValidationFailed {
+		t.Fatalf("Login with unknown scope: want %s, got %v", gErrors.CodeValidationFailed, err)
+	}
+}
+
+// ── Sessions ─────────────────────────────────────────────────────────────────
+
+func TestSessions(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+
+	user, err := cli.CreateUser(ctx, params.NewUserParams{
+		Email:    "laptop@example.com",
+		Username: "laptop",
+		FullName: "Laptop User",
+		Password: testPassword,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	logins := make([]*client.Client, 3)
+	for idx := range logins {
+		logins[idx], err = client.NewClient(baseURL)
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		if _, err := logins[idx].Login(ctx, "laptop", testPassword); err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+	}
+
+	sessions, err := logins[0].ListSessions(ctx)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("want 3 sessions, got %+v", sessions)
+	}
+	var current, other string
+	for _, session := range sessions {
+		if session.IP != "127.0.0.1" || session.UserAgent == "" {
+			t.Errorf("unexpected session details: %+v", session)
+		}
+		if session.Current {
+			current = session.ID
+		} else {
+			other = session.ID
+		}
+	}
+	if current == "" {
+		t.Fatal("expected the session making the request to be marked as current")
+	}
+
+	if err := logins[0].RevokeSession(ctx, other); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if err := logins[0].RevokeSession(ctx, other); gErrors.Code(err) != gErrors.CodeSessionNotFound {
+		t.Fatalf("revoking twice: want %s, got %v", gErrors.CodeSessionNotFound, err)
+	}
+	sessions, err = cli.ListUserSessions(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("ListUserSessions: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("want 2 sessions left, got %+v", sessions)
+	}
+
+	// Log out everywhere.
+	if err := logins[0].RevokeAllSessions(ctx); err != nil {
+		t.Fatalf("RevokeAllSessions: %v", err)
+	}
+	for idx, login := range logins {
+		if _, err := login.ListPastes(ctx, 1, 10, nil); gErrors.Code(err) != gErrors.CodeInvalidToken {
+			t.Errorf("login %d: want %s, got %v", idx, gErrors.CodeInvalidToken, err)
+		}
+	}
+
+	// Admins can force a user to log out.
+	if _, err := logins[0].Login(ctx, "laptop", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if err := cli.RevokeUserSessions(ctx, user.ID); err != nil {
+		t.Fatalf("RevokeUserSessions: %v", err)
+	}
+	if _, err := logins[0].ListPastes(ctx, 1, 10, nil); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Fatalf("want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+	if err := cli.RevokeUserSessions(ctx, 999); gErrors.Code(err) != gErrors.CodeUserNotFound {
+		t.Fatalf("unknown user: want %s, got %v", gErrors.CodeUserNotFound, err)
+	}
+	// The admin session is untouched.
+	if _, err := cli.ListPastes(ctx, 1, 10, nil); err != nil {
+		t.Fatalf("ListPastes as admin: %v", err)
+	}
+}
+
+func TestSecurityStamp(t *testing.T) {
+	cli, _, ctx := newAdminFixture(t)
+	users, err := cli.ListUsers(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	admin := users.Users[0]
+
+	// Updating the user does not log them out.
+	name := "Renamed Admin"
+	if _, err := cli.UpdateUser(ctx, admin.ID, params.UpdateUserPayload{FullName: &name}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if _, err := cli.ListPastes(ctx, 1, 10, nil); err != nil {
+		t.Fatalf("ListPastes after rename: %v", err)
+	}
+
+	// Changing the password does.
+	password := "Another-Correct-Horse-Battery-Staple-2024!"
+	if _, err := cli.UpdateUser(ctx, admin.ID, params.UpdateUserPayload{Password: &password}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if _, err := cli.ListPastes(ctx, 1, 10, nil); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Fatalf("after password change: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+}
+
+// ── Login throttling ─────────────────────────────────────────────────────────
+
+func TestLoginLockout(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.APIServer.LoginThrottle = config.LoginThrottle{
+		FreeAttempts:     100,
+		LockoutThreshold: 2,
+		LockoutDuration:  "1h",
+	}
+	cli, baseURL := startServer(t, cfg)
+	ctx := context.Background()
+	if _, err := cli.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	if _, err := cli.Login(ctx, "admin", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	user, err := cli.CreateUser(ctx, params.NewUserParams{
+		Email:    "bob@example.com",
+		Username: "bob",
+		FullName: "Bob",
+		Password: testPassword,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	userCli, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	for idx := 0; idx < 2; idx++ {
+		if _, err := userCli.Login(ctx, "bob", "wrong"); gErrors.Code(err) != gErrors.CodeInvalidCredentials {
+			t.Fatalf("want %s, got %v", gErrors.CodeInvalidCredentials, err)
+		}
+	}
+	// Once locked, even the right password is refused.
+	_, err = userCli.Login(ctx, "bob", testPassword)
+	tooMany, ok := err.(*gErrors.TooManyRequestsError)
+	if !ok {
+		t.Fatalf("want TooManyRequestsError, got %T: %v", err, err)
+	}
+	if gErrors.Code(err) != gErrors.CodeAccountLocked {
+		t.Errorf("want code %s, got %s", gErrors.CodeAccountLocked, gErrors.Code(err))
+	}
+	if tooMany.RetryAfter() < 59*time.Minute || tooMany.RetryAfter() > time.Hour {
+		t.Errorf("want retry after about an hour, got %s", tooMany.RetryAfter())
+	}
+
+	if err := cli.UnlockUser(ctx, user.ID); err != nil {
+		t.Fatalf("UnlockUser: %v", err)
+	}
+	if _, err := userCli.Login(ctx, "bob", testPassword); err != nil {
+		t.Fatalf("Login after unlock: %v", err)
+	}
+}
+
+// ── Two-factor authentication ────────────────────────────────────────────────
+
+// totpCode returns the code for secret, offset periods from now. Codes
+// may only be used once, later codes are taken from the next period.
+func totpCode(t *testing.T, secret string, offset int) string {
+	t.Helper()
+	code, err := totp.Code(secret, time.Now().Add(time.Duration(offset)*totp.Period))
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+	return code
+}
+
+// enableTwoFactor enrolls an authenticator for the user cli is logged in
+// as, and returns the secret and recovery codes.
+func enableTwoFactor(t *testing.T, ctx context.Context, cli *client.Client) (string, []string) {
+	t.Helper()
+	enrollment, err := cli.EnrollTwoFactor(ctx)
+	if err != nil {
+		t.Fatalf("EnrollTwoFactor: %v", err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") || len(enrollment.QRCode) == 0 {
+		t.Fatalf("unexpected enrollment %+v", enrollment)
+	}
+	codes, err := cli.ConfirmTwoFactor(ctx, totpCode(t, enrollment.Secret, 0))
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactor: %v", err)
+	}
+	return enrollment.Secret, codes.Codes
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	admin, baseURL, ctx := newAdminFixture(t)
+	user, err := admin.CreateUser(ctx, params.NewUserParams{
+		Email:    "jane@example.com",
+		Username: "jane",
+		FullName: "Jane Doe",
+		Password: testPassword,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	cli, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := cli.Login(ctx, "jane", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	secret, recoveryCodes := enableTwoFactor(t, ctx, cli)
+
+	// The password alone no longer yields a session token.
+	login := func() params.JWTResponse {
+		t.Helper()
+		cli.SetToken("")
+		ret, err := cli.Login(ctx, "jane", testPassword)
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		if !ret.TwoFactorRequired || ret.PreAuthToken == "" || ret.Token != "" || cli.Token() != "" {
+			t.Fatalf("expected a two-factor challenge, got %+v", ret)
+		}
+		return ret
+	}
+	ret := login()
+	if _, err := cli.ListPastes(ctx, 1, 10, nil); !errors.Is(err, gErrors.ErrUnauthorized) {
+		t.Fatalf("ListPastes without a session: want unauthorized, got %v", err)
+	}
+	preAuth, err := client.NewClient(baseURL, client.WithToken(ret.PreAuthToken))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := preAuth.ListPastes(ctx, 1, 10, nil); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Fatalf("pre-auth token used as a session: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+
+	// A wrong code burns the pre-auth token.
+	if _, err := cli.LoginTwoFactor(ctx, ret.PreAuthToken, "000000"); gErrors.Code(err) != gErrors.CodeInvalidTwoFactorCode {
+		t.Fatalf("wrong code: want %s, got %v", gErrors.CodeInvalidTwoFactorCode, err)
+	}
+	if _, err := cli.LoginTwoFactor(ctx, ret.PreAuthToken, totpCode(t, secret, 1)); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Fatalf("reused pre-auth token: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+
+	ret = login()
+	if _, err := cli.LoginTwoFactor(ctx, ret.PreAuthToken, totpCode(t, secret, 1)); err != nil {
+		t.Fatalf("LoginTwoFactor: %v", err)
+	}
+	if _, err := cli.ListPastes(ctx, 1, 10, nil); err != nil {
+		t.Fatalf("ListPastes: %v", err)
+	}
+
+	// Recovery codes work once.
+	ret = login()
+	if _, err := cli.LoginTwoFactor(ctx, ret.PreAuthToken, recoveryCodes[0]); err != nil {
+		t.Fatalf("LoginTwoFactor with a recovery code: %v", err)
+	}
+	status, err := cli.TwoFactorStatus(ctx)
+	if err != nil {
+		t.Fatalf("TwoFactorStatus: %v", err)
+	}
+	if !status.Enabled || status.RecoveryCodesLeft != len(recoveryCodes)-1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	ret = login()
+	if _, err := cli.LoginTwoFactor(ctx, ret.PreAuthToken, recoveryCodes[0]); gErrors.Code(err) != gErrors.CodeInvalidTwoFactorCode {
+		t.Fatalf("used recovery code: want %s, got %v", gErrors.CodeInvalidTwoFactorCode, err)
+	}
+
+	// Scopes requested with the password carry over to the session.
+	cli.SetToken("")
+	ret, err = cli.Login(ctx, "jane", testPassword, "paste:read")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := cli.LoginTwoFactor(ctx, ret.PreAuthToken, recoveryCodes[1]); err != nil {
+		t.Fatalf("LoginTwoFactor: %v", err)
+	}
+	if _, err := cli.TwoFactorStatus(ctx); gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Fatalf("TwoFactorStatus: want %s, got %v", gErrors.CodeInsufficientScope, err)
+	}
+
+	// Admins may reset the two-factor authentication of users that lost
+	// their authenticator.
+	adminStatus, err := admin.UserTwoFactorStatus(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("UserTwoFactorStatus: %v", err)
+	}
+	if !adminStatus.Enabled {
+		t.Fatal("admin sees two-factor authentication disabled")
+	}
+	if err := admin.ResetUserTwoFactor(ctx, user.ID); err != nil {
+		t.Fatalf("ResetUserTwoFactor: %v", err)
+	}
+	cli.SetToken("")
+	ret, err = cli.Login(ctx, "jane", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if ret.TwoFactorRequired || ret.Token == "" {
+		t.Fatalf("expected a session token after reset, got %+v", ret)
+	}
+}
+
+func TestTwoFactorRequiredForAdmins(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.APIServer.TwoFactor.RequireForAdmins = true
+	cli, _ := startServer(t, cfg)
+	ctx := context.Background()
+	if _, err := cli.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+
+	ret, err := cli.Login(ctx, "admin", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !ret.TwoFactorEnrollmentRequired || ret.Token == "" {
+		t.Fatalf("expected an enrollment only session, got %+v", ret)
+	}
+	if _, err := cli.ListUsers(ctx, 1, 10); gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Fatalf("ListUsers: want %s, got %v", gErrors.CodeInsufficientScope, err)
+	}
+	secret, _ := enableTwoFactor(t, ctx, cli)
+
+	ret, err = cli.Login(ctx, "admin", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !ret.TwoFactorRequired {
+		t.Fatalf("expected a two-factor challenge, got %+v", ret)
+	}
+	ret, err = cli.LoginTwoFactor(ctx, ret.PreAuthToken, totpCode(t, secret, 1))
+	if err != nil {
+		t.Fatalf("LoginTwoFactor: %v", err)
+	}
+	if ret.TwoFactorEnrollmentRequired {
+		t.Fatal("enrollment still required after enabling two-factor authentication")
+	}
+	if _, err := cli.ListUsers(ctx, 1, 10); err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+}
+
+// ── Single sign-on ───────────────────────────────────────────────────────────
+
+// newOIDCFixture starts an initialized API server using cfg, that logs
+// users in through a fake identity provider.
+func newOIDCFixture(t *testing.T, cfg *config.Config) (*oidctest.Server, string) {
+	t.Helper()
+	idp := oidctest.NewServer("gopherbin", "idp-secret")
+	t.Cleanup(idp.Close)
+
+	// The redirect URL must be known before the server starts listening.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	cfg.APIServer.Port = port
+	cfg.APIServer.OIDC = config.OIDC{
+		Enable:       true,
+		IssuerURL:    idp.URL,
+		ClientID:     "gopherbin",
+		ClientSecret: "idp-secret",
+		RedirectURL:  fmt.Sprintf("http://127.0.0.1:%d/api/v1/auth/oidc/callback", port),
+		AdminGroups:  []string{"admins"},
+	}
+	cli, baseURL := startServer(t, cfg)
+	if _, err := cli.FirstRun(context.Background(), params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	return idp, baseURL
+}
+
+// ssoLogin follows the login flow the way a browser would, and returns
+// the final response. query is added to the login URL.
+func ssoLogin(t *testing.T, baseURL, query string) *http.Response {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar: %v", err)
+	}
+	browser := &http.Client{Jar: jar}
+	resp, err := browser.Get(baseURL + "api/v1/auth/oidc/login" + query)
+	if err != nil {
+		t.Fatalf("oidc login: %v", err)
+	}
+	return resp
+}
+
+// janeAdmin is an admin at the fake identity provider
+var janeAdmin = map[string]interface{}{
+	"sub":                "jane-id",
+	"preferred_username": "jane",
+	"email":              "jane@example.com",
+	"email_verified":     true,
+	"name":               "Jane Doe",
+	"groups":             []string{"admins"},
+}
+
+// ssoToken follows the login flow and returns the login response.
+func ssoToken(t *testing.T, baseURL string) params.JWTResponse {
+	t.Helper()
+	resp := ssoLogin(t, baseURL, "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %s", resp.Status)
+	}
+	var token params.JWTResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		t.Fatalf("decoding token: %v", err)
+	}
+	return token
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp, baseURL := newOIDCFixture(t, testConfig(t))
+	idp.SetUser(janeAdmin)
+	token := ssoToken(t, baseURL)
+
+	cli, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	cli.SetToken(token.Token)
+	ctx := context.Background()
+	users, err := cli.ListUsers(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("ListUsers as SSO admin: %v", err)
+	}
+	var jane *params.Users
+	for idx := range users.Users {
+		if users.Users[idx].Username == "jane" {
+			jane = &users.Users[idx]
+		}
+	}
+	if jane == nil {
+		t.Fatalf("SSO user was not provisioned: %+v", users.Users)
+	}
+	if jane.Email != "jane@example.com" || jane.FullName != "Jane Doe" || !jane.IsAdmin || jane.AuthProvider != "oidc" {
+		t.Errorf("unexpected user: %+v", jane)
+	}
+	if _, err := cli.ListPastes(ctx, 1, 10, nil); err != nil {
+		t.Fatalf("ListPastes: %v", err)
+	}
+}
+
+func TestOIDCLogin_TwoFactor(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.APIServer.TwoFactor.RequireForAdmins = true
+	idp, baseURL := newOIDCFixture(t, cfg)
+	idp.SetUser(janeAdmin)
+	ctx := context.Background()
+
+	// Logging in with the identity provider does not skip enrollment.
+	token := ssoToken(t, baseURL)
+	if !token.TwoFactorEnrollmentRequired || token.Token == "" {
+		t.Fatalf("expected an enrollment only session, got %+v", token)
+	}
+	cli, err := client.NewClient(baseURL, client.WithToken(token.Token))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := cli.ListUsers(ctx, 1, 10); gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Fatalf("ListUsers: want %s, got %v", gErrors.CodeInsufficientScope, err)
+	}
+	secret, _ := enableTwoFactor(t, ctx, cli)
+
+	// Nor the code, once enrolled.
+	token = ssoToken(t, baseURL)
+	if !token.TwoFactorRequired || token.PreAuthToken == "" || token.Token != "" {
+		t.Fatalf("expected a two-factor challenge, got %+v", token)
+	}
+	cli.SetToken("")
+	if _, err := cli.LoginTwoFactor(ctx, token.PreAuthToken, totpCode(t, secret, 1)); err != nil {
+		t.Fatalf("LoginTwoFactor: %v", err)
+	}
+	if _, err := cli.ListUsers(ctx, 1, 10); err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+}
+
+func TestOIDCLogin_CookieSession(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.APIServer.SessionCookie = config.SessionCookie{Enable: true}
+	if err := cfg.APIServer.SessionCookie.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	idp, baseURL := newOIDCFixture(t, cfg)
+	idp.SetUser(janeAdmin)
+
+	resp := ssoLogin(t, baseURL, "?cookie=true")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %s", resp.Status)
+	}
+	var login params.JWTResponse
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		t.Fatalf("decoding login response: %v", err)
+	}
+	if login.Token != "" || login.CSRFToken == "" || login.User == nil || login.User.Username != "jane" {
+		t.Fatalf("expected a cookie session, got %+v", login)
+	}
+	var session *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == auth.SessionCookieName {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie set")
+	}
+	me := sessionRequest(t, http.MethodGet, baseURL+"api/v1/me", session, "")
+	me.Body.Close()
+	if me.StatusCode != http.StatusOK {
+		t.Fatalf("GET /me with the session cookie: want 200, got %s", me.Status)
+	}
+}
+
+func TestOIDCLogin_Denied(t *testing.T) {
+	_, baseURL := newOIDCFixture(t, testConfig(t))
+
+	// No user is logged in at the identity provider.
+	resp := ssoLogin(t, baseURL, "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("want 401, got %s", resp.Status)
+	}
+	var apiErr responses.APIErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("decoding error: %v", err)
+	}
+	if apiErr.Code != gErrors.CodeSSOFailed {
+		t.Errorf("want code %q, got %q", gErrors.CodeSSOFailed, apiErr.Code)
+	}
+}
+
+func TestOIDCLogin_MissingState(t *testing.T) {
+	_, baseURL := newOIDCFixture(t, testConfig(t))
+
+	// A callback without the state cookie set by the login endpoint is
+	// rejected, which protects against login CSRF.
+	resp, err := http.Get(baseURL + "api/v1/auth/oidc/callback?code=stolen&state=forged")
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("want 401, got %s", resp.Status)
+	}
+}
+
+func TestOIDCLogin_Disabled(t *testing.T) {
+	_, baseURL, _ := newAdminFixture(t)
+	resp, err := http.Get(baseURL + "api/v1/auth/oidc/login")
+	if err != nil {
+		t.Fatalf("oidc login: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("want 404, got %s", resp.Status)
+	}
+}
+
+// ── LDAP ─────────────────────────────────────────────────────────────────────
+
+func TestLDAPLogin(t *testing.T) {
+	directory := ldaptest.NewServer()
+	defer directory.Close()
+	directory.AddEntry(ldaptest.Entry{
+		DN:       "uid=grace,ou=people,dc=example,dc=com",
+		Password: "grace-secret",
+		Attributes: map[string][]string{
+			"uid":  {"grace"},
+			"mail": {"grace@example.com"},
+			"cn":   {"Grace Hopper"},
+		},
+	})
+
+	cfg := testConfig(t)
+	cfg.APIServer.LDAP = config.LDAP{
+		Enable:         true,
+		URL:            directory.URL,
+		UserDNTemplate: "uid=%s,ou=people,dc=example,dc=com",
+	}
+	cli, _ := startServer(t, cfg)
+	ctx := context.Background()
+	if _, err := cli.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+
+	if _, err := cli.Login(ctx, "grace", "wrong"); !errors.Is(err, gErrors.ErrUnauthorized) {
+		t.Fatalf("want UnauthorizedError, got %v", err)
+	}
+	if _, err := cli.Login(ctx, "grace", "grace-secret"); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := cli.CreatePaste(ctx, params.Paste{Name: "ldap.txt", Data: []byte("hello")}); err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+
+	// Local users keep logging in with their gopherbin password.
+	if _, err := cli.Login(ctx, "admin", testPassword); err != nil {
+		t.Fatalf("Login as admin: %v", err)
+	}
+}
+
+// ── Password reset ───────────────────────────────────────────────────────────
+
+// resetTokenFrom returns the token in the password reset link in body
+func resetTokenFrom(t *testing.T, body string) string {
+	t.Helper()
+	return linkTokenFrom(t, body, "https://paste.example.com/reset-password?token=")
+}
+
+// linkTokenFrom returns the token in the link starting with prefix in body
+func linkTokenFrom(t *testing.T, body, prefix string) string {
+	t.Helper()
+	start := strings.Index(body, prefix)
+	if start == -1 {
+		t.Fatalf("no link to %s in %q", prefix, body)
+	}
+	link := strings.Fields(body[start:])[0]
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parsing link: %v", err)
+	}
+	return parsed.Query().Get("token")
+}
+
+func TestPasswordReset(t *testing.T) {
+	sink, err := mailtest.NewSink()
+	if err != nil {
+		t.Fatalf("NewSink: %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	cfg := testConfig(t)
+	cfg.Mail = config.Mail{
+		Enable:  true,
+		Host:    sink.Host(),
+		Port:    sink.Port(),
+		From:    "gopherbin@example.com",
+		TLS:     config.MailTLSNone,
+		BaseURL: "https://paste.example.com/",
+	}
+	cli, baseURL := startServer(t, cfg)
+	ctx := context.Background()
+	if _, err := cli.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	if _, err := cli.Login(ctx, "admin", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := cli.CreateUser(ctx, params.NewUserParams{
+		Email:    "bob@example.com",
+		Username: "bob",
+		FullName: "Bob",
+		Password: testPassword,
+		Enabled:  true,
+	}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	userCli, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := userCli.Login(ctx, "bob", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	anon, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	// Unknown addresses get the same response, and no email.
+	if err := anon.ForgotPassword(ctx, "nobody@example.com"); err != nil {
+		t.Fatalf("ForgotPassword for unknown address: %v", err)
+	}
+	if err := anon.ForgotPassword(ctx, "bob@example.com"); err != nil {
+		t.Fatalf("ForgotPassword: %v", err)
+	}
+	msg, err := sink.Next(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.To) != 1 || msg.To[0] != "bob@example.com" {
+		t.Fatalf("unexpected recipients %v", msg.To)
+	}
+	token := resetTokenFrom(t, msg.Body)
+
+	// The new password must be strong enough. A rejected password does
+	// not use up the token.
+	err = anon.ResetPassword(ctx, token, "password")
+	if badReq, ok := err.(*gErrors.BadRequestError); !ok || len(badReq.Fields()) != 1 || badReq.Fields()[0].Code != gErrors.CodePasswordTooWeak {
+		t.Fatalf("weak password: want %s, got %v", gErrors.CodePasswordTooWeak, err)
+	}
+	newPassword := "Another-Correct-Horse-Battery-Staple-2024!"
+	if err := anon.ResetPassword(ctx, token, newPassword); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if err := anon.ResetPassword(ctx, token, newPassword+"?"); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Errorf("reused token: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+
+	// Existing sessions are revoked.
+	if _, err := userCli.ListPastes(ctx, 1, 10, nil); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Errorf("old session: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+	if _, err := userCli.Login(ctx, "bob", testPassword); gErrors.Code(err) != gErrors.CodeInvalidCredentials {
+		t.Errorf("old password: want %s, got %v", gErrors.CodeInvalidCredentials, err)
+	}
+	if _, err := userCli.Login(ctx, "bob", newPassword); err != nil {
+		t.Fatalf("Login with new password: %v", err)
+	}
+	if got := len(sink.Messages()); got != 1 {
+		t.Errorf("want 1 email, got %d", got)
+	}
+}
+
+func TestPasswordReset_Disabled(t *testing.T) {
+	cli, _, ctx := newAdminFixture(t)
+	err := cli.ForgotPassword(ctx, "admin@example.com")
+	if _, ok := err.(*gErrors.NotFoundError); !ok {
+		t.Fatalf("want NotFoundError, got %T: %v", err, err)
+	}
+}
+
+// ── Registration ─────────────────────────────────────────────────────────────
+
+func TestRegistration(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.APIServer.Registration.Mode = config.RegistrationOpen
+	cli, baseURL := startServer(t, cfg)
+	ctx := context.Background()
+	if _, err := cli.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	if _, err := cli.Login(ctx, "admin", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := cli.CreateTeam(ctx, "devs"); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	invite, err := cli.CreateInvite(ctx, params.NewInviteParams{Team: "devs", Note: "for bob"})
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	if invite.Code == "" || invite.MaxUses != 1 || invite.Team != "devs" {
+		t.Fatalf("unexpected invite %+v", invite)
+	}
+
+	anon, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	register := func(username, code string) (params.Users, error) {
+		return anon.Register(ctx, params.RegisterParams{
+			Email:      username + "@example.com",
+			Username:   username,
+			FullName:   username,
+			Password:   testPassword,
+			InviteCode: code,
+		})
+	}
+
+	// Users with an invite can log in right away, and join the team of
+	// the invite.
+	bob, err := register("bob", invite.Code)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if !bob.Enabled || bob.PendingApproval {
+		t.Fatalf("unexpected user %+v", bob)
+	}
+	if _, err := anon.Login(ctx, "bob", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := anon.GetTeam(ctx, "devs"); err != nil {
+		t.Errorf("GetTeam as invited member: %v", err)
+	}
+	anon.SetToken("")
+	if _, err := register("carol", invite.Code); gErrors.Code(err) != gErrors.CodeValidationFailed {
+		t.Errorf("used up invite: want %s, got %v", gErrors.CodeValidationFailed, err)
+	}
+
+	// Everyone else waits for approval.
+	dave, err := register("dave", "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if dave.Enabled || !dave.PendingApproval {
+		t.Fatalf("want pending user, got %+v", dave)
+	}
+	if _, err := anon.Login(ctx, "dave", testPassword); gErrors.Code(err) != gErrors.CodeApprovalPending {
+		t.Fatalf("want %s, got %v", gErrors.CodeApprovalPending, err)
+	}
+	pending, err := cli.ListPendingUsers(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("ListPendingUsers: %v", err)
+	}
+	if len(pending.Users) != 1 || pending.Users[0].ID != dave.ID {
+		t.Fatalf("unexpected pending users %+v", pending.Users)
+	}
+	if _, err := cli.ApproveUser(ctx, dave.ID); err != nil {
+		t.Fatalf("ApproveUser: %v", err)
+	}
+	if _, err := anon.Login(ctx, "dave", testPassword); err != nil {
+		t.Fatalf("Login after approval: %v", err)
+	}
+	anon.SetToken("")
+
+	erin, err := register("erin", "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := cli.RejectUser(ctx, erin.ID); err != nil {
+		t.Fatalf("RejectUser: %v", err)
+	}
+	if err := cli.RejectUser(ctx, dave.ID); gErrors.Code(err) != gErrors.CodeNotPending {
+		t.Errorf("rejecting an approved user: want %s, got %v", gErrors.CodeNotPending, err)
+	}
+
+	invites, err := cli.ListInvites(ctx)
+	if err != nil {
+		t.Fatalf("ListInvites: %v", err)
+	}
+	if len(invites) != 1 || invites[0].Uses != 1 || invites[0].Code != "" {
+		t.Fatalf("unexpected invites %+v", invites)
+	}
+	if err := cli.DeleteInvite(ctx, invite.ID); err != nil {
+		t.Fatalf("DeleteInvite: %v", err)
+	}
+}
+
+func TestRegistration_Throttled(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.APIServer.Registration.Mode = config.RegistrationOpen
+	cfg.APIServer.LoginThrottle = config.LoginThrottle{
+		IPLockoutThreshold: 2,
+		LockoutDuration:    "1h",
+	}
+	cli, _ := startServer(t, cfg)
+	ctx := context.Background()
+	if _, err := cli.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+
+	register := func(username, email string) error {
+		_, err := cli.Register(ctx, params.RegisterParams{
+			Email:    email,
+			Username: username,
+			FullName: username,
+			Password: testPassword,
+		})
+		return err
+	}
+	// A taken username and a taken email address can not be told apart.
+	if err := register("admin", "bob@example.com"); gErrors.Code(err) != gErrors.CodeAccountExists {
+		t.Fatalf("username taken: want %s, got %v", gErrors.CodeAccountExists, err)
+	}
+	if err := register("bob", "admin@example.com"); gErrors.Code(err) != gErrors.CodeAccountExists {
+		t.Fatalf("email taken: want %s, got %v", gErrors.CodeAccountExists, err)
+	}
+	// Probing further locks out the address, even for new accounts.
+	err := register("carol", "carol@example.com")
+	if _, ok := err.(*gErrors.TooManyRequestsError); !ok || gErrors.Code(err) != gErrors.CodeLoginThrottled {
+		t.Fatalf("want TooManyRequestsError with code %s, got %T: %v", gErrors.CodeLoginThrottled, err, err)
+	}
+}
+
+func TestRegistration_Disabled(t *testing.T) {
+	cli, _, ctx := newAdminFixture(t)
+	_, err := cli.Register(ctx, params.RegisterParams{
+		Email:    "bob@example.com",
+		Username: "bob",
+		FullName: "Bob",
+		Password: testPassword,
+	})
+	if gErrors.Code(err) != gErrors.CodeRegistrationDisabled {
+		t.Fatalf("want %s, got %v", gErrors.CodeRegistrationDisabled, err)
+	}
+}
+
+// ── Signing keys ─────────────────────────────────────────────────────────────
+
+func fetchJWKS(t *testing.T, baseURL string) params.JSONWebKeySet {
+	t.Helper()
+	resp, err := http.Get(baseURL + ".well-known/jwks.json")
+	if err != nil {
+		t.Fatalf("fetching JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+	var set params.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatalf("decoding JWKS: %v", err)
+	}
+	return set
+}
+
+func TestJWKSVerifiesLoginTokens(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.APIServer.JWTAuth.Algorithm = config.JWTAlgorithmEdDSA
+	cli, baseURL := startServer(t, cfg)
+	ctx := context.Background()
+	if _, err := cli.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	login, err := cli.Login(ctx, "admin", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := cli.ListSessions(ctx); err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+
+	set := fetchJWKS(t, baseURL)
+	if len(set.Keys) != 1 {
+		t.Fatalf("want 1 published key, got %+v", set.Keys)
+	}
+	jwk := set.Keys[0]
+	if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Alg != "EdDSA" {
+		t.Fatalf("unexpected key %+v", jwk)
+	}
+	public, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		t.Fatalf("decoding key: %v", err)
+	}
+
+	// Another service verifies the token using the published key alone.
+	token, err := jwt.Parse(login.Token, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != jwk.Kid {
+			return nil, fmt.Errorf("unexpected kid %v", token.Header["kid"])
+		}
+		return ed25519.PublicKey(public), nil
+	}, jwt.WithValidMethods([]string{"EdDSA"}))
+	if err != nil || !token.Valid {
+		t.Fatalf("verifying token: %v", err)
+	}
+}
+
+func TestJWKSOmitsHMACKeys(t *testing.T) {
+	_, baseURL := newServerFixture(t)
+	if set := fetchJWKS(t, baseURL); len(set.Keys) != 0 {
+		t.Errorf("want no published keys, got %+v", set.Keys)
+	}
+}
+
+// ── Proxy auth ───────────────────────────────────────────────────────────────
+
+// newProxyFixture starts an initialized server trusting the identity
+// headers of requests coming from the networks in trusted.
+func newProxyFixture(t *testing.T, autoProvision bool, trusted ...string) (*client.Client, string) {
+	t.Helper()
+	cfg := testConfig(t)
+	cfg.APIServer.ProxyAuth = config.ProxyAuth{
+		Enable:         true,
+		TrustedProxies: trusted,
+		AutoProvision:  autoProvision,
+	}
+	if err := cfg.APIServer.ProxyAuth.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	cli, baseURL := startServer(t, cfg)
+	if _, err := cli.FirstRun(context.Background(), params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	return cli, baseURL
+}
+
+// proxyRequest makes a request the way an authenticating proxy would.
+func proxyRequest(t *testing.T, method, target, user, email string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("X-Forwarded-User", user)
+	req.Header.Set("X-Forwarded-Email", email)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestProxyAuthProvisionsUsers(t *testing.T) {
+	cli, baseURL := newProxyFixture(t, true, "127.0.0.0/8", "::1/128")
+
+	resp := proxyRequest(t, http.MethodGet, baseURL+"api/v1/tags", "alice", "alice@example.com")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+
+	// The web UI exchanges the proxy identity for a token.
+	resp = proxyRequest(t, http.MethodPost, baseURL+"api/v1/auth/proxy", "alice", "alice@example.com")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+	var login params.JWTResponse
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		t.Fatalf("decoding token: %v", err)
+	}
+	cli.SetToken(login.Token)
+	sessions, err := cli.ListSessions(context.Background())
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Errorf("want 1 session, got %d", len(sessions))
+	}
+
+	// The proxy login endpoint is not a way around logging in.
+	resp, err = http.Post(baseURL+"api/v1/auth/proxy", "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without proxy headers: want 401, got %d", resp.StatusCode)
+	}
+}
+
+func TestProxyAuthLinksExistingUsers(t *testing.T) {
+	cli, baseURL := newProxyFixture(t, false, "127.0.0.0/8", "::1/128")
+
+	resp := proxyRequest(t, http.MethodGet, baseURL+"api/v1/tags", "mallory", "mallory@example.com")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unknown user: want 401, got %d", resp.StatusCode)
+	}
+
+	resp = proxyRequest(t, http.MethodPost, baseURL+"api/v1/auth/proxy", "admin-sso", "admin@example.com")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("existing user: want 200, got %d", resp.StatusCode)
+	}
+	var login params.JWTResponse
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		t.Fatalf("decoding token: %v", err)
+	}
+	cli.SetToken(login.Token)
+	// Only admins may list users.
+	if _, err := cli.ListUsers(context.Background(), 1, 10); err != nil {
+		t.Errorf("ListUsers: %v", err)
+	}
+}
+
+func TestProxyAuthTwoFactor(t *testing.T) {
+	cli, baseURL := newProxyFixture(t, false, "127.0.0.0/8", "::1/128")
+	ctx := context.Background()
+	if _, err := cli.Login(ctx, "admin", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	secret, _ := enableTwoFactor(t, ctx, cli)
+
+	// The proxy headers alone do not prove the second factor.
+	resp := proxyRequest(t, http.MethodGet, baseURL+"api/v1/tags", "admin-sso", "admin@example.com")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("want 403, got %d", resp.StatusCode)
+	}
+	resp = proxyRequest(t, http.MethodPost, baseURL+"api/v1/auth/proxy", "admin-sso", "admin@example.com")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %d", resp.StatusCode)
+	}
+	var login params.JWTResponse
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		t.Fatalf("decoding token: %v", err)
+	}
+	if !login.TwoFactorRequired || login.PreAuthToken == "" || login.Token != "" {
+		t.Fatalf("expected a two-factor challenge, got %+v", login)
+	}
+	cli.SetToken("")
+	if _, err := cli.LoginTwoFactor(ctx, login.PreAuthToken, totpCode(t, secret, 1)); err != nil {
+		t.Fatalf("LoginTwoFactor: %v", err)
+	}
+	if _, err := cli.ListUsers(ctx, 1, 10); err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+}
+
+func TestProxyAuthIgnoresUntrustedAddresses(t *testing.T) {
+	_, baseURL := newProxyFixture(t, true, "10.0.0.0/8")
+	resp := proxyRequest(t, http.MethodGet, baseURL+"api/v1/tags", "alice", "alice@example.com")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("want 401, got %d", resp.StatusCode)
+	}
+}
+
+// ── Client certificates ──────────────────────────────────────────────────────
+
+type mtlsFixture struct {
+	ca      *certtest.CA
+	crl     string
+	baseURL string
+	// admin is logged in as the superuser, without a client certificate.
+	admin *client.Client
+}
+
+// newMTLSFixture starts an initialized server asking clients for a
+// certificate issued by a test CA.
+func newMTLSFixture(t *testing.T, mode config.ClientAuthMode, mapping ...config.ClientCertMapping) *mtlsFixture {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := certtest.NewCA("Gopherbin test CA")
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	caFile, err := ca.WriteCAFile(dir, "ca")
+	if err != nil {
+		t.Fatalf("WriteCAFile: %v", err)
+	}
+	server, err := ca.IssueServer("127.0.0.1")
+	if err != nil {
+		t.Fatalf("IssueServer: %v", err)
+	}
+	crt, key, err := server.WriteFiles(dir, "server")
+	if err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	f := &mtlsFixture{ca: ca, crl: filepath.Join(dir, "ca.crl")}
+	f.revoke(t)
+
+	cfg := testConfig(t)
+	cfg.APIServer.UseTLS = true
+	cfg.APIServer.TLSConfig = config.TLSConfig{
+		CRT:               crt,
+		Key:               key,
+		CACert:            caFile,
+		ClientAuth:        mode,
+		CRL:               f.crl,
+		ClientCertMapping: mapping,
+	}
+	if err := cfg.APIServer.TLSConfig.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	_, f.baseURL = startServer(t, cfg)
+
+	// The admin presents a certificate only when the server requires
+	// one. It maps to no user, and the token wins anyway.
+	var adminCert *certtest.Certificate
+	if mode == config.ClientAuthRequire {
+		adminCert = f.issue(t, "operator")
+	}
+	f.admin = f.client(t, adminCert)
+	ctx := context.Background()
+	if _, err := f.admin.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	if _, err := f.admin.Login(ctx, "admin", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return f
+}
+
+// revoke writes a CRL listing certs.
+func (f *mtlsFixture) revoke(t *testing.T, certs ...*certtest.Certificate) {
+	t.Helper()
+	crl, err := f.ca.CRL(certs...)
+	if err != nil {
+		t.Fatalf("CRL: %v", err)
+	}
+	if err := os.WriteFile(f.crl, crl, 0o600); err != nil {
+		t.Fatalf("write crl: %v", err)
+	}
+}
+
+// client returns a client trusting the test CA, presenting cert if set.
+// Every client gets its own connections.
+func (f *mtlsFixture) client(t *testing.T, cert *certtest.Certificate) *client.Client {
+	t.Helper()
+	tlsCfg := &tls.Config{RootCAs: x509.NewCertPool()}
+	tlsCfg.RootCAs.AddCert(f.ca.Cert)
+	if cert != nil {
+		tlsCfg.Certificates = []tls.Certificate{cert.TLSCertificate()}
+	}
+	transport := &http.Transport{TLSClientConfig: tlsCfg}
+	t.Cleanup(transport.CloseIdleConnections)
+	cli, err := client.NewClient(f.baseURL, client.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return cli
+}
+
+func isUnauthorized(err error) bool {
+	_, ok := err.(*gErrors.UnauthorizedError)
+	return ok
+}
+
+// isAPIError returns true if err was returned by the server, rather than
+// by a failed connection.
+func isAPIError(err error) bool {
+	return gErrors.Code(err) != ""
+}
+
+func (f *mtlsFixture) issue(t *testing.T, commonName string, emails ...string) *certtest.Certificate {
+	t.Helper()
+	cert, err := f.ca.IssueClient(commonName, emails...)
+	if err != nil {
+		t.Fatalf("IssueClient: %v", err)
+	}
+	return cert
+}
+
+func TestClientCertificateFingerprint(t *testing.T) {
+	f := newMTLSFixture(t, config.ClientAuthRequest)
+	ctx := context.Background()
+	cert := f.issue(t, "ci-runner")
+
+	// Unregistered certificates are rejected, not ignored.
+	if _, err := f.client(t, cert).ListTags(ctx); !isUnauthorized(err) {
+		t.Fatalf("unregistered certificate: want unauthorized, got %v", err)
+	}
+
+	newCert := params.NewClientCertificateParams{
+		Name:   "ci",
+		Scopes: []string{"paste:read", "paste:write"},
+	}
+	// Only the certificate presented on the connection is registered, so
+	// a certificate the caller does not hold can not be claimed.
+	_, err := f.admin.RegisterClientCertificate(ctx, newCert)
+	if badReq, ok := err.(*gErrors.BadRequestError); !ok || len(badReq.Fields()) != 1 || badReq.Fields()[0].Field != "certificate" {
+		t.Fatalf("register without presenting the certificate: want a certificate field error, got %T: %v", err, err)
+	}
+	holder := f.client(t, cert)
+	holder.SetToken(f.admin.Token())
+	registered, err := holder.RegisterClientCertificate(ctx, newCert)
+	if err != nil {
+		t.Fatalf("RegisterClientCertificate: %v", err)
+	}
+	if registered.Fingerprint != cert.Fingerprint() {
+		t.Errorf("want fingerprint %s, got %s", cert.Fingerprint(), registered.Fingerprint)
+	}
+
+	ci := f.client(t, cert)
+	paste, err := ci.CreatePaste(ctx, params.Paste{Name: "build.log", Data: []byte("ok")})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, err := f.admin.GetPaste(ctx, paste.PasteID); err != nil {
+		t.Errorf("paste not owned by the certificate owner: %v", err)
+	}
+	if _, err := ci.ListUsers(ctx, 1, 10); gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Errorf("ListUsers: want %s, got %v", gErrors.CodeInsufficientScope, err)
+	}
+
+	certs, err := f.admin.ListUserClientCertificates(ctx, registered.UserID)
+	if err != nil {
+		t.Fatalf("ListUserClientCertificates: %v", err)
+	}
+	if len(certs) != 1 || certs[0].LastUsedAt == nil {
+		t.Fatalf("want a used certificate, got %+v", certs)
+	}
+	if err := f.admin.DeleteClientCertificate(ctx, registered.ID); err != nil {
+		t.Fatalf("DeleteClientCertificate: %v", err)
+	}
+	if _, err := f.client(t, cert).ListTags(ctx); !isUnauthorized(err) {
+		t.Errorf("deleted certificate: want unauthorized, got %v", err)
+	}
+}
+
+func TestClientCertificateSubject(t *testing.T) {
+	f := newMTLSFixture(t, config.ClientAuthRequest, config.ClientCertMapCommonName, config.ClientCertMapEmail)
+	ctx := context.Background()
+
+	if _, err := f.client(t, f.issue(t, "admin")).ListTags(ctx); err != nil {
+		t.Errorf("by common name: %v", err)
+	}
+	if _, err := f.client(t, f.issue(t, "host-01", "admin@example.com")).ListTags(ctx); err != nil {
+		t.Errorf("by email: %v", err)
+	}
+	// A certificate issued for the name of an admin does not grant
+	// admin access, unless configured.
+	if _, err := f.client(t, f.issue(t, "admin")).ListUsers(ctx, 1, 10); gErrors.Code(err) != gErrors.CodeInsufficientScope {
+		t.Errorf("ListUsers by common name: want %s, got %v", gErrors.CodeInsufficientScope, err)
+	}
+	if _, err := f.client(t, f.issue(t, "host-02")).ListTags(ctx); !isUnauthorized(err) {
+		t.Errorf("unknown subject: want unauthorized, got %v", err)
+	}
+}
+
+func TestClientCertificateRevoked(t *testing.T) {
+	f := newMTLSFixture(t, config.ClientAuthRequest, config.ClientCertMapCommonName)
+	ctx := context.Background()
+	cert := f.issue(t, "admin")
+	if _, err := f.client(t, cert).ListTags(ctx); err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+
+	f.revoke(t, cert)
+	if _, err := f.client(t, cert).ListTags(ctx); err == nil || isAPIError(err) {
+		t.Fatalf("want the handshake with a revoked certificate to fail, got %v", err)
+	}
+	// Clients without a certificate are not affected.
+	if _, err := f.admin.ListTags(ctx); err != nil {
+		t.Errorf("ListTags without certificate: %v", err)
+	}
+}
+
+func TestClientCertificateRequired(t *testing.T) {
+	f := newMTLSFixture(t, config.ClientAuthRequire, config.ClientCertMapCommonName)
+	ctx := context.Background()
+	if _, err := f.client(t, nil).ListTags(ctx); err == nil || isAPIError(err) {
+		t.Fatalf("want the handshake without a certificate to fail, got %v", err)
+	}
+	if _, err := f.client(t, f.issue(t, "admin")).ListTags(ctx); err != nil {
+		t.Errorf("with certificate: %v", err)
+	}
+	if _, err := f.admin.ListTags(ctx); err != nil {
+		t.Errorf("with token: %v", err)
+	}
+}
+
+// ── Session cookies ──────────────────────────────────────────────────────────
+
+// newSessionCookieFixture starts an initialized server with session cookies
+// enabled.
+func newSessionCookieFixture(t *testing.T) string {
+	t.Helper()
+	cfg := testConfig(t)
+	cfg.APIServer.SessionCookie = config.SessionCookie{Enable: true}
+	if err := cfg.APIServer.SessionCookie.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	cli, baseURL := startServer(t, cfg)
+	if _, err := cli.FirstRun(context.Background(), params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	return baseURL
+}
+
+// cookieLogin logs in as the superuser asking for a cookie session.
+func cookieLogin(t *testing.T, baseURL string) (*http.Response, params.JWTResponse) {
+	t.Helper()
+	body := fmt.Sprintf(`{"username": "admin", "password": %q, "cookie": true}`, testPassword)
+	resp, err := http.Post(baseURL+"api/v1/auth/login", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST login: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login: want 200, got %d", resp.StatusCode)
+	}
+	var login params.JWTResponse
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		t.Fatalf("decoding login response: %v", err)
+	}
+	return resp, login
+}
+
+// sessionRequest makes a request the way a browser would. The cookies are
+// set by hand, as a cookie jar does not send Secure cookies over plain
+// HTTP.
+func sessionRequest(t *testing.T, method, target string, session *http.Cookie, csrfToken string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.AddCookie(session)
+	if csrfToken != "" {
+		req.Header.Set(auth.CSRFHeader, csrfToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestSessionCookieLogin(t *testing.T) {
+	baseURL := newSessionCookieFixture(t)
+	resp, login := cookieLogin(t, baseURL)
+	if login.Token != "" {
+		t.Error("the token must only be sent in the cookie")
+	}
+	if login.CSRFToken == "" || login.User == nil || login.User.Username != "admin" {
+		t.Fatalf("want a CSRF token and the user, got %+v", login)
+	}
+	session := findCookie(resp.Cookies(), auth.SessionCookieName)
+	if session == nil || !session.HttpOnly || !session.Secure || session.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("want an HttpOnly, Secure and SameSite session cookie, got %+v", session)
+	}
+	csrf := findCookie(resp.Cookies(), auth.CSRFCookieName)
+	if csrf == nil || csrf.Value != login.CSRFToken || csrf.HttpOnly {
+		t.Fatalf("want the CSRF token in a cookie readable by scripts, got %+v", csrf)
+	}
+
+	if resp := sessionRequest(t, http.MethodGet, baseURL+"api/v1/tags", session, ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET with session cookie: want 200, got %d", resp.StatusCode)
+	}
+	resp = sessionRequest(t, http.MethodPost, baseURL+"api/v1/logout", session, "")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("POST without CSRF token: want 403, got %d", resp.StatusCode)
+	}
+	if resp := sessionRequest(t, http.MethodGet, baseURL+"api/v1/logout", session, ""); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("logout with GET: want 400, got %d", resp.StatusCode)
+	}
+
+	resp = sessionRequest(t, http.MethodPost, baseURL+"api/v1/logout", session, login.CSRFToken)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("logout: want 200, got %d", resp.StatusCode)
+	}
+	if cleared := findCookie(resp.Cookies(), auth.SessionCookieName); cleared == nil || cleared.MaxAge >= 0 {
+		t.Errorf("want the session cookie cleared, got %+v", cleared)
+	}
+	if resp := sessionRequest(t, http.MethodGet, baseURL+"api/v1/tags", session, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("after logout: want 401, got %d", resp.StatusCode)
+	}
+}
+
+func TestSessionCookieDisabled(t *testing.T) {
+	_, baseURL, _ := newAdminFixture(t)
+	resp, login := cookieLogin(t, baseURL)
+	if login.Token == "" || login.CSRFToken != "" {
+		t.Errorf("want a bearer token when session cookies are disabled, got %+v", login)
+	}
+	if findCookie(resp.Cookies(), auth.SessionCookieName) != nil {
+		t.Error("want no session cookie when session cookies are disabled")
+	}
+}
+
+// ── User administration ──────────────────────────────────────────────────────
+
+func TestUserAdministration(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+
+	user, err := cli.CreateUser(ctx, params.NewUserParams{
+		Email:    "carol@example.com",
+		Username: "carol",
+		FullName: "Carol Danvers",
+		Password: testPassword,
+		Enabled:  true,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	carol, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := carol.Login(ctx, "carol", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := carol.CreatePaste(ctx, params.Paste{Name: "notes.txt", Data: []byte("12345")}); err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, err := carol.CreateTeam(ctx, "marvels"); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+
+	found, err := cli.SearchUsers(ctx, client.UserFilter{Query: "danvers"}, 1, 10)
+	if err != nil {
+		t.Fatalf("SearchUsers: %v", err)
+	}
+	if len(found.Users) != 1 || found.Users[0].ID != user.ID || found.Users[0].LastLoginAt == nil {
+		t.Fatalf("want carol, with a last login, got %+v", found.Users)
+	}
+	isAdmin := true
+	if admins, err := cli.SearchUsers(ctx, client.UserFilter{IsAdmin: &isAdmin}, 1, 10); err != nil || len(admins.Users) != 1 || admins.Users[0].Username != "admin" {
+		t.Errorf("want only the admin, got %+v (%v)", admins.Users, err)
+	}
+	if _, err := cli.SearchUsers(ctx, client.UserFilter{}, 1, 10); err != nil {
+		t.Errorf("SearchUsers without filters: %v", err)
+	}
+	resp, err := http.Get(baseURL + "api/v1/admin/users?enabled=maybe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated: want 401, got %d", resp.StatusCode)
+	}
+
+	details, err := cli.GetUserDetails(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("GetUserDetails: %v", err)
+	}
+	if details.User.Username != "carol" || details.PasteCount != 1 || details.StorageUsed != 5 {
+		t.Errorf("unexpected details: %+v", details)
+	}
+	if len(details.Teams) != 1 || details.Teams[0].Name != "marvels" || len(details.Sessions) != 1 {
+		t.Errorf("want one team and one session, got %+v", details)
+	}
+	if _, err := cli.GetUserDetails(ctx, user.ID+100); err == nil {
+		t.Error("expected error for a missing user")
+	}
+	if _, err := carol.GetUserDetails(ctx, user.ID); err == nil {
+		t.Error("expected error fetching details as a regular user")
+	}
+
+	disabled, err := cli.DisableUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("DisableUser: %v", err)
+	}
+	if disabled.Enabled {
+		t.Error("want the user disabled")
+	}
+	if _, err := carol.ListPastes(ctx, 1, 10, nil); err == nil {
+		t.Error("expected the disabled user to be logged out")
+	}
+	isEnabled := false
+	if res, err := cli.SearchUsers(ctx, client.UserFilter{Enabled: &isEnabled}, 1, 10); err != nil || len(res.Users) != 1 || res.Users[0].ID != user.ID {
+		t.Errorf("want only carol disabled, got %+v (%v)", res.Users, err)
+	}
+	if enabled, err := cli.EnableUser(ctx, user.ID); err != nil || !enabled.Enabled {
+		t.Errorf("EnableUser: want the user enabled, got %+v (%v)", enabled, err)
+	}
+	admin, err := cli.SearchUsers(ctx, client.UserFilter{Query: "admin@"}, 1, 10)
+	if err != nil || len(admin.Users) != 1 {
+		t.Fatalf("SearchUsers: %+v (%v)", admin.Users, err)
+	}
+	if _, err := cli.DisableUser(ctx, admin.Users[0].ID); err == nil {
+		t.Error("expected error disabling your own account")
+	}
+}
+
+func TestDeleteUserTransfer(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+
+	var clients []*client.Client
+	var users []params.Users
+	for _, username := range []string{"leaver", "heir"} {
+		user, err := cli.CreateUser(ctx, params.NewUserParams{
+			Email:    username + "@example.com",
+			Username: username,
+			FullName: username,
+			Password: testPassword,
+			Enabled:  true,
+		})
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		userCli, err := client.NewClient(baseURL)
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		if _, err := userCli.Login(ctx, username, testPassword); err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		clients = append(clients, userCli)
+		users = append(users, user)
+	}
+	leaver, heir := clients[0], clients[1]
+	runbook, err := leaver.CreatePaste(ctx, params.Paste{Name: "runbook.md", Data: []byte("restart it")})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if _, err := leaver.CreateTeam(ctx, "oncall"); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+
+	report, err := cli.DeleteUser(ctx, users[0].ID, client.DeleteUserOptions{TransferTo: "heir", DryRun: true})
+	if err != nil {
+		t.Fatalf("DeleteUser dry run: %v", err)
+	}
+	if !report.DryRun || report.PastesTransferred != 1 || report.TeamsTransferred != 1 || report.TransferTo == nil || report.TransferTo.ID != users[1].ID {
+		t.Errorf("unexpected dry run report: %+v", report)
+	}
+	if _, err := leaver.GetPaste(ctx, runbook.PasteID); err != nil {
+		t.Errorf("want the paste kept after a dry run, got %v", err)
+	}
+
+	if _, err := cli.DeleteUser(ctx, users[0].ID, client.DeleteUserOptions{TransferTo: "nobody"}); gErrors.Code(err) != gErrors.CodeValidationFailed {
+		t.Errorf("unknown transfer_to: want %s, got %v", gErrors.CodeValidationFailed, err)
+	}
+	report, err = cli.DeleteUser(ctx, users[0].ID, client.DeleteUserOptions{TransferTo: "heir@example.com"})
+	if err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if report.DryRun || report.PastesTransferred != 1 || report.PastesDeleted != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	// Users only list their own pastes.
+	if list, err := heir.ListPastes(ctx, 1, 10, nil); err != nil || len(list.Pastes) != 1 || list.Pastes[0].PasteID != runbook.PasteID {
+		t.Errorf("want the paste owned by heir, got %+v (%v)", list, err)
+	}
+	if team, err := heir.GetTeam(ctx, "oncall"); err != nil || team.Owner.Username != "heir" {
+		t.Errorf("want the team owned by heir, got %+v (%v)", team, err)
+	}
+}
+
+// ── Audit log ────────────────────────────────────────────────────────────────
+
+func TestAuditLog(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+
+	created, err := cli.CreatePaste(ctx, params.Paste{
+		Name:     "audited.txt",
+		Language: "text",
+		Data:     []byte("hello"),
+	})
+	if err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	if err := cli.DeletePaste(ctx, created.PasteID); err != nil {
+		t.Fatalf("DeletePaste: %v", err)
+	}
+	anon, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := anon.Login(ctx, "admin", "wrong"); err == nil {
+		t.Fatal("expected login with a bad password to fail")
+	}
+
+	all, err := cli.ListAuditEvents(ctx, client.AuditLogFilter{}, 1, 50)
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	seen := map[string]params.AuditEvent{}
+	for _, event := range all.Events {
+		if _, ok := seen[event.Action]; !ok {
+			seen[event.Action] = event
+		}
+	}
+	for _, action := range []string{"auth.login", "auth.login_failed", "paste.create", "paste.delete", "user.create"} {
+		if _, ok := seen[action]; !ok {
+			t.Errorf("missing %s event in %+v", action, all.Events)
+		}
+	}
+	if len(all.Events) > 0 && all.Events[0].Action != "auth.login_failed" {
+		t.Errorf("want the newest event first, got %s", all.Events[0].Action)
+	}
+	if failed := seen["auth.login_failed"]; failed.ActorID != 0 || failed.Details["login"] != "admin" || failed.IP == "" {
+		t.Errorf("unexpected failed login event: %+v", failed)
+	}
+	if deleted := seen["paste.delete"]; deleted.Actor != "admin" || deleted.TargetID != created.PasteID || deleted.RequestID == "" {
+		t.Errorf("unexpected paste delete event: %+v", deleted)
+	}
+
+	pasteEvents, err := cli.ListAuditEvents(ctx, client.AuditLogFilter{TargetType: "paste", TargetID: created.PasteID}, 1, 50)
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(pasteEvents.Events) != 2 {
+		t.Errorf("want the create and delete events of the paste, got %+v", pasteEvents.Events)
+	}
+	future := time.Now().Add(time.Hour)
+	if none, err := cli.ListAuditEvents(ctx, client.AuditLogFilter{Since: &future}, 1, 50); err != nil || len(none.Events) != 0 {
+		t.Errorf("want no events in the future, got %+v (%v)", none.Events, err)
+	}
+	var anonymous uint
+	// The failed login and the creation of the superuser on first run.
+	if anonEvents, err := cli.ListAuditEvents(ctx, client.AuditLogFilter{ActorID: &anonymous}, 1, 50); err != nil || len(anonEvents.Events) != 2 {
+		t.Errorf("want two anonymous events, got %+v (%v)", anonEvents.Events, err)
+	}
+
+	resp, err := http.Get(baseURL + "api/v1/admin/audit?since=yesterday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated: want 401, got %d", resp.StatusCode)
+	}
+	past := future.Add(-2 * time.Hour)
+	if _, err := cli.ListAuditEvents(ctx, client.AuditLogFilter{Since: &future, Until: &past}, 1, 50); err == nil {
+		t.Error("expected error when until is before since")
+	} else if _, ok := err.(*gErrors.BadRequestError); !ok {
+		t.Errorf("want BadRequestError, got %T: %v", err, err)
+	}
+
+	export, err := cli.ExportAuditLog(ctx, client.AuditLogFilter{Actions: []string{"paste.create", "paste.delete"}})
+	if err != nil {
+		t.Fatalf("ExportAuditLog: %v", err)
+	}
+	defer export.Close()
+	dec := json.NewDecoder(export)
+	var actions []string
+	for dec.More() {
+		var event params.AuditEvent
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("decoding export: %v", err)
+		}
+		actions = append(actions, event.Action)
+	}
+	if strings.Join(actions, ",") != "paste.create,paste.delete" {
+		t.Errorf("want the paste events oldest first, got %v", actions)
+	}
+}
+
+// ── Profile ──────────────────────────────────────────────────────────────────
+
+// newProfileFixture creates a user named bob, along with a team he is a
+// member of, and returns a client logged in as him.
+func newProfileFixture(t *testing.T, cli *client.Client, baseURL string) *client.Client {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := cli.CreateUser(ctx, params.NewUserParams{
+		Email:    "bob@example.com",
+		Username: "bob",
+		FullName: "Bob",
+		Password: testPassword,
+		Enabled:  true,
+	}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := cli.CreateTeam(ctx, "ops"); err != nil {
+		t.Fatalf("CreateTeam: %v", err)
+	}
+	if _, err := cli.AddTeamMember(ctx, "ops", "bob"); err != nil {
+		t.Fatalf("AddTeamMember: %v", err)
+	}
+	bob, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := bob.Login(ctx, "bob", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return bob
+}
+
+func TestProfile(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+	bob := newProfileFixture(t, cli, baseURL)
+	if _, err := bob.CreatePaste(ctx, params.Paste{Data: []byte("hello"), Name: "hello.txt"}); err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+
+	profile, err := bob.GetProfile(ctx)
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if profile.User.Username != "bob" || profile.PasteCount != 1 || profile.StorageUsed != 5 {
+		t.Errorf("unexpected profile %+v", profile)
+	}
+	if len(profile.Teams) != 1 || profile.Teams[0].Name != "ops" || profile.Teams[0].Owner.Username != "admin" {
+		t.Errorf("unexpected teams %+v", profile.Teams)
+	}
+
+	name, email := "Robert", "robert@example.com"
+	profile, err = bob.UpdateProfile(ctx, params.UpdateProfileParams{FullName: &name, Email: &email})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if profile.User.FullName != name || profile.User.Email != email || profile.PendingEmail != "" {
+		t.Errorf("unexpected profile after update %+v", profile.User)
+	}
+
+	taken := "admin@example.com"
+	if _, err := bob.UpdateProfile(ctx, params.UpdateProfileParams{Email: &taken}); gErrors.Code(err) != gErrors.CodeEmailInUse {
+		t.Errorf("email in use: want %s, got %v", gErrors.CodeEmailInUse, err)
+	}
+	invalid := "robert"
+	_, err = bob.UpdateProfile(ctx, params.UpdateProfileParams{Email: &invalid})
+	if badReq, ok := err.(*gErrors.BadRequestError); !ok || len(badReq.Fields()) != 1 || badReq.Fields()[0].Code != gErrors.CodeInvalidEmail {
+		t.Errorf("invalid email: want %s, got %v", gErrors.CodeInvalidEmail, err)
+	}
+}
+
+func TestProfile_ChangePassword(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+	bob := newProfileFixture(t, cli, baseURL)
+	other, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if _, err := other.Login(ctx, "bob", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	newPassword := "Another-Correct-Horse-Battery-Staple-2024!"
+	err = bob.ChangePassword(ctx, "wrong", newPassword)
+	if badReq, ok := err.(*gErrors.BadRequestError); !ok || gErrors.Code(err) != gErrors.CodeWrongPassword || len(badReq.Fields()) != 1 || badReq.Fields()[0].Field != "current_password" {
+		t.Fatalf("wrong current password: want %s, got %v", gErrors.CodeWrongPassword, err)
+	}
+	err = bob.ChangePassword(ctx, testPassword, "password")
+	if badReq, ok := err.(*gErrors.BadRequestError); !ok || len(badReq.Fields()) != 1 || badReq.Fields()[0].Code != gErrors.CodePasswordTooWeak {
+		t.Fatalf("weak password: want %s, got %v", gErrors.CodePasswordTooWeak, err)
+	}
+	oldToken := bob.Token()
+	if err := bob.ChangePassword(ctx, testPassword, newPassword); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	// The client gets a new session, other sessions are revoked.
+	if bob.Token() == oldToken {
+		t.Error("expected a new session token")
+	}
+	if _, err := bob.GetProfile(ctx); err != nil {
+		t.Errorf("GetProfile with new session: %v", err)
+	}
+	if _, err := other.GetProfile(ctx); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Errorf("other session: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+	sessions, err := bob.ListSessions(ctx)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("want only the current session, got %+v", sessions)
+	}
+	if _, err := other.Login(ctx, "bob", testPassword); gErrors.Code(err) != gErrors.CodeInvalidCredentials {
+		t.Errorf("old password: want %s, got %v", gErrors.CodeInvalidCredentials, err)
+	}
+	if _, err := other.Login(ctx, "bob", newPassword); err != nil {
+		t.Errorf("Login with new password: %v", err)
+	}
+}
+
+func TestProfile_AdminUpdateOfSelfRefused(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+	bob := newProfileFixture(t, cli, baseURL)
+	profile, err := bob.GetProfile(ctx)
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+
+	// Users must go through /me and /me/password, which ask for the
+	// current password and confirm new email addresses.
+	password := "Another-Correct-Horse-Battery-Staple-2024!"
+	email := "mallory@example.com"
+	for _, update := range []params.UpdateUserPayload{{Password: &password}, {Email: &email}} {
+		if _, err := bob.UpdateUser(ctx, profile.User.ID, update); err == nil {
+			t.Fatal("expected error")
+		} else if _, ok := err.(*gErrors.UnauthorizedError); !ok {
+			t.Fatalf("want UnauthorizedError, got %T: %v", err, err)
+		}
+	}
+
+	after, err := bob.GetProfile(ctx)
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if after.User.Email != "bob@example.com" {
+		t.Errorf("want the email address unchanged, got %s", after.User.Email)
+	}
+	if _, err := bob.Login(ctx, "bob", testPassword); err != nil {
+		t.Errorf("want the password unchanged, got %v", err)
+	}
+}
+
+func TestProfile_ChangePasswordCookieSession(t *testing.T) {
+	baseURL := newSessionCookieFixture(t)
+	resp, login := cookieLogin(t, baseURL)
+	session := findCookie(resp.Cookies(), auth.SessionCookieName)
+
+	body := fmt.Sprintf(`{"current_password": %q, "new_password": "Another-Correct-Horse-Battery-Staple-2024!"}`, testPassword)
+	req, err := http.NewRequest(http.MethodPost, baseURL+"api/v1/me/password", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.AddCookie(session)
+	req.Header.Set(auth.CSRFHeader, login.CSRFToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST password: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("change password: want 200, got %d", resp.StatusCode)
+	}
+	var changed params.JWTResponse
+	if err := json.NewDecoder(resp.Body).Decode(&changed); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	newSession := findCookie(resp.Cookies(), auth.SessionCookieName)
+	if changed.Token != "" || changed.CSRFToken == "" || newSession == nil {
+		t.Fatalf("want a new cookie session, got %+v", changed)
+	}
+
+	if resp := sessionRequest(t, http.MethodGet, baseURL+"api/v1/me", session, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("old session cookie: want 401, got %d", resp.StatusCode)
+	}
+	if resp := sessionRequest(t, http.MethodGet, baseURL+"api/v1/me", newSession, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("new session cookie: want 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestProfile_ConfirmEmailChange(t *testing.T) {
+	sink, err := mailtest.NewSink()
+	if err != nil {
+		t.Fatalf("NewSink: %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	cfg := testConfig(t)
+	cfg.Mail = config.Mail{
+		Enable:             true,
+		Host:               sink.Host(),
+		Port:               sink.Port(),
+		From:               "gopherbin@example.com",
+		TLS:                config.MailTLSNone,
+		BaseURL:            "https://paste.example.com/",
+		ConfirmEmailChange: true,
+	}
+	cli, baseURL := startServer(t, cfg)
+	ctx := context.Background()
+	if _, err := cli.FirstRun(ctx, params.NewUserParams{
+		Email:    "admin@example.com",
+		Username: "admin",
+		FullName: "Admin",
+		Password: testPassword,
+	}); err != nil {
+		t.Fatalf("FirstRun: %v", err)
+	}
+	if _, err := cli.Login(ctx, "admin", testPassword); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	bob := newProfileFixture(t, cli, baseURL)
+
+	// The name changes right away, the email address once confirmed.
+	name, email := "Robert", "robert@example.com"
+	profile, err := bob.UpdateProfile(ctx, params.UpdateProfileParams{FullName: &name, Email: &email})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if profile.User.FullName != name || profile.User.Email != "bob@example.com" || profile.PendingEmail != email {
+		t.Fatalf("unexpected profile after update %+v", profile)
+	}
+	msg, err := sink.Next(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.To) != 1 || msg.To[0] != email {
+		t.Fatalf("unexpected recipients %v", msg.To)
+	}
+	token := linkTokenFrom(t, msg.Body, "https://paste.example.com/confirm-email?token=")
+
+	// A second change makes the first link stale once confirmed.
+	other := "bobby@example.com"
+	if _, err := bob.UpdateProfile(ctx, params.UpdateProfileParams{Email: &other}); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	msg, err = sink.Next(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken := linkTokenFrom(t, msg.Body, "https://paste.example.com/confirm-email?token=")
+
+	anon, err := client.NewClient(baseURL)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := anon.ConfirmEmail(ctx, token); err != nil {
+		t.Fatalf("ConfirmEmail: %v", err)
+	}
+	if err := anon.ConfirmEmail(ctx, token); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Errorf("reused token: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+	if err := anon.ConfirmEmail(ctx, otherToken); gErrors.Code(err) != gErrors.CodeInvalidToken {
+		t.Errorf("stale token: want %s, got %v", gErrors.CodeInvalidToken, err)
+	}
+	profile, err = bob.GetProfile(ctx)
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if profile.User.Email != email {
+		t.Errorf("want email %s, got %s", email, profile.User.Email)
+	}
+
+	taken := "admin@example.com"
+	if _, err := bob.UpdateProfile(ctx, params.UpdateProfileParams{Email: &taken}); gErrors.Code(err) != gErrors.CodeEmailInUse {
+		t.Errorf("email in use: want %s, got %v", gErrors.CodeEmailInUse, err)
+	}
+}
+
+func TestProfile_ConfirmEmailDisabled(t *testing.T) {
+	cli, _, ctx := newAdminFixture(t)
+	if err := cli.ConfirmEmail(ctx, "token"); err == nil {
+		t.Fatal("expected error")
+	} else if _, ok := err.(*gErrors.NotFoundError); !ok {
+		t.Fatalf("want NotFoundError, got %T: %v", err, err)
+	}
+}
+
+func TestProfile_ExportData(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+	bob := newProfileFixture(t, cli, baseURL)
+	pastes := []params.Paste{
+		{Data: []byte("hello"), Name: "hello.txt", Language: "text"},
+		{Data: []byte("package main"), Name: "main", Language: "go"},
+		{Data: []byte("first"), Name: "notes", Language: "text"},
+		{Data: []byte("second"), Name: "notes", Language: "text"},
+	}
+	for idx, pst := range pastes {
+		created, err := bob.CreatePaste(ctx, pst)
+		if err != nil {
+			t.Fatalf("CreatePaste: %v", err)
+		}
+		pastes[idx] = created
+	}
+	if _, err := bob.SharePaste(ctx, pastes[0].PasteID, "admin"); err != nil {
+		t.Fatalf("SharePaste: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, baseURL+"api/v1/me/export", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+bob.Token())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET export: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("want a zip archive, got %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	resp.Body.Close()
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != `attachment; filename=gopherbin-export-bob.zip` {
+		t.Errorf("unexpected Content-Disposition %q", disposition)
+	}
+
+	export, err := bob.ExportData(ctx)
+	if err != nil {
+		t.Fatalf("ExportData: %v", err)
+	}
+	files, manifest := readDataExport(t, export)
+
+	wantFiles := map[string]string{
+		"pastes/hello.txt": "hello",
+		"pastes/main.go":   "package main",
+		"pastes/notes.txt": "first",
+		"pastes/notes-" + pastes[3].PasteID + ".txt": "second",
+	}
+	if len(files) != len(wantFiles) {
+		t.Errorf("want %d paste files, got %v", len(wantFiles), files)
+	}
+	for name, data := range wantFiles {
+		if files[name] != data {
+			t.Errorf("%s: want %q, got %q", name, data, files[name])
+		}
+	}
+	if manifest.User.Username != "bob" || len(manifest.Teams) != 1 || manifest.Teams[0].Name != "ops" {
+		t.Errorf("unexpected manifest user or teams: %+v %+v", manifest.User, manifest.Teams)
+	}
+	if len(manifest.Pastes) != len(pastes) {
+		t.Fatalf("want %d pastes in the manifest, got %d", len(pastes), len(manifest.Pastes))
+	}
+	for idx, exported := range manifest.Pastes {
+		if exported.Paste.PasteID != pastes[idx].PasteID || exported.Paste.Data != nil {
+			t.Errorf("unexpected paste %d in the manifest: %+v", idx, exported.Paste)
+		}
+		if _, ok := wantFiles[exported.File]; !ok {
+			t.Errorf("paste %d points to unexpected file %q", idx, exported.File)
+		}
+	}
+	if shared := manifest.Pastes[0].SharedWith; len(shared) != 1 || shared[0].Username != "admin" {
+		t.Errorf("want %s shared with admin, got %+v", pastes[0].PasteID, shared)
+	}
+	if shared := manifest.Pastes[1].SharedWith; len(shared) != 0 {
+		t.Errorf("want %s not shared, got %+v", pastes[1].PasteID, shared)
+	}
+}
+
+func TestProfile_ExportUserData(t *testing.T) {
+	cli, baseURL, ctx := newAdminFixture(t)
+	bob := newProfileFixture(t, cli, baseURL)
+	if _, err := bob.CreatePaste(ctx, params.Paste{Data: []byte("hello"), Name: "hello.txt"}); err != nil {
+		t.Fatalf("CreatePaste: %v", err)
+	}
+	profile, err := bob.GetProfile(ctx)
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+
+	export, err := cli.ExportUserData(ctx, profile.User.ID)
+	if err != nil {
+		t.Fatalf("ExportUserData: %v", err)
+	}
+	files, manifest := readDataExport(t, export)
+	if files["pastes/hello.txt"] != "hello" || manifest.User.Username != "bob" || len(manifest.Pastes) != 1 {
+		t.Errorf("unexpected export of bob: %v %+v", files, manifest)
+	}
+
+	events, err := cli.ListAuditEvents(ctx, client.AuditLogFilter{Actions: []string{"user.export"}}, 1, 10)
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(events.Events) != 1 || events.Events[0].Details["pastes"] != "1" {
+		t.Errorf("want the export to be audited, got %+v", events.Events)
+	}
+
+	// Errors are reported before the archive starts.
+	if _, err := cli.ExportUserData(ctx, 4242); err == nil {
+		t.Fatal("expected error")
+	} else if _, ok := err.(*gErrors.NotFoundError); !ok {
+		t.Fatalf("want NotFoundError, got %T: %v", err, err)
+	}
+
+	admin, err := cli.GetProfile(ctx)
+	if err != nil {
+		t.Fatalf("GetProfile: %v", err)
+	}
+	if _, err := bob.ExportUserData(ctx, admin.User.ID); err == nil {
+		t.Fatal("expected error")
+	} else if _, ok := err.(*gErrors.UnauthorizedError); !ok {
+		t.Fatalf("want UnauthorizedError, got %T: %v", err, err)
+	}
+}
+
+// readDataExport reads a data export archive, and returns the contents of
+// the paste files along with the manifest.
+func readDataExport(t *testing.T, export io.ReadCloser) (map[string]string, params.DataExport) {
+	t.Helper()
+	defer export.Close()
+	data, err := io.ReadAll(export)
+	if err != nil {
+		t.Fatalf("reading export: %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("opening export: %v", err)
+	}
+	files := map[string]string{}
+	var manifest params.DataExport
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("opening %s: %v", f.Name, err)
+		}
+		if f.Name == "manifest.json" {
+			err = json.NewDecoder(r).Decode(&manifest)
+		} else {
+			var contents []byte
+			contents, err = io.ReadAll(r)
+			files[f.Name] = string(contents)
+		}
+		r.Close()
+		if err != nil {
+			t.Fatalf("reading %s: %v", f.Name, err)
+		}
+	}
+	if manifest.ExportedAt.IsZero() {
+		t.Fatal("the export has no manifest")
+	}
+	return files, manifest
+}
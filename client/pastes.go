@@ -0,0 +1,149 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopherbin/params"
+
+	"github.com/pkg/errors"
+)
+
+// CreatePaste creates a new paste. Only the Data, Name, Language, Description,
+// Expires, Public, Metadata, MaxAccesses and Tags fields are used.
+func (c *Client) CreatePaste(ctx context.Context, paste params.Paste) (params.Paste, error) {
+	var ret params.Paste
+	if err := c.do(ctx, http.MethodPost, "/paste", nil, paste, &ret); err != nil {
+		return params.Paste{}, err
+	}
+	return ret, nil
+}
+
+// GetPaste returns a single paste
+func (c *Client) GetPaste(ctx context.Context, pasteID string) (params.Paste, error) {
+	var ret params.Paste
+	if err := c.do(ctx, http.MethodGet, "/paste/"+escape(pasteID), nil, nil, &ret); err != nil {
+		return params.Paste{}, err
+	}
+	return ret, nil
+}
+
+// GetPublicPaste returns a single public paste. No authentication is needed.
+func (c *Client) GetPublicPaste(ctx context.Context, pasteID string) (params.Paste, error) {
+	var ret params.Paste
+	if err := c.do(ctx, http.MethodGet, "/public/paste/"+escape(pasteID), nil, nil, &ret); err != nil {
+		return params.Paste{}, err
+	}
+	return ret, nil
+}
+
+// DownloadPaste returns the raw contents of a paste
+func (c *Client) DownloadPaste(ctx context.Context, pasteID string) ([]byte, error) {
+	resp, err := c.doRaw(ctx, http.MethodGet, "/paste/"+escape(pasteID)+"/download", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading paste")
+	}
+	return data, nil
+}
+
+func tagsQuery(query url.Values, tags []string) url.Values {
+	if len(tags) > 0 {
+		query.Set("tags", strings.Join(tags, ","))
+	}
+	return query
+}
+
+// ListPastes returns a page of pastes owned by the user. If tags are
+// specified, only pastes that have all of the tags are returned.
+func (c *Client) ListPastes(ctx context.Context, page, maxResults int64, tags []string) (params.PasteListResult, error) {
+	var ret params.PasteListResult
+	query := tagsQuery(pageQuery(page, maxResults), tags)
+	if err := c.do(ctx, http.MethodGet, "/paste", query, nil, &ret); err != nil {
+		return params.PasteListResult{}, err
+	}
+	return ret, nil
+}
+
+// SearchPastes searches the pastes owned by the user. If tags are
+// specified, only pastes that have all of the tags are returned.
+func (c *Client) SearchPastes(ctx context.Context, searchQuery string, page, maxResults int64, tags []string) (params.PasteListResult, error) {
+	var ret params.PasteListResult
+	query := tagsQuery(pageQuery(page, maxResults), tags)
+	query.Set("q", searchQuery)
+	if err := c.do(ctx, http.MethodGet, "/paste/search", query, nil, &ret); err != nil {
+		return params.PasteListResult{}, err
+	}
+	return ret, nil
+}
+
+// UpdatePaste updates the privacy setting and/or the tags of a paste
+func (c *Client) UpdatePaste(ctx context.Context, pasteID string, update params.UpdatePasteParams) (params.Paste, error) {
+	var ret params.Paste
+	if err := c.do(ctx, http.MethodPut, "/paste/"+escape(pasteID), nil, update, &ret); err != nil {
+		return params.Paste{}, err
+	}
+	return ret, nil
+}
+
+// DeletePaste deletes a paste
+func (c *Client) DeletePaste(ctx context.Context, pasteID string) error {
+	return c.do(ctx, http.MethodDelete, "/paste/"+escape(pasteID), nil, nil, nil)
+}
+
+// ListTags returns the tags visible to the user, along with the number
+// of pastes each tag is attached to.
+func (c *Client) ListTags(ctx context.Context) (params.TagListResult, error) {
+	var ret params.TagListResult
+	if err := c.do(ctx, http.MethodGet, "/tags", nil, nil, &ret); err != nil {
+		return params.TagListResult{}, err
+	}
+	return ret, nil
+}
+
+// SharePaste shares a paste with a user. The user may be identified by
+// username or email address.
+func (c *Client) SharePaste(ctx context.Context, pasteID, user string) (params.TeamMember, error) {
+	var ret params.TeamMember
+	req := params.UserActionRequest{UserID: user}
+	if err := c.do(ctx, http.MethodPost, "/paste/"+escape(pasteID)+"/sharing", nil, req, &ret); err != nil {
+		return params.TeamMember{}, err
+	}
+	return ret, nil
+}
+
+// UnsharePaste stops sharing a paste with a user
+func (c *Client) UnsharePaste(ctx context.Context, pasteID, user string) error {
+	return c.do(ctx, http.MethodDelete, "/paste/"+escape(pasteID)+"/sharing/"+escape(user), nil, nil, nil)
+}
+
+// ListShares returns the users a paste is shared with
+func (c *Client) ListShares(ctx context.Context, pasteID string) (params.PasteShareListResponse, error) {
+	var ret params.PasteShareListResponse
+	if err := c.do(ctx, http.MethodGet, "/paste/"+escape(pasteID)+"/sharing", nil, nil, &ret); err != nil {
+		return params.PasteShareListResponse{}, err
+	}
+	return ret, nil
+}
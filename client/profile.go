@@ -0,0 +1,81 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"gopherbin/params"
+)
+
+// GetProfile returns the profile of the current user, along with their
+// paste count, storage used and teams.
+func (c *Client) GetProfile(ctx context.Context) (params.Profile, error) {
+	var ret params.Profile
+	if err := c.do(ctx, http.MethodGet, "/me", nil, nil, &ret); err != nil {
+		return params.Profile{}, err
+	}
+	return ret, nil
+}
+
+// UpdateProfile changes the full name and email address of the current
+// user. If the server requires email changes to be confirmed, the email
+// address stays the same and PendingEmail is set in the returned profile
+// until the link sent to the new address is opened.
+func (c *Client) UpdateProfile(ctx context.Context, update params.UpdateProfileParams) (params.Profile, error) {
+	var ret params.Profile
+	if err := c.do(ctx, http.MethodPatch, "/me", nil, update, &ret); err != nil {
+		return params.Profile{}, err
+	}
+	return ret, nil
+}
+
+// ChangePassword sets a new password for the current user. All other
+// sessions of the user are revoked. If the client logged in, the new
+// session token returned by the server is used for all subsequent
+// requests.
+func (c *Client) ChangePassword(ctx context.Context, currentPassword, newPassword string) error {
+	var ret params.JWTResponse
+	changeParams := params.ChangePasswordParams{
+		CurrentPassword: currentPassword,
+		NewPassword:     newPassword,
+	}
+	if err := c.do(ctx, http.MethodPost, "/me/password", nil, changeParams, &ret); err != nil {
+		return err
+	}
+	if ret.Token != "" {
+		c.SetToken(ret.Token)
+	}
+	return nil
+}
+
+// ExportData downloads a zip archive with every paste owned by the
+// current user and a manifest.json file describing them. The archive is
+// streamed, so the caller must close it once read.
+func (c *Client) ExportData(ctx context.Context) (io.ReadCloser, error) {
+	resp, err := c.doRaw(ctx, http.MethodGet, "/me/export", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ConfirmEmail changes the email address of a user using the token from
+// the link sent to the new address.
+func (c *Client) ConfirmEmail(ctx context.Context, token string) error {
+	return c.do(ctx, http.MethodPost, "/auth/email/confirm", nil, params.ConfirmEmailParams{Token: token}, nil)
+}
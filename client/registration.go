@@ -0,0 +1,84 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"gopherbin/params"
+)
+
+// Register creates an account for the caller. Depending on the server
+// settings, an invite code is required. Accounts registered without one
+// can only log in once an admin approves them.
+func (c *Client) Register(ctx context.Context, registration params.RegisterParams) (params.Users, error) {
+	var ret params.Users
+	if err := c.do(ctx, http.MethodPost, "/auth/register", nil, registration, &ret); err != nil {
+		return params.Users{}, err
+	}
+	return ret, nil
+}
+
+// CreateInvite creates an invite code. The code is only returned here.
+// This requires admin privileges.
+func (c *Client) CreateInvite(ctx context.Context, invite params.NewInviteParams) (params.Invite, error) {
+	var ret params.Invite
+	if err := c.do(ctx, http.MethodPost, "/admin/invites", nil, invite, &ret); err != nil {
+		return params.Invite{}, err
+	}
+	return ret, nil
+}
+
+// ListInvites returns all invite codes. This requires admin privileges.
+func (c *Client) ListInvites(ctx context.Context) ([]params.Invite, error) {
+	var ret params.InviteListResult
+	if err := c.do(ctx, http.MethodGet, "/admin/invites", nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	return ret.Invites, nil
+}
+
+// DeleteInvite deletes an invite code. This requires admin privileges.
+func (c *Client) DeleteInvite(ctx context.Context, inviteID uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/admin/invites/%d", inviteID), nil, nil, nil)
+}
+
+// ListPendingUsers returns a page of users awaiting approval. This
+// requires admin privileges.
+func (c *Client) ListPendingUsers(ctx context.Context, page, maxResults int64) (params.UserListResult, error) {
+	var ret params.UserListResult
+	if err := c.do(ctx, http.MethodGet, "/admin/users/pending", pageQuery(page, maxResults), nil, &ret); err != nil {
+		return params.UserListResult{}, err
+	}
+	return ret, nil
+}
+
+// ApproveUser enables the account of a user awaiting approval. This
+// requires admin privileges.
+func (c *Client) ApproveUser(ctx context.Context, userID uint) (params.Users, error) {
+	var ret params.Users
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/users/%d/approve", userID), nil, nil, &ret); err != nil {
+		return params.Users{}, err
+	}
+	return ret, nil
+}
+
+// RejectUser deletes the account of a user awaiting approval. This
+// requires admin privileges.
+func (c *Client) RejectUser(ctx context.Context, userID uint) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/users/%d/reject", userID), nil, nil, nil)
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"gopherbin/params"
+)
+
+// ListSessions returns the active sessions of the current user.
+func (c *Client) ListSessions(ctx context.Context) ([]params.Session, error) {
+	var ret params.SessionListResult
+	if err := c.do(ctx, http.MethodGet, "/account/sessions", nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	return ret.Sessions, nil
+}
+
+// RevokeSession revokes one of the sessions of the current user.
+func (c *Client) RevokeSession(ctx context.Context, sessionID string) error {
+	return c.do(ctx, http.MethodDelete, "/account/sessions/"+escape(sessionID), nil, nil, nil)
+}
+
+// RevokeAllSessions logs the current user out everywhere, including the
+// session used by the client, if it logged in. API tokens keep working.
+func (c *Client) RevokeAllSessions(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/account/sessions", nil, nil, nil)
+}
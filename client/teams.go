@@ -0,0 +1,80 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"gopherbin/params"
+)
+
+// CreateTeam creates a new team owned by the user
+func (c *Client) CreateTeam(ctx context.Context, name string) (params.Teams, error) {
+	var ret params.Teams
+	req := params.NewTeamParams{Name: name}
+	if err := c.do(ctx, http.MethodPost, "/teams", nil, req, &ret); err != nil {
+		return params.Teams{}, err
+	}
+	return ret, nil
+}
+
+// GetTeam returns details about a single team
+func (c *Client) GetTeam(ctx context.Context, name string) (params.Teams, error) {
+	var ret params.Teams
+	if err := c.do(ctx, http.MethodGet, "/teams/"+escape(name), nil, nil, &ret); err != nil {
+		return params.Teams{}, err
+	}
+	return ret, nil
+}
+
+// DeleteTeam deletes a team. All pastes created within this team will also be deleted.
+func (c *Client) DeleteTeam(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/teams/"+escape(name), nil, nil, nil)
+}
+
+// ListTeams returns a page of teams owned by the user
+func (c *Client) ListTeams(ctx context.Context, page, maxResults int64) (params.TeamListResult, error) {
+	var ret params.TeamListResult
+	if err := c.do(ctx, http.MethodGet, "/teams", pageQuery(page, maxResults), nil, &ret); err != nil {
+		return params.TeamListResult{}, err
+	}
+	return ret, nil
+}
+
+// AddTeamMember adds a user to a team. The user may be identified by
+// username or email address.
+func (c *Client) AddTeamMember(ctx context.Context, team, member string) (params.TeamMember, error) {
+	var ret params.TeamMember
+	req := params.UserActionRequest{UserID: member}
+	if err := c.do(ctx, http.MethodPost, "/teams/"+escape(team)+"/members", nil, req, &ret); err != nil {
+		return params.TeamMember{}, err
+	}
+	return ret, nil
+}
+
+// ListTeamMembers returns all members of a team, including the owner
+func (c *Client) ListTeamMembers(ctx context.Context, team string) ([]params.TeamMember, error) {
+	var ret []params.TeamMember
+	if err := c.do(ctx, http.MethodGet, "/teams/"+escape(team)+"/members", nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// RemoveTeamMember removes a user from a team
+func (c *Client) RemoveTeamMember(ctx context.Context, team, member string) error {
+	return c.do(ctx, http.MethodDelete, "/teams/"+escape(team)+"/members/"+escape(member), nil, nil, nil)
+}
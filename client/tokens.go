@@ -0,0 +1,47 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"gopherbin/params"
+)
+
+// CreateAPIToken creates a personal API token. The token itself is only
+// available in the returned value, and can be used with WithToken.
+func (c *Client) CreateAPIToken(ctx context.Context, token params.NewAPITokenParams) (params.APIToken, error) {
+	var ret params.APIToken
+	if err := c.do(ctx, http.MethodPost, "/tokens", nil, token, &ret); err != nil {
+		return params.APIToken{}, err
+	}
+	return ret, nil
+}
+
+// ListAPITokens returns the API tokens of the current user.
+func (c *Client) ListAPITokens(ctx context.Context) ([]params.APIToken, error) {
+	var ret params.APITokenListResult
+	if err := c.do(ctx, http.MethodGet, "/tokens", nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	return ret.Tokens, nil
+}
+
+// RevokeAPIToken revokes one of the API tokens of the current user.
+func (c *Client) RevokeAPIToken(ctx context.Context, tokenID uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/tokens/%d", tokenID), nil, nil, nil)
+}
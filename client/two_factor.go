@@ -0,0 +1,69 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"gopherbin/params"
+)
+
+// TwoFactorStatus returns the two-factor authentication settings of the
+// current user.
+func (c *Client) TwoFactorStatus(ctx context.Context) (params.TwoFactorStatus, error) {
+	var ret params.TwoFactorStatus
+	if err := c.do(ctx, http.MethodGet, "/account/2fa", nil, nil, &ret); err != nil {
+		return params.TwoFactorStatus{}, err
+	}
+	return ret, nil
+}
+
+// EnrollTwoFactor generates a new TOTP secret for the current user.
+// Two-factor authentication is enabled once a code generated with the
+// secret is passed to ConfirmTwoFactor.
+func (c *Client) EnrollTwoFactor(ctx context.Context) (params.TwoFactorEnrollment, error) {
+	var ret params.TwoFactorEnrollment
+	if err := c.do(ctx, http.MethodPost, "/account/2fa/enroll", nil, nil, &ret); err != nil {
+		return params.TwoFactorEnrollment{}, err
+	}
+	return ret, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication for the current
+// user, and returns their recovery codes.
+func (c *Client) ConfirmTwoFactor(ctx context.Context, code string) (params.RecoveryCodes, error) {
+	var ret params.RecoveryCodes
+	if err := c.do(ctx, http.MethodPost, "/account/2fa/confirm", nil, params.TwoFactorCodeParams{Code: code}, &ret); err != nil {
+		return params.RecoveryCodes{}, err
+	}
+	return ret, nil
+}
+
+// DisableTwoFactor disables two-factor authentication for the current
+// user. The code may be generated by the authenticator app, or be a
+// recovery code.
+func (c *Client) DisableTwoFactor(ctx context.Context, code string) error {
+	return c.do(ctx, http.MethodPost, "/account/2fa/disable", nil, params.TwoFactorCodeParams{Code: code}, nil)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user.
+func (c *Client) RegenerateRecoveryCodes(ctx context.Context, code string) (params.RecoveryCodes, error) {
+	var ret params.RecoveryCodes
+	if err := c.do(ctx, http.MethodPost, "/account/2fa/recovery-codes", nil, params.TwoFactorCodeParams{Code: code}, &ret); err != nil {
+		return params.RecoveryCodes{}, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopherbin/client"
+	"gopherbin/params"
+
+	"github.com/pkg/errors"
+)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// setEcho turns terminal echo on or off. Errors are ignored, as the
+// worst outcome is that the password is visible while typing.
+func setEcho(on bool) {
+	arg := "-echo"
+	if on {
+		arg = "echo"
+	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	cmd.Run()
+}
+
+func (a *app) prompt(label string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	line, err := a.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.Wrapf(err, "reading %s", strings.ToLower(label))
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func (a *app) promptPassword(label string) (string, error) {
+	if isTerminal(os.Stdin) {
+		setEcho(false)
+		defer func() {
+			setEcho(true)
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	return a.prompt(label)
+}
+
+func (a *app) confirm(question string) bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+	answer, err := a.prompt(question + " [y/N]")
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
+
+func cmdLogin(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("login", "[flags]")
+	username := fs.String("u", a.cfg.Username, "username or email address")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		if *username, err = a.prompt("Username"); err != nil {
+			return err
+		}
+	}
+	password := a.cfg.password
+	if password == "" {
+		if password, err = a.promptPassword("Password"); err != nil {
+			return err
+		}
+	}
+
+	cli, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	err = a.login(ctx, cli, *username, password, true)
+	if errors.Cause(err) == client.ErrInitRequired {
+		fmt.Fprintln(os.Stderr, "gopherbin has not been initialized yet, an administrator account must be created first.")
+		if !a.confirm("Create the administrator account now?") {
+			return err
+		}
+		if _, err := a.firstRun(ctx, cli); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Log in using the administrator account you just created.")
+		return cmdLogin(ctx, a, nil)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Logged in to %s\n", a.cfg.URL)
+	return nil
+}
+
+func cmdLogout(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("logout", "")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	token := a.creds[a.cfg.URL]
+	if token == "" {
+		return fmt.Errorf("not logged in to %s", a.cfg.URL)
+	}
+	cli, err := client.NewClient(a.cfg.URL, client.WithToken(token))
+	if err != nil {
+		return err
+	}
+	// The cached token is removed even if the server rejects it, as it
+	// is of no further use.
+	logoutErr := cli.Logout(ctx)
+	if err := a.forgetToken(); err != nil {
+		return err
+	}
+	return logoutErr
+}
+
+func (a *app) firstRun(ctx context.Context, cli *client.Client) (params.Users, error) {
+	var user params.NewUserParams
+	var err error
+	if user.Email, err = a.prompt("Email"); err != nil {
+		return params.Users{}, err
+	}
+	if user.Username, err = a.prompt("Username"); err != nil {
+		return params.Users{}, err
+	}
+	if user.FullName, err = a.prompt("Full name"); err != nil {
+		return params.Users{}, err
+	}
+	if user.Password, err = a.promptPassword("Password"); err != nil {
+		return params.Users{}, err
+	}
+	confirm, err := a.promptPassword("Confirm password")
+	if err != nil {
+		return params.Users{}, err
+	}
+	if confirm != user.Password {
+		return params.Users{}, fmt.Errorf("passwords do not match")
+	}
+	user.Enabled = true
+	user.IsAdmin = true
+
+	created, err := cli.FirstRun(ctx, user)
+	if err != nil {
+		return params.Users{}, errors.Wrap(err, "creating administrator")
+	}
+	a.cfg.Username = created.Username
+	return created, nil
+}
+
+func cmdFirstRun(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("first-run", "")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cli, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	user, err := a.firstRun(ctx, cli)
+	if err != nil {
+		return err
+	}
+	return a.printUser(user)
+}
+
+func cmdRegister(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("register", "")
+	invite := fs.String("i", "", "invite code issued by an administrator")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cli, err := a.newClient()
+	if err != nil {
+		return err
+	}
+
+	registration := params.RegisterParams{InviteCode: *invite}
+	if registration.Email, err = a.prompt("Email"); err != nil {
+		return err
+	}
+	if registration.Username, err = a.prompt("Username"); err != nil {
+		return err
+	}
+	if registration.FullName, err = a.prompt("Full name"); err != nil {
+		return err
+	}
+	if registration.Password, err = a.promptPassword("Password"); err != nil {
+		return err
+	}
+	confirm, err := a.promptPassword("Confirm password")
+	if err != nil {
+		return err
+	}
+	if confirm != registration.Password {
+		return fmt.Errorf("passwords do not match")
+	}
+
+	user, err := cli.Register(ctx, registration)
+	if err != nil {
+		return errors.Wrap(err, "registering")
+	}
+	if err := a.printUser(user); err != nil {
+		return err
+	}
+	if user.PendingApproval {
+		fmt.Fprintln(os.Stderr, "Your account is awaiting approval by an administrator.")
+	} else {
+		fmt.Fprintf(os.Stderr, "Account created, run: %s login\n", os.Args[0])
+	}
+	return nil
+}
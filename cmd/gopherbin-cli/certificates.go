@@ -0,0 +1,86 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"gopherbin/params"
+)
+
+func cmdCert(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("cert", "<add|list|remove> ...")
+	scopes := fs.String("s", "", "comma separated list of scopes, defaults to all scopes of your session (add only)")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("cert: missing subcommand")
+	}
+
+	sub, args := args[0], args[1:]
+	nargs := map[string]int{
+		"add":    1,
+		"list":   0,
+		"remove": 1,
+	}
+	want, ok := nargs[sub]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("cert: unknown subcommand %q", sub)
+	}
+	if len(args) != want {
+		fs.Usage()
+		return fmt.Errorf("cert %s: wrong number of arguments", sub)
+	}
+
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "add":
+		// The server registers the certificate presented on the
+		// connection, which proves we hold its private key.
+		if a.cfg.ClientCertificate == "" {
+			return fmt.Errorf("cert add: set client_certificate and client_key in the config to the certificate to register")
+		}
+		newCert := params.NewClientCertificateParams{
+			Name:   args[0],
+			Scopes: splitTags(*scopes),
+		}
+		cert, err := cli.RegisterClientCertificate(ctx, newCert)
+		if err != nil {
+			return err
+		}
+		return a.printCertificates([]params.ClientCertificate{cert})
+	case "list":
+		certs, err := cli.ListClientCertificates(ctx)
+		if err != nil {
+			return err
+		}
+		return a.printCertificates(certs)
+	default:
+		certID, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid certificate ID %q", args[0])
+		}
+		return cli.DeleteClientCertificate(ctx, uint(certID))
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultConfigFile is the name of the dotfile, relative to the home folder.
+	defaultConfigFile = ".gopherbin-cli.toml"
+	// defaultURL is used when no URL is set in the config file or environment.
+	defaultURL = "http://127.0.0.1:9997"
+
+	envConfig   = "GOPHERBIN_CLI_CONFIG"
+	envURL      = "GOPHERBIN_URL"
+	envUsername = "GOPHERBIN_USERNAME"
+	envPassword = "GOPHERBIN_PASSWORD"
+	envToken    = "GOPHERBIN_TOKEN"
+	envOutput   = "GOPHERBIN_OUTPUT"
+)
+
+// cliConfig holds the CLI settings. Values are read from the dotfile and
+// may be overridden by environment variables and command line flags.
+type cliConfig struct {
+	URL      string `toml:"url"`
+	Username string `toml:"username"`
+	Output   string `toml:"output"`
+	// CredentialsFile is the file used to cache tokens obtained on login.
+	CredentialsFile string `toml:"credentials_file"`
+	// ClientCertificate and ClientKey are the PEM encoded TLS client
+	// certificate and key presented to gopherbin. Without a token,
+	// requests are authenticated by the certificate.
+	ClientCertificate string `toml:"client_certificate"`
+	ClientKey         string `toml:"client_key"`
+	// CACertificate is a PEM encoded CA certificate the server
+	// certificate is verified against, instead of the system roots.
+	CACertificate string `toml:"ca_certificate"`
+
+	// password and token are only read from the environment.
+	password string
+	token    string
+}
+
+func defaultConfigPath() string {
+	if cfgFile := os.Getenv(envConfig); cfgFile != "" {
+		return cfgFile
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, defaultConfigFile)
+}
+
+func defaultCredentialsPath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "finding cache folder")
+	}
+	return filepath.Join(cacheDir, "gopherbin", "credentials.json"), nil
+}
+
+// loadConfig reads the config file, if it exists, and applies any
+// overrides set in the environment.
+func loadConfig(cfgFile string) (*cliConfig, error) {
+	cfg := &cliConfig{}
+	if cfgFile != "" {
+		if _, err := toml.DecodeFile(cfgFile, cfg); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "reading %s", cfgFile)
+		}
+	}
+
+	if val := os.Getenv(envURL); val != "" {
+		cfg.URL = val
+	}
+	if val := os.Getenv(envUsername); val != "" {
+		cfg.Username = val
+	}
+	if val := os.Getenv(envOutput); val != "" {
+		cfg.Output = val
+	}
+	cfg.password = os.Getenv(envPassword)
+	cfg.token = os.Getenv(envToken)
+
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+	}
+	if cfg.Output == "" {
+		cfg.Output = outputTable
+	}
+	if cfg.CredentialsFile == "" {
+		credsFile, err := defaultCredentialsPath()
+		if err != nil {
+			return nil, err
+		}
+		cfg.CredentialsFile = credsFile
+	}
+	return cfg, nil
+}
+
+// tlsConfig returns the TLS settings used to connect to gopherbin, or nil
+// if the defaults should be used.
+func (c *cliConfig) tlsConfig() (*tls.Config, error) {
+	if c.ClientCertificate == "" && c.ClientKey == "" && c.CACertificate == "" {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{}
+	if c.ClientCertificate != "" || c.ClientKey != "" {
+		if c.ClientCertificate == "" || c.ClientKey == "" {
+			return nil, fmt.Errorf("both client_certificate and client_key must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCertificate, c.ClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client certificate")
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	if c.CACertificate != "" {
+		caPEM, err := os.ReadFile(c.CACertificate)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading CA certificate")
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACertificate)
+		}
+	}
+	return tlsCfg, nil
+}
+
+// credentials maps a gopherbin URL to the token obtained when logging in.
+type credentials map[string]string
+
+func loadCredentials(path string) (credentials, error) {
+	creds := credentials{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return creds, nil
+		}
+		return nil, errors.Wrap(err, "reading credentials")
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", path, err)
+	}
+	return creds, nil
+}
+
+func (c credentials) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return errors.Wrap(err, "creating credentials folder")
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding credentials")
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return errors.Wrap(err, "writing credentials")
+	}
+	// WriteFile does not change the permissions of an existing file.
+	return os.Chmod(path, 0o600)
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Command gopherbin-cli is a command line client for gopherbin.
+//
+//	echo foo | gopherbin-cli paste -l go -e 1h --public
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"gopherbin/client"
+	gErrors "gopherbin/errors"
+
+	"github.com/pkg/errors"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = []command{
+	{"paste", "create a paste from a file or standard input", cmdPaste},
+	{"get", "show a paste", cmdGet},
+	{"raw", "write the contents of a paste to standard output", cmdRaw},
+	{"list", "list your pastes", cmdList},
+	{"search", "search your pastes", cmdSearch},
+	{"delete", "delete a paste", cmdDelete},
+	{"share", "share a paste with a user, or list the users it is shared with", cmdShare},
+	{"unshare", "stop sharing a paste with a user", cmdUnshare},
+	{"team", "manage teams", cmdTeam},
+	{"token", "manage personal API tokens", cmdToken},
+	{"2fa", "manage two-factor authentication", cmdTwoFactor},
+	{"session", "list and revoke your login sessions", cmdSession},
+	{"profile", "show, update or export your profile", cmdProfile},
+	{"cert", "manage TLS client certificates", cmdCert},
+	{"register", "register an account", cmdRegister},
+	{"password", "change your password, or reset a forgotten one by email", cmdPassword},
+	{"login", "log in and cache the token", cmdLogin},
+	{"logout", "invalidate and remove the cached token", cmdLogout},
+	{"first-run", "initialize gopherbin by creating the administrator", cmdFirstRun},
+}
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] <command> [args]\n\nCommands:\n", os.Args[0])
+		for _, cmd := range commands {
+			fmt.Fprintf(out, "  %-10s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintf(out, "\nFlags:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(out, "\nSettings are read from %s and the %s, %s, %s, %s and %s environment variables.\n",
+			defaultConfigPath(), envURL, envUsername, envPassword, envToken, envOutput)
+	}
+}
+
+// app holds the state shared by all commands.
+type app struct {
+	cfg   *cliConfig
+	in    *bufio.Reader
+	creds credentials
+}
+
+func (a *app) newClient() (*client.Client, error) {
+	tlsCfg, err := a.cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg == nil {
+		return client.NewClient(a.cfg.URL)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	return client.NewClient(a.cfg.URL, client.WithHTTPClient(&http.Client{Transport: transport}))
+}
+
+// authenticatedClient returns a client that uses the token from the
+// environment or the credentials cache. If no token is available, the
+// client certificate is used if one is configured. Otherwise, if a
+// password is set in the environment, it logs in first.
+func (a *app) authenticatedClient(ctx context.Context) (*client.Client, error) {
+	cli, err := a.newClient()
+	if err != nil {
+		return nil, err
+	}
+	if a.cfg.token != "" {
+		cli.SetToken(a.cfg.token)
+		return cli, nil
+	}
+	if token := a.creds[a.cfg.URL]; token != "" {
+		cli.SetToken(token)
+		return cli, nil
+	}
+	if a.cfg.ClientCertificate != "" {
+		return cli, nil
+	}
+	if a.cfg.Username != "" && a.cfg.password != "" {
+		if err := a.login(ctx, cli, a.cfg.Username, a.cfg.password, false); err != nil {
+			return nil, err
+		}
+		return cli, nil
+	}
+	return nil, fmt.Errorf("not logged in to %s, run: %s login", a.cfg.URL, os.Args[0])
+}
+
+// login logs in and caches the token. If the user has two-factor
+// authentication enabled, the code is read from standard input, unless
+// interactive is false and standard input is not a terminal, as it may
+// hold the contents of a paste.
+func (a *app) login(ctx context.Context, cli *client.Client, username, password string, interactive bool) error {
+	ret, err := cli.Login(ctx, username, password)
+	if err != nil {
+		return err
+	}
+	if ret.TwoFactorRequired {
+		if !interactive && !isTerminal(os.Stdin) {
+			return fmt.Errorf("two-factor authentication is enabled, log in first by running: %s login", os.Args[0])
+		}
+		code, err := a.prompt("Two-factor code")
+		if err != nil {
+			return err
+		}
+		if ret, err = cli.LoginTwoFactor(ctx, ret.PreAuthToken, code); err != nil {
+			return err
+		}
+	}
+	if ret.TwoFactorEnrollmentRequired {
+		fmt.Fprintf(os.Stderr, "Your account must use two-factor authentication. Enable it by running: %s 2fa enroll\n", os.Args[0])
+	}
+	a.creds[a.cfg.URL] = ret.Token
+	return a.creds.save(a.cfg.CredentialsFile)
+}
+
+func (a *app) forgetToken() error {
+	if _, ok := a.creds[a.cfg.URL]; !ok {
+		return nil
+	}
+	delete(a.creds, a.cfg.URL)
+	return a.creds.save(a.cfg.CredentialsFile)
+}
+
+func explainError(cmd string, err error) string {
+	if _, ok := errors.Cause(err).(*gErrors.UnauthorizedError); ok && cmd != "login" {
+		return fmt.Sprintf("%v\nYour session may have expired, run: %s login", err, os.Args[0])
+	}
+	if tooMany, ok := errors.Cause(err).(*gErrors.TooManyRequestsError); ok && tooMany.RetryAfter() > 0 {
+		return fmt.Sprintf("%v\nTry again in %s", err, tooMany.RetryAfter())
+	}
+	if errors.Cause(err) == client.ErrInitRequired {
+		return fmt.Sprintf("gopherbin has not been initialized yet.\nCreate the administrator account by running: %s first-run", os.Args[0])
+	}
+	return err.Error()
+}
+
+func main() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	cfgFile := fs.String("config", defaultConfigPath(), "CLI config file")
+	url := fs.String("url", "", "gopherbin URL (overrides config and "+envURL+")")
+	output := fs.String("o", "", "output format: table or json")
+	fs.Usage = usage(fs)
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*cfgFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *url != "" {
+		cfg.URL = *url
+	}
+	if *output != "" {
+		cfg.Output = *output
+	}
+	if cfg.Output != outputTable && cfg.Output != outputJSON {
+		fmt.Fprintf(os.Stderr, "invalid output format %q\n", cfg.Output)
+		os.Exit(2)
+	}
+
+	creds, err := loadCredentials(cfg.CredentialsFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	a := &app{
+		cfg:   cfg,
+		in:    bufio.NewReader(os.Stdin),
+		creds: creds,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	name := fs.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(ctx, a, fs.Args()[1:]); err != nil {
+			if err == flag.ErrHelp {
+				os.Exit(0)
+			}
+			fmt.Fprintln(os.Stderr, explainError(name, err))
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	fs.Usage()
+	os.Exit(2)
+}
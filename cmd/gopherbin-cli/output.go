@@ -0,0 +1,228 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopherbin/params"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func (a *app) printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func visibility(public bool) string {
+	if public {
+		return "public"
+	}
+	return "private"
+}
+
+func (a *app) printPaste(paste params.Paste) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(paste)
+	}
+	tw := newTable()
+	fmt.Fprintf(tw, "ID:\t%s\n", paste.PasteID)
+	fmt.Fprintf(tw, "Name:\t%s\n", paste.Name)
+	fmt.Fprintf(tw, "Language:\t%s\n", paste.Language)
+	if paste.Description != "" {
+		fmt.Fprintf(tw, "Description:\t%s\n", paste.Description)
+	}
+	fmt.Fprintf(tw, "Visibility:\t%s\n", visibility(paste.Public))
+	fmt.Fprintf(tw, "Created:\t%s\n", paste.FormattedCreatedAt())
+	if paste.Expires != nil {
+		fmt.Fprintf(tw, "Expires:\t%s\n", paste.FormattedExpires())
+	}
+	if len(paste.Tags) > 0 {
+		fmt.Fprintf(tw, "Tags:\t%s\n", strings.Join(paste.Tags, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(paste.Data) > 0 {
+		fmt.Printf("\n%s", paste.Data)
+		if !strings.HasSuffix(string(paste.Data), "\n") {
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+func (a *app) printPasteList(list params.PasteListResult) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(list)
+	}
+	tw := newTable()
+	fmt.Fprintln(tw, "ID\tNAME\tLANGUAGE\tVISIBILITY\tCREATED\tEXPIRES\tTAGS")
+	for _, paste := range list.Pastes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			paste.PasteID, paste.Name, paste.Language, visibility(paste.Public),
+			paste.FormattedCreatedAt(), paste.FormattedExpires(), strings.Join(paste.Tags, ","))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if list.TotalPages > 1 {
+		fmt.Printf("\npage %d of %d\n", list.Page, list.TotalPages)
+	}
+	return nil
+}
+
+func (a *app) printMembers(members []params.TeamMember) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(members)
+	}
+	tw := newTable()
+	fmt.Fprintln(tw, "USERNAME\tEMAIL\tFULL NAME")
+	for _, member := range members {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", member.Username, member.Email, member.FullName)
+	}
+	return tw.Flush()
+}
+
+func (a *app) printTeam(team params.Teams) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(team)
+	}
+	tw := newTable()
+	fmt.Fprintf(tw, "Name:\t%s\n", team.Name)
+	fmt.Fprintf(tw, "Owner:\t%s\n", team.Owner.Username)
+	fmt.Fprintf(tw, "Members:\t%d\n", len(team.Members))
+	return tw.Flush()
+}
+
+func (a *app) printTeamList(list params.TeamListResult) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(list)
+	}
+	tw := newTable()
+	fmt.Fprintln(tw, "NAME\tOWNER")
+	for _, team := range list.Teams {
+		fmt.Fprintf(tw, "%s\t%s\n", team.Name, team.Owner.Username)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if list.TotalPages > 1 {
+		fmt.Printf("\npage %d of %d\n", list.Page, list.TotalPages)
+	}
+	return nil
+}
+
+func (a *app) printUser(user params.Users) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(user)
+	}
+	tw := newTable()
+	fmt.Fprintf(tw, "ID:\t%d\n", user.ID)
+	fmt.Fprintf(tw, "Username:\t%s\n", user.Username)
+	fmt.Fprintf(tw, "Email:\t%s\n", user.Email)
+	fmt.Fprintf(tw, "Full name:\t%s\n", user.FullName)
+	fmt.Fprintf(tw, "Admin:\t%t\n", user.IsAdmin)
+	return tw.Flush()
+}
+
+func (a *app) printProfile(profile params.Profile) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(profile)
+	}
+	teams := make([]string, len(profile.Teams))
+	for idx, team := range profile.Teams {
+		teams[idx] = team.Name
+	}
+	tw := newTable()
+	fmt.Fprintf(tw, "Username:\t%s\n", profile.User.Username)
+	fmt.Fprintf(tw, "Email:\t%s\n", profile.User.Email)
+	if profile.PendingEmail != "" {
+		fmt.Fprintf(tw, "Pending email:\t%s\n", profile.PendingEmail)
+	}
+	fmt.Fprintf(tw, "Full name:\t%s\n", profile.User.FullName)
+	fmt.Fprintf(tw, "Admin:\t%t\n", profile.User.IsAdmin)
+	fmt.Fprintf(tw, "Pastes:\t%d\n", profile.PasteCount)
+	fmt.Fprintf(tw, "Storage used:\t%d bytes\n", profile.StorageUsed)
+	fmt.Fprintf(tw, "Teams:\t%s\n", strings.Join(teams, ", "))
+	return tw.Flush()
+}
+
+func formatTime(tm *time.Time) string {
+	if tm == nil {
+		return "never"
+	}
+	return tm.Local().Format("02-Jan-2006 15:04")
+}
+
+func (a *app) printTokens(tokens []params.APIToken) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(tokens)
+	}
+	tw := newTable()
+	fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tSCOPES\tCREATED\tEXPIRES\tLAST USED")
+	for _, token := range tokens {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			token.ID, token.Name, token.Prefix, strings.Join(token.Scopes, ","), formatTime(&token.CreatedAt),
+			formatTime(token.ExpiresAt), formatTime(token.LastUsedAt))
+	}
+	return tw.Flush()
+}
+
+func (a *app) printCertificates(certs []params.ClientCertificate) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(certs)
+	}
+	tw := newTable()
+	fmt.Fprintln(tw, "ID\tNAME\tFINGERPRINT\tSCOPES\tCREATED\tLAST USED")
+	for _, cert := range certs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			cert.ID, cert.Name, cert.Fingerprint, strings.Join(cert.Scopes, ","),
+			formatTime(&cert.CreatedAt), formatTime(cert.LastUsedAt))
+	}
+	return tw.Flush()
+}
+
+func (a *app) printSessions(sessions []params.Session) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(sessions)
+	}
+	tw := newTable()
+	fmt.Fprintln(tw, "ID\tIP\tUSER AGENT\tCREATED\tLAST SEEN\tEXPIRES\tCURRENT")
+	for _, session := range sessions {
+		current := ""
+		if session.Current {
+			current = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			session.ID, session.IP, session.UserAgent, formatTime(&session.CreatedAt),
+			formatTime(&session.LastSeenAt), formatTime(&session.ExpiresAt), current)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,130 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func cmdPassword(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("password", "<change|forgot [email]|reset [token]>")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("password: missing subcommand")
+	}
+
+	sub, args := args[0], args[1:]
+	if sub != "change" && sub != "forgot" && sub != "reset" {
+		fs.Usage()
+		return fmt.Errorf("password: unknown subcommand %q", sub)
+	}
+	if sub == "change" {
+		if len(args) != 0 {
+			fs.Usage()
+			return fmt.Errorf("password change: wrong number of arguments")
+		}
+		return a.changePassword(ctx)
+	}
+	if len(args) > 1 {
+		fs.Usage()
+		return fmt.Errorf("password %s: wrong number of arguments", sub)
+	}
+	var arg string
+	if len(args) == 1 {
+		arg = args[0]
+	}
+
+	cli, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	if sub == "forgot" {
+		if arg == "" {
+			if arg, err = a.prompt("Email"); err != nil {
+				return err
+			}
+		}
+		if err := cli.ForgotPassword(ctx, arg); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "If %s belongs to an account, a password reset link is on its way.\n", arg)
+		return nil
+	}
+
+	// The token is the token parameter of the link in the email.
+	if arg == "" {
+		if arg, err = a.prompt("Token"); err != nil {
+			return err
+		}
+	}
+	password, err := a.promptPassword("New password")
+	if err != nil {
+		return err
+	}
+	confirm, err := a.promptPassword("Confirm password")
+	if err != nil {
+		return err
+	}
+	if confirm != password {
+		return fmt.Errorf("passwords do not match")
+	}
+	if err := cli.ResetPassword(ctx, arg, password); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Password changed. You have been logged out everywhere, run: %s login\n", os.Args[0])
+	return nil
+}
+
+// changePassword changes the password of the current user. The server
+// revokes all other sessions, and replaces the session of the client with
+// a new one, which is cached instead of the old token.
+func (a *app) changePassword(ctx context.Context) error {
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	current, err := a.promptPassword("Current password")
+	if err != nil {
+		return err
+	}
+	password, err := a.promptPassword("New password")
+	if err != nil {
+		return err
+	}
+	confirm, err := a.promptPassword("Confirm password")
+	if err != nil {
+		return err
+	}
+	if confirm != password {
+		return fmt.Errorf("passwords do not match")
+	}
+	if err := cli.ChangePassword(ctx, current, password); err != nil {
+		return err
+	}
+	if a.cfg.token == "" && a.creds[a.cfg.URL] != "" {
+		a.creds[a.cfg.URL] = cli.Token()
+		if err := a.creds.save(a.cfg.CredentialsFile); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Password changed. Your other sessions have been logged out.")
+	return nil
+}
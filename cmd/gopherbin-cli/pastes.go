@@ -0,0 +1,316 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopherbin/params"
+
+	"github.com/pkg/errors"
+)
+
+// parseFlags parses the command flags, allowing them to be interleaved
+// with positional arguments, and returns the positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func wantArgs(fs *flag.FlagSet, args []string, min, max int) error {
+	if len(args) < min || len(args) > max {
+		fs.Usage()
+		return fmt.Errorf("%s: wrong number of arguments", fs.Name())
+	}
+	return nil
+}
+
+// parseExpiry parses durations such as 30m, 1h or 7d.
+func parseExpiry(val string) (*time.Time, error) {
+	if val == "" {
+		return nil, nil
+	}
+	var duration time.Duration
+	if days, ok := strings.CutSuffix(val, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry %q", val)
+		}
+		duration = time.Duration(count) * 24 * time.Hour
+	} else {
+		var err error
+		duration, err = time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry %q", val)
+		}
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("invalid expiry %q: must be in the future", val)
+	}
+	expires := time.Now().UTC().Add(duration)
+	return &expires, nil
+}
+
+func splitTags(val string) []string {
+	var tags []string
+	for _, tag := range strings.Split(val, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func cmdPaste(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("paste", "[flags] [file]")
+	name := fs.String("n", "", "paste name (defaults to the file name)")
+	lang := fs.String("l", "", "language used for syntax highlighting")
+	expires := fs.String("e", "", "expire the paste after this long (for example 30m, 1h, 7d)")
+	desc := fs.String("d", "", "paste description")
+	tags := fs.String("t", "", "comma separated list of tags")
+	public := fs.Bool("public", false, "make the paste public")
+	maxAccesses := fs.Int("max-accesses", 0, "delete the paste after it has been viewed this many times")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 0, 1); err != nil {
+		return err
+	}
+
+	expiry, err := parseExpiry(*expires)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if len(args) == 1 {
+		data, err = os.ReadFile(args[0])
+		if *name == "" {
+			*name = filepath.Base(args[0])
+		}
+	} else {
+		data, err = io.ReadAll(a.in)
+	}
+	if err != nil {
+		return errors.Wrap(err, "reading paste")
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("refusing to create an empty paste")
+	}
+	if *name == "" {
+		*name = "stdin"
+	}
+
+	paste := params.Paste{
+		Name:        *name,
+		Language:    *lang,
+		Description: *desc,
+		Data:        data,
+		Expires:     expiry,
+		Public:      *public,
+		Tags:        splitTags(*tags),
+	}
+	if *maxAccesses > 0 {
+		paste.MaxAccesses = maxAccesses
+	}
+
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	created, err := cli.CreatePaste(ctx, paste)
+	if err != nil {
+		return err
+	}
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(created)
+	}
+	fmt.Println(created.PasteID)
+	return nil
+}
+
+func cmdGet(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("get", "<paste-id>")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	paste, err := cli.GetPaste(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return a.printPaste(paste)
+}
+
+func cmdRaw(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("raw", "<paste-id>")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := cli.DownloadPaste(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func cmdList(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("list", "[flags]")
+	page := fs.Int64("page", 1, "page to show")
+	maxResults := fs.Int64("max", 20, "number of results per page")
+	tags := fs.String("t", "", "only list pastes that have all of these comma separated tags")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	list, err := cli.ListPastes(ctx, *page, *maxResults, splitTags(*tags))
+	if err != nil {
+		return err
+	}
+	return a.printPasteList(list)
+}
+
+func cmdSearch(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("search", "[flags] <query>")
+	page := fs.Int64("page", 1, "page to show")
+	maxResults := fs.Int64("max", 20, "number of results per page")
+	tags := fs.String("t", "", "only search pastes that have all of these comma separated tags")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("search: missing query")
+	}
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	list, err := cli.SearchPastes(ctx, strings.Join(args, " "), *page, *maxResults, splitTags(*tags))
+	if err != nil {
+		return err
+	}
+	return a.printPasteList(list)
+}
+
+func cmdDelete(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("delete", "<paste-id>")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	return cli.DeletePaste(ctx, args[0])
+}
+
+func cmdShare(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("share", "<paste-id> [user]")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 1, 2); err != nil {
+		return err
+	}
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		shares, err := cli.ListShares(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return a.printMembers(shares.Users)
+	}
+	member, err := cli.SharePaste(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return a.printMembers([]params.TeamMember{member})
+}
+
+func cmdUnshare(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("unshare", "<paste-id> <user>")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 2, 2); err != nil {
+		return err
+	}
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	return cli.UnsharePaste(ctx, args[0], args[1])
+}
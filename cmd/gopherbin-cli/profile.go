@@ -0,0 +1,141 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"gopherbin/params"
+
+	"github.com/pkg/errors"
+)
+
+func cmdProfile(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("profile", "[show|update|export|confirm-email [token]]")
+	name := fs.String("n", "", "new full name (update only)")
+	email := fs.String("e", "", "new email address (update only)")
+	file := fs.String("f", "", "write the archive to this file instead of standard output (export only)")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	sub := "show"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	maxArgs := map[string]int{
+		"show":          0,
+		"update":        0,
+		"export":        0,
+		"confirm-email": 1,
+	}
+	max, ok := maxArgs[sub]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("profile: unknown subcommand %q", sub)
+	}
+	if len(args) > max {
+		fs.Usage()
+		return fmt.Errorf("profile %s: wrong number of arguments", sub)
+	}
+
+	if sub == "confirm-email" {
+		cli, err := a.newClient()
+		if err != nil {
+			return err
+		}
+		// The token is the token parameter of the link in the email.
+		var token string
+		if len(args) == 1 {
+			token = args[0]
+		} else if token, err = a.prompt("Token"); err != nil {
+			return err
+		}
+		if err := cli.ConfirmEmail(ctx, token); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Email address changed.")
+		return nil
+	}
+
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	if sub == "show" {
+		profile, err := cli.GetProfile(ctx)
+		if err != nil {
+			return err
+		}
+		return a.printProfile(profile)
+	}
+	if sub == "export" {
+		archive, err := cli.ExportData(ctx)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+		return writeArchive(archive, *file)
+	}
+
+	// Only the flags that were passed are changed.
+	var update params.UpdateProfileParams
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "n":
+			update.FullName = name
+		case "e":
+			update.Email = email
+		}
+	})
+	if update.FullName == nil && update.Email == nil {
+		fs.Usage()
+		return fmt.Errorf("profile update: nothing to update")
+	}
+	profile, err := cli.UpdateProfile(ctx, update)
+	if err != nil {
+		return err
+	}
+	if profile.PendingEmail != "" {
+		fmt.Fprintf(os.Stderr, "A confirmation link was sent to %s. Your email address changes once you open it.\n", profile.PendingEmail)
+	}
+	return a.printProfile(profile)
+}
+
+// writeArchive writes a data export archive to path, or to standard
+// output if path is empty.
+func writeArchive(archive io.Reader, path string) error {
+	out := io.Writer(os.Stdout)
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return errors.Wrap(err, "creating archive")
+		}
+		defer f.Close()
+		out = f
+	}
+	if _, err := io.Copy(out, archive); err != nil {
+		return errors.Wrap(err, "writing archive")
+	}
+	if path != "" {
+		fmt.Fprintf(os.Stderr, "Data exported to %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func cmdSession(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("session", "<list|revoke|revoke-all> ...")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("session: missing subcommand")
+	}
+
+	sub, args := args[0], args[1:]
+	nargs := map[string]int{
+		"list":       0,
+		"revoke":     1,
+		"revoke-all": 0,
+	}
+	want, ok := nargs[sub]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("session: unknown subcommand %q", sub)
+	}
+	if len(args) != want {
+		fs.Usage()
+		return fmt.Errorf("session %s: wrong number of arguments", sub)
+	}
+
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "list":
+		sessions, err := cli.ListSessions(ctx)
+		if err != nil {
+			return err
+		}
+		return a.printSessions(sessions)
+	case "revoke":
+		return cli.RevokeSession(ctx, args[0])
+	default:
+		if err := cli.RevokeAllSessions(ctx); err != nil {
+			return err
+		}
+		// The cached token belonged to one of the revoked sessions.
+		if err := a.forgetToken(); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Logged out everywhere.")
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"gopherbin/params"
+)
+
+func cmdTeam(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("team", "<create|get|delete|list|add|members|remove> ...")
+	page := fs.Int64("page", 1, "page to show (list only)")
+	maxResults := fs.Int64("max", 20, "number of results per page (list only)")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("team: missing subcommand")
+	}
+
+	sub, args := args[0], args[1:]
+	nargs := map[string]int{
+		"create":  1,
+		"get":     1,
+		"delete":  1,
+		"list":    0,
+		"add":     2,
+		"members": 1,
+		"remove":  2,
+	}
+	want, ok := nargs[sub]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("team: unknown subcommand %q", sub)
+	}
+	if len(args) != want {
+		fs.Usage()
+		return fmt.Errorf("team %s: wrong number of arguments", sub)
+	}
+
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "create":
+		team, err := cli.CreateTeam(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return a.printTeam(team)
+	case "get":
+		team, err := cli.GetTeam(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return a.printTeam(team)
+	case "delete":
+		return cli.DeleteTeam(ctx, args[0])
+	case "list":
+		teams, err := cli.ListTeams(ctx, *page, *maxResults)
+		if err != nil {
+			return err
+		}
+		return a.printTeamList(teams)
+	case "add":
+		member, err := cli.AddTeamMember(ctx, args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return a.printMembers([]params.TeamMember{member})
+	case "members":
+		members, err := cli.ListTeamMembers(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		return a.printMembers(members)
+	default:
+		return cli.RemoveTeamMember(ctx, args[0], args[1])
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopherbin/params"
+)
+
+func cmdToken(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("token", "<create|list|revoke> ...")
+	expires := fs.String("e", "", "expire the token after this long, for example 90d (create only)")
+	scopes := fs.String("s", "", "comma separated list of scopes, defaults to all scopes of your session (create only)")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		return fmt.Errorf("token: missing subcommand")
+	}
+
+	sub, args := args[0], args[1:]
+	nargs := map[string]int{
+		"create": 1,
+		"list":   0,
+		"revoke": 1,
+	}
+	want, ok := nargs[sub]
+	if !ok {
+		fs.Usage()
+		return fmt.Errorf("token: unknown subcommand %q", sub)
+	}
+	if len(args) != want {
+		fs.Usage()
+		return fmt.Errorf("token %s: wrong number of arguments", sub)
+	}
+
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "create":
+		expiry, err := parseExpiry(*expires)
+		if err != nil {
+			return err
+		}
+		token, err := cli.CreateAPIToken(ctx, params.NewAPITokenParams{
+			Name:      args[0],
+			ExpiresAt: expiry,
+			Scopes:    splitTags(*scopes),
+		})
+		if err != nil {
+			return err
+		}
+		if a.cfg.Output == outputJSON {
+			return a.printJSON(token)
+		}
+		fmt.Fprintln(os.Stderr, "Store this token now, it will not be shown again.")
+		fmt.Println(token.Token)
+		return nil
+	case "list":
+		tokens, err := cli.ListAPITokens(ctx)
+		if err != nil {
+			return err
+		}
+		return a.printTokens(tokens)
+	default:
+		tokenID, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid token ID %q", args[0])
+		}
+		return cli.RevokeAPIToken(ctx, uint(tokenID))
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"gopherbin/params"
+
+	"github.com/pkg/errors"
+)
+
+func (a *app) printRecoveryCodes(codes params.RecoveryCodes) error {
+	if a.cfg.Output == outputJSON {
+		return a.printJSON(codes)
+	}
+	fmt.Fprintln(os.Stderr, "Store these recovery codes somewhere safe, they will not be shown again.")
+	fmt.Fprintln(os.Stderr, "Each code can be used once to log in if you lose your authenticator.")
+	for _, code := range codes.Codes {
+		fmt.Println(code)
+	}
+	return nil
+}
+
+func cmdTwoFactor(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("2fa", "<status|enroll|disable|recovery-codes>")
+	qrFile := fs.String("qr", "", "write the QR code to this PNG file (enroll only)")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := wantArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	cli, err := a.authenticatedClient(ctx)
+	if err != nil {
+		return err
+	}
+	switch sub := args[0]; sub {
+	case "status":
+		status, err := cli.TwoFactorStatus(ctx)
+		if err != nil {
+			return err
+		}
+		if a.cfg.Output == outputJSON {
+			return a.printJSON(status)
+		}
+		if !status.Enabled {
+			fmt.Println("Two-factor authentication is disabled")
+			return nil
+		}
+		fmt.Printf("Two-factor authentication is enabled since %s\n", formatTime(status.EnabledAt))
+		fmt.Printf("Recovery codes left: %d\n", status.RecoveryCodesLeft)
+		return nil
+	case "enroll":
+		enrollment, err := cli.EnrollTwoFactor(ctx)
+		if err != nil {
+			return err
+		}
+		if *qrFile != "" {
+			if err := os.WriteFile(*qrFile, enrollment.QRCode, 0600); err != nil {
+				return errors.Wrap(err, "writing QR code")
+			}
+			fmt.Fprintf(os.Stderr, "Scan the QR code saved in %s with your authenticator app,\n", *qrFile)
+		} else {
+			fmt.Fprintln(os.Stderr, "Add this account to your authenticator app using the URI below,")
+		}
+		fmt.Fprintf(os.Stderr, "or enter the secret manually: %s\n\n", enrollment.Secret)
+		fmt.Println(enrollment.URI)
+		fmt.Fprintln(os.Stderr)
+		code, err := a.prompt("Code shown by the authenticator app")
+		if err != nil {
+			return err
+		}
+		codes, err := cli.ConfirmTwoFactor(ctx, code)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Two-factor authentication is now enabled.")
+		return a.printRecoveryCodes(codes)
+	case "disable":
+		code, err := a.prompt("Two-factor code")
+		if err != nil {
+			return err
+		}
+		return cli.DisableTwoFactor(ctx, code)
+	case "recovery-codes":
+		code, err := a.prompt("Two-factor code")
+		if err != nil {
+			return err
+		}
+		codes, err := cli.RegenerateRecoveryCodes(ctx, code)
+		if err != nil {
+			return err
+		}
+		return a.printRecoveryCodes(codes)
+	default:
+		fs.Usage()
+		return fmt.Errorf("2fa: unknown subcommand %q", sub)
+	}
+}
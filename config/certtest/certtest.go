@@ -0,0 +1,220 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package certtest provides a certificate authority issuing server and
+// client certificates, and revocation lists, for testing TLS client
+// authentication.
+package certtest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Certificate is a certificate issued by a CA, along with its key
+type Certificate struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// CertPEM returns the PEM encoded certificate
+func (c *Certificate) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// KeyPEM returns the PEM encoded private key
+func (c *Certificate) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// TLSCertificate returns the certificate and key, for use in a
+// tls.Config
+func (c *Certificate) TLSCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{c.Cert.Raw},
+		PrivateKey:  c.Key,
+		Leaf:        c.Cert,
+	}
+}
+
+// Fingerprint returns the hex encoded SHA-256 hash of the certificate
+func (c *Certificate) Fingerprint() string {
+	sum := sha256.Sum256(c.Cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteFiles writes the PEM encoded certificate and key to dir, as
+// <name>.pem and <name>-key.pem.
+func (c *Certificate) WriteFiles(dir, name string) (certFile, keyFile string, err error) {
+	keyPEM, err := c.KeyPEM()
+	if err != nil {
+		return "", "", err
+	}
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	if err := os.WriteFile(certFile, c.CertPEM(), 0o600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// CA is a certificate authority
+type CA struct {
+	Certificate
+
+	mux    sync.Mutex
+	serial int64
+}
+
+func newKey() (crypto.Signer, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// NewCA returns a new self signed CA
+func NewCA(name string) (*CA, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{
+		Certificate: Certificate{Cert: cert, Key: key},
+		serial:      1,
+	}, nil
+}
+
+// WriteCAFile writes the PEM encoded CA certificate to dir, as
+// <name>.pem.
+func (c *CA) WriteCAFile(dir, name string) (string, error) {
+	caFile := filepath.Join(dir, name+".pem")
+	if err := os.WriteFile(caFile, c.CertPEM(), 0o600); err != nil {
+		return "", err
+	}
+	return caFile, nil
+}
+
+func (c *CA) issue(template *x509.Certificate) (*Certificate, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	c.mux.Lock()
+	c.serial++
+	template.SerialNumber = big.NewInt(c.serial)
+	c.mux.Unlock()
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, c.Cert, key.Public(), c.Key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{Cert: cert, Key: key}, nil
+}
+
+// IssueServer returns a server certificate valid for hosts, which may be
+// IP addresses or DNS names.
+func (c *CA) IssueServer(hosts ...string) (*Certificate, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return c.issue(template)
+}
+
+// IssueClient returns a client certificate for commonName, holding the
+// given email addresses.
+func (c *CA) IssueClient(commonName string, emails ...string) (*Certificate, error) {
+	return c.issue(&x509.Certificate{
+		Subject:        pkix.Name{CommonName: commonName},
+		EmailAddresses: emails,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+// CRL returns a PEM encoded revocation list, signed by the CA, listing
+// the revoked certificates.
+func (c *CA) CRL(revoked ...*Certificate) ([]byte, error) {
+	return c.CRLUntil(time.Now().Add(time.Hour), revoked...)
+}
+
+// CRLUntil returns a PEM encoded revocation list, like CRL, that is due
+// to be updated at nextUpdate.
+func (c *CA) CRLUntil(nextUpdate time.Time, revoked ...*Certificate) ([]byte, error) {
+	template := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: nextUpdate.Add(-time.Hour - time.Minute),
+		NextUpdate: nextUpdate,
+	}
+	for _, cert := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   cert.Cert.SerialNumber,
+			RevocationTime: time.Now(),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
@@ -20,8 +20,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -56,6 +60,9 @@ func NewConfig(cfgFile string) (*Config, error) {
 type Config struct {
 	APIServer APIServer `toml:"apiserver" json:"apiserver"`
 	Database  Database  `toml:"database" json:"database"`
+	Mail      Mail      `toml:"mail" json:"mail"`
+	Security  Security  `toml:"security" json:"security"`
+	Audit     Audit     `toml:"audit" json:"audit"`
 }
 
 // Validate validates the config
@@ -66,6 +73,15 @@ func (c *Config) Validate() error {
 	if err := c.Database.Validate(); err != nil {
 		return errors.Wrap(err, "validating database config")
 	}
+	if err := c.Mail.Validate(); err != nil {
+		return errors.Wrap(err, "validating mail config")
+	}
+	if err := c.Security.Validate(); err != nil {
+		return errors.Wrap(err, "validating security config")
+	}
+	if err := c.Audit.Validate(); err != nil {
+		return errors.Wrap(err, "validating audit config")
+	}
 
 	return nil
 }
@@ -177,11 +193,71 @@ func (m *MySQL) ConnectionString() (string, error) {
 	return connString, nil
 }
 
+// ClientAuthMode selects whether the API server asks clients for a TLS
+// certificate
+type ClientAuthMode string
+
+const (
+	// ClientAuthNone does not ask clients for a certificate.
+	ClientAuthNone ClientAuthMode = "none"
+	// ClientAuthRequest asks clients for a certificate, but lets them
+	// connect without one. Certificates that are sent must be valid.
+	ClientAuthRequest ClientAuthMode = "request"
+	// ClientAuthRequire only lets clients with a valid certificate
+	// connect.
+	ClientAuthRequire ClientAuthMode = "require"
+)
+
+// ClientCertMapping selects how a client certificate is mapped to a user
+type ClientCertMapping string
+
+const (
+	// ClientCertMapFingerprint maps certificates to the user that
+	// registered their SHA-256 fingerprint.
+	ClientCertMapFingerprint ClientCertMapping = "fingerprint"
+	// ClientCertMapCommonName maps certificates to the user whose
+	// username is the common name of the subject.
+	ClientCertMapCommonName ClientCertMapping = "cn"
+	// ClientCertMapEmail maps certificates to the user whose email
+	// address is one of the email addresses in the certificate.
+	ClientCertMapEmail ClientCertMapping = "email"
+)
+
 // TLSConfig is the API server TLS config
 type TLSConfig struct {
 	CRT    string `toml:"certificate" json:"certificate"`
 	Key    string `toml:"key" json:"key"`
 	CACert string `toml:"ca_certificate" json:"ca-certificate"`
+	// ClientAuth is one of none, request or require. Defaults to none.
+	// Client certificates are verified against CACert.
+	ClientAuth ClientAuthMode `toml:"client_auth" json:"client-auth"`
+	// CRL is a PEM or DER encoded certificate revocation list, issued
+	// by the CA. Client certificates it lists are rejected. The file is
+	// read again when it changes, and must be refreshed before its next
+	// update, as all certificates of the CA are rejected once it expires.
+	CRL string `toml:"crl" json:"crl"`
+	// ClientCertMapping lists, in order, the ways a client certificate
+	// is mapped to a user: fingerprint, cn or email. Defaults to
+	// fingerprint.
+	ClientCertMapping []ClientCertMapping `toml:"client_cert_mapping" json:"client-cert-mapping"`
+	// ClientCertScopes are the scopes granted to certificates mapped by
+	// cn or email. Registered certificates use the scopes they were
+	// registered with. Defaults to the paste, share and teams scopes.
+	ClientCertScopes []string `toml:"client_cert_scopes" json:"client-cert-scopes"`
+}
+
+// ClientAuthEnabled returns true if clients may authenticate using a
+// TLS certificate
+func (t *TLSConfig) ClientAuthEnabled() bool {
+	return t.ClientAuth == ClientAuthRequest || t.ClientAuth == ClientAuthRequire
+}
+
+// UserMapping returns the ways client certificates are mapped to users
+func (t *TLSConfig) UserMapping() []ClientCertMapping {
+	if len(t.ClientCertMapping) == 0 {
+		return []ClientCertMapping{ClientCertMapFingerprint}
+	}
+	return t.ClientCertMapping
 }
 
 // TLSConfig returns a new TLSConfig suitable for use in the
@@ -209,14 +285,45 @@ func (t *TLSConfig) TLSConfig() (*tls.Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &tls.Config{
+	tlsCfg := &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		ClientCAs:    roots,
-	}, nil
+	}
+	switch t.ClientAuth {
+	case "", ClientAuthNone:
+		return tlsCfg, nil
+	case ClientAuthRequest:
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid client_auth %q", t.ClientAuth)
+	}
+	if roots == nil {
+		return nil, fmt.Errorf("ca_certificate is mandatory when client_auth is %s", t.ClientAuth)
+	}
+	if t.CRL != "" {
+		crl, err := newCRLChecker(t.CRL)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.VerifyConnection = crl.verifyConnection
+	}
+	return tlsCfg, nil
 }
 
 // Validate validates the TLS config
 func (t *TLSConfig) Validate() error {
+	if t.CRL != "" && !t.ClientAuthEnabled() {
+		return fmt.Errorf("crl can only be used with client_auth")
+	}
+	for _, mapping := range t.ClientCertMapping {
+		switch mapping {
+		case ClientCertMapFingerprint, ClientCertMapCommonName, ClientCertMapEmail:
+		default:
+			return fmt.Errorf("invalid client_cert_mapping %q", mapping)
+		}
+	}
 	if _, err := t.TLSConfig(); err != nil {
 		return err
 	}
@@ -243,10 +350,42 @@ func (d *timeToLive) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// JWTAlgorithm is the algorithm used to sign login tokens
+type JWTAlgorithm string
+
+const (
+	// JWTAlgorithmHS256 signs tokens with a shared HMAC key. Other
+	// services can not verify these tokens without knowing the key.
+	JWTAlgorithmHS256 JWTAlgorithm = "HS256"
+	// JWTAlgorithmEdDSA signs tokens with an Ed25519 key.
+	JWTAlgorithmEdDSA JWTAlgorithm = "EdDSA"
+	// JWTAlgorithmRS256 signs tokens with a 2048 bit RSA key.
+	JWTAlgorithmRS256 JWTAlgorithm = "RS256"
+)
+
+// DefaultJWTKeyRotationInterval is how long a signing key is used
+// before a new one replaces it.
+const DefaultJWTKeyRotationInterval = 30 * 24 * time.Hour
+
 // JWTAuth holds settings used to generate JWT tokens
 type JWTAuth struct {
+	// Secret is used to derive the keys that sign the short lived tokens
+	// used during two factor login, password resets, email changes and
+	// OIDC logins. Login tokens issued before signing keys were
+	// introduced are also verified with it, until they expire.
 	Secret     string     `toml:"secret" json:"secret"`
 	TimeToLive timeToLive `toml:"time_to_live" json:"time-to-live"`
+	// Algorithm used to sign login tokens. One of HS256, EdDSA or RS256.
+	// Defaults to HS256.
+	Algorithm JWTAlgorithm `toml:"algorithm" json:"algorithm"`
+	// KeyRotationInterval is how long a signing key is used before it
+	// is replaced. Defaults to 720h.
+	KeyRotationInterval string `toml:"key_rotation_interval" json:"key-rotation-interval"`
+}
+
+// KeyRotationDuration returns the configured key rotation interval
+func (j *JWTAuth) KeyRotationDuration() time.Duration {
+	return durationOrDefault(j.KeyRotationInterval, DefaultJWTKeyRotationInterval)
 }
 
 // Validate validates the JWTAuth config
@@ -258,6 +397,22 @@ func (j *JWTAuth) Validate() error {
 	if j.Secret == "" {
 		return fmt.Errorf("invalid JWT secret")
 	}
+	switch j.Algorithm {
+	case "":
+		j.Algorithm = JWTAlgorithmHS256
+	case JWTAlgorithmHS256, JWTAlgorithmEdDSA, JWTAlgorithmRS256:
+	default:
+		return fmt.Errorf("invalid JWT algorithm %q", j.Algorithm)
+	}
+	if j.KeyRotationInterval != "" {
+		interval, err := time.ParseDuration(j.KeyRotationInterval)
+		if err != nil {
+			return errors.Wrap(err, "parsing key_rotation_interval")
+		}
+		if interval < time.Hour {
+			return fmt.Errorf("key_rotation_interval must be at least 1h")
+		}
+	}
 	return nil
 }
 
@@ -270,6 +425,33 @@ type APIServer struct {
 	JWTAuth     JWTAuth   `toml:"jwt_auth" json:"jwt-auth"`
 	TLSConfig   TLSConfig `toml:"tls" json:"tls"`
 	CORSOrigins []string  `toml:"cors_origins" json:"cors-origins"`
+	OIDC        OIDC      `toml:"oidc" json:"oidc"`
+	LDAP        LDAP      `toml:"ldap" json:"ldap"`
+	TwoFactor   TwoFactor `toml:"two_factor" json:"two-factor"`
+	// LoginThrottle protects password logins against guessing.
+	LoginThrottle LoginThrottle `toml:"login_throttle" json:"login-throttle"`
+	// Registration controls whether users may create their own accounts.
+	Registration Registration `toml:"registration" json:"registration"`
+	// ProxyAuth trusts users authenticated by a reverse proxy.
+	ProxyAuth ProxyAuth `toml:"proxy_auth" json:"proxy-auth"`
+	// SessionCookie lets browsers keep their login token in a cookie.
+	SessionCookie SessionCookie `toml:"session_cookie" json:"session-cookie"`
+	// TrustedProxies lists the networks, in CIDR notation, of the reverse
+	// proxies in front of gopherbin. The client address of requests
+	// coming from them is read from the X-Forwarded-For or X-Real-IP
+	// headers.
+	TrustedProxies []string `toml:"trusted_proxies" json:"trusted-proxies"`
+}
+
+// ForwardingProxies returns the networks of the proxies trusted to pass
+// the address of the client. Proxies trusted to authenticate users are
+// trusted to pass the address of the client as well.
+func (a *APIServer) ForwardingProxies() ([]*net.IPNet, error) {
+	cidrs := a.TrustedProxies
+	if a.ProxyAuth.Enable {
+		cidrs = append(append([]string(nil), cidrs...), a.ProxyAuth.TrustedProxies...)
+	}
+	return parseNetworks(cidrs)
 }
 
 // Validate validates the API server config
@@ -286,6 +468,30 @@ func (a *APIServer) Validate() error {
 	if err := a.JWTAuth.Validate(); err != nil {
 		return errors.Wrap(err, "validating jwt config")
 	}
+	if err := a.OIDC.Validate(); err != nil {
+		return errors.Wrap(err, "validating oidc config")
+	}
+	if err := a.LDAP.Validate(); err != nil {
+		return errors.Wrap(err, "validating ldap config")
+	}
+	if err := a.TwoFactor.Validate(); err != nil {
+		return errors.Wrap(err, "validating two-factor config")
+	}
+	if err := a.LoginThrottle.Validate(); err != nil {
+		return errors.Wrap(err, "validating login throttle config")
+	}
+	if err := a.Registration.Validate(); err != nil {
+		return errors.Wrap(err, "validating registration config")
+	}
+	if err := a.ProxyAuth.Validate(); err != nil {
+		return errors.Wrap(err, "validating proxy auth config")
+	}
+	if err := a.SessionCookie.Validate(); err != nil {
+		return errors.Wrap(err, "validating session cookie config")
+	}
+	if _, err := parseNetworks(a.TrustedProxies); err != nil {
+		return err
+	}
 	ip := net.ParseIP(a.Bind)
 	if ip == nil {
 		// No need for deeper validation here, as any invalid
@@ -295,3 +501,744 @@ func (a *APIServer) Validate() error {
 	}
 	return nil
 }
+
+// OIDC holds settings for OpenID Connect single sign-on
+type OIDC struct {
+	Enable bool `toml:"enable" json:"enable"`
+	// IssuerURL is the URL of the identity provider. The provider
+	// configuration is discovered from
+	// <issuer_url>/.well-known/openid-configuration
+	IssuerURL    string `toml:"issuer_url" json:"issuer-url"`
+	ClientID     string `toml:"client_id" json:"client-id"`
+	ClientSecret string `toml:"client_secret" json:"client-secret"`
+	// RedirectURL is the URL of the gopherbin callback endpoint as
+	// reachable by browsers, for example:
+	// https://paste.example.com/api/v1/auth/oidc/callback
+	RedirectURL string `toml:"redirect_url" json:"redirect-url"`
+	// Scopes requested from the identity provider, in addition to openid.
+	// Defaults to profile and email.
+	Scopes []string `toml:"scopes" json:"scopes"`
+
+	// UsernameClaim is the claim used as gopherbin username. Defaults to
+	// preferred_username. If the claim holds an email address, only the
+	// part before the @ is used. Characters that are not allowed in
+	// usernames are dropped. If the claim is missing, the username is
+	// derived from the email address.
+	UsernameClaim string `toml:"username_claim" json:"username-claim"`
+	// EmailClaim is the claim holding the email address. Defaults to email.
+	EmailClaim string `toml:"email_claim" json:"email-claim"`
+	// FullNameClaim is the claim holding the full name. Defaults to name.
+	FullNameClaim string `toml:"full_name_claim" json:"full-name-claim"`
+	// AllowedEmailDomains limits logins to users with an email address in
+	// one of these domains. All domains are allowed if empty.
+	AllowedEmailDomains []string `toml:"allowed_email_domains" json:"allowed-email-domains"`
+	// LinkByEmail allows users logging in for the first time to be linked
+	// to an existing gopherbin account with the same, verified, email
+	// address. If disabled, such logins are rejected.
+	LinkByEmail bool `toml:"link_by_email" json:"link-by-email"`
+
+	// GroupsClaim is the claim holding the groups of the user. Defaults
+	// to groups.
+	GroupsClaim string `toml:"groups_claim" json:"groups-claim"`
+	// AdminGroups lists the groups whose members are gopherbin admins.
+	// When set, the admin flag of users logging in through OIDC is
+	// updated on every login. When empty, it is left untouched.
+	AdminGroups []string `toml:"admin_groups" json:"admin-groups"`
+}
+
+// Validate validates the OIDC config
+func (o *OIDC) Validate() error {
+	if !o.Enable {
+		return nil
+	}
+	if o.IssuerURL == "" || o.ClientID == "" || o.RedirectURL == "" {
+		return fmt.Errorf("issuer_url, client_id and redirect_url are mandatory when oidc is enabled")
+	}
+	for _, val := range []string{o.IssuerURL, o.RedirectURL} {
+		parsed, err := url.Parse(val)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("invalid URL %q", val)
+		}
+	}
+	if o.UsernameClaim == "" {
+		o.UsernameClaim = "preferred_username"
+	}
+	if o.EmailClaim == "" {
+		o.EmailClaim = "email"
+	}
+	if o.FullNameClaim == "" {
+		o.FullNameClaim = "name"
+	}
+	if o.GroupsClaim == "" {
+		o.GroupsClaim = "groups"
+	}
+	if len(o.Scopes) == 0 {
+		o.Scopes = []string{"profile", "email"}
+	}
+	return nil
+}
+
+// DefaultLDAPTimeout is the default timeout for LDAP operations
+const DefaultLDAPTimeout = 10 * time.Second
+
+// LDAP holds settings for authenticating users against an LDAP server or
+// Active Directory.
+type LDAP struct {
+	Enable bool `toml:"enable" json:"enable"`
+	// URL of the LDAP server, for example ldaps://ldap.example.com:636
+	URL string `toml:"url" json:"url"`
+	// StartTLS upgrades ldap:// connections to TLS.
+	StartTLS bool `toml:"start_tls" json:"start-tls"`
+	// CACertificate is the CA bundle used to verify the server
+	// certificate. The system roots are used if empty.
+	CACertificate      string `toml:"ca_certificate" json:"ca-certificate"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify" json:"insecure-skip-verify"`
+	// Timeout for connecting to the server and for every request.
+	// Defaults to 10s.
+	Timeout string `toml:"timeout" json:"timeout"`
+
+	// BindDN and BindPassword are the credentials of a service account
+	// used to search for users. If BindDN is empty, UserDNTemplate is
+	// used to bind as the user directly.
+	BindDN       string `toml:"bind_dn" json:"bind-dn"`
+	BindPassword string `toml:"bind_password" json:"bind-password"`
+	// UserDNTemplate is the DN of a user, with %s in place of the
+	// username, for example uid=%s,ou=people,dc=example,dc=com
+	UserDNTemplate string `toml:"user_dn_template" json:"user-dn-template"`
+	// UserBaseDN is the DN users are searched under.
+	UserBaseDN string `toml:"user_base_dn" json:"user-base-dn"`
+	// UserFilter is the filter used to find users, with %s in place of
+	// the username. Defaults to (uid=%s). For Active Directory, use
+	// (sAMAccountName=%s). To also allow logging in with an email
+	// address, use (|(uid=%s)(mail=%s)).
+	UserFilter string `toml:"user_filter" json:"user-filter"`
+	// DisabledFilter, if set, matches users that are disabled in the
+	// directory. Their gopherbin account is disabled as well. For Active
+	// Directory, use (userAccountControl:1.2.840.113556.1.4.803:=2).
+	DisabledFilter string `toml:"disabled_filter" json:"disabled-filter"`
+
+	// UsernameAttribute defaults to uid.
+	UsernameAttribute string `toml:"username_attribute" json:"username-attribute"`
+	// EmailAttribute defaults to mail.
+	EmailAttribute string `toml:"email_attribute" json:"email-attribute"`
+	// FullNameAttribute defaults to cn.
+	FullNameAttribute string `toml:"full_name_attribute" json:"full-name-attribute"`
+	// IDAttribute is an attribute that uniquely identifies a user and
+	// never changes, like entryUUID. Defaults to the DN of the user.
+	IDAttribute string `toml:"id_attribute" json:"id-attribute"`
+
+	// GroupBaseDN is the DN groups are searched under. Groups are only
+	// looked up if set.
+	GroupBaseDN string `toml:"group_base_dn" json:"group-base-dn"`
+	// GroupFilter is the filter used to find the groups of a user, with
+	// %s in place of the DN of the user. Defaults to (member=%s).
+	GroupFilter string `toml:"group_filter" json:"group-filter"`
+	// GroupNameAttribute defaults to cn.
+	GroupNameAttribute string `toml:"group_name_attribute" json:"group-name-attribute"`
+	// AdminGroups lists the groups whose members are gopherbin admins.
+	// When set, the admin flag of LDAP users is updated on every login.
+	AdminGroups []string `toml:"admin_groups" json:"admin-groups"`
+	// TeamGroups maps group names to gopherbin teams. Members of a group
+	// are added to the team when they log in, and users that left the
+	// group are removed from it. Missing teams are created, owned by the
+	// super user.
+	TeamGroups map[string]string `toml:"team_groups" json:"team-groups"`
+}
+
+// TimeoutDuration returns the LDAP timeout
+func (l *LDAP) TimeoutDuration() time.Duration {
+	timeout, err := time.ParseDuration(l.Timeout)
+	if err != nil || timeout <= 0 {
+		return DefaultLDAPTimeout
+	}
+	return timeout
+}
+
+// Validate validates the LDAP config
+func (l *LDAP) Validate() error {
+	if !l.Enable {
+		return nil
+	}
+	parsed, err := url.Parse(l.URL)
+	if err != nil || (parsed.Scheme != "ldap" && parsed.Scheme != "ldaps") || parsed.Host == "" {
+		return fmt.Errorf("invalid LDAP URL %q", l.URL)
+	}
+	if l.StartTLS && parsed.Scheme == "ldaps" {
+		return fmt.Errorf("start_tls can not be used with ldaps://")
+	}
+	if l.Timeout != "" {
+		if _, err := time.ParseDuration(l.Timeout); err != nil {
+			return errors.Wrap(err, "parsing timeout")
+		}
+	}
+	if l.BindDN == "" {
+		if !strings.Contains(l.UserDNTemplate, "%s") {
+			return fmt.Errorf("user_dn_template must contain %%s when bind_dn is not set")
+		}
+	} else if l.UserBaseDN == "" {
+		return fmt.Errorf("user_base_dn is mandatory when bind_dn is set")
+	}
+	if l.UserFilter == "" {
+		l.UserFilter = "(uid=%s)"
+	}
+	if !strings.Contains(l.UserFilter, "%s") {
+		return fmt.Errorf("user_filter must contain %%s")
+	}
+	if l.GroupFilter == "" {
+		l.GroupFilter = "(member=%s)"
+	}
+	if !strings.Contains(l.GroupFilter, "%s") {
+		return fmt.Errorf("group_filter must contain %%s")
+	}
+	if l.GroupBaseDN == "" && (len(l.AdminGroups) > 0 || len(l.TeamGroups) > 0) {
+		return fmt.Errorf("group_base_dn is mandatory when using admin_groups or team_groups")
+	}
+	for group, team := range l.TeamGroups {
+		if team == "" || len(team) > 32 {
+			return fmt.Errorf("invalid team name %q for group %q", team, group)
+		}
+	}
+	if l.UsernameAttribute == "" {
+		l.UsernameAttribute = "uid"
+	}
+	if l.EmailAttribute == "" {
+		l.EmailAttribute = "mail"
+	}
+	if l.FullNameAttribute == "" {
+		l.FullNameAttribute = "cn"
+	}
+	if l.GroupNameAttribute == "" {
+		l.GroupNameAttribute = "cn"
+	}
+	return nil
+}
+
+// DefaultTwoFactorIssuer is the default name under which gopherbin
+// accounts are shown in authenticator apps
+const DefaultTwoFactorIssuer = "Gopherbin"
+
+// TwoFactor holds settings for TOTP two-factor authentication
+type TwoFactor struct {
+	// Issuer is the name under which accounts are shown in
+	// authenticator apps.
+	Issuer string `toml:"issuer" json:"issuer"`
+	// RequireForAdmins requires admins to enable two-factor
+	// authentication. Until they do, logging in with a password only
+	// grants the account scope, which allows enrolling an authenticator.
+	RequireForAdmins bool `toml:"require_for_admins" json:"require-for-admins"`
+}
+
+// Validate validates the two-factor config and sets defaults
+func (t *TwoFactor) Validate() error {
+	if t.Issuer == "" {
+		t.Issuer = DefaultTwoFactorIssuer
+	}
+	if strings.Contains(t.Issuer, ":") {
+		return fmt.Errorf("issuer may not contain a colon")
+	}
+	return nil
+}
+
+// Defaults for the login throttle settings
+const (
+	DefaultLoginFreeAttempts       = 3
+	DefaultLoginBaseDelay          = time.Second
+	DefaultLoginMaxDelay           = 5 * time.Minute
+	DefaultLoginLockoutThreshold   = 10
+	DefaultLoginLockoutDuration    = 30 * time.Minute
+	DefaultLoginIPLockoutThreshold = 100
+	DefaultLoginResetAfter         = 24 * time.Hour
+)
+
+// LoginThrottle holds settings protecting password logins against
+// guessing. Failed logins are counted per account and per client IP
+// address. Once the free attempts of an account are used up, every
+// further failure doubles the time before it may try again.
+type LoginThrottle struct {
+	Disable bool `toml:"disable" json:"disable"`
+	// FreeAttempts is the number of failed logins allowed for an
+	// account before it has to wait between attempts. Defaults to 3.
+	FreeAttempts int `toml:"free_attempts" json:"free-attempts"`
+	// BaseDelay is the wait time after the first failure past the free
+	// attempts. Defaults to 1s.
+	BaseDelay string `toml:"base_delay" json:"base-delay"`
+	// MaxDelay caps the wait time between attempts. Defaults to 5m.
+	MaxDelay string `toml:"max_delay" json:"max-delay"`
+	// LockoutThreshold is the number of failed logins after which an
+	// account is locked. Defaults to 10.
+	LockoutThreshold int `toml:"lockout_threshold" json:"lockout-threshold"`
+	// LockoutDuration is how long accounts and addresses stay locked,
+	// unless an admin unlocks them. Defaults to 30m.
+	LockoutDuration string `toml:"lockout_duration" json:"lockout-duration"`
+	// IPLockoutThreshold is the number of failed logins from a single
+	// address, for any account, after which the address is locked out.
+	// Defaults to 100.
+	IPLockoutThreshold int `toml:"ip_lockout_threshold" json:"ip-lockout-threshold"`
+	// ResetAfter is how long failed logins are remembered. Defaults
+	// to 24h.
+	ResetAfter string `toml:"reset_after" json:"reset-after"`
+}
+
+func durationOrDefault(value string, def time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return def
+	}
+	return duration
+}
+
+// BaseDelayDuration returns the wait time after the first throttled failure
+func (l *LoginThrottle) BaseDelayDuration() time.Duration {
+	return durationOrDefault(l.BaseDelay, DefaultLoginBaseDelay)
+}
+
+// MaxDelayDuration returns the maximum wait time between attempts
+func (l *LoginThrottle) MaxDelayDuration() time.Duration {
+	return durationOrDefault(l.MaxDelay, DefaultLoginMaxDelay)
+}
+
+// LockoutPeriod returns how long lockouts last
+func (l *LoginThrottle) LockoutPeriod() time.Duration {
+	return durationOrDefault(l.LockoutDuration, DefaultLoginLockoutDuration)
+}
+
+// ResetAfterDuration returns how long failed logins are remembered
+func (l *LoginThrottle) ResetAfterDuration() time.Duration {
+	return durationOrDefault(l.ResetAfter, DefaultLoginResetAfter)
+}
+
+// Validate validates the login throttle config and sets defaults
+func (l *LoginThrottle) Validate() error {
+	durations := map[string]string{
+		"base_delay":       l.BaseDelay,
+		"max_delay":        l.MaxDelay,
+		"lockout_duration": l.LockoutDuration,
+		"reset_after":      l.ResetAfter,
+	}
+	for name, value := range durations {
+		if value == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(value); err != nil || duration <= 0 {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	if l.FreeAttempts < 0 || l.LockoutThreshold < 0 || l.IPLockoutThreshold < 0 {
+		return fmt.Errorf("attempt counts may not be negative")
+	}
+	if l.FreeAttempts == 0 {
+		l.FreeAttempts = DefaultLoginFreeAttempts
+	}
+	if l.LockoutThreshold == 0 {
+		l.LockoutThreshold = DefaultLoginLockoutThreshold
+	}
+	if l.IPLockoutThreshold == 0 {
+		l.IPLockoutThreshold = DefaultLoginIPLockoutThreshold
+	}
+	if l.MaxDelayDuration() < l.BaseDelayDuration() {
+		return fmt.Errorf("max_delay may not be shorter than base_delay")
+	}
+	return nil
+}
+
+// RegistrationMode selects whether, and how, users may register their
+// own accounts
+type RegistrationMode string
+
+const (
+	// RegistrationDisabled only allows admins to create accounts.
+	RegistrationDisabled RegistrationMode = "disabled"
+	// RegistrationInvite requires an invite code issued by an admin.
+	RegistrationInvite RegistrationMode = "invite"
+	// RegistrationOpen allows anyone to register. Accounts registered
+	// without an invite code stay disabled until an admin approves them.
+	RegistrationOpen RegistrationMode = "open"
+)
+
+// Registration holds settings for user self-registration
+type Registration struct {
+	// Mode is one of disabled, invite or open. Defaults to disabled.
+	Mode RegistrationMode `toml:"mode" json:"mode"`
+}
+
+// Enabled returns true if users may register their own accounts
+func (r *Registration) Enabled() bool {
+	return r.Mode == RegistrationInvite || r.Mode == RegistrationOpen
+}
+
+// Validate validates the registration config and sets defaults
+func (r *Registration) Validate() error {
+	switch r.Mode {
+	case "":
+		r.Mode = RegistrationDisabled
+	case RegistrationDisabled, RegistrationInvite, RegistrationOpen:
+	default:
+		return fmt.Errorf("invalid registration mode %q", r.Mode)
+	}
+	return nil
+}
+
+// Default headers set by authenticating reverse proxies
+const (
+	DefaultProxyUserHeader  = "X-Forwarded-User"
+	DefaultProxyEmailHeader = "X-Forwarded-Email"
+)
+
+// ProxyAuth holds settings for trusting users authenticated by a reverse
+// proxy, such as oauth2-proxy, which passes the identity of the user in
+// request headers.
+type ProxyAuth struct {
+	Enable bool `toml:"enable" json:"enable"`
+	// TrustedProxies lists the networks, in CIDR notation, the proxy
+	// connects from. The headers of requests coming from any other
+	// address are ignored.
+	TrustedProxies []string `toml:"trusted_proxies" json:"trusted-proxies"`
+	// UserHeader holds the ID of the user, as known to the proxy.
+	// Defaults to X-Forwarded-User.
+	UserHeader string `toml:"user_header" json:"user-header"`
+	// EmailHeader holds the email address of the user. Defaults to
+	// X-Forwarded-Email.
+	EmailHeader string `toml:"email_header" json:"email-header"`
+	// AutoProvision creates an account for users seen for the first
+	// time. When disabled, they are only let in if an account with their
+	// email address already exists.
+	AutoProvision bool `toml:"auto_provision" json:"auto-provision"`
+}
+
+// TrustedNetworks returns the parsed TrustedProxies
+func (p *ProxyAuth) TrustedNetworks() ([]*net.IPNet, error) {
+	return parseNetworks(p.TrustedProxies)
+}
+
+// parseNetworks parses a list of trusted proxy networks in CIDR notation
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network %q", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Validate validates the proxy auth config and sets defaults
+func (p *ProxyAuth) Validate() error {
+	if !p.Enable {
+		return nil
+	}
+	if len(p.TrustedProxies) == 0 {
+		return fmt.Errorf("trusted_proxies is mandatory when proxy auth is enabled")
+	}
+	if _, err := p.TrustedNetworks(); err != nil {
+		return err
+	}
+	if p.UserHeader == "" {
+		p.UserHeader = DefaultProxyUserHeader
+	}
+	if p.EmailHeader == "" {
+		p.EmailHeader = DefaultProxyEmailHeader
+	}
+	return nil
+}
+
+// SameSiteMode is the SameSite attribute of the session cookie
+type SameSiteMode string
+
+const (
+	// SameSiteStrict never sends the session cookie with cross-site
+	// requests. This is the default.
+	SameSiteStrict SameSiteMode = "strict"
+	// SameSiteLax sends the session cookie with top level cross-site
+	// navigations, such as following a link to a paste.
+	SameSiteLax SameSiteMode = "lax"
+)
+
+// SessionCookie holds settings for browser sessions kept in an HttpOnly,
+// Secure cookie instead of in storage readable by scripts. State changing
+// requests made with the cookie must carry a CSRF token.
+type SessionCookie struct {
+	Enable bool `toml:"enable" json:"enable"`
+	// SameSite is either strict or lax. Defaults to strict.
+	SameSite SameSiteMode `toml:"same_site" json:"same-site"`
+}
+
+// SameSiteMode returns the SameSite attribute to set on session cookies
+func (s *SessionCookie) SameSiteMode() http.SameSite {
+	if s.SameSite == SameSiteLax {
+		return http.SameSiteLaxMode
+	}
+	return http.SameSiteStrictMode
+}
+
+// Validate validates the session cookie config and sets defaults
+func (s *SessionCookie) Validate() error {
+	switch s.SameSite {
+	case "":
+		s.SameSite = SameSiteStrict
+	case SameSiteStrict, SameSiteLax:
+	default:
+		return fmt.Errorf("invalid same_site %q", s.SameSite)
+	}
+	return nil
+}
+
+// MailTLSMode selects how connections to the SMTP server are secured
+type MailTLSMode string
+
+const (
+	// MailTLSStartTLS upgrades plain connections using STARTTLS
+	MailTLSStartTLS MailTLSMode = "starttls"
+	// MailTLSImplicit connects using TLS, usually to port 465
+	MailTLSImplicit MailTLSMode = "tls"
+	// MailTLSNone sends mail without encryption. Only use it with
+	// a local relay.
+	MailTLSNone MailTLSMode = "none"
+)
+
+// Defaults for the mail settings
+const (
+	DefaultMailPort             = 587
+	DefaultMailTimeout          = 10 * time.Second
+	DefaultPasswordResetTimeout = time.Hour
+	DefaultEmailChangeTimeout   = 24 * time.Hour
+)
+
+// Mail holds the settings of the SMTP server used to send emails, such
+// as password reset links.
+type Mail struct {
+	Enable bool   `toml:"enable" json:"enable"`
+	Host   string `toml:"host" json:"host"`
+	// Port defaults to 587.
+	Port     int    `toml:"port" json:"port"`
+	Username string `toml:"username" json:"username"`
+	Password string `toml:"password" json:"password"`
+	// From is the sender address, for example
+	// "Gopherbin <gopherbin@example.com>".
+	From string `toml:"from" json:"from"`
+	// TLS is one of starttls, tls or none. Defaults to starttls.
+	TLS MailTLSMode `toml:"tls" json:"tls"`
+	// CACertificate is the CA bundle used to verify the server
+	// certificate. The system roots are used if empty.
+	CACertificate      string `toml:"ca_certificate" json:"ca-certificate"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify" json:"insecure-skip-verify"`
+	// Timeout for delivering a message. Defaults to 10s.
+	Timeout string `toml:"timeout" json:"timeout"`
+	// BaseURL is the URL users open gopherbin at. Links sent by email
+	// point to it.
+	BaseURL string `toml:"base_url" json:"base-url"`
+	// PasswordResetTimeout is how long password reset links are valid
+	// for. Defaults to 1h.
+	PasswordResetTimeout string `toml:"password_reset_timeout" json:"password-reset-timeout"`
+	// ConfirmEmailChange sends a confirmation link to the new address of
+	// users changing their email address. The address only changes once
+	// the link is opened.
+	ConfirmEmailChange bool `toml:"confirm_email_change" json:"confirm-email-change"`
+	// EmailChangeTimeout is how long email confirmation links are valid
+	// for. Defaults to 24h.
+	EmailChangeTimeout string `toml:"email_change_timeout" json:"email-change-timeout"`
+}
+
+// TimeoutDuration returns the timeout for delivering a message
+func (m *Mail) TimeoutDuration() time.Duration {
+	return durationOrDefault(m.Timeout, DefaultMailTimeout)
+}
+
+// PasswordResetTimeoutDuration returns how long password reset links
+// are valid for
+func (m *Mail) PasswordResetTimeoutDuration() time.Duration {
+	return durationOrDefault(m.PasswordResetTimeout, DefaultPasswordResetTimeout)
+}
+
+// EmailChangeTimeoutDuration returns how long email confirmation links
+// are valid for
+func (m *Mail) EmailChangeTimeoutDuration() time.Duration {
+	return durationOrDefault(m.EmailChangeTimeout, DefaultEmailChangeTimeout)
+}
+
+// Validate validates the mail config and sets defaults
+func (m *Mail) Validate() error {
+	if !m.Enable {
+		if m.ConfirmEmailChange {
+			return fmt.Errorf("confirm_email_change requires mail to be enabled")
+		}
+		return nil
+	}
+	if m.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	if m.Port == 0 {
+		m.Port = DefaultMailPort
+	}
+	if m.Port < 1 || m.Port > 65535 {
+		return fmt.Errorf("invalid port nr %d", m.Port)
+	}
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		return errors.Wrap(err, "parsing from address")
+	}
+	switch m.TLS {
+	case "":
+		m.TLS = MailTLSStartTLS
+	case MailTLSStartTLS, MailTLSImplicit, MailTLSNone:
+	default:
+		return fmt.Errorf("invalid tls mode %q", m.TLS)
+	}
+	for name, value := range map[string]string{"timeout": m.Timeout, "password_reset_timeout": m.PasswordResetTimeout, "email_change_timeout": m.EmailChangeTimeout} {
+		if value == "" {
+			continue
+		}
+		if duration, err := time.ParseDuration(value); err != nil || duration <= 0 {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	parsed, err := url.Parse(m.BaseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid base_url %q", m.BaseURL)
+	}
+	return nil
+}
+
+// Defaults for the password policy
+const (
+	DefaultPasswordMinScore  = 4
+	DefaultPasswordMinLength = 8
+)
+
+// Security holds settings that apply to all accounts
+type Security struct {
+	PasswordPolicy PasswordPolicy `toml:"password_policy" json:"password-policy"`
+}
+
+// Validate validates the security config and sets defaults
+func (s *Security) Validate() error {
+	if err := s.PasswordPolicy.Validate(); err != nil {
+		return errors.Wrap(err, "validating password policy")
+	}
+	return nil
+}
+
+// PasswordPolicy holds the rules new passwords must follow. They apply
+// to the password of the super user, to users created by admins or
+// registering themselves, and to changed passwords.
+type PasswordPolicy struct {
+	// MinScore is the minimum zxcvbn strength score, from 0 to 4.
+	// Defaults to 4.
+	MinScore *int `toml:"min_score" json:"min-score"`
+	// MinLength is the minimum number of characters. Defaults to 8.
+	MinLength int `toml:"min_length" json:"min-length"`
+	// BreachedPasswordsFile is a list of SHA-1 hashes of breached
+	// passwords, one upper or lower case hex encoded hash per line,
+	// sorted by hash. Anything following the hash on a line, such as
+	// the ":count" suffix of the Have I Been Pwned downloads ordered by
+	// hash, is ignored. Passwords in the list are refused.
+	BreachedPasswordsFile string `toml:"breached_passwords_file" json:"breached-passwords-file"`
+}
+
+// MinimumScore returns the minimum zxcvbn strength score
+func (p *PasswordPolicy) MinimumScore() int {
+	if p.MinScore == nil {
+		return DefaultPasswordMinScore
+	}
+	return *p.MinScore
+}
+
+// MinimumLength returns the minimum number of characters
+func (p *PasswordPolicy) MinimumLength() int {
+	if p.MinLength == 0 {
+		return DefaultPasswordMinLength
+	}
+	return p.MinLength
+}
+
+// Validate validates the password policy and sets defaults
+func (p *PasswordPolicy) Validate() error {
+	if score := p.MinimumScore(); score < 0 || score > 4 {
+		return fmt.Errorf("min_score must be between 0 and 4")
+	}
+	if p.MinLength < 0 {
+		return fmt.Errorf("min_length may not be negative")
+	}
+	if p.MinLength == 0 {
+		p.MinLength = DefaultPasswordMinLength
+	}
+	if p.BreachedPasswordsFile != "" {
+		if _, err := os.Stat(p.BreachedPasswordsFile); err != nil {
+			return errors.Wrap(err, "checking breached_passwords_file")
+		}
+	}
+	return nil
+}
+
+// Defaults for the audit log settings
+const (
+	DefaultAuditSyslogTag      = "gopherbin"
+	DefaultAuditSyslogFacility = "auth"
+)
+
+// auditSyslogFacilities lists the syslog facilities audit events may be
+// forwarded with
+var auditSyslogFacilities = []string{
+	"auth", "authpriv", "daemon", "user",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// Audit holds settings for the audit log. Audited actions are always
+// stored in the database, and may also be forwarded to syslog.
+type Audit struct {
+	Syslog AuditSyslog `toml:"syslog" json:"syslog"`
+}
+
+// Validate validates the audit config and sets defaults
+func (a *Audit) Validate() error {
+	if err := a.Syslog.Validate(); err != nil {
+		return errors.Wrap(err, "validating syslog config")
+	}
+	return nil
+}
+
+// AuditSyslog holds settings for forwarding audit events to syslog
+type AuditSyslog struct {
+	Enable bool `toml:"enable" json:"enable"`
+	// Network is one of udp, tcp or unix. Events are sent to the local
+	// syslog daemon if Network and Address are empty.
+	Network string `toml:"network" json:"network"`
+	Address string `toml:"address" json:"address"`
+	// Tag is the program name events are tagged with. Defaults to
+	// gopherbin.
+	Tag string `toml:"tag" json:"tag"`
+	// Facility is one of auth, authpriv, daemon, user or local0 to
+	// local7. Defaults to auth.
+	Facility string `toml:"facility" json:"facility"`
+}
+
+// Validate validates the syslog config and sets defaults
+func (s *AuditSyslog) Validate() error {
+	if !s.Enable {
+		return nil
+	}
+	switch s.Network {
+	case "":
+		if s.Address != "" {
+			return fmt.Errorf("network is mandatory when address is set")
+		}
+	case "udp", "tcp", "unix":
+		if s.Address == "" {
+			return fmt.Errorf("address is mandatory when network is set")
+		}
+	default:
+		return fmt.Errorf("invalid network %q", s.Network)
+	}
+	if s.Tag == "" {
+		s.Tag = DefaultAuditSyslogTag
+	}
+	if s.Facility == "" {
+		s.Facility = DefaultAuditSyslogFacility
+	}
+	for _, facility := range auditSyslogFacilities {
+		if s.Facility == facility {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid facility %q", s.Facility)
+}
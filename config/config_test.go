@@ -1,12 +1,18 @@
 package config_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"gopherbin/config"
+	"gopherbin/config/certtest"
 )
 
 // ── helpers ───────────────────────────────────────────────────────────────────
@@ -175,6 +181,44 @@ func TestJWTAuth_Validate_SetsDefaultTTL(t *testing.T) {
 	}
 }
 
+func TestJWTAuth_Validate_DefaultsAlgorithm(t *testing.T) {
+	j := config.JWTAuth{Secret: "s3cr3t"}
+	if err := j.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if j.Algorithm != config.JWTAlgorithmHS256 {
+		t.Errorf("want algorithm %q, got %q", config.JWTAlgorithmHS256, j.Algorithm)
+	}
+	if j.KeyRotationDuration() != config.DefaultJWTKeyRotationInterval {
+		t.Errorf("want rotation interval %v, got %v", config.DefaultJWTKeyRotationInterval, j.KeyRotationDuration())
+	}
+}
+
+func TestJWTAuth_Validate_InvalidAlgorithm(t *testing.T) {
+	j := config.JWTAuth{Secret: "s3cr3t", Algorithm: "none"}
+	if err := j.Validate(); err == nil {
+		t.Fatal("expected error for unsupported algorithm")
+	}
+}
+
+func TestJWTAuth_Validate_KeyRotationInterval(t *testing.T) {
+	for _, tc := range []struct {
+		interval string
+		wantErr  bool
+	}{
+		{"168h", false},
+		{"1h", false},
+		{"30m", true},
+		{"weekly", true},
+	} {
+		j := config.JWTAuth{Secret: "s3cr3t", Algorithm: config.JWTAlgorithmEdDSA, KeyRotationInterval: tc.interval}
+		err := j.Validate()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: want error %v, got %v", tc.interval, tc.wantErr, err)
+		}
+	}
+}
+
 // ── Database.Validate ─────────────────────────────────────────────────────────
 
 func TestDatabase_Validate_EmptyBackend(t *testing.T) {
@@ -295,6 +339,568 @@ func TestAPIServer_Validate_Valid(t *testing.T) {
 	}
 }
 
+func TestAPIServer_ForwardingProxies(t *testing.T) {
+	a := config.APIServer{
+		Port:           9997,
+		Bind:           "0.0.0.0",
+		JWTAuth:        config.JWTAuth{Secret: "super-secret"},
+		TrustedProxies: []string{"10.0.0.0/8"},
+		ProxyAuth:      config.ProxyAuth{TrustedProxies: []string{"192.168.0.0/16"}},
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	networks, err := a.ForwardingProxies()
+	if err != nil || len(networks) != 1 {
+		t.Fatalf("proxy auth disabled: want 1 network, got %v (%v)", networks, err)
+	}
+	a.ProxyAuth.Enable = true
+	networks, err = a.ForwardingProxies()
+	if err != nil || len(networks) != 2 {
+		t.Fatalf("proxy auth enabled: want 2 networks, got %v (%v)", networks, err)
+	}
+
+	a.TrustedProxies = []string{"10.0.0.1"}
+	if err := a.Validate(); err == nil {
+		t.Fatal("expected error for invalid trusted proxy network")
+	}
+}
+
+// ── OIDC ──────────────────────────────────────────────────────────────────────
+
+func TestOIDC_Validate_Disabled(t *testing.T) {
+	o := config.OIDC{}
+	if err := o.Validate(); err != nil {
+		t.Fatalf("disabled config should not be validated: %v", err)
+	}
+}
+
+func TestOIDC_Validate_MissingFields(t *testing.T) {
+	o := config.OIDC{Enable: true, IssuerURL: "https://idp.example.com"}
+	if err := o.Validate(); err == nil {
+		t.Fatal("expected error for missing client_id and redirect_url")
+	}
+}
+
+func TestOIDC_Validate_InvalidURL(t *testing.T) {
+	o := config.OIDC{
+		Enable:      true,
+		IssuerURL:   "idp.example.com",
+		ClientID:    "gopherbin",
+		RedirectURL: "https://paste.example.com/api/v1/auth/oidc/callback",
+	}
+	if err := o.Validate(); err == nil {
+		t.Fatal("expected error for issuer URL without scheme")
+	}
+}
+
+func TestOIDC_Validate_SetsDefaults(t *testing.T) {
+	o := config.OIDC{
+		Enable:      true,
+		IssuerURL:   "https://idp.example.com",
+		ClientID:    "gopherbin",
+		RedirectURL: "https://paste.example.com/api/v1/auth/oidc/callback",
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.UsernameClaim != "preferred_username" || o.EmailClaim != "email" || o.GroupsClaim != "groups" {
+		t.Errorf("unexpected claim defaults: %+v", o)
+	}
+	if len(o.Scopes) != 2 {
+		t.Errorf("want default scopes, got %v", o.Scopes)
+	}
+}
+
+// ── LDAP ─────────────────────────────────────────────────────────────────────
+
+func TestLDAP_Validate_Disabled(t *testing.T) {
+	l := config.LDAP{}
+	if err := l.Validate(); err != nil {
+		t.Fatalf("disabled config should not be validated: %v", err)
+	}
+}
+
+func TestLDAP_Validate_Invalid(t *testing.T) {
+	cases := map[string]config.LDAP{
+		"invalid URL":          {Enable: true, URL: "https://ldap.example.com", UserDNTemplate: "uid=%s"},
+		"start_tls with ldaps": {Enable: true, URL: "ldaps://ldap.example.com", StartTLS: true, UserDNTemplate: "uid=%s"},
+		"no bind method":       {Enable: true, URL: "ldap://ldap.example.com"},
+		"template without %s":  {Enable: true, URL: "ldap://ldap.example.com", UserDNTemplate: "uid=admin"},
+		"bind_dn without base": {Enable: true, URL: "ldap://ldap.example.com", BindDN: "cn=svc"},
+		"filter without %s":    {Enable: true, URL: "ldap://ldap.example.com", UserDNTemplate: "uid=%s", UserFilter: "(uid=admin)"},
+		"groups without base":  {Enable: true, URL: "ldap://ldap.example.com", UserDNTemplate: "uid=%s", AdminGroups: []string{"admins"}},
+		"invalid timeout":      {Enable: true, URL: "ldap://ldap.example.com", UserDNTemplate: "uid=%s", Timeout: "soon"},
+		"team name too long":   {Enable: true, URL: "ldap://ldap.example.com", UserDNTemplate: "uid=%s", GroupBaseDN: "ou=groups", TeamGroups: map[string]string{"devs": strings.Repeat("x", 33)}},
+		"team name empty":      {Enable: true, URL: "ldap://ldap.example.com", UserDNTemplate: "uid=%s", GroupBaseDN: "ou=groups", TeamGroups: map[string]string{"devs": ""}},
+	}
+	for name, l := range cases {
+		if err := l.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLDAP_Validate_SetsDefaults(t *testing.T) {
+	l := config.LDAP{
+		Enable:       true,
+		URL:          "ldaps://ldap.example.com",
+		BindDN:       "cn=gopherbin,dc=example,dc=com",
+		BindPassword: "secret",
+		UserBaseDN:   "ou=people,dc=example,dc=com",
+	}
+	if err := l.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l.UserFilter != "(uid=%s)" || l.GroupFilter != "(member=%s)" {
+		t.Errorf("unexpected filter defaults: %+v", l)
+	}
+	if l.UsernameAttribute != "uid" || l.EmailAttribute != "mail" || l.FullNameAttribute != "cn" || l.GroupNameAttribute != "cn" {
+		t.Errorf("unexpected attribute defaults: %+v", l)
+	}
+	if l.TimeoutDuration() != config.DefaultLDAPTimeout {
+		t.Errorf("want default timeout, got %s", l.TimeoutDuration())
+	}
+}
+
+// ── TwoFactor ────────────────────────────────────────────────────────────────
+
+func TestTwoFactor_Validate_SetsDefaults(t *testing.T) {
+	tf := config.TwoFactor{}
+	if err := tf.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tf.Issuer != config.DefaultTwoFactorIssuer {
+		t.Errorf("want default issuer, got %q", tf.Issuer)
+	}
+}
+
+func TestTwoFactor_Validate_InvalidIssuer(t *testing.T) {
+	tf := config.TwoFactor{Issuer: "paste:example"}
+	if err := tf.Validate(); err == nil {
+		t.Fatal("expected error for issuer containing a colon")
+	}
+}
+
+func TestLoginThrottle_Validate_SetsDefaults(t *testing.T) {
+	lt := config.LoginThrottle{}
+	if err := lt.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lt.FreeAttempts != config.DefaultLoginFreeAttempts {
+		t.Errorf("want %d free attempts, got %d", config.DefaultLoginFreeAttempts, lt.FreeAttempts)
+	}
+	if lt.LockoutThreshold != config.DefaultLoginLockoutThreshold {
+		t.Errorf("want lockout threshold %d, got %d", config.DefaultLoginLockoutThreshold, lt.LockoutThreshold)
+	}
+	if lt.IPLockoutThreshold != config.DefaultLoginIPLockoutThreshold {
+		t.Errorf("want ip lockout threshold %d, got %d", config.DefaultLoginIPLockoutThreshold, lt.IPLockoutThreshold)
+	}
+	if lt.BaseDelayDuration() != config.DefaultLoginBaseDelay || lt.LockoutPeriod() != config.DefaultLoginLockoutDuration {
+		t.Errorf("unexpected default durations: %s, %s", lt.BaseDelayDuration(), lt.LockoutPeriod())
+	}
+}
+
+func TestLoginThrottle_Validate_Invalid(t *testing.T) {
+	cases := map[string]config.LoginThrottle{
+		"bad duration":       {LockoutDuration: "forever"},
+		"negative duration":  {ResetAfter: "-1h"},
+		"negative threshold": {LockoutThreshold: -1},
+		"max below base":     {BaseDelay: "1m", MaxDelay: "10s"},
+	}
+	for name, lt := range cases {
+		if err := lt.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPasswordPolicy_Validate_SetsDefaults(t *testing.T) {
+	pp := config.PasswordPolicy{}
+	if err := pp.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pp.MinimumScore() != config.DefaultPasswordMinScore || pp.MinLength != config.DefaultPasswordMinLength {
+		t.Errorf("unexpected defaults: score %d, length %d", pp.MinimumScore(), pp.MinLength)
+	}
+	zero := 0
+	pp = config.PasswordPolicy{MinScore: &zero}
+	if err := pp.Validate(); err != nil || pp.MinimumScore() != 0 {
+		t.Errorf("want min score 0 to be kept, got %d (%v)", pp.MinimumScore(), err)
+	}
+}
+
+func TestPasswordPolicy_Validate_Invalid(t *testing.T) {
+	high, low := 5, -1
+	cases := map[string]config.PasswordPolicy{
+		"score too high":   {MinScore: &high},
+		"negative score":   {MinScore: &low},
+		"negative length":  {MinLength: -1},
+		"missing hashfile": {BreachedPasswordsFile: filepath.Join(t.TempDir(), "missing.txt")},
+	}
+	for name, pp := range cases {
+		if err := pp.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRegistration_Validate(t *testing.T) {
+	r := config.Registration{}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Mode != config.RegistrationDisabled || r.Enabled() {
+		t.Errorf("want registration disabled by default, got %q", r.Mode)
+	}
+	for _, mode := range []config.RegistrationMode{config.RegistrationInvite, config.RegistrationOpen} {
+		r := config.Registration{Mode: mode}
+		if err := r.Validate(); err != nil {
+			t.Errorf("%s: unexpected error: %v", mode, err)
+		}
+		if !r.Enabled() {
+			t.Errorf("%s: want registration enabled", mode)
+		}
+	}
+	r = config.Registration{Mode: "anyone"}
+	if err := r.Validate(); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestAuditSyslog_Validate_SetsDefaults(t *testing.T) {
+	s := config.AuditSyslog{}
+	if err := s.Validate(); err != nil || s.Tag != "" {
+		t.Fatalf("want a disabled config left untouched, got %+v (%v)", s, err)
+	}
+	s = config.AuditSyslog{Enable: true}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Tag != config.DefaultAuditSyslogTag || s.Facility != config.DefaultAuditSyslogFacility {
+		t.Errorf("unexpected defaults: %+v", s)
+	}
+}
+
+func TestAuditSyslog_Validate_Invalid(t *testing.T) {
+	cases := map[string]config.AuditSyslog{
+		"missing address":  {Enable: true, Network: "udp"},
+		"missing network":  {Enable: true, Address: "localhost:514"},
+		"invalid network":  {Enable: true, Network: "http", Address: "localhost:514"},
+		"invalid facility": {Enable: true, Facility: "kern"},
+	}
+	for name, s := range cases {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestProxyAuth_Validate(t *testing.T) {
+	p := config.ProxyAuth{}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("disabled: unexpected error: %v", err)
+	}
+
+	p = config.ProxyAuth{Enable: true, TrustedProxies: []string{"10.0.0.0/8", "::1/128"}}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.UserHeader != config.DefaultProxyUserHeader || p.EmailHeader != config.DefaultProxyEmailHeader {
+		t.Errorf("want default headers, got %q and %q", p.UserHeader, p.EmailHeader)
+	}
+	networks, err := p.TrustedNetworks()
+	if err != nil || len(networks) != 2 {
+		t.Fatalf("want 2 trusted networks, got %v (%v)", networks, err)
+	}
+
+	cases := map[string]config.ProxyAuth{
+		"no trusted proxies": {Enable: true},
+		"invalid network":    {Enable: true, TrustedProxies: []string{"10.0.0.1"}},
+	}
+	for name, p := range cases {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestSessionCookie_Validate(t *testing.T) {
+	s := config.SessionCookie{Enable: true}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.SameSite != config.SameSiteStrict || s.SameSiteMode() != http.SameSiteStrictMode {
+		t.Errorf("want strict by default, got %q", s.SameSite)
+	}
+
+	s = config.SessionCookie{Enable: true, SameSite: config.SameSiteLax}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.SameSiteMode() != http.SameSiteLaxMode {
+		t.Errorf("want lax, got %v", s.SameSiteMode())
+	}
+
+	s = config.SessionCookie{Enable: true, SameSite: "none"}
+	if err := s.Validate(); err == nil {
+		t.Error("expected error for same_site none")
+	}
+}
+
+func validMailConfig() config.Mail {
+	return config.Mail{
+		Enable:  true,
+		Host:    "smtp.example.com",
+		From:    "Gopherbin <gopherbin@example.com>",
+		BaseURL: "https://paste.example.com",
+	}
+}
+
+func TestMail_Validate_Disabled(t *testing.T) {
+	m := config.Mail{}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.ConfirmEmailChange = true
+	if err := m.Validate(); err == nil {
+		t.Error("expected error confirming email changes without mail")
+	}
+}
+
+func TestMail_Validate_SetsDefaults(t *testing.T) {
+	m := validMailConfig()
+	if err := m.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Port != config.DefaultMailPort || m.TLS != config.MailTLSStartTLS {
+		t.Errorf("want port %d and starttls, got %d and %q", config.DefaultMailPort, m.Port, m.TLS)
+	}
+	if m.PasswordResetTimeoutDuration() != config.DefaultPasswordResetTimeout {
+		t.Errorf("want default reset timeout, got %s", m.PasswordResetTimeoutDuration())
+	}
+	if m.EmailChangeTimeoutDuration() != config.DefaultEmailChangeTimeout {
+		t.Errorf("want default email change timeout, got %s", m.EmailChangeTimeoutDuration())
+	}
+}
+
+func TestMail_Validate_Invalid(t *testing.T) {
+	cases := map[string]func(*config.Mail){
+		"missing host":    func(m *config.Mail) { m.Host = "" },
+		"invalid from":    func(m *config.Mail) { m.From = "gopherbin" },
+		"invalid tls":     func(m *config.Mail) { m.TLS = "ssl" },
+		"invalid port":    func(m *config.Mail) { m.Port = 70000 },
+		"bad timeout":     func(m *config.Mail) { m.PasswordResetTimeout = "soon" },
+		"bad email link":  func(m *config.Mail) { m.EmailChangeTimeout = "-1h" },
+		"missing url":     func(m *config.Mail) { m.BaseURL = "" },
+		"relative url":    func(m *config.Mail) { m.BaseURL = "/gopherbin" },
+		"unsupported url": func(m *config.Mail) { m.BaseURL = "ftp://paste.example.com" },
+	}
+	for name, mutate := range cases {
+		m := validMailConfig()
+		mutate(&m)
+		if err := m.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// ── TLSConfig ─────────────────────────────────────────────────────────────────
+
+type tlsFixture struct {
+	dir    string
+	ca     *certtest.CA
+	client *certtest.Certificate
+	cfg    config.TLSConfig
+}
+
+func newTLSFixture(t *testing.T) *tlsFixture {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := certtest.NewCA("Gopherbin test CA")
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	caFile, err := ca.WriteCAFile(dir, "ca")
+	if err != nil {
+		t.Fatalf("WriteCAFile: %v", err)
+	}
+	server, err := ca.IssueServer("127.0.0.1")
+	if err != nil {
+		t.Fatalf("IssueServer: %v", err)
+	}
+	crt, key, err := server.WriteFiles(dir, "server")
+	if err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+	client, err := ca.IssueClient("alice", "alice@example.com")
+	if err != nil {
+		t.Fatalf("IssueClient: %v", err)
+	}
+	return &tlsFixture{
+		dir:    dir,
+		ca:     ca,
+		client: client,
+		cfg:    config.TLSConfig{CRT: crt, Key: key, CACert: caFile},
+	}
+}
+
+func (f *tlsFixture) writeCRL(t *testing.T, revoked ...*certtest.Certificate) string {
+	t.Helper()
+	crl, err := f.ca.CRL(revoked...)
+	if err != nil {
+		t.Fatalf("CRL: %v", err)
+	}
+	path := filepath.Join(f.dir, "ca.crl")
+	if err := os.WriteFile(path, crl, 0o600); err != nil {
+		t.Fatalf("write crl: %v", err)
+	}
+	return path
+}
+
+// verify runs the checks the TLS server runs on the verified chains of
+// cert.
+func (f *tlsFixture) verify(t *testing.T, tlsCfg *tls.Config, cert *certtest.Certificate) error {
+	t.Helper()
+	chains, err := cert.Cert.Verify(x509.VerifyOptions{
+		Roots:     tlsCfg.ClientCAs,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return tlsCfg.VerifyConnection(tls.ConnectionState{VerifiedChains: chains})
+}
+
+func TestTLSConfig_ClientAuthModes(t *testing.T) {
+	f := newTLSFixture(t)
+	modes := map[config.ClientAuthMode]tls.ClientAuthType{
+		"":                       tls.NoClientCert,
+		config.ClientAuthNone:    tls.NoClientCert,
+		config.ClientAuthRequest: tls.VerifyClientCertIfGiven,
+		config.ClientAuthRequire: tls.RequireAndVerifyClientCert,
+	}
+	for mode, want := range modes {
+		cfg := f.cfg
+		cfg.ClientAuth = mode
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("%q: unexpected error: %v", mode, err)
+		}
+		tlsCfg, err := cfg.TLSConfig()
+		if err != nil {
+			t.Fatalf("%q: TLSConfig: %v", mode, err)
+		}
+		if tlsCfg.ClientAuth != want {
+			t.Errorf("%q: want %v, got %v", mode, want, tlsCfg.ClientAuth)
+		}
+	}
+	cfg := f.cfg
+	if mapping := cfg.UserMapping(); len(mapping) != 1 || mapping[0] != config.ClientCertMapFingerprint {
+		t.Errorf("want fingerprint mapping by default, got %v", mapping)
+	}
+}
+
+func TestTLSConfig_Validate_Invalid(t *testing.T) {
+	f := newTLSFixture(t)
+	crl := f.writeCRL(t)
+	cases := map[string]func(*config.TLSConfig){
+		"invalid mode": func(c *config.TLSConfig) { c.ClientAuth = "optional" },
+		"missing ca":   func(c *config.TLSConfig) { c.ClientAuth = config.ClientAuthRequire; c.CACert = "" },
+		"invalid mapping": func(c *config.TLSConfig) {
+			c.ClientAuth = config.ClientAuthRequest
+			c.ClientCertMapping = []config.ClientCertMapping{"serial"}
+		},
+		"crl without auth": func(c *config.TLSConfig) { c.CRL = crl },
+		"missing crl file": func(c *config.TLSConfig) {
+			c.ClientAuth = config.ClientAuthRequire
+			c.CRL = filepath.Join(f.dir, "missing.crl")
+		},
+		"invalid crl content": func(c *config.TLSConfig) { c.ClientAuth = config.ClientAuthRequire; c.CRL = c.CACert },
+	}
+	for name, mutate := range cases {
+		cfg := f.cfg
+		mutate(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestTLSConfig_CRL(t *testing.T) {
+	f := newTLSFixture(t)
+	revoked, err := f.ca.IssueClient("mallory")
+	if err != nil {
+		t.Fatalf("IssueClient: %v", err)
+	}
+
+	cfg := f.cfg
+	cfg.ClientAuth = config.ClientAuthRequire
+	cfg.CRL = f.writeCRL(t, revoked)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tlsCfg, err := cfg.TLSConfig()
+	if err != nil {
+		t.Fatalf("TLSConfig: %v", err)
+	}
+	if err := f.verify(t, tlsCfg, f.client); err != nil {
+		t.Errorf("want valid certificate accepted, got %v", err)
+	}
+	if err := f.verify(t, tlsCfg, revoked); err == nil {
+		t.Error("want revoked certificate rejected")
+	}
+
+	// The file is read again when it changes.
+	f.writeCRL(t, revoked, f.client)
+	if err := f.verify(t, tlsCfg, f.client); err == nil {
+		t.Error("want certificate rejected once the CRL lists it")
+	}
+
+	// Fail closed when the CRL can not be read.
+	if err := os.Remove(cfg.CRL); err != nil {
+		t.Fatalf("remove crl: %v", err)
+	}
+	valid, err := f.ca.IssueClient("bob")
+	if err != nil {
+		t.Fatalf("IssueClient: %v", err)
+	}
+	if err := f.verify(t, tlsCfg, valid); err == nil {
+		t.Error("want error when the CRL is missing")
+	}
+}
+
+func TestTLSConfig_ExpiredCRL(t *testing.T) {
+	f := newTLSFixture(t)
+	cfg := f.cfg
+	cfg.ClientAuth = config.ClientAuthRequire
+	cfg.CRL = f.writeCRL(t)
+	tlsCfg, err := cfg.TLSConfig()
+	if err != nil {
+		t.Fatalf("TLSConfig: %v", err)
+	}
+	if err := f.verify(t, tlsCfg, f.client); err != nil {
+		t.Fatalf("want valid certificate accepted, got %v", err)
+	}
+
+	// A stale CRL may miss recent revocations.
+	crl, err := f.ca.CRLUntil(time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("CRLUntil: %v", err)
+	}
+	if err := os.WriteFile(cfg.CRL, crl, 0o600); err != nil {
+		t.Fatalf("write crl: %v", err)
+	}
+	if err := f.verify(t, tlsCfg, f.client); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("want certificate rejected while the CRL is expired, got %v", err)
+	}
+
+	// Refreshing the CRL lets certificates in again.
+	f.writeCRL(t)
+	if err := f.verify(t, tlsCfg, f.client); err != nil {
+		t.Errorf("want certificate accepted with a fresh CRL, got %v", err)
+	}
+}
+
 // ── utility ───────────────────────────────────────────────────────────────────
 
 func contains(s, sub string) bool {
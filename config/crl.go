@@ -0,0 +1,151 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// crlChecker rejects client certificates listed in a certificate
+// revocation list. The list is read again whenever the file changes.
+type crlChecker struct {
+	path string
+
+	mux     sync.Mutex
+	modTime time.Time
+	size    int64
+	lists   []*x509.RevocationList
+}
+
+func newCRLChecker(path string) (*crlChecker, error) {
+	c := &crlChecker{path: path}
+	if _, err := c.revocationLists(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseCRLs parses the revocation lists in data, which may hold any
+// number of PEM encoded lists, or a single DER encoded one.
+func parseCRLs(data []byte) ([]*x509.RevocationList, error) {
+	var lists []*x509.RevocationList
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		list, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing CRL")
+		}
+		lists = append(lists, list)
+	}
+	if len(lists) > 0 {
+		return lists, nil
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing CRL")
+	}
+	return []*x509.RevocationList{list}, nil
+}
+
+// revocationLists returns the revocation lists in the file, reading it
+// again if it changed.
+func (c *crlChecker) revocationLists() ([]*x509.RevocationList, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading CRL")
+	}
+	if c.lists != nil && info.ModTime().Equal(c.modTime) && info.Size() == c.size {
+		return c.lists, nil
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading CRL")
+	}
+	lists, err := parseCRLs(data)
+	if err != nil {
+		return nil, err
+	}
+	c.lists = lists
+	c.modTime = info.ModTime()
+	c.size = info.Size()
+	return lists, nil
+}
+
+// checkRevoked returns an error if cert, issued by issuer, is listed in
+// one of the revocation lists signed by issuer. A list past its next
+// update may be missing recent revocations, so it rejects every
+// certificate of issuer until the file is refreshed.
+func checkRevoked(lists []*x509.RevocationList, cert, issuer *x509.Certificate, now time.Time) error {
+	for _, list := range lists {
+		if !bytes.Equal(list.RawIssuer, cert.RawIssuer) {
+			continue
+		}
+		if err := list.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+		if !list.NextUpdate.IsZero() && now.After(list.NextUpdate) {
+			return fmt.Errorf("the CRL of %s expired on %s", issuer.Subject, list.NextUpdate.Format(time.RFC3339))
+		}
+		for _, entry := range list.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("client certificate %s has been revoked", cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyConnection is used as tls.Config.VerifyConnection. It rejects
+// client certificates, and the intermediate CAs that issued them, that
+// have been revoked.
+func (c *crlChecker) verifyConnection(state tls.ConnectionState) error {
+	if len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	lists, err := c.revocationLists()
+	if err != nil {
+		// Fail closed, revoked certificates must not get in.
+		return err
+	}
+	now := time.Now()
+	for _, chain := range state.VerifiedChains {
+		for idx := 0; idx < len(chain)-1; idx++ {
+			if err := checkRevoked(lists, chain[idx], chain[idx+1], now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -14,12 +14,80 @@
 
 package errors
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Error codes are stable, machine readable identifiers sent to API clients
+// along with the error message. Clients should rely on these rather than on
+// the message itself, which may change.
+const (
+	CodeUnauthorized       = "unauthorized"
+	CodeNotFound           = "not_found"
+	CodeDuplicate          = "duplicate"
+	CodeBadRequest         = "bad_request"
+	CodeConflict           = "conflict"
+	CodeInternal           = "internal_error"
+	CodeInitRequired       = "init_required"
+	CodeAlreadyInitialized = "already_initialized"
+	CodeValidationFailed   = "validation_failed"
+	CodeRequired           = "required"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeInvalidToken       = "invalid_token"
+	CodeUserDisabled       = "user_disabled"
+	CodeRouteNotFound      = "route_not_found"
+	CodePasteNotFound      = "paste_not_found"
+	CodeUserNotFound       = "user_not_found"
+	CodeTeamNotFound       = "team_not_found"
+	CodeEmailInUse         = "email_in_use"
+	CodeUsernameInUse      = "username_in_use"
+	CodeTeamExists         = "team_exists"
+	CodePasswordTooWeak    = "password_too_weak"
+	CodeInvalidEmail       = "invalid_email"
+	CodeInvalidUsername    = "invalid_username"
+	CodeInvalidFullName    = "invalid_full_name"
+	CodeInvalidTags        = "invalid_tags"
+	CodeTokenNotFound      = "token_not_found"
+	CodeSessionNotFound    = "session_not_found"
+	CodeForbidden          = "forbidden"
+	CodeInsufficientScope  = "insufficient_scope"
+	CodeInvalidScope       = "invalid_scope"
+	CodeSSOFailed          = "sso_failed"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeAccountLocked      = "account_locked"
+	CodeLoginThrottled     = "login_throttled"
+	// Registration
+	CodeRegistrationDisabled = "registration_disabled"
+	CodeInvalidInvite        = "invalid_invite"
+	CodeInviteNotFound       = "invite_not_found"
+	CodeApprovalPending      = "approval_pending"
+	CodeNotPending           = "not_pending"
+	CodeAccountExists        = "account_exists"
+	// Two-factor authentication
+	CodeInvalidTwoFactorCode = "invalid_two_factor_code"
+	CodeTwoFactorEnabled     = "two_factor_enabled"
+	CodeTwoFactorNotEnabled  = "two_factor_not_enabled"
+	// Client certificates
+	CodeCertificateNotFound = "certificate_not_found"
+	CodeCertificateInUse    = "certificate_in_use"
+	CodeInvalidCertificate  = "invalid_certificate"
+	// Cookie sessions
+	CodeInvalidCSRFToken = "invalid_csrf_token"
+	// Password policy
+	CodePasswordTooShort = "password_too_short"
+	CodePasswordBreached = "password_breached"
+	CodeWrongPassword    = "wrong_password"
+)
 
 var (
 	// ErrUnauthorized is returned when a user does not have
 	// authorization to perform a request
 	ErrUnauthorized = NewUnauthorizedError("Unauthorized")
+	// ErrForbidden is returned when the credentials used to make a
+	// request do not grant access to the requested operation
+	ErrForbidden = NewForbiddenError("forbidden")
 	// ErrNotFound is returned if an object is not found in
 	// the database.
 	ErrNotFound = NewNotFoundError("not found")
@@ -28,21 +96,128 @@ var (
 	ErrDuplicateEntity = NewDuplicateUserError("duplicate")
 	// ErrBadRequest is returned is a malformed request is sent
 	ErrBadRequest = NewBadRequestError("invalid request")
+	// ErrTooManyRequests is returned when a client must wait before
+	// retrying a request
+	ErrTooManyRequests = NewTooManyRequestsError(0, "too many requests")
+
+	// ErrPasteNotFound is returned when a paste does not exist, or
+	// the user may not access it.
+	ErrPasteNotFound = WithCode(NewNotFoundError("paste not found"), CodePasteNotFound)
+	// ErrUserNotFound is returned when a user does not exist.
+	ErrUserNotFound = WithCode(NewNotFoundError("user not found"), CodeUserNotFound)
+	// ErrTeamNotFound is returned when a team does not exist.
+	ErrTeamNotFound = WithCode(NewNotFoundError("team not found"), CodeTeamNotFound)
+	// ErrTokenNotFound is returned when an API token does not exist.
+	ErrTokenNotFound = WithCode(NewNotFoundError("API token not found"), CodeTokenNotFound)
+	// ErrSessionNotFound is returned when a session does not exist.
+	ErrSessionNotFound = WithCode(NewNotFoundError("session not found"), CodeSessionNotFound)
+	// ErrInviteNotFound is returned when an invite does not exist.
+	ErrInviteNotFound = WithCode(NewNotFoundError("invite not found"), CodeInviteNotFound)
+	// ErrCertificateNotFound is returned when a client certificate does
+	// not exist.
+	ErrCertificateNotFound = WithCode(NewNotFoundError("client certificate not found"), CodeCertificateNotFound)
+	// ErrInvalidCredentials is returned when authentication fails.
+	ErrInvalidCredentials = WithCode(NewUnauthorizedError("invalid username or password"), CodeInvalidCredentials)
+	// ErrInvalidTwoFactorCode is returned when a two-factor code is wrong
+	// or has already been used.
+	ErrInvalidTwoFactorCode = WithCode(NewUnauthorizedError("invalid two-factor code"), CodeInvalidTwoFactorCode)
+	// ErrWrongPassword is returned when users changing their password
+	// get their current password wrong. Unlike ErrInvalidCredentials, it
+	// does not end the session of the client.
+	ErrWrongPassword = WithCode(NewValidationError(FieldError{
+		Field:   "current_password",
+		Code:    CodeWrongPassword,
+		Message: "the current password is wrong",
+	}), CodeWrongPassword)
 )
 
+// Coder is implemented by errors that carry an error code
+type Coder interface {
+	Code() string
+}
+
+// Code returns the error code of err, or an empty string if err
+// does not carry one.
+func Code(err error) string {
+	for err != nil {
+		if coder, ok := err.(Coder); ok && coder.Code() != "" {
+			return coder.Code()
+		}
+		unwrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return ""
+		}
+		err = unwrapper.Unwrap()
+	}
+	return ""
+}
+
 type baseError struct {
-	msg string
+	msg  string
+	code string
+	// parent is the error this error was derived from using WithCode.
+	// It allows errors.Is() to match the generic sentinel errors.
+	parent error
 }
 
 func (b *baseError) Error() string {
 	return b.msg
 }
 
+// Code returns the error code
+func (b *baseError) Code() string {
+	return b.code
+}
+
+// Unwrap returns the error this error was derived from, if any
+func (b *baseError) Unwrap() error {
+	return b.parent
+}
+
+// WithCode returns a copy of err, which must be one of the error types
+// defined in this package, with the error code set to code. The returned
+// error still matches err when using errors.Is(). Any other errors are
+// returned unchanged.
+func WithCode(err error, code string) error {
+	switch e := err.(type) {
+	case *UnauthorizedError:
+		ret := *e
+		ret.code, ret.parent = code, err
+		return &ret
+	case *ForbiddenError:
+		ret := *e
+		ret.code, ret.parent = code, err
+		return &ret
+	case *NotFoundError:
+		ret := *e
+		ret.code, ret.parent = code, err
+		return &ret
+	case *DuplicateUserError:
+		ret := *e
+		ret.code, ret.parent = code, err
+		return &ret
+	case *BadRequestError:
+		ret := *e
+		ret.code, ret.parent = code, err
+		return &ret
+	case *ConflictError:
+		ret := *e
+		ret.code, ret.parent = code, err
+		return &ret
+	case *TooManyRequestsError:
+		ret := *e
+		ret.code, ret.parent = code, err
+		return &ret
+	}
+	return err
+}
+
 // NewUnauthorizedError returns a new UnauthorizedError
 func NewUnauthorizedError(msg string) error {
 	return &UnauthorizedError{
 		baseError{
-			msg: msg,
+			msg:  msg,
+			code: CodeUnauthorized,
 		},
 	}
 }
@@ -52,11 +227,39 @@ type UnauthorizedError struct {
 	baseError
 }
 
+// Is allows any UnauthorizedError to match ErrUnauthorized when using errors.Is()
+func (e *UnauthorizedError) Is(target error) bool {
+	return target == ErrUnauthorized
+}
+
+// NewForbiddenError returns a new ForbiddenError
+func NewForbiddenError(msg string, a ...interface{}) error {
+	return &ForbiddenError{
+		baseError{
+			msg:  fmt.Sprintf(msg, a...),
+			code: CodeForbidden,
+		},
+	}
+}
+
+// ForbiddenError is returned when the user is authenticated, but the
+// credentials used do not allow the request, for example because a
+// token lacks a scope.
+type ForbiddenError struct {
+	baseError
+}
+
+// Is allows any ForbiddenError to match ErrForbidden when using errors.Is()
+func (e *ForbiddenError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
 // NewNotFoundError returns a new NotFoundError
 func NewNotFoundError(msg string) error {
 	return &NotFoundError{
 		baseError{
-			msg: msg,
+			msg:  msg,
+			code: CodeNotFound,
 		},
 	}
 }
@@ -66,11 +269,17 @@ type NotFoundError struct {
 	baseError
 }
 
+// Is allows any NotFoundError to match ErrNotFound when using errors.Is()
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
 // NewDuplicateUserError returns a new DuplicateUserError
 func NewDuplicateUserError(msg string) error {
 	return &DuplicateUserError{
 		baseError{
-			msg: msg,
+			msg:  msg,
+			code: CodeDuplicate,
 		},
 	}
 }
@@ -80,11 +289,17 @@ type DuplicateUserError struct {
 	baseError
 }
 
+// Is allows any DuplicateUserError to match ErrDuplicateEntity when using errors.Is()
+func (e *DuplicateUserError) Is(target error) bool {
+	return target == ErrDuplicateEntity
+}
+
 // NewBadRequestError returns a new BadRequestError
 func NewBadRequestError(msg string, a ...interface{}) error {
 	return &BadRequestError{
-		baseError{
-			msg: fmt.Sprintf(msg, a...),
+		baseError: baseError{
+			msg:  fmt.Sprintf(msg, a...),
+			code: CodeBadRequest,
 		},
 	}
 }
@@ -92,13 +307,49 @@ func NewBadRequestError(msg string, a ...interface{}) error {
 // BadRequestError is returned when a malformed request is received
 type BadRequestError struct {
 	baseError
+	fields []FieldError
+}
+
+// Is allows any BadRequestError to match ErrBadRequest when using errors.Is()
+func (e *BadRequestError) Is(target error) bool {
+	return target == ErrBadRequest
+}
+
+// Fields returns details about the fields that failed validation,
+// if any.
+func (b *BadRequestError) Fields() []FieldError {
+	return b.fields
+}
+
+// FieldError describes why a single field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewValidationError returns a new BadRequestError describing the fields
+// of a request that are invalid.
+func NewValidationError(fields ...FieldError) error {
+	msgs := make([]string, len(fields))
+	for idx, field := range fields {
+		msgs[idx] = fmt.Sprintf("%s: %s", field.Field, field.Message)
+	}
+	return &BadRequestError{
+		baseError: baseError{
+			msg:  "invalid parameters: " + strings.Join(msgs, "; "),
+			code: CodeValidationFailed,
+		},
+		fields: fields,
+	}
 }
 
 // NewConflictError returns a new ConflictError
 func NewConflictError(msg string, a ...interface{}) error {
 	return &ConflictError{
 		baseError{
-			msg: fmt.Sprintf(msg, a...),
+			msg:  fmt.Sprintf(msg, a...),
+			code: CodeConflict,
 		},
 	}
 }
@@ -107,3 +358,33 @@ func NewConflictError(msg string, a ...interface{}) error {
 type ConflictError struct {
 	baseError
 }
+
+// NewTooManyRequestsError returns a new TooManyRequestsError. The client
+// should wait for retryAfter before trying again.
+func NewTooManyRequestsError(retryAfter time.Duration, msg string, a ...interface{}) error {
+	return &TooManyRequestsError{
+		baseError: baseError{
+			msg:  fmt.Sprintf(msg, a...),
+			code: CodeTooManyRequests,
+		},
+		retryAfter: retryAfter,
+	}
+}
+
+// TooManyRequestsError is returned when a client is rate limited or
+// locked out
+type TooManyRequestsError struct {
+	baseError
+	retryAfter time.Duration
+}
+
+// Is allows any TooManyRequestsError to match ErrTooManyRequests when using errors.Is()
+func (e *TooManyRequestsError) Is(target error) bool {
+	return target == ErrTooManyRequests
+}
+
+// RetryAfter returns how long the client should wait before retrying
+// the request. A zero value means the wait time is unknown.
+func (e *TooManyRequestsError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
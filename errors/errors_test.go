@@ -1,9 +1,14 @@
 package errors_test
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	gErrors "gopherbin/errors"
+
+	pkgErrors "github.com/pkg/errors"
 )
 
 func TestNewUnauthorizedError(t *testing.T) {
@@ -58,6 +63,20 @@ func TestNewConflictError(t *testing.T) {
 	}
 }
 
+func TestNewForbiddenError(t *testing.T) {
+	err := gErrors.NewForbiddenError("missing scope %s", "paste:write")
+	want := "missing scope paste:write"
+	if err.Error() != want {
+		t.Errorf("want %q, got %q", want, err.Error())
+	}
+	if _, ok := err.(*gErrors.ForbiddenError); !ok {
+		t.Error("expected *ForbiddenError")
+	}
+	if !errors.Is(gErrors.WithCode(err, gErrors.CodeInsufficientScope), gErrors.ErrForbidden) {
+		t.Error("expected error to match ErrForbidden")
+	}
+}
+
 func TestSentinelVars(t *testing.T) {
 	if _, ok := gErrors.ErrUnauthorized.(*gErrors.UnauthorizedError); !ok {
 		t.Error("ErrUnauthorized: expected *UnauthorizedError")
@@ -72,3 +91,103 @@ func TestSentinelVars(t *testing.T) {
 		t.Error("ErrBadRequest: expected *BadRequestError")
 	}
 }
+
+func TestDefaultCodes(t *testing.T) {
+	cases := map[string]error{
+		gErrors.CodeUnauthorized: gErrors.NewUnauthorizedError("x"),
+		gErrors.CodeNotFound:     gErrors.NewNotFoundError("x"),
+		gErrors.CodeDuplicate:    gErrors.NewDuplicateUserError("x"),
+		gErrors.CodeBadRequest:   gErrors.NewBadRequestError("x"),
+		gErrors.CodeConflict:     gErrors.NewConflictError("x"),
+		gErrors.CodeForbidden:    gErrors.NewForbiddenError("x"),
+	}
+	for want, err := range cases {
+		if got := gErrors.Code(err); got != want {
+			t.Errorf("%T: want code %q, got %q", err, want, got)
+		}
+	}
+	if got := gErrors.Code(fmt.Errorf("plain")); got != "" {
+		t.Errorf("plain error: want empty code, got %q", got)
+	}
+}
+
+func TestWithCode(t *testing.T) {
+	err := gErrors.WithCode(gErrors.NewNotFoundError("paste not found"), gErrors.CodePasteNotFound)
+	if _, ok := err.(*gErrors.NotFoundError); !ok {
+		t.Fatalf("expected *NotFoundError, got %T", err)
+	}
+	if got := gErrors.Code(err); got != gErrors.CodePasteNotFound {
+		t.Errorf("want code %q, got %q", gErrors.CodePasteNotFound, got)
+	}
+	if err.Error() != "paste not found" {
+		t.Errorf("want %q, got %q", "paste not found", err.Error())
+	}
+	if !errors.Is(err, gErrors.ErrNotFound) {
+		t.Error("expected error to match ErrNotFound")
+	}
+	// The original error is left untouched.
+	if got := gErrors.Code(gErrors.ErrNotFound); got != gErrors.CodeNotFound {
+		t.Errorf("ErrNotFound: want code %q, got %q", gErrors.CodeNotFound, got)
+	}
+
+	plain := fmt.Errorf("plain")
+	if gErrors.WithCode(plain, "whatever") != plain {
+		t.Error("expected foreign errors to be returned unchanged")
+	}
+}
+
+func TestCodeThroughWrapping(t *testing.T) {
+	err := pkgErrors.Wrap(gErrors.ErrPasteNotFound, "fetching paste")
+	if got := gErrors.Code(pkgErrors.Cause(err)); got != gErrors.CodePasteNotFound {
+		t.Errorf("want code %q, got %q", gErrors.CodePasteNotFound, got)
+	}
+	if !errors.Is(err, gErrors.ErrPasteNotFound) || !errors.Is(err, gErrors.ErrNotFound) {
+		t.Error("expected wrapped error to match both sentinels")
+	}
+}
+
+func TestNewValidationError(t *testing.T) {
+	err := gErrors.NewValidationError(
+		gErrors.FieldError{Field: "password", Code: gErrors.CodePasswordTooWeak, Message: "too weak"},
+		gErrors.FieldError{Field: "email", Code: gErrors.CodeInvalidEmail, Message: "invalid"},
+	)
+	badReq, ok := err.(*gErrors.BadRequestError)
+	if !ok {
+		t.Fatalf("expected *BadRequestError, got %T", err)
+	}
+	if got := gErrors.Code(err); got != gErrors.CodeValidationFailed {
+		t.Errorf("want code %q, got %q", gErrors.CodeValidationFailed, got)
+	}
+	if len(badReq.Fields()) != 2 || badReq.Fields()[0].Field != "password" {
+		t.Errorf("unexpected fields: %+v", badReq.Fields())
+	}
+	want := "invalid parameters: password: too weak; email: invalid"
+	if err.Error() != want {
+		t.Errorf("want %q, got %q", want, err.Error())
+	}
+}
+
+func TestNewTooManyRequestsError(t *testing.T) {
+	err := gErrors.NewTooManyRequestsError(90*time.Second, "try again in %s", "90s")
+	tooMany, ok := err.(*gErrors.TooManyRequestsError)
+	if !ok {
+		t.Fatalf("expected *TooManyRequestsError, got %T", err)
+	}
+	if tooMany.RetryAfter() != 90*time.Second {
+		t.Errorf("want retry after 90s, got %s", tooMany.RetryAfter())
+	}
+	if got := gErrors.Code(err); got != gErrors.CodeTooManyRequests {
+		t.Errorf("want code %q, got %q", gErrors.CodeTooManyRequests, got)
+	}
+
+	locked := gErrors.WithCode(err, gErrors.CodeAccountLocked)
+	if got := gErrors.Code(locked); got != gErrors.CodeAccountLocked {
+		t.Errorf("want code %q, got %q", gErrors.CodeAccountLocked, got)
+	}
+	if !errors.Is(locked, gErrors.ErrTooManyRequests) {
+		t.Error("expected error to match ErrTooManyRequests")
+	}
+	if locked.(*gErrors.TooManyRequestsError).RetryAfter() != 90*time.Second {
+		t.Error("expected WithCode to keep the retry delay")
+	}
+}
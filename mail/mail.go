@@ -0,0 +1,94 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package mail sends emails, such as password reset links, through an
+// SMTP server.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gopherbin/util"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Sender sends emails
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// recipients parses the recipients of the message
+func (m Message) recipients() ([]*mail.Address, error) {
+	if len(m.To) == 0 {
+		return nil, fmt.Errorf("message has no recipients")
+	}
+	ret := make([]*mail.Address, len(m.To))
+	for idx, to := range m.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing recipient %q", to)
+		}
+		ret[idx] = addr
+	}
+	return ret, nil
+}
+
+// encode returns the message formatted for sending by from
+func (m Message) encode(from *mail.Address, to []*mail.Address, now time.Time) ([]byte, error) {
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return nil, fmt.Errorf("subject may not contain line breaks")
+	}
+	messageID, err := util.GetRandomString(24)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating message ID")
+	}
+	recipients := make([]string, len(to))
+	for idx, addr := range to {
+		recipients[idx] = addr.String()
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", messageID, domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	body := quotedprintable.NewWriter(&buf)
+	if _, err := body.Write([]byte(m.Body)); err != nil {
+		return nil, errors.Wrap(err, "encoding body")
+	}
+	if err := body.Close(); err != nil {
+		return nil, errors.Wrap(err, "encoding body")
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package mailtest provides an SMTP server that keeps the messages it
+// receives in memory, for testing code that sends email.
+package mailtest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a message received by a Sink
+type Message struct {
+	From   string
+	To     []string
+	Header mail.Header
+	// Body is the decoded body of the message
+	Body string
+}
+
+// NewSink starts a Sink listening on a random local port
+func NewSink() (*Sink, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	sink := &Sink{
+		listener: listener,
+		received: make(chan Message, 100),
+	}
+	go sink.serve()
+	return sink, nil
+}
+
+// Sink is a minimal SMTP server. It accepts any message, without
+// authentication or TLS.
+type Sink struct {
+	listener net.Listener
+	received chan Message
+
+	mux      sync.Mutex
+	messages []Message
+}
+
+// Host returns the address the sink listens on
+func (s *Sink) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port the sink listens on
+func (s *Sink) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the sink
+func (s *Sink) Close() error {
+	return s.listener.Close()
+}
+
+// Messages returns all messages received so far
+func (s *Sink) Messages() []Message {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Next waits up to timeout for the next message
+func (s *Sink) Next(timeout time.Duration) (Message, error) {
+	select {
+	case msg := <-s.received:
+		return msg, nil
+	case <-time.After(timeout):
+		return Message{}, fmt.Errorf("no message received within %s", timeout)
+	}
+}
+
+func (s *Sink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Sink) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	reply("220 mailtest ESMTP")
+	var msg Message
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 mailtest")
+		case "MAIL":
+			msg = Message{From: addressArg(line)}
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, addressArg(line))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := readData(reader)
+			if err != nil {
+				return
+			}
+			if err := parseData(&msg, data); err != nil {
+				reply("554 " + err.Error())
+				continue
+			}
+			s.mux.Lock()
+			s.messages = append(s.messages, msg)
+			s.mux.Unlock()
+			select {
+			case s.received <- msg:
+			default:
+			}
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// addressArg returns the address of a MAIL FROM or RCPT TO command
+func addressArg(line string) string {
+	start, end := strings.Index(line, "<"), strings.LastIndex(line, ">")
+	if start == -1 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+// readData reads the message sent after the DATA command, undoing dot
+// stuffing.
+func readData(reader *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == ".\r\n" || line == ".\n" {
+			return buf.Bytes(), nil
+		}
+		buf.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
+
+func parseData(msg *Message, data []byte) error {
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	var body io.Reader = parsed.Body
+	if strings.EqualFold(parsed.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+		body = quotedprintable.NewReader(body)
+	}
+	decoded, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	msg.Header = parsed.Header
+	msg.Body = strings.ReplaceAll(string(decoded), "\r\n", "\n")
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package mail
+
+import (
+	"fmt"
+	"time"
+)
+
+// NewPasswordResetMessage returns the email sent to a user that asked to
+// reset their password. The link is valid for validFor.
+func NewPasswordResetMessage(to, name, username, link string, validFor time.Duration) Message {
+	body := fmt.Sprintf(`Hi %s,
+
+Someone asked to reset the password of your gopherbin account %s.
+Open the link below to choose a new password. It can be used once, within
+%s.
+
+%s
+
+If you did not ask for this, you can ignore this email. Your password
+stays the same.
+`, name, username, validFor, link)
+	return Message{
+		To:      []string{to},
+		Subject: "Reset your gopherbin password",
+		Body:    body,
+	}
+}
+
+// NewEmailChangeMessage returns the email sent to the new address of a
+// user changing their email address. The link is valid for validFor.
+func NewEmailChangeMessage(to, name, username, link string, validFor time.Duration) Message {
+	body := fmt.Sprintf(`Hi %s,
+
+Someone asked to change the email address of your gopherbin account %s
+to this address. Open the link below to confirm the change. It can be used
+once, within %s.
+
+%s
+
+If you did not ask for this, you can ignore this email. The email address
+of the account stays the same.
+`, name, username, validFor, link)
+	return Message{
+		To:      []string{to},
+		Subject: "Confirm your new gopherbin email address",
+		Body:    body,
+	}
+}
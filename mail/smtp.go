@@ -0,0 +1,152 @@
+// Copyright 2019 Gabriel-Adrian Samfira
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gopherbin/config"
+)
+
+// NewSender returns a Sender delivering mail through the SMTP server
+// configured in cfg
+func NewSender(cfg config.Mail) (Sender, error) {
+	if !cfg.Enable {
+		return nil, fmt.Errorf("mail is not enabled")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "validating mail config")
+	}
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing from address")
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.Host,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CACertificate != "" {
+		caCertPEM, err := ioutil.ReadFile(cfg.CACertificate)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading CA certificate")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCertPEM) {
+			return nil, fmt.Errorf("failed to parse CA certificate")
+		}
+	}
+	return &smtpSender{
+		cfg:       cfg,
+		from:      from,
+		tlsConfig: tlsConfig,
+	}, nil
+}
+
+type smtpSender struct {
+	cfg       config.Mail
+	from      *mail.Address
+	tlsConfig *tls.Config
+}
+
+func (s *smtpSender) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if s.cfg.TLS == config.MailTLSImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to smtp server")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "starting smtp session")
+	}
+	if s.cfg.TLS == config.MailTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(s.tlsConfig); err != nil {
+			client.Close()
+			return nil, errors.Wrap(err, "starting TLS")
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			client.Close()
+			return nil, errors.Wrap(err, "authenticating to smtp server")
+		}
+	}
+	return client, nil
+}
+
+// Send delivers msg to the SMTP server
+func (s *smtpSender) Send(ctx context.Context, msg Message) error {
+	to, err := msg.recipients()
+	if err != nil {
+		return err
+	}
+	data, err := msg.encode(s.from, to, time.Now())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.TimeoutDuration())
+	defer cancel()
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(s.from.Address); err != nil {
+		return errors.Wrap(err, "setting sender")
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr.Address); err != nil {
+			return errors.Wrapf(err, "adding recipient %s", addr.Address)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return errors.Wrap(err, "starting message")
+	}
+	if _, err := writer.Write(data); err != nil {
+		return errors.Wrap(err, "writing message")
+	}
+	if err := writer.Close(); err != nil {
+		return errors.Wrap(err, "sending message")
+	}
+	return client.Quit()
+}
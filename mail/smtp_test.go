@@ -0,0 +1,113 @@
+package mail_test
+
+import (
+	"context"
+	"mime"
+	"strings"
+	"testing"
+	"time"
+
+	"gopherbin/config"
+	"gopherbin/mail"
+	"gopherbin/mail/mailtest"
+)
+
+func newSink(t *testing.T) *mailtest.Sink {
+	t.Helper()
+	sink, err := mailtest.NewSink()
+	if err != nil {
+		t.Fatalf("NewSink: %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink
+}
+
+func sinkConfig(sink *mailtest.Sink) config.Mail {
+	return config.Mail{
+		Enable:  true,
+		Host:    sink.Host(),
+		Port:    sink.Port(),
+		From:    "Gopherbin <gopherbin@example.com>",
+		TLS:     config.MailTLSNone,
+		BaseURL: "https://paste.example.com",
+	}
+}
+
+func TestSend(t *testing.T) {
+	sink := newSink(t)
+	sender, err := mail.NewSender(sinkConfig(sink))
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	body := "Grüße,\n" + strings.Repeat("a long line ", 20) + "\n.starts with a dot\n"
+	err = sender.Send(context.Background(), mail.Message{
+		To:      []string{"Bob <bob@example.com>"},
+		Subject: "Passwort zurücksetzen",
+		Body:    body,
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	msg, err := sink.Next(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.From != "gopherbin@example.com" {
+		t.Errorf("unexpected envelope sender %q", msg.From)
+	}
+	if len(msg.To) != 1 || msg.To[0] != "bob@example.com" {
+		t.Errorf("unexpected envelope recipients %v", msg.To)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Passwort zurücksetzen" {
+		t.Errorf("unexpected subject %q (%v)", subject, err)
+	}
+	if msg.Header.Get("Message-ID") == "" || msg.Header.Get("Date") == "" {
+		t.Error("expected Message-ID and Date headers")
+	}
+	if msg.Body != body {
+		t.Errorf("want body %q, got %q", body, msg.Body)
+	}
+}
+
+func TestSend_InvalidMessage(t *testing.T) {
+	sink := newSink(t)
+	sender, err := mail.NewSender(sinkConfig(sink))
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	cases := map[string]mail.Message{
+		"no recipients":     {Subject: "hi"},
+		"invalid recipient": {To: []string{"not an address"}, Subject: "hi"},
+		"header injection":  {To: []string{"bob@example.com"}, Subject: "hi\r\nBcc: eve@example.com"},
+	}
+	for name, msg := range cases {
+		if err := sender.Send(context.Background(), msg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if len(sink.Messages()) != 0 {
+		t.Errorf("expected no messages to be sent, got %d", len(sink.Messages()))
+	}
+}
+
+func TestSend_RequiresStartTLS(t *testing.T) {
+	sink := newSink(t)
+	cfg := sinkConfig(sink)
+	cfg.TLS = config.MailTLSStartTLS
+	sender, err := mail.NewSender(cfg)
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	err = sender.Send(context.Background(), mail.Message{To: []string{"bob@example.com"}, Subject: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("expected STARTTLS error, got %v", err)
+	}
+}
+
+func TestNewSender_Disabled(t *testing.T) {
+	if _, err := mail.NewSender(config.Mail{}); err == nil {
+		t.Fatal("expected error when mail is disabled")
+	}
+}
@@ -39,6 +39,7 @@ type Paste struct {
 	TeamID      *uint
 	Team        Teams   `gorm:"foreignKey:TeamID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	Users       []Users `gorm:"many2many:paste_users;constraint:OnDelete:CASCADE"`
+	Tags        []Tags  `gorm:"many2many:paste_tags;constraint:OnDelete:CASCADE"`
 }
 
 // Users represents a user entry in the database
@@ -54,6 +55,22 @@ type Users struct {
 	IsAdmin     bool
 	IsSuperUser bool
 	Enabled     bool
+	// AuthProvider and ExternalID identify users provisioned by an
+	// external identity provider. Both are empty for local users, even
+	// when they are linked to an external identity.
+	AuthProvider string  `gorm:"type:varchar(32);uniqueIndex:idx_external_id"`
+	ExternalID   *string `gorm:"type:varchar(255);uniqueIndex:idx_external_id"`
+	// SecurityStamp is embedded in every token issued to the user. It is
+	// rotated when the password changes, when the user is disabled or
+	// demoted, or when the user is logged out everywhere, invalidating
+	// all tokens issued before.
+	SecurityStamp string `gorm:"type:varchar(32)"`
+	// PendingApproval is set for users that registered without an
+	// invite code. They stay disabled until an admin approves them.
+	PendingApproval bool `gorm:"index"`
+	// LastLoginAt is the time the user last started a session. It is
+	// nil for users that never logged in.
+	LastLoginAt *time.Time `gorm:"index"`
 }
 
 // Teams represents a team of users
@@ -63,6 +80,15 @@ type Teams struct {
 	OwnerID uint
 	Owner   Users    `gorm:"foreignKey:OwnerID"`
 	Members []*Users `gorm:"many2many:team_users;constraint:OnDelete:CASCADE"`
+	// ManagedBy is the identity provider that created the team, and
+	// keeps its members in sync. It is empty for teams created by users.
+	ManagedBy string `gorm:"type:varchar(32);index"`
+}
+
+// Tags represents a label that can be attached to pastes
+type Tags struct {
+	ID   uint   `gorm:"primarykey"`
+	Name string `gorm:"type:varchar(64);uniqueIndex"`
 }
 
 // JWTBacklist is a JWT token blacklist
@@ -70,3 +96,137 @@ type JWTBacklist struct {
 	TokenID    string `gorm:"primarykey;type:varchar(16)"`
 	Expiration int64  `gorm:"index:expire"`
 }
+
+// APIToken is a personal access token. Only the SHA-256 hash of the
+// token is stored. The prefix is kept so users can tell tokens apart.
+type APIToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Name      string `gorm:"type:varchar(64)"`
+	Prefix    string `gorm:"type:varchar(16)"`
+	TokenHash string `gorm:"type:varchar(64);uniqueIndex"`
+	UserID    uint   `gorm:"index"`
+	User      Users  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Scopes is a space separated list of scopes
+	Scopes     string `gorm:"type:varchar(255)"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// ClientCertificate is a TLS client certificate registered by a user.
+// Only the SHA-256 fingerprint of the certificate is stored.
+type ClientCertificate struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	Name        string `gorm:"type:varchar(64)"`
+	Fingerprint string `gorm:"type:varchar(64);uniqueIndex"`
+	UserID      uint   `gorm:"index"`
+	User        Users  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	// Scopes is a space separated list of scopes
+	Scopes     string `gorm:"type:varchar(255)"`
+	LastUsedAt *time.Time
+}
+
+// ExternalIdentity links a local account to an identity of an external
+// identity provider, so the user can log in with either. The account
+// keeps its password, which is still managed by gopherbin.
+type ExternalIdentity struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Provider  string `gorm:"type:varchar(32);uniqueIndex:idx_identity_subject;uniqueIndex:idx_identity_user"`
+	Subject   string `gorm:"type:varchar(255);uniqueIndex:idx_identity_subject"`
+	UserID    uint   `gorm:"uniqueIndex:idx_identity_user"`
+	User      Users  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// Invite is a code issued by an admin, which allows registering an
+// account. Only the SHA-256 hash of the code is stored. Users registering
+// with an invite for a team are added to it.
+type Invite struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	Prefix      string `gorm:"type:varchar(16)"`
+	CodeHash    string `gorm:"type:varchar(64);uniqueIndex"`
+	CreatedByID uint   `gorm:"index"`
+	CreatedBy   Users  `gorm:"foreignKey:CreatedByID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Note        string `gorm:"type:varchar(255)"`
+	MaxUses     int
+	Uses        int
+	ExpiresAt   *time.Time
+	TeamID      *uint
+	Team        Teams `gorm:"foreignKey:TeamID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// SigningKey is a key used to sign login tokens. ID is sent as the kid
+// header of the tokens it signs. Ed25519 and RSA keys are stored PKCS #8
+// encoded, HMAC keys are stored as is.
+type SigningKey struct {
+	ID         string    `gorm:"type:varchar(32);primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	Algorithm  string    `gorm:"type:varchar(16)"`
+	PrivateKey []byte    `gorm:"type:blob"`
+}
+
+// TwoFactor holds the TOTP secret of a user. Two-factor authentication
+// is only enforced once the secret has been confirmed.
+type TwoFactor struct {
+	UserID      uint  `gorm:"primarykey;autoIncrement:false"`
+	User        Users `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	CreatedAt   time.Time
+	Secret      string `gorm:"type:varchar(64)"`
+	ConfirmedAt *time.Time
+	// LastCounter is the time step of the last code used. Codes are
+	// only accepted for later time steps, so they can't be replayed.
+	LastCounter int64
+}
+
+// Session is created every time a user logs in. ID is the ID of the JWT
+// token issued for the session. Tokens are only accepted while their
+// session exists, except tokens issued before sessions were introduced.
+type Session struct {
+	ID         string `gorm:"primarykey;type:varchar(16)"`
+	CreatedAt  time.Time
+	UserID     uint      `gorm:"index"`
+	User       Users     `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	ExpiresAt  time.Time `gorm:"index"`
+	LastSeenAt time.Time
+	IP         string `gorm:"type:varchar(45)"`
+	UserAgent  string `gorm:"type:varchar(255)"`
+}
+
+// LoginAttempt counts the recent failed logins for an account or a
+// client address. Subject is user:<id> for existing users, login:<name>
+// for unknown logins and ip:<address> for client addresses.
+type LoginAttempt struct {
+	Subject       string `gorm:"primarykey;type:varchar(255)"`
+	Failures      int
+	LastFailureAt time.Time `gorm:"index"`
+	LockedUntil   *time.Time
+}
+
+// RecoveryCode is a single use code which may be used instead of a TOTP
+// code. Only the SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index"`
+	User      Users  `gorm:"foreignKey:UserID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	CodeHash  string `gorm:"type:varchar(64);uniqueIndex"`
+}
+
+// AuditLog is an entry of the audit log. Entries are never updated or
+// deleted, and outlive the users and pastes they refer to, so there are
+// no foreign keys. Actor is the username of the actor when the action
+// happened.
+type AuditLog struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	Action     string    `gorm:"type:varchar(64);index"`
+	ActorID    uint      `gorm:"index"`
+	Actor      string    `gorm:"type:varchar(64)"`
+	TargetType string    `gorm:"type:varchar(32);index:idx_audit_target"`
+	TargetID   string    `gorm:"type:varchar(64);index:idx_audit_target"`
+	IP         string    `gorm:"type:varchar(45);index"`
+	RequestID  string    `gorm:"type:varchar(128)"`
+	Details    datatypes.JSON
+}
@@ -18,8 +18,8 @@ import (
 	"fmt"
 	"gopherbin/errors"
 	"gopherbin/util"
-
-	zxcvbn "github.com/nbutton23/zxcvbn-go"
+	"strings"
+	"time"
 )
 
 // NewUserParams holds the needed information to create
@@ -35,26 +35,41 @@ type NewUserParams struct {
 
 // Validate validates the object in order to determine
 // if the minimum required fields have proper values (email
-// is valid, password is of a decent strength etc).
+// is valid, username is alphanumeric etc). The password is
+// checked against the password policy by the user manager.
 func (u NewUserParams) Validate() error {
-	passwordStenght := zxcvbn.PasswordStrength(u.Password, nil)
-	if passwordStenght.Score < 4 {
-		return fmt.Errorf("the password is too weak, please use a stronger password")
-	}
+	var fields []errors.FieldError
 	if !util.IsValidEmail(u.Email) {
-		return fmt.Errorf("invalid email address %s", u.Email)
+		fields = append(fields, errors.FieldError{
+			Field:   "email",
+			Code:    errors.CodeInvalidEmail,
+			Message: fmt.Sprintf("invalid email address %s", u.Email),
+		})
 	}
 
 	if !util.IsAlphanumeric(u.Username) {
-		return fmt.Errorf("invalid username %s", u.Username)
+		fields = append(fields, errors.FieldError{
+			Field:   "username",
+			Code:    errors.CodeInvalidUsername,
+			Message: fmt.Sprintf("invalid username %s", u.Username),
+		})
 	}
 
 	if len(u.FullName) == 0 || len(u.FullName) > 255 {
-		return fmt.Errorf("full name may not be empty")
+		fields = append(fields, invalidFullNameField)
+	}
+	if len(fields) > 0 {
+		return errors.NewValidationError(fields...)
 	}
 	return nil
 }
 
+var invalidFullNameField = errors.FieldError{
+	Field:   "full_name",
+	Code:    errors.CodeInvalidFullName,
+	Message: "full name must be between 1 and 255 characters",
+}
+
 // UpdateUserPayload defines fields that may be updated
 // on a user entry
 type UpdateUserPayload struct {
@@ -67,21 +82,19 @@ type UpdateUserPayload struct {
 }
 
 // Validate validates the object in order to determine
-// if the minimum required fields have proper values (email
-// is valid, password is of a decent strength etc).
+// if the fields that are set have proper values. A new
+// password is checked against the password policy by the
+// user manager.
 func (u UpdateUserPayload) Validate() error {
-	if u.Password != nil {
-		passwordStenght := zxcvbn.PasswordStrength(*u.Password, nil)
-		if passwordStenght.Score < 4 {
-			return errors.NewBadRequestError("the password is too weak, please use a stronger password")
-		}
-	}
-
+	var fields []errors.FieldError
 	if u.FullName != nil {
 		if len(*u.FullName) == 0 || len(*u.FullName) > 255 {
-			return errors.NewBadRequestError("invalid full name")
+			fields = append(fields, invalidFullNameField)
 		}
 	}
+	if len(fields) > 0 {
+		return errors.NewValidationError(fields...)
+	}
 	return nil
 }
 
@@ -91,6 +104,13 @@ func (u UpdateUserPayload) Validate() error {
 type PasswordLoginParams struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Scopes limits what the returned token may be used for. All
+	// scopes are granted if omitted.
+	Scopes []string `json:"scopes,omitempty"`
+	// Cookie asks for a browser session kept in a cookie, instead of
+	// a token in the response. It is ignored if session cookies are
+	// disabled.
+	Cookie bool `json:"cookie,omitempty"`
 }
 
 // ID returns a xxhash (int64) of the username
@@ -113,9 +133,183 @@ func (p PasswordLoginParams) Validate() error {
 	return nil
 }
 
+// TwoFactorCodeParams holds a two-factor code, either generated by an
+// authenticator app, or a recovery code
+type TwoFactorCodeParams struct {
+	Code string `json:"code"`
+}
+
+// Validate checks that the code is set
+func (p TwoFactorCodeParams) Validate() error {
+	if strings.TrimSpace(p.Code) == "" {
+		return errors.NewValidationError(errors.FieldError{Field: "code", Code: errors.CodeRequired, Message: "a two-factor code is required"})
+	}
+	return nil
+}
+
+// TwoFactorLoginParams holds the information used to complete a login
+// for users with two-factor authentication enabled
+type TwoFactorLoginParams struct {
+	PreAuthToken string `json:"pre_auth_token"`
+	Code         string `json:"code"`
+	// Cookie asks for a browser session kept in a cookie.
+	Cookie bool `json:"cookie,omitempty"`
+}
+
+// ProxyLoginParams holds the optional parameters of a login through a
+// trusted reverse proxy
+type ProxyLoginParams struct {
+	// Cookie asks for a browser session kept in a cookie.
+	Cookie bool `json:"cookie,omitempty"`
+}
+
+// Validate checks that the pre-auth token and code are set
+func (p TwoFactorLoginParams) Validate() error {
+	var fields []errors.FieldError
+	if p.PreAuthToken == "" {
+		fields = append(fields, errors.FieldError{Field: "pre_auth_token", Code: errors.CodeRequired, Message: "a pre-auth token is required"})
+	}
+	if strings.TrimSpace(p.Code) == "" {
+		fields = append(fields, errors.FieldError{Field: "code", Code: errors.CodeRequired, Message: "a two-factor code is required"})
+	}
+	if len(fields) > 0 {
+		return errors.NewValidationError(fields...)
+	}
+	return nil
+}
+
+// ForgotPasswordParams holds the email address of a user that forgot
+// their password
+type ForgotPasswordParams struct {
+	Email string `json:"email"`
+}
+
+// Validate checks that the email address is valid
+func (p ForgotPasswordParams) Validate() error {
+	if !util.IsValidEmail(p.Email) {
+		return errors.NewValidationError(errors.FieldError{
+			Field:   "email",
+			Code:    errors.CodeInvalidEmail,
+			Message: fmt.Sprintf("invalid email address %s", p.Email),
+		})
+	}
+	return nil
+}
+
+// ResetPasswordParams holds the token emailed to a user that forgot
+// their password, and their new password
+type ResetPasswordParams struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Validate checks that the token and the new password are set. The
+// password is checked against the password policy by the user manager.
+func (p ResetPasswordParams) Validate() error {
+	var fields []errors.FieldError
+	if p.Token == "" {
+		fields = append(fields, errors.FieldError{Field: "token", Code: errors.CodeRequired, Message: "a password reset token is required"})
+	}
+	if p.Password == "" {
+		fields = append(fields, errors.FieldError{Field: "password", Code: errors.CodeRequired, Message: "a new password is required"})
+	}
+	if len(fields) > 0 {
+		return errors.NewValidationError(fields...)
+	}
+	return nil
+}
+
+// UpdateProfileParams holds the details users may change about their
+// own account
+type UpdateProfileParams struct {
+	FullName *string `json:"full_name,omitempty"`
+	Email    *string `json:"email,omitempty"`
+}
+
+// Validate checks the fields that are set
+func (p UpdateProfileParams) Validate() error {
+	var fields []errors.FieldError
+	if p.FullName != nil && (len(*p.FullName) == 0 || len(*p.FullName) > 255) {
+		fields = append(fields, invalidFullNameField)
+	}
+	if p.Email != nil && !util.IsValidEmail(*p.Email) {
+		fields = append(fields, errors.FieldError{
+			Field:   "email",
+			Code:    errors.CodeInvalidEmail,
+			Message: fmt.Sprintf("invalid email address %s", *p.Email),
+		})
+	}
+	if len(fields) > 0 {
+		return errors.NewValidationError(fields...)
+	}
+	return nil
+}
+
+// ChangePasswordParams holds the current and the new password of a user
+// changing their own password
+type ChangePasswordParams struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Validate checks that both passwords are set. The new password is
+// checked against the password policy by the user manager.
+func (p ChangePasswordParams) Validate() error {
+	var fields []errors.FieldError
+	if p.CurrentPassword == "" {
+		fields = append(fields, errors.FieldError{Field: "current_password", Code: errors.CodeRequired, Message: "the current password is required"})
+	}
+	if p.NewPassword == "" {
+		fields = append(fields, errors.FieldError{Field: "new_password", Code: errors.CodeRequired, Message: "a new password is required"})
+	}
+	if len(fields) > 0 {
+		return errors.NewValidationError(fields...)
+	}
+	return nil
+}
+
+// ConfirmEmailParams holds the token emailed to a user confirming a new
+// email address
+type ConfirmEmailParams struct {
+	Token string `json:"token"`
+}
+
+// Validate checks that the token is set
+func (p ConfirmEmailParams) Validate() error {
+	if p.Token == "" {
+		return errors.NewValidationError(errors.FieldError{Field: "token", Code: errors.CodeRequired, Message: "an email confirmation token is required"})
+	}
+	return nil
+}
+
+// RegisterParams holds the information needed by users registering
+// their own account. The invite code is required unless registration is
+// open to anyone.
+type RegisterParams struct {
+	Email      string `json:"email"`
+	Username   string `json:"username"`
+	FullName   string `json:"full_name"`
+	Password   string `json:"password"`
+	InviteCode string `json:"invite_code,omitempty"`
+}
+
+// Validate checks the new account the same way an account created by an
+// admin is checked
+func (p RegisterParams) Validate() error {
+	return NewUserParams{
+		Email:    p.Email,
+		Username: p.Username,
+		FullName: p.FullName,
+		Password: p.Password,
+	}.Validate()
+}
+
 // UpdatePasteParams is the payload we can send to update a paste.
+// Fields that are omitted are left untouched. Sending an empty
+// tags list removes all tags from the paste.
 type UpdatePasteParams struct {
-	Public bool `json:"public"`
+	Public *bool    `json:"public,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
 }
 
 // NewTeamParams holds information needed to create a new team.
@@ -129,3 +323,84 @@ type NewTeamParams struct {
 type UserActionRequest struct {
 	UserID string `json:"userID"`
 }
+
+// NewAPITokenParams holds information needed to create a personal
+// API token. Tokens without an expiry date never expire. Tokens created
+// without scopes get all the scopes of the token used to create them.
+type NewAPITokenParams struct {
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+}
+
+// Validate checks that the token has a name and that the expiry date,
+// if set, is in the future
+func (p NewAPITokenParams) Validate() error {
+	var fields []errors.FieldError
+	if name := strings.TrimSpace(p.Name); name == "" {
+		fields = append(fields, errors.FieldError{Field: "name", Code: errors.CodeRequired, Message: "a token name is required"})
+	} else if len(name) > 64 {
+		fields = append(fields, errors.FieldError{Field: "name", Code: errors.CodeValidationFailed, Message: "token names may be at most 64 characters long"})
+	}
+	if p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now()) {
+		fields = append(fields, errors.FieldError{Field: "expires_at", Code: errors.CodeValidationFailed, Message: "expiry date must be in the future"})
+	}
+	if len(fields) > 0 {
+		return errors.NewValidationError(fields...)
+	}
+	return nil
+}
+
+// NewClientCertificateParams holds information needed to register a TLS
+// client certificate. The certificate registered is the one presented on
+// the connection the request is made over, so only the holder of its
+// private key can register it. Certificates registered without scopes get
+// all the scopes of the credentials used to register them.
+type NewClientCertificateParams struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// Validate checks that the certificate has a name
+func (p NewClientCertificateParams) Validate() error {
+	var fields []errors.FieldError
+	if name := strings.TrimSpace(p.Name); name == "" {
+		fields = append(fields, errors.FieldError{Field: "name", Code: errors.CodeRequired, Message: "a certificate name is required"})
+	} else if len(name) > 64 {
+		fields = append(fields, errors.FieldError{Field: "name", Code: errors.CodeValidationFailed, Message: "certificate names may be at most 64 characters long"})
+	}
+	if len(fields) > 0 {
+		return errors.NewValidationError(fields...)
+	}
+	return nil
+}
+
+// NewInviteParams holds information needed to create an invite code.
+// Invites may be used once unless MaxUses is set, and never expire unless
+// ExpiresAt is set. Users registering with an invite for a team are added
+// to the team.
+type NewInviteParams struct {
+	Note      string     `json:"note,omitempty"`
+	MaxUses   int        `json:"max_uses,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Team      string     `json:"team,omitempty"`
+}
+
+// Validate checks that the number of uses is not negative and that the
+// expiry date, if set, is in the future
+func (p NewInviteParams) Validate() error {
+	var fields []errors.FieldError
+	if len(p.Note) > 255 {
+		fields = append(fields, errors.FieldError{Field: "note", Code: errors.CodeValidationFailed, Message: "notes may be at most 255 characters long"})
+	}
+	if p.MaxUses < 0 {
+		fields = append(fields, errors.FieldError{Field: "max_uses", Code: errors.CodeValidationFailed, Message: "the number of uses may not be negative"})
+	}
+	if p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now()) {
+		fields = append(fields, errors.FieldError{Field: "expires_at", Code: errors.CodeValidationFailed, Message: "expiry date must be in the future"})
+	}
+	if len(fields) > 0 {
+		return errors.NewValidationError(fields...)
+	}
+	return nil
+}
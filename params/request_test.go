@@ -1,8 +1,11 @@
 package params_test
 
 import (
+	"strings"
 	"testing"
+	"time"
 
+	gErrors "gopherbin/errors"
 	"gopherbin/params"
 )
 
@@ -20,15 +23,15 @@ func TestNewUserParams_Validate_Valid(t *testing.T) {
 	}
 }
 
-func TestNewUserParams_Validate_WeakPassword(t *testing.T) {
+func TestNewUserParams_Validate_LeavesPasswordToPolicy(t *testing.T) {
 	p := params.NewUserParams{
 		Email:    "user@example.com",
 		Username: "testuser",
 		FullName: "Test User",
 		Password: "password",
 	}
-	if err := p.Validate(); err == nil {
-		t.Fatal("expected error for weak password")
+	if err := p.Validate(); err != nil {
+		t.Fatalf("expected the password policy to be left to the user manager, got %v", err)
 	}
 }
 
@@ -88,3 +91,164 @@ func TestPasswordLoginParams_Validate(t *testing.T) {
 		}
 	})
 }
+
+func TestNewUserParams_Validate_ReportsAllFields(t *testing.T) {
+	p := params.NewUserParams{
+		Email:    "not-an-email",
+		Username: "test user",
+		Password: "password",
+	}
+	err := p.Validate()
+	badReq, ok := err.(*gErrors.BadRequestError)
+	if !ok {
+		t.Fatalf("expected *BadRequestError, got %T: %v", err, err)
+	}
+	if gErrors.Code(err) != gErrors.CodeValidationFailed {
+		t.Errorf("want code %q, got %q", gErrors.CodeValidationFailed, gErrors.Code(err))
+	}
+	got := map[string]string{}
+	for _, field := range badReq.Fields() {
+		got[field.Field] = field.Code
+	}
+	want := map[string]string{
+		"email":     gErrors.CodeInvalidEmail,
+		"username":  gErrors.CodeInvalidUsername,
+		"full_name": gErrors.CodeInvalidFullName,
+	}
+	for field, code := range want {
+		if got[field] != code {
+			t.Errorf("field %s: want code %q, got %q", field, code, got[field])
+		}
+	}
+}
+
+func TestForgotPasswordParams_Validate(t *testing.T) {
+	if err := (params.ForgotPasswordParams{Email: "user@example.com"}).Validate(); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+	err := params.ForgotPasswordParams{Email: "user"}.Validate()
+	badReq, ok := err.(*gErrors.BadRequestError)
+	if !ok || len(badReq.Fields()) != 1 || badReq.Fields()[0].Code != gErrors.CodeInvalidEmail {
+		t.Errorf("want invalid email field, got %v", err)
+	}
+}
+
+func TestResetPasswordParams_Validate(t *testing.T) {
+	if err := (params.ResetPasswordParams{Token: "token", Password: strongPassword}).Validate(); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+	err := params.ResetPasswordParams{}.Validate()
+	badReq, ok := err.(*gErrors.BadRequestError)
+	if !ok {
+		t.Fatalf("expected *BadRequestError, got %T: %v", err, err)
+	}
+	got := map[string]string{}
+	for _, field := range badReq.Fields() {
+		got[field.Field] = field.Code
+	}
+	if got["token"] != gErrors.CodeRequired || got["password"] != gErrors.CodeRequired {
+		t.Errorf("unexpected fields %v", got)
+	}
+}
+
+func TestUpdateProfileParams_Validate(t *testing.T) {
+	name, email := "Jane Doe", "jane@example.com"
+	if err := (params.UpdateProfileParams{FullName: &name, Email: &email}).Validate(); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+	if err := (params.UpdateProfileParams{}).Validate(); err != nil {
+		t.Errorf("expected an empty update to be valid, got %v", err)
+	}
+	empty, invalid := "", "jane"
+	err := params.UpdateProfileParams{FullName: &empty, Email: &invalid}.Validate()
+	badReq, ok := err.(*gErrors.BadRequestError)
+	if !ok {
+		t.Fatalf("expected *BadRequestError, got %T: %v", err, err)
+	}
+	got := map[string]string{}
+	for _, field := range badReq.Fields() {
+		got[field.Field] = field.Code
+	}
+	if got["full_name"] != gErrors.CodeInvalidFullName || got["email"] != gErrors.CodeInvalidEmail {
+		t.Errorf("unexpected fields %v", got)
+	}
+}
+
+func TestChangePasswordParams_Validate(t *testing.T) {
+	if err := (params.ChangePasswordParams{CurrentPassword: "old", NewPassword: strongPassword}).Validate(); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+	err := params.ChangePasswordParams{}.Validate()
+	badReq, ok := err.(*gErrors.BadRequestError)
+	if !ok {
+		t.Fatalf("expected *BadRequestError, got %T: %v", err, err)
+	}
+	got := map[string]string{}
+	for _, field := range badReq.Fields() {
+		got[field.Field] = field.Code
+	}
+	if got["current_password"] != gErrors.CodeRequired || got["new_password"] != gErrors.CodeRequired {
+		t.Errorf("unexpected fields %v", got)
+	}
+}
+
+func TestRegisterParams_Validate(t *testing.T) {
+	valid := params.RegisterParams{
+		Email:    "jane@example.com",
+		Username: "jane",
+		FullName: "Jane Doe",
+		Password: strongPassword,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+	invalid := valid
+	invalid.Username = "jane doe"
+	invalid.Email = "jane"
+	err := invalid.Validate()
+	badReq, ok := err.(*gErrors.BadRequestError)
+	if !ok {
+		t.Fatalf("expected *BadRequestError, got %T: %v", err, err)
+	}
+	got := map[string]string{}
+	for _, field := range badReq.Fields() {
+		got[field.Field] = field.Code
+	}
+	if got["username"] != gErrors.CodeInvalidUsername || got["email"] != gErrors.CodeInvalidEmail {
+		t.Errorf("unexpected fields %v", got)
+	}
+}
+
+func TestNewInviteParams_Validate(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	if err := (params.NewInviteParams{MaxUses: 5, ExpiresAt: &future}).Validate(); err != nil {
+		t.Errorf("expected valid, got %v", err)
+	}
+	cases := map[string]params.NewInviteParams{
+		"negative uses": {MaxUses: -1},
+		"expired":       {ExpiresAt: &past},
+		"long note":     {Note: strings.Repeat("a", 256)},
+	}
+	for name, p := range cases {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestNewClientCertificateParams_Validate(t *testing.T) {
+	if err := (params.NewClientCertificateParams{Name: "ci"}).Validate(); err != nil {
+		t.Fatalf("expected valid, got %v", err)
+	}
+	cases := map[string]params.NewClientCertificateParams{
+		"long name":  {Name: strings.Repeat("a", 65)},
+		"blank name": {Name: " "},
+	}
+	for name, p := range cases {
+		err := p.Validate()
+		if _, ok := err.(*gErrors.BadRequestError); !ok {
+			t.Errorf("%s: want BadRequestError, got %v", name, err)
+		}
+	}
+}
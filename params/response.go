@@ -44,6 +44,18 @@ type Users struct {
 	Enabled     bool      `json:"enabled"`
 	IsAdmin     bool      `json:"is_admin"`
 	IsSuperUser bool      `json:"is_superuser"`
+	// AuthProvider is set for users provisioned by an external
+	// identity provider
+	AuthProvider string `json:"auth_provider,omitempty"`
+	// SecurityStamp changes whenever the tokens of the user must be
+	// invalidated. It is never sent to clients.
+	SecurityStamp string `json:"-"`
+	// PendingApproval is set for users that registered themselves and
+	// wait for an admin to approve their account.
+	PendingApproval bool `json:"pending_approval,omitempty"`
+	// LastLoginAt is the time the user last logged in. It is not set for
+	// users that never logged in.
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
 }
 
 // FormattedCreatedAt returns a DD-MM-YY formatted createdAt
@@ -64,6 +76,75 @@ type UserListResult struct {
 	Users      []Users `json:"users"`
 }
 
+// UserDeletionReport describes what deleting a user deleted, or moved to
+// another user. Nothing is changed in a dry run.
+type UserDeletionReport struct {
+	DryRun bool  `json:"dry_run"`
+	User   Users `json:"user"`
+	// TransferTo is the user that received the pastes, teams and shares
+	// of the deleted user, if any.
+	TransferTo *Users `json:"transfer_to,omitempty"`
+	// PastesDeleted includes the pastes other users posted to teams owned
+	// by the deleted user.
+	PastesDeleted     int64 `json:"pastes_deleted"`
+	PastesTransferred int64 `json:"pastes_transferred"`
+	TeamsDeleted      int64 `json:"teams_deleted"`
+	TeamsTransferred  int64 `json:"teams_transferred"`
+	// SharesRemoved counts the pastes shared with the deleted user which
+	// are no longer shared. Shares are only removed when transferring if
+	// the new owner already has access to the paste.
+	SharesRemoved          int64 `json:"shares_removed"`
+	SharesTransferred      int64 `json:"shares_transferred"`
+	TeamMembershipsRemoved int64 `json:"team_memberships_removed"`
+}
+
+// UserDetails holds the details of a user shown to admins
+type UserDetails struct {
+	User       Users `json:"user"`
+	PasteCount int64 `json:"paste_count"`
+	// StorageUsed is the size, in bytes, of the pastes of the user.
+	StorageUsed int64 `json:"storage_used"`
+	// Teams lists the teams the user owns or is a member of.
+	Teams    []Teams   `json:"teams"`
+	Sessions []Session `json:"sessions"`
+}
+
+// Profile holds the details users see about their own account
+type Profile struct {
+	User       Users `json:"user"`
+	PasteCount int64 `json:"paste_count"`
+	// StorageUsed is the size, in bytes, of the pastes of the user.
+	StorageUsed int64 `json:"storage_used"`
+	// Teams lists the teams the user owns or is a member of.
+	Teams []Teams `json:"teams"`
+	// PendingEmail is the new email address of the user, waiting to be
+	// confirmed through the link sent to it. It is only set in response
+	// to a profile update.
+	PendingEmail string `json:"pending_email,omitempty"`
+}
+
+// DataExport is the manifest of a personal data export. The contents of
+// the pastes are stored in separate files of the export archive.
+type DataExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+	User       Users     `json:"user"`
+	// Teams lists the teams the user owns or is a member of.
+	Teams  []Teams         `json:"teams"`
+	Pastes []ExportedPaste `json:"pastes"`
+}
+
+// ExportedPaste describes a paste in a personal data export
+type ExportedPaste struct {
+	// Paste holds the metadata of the paste, without its contents.
+	Paste Paste `json:"paste"`
+	// File is the path of the file holding the contents of the paste
+	// in the export archive.
+	File string `json:"file"`
+	// Team is the name of the team the paste was posted to, if any.
+	Team       string       `json:"team,omitempty"`
+	SharedWith []TeamMember `json:"shared_with"`
+}
+
 // Paste holds information about a paste
 type Paste struct {
 	ID          uint              `json:"id"`
@@ -80,6 +161,7 @@ type Paste struct {
 	CreatedAt   time.Time         `json:"created_at"`
 	CreatedBy   string            `json:"created_by"`
 	Metadata    map[string]string `json:"metadata"`
+	Tags        []string          `json:"tags"`
 }
 
 // FormattedCreatedAt returns a DD-MM-YY formatted createdAt
@@ -111,6 +193,17 @@ type TeamListResult struct {
 	Teams      []Teams `json:"teams"`
 }
 
+// TagCount holds the number of pastes a tag is attached to
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// TagListResult holds results for a tag list request
+type TagListResult struct {
+	Tags []TagCount `json:"tags"`
+}
+
 type PasteShareListResponse struct {
 	Users []TeamMember `json:"users"`
 }
@@ -118,5 +211,161 @@ type PasteShareListResponse struct {
 // JWTResponse holds the JWT token returned as a result of a
 // successful auth
 type JWTResponse struct {
-	Token string `json:"token"`
+	Token string `json:"token,omitempty"`
+	// TwoFactorRequired is set, instead of Token, when the user has
+	// two-factor authentication enabled. The login is completed by
+	// sending PreAuthToken along with a two-factor code.
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	PreAuthToken      string `json:"pre_auth_token,omitempty"`
+	// TwoFactorEnrollmentRequired is set when the user must enable
+	// two-factor authentication. Token only grants the account scope
+	// until they do.
+	TwoFactorEnrollmentRequired bool `json:"two_factor_enrollment_required,omitempty"`
+	// CSRFToken is set, instead of Token, when the session is kept in a
+	// cookie. It must be sent in the X-CSRF-Token header of every
+	// request that changes state.
+	CSRFToken string `json:"csrf_token,omitempty"`
+	// User is the user that logged in. It is only set for cookie
+	// sessions, as the token can not be decoded by the client.
+	User *Users `json:"user,omitempty"`
+}
+
+// APIToken holds information about a personal API token. The token
+// itself is only returned once, when the token is created.
+type APIToken struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Token      string     `json:"token,omitempty"`
+	UserID     uint       `json:"user_id"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// APITokenListResult holds results for an API token list request
+type APITokenListResult struct {
+	Tokens []APIToken `json:"tokens"`
+}
+
+// ClientCertificate holds information about a TLS client certificate
+// registered by a user
+type ClientCertificate struct {
+	ID          uint       `json:"id"`
+	Name        string     `json:"name"`
+	Fingerprint string     `json:"fingerprint"`
+	UserID      uint       `json:"user_id"`
+	Scopes      []string   `json:"scopes"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ClientCertificateListResult holds results for a client certificate
+// list request
+type ClientCertificateListResult struct {
+	Certificates []ClientCertificate `json:"certificates"`
+}
+
+// Invite holds information about an invite code. The code itself is only
+// returned once, when the invite is created.
+type Invite struct {
+	ID        uint       `json:"id"`
+	Code      string     `json:"code,omitempty"`
+	Prefix    string     `json:"prefix"`
+	Note      string     `json:"note,omitempty"`
+	CreatedBy uint       `json:"created_by"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	Team      string     `json:"team,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// InviteListResult holds results for an invite list request
+type InviteListResult struct {
+	Invites []Invite `json:"invites"`
+}
+
+// Session holds information about a login session. Current is set for
+// the session used to make the request.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     uint      `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
+}
+
+// SessionListResult holds results for a session list request
+type SessionListResult struct {
+	Sessions []Session `json:"sessions"`
+}
+
+// TwoFactorStatus holds the two-factor authentication settings of a user
+type TwoFactorStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// TwoFactorEnrollment holds the secret of a TOTP authenticator that is
+// being enrolled. The secret is only returned once.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth URI used to add the secret to an
+	// authenticator app.
+	URI string `json:"uri"`
+	// QRCode is a PNG image of a QR code holding URI
+	QRCode []byte `json:"qr_code"`
+}
+
+// RecoveryCodes holds single use recovery codes. They are only returned
+// once, when they are generated.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// JSONWebKey is the public part of a key used to sign login tokens
+type JSONWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// Crv and X are set for Ed25519 keys
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JSONWebKeySet holds the public keys other services may use to verify
+// login tokens
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// AuditEvent is an entry of the audit log. ActorID is 0 for anonymous
+// users, such as someone failing to log in or viewing a public paste.
+type AuditEvent struct {
+	ID         uint              `json:"id,omitempty"`
+	Time       time.Time         `json:"time"`
+	Action     string            `json:"action"`
+	ActorID    uint              `json:"actor_id"`
+	Actor      string            `json:"actor,omitempty"`
+	TargetType string            `json:"target_type,omitempty"`
+	TargetID   string            `json:"target_id,omitempty"`
+	IP         string            `json:"ip,omitempty"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+// AuditLogListResult holds results for an audit log query
+type AuditLogListResult struct {
+	TotalPages int64        `json:"total_pages"`
+	Events     []AuditEvent `json:"events"`
 }
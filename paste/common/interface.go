@@ -29,16 +29,31 @@ type Paster interface {
 		expires *time.Time,
 		isPublic bool, team string,
 		metadata map[string]string,
-		maxAccesses *int) (paste params.Paste, err error)
+		maxAccesses *int, tags []string) (paste params.Paste, err error)
 	Get(ctx context.Context, pasteID string) (paste params.Paste, err error)
 	GetPublicPaste(ctx context.Context, pasteID string) (paste params.Paste, err error)
-	List(ctx context.Context, page int64, results int64) (paste params.PasteListResult, err error)
-	Search(ctx context.Context, query string, page int64, results int64) (paste params.PasteListResult, err error)
+	// List returns the pastes owned by the user. If tags are specified, only pastes
+	// that have all of the tags are returned.
+	List(ctx context.Context, page int64, results int64, tags []string) (paste params.PasteListResult, err error)
+	// Search searches the pastes owned by the user. If tags are specified, only pastes
+	// that have all of the tags are returned.
+	Search(ctx context.Context, query string, page int64, results int64, tags []string) (paste params.PasteListResult, err error)
 	Delete(ctx context.Context, pasteID string) error
 	SetPrivacy(ctx context.Context, pasteID string, public bool) (params.Paste, error)
+	// SetTags replaces the tags of a paste. Only the owner of the paste, or the owner
+	// of the team the paste belongs to, may change tags.
+	SetTags(ctx context.Context, pasteID string, tags []string) (params.Paste, error)
+	// ListTags returns all tags visible to the user, along with the number of pastes
+	// each tag is attached to.
+	ListTags(ctx context.Context) (params.TagListResult, error)
 	ShareWithUser(ctx context.Context, pasteID string, userID string) (params.TeamMember, error)
 	UnshareWithUser(ctx context.Context, pasteID string, userID string) error
 	ListShares(ctx context.Context, pasteID string) (params.PasteShareListResponse, error)
+	// Export calls fn with every paste owned by userID, oldest first, along
+	// with its contents, team and the users it is shared with. Pastes are
+	// fetched in batches, so exporting does not load all of them in memory.
+	// Users may export their own pastes, admins those of any user.
+	Export(ctx context.Context, userID uint, fn func(params.ExportedPaste) error) error
 }
 
 type TeamManager interface {
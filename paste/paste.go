@@ -17,30 +17,31 @@ package paste
 import (
 	"fmt"
 
+	"gopherbin/audit"
 	"gopherbin/config"
 	"gopherbin/paste/common"
 	"gopherbin/paste/sql"
 )
 
 // NewPaster returns a new paste implementation based on the configured
-// database backend
-func NewPaster(dbCfg config.Database) (common.Paster, error) {
+// database backend. Actions on pastes are recorded through recorder.
+func NewPaster(dbCfg config.Database, recorder audit.Recorder) (common.Paster, error) {
 	dbBackend := dbCfg.DbBackend
 	switch dbBackend {
 	case config.MySQLBackend, config.SQLiteBackend:
-		return sql.NewPaster(dbCfg)
+		return sql.NewPaster(dbCfg, recorder)
 	default:
 		return nil, fmt.Errorf("no paste backend available for db backend %s", dbBackend)
 	}
 }
 
 // NewTeamManager returns a new team manager implementation based on the configured
-// database backend
-func NewTeamManager(dbCfg config.Database) (common.TeamManager, error) {
+// database backend. Changes to teams are recorded through recorder.
+func NewTeamManager(dbCfg config.Database, recorder audit.Recorder) (common.TeamManager, error) {
 	dbBackend := dbCfg.DbBackend
 	switch dbBackend {
 	case config.MySQLBackend, config.SQLiteBackend:
-		return sql.NewTeamManager(dbCfg)
+		return sql.NewTeamManager(dbCfg, recorder)
 	default:
 		return nil, fmt.Errorf("no team manager backend available for db backend %s", dbBackend)
 	}
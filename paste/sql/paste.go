@@ -19,8 +19,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
 	"time"
 
+	"gopherbin/audit"
 	"gopherbin/auth"
 	"gopherbin/config"
 	gErrors "gopherbin/errors"
@@ -35,19 +37,26 @@ import (
 	"github.com/pkg/errors"
 )
 
-// NewPaster returns a SQL backed paste implementation
-func NewPaster(dbCfg config.Database) (common.Paster, error) {
+// NewPaster returns a SQL backed paste implementation. Changes to pastes,
+// and views of pastes, are recorded in the audit log through recorder,
+// which may be nil.
+func NewPaster(dbCfg config.Database, recorder audit.Recorder) (common.Paster, error) {
 	db, err := util.NewDBConn(dbCfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "connecting to database")
 	}
+	if recorder == nil {
+		recorder = audit.Discard
+	}
 
 	p := &paste{
 		conn:      db,
 		dbBackend: dbCfg.DbBackend,
 		teamMgr: &teamManager{
-			conn: db,
+			conn:     db,
+			recorder: recorder,
 		},
+		recorder: recorder,
 	}
 	if err := p.migrateDB(); err != nil {
 		return nil, errors.Wrap(err, "migrating DB")
@@ -59,6 +68,18 @@ type paste struct {
 	conn      *gorm.DB
 	dbBackend config.DBBackendType
 	teamMgr   common.TeamManager
+	recorder  audit.Recorder
+}
+
+// record records action on the paste identified by pasteID in the audit
+// log
+func (p *paste) record(ctx context.Context, action, pasteID string, details map[string]string) {
+	p.recorder.Record(ctx, params.AuditEvent{
+		Action:     action,
+		TargetType: audit.TargetPaste,
+		TargetID:   pasteID,
+		Details:    details,
+	})
 }
 
 func (p *paste) migrateDB() error {
@@ -66,10 +87,24 @@ func (p *paste) migrateDB() error {
 		&models.Users{},
 		&models.Paste{},
 		&models.Teams{},
+		&models.Tags{},
 		&models.JWTBacklist{},
+		&models.APIToken{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.Session{},
+		&models.LoginAttempt{},
+		&models.Invite{},
+		&models.SigningKey{},
+		&models.ClientCertificate{},
+		&models.ExternalIdentity{},
+		&models.AuditLog{},
 	); err != nil {
 		return err
 	}
+	if err := p.populateSecurityStamps(); err != nil {
+		return errors.Wrap(err, "populating security stamps")
+	}
 
 	// Setup full-text search based on database backend
 	switch p.dbBackend {
@@ -176,6 +211,29 @@ func (p *paste) migrateDB() error {
 	return nil
 }
 
+// populateSecurityStamps gives a security stamp to users created before
+// security stamps were introduced. The updated_at column is left alone, so
+// tokens issued to them before, which are checked against it instead, keep
+// working until they expire.
+func (p *paste) populateSecurityStamps() error {
+	var users []models.Users
+	q := p.conn.Select("id").Where("security_stamp = '' or security_stamp is null").Find(&users)
+	if q.Error != nil {
+		return errors.Wrap(q.Error, "fetching users")
+	}
+	for _, user := range users {
+		stamp, err := auth.NewSecurityStamp()
+		if err != nil {
+			return err
+		}
+		q = p.conn.Model(&models.Users{}).Where("id = ?", user.ID).UpdateColumn("security_stamp", stamp)
+		if q.Error != nil {
+			return errors.Wrapf(q.Error, "updating user %d", user.ID)
+		}
+	}
+	return nil
+}
+
 func (p *paste) getUserFromContext(ctx context.Context) (models.Users, error) {
 	if auth.IsAnonymous(ctx) || !auth.IsEnabled(ctx) {
 		return models.Users{}, gErrors.ErrUnauthorized
@@ -194,7 +252,7 @@ func (p *paste) getUser(userID uint) (models.Users, error) {
 	q := p.conn.Preload("MemberOf").Where("id = ?", userID).First(&tmpUser)
 	if q.Error != nil {
 		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-			return models.Users{}, gErrors.ErrNotFound
+			return models.Users{}, gErrors.ErrUserNotFound
 		}
 		return models.Users{}, errors.Wrap(q.Error, "fetching user from database")
 	}
@@ -212,7 +270,7 @@ func (p *paste) getUserByUsernameOrEmail(userID string) (models.Users, error) {
 	q := p.conn.Preload("MemberOf").Where(queryString, userID).First(&tmpUser)
 	if q.Error != nil {
 		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-			return models.Users{}, gErrors.ErrNotFound
+			return models.Users{}, gErrors.ErrUserNotFound
 		}
 		return models.Users{}, errors.Wrap(q.Error, "fetching user from database")
 	}
@@ -258,7 +316,12 @@ func (p *paste) sqlToCommonPaste(modelPaste models.Paste, withPreview bool) para
 		AccessCount: modelPaste.AccessCount,
 		CreatedBy:   modelPaste.Owner.FullName,
 		Metadata:    metadata,
+		Tags:        make([]string, len(modelPaste.Tags)),
 	}
+	for idx, tag := range modelPaste.Tags {
+		paste.Tags[idx] = tag.Name
+	}
+	sort.Strings(paste.Tags)
 	if withPreview {
 		paste.Preview = modelPaste.Data
 	} else {
@@ -267,13 +330,49 @@ func (p *paste) sqlToCommonPaste(modelPaste models.Paste, withPreview bool) para
 	return paste
 }
 
+// getOrCreateTags returns the tag models for the supplied (normalized) tag names,
+// creating any tag that does not yet exist.
+func (p *paste) getOrCreateTags(tx *gorm.DB, names []string) ([]models.Tags, error) {
+	if len(names) == 0 {
+		return []models.Tags{}, nil
+	}
+	newTags := make([]models.Tags, len(names))
+	for idx, name := range names {
+		newTags[idx] = models.Tags{Name: name}
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&newTags).Error; err != nil {
+		return nil, errors.Wrap(err, "creating tags")
+	}
+
+	var tags []models.Tags
+	if err := tx.Where("name IN ?", names).Find(&tags).Error; err != nil {
+		return nil, errors.Wrap(err, "fetching tags")
+	}
+	return tags, nil
+}
+
+// withTags limits a paste query to pastes that have all of the supplied
+// (normalized) tags.
+func (p *paste) withTags(q *gorm.DB, tags []string) *gorm.DB {
+	if len(tags) == 0 {
+		return q
+	}
+	tagged := p.conn.Table("paste_tags").
+		Select("paste_tags.paste_id").
+		Joins("INNER JOIN tags ON tags.id = paste_tags.tags_id").
+		Where("tags.name IN ?", tags).
+		Group("paste_tags.paste_id").
+		Having("COUNT(DISTINCT tags.name) = ?", len(tags))
+	return q.Where("pastes.id IN (?)", tagged)
+}
+
 func (p *paste) Create(
 	ctx context.Context, data []byte,
 	title, language, description string,
 	expires *time.Time,
 	isPublic bool, team string,
 	metadata map[string]string,
-	maxAccesses *int) (paste params.Paste, err error) {
+	maxAccesses *int, tags []string) (paste params.Paste, err error) {
 
 	pasteID, err := util.GetRandomString(24)
 	if err != nil {
@@ -284,10 +383,28 @@ func (p *paste) Create(
 	if err != nil {
 		return params.Paste{}, errors.Wrap(err, "fetching user")
 	}
-	if len(data) == 0 || len(title) == 0 {
-		// TODO: create some custom error types
-		fmt.Printf("data --> %v --> title: %v\n", data, title)
-		return params.Paste{}, gErrors.ErrBadRequest
+	var fields []gErrors.FieldError
+	if len(data) == 0 {
+		fields = append(fields, gErrors.FieldError{
+			Field:   "data",
+			Code:    gErrors.CodeRequired,
+			Message: "paste data may not be empty",
+		})
+	}
+	if len(title) == 0 {
+		fields = append(fields, gErrors.FieldError{
+			Field:   "name",
+			Code:    gErrors.CodeRequired,
+			Message: "paste name may not be empty",
+		})
+	}
+	if len(fields) > 0 {
+		return params.Paste{}, gErrors.NewValidationError(fields...)
+	}
+
+	tags, err = util.NormalizeTags(tags)
+	if err != nil {
+		return params.Paste{}, gErrors.WithCode(gErrors.NewBadRequestError("invalid tags: %s", err), gErrors.CodeInvalidTags)
 	}
 
 	var encodedMetadata []byte
@@ -311,10 +428,24 @@ func (p *paste) Create(
 		Metadata:    encodedMetadata,
 		MaxAccesses: maxAccesses,
 	}
-	q := p.conn.Create(&newPaste)
-	if q.Error != nil {
-		return params.Paste{}, errors.Wrap(q.Error, "creating paste")
+	err = p.conn.Transaction(func(tx *gorm.DB) error {
+		tagModels, err := p.getOrCreateTags(tx, tags)
+		if err != nil {
+			return err
+		}
+		newPaste.Tags = tagModels
+		if err := tx.Create(&newPaste).Error; err != nil {
+			return errors.Wrap(err, "creating paste")
+		}
+		return nil
+	})
+	if err != nil {
+		return params.Paste{}, err
 	}
+	p.record(ctx, audit.ActionPasteCreate, pasteID, map[string]string{
+		"name":   title,
+		"public": fmt.Sprintf("%t", isPublic),
+	})
 	return p.sqlToCommonPaste(newPaste, false), nil
 }
 
@@ -356,11 +487,11 @@ func (p *paste) GetPublicPaste(ctx context.Context, pasteID string) (params.Past
 	var tmpPaste models.Paste
 	err := p.conn.Transaction(func(tx *gorm.DB) error {
 		now := time.Now()
-		q := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(
+		q := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Tags").Where(
 			"paste_id = ? and (expires is NULL or expires >= ?) and public = ?", pasteID, now, true).First(&tmpPaste)
 		if q.Error != nil {
 			if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-				return gErrors.ErrNotFound
+				return gErrors.ErrPasteNotFound
 			}
 			return errors.Wrap(q.Error, "fetching paste from database")
 		}
@@ -372,6 +503,7 @@ func (p *paste) GetPublicPaste(ctx context.Context, pasteID string) (params.Past
 	if err != nil {
 		return params.Paste{}, err
 	}
+	p.record(ctx, audit.ActionPasteView, pasteID, nil)
 	return p.sqlToCommonPaste(tmpPaste, false), nil
 }
 
@@ -379,16 +511,16 @@ func (p *paste) getPaste(pasteID string, user models.Users) (models.Paste, error
 	var tmpPaste models.Paste
 	err := p.conn.Transaction(func(tx *gorm.DB) error {
 		now := time.Now()
-		q := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Users").Preload("Owner").Where(
+		q := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Users").Preload("Owner").Preload("Team").Preload("Tags").Where(
 			"paste_id = ? and (expires is NULL or expires >= ?)", pasteID, now).First(&tmpPaste)
 		if q.Error != nil {
 			if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-				return gErrors.ErrNotFound
+				return gErrors.ErrPasteNotFound
 			}
 			return errors.Wrap(q.Error, "fetching paste from database")
 		}
 		if canAccess := p.canAccess(tmpPaste, user); !canAccess {
-			return gErrors.ErrNotFound
+			return gErrors.ErrPasteNotFound
 		}
 		if tmpPaste.MaxAccesses != nil {
 			return p.incrementAndMaybeDestroy(tx, &tmpPaste)
@@ -418,14 +550,19 @@ func (p *paste) Get(ctx context.Context, pasteID string) (paste params.Paste, er
 	if err != nil {
 		return params.Paste{}, errors.Wrap(err, "fetching paste")
 	}
+	p.record(ctx, audit.ActionPasteView, pasteID, nil)
 	return p.sqlToCommonPaste(pst, false), nil
 }
 
-func (p *paste) Search(ctx context.Context, query string, page int64, results int64) (params.PasteListResult, error) {
+func (p *paste) Search(ctx context.Context, query string, page int64, results int64, tags []string) (params.PasteListResult, error) {
 	user, err := p.getUserFromContext(ctx)
 	if err != nil {
 		return params.PasteListResult{}, errors.Wrap(err, "fetching user from DB")
 	}
+	tags, err = util.NormalizeTags(tags)
+	if err != nil {
+		return params.PasteListResult{}, gErrors.WithCode(gErrors.NewBadRequestError("invalid tags: %s", err), gErrors.CodeInvalidTags)
+	}
 	if page == 0 {
 		page = 1
 	}
@@ -490,13 +627,14 @@ func (p *paste) Search(ctx context.Context, query string, page int64, results in
 			"id, paste_id, language, name, description, metadata, owner_id as owner, created_at, expires, public, substr(`data`, 1, 512) as data",
 		).Where("owner_id = ? and name LIKE ? and (expires is NULL or expires >= ?)", user.ID, searchPattern, now).Order("id desc")
 	}
+	q = p.withTags(q, tags)
 
 	cntQ := q.Model(&models.Paste{}).Count(&cnt)
 	if cntQ.Error != nil {
 		return params.PasteListResult{}, errors.Wrap(cntQ.Error, "counting results")
 	}
 
-	resQ := q.Offset(int(startFrom)).Limit(int(results)).Find(&pasteResults)
+	resQ := q.Preload("Tags").Offset(int(startFrom)).Limit(int(results)).Find(&pasteResults)
 	if resQ.Error != nil {
 		if errors.Is(resQ.Error, gorm.ErrRecordNotFound) {
 			return params.PasteListResult{}, gErrors.ErrNotFound
@@ -536,14 +674,19 @@ func (p *paste) Delete(ctx context.Context, pasteID string) error {
 	if q.Error != nil && !errors.Is(q.Error, gorm.ErrRecordNotFound) {
 		return errors.Wrap(q.Error, "deleting paste")
 	}
+	p.record(ctx, audit.ActionPasteDelete, pasteID, map[string]string{"name": pst.Name})
 	return nil
 }
 
-func (p *paste) List(ctx context.Context, page int64, results int64) (paste params.PasteListResult, err error) {
+func (p *paste) List(ctx context.Context, page int64, results int64, tags []string) (paste params.PasteListResult, err error) {
 	user, err := p.getUserFromContext(ctx)
 	if err != nil {
 		return params.PasteListResult{}, errors.Wrap(err, "fetching user from DB")
 	}
+	tags, err = util.NormalizeTags(tags)
+	if err != nil {
+		return params.PasteListResult{}, gErrors.WithCode(gErrors.NewBadRequestError("invalid tags: %s", err), gErrors.CodeInvalidTags)
+	}
 	if page == 0 {
 		page = 1
 	}
@@ -559,13 +702,14 @@ func (p *paste) List(ctx context.Context, page int64, results int64) (paste para
 	q := p.conn.Select(
 		"id, paste_id, language, name, description, metadata, owner_id as owner, created_at, expires, public, substr(`data`, 1, 512) as data",
 	).Where("owner_id = ? and (expires is NULL or expires >= ?)", user.ID, now).Order("id desc")
+	q = p.withTags(q, tags)
 
 	cntQ := q.Model(&models.Paste{}).Count(&cnt)
 	if cntQ.Error != nil {
 		return params.PasteListResult{}, errors.Wrap(cntQ.Error, "counting results")
 	}
 
-	resQ := q.Offset(int(startFrom)).Limit(int(results)).Find(&pasteResults)
+	resQ := q.Preload("Tags").Offset(int(startFrom)).Limit(int(results)).Find(&pasteResults)
 	if resQ.Error != nil {
 		if errors.Is(resQ.Error, gorm.ErrRecordNotFound) {
 			return params.PasteListResult{}, gErrors.ErrNotFound
@@ -616,6 +760,7 @@ func (p *paste) ShareWithUser(ctx context.Context, pasteID string, userID string
 	if err := p.conn.Model(&pst).Association("Users").Append(&targetUser); err != nil {
 		return params.TeamMember{}, errors.Wrap(err, "sharing with user")
 	}
+	p.record(ctx, audit.ActionPasteShare, pasteID, memberDetails(targetUser))
 	return sqlUserToTeamMember(targetUser), nil
 }
 
@@ -641,6 +786,7 @@ func (p *paste) UnshareWithUser(ctx context.Context, pasteID string, userID stri
 		}
 		return errors.Wrap(err, "unsharing with user")
 	}
+	p.record(ctx, audit.ActionPasteUnshare, pasteID, memberDetails(targetUser))
 	return nil
 }
 
@@ -672,6 +818,50 @@ func (p *paste) ListShares(ctx context.Context, pasteID string) (params.PasteSha
 	}, nil
 }
 
+// pasteExportBatchSize is the number of pastes loaded at once while
+// exporting. Pastes are loaded along with their contents, so the batches
+// are kept small.
+const pasteExportBatchSize = 20
+
+func (p *paste) Export(ctx context.Context, userID uint, fn func(params.ExportedPaste) error) error {
+	if auth.IsAnonymous(ctx) || (auth.UserID(ctx) != userID && !auth.IsAdmin(ctx)) {
+		return gErrors.ErrUnauthorized
+	}
+	if _, err := p.getUser(userID); err != nil {
+		return errors.Wrap(err, "fetching user")
+	}
+
+	var lastID uint
+	for {
+		var pastes []models.Paste
+		q := p.conn.Preload("Owner").Preload("Tags").Preload("Team").Preload("Users").
+			Where("owner_id = ? and id > ?", userID, lastID).
+			Order("id asc").
+			Limit(pasteExportBatchSize).
+			Find(&pastes)
+		if q.Error != nil {
+			return errors.Wrap(q.Error, "fetching pastes")
+		}
+		for _, pst := range pastes {
+			exported := params.ExportedPaste{
+				Paste:      p.sqlToCommonPaste(pst, false),
+				Team:       pst.Team.Name,
+				SharedWith: make([]params.TeamMember, len(pst.Users)),
+			}
+			for idx, user := range pst.Users {
+				exported.SharedWith[idx] = sqlUserToTeamMember(user)
+			}
+			if err := fn(exported); err != nil {
+				return err
+			}
+		}
+		if len(pastes) < pasteExportBatchSize {
+			return nil
+		}
+		lastID = pastes[len(pastes)-1].ID
+	}
+}
+
 func (p *paste) SetPrivacy(ctx context.Context, pasteID string, public bool) (params.Paste, error) {
 	pst, err := p.get(ctx, pasteID)
 	if err != nil {
@@ -682,5 +872,80 @@ func (p *paste) SetPrivacy(ctx context.Context, pasteID string, public bool) (pa
 	if q.Error != nil {
 		return params.Paste{}, errors.Wrap(q.Error, "saving paste to DB")
 	}
+	p.record(ctx, audit.ActionPastePrivacy, pasteID, map[string]string{"public": fmt.Sprintf("%t", public)})
 	return p.sqlToCommonPaste(pst, true), nil
 }
+
+func (p *paste) SetTags(ctx context.Context, pasteID string, tags []string) (params.Paste, error) {
+	ctxUserID := auth.UserID(ctx)
+
+	tags, err := util.NormalizeTags(tags)
+	if err != nil {
+		return params.Paste{}, gErrors.WithCode(gErrors.NewBadRequestError("invalid tags: %s", err), gErrors.CodeInvalidTags)
+	}
+
+	pst, err := p.get(ctx, pasteID)
+	if err != nil {
+		return params.Paste{}, errors.Wrap(err, "fetching paste")
+	}
+	if pst.Owner.ID != ctxUserID && pst.Team.OwnerID != ctxUserID {
+		return params.Paste{}, errors.Wrap(gErrors.ErrUnauthorized, "tagging foreign paste")
+	}
+
+	err = p.conn.Transaction(func(tx *gorm.DB) error {
+		tagModels, err := p.getOrCreateTags(tx, tags)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&pst).Association("Tags").Replace(tagModels); err != nil {
+			return errors.Wrap(err, "updating tags")
+		}
+		pst.Tags = tagModels
+		return nil
+	})
+	if err != nil {
+		return params.Paste{}, err
+	}
+	return p.sqlToCommonPaste(pst, true), nil
+}
+
+func (p *paste) ListTags(ctx context.Context) (params.TagListResult, error) {
+	user, err := p.getUserFromContext(ctx)
+	if err != nil {
+		return params.TagListResult{}, errors.Wrap(err, "fetching user from DB")
+	}
+
+	// Tags on team pastes are visible to every member of the team, as well
+	// as to the team owner.
+	var teamIDs []uint
+	if err := p.conn.Model(&models.Teams{}).Where("owner_id = ?", user.ID).Pluck("id", &teamIDs).Error; err != nil {
+		return params.TagListResult{}, errors.Wrap(err, "fetching teams")
+	}
+	for _, team := range user.MemberOf {
+		teamIDs = append(teamIDs, team.ID)
+	}
+
+	visible := "pastes.owner_id = ?"
+	args := []interface{}{user.ID}
+	if len(teamIDs) > 0 {
+		visible = "(pastes.owner_id = ? OR pastes.team_id IN ?)"
+		args = append(args, teamIDs)
+	}
+	args = append(args, time.Now())
+
+	tags := []params.TagCount{}
+	q := p.conn.Table("tags").
+		Select("tags.name AS name, COUNT(DISTINCT pastes.id) AS count").
+		Joins("INNER JOIN paste_tags ON paste_tags.tags_id = tags.id").
+		Joins("INNER JOIN pastes ON pastes.id = paste_tags.paste_id").
+		Where(visible+" AND (pastes.expires IS NULL OR pastes.expires >= ?)", args...).
+		Group("tags.name").
+		Order("count desc, tags.name").
+		Scan(&tags)
+	if q.Error != nil {
+		return params.TagListResult{}, errors.Wrap(q.Error, "fetching tags from database")
+	}
+	return params.TagListResult{
+		Tags: tags,
+	}, nil
+}
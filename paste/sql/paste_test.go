@@ -2,6 +2,7 @@ package sql_test
 
 import (
 	"context"
+	"fmt"
 	"path/filepath"
 	"testing"
 
@@ -32,11 +33,11 @@ func newPasterFixture(t *testing.T) (pasteCommon.Paster, context.Context) {
 	t.Helper()
 	dbCfg := testDBConfig(t)
 
-	paster, err := pasteSQL.NewPaster(dbCfg)
+	paster, err := pasteSQL.NewPaster(dbCfg, nil)
 	if err != nil {
 		t.Fatalf("NewPaster: %v", err)
 	}
-	mgr, err := adminSQL.NewUserManager(dbCfg)
+	mgr, err := adminSQL.NewUserManager(dbCfg, config.PasswordPolicy{}, nil)
 	if err != nil {
 		t.Fatalf("NewUserManager: %v", err)
 	}
@@ -63,7 +64,7 @@ func pInt(n int) *int { return &n }
 // mustCreate is a helper to create a paste and fail the test on error.
 func mustCreate(t *testing.T, paster pasteCommon.Paster, ctx context.Context, title string, public bool, maxAccesses *int) params.Paste {
 	t.Helper()
-	p, err := paster.Create(ctx, []byte("paste content"), title, "text", "", nil, public, "", nil, maxAccesses)
+	p, err := paster.Create(ctx, []byte("paste content"), title, "text", "", nil, public, "", nil, maxAccesses, nil)
 	if err != nil {
 		t.Fatalf("Create(%q): %v", title, err)
 	}
@@ -235,3 +236,198 @@ func TestDelete_RemovesPasteImmediately(t *testing.T) {
 		t.Fatalf("Get after Delete: want NotFound, got %v", err)
 	}
 }
+
+// ── Tags ──────────────────────────────────────────────────────────────────────
+
+func mustCreateTagged(t *testing.T, paster pasteCommon.Paster, ctx context.Context, title string, tags []string) params.Paste {
+	t.Helper()
+	p, err := paster.Create(ctx, []byte("paste content"), title, "text", "", nil, false, "", nil, nil, tags)
+	if err != nil {
+		t.Fatalf("Create(%q): %v", title, err)
+	}
+	return p
+}
+
+func TestCreate_NormalizesTags(t *testing.T) {
+	paster, ctx := newPasterFixture(t)
+	p := mustCreateTagged(t, paster, ctx, "tagged", []string{" K8S ", "Incident 4411", "k8s"})
+	if len(p.Tags) != 2 || p.Tags[0] != "incident-4411" || p.Tags[1] != "k8s" {
+		t.Fatalf("Tags: unexpected value %v", p.Tags)
+	}
+
+	got, err := paster.Get(ctx, p.PasteID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(got.Tags) != 2 {
+		t.Errorf("Get Tags: want 2 tags, got %v", got.Tags)
+	}
+}
+
+func TestCreate_InvalidTagRejected(t *testing.T) {
+	paster, ctx := newPasterFixture(t)
+	_, err := paster.Create(ctx, []byte("paste content"), "bad-tags", "text", "", nil, false, "", nil, nil, []string{"not/valid"})
+	if _, ok := pkgErrors.Cause(err).(*gErrors.BadRequestError); !ok {
+		t.Fatalf("expected BadRequestError, got %v", err)
+	}
+}
+
+func TestSetTags_ReplacesAndClears(t *testing.T) {
+	paster, ctx := newPasterFixture(t)
+	p := mustCreateTagged(t, paster, ctx, "retag", []string{"old"})
+
+	updated, err := paster.SetTags(ctx, p.PasteID, []string{"new", "k8s"})
+	if err != nil {
+		t.Fatalf("SetTags: %v", err)
+	}
+	if len(updated.Tags) != 2 || updated.Tags[0] != "k8s" || updated.Tags[1] != "new" {
+		t.Errorf("SetTags: unexpected tags %v", updated.Tags)
+	}
+
+	got, err := paster.Get(ctx, p.PasteID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(got.Tags) != 2 {
+		t.Errorf("Get after SetTags: want 2 tags, got %v", got.Tags)
+	}
+
+	if _, err := paster.SetTags(ctx, p.PasteID, []string{}); err != nil {
+		t.Fatalf("SetTags(empty): %v", err)
+	}
+	got, err = paster.Get(ctx, p.PasteID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(got.Tags) != 0 {
+		t.Errorf("Get after clearing tags: want none, got %v", got.Tags)
+	}
+}
+
+func TestList_FiltersByTags(t *testing.T) {
+	paster, ctx := newPasterFixture(t)
+	mustCreateTagged(t, paster, ctx, "both", []string{"k8s", "incident-4411"})
+	mustCreateTagged(t, paster, ctx, "only-k8s", []string{"k8s"})
+	mustCreateTagged(t, paster, ctx, "untagged", nil)
+
+	res, err := paster.List(ctx, 1, 50, []string{"K8s"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(res.Pastes) != 2 {
+		t.Errorf("List(k8s): want 2 pastes, got %d", len(res.Pastes))
+	}
+
+	res, err = paster.List(ctx, 1, 50, []string{"k8s", "incident-4411"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(res.Pastes) != 1 || res.Pastes[0].Name != "both" {
+		t.Errorf("List(k8s, incident-4411): unexpected result %+v", res.Pastes)
+	}
+	if len(res.Pastes) == 1 && len(res.Pastes[0].Tags) != 2 {
+		t.Errorf("List: want tags on results, got %v", res.Pastes[0].Tags)
+	}
+
+	res, err = paster.List(ctx, 1, 50, nil)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(res.Pastes) != 3 {
+		t.Errorf("List(no filter): want 3 pastes, got %d", len(res.Pastes))
+	}
+}
+
+func TestSearch_FiltersByTags(t *testing.T) {
+	paster, ctx := newPasterFixture(t)
+	mustCreateTagged(t, paster, ctx, "runbook", []string{"k8s"})
+	mustCreateTagged(t, paster, ctx, "runbook-old", nil)
+
+	res, err := paster.Search(ctx, "runbook*", 1, 50, []string{"k8s"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(res.Pastes) != 1 || res.Pastes[0].Name != "runbook" {
+		t.Errorf("Search: unexpected result %+v", res.Pastes)
+	}
+}
+
+func TestListTags_Counts(t *testing.T) {
+	paster, ctx := newPasterFixture(t)
+	mustCreateTagged(t, paster, ctx, "one", []string{"k8s", "incident-4411"})
+	mustCreateTagged(t, paster, ctx, "two", []string{"k8s"})
+
+	res, err := paster.ListTags(ctx)
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	if len(res.Tags) != 2 {
+		t.Fatalf("ListTags: want 2 tags, got %+v", res.Tags)
+	}
+	if res.Tags[0].Name != "k8s" || res.Tags[0].Count != 2 {
+		t.Errorf("ListTags: want k8s=2 first, got %+v", res.Tags[0])
+	}
+	if res.Tags[1].Name != "incident-4411" || res.Tags[1].Count != 1 {
+		t.Errorf("ListTags: want incident-4411=1, got %+v", res.Tags[1])
+	}
+}
+
+// ── Export ────────────────────────────────────────────────────────────────────
+
+func TestExport_AllPastesInBatches(t *testing.T) {
+	paster, ctx := newPasterFixture(t)
+	// More than one batch of pastes.
+	var created []params.Paste
+	for i := 0; i < 45; i++ {
+		created = append(created, mustCreate(t, paster, ctx, fmt.Sprintf("paste-%d", i), false, nil))
+	}
+
+	var exported []params.ExportedPaste
+	err := paster.Export(ctx, auth.UserID(ctx), func(pst params.ExportedPaste) error {
+		exported = append(exported, pst)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if len(exported) != len(created) {
+		t.Fatalf("Export: want %d pastes, got %d", len(created), len(exported))
+	}
+	for idx, pst := range exported {
+		if pst.Paste.PasteID != created[idx].PasteID {
+			t.Errorf("Export: want %s at %d, got %s", created[idx].PasteID, idx, pst.Paste.PasteID)
+		}
+		if string(pst.Paste.Data) != "paste content" {
+			t.Errorf("Export: want the contents of %s, got %q", pst.Paste.PasteID, pst.Paste.Data)
+		}
+	}
+}
+
+func TestExport_StopsOnError(t *testing.T) {
+	paster, ctx := newPasterFixture(t)
+	mustCreate(t, paster, ctx, "one", false, nil)
+	mustCreate(t, paster, ctx, "two", false, nil)
+
+	calls := 0
+	stop := pkgErrors.New("stop")
+	err := paster.Export(ctx, auth.UserID(ctx), func(params.ExportedPaste) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Export: want the error of the first call, got %v after %d calls", err, calls)
+	}
+}
+
+func TestExport_AnonymousRejected(t *testing.T) {
+	paster, ctx := newPasterFixture(t)
+	mustCreate(t, paster, ctx, "one", false, nil)
+
+	err := paster.Export(context.Background(), auth.UserID(ctx), func(params.ExportedPaste) error {
+		t.Error("Export: unexpected paste")
+		return nil
+	})
+	if _, ok := pkgErrors.Cause(err).(*gErrors.UnauthorizedError); !ok {
+		t.Errorf("Export: want UnauthorizedError, got %v", err)
+	}
+}
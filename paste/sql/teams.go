@@ -2,8 +2,10 @@ package sql
 
 import (
 	"context"
+	"fmt"
 	"math"
 
+	"gopherbin/audit"
 	"gopherbin/auth"
 	"gopherbin/config"
 	gErrors "gopherbin/errors"
@@ -16,21 +18,44 @@ import (
 	"gorm.io/gorm"
 )
 
-func NewTeamManager(dbCfg config.Database) (common.TeamManager, error) {
+func NewTeamManager(dbCfg config.Database, recorder audit.Recorder) (common.TeamManager, error) {
 	db, err := util.NewDBConn(dbCfg)
 	if err != nil {
 		return nil, errors.Wrap(err, "connecting to database")
 	}
+	if recorder == nil {
+		recorder = audit.Discard
+	}
 
 	p := &teamManager{
-		conn: db,
+		conn:     db,
+		recorder: recorder,
 	}
 
 	return p, nil
 }
 
 type teamManager struct {
-	conn *gorm.DB
+	conn     *gorm.DB
+	recorder audit.Recorder
+}
+
+// record records action on the team named name in the audit log
+func (t *teamManager) record(ctx context.Context, action, name string, details map[string]string) {
+	t.recorder.Record(ctx, params.AuditEvent{
+		Action:     action,
+		TargetType: audit.TargetTeam,
+		TargetID:   name,
+		Details:    details,
+	})
+}
+
+// memberDetails returns the audit log details identifying a member
+func memberDetails(member models.Users) map[string]string {
+	return map[string]string{
+		"user_id":  fmt.Sprintf("%d", member.ID),
+		"username": member.Username,
+	}
 }
 
 // TODO: dedup user lookup. Use the admin.UserManager?
@@ -45,7 +70,7 @@ func (t *teamManager) getUserByUsernameOrEmail(userID string) (models.Users, err
 	q := t.conn.Where(queryString, userID).First(&tmpUser)
 	if q.Error != nil {
 		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-			return models.Users{}, gErrors.ErrNotFound
+			return models.Users{}, gErrors.ErrUserNotFound
 		}
 		return models.Users{}, errors.Wrap(q.Error, "fetching user from database")
 	}
@@ -58,7 +83,7 @@ func (t *teamManager) getUser(userID uint) (models.Users, error) {
 	q := t.conn.Where("id = ?", userID).First(&tmpUser)
 	if q.Error != nil {
 		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-			return models.Users{}, gErrors.ErrNotFound
+			return models.Users{}, gErrors.ErrUserNotFound
 		}
 		return models.Users{}, errors.Wrap(q.Error, "fetching user from database")
 	}
@@ -96,7 +121,7 @@ func (t *teamManager) get(name string) (models.Teams, error) {
 	q := t.conn.Preload("Members").Preload("Owner").Where("name = ?", name).First(&teamModel)
 	if q.Error != nil {
 		if errors.Is(q.Error, gorm.ErrRecordNotFound) {
-			return models.Teams{}, gErrors.ErrNotFound
+			return models.Teams{}, gErrors.ErrTeamNotFound
 		}
 		return models.Teams{}, errors.Wrap(q.Error, "fetching team from database")
 	}
@@ -156,7 +181,7 @@ func (t *teamManager) Create(ctx context.Context, name string) (params.Teams, er
 			return params.Teams{}, errors.Wrap(err, "creating team")
 		}
 	} else {
-		return params.Teams{}, errors.Wrap(gErrors.ErrDuplicateEntity, "creating team")
+		return params.Teams{}, gErrors.WithCode(gErrors.NewDuplicateUserError("a team with this name already exists"), gErrors.CodeTeamExists)
 	}
 
 	team := models.Teams{
@@ -169,6 +194,7 @@ func (t *teamManager) Create(ctx context.Context, name string) (params.Teams, er
 	if q.Error != nil {
 		return params.Teams{}, errors.Wrap(q.Error, "creating team")
 	}
+	t.record(ctx, audit.ActionTeamCreate, team.Name, nil)
 
 	return t.sqlToCommonTeams(team, true), nil
 }
@@ -194,6 +220,7 @@ func (t *teamManager) Delete(ctx context.Context, name string) error {
 	if q.Error != nil && !errors.Is(q.Error, gorm.ErrRecordNotFound) {
 		return errors.Wrap(q.Error, "deleting paste")
 	}
+	t.record(ctx, audit.ActionTeamDelete, team.Name, nil)
 	return nil
 }
 
@@ -221,7 +248,7 @@ func (t *teamManager) List(ctx context.Context, page int64, results int64) (team
 	var cnt int64
 	startFrom := (page - 1) * results
 
-	q := t.conn.Preload("Owner").Select("id, name, owner_id").Where("owner = ?", user.ID).Order("id desc")
+	q := t.conn.Preload("Owner").Select("id, name, owner_id").Where("owner_id = ?", user.ID).Order("id desc")
 
 	cntQ := q.Model(&models.Teams{}).Count(&cnt)
 	if cntQ.Error != nil {
@@ -279,6 +306,7 @@ func (t *teamManager) AddMember(ctx context.Context, teamName string, userID str
 	if err := t.conn.Model(&team).Association("Members").Append(&memberUser); err != nil {
 		return params.TeamMember{}, errors.Wrapf(err, "adding member %s to team %s", memberUser.Email, team.Name)
 	}
+	t.record(ctx, audit.ActionTeamMemberAdd, team.Name, memberDetails(memberUser))
 	return sqlUserToTeamMember(memberUser), nil
 }
 
@@ -308,6 +336,7 @@ func (t *teamManager) RemoveMember(ctx context.Context, teamName, member string)
 		}
 		return errors.Wrap(err, "removing member")
 	}
+	t.record(ctx, audit.ActionTeamMemberRemove, team.Name, memberDetails(memberUser))
 	return nil
 }
 
@@ -15,9 +15,12 @@
 package util
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 	"regexp"
+	"strings"
 	"unicode"
 
 	"gopherbin/config"
@@ -31,7 +34,16 @@ import (
 	"gorm.io/gorm"
 )
 
-const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+const (
+	alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// MaxTagLength is the maximum length of a normalized tag name
+	MaxTagLength = 64
+	// MaxTagsPerPaste is the maximum number of tags a paste may have
+	MaxTagsPerPaste = 20
+	// MaxUsernameLength is the length of the username column
+	MaxUsernameLength = 64
+)
 
 // From: https://www.alexedwards.net/blog/validation-snippets-for-go#email-validation
 var rxEmail = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
@@ -53,6 +65,71 @@ func IsAlphanumeric(s string) bool {
 	return true
 }
 
+// UsernameFrom turns a value supplied by an identity provider into a
+// valid username. If the value is an email address, only the part before
+// the @ is used. Characters that are not letters or digits are dropped.
+func UsernameFrom(val string) string {
+	if idx := strings.Index(val, "@"); idx >= 0 {
+		val = val[:idx]
+	}
+	username := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return -1
+	}, val)
+	if len(username) > MaxUsernameLength {
+		username = username[:MaxUsernameLength]
+	}
+	return username
+}
+
+// NormalizeTag lowercases a tag, trims surrounding whitespace and replaces
+// inner whitespace with dashes. Only letters, numbers and the "-", "_", "."
+// and ":" characters are allowed in a tag name.
+func NormalizeTag(tag string) (string, error) {
+	normalized := strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	if normalized == "" {
+		return "", fmt.Errorf("tag may not be empty")
+	}
+	if len(normalized) > MaxTagLength {
+		return "", fmt.Errorf("tag %q is longer than %d characters", tag, MaxTagLength)
+	}
+	for _, r := range normalized {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			continue
+		}
+		switch r {
+		case '-', '_', '.', ':':
+			continue
+		}
+		return "", fmt.Errorf("tag %q contains invalid characters", tag)
+	}
+	return normalized, nil
+}
+
+// NormalizeTags normalizes a list of tags, dropping duplicates while
+// preserving the order in which tags were first seen.
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	ret := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		normalized, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		ret = append(ret, normalized)
+	}
+	if len(ret) > MaxTagsPerPaste {
+		return nil, fmt.Errorf("a paste may have at most %d tags", MaxTagsPerPaste)
+	}
+	return ret, nil
+}
+
 // NewDBConn returns a new gorm db connection, given the config
 func NewDBConn(dbCfg config.Database) (conn *gorm.DB, err error) {
 	dbType, connURI, err := dbCfg.GormParams()
@@ -109,3 +186,12 @@ func GetRandomString(n int) (string, error) {
 
 	return string(data), nil
 }
+
+// DeriveKey returns a key derived from secret for a single purpose, so
+// that tokens signed for one purpose can not be verified as tokens of
+// another, nor as tokens signed with the secret itself.
+func DeriveKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
@@ -47,6 +47,22 @@ func TestIsAlphanumeric(t *testing.T) {
 	}
 }
 
+func TestUsernameFrom(t *testing.T) {
+	cases := map[string]string{
+		"alice":                 "alice",
+		"alice.smith":           "alicesmith",
+		"bob@example.com":       "bob",
+		"Zoë-Müller":            "ZoMller",
+		"":                      "",
+		strings.Repeat("a", 70): strings.Repeat("a", util.MaxUsernameLength),
+	}
+	for input, want := range cases {
+		if got := util.UsernameFrom(input); got != want {
+			t.Errorf("UsernameFrom(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
 func TestGetRandomString_LengthAndCharset(t *testing.T) {
 	const n = 24
 	s, err := util.GetRandomString(n)
@@ -98,3 +114,44 @@ func TestHashString_DifferentInputs(t *testing.T) {
 		t.Fatal("collision on different inputs")
 	}
 }
+
+func TestNormalizeTag(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+		valid bool
+	}{
+		{"k8s", "k8s", true},
+		{"  Incident-4411 ", "incident-4411", true},
+		{"Team  Alpha", "team-alpha", true},
+		{"env:prod", "env:prod", true},
+		{"", "", false},
+		{"   ", "", false},
+		{"bad/tag", "", false},
+		{strings.Repeat("a", util.MaxTagLength+1), "", false},
+	}
+	for _, tc := range cases {
+		got, err := util.NormalizeTag(tc.input)
+		if tc.valid && err != nil {
+			t.Errorf("NormalizeTag(%q): unexpected error %v", tc.input, err)
+			continue
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("NormalizeTag(%q): expected error", tc.input)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("NormalizeTag(%q) = %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeTags_Deduplicates(t *testing.T) {
+	got, err := util.NormalizeTags([]string{"K8S", "k8s", "incident-4411"})
+	if err != nil {
+		t.Fatalf("NormalizeTags: %v", err)
+	}
+	if len(got) != 2 || got[0] != "k8s" || got[1] != "incident-4411" {
+		t.Errorf("NormalizeTags: unexpected result %v", got)
+	}
+}
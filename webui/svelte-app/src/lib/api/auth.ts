@@ -1,10 +1,31 @@
 import { apiClient } from './client';
 import type { LoginRequest, LoginResponse } from '$lib/types/api';
+import type { User, UserRegister } from '$lib/types/user';
 
 export async function login(credentials: LoginRequest): Promise<LoginResponse> {
 	return apiClient.post<LoginResponse>('/auth/login', credentials);
 }
 
-export async function logout(token: string): Promise<void> {
-	return apiClient.get<void>('/logout', token);
+// proxyLogin returns a token for the user authenticated by a trusted
+// reverse proxy. It fails if proxy auth is disabled.
+export async function proxyLogin(cookie = false): Promise<LoginResponse> {
+	return apiClient.post<LoginResponse>('/auth/proxy', cookie ? { cookie } : undefined);
+}
+
+// logout revokes the session. Without a token, the cookie session is
+// logged out.
+export async function logout(token: string | null): Promise<void> {
+	return apiClient.post<void>('/logout', undefined, token);
+}
+
+export async function forgotPassword(email: string): Promise<void> {
+	return apiClient.post<void>('/auth/password/forgot', { email });
+}
+
+export async function resetPassword(token: string, password: string): Promise<void> {
+	return apiClient.post<void>('/auth/password/reset', { token, password });
+}
+
+export async function register(data: UserRegister): Promise<User> {
+	return apiClient.post<User>('/auth/register', data);
 }
@@ -2,6 +2,29 @@ import type { ApiError } from '$lib/types/api';
 
 const API_BASE = '/api/v1';
 
+const CSRF_COOKIE = 'gopherbin_csrf';
+const CSRF_HEADER = 'X-CSRF-Token';
+const SAFE_METHODS = ['GET', 'HEAD', 'OPTIONS'];
+
+// csrfToken returns the CSRF token of the cookie session, if any
+function csrfToken(): string | null {
+	if (typeof document === 'undefined') {
+		return null;
+	}
+	for (const cookie of document.cookie.split('; ')) {
+		const [name, value] = cookie.split('=');
+		if (name === CSRF_COOKIE) {
+			return decodeURIComponent(value);
+		}
+	}
+	return null;
+}
+
+// hasCookieSession returns true if the browser holds a cookie session
+export function hasCookieSession(): boolean {
+	return csrfToken() !== null;
+}
+
 export class ApiClient {
 	private async request<T>(
 		endpoint: string,
@@ -15,6 +38,12 @@ export class ApiClient {
 
 		if (token) {
 			headers['Authorization'] = `Bearer ${token}`;
+		} else if (!SAFE_METHODS.includes(options.method ?? 'GET')) {
+			// Cookie sessions must echo the CSRF token on state changing requests
+			const csrf = csrfToken();
+			if (csrf) {
+				headers[CSRF_HEADER] = csrf;
+			}
 		}
 
 		const url = `${API_BASE}${endpoint}`;
@@ -1,11 +1,11 @@
 import { apiClient } from './client';
 import type { Paste, PasteCreate, PasteList, PasteUpdate, PasteShare } from '$lib/types/paste';
 
-export async function createPaste(data: PasteCreate, token: string): Promise<{ paste_id: string }> {
+export async function createPaste(data: PasteCreate, token: string | null): Promise<{ paste_id: string }> {
 	return apiClient.post<{ paste_id: string }>('/paste', data, token);
 }
 
-export async function getPaste(pasteId: string, token: string): Promise<Paste> {
+export async function getPaste(pasteId: string, token: string | null): Promise<Paste> {
 	return apiClient.get<Paste>(`/paste/${pasteId}`, token);
 }
 
@@ -16,7 +16,7 @@ export async function getPublicPaste(pasteId: string): Promise<Paste> {
 export async function listPastes(
 	page: number,
 	maxResults: number,
-	token: string
+	token: string | null
 ): Promise<PasteList> {
 	return apiClient.get<PasteList>(`/paste?page=${page}&max_results=${maxResults}`, token);
 }
@@ -25,7 +25,7 @@ export async function searchPastes(
 	query: string,
 	page: number,
 	maxResults: number,
-	token: string
+	token: string | null
 ): Promise<PasteList> {
 	return apiClient.get<PasteList>(`/paste/search?q=${encodeURIComponent(query)}&page=${page}&max_results=${maxResults}`, token);
 }
@@ -33,16 +33,16 @@ export async function searchPastes(
 export async function updatePaste(
 	pasteId: string,
 	data: PasteUpdate,
-	token: string
+	token: string | null
 ): Promise<void> {
 	return apiClient.put<void>(`/paste/${pasteId}`, data, token);
 }
 
-export async function deletePaste(pasteId: string, token: string): Promise<void> {
+export async function deletePaste(pasteId: string, token: string | null): Promise<void> {
 	return apiClient.delete<void>(`/paste/${pasteId}`, token);
 }
 
-export async function listPasteShares(pasteId: string, token: string): Promise<PasteShare[]> {
+export async function listPasteShares(pasteId: string, token: string | null): Promise<PasteShare[]> {
 	const response = await apiClient.get<{ users: PasteShare[] }>(`/paste/${pasteId}/sharing`, token);
 	return response.users || [];
 }
@@ -50,7 +50,7 @@ export async function listPasteShares(pasteId: string, token: string): Promise<P
 export async function sharePaste(
 	pasteId: string,
 	username: string,
-	token: string
+	token: string | null
 ): Promise<void> {
 	return apiClient.post<void>(`/paste/${pasteId}/sharing`, { userID: username }, token);
 }
@@ -58,7 +58,7 @@ export async function sharePaste(
 export async function unsharePaste(
 	pasteId: string,
 	username: string,
-	token: string
+	token: string | null
 ): Promise<void> {
 	return apiClient.delete<void>(`/paste/${pasteId}/sharing/${username}`, token);
 }
@@ -2,6 +2,11 @@ import { writable } from 'svelte/store';
 import { browser } from '$app/environment';
 import { jwtDecode } from 'jwt-decode';
 import type { LoginResponse, JWTPayload } from '$lib/types/api';
+import { hasCookieSession } from '$lib/api/client';
+
+// Cookie sessions keep the token in an HttpOnly cookie, so only the user
+// information returned by the login is stored.
+const COOKIE_SESSION = 'cookieSession';
 
 interface AuthState {
 	token: string | null;
@@ -23,6 +28,23 @@ function createAuthStore() {
 			};
 		}
 
+		if (localStorage.getItem(COOKIE_SESSION)) {
+			// The CSRF cookie expires along with the session cookie
+			if (hasCookieSession()) {
+				return {
+					token: null,
+					isAdmin: localStorage.getItem('isAdmin') === 'true',
+					username: localStorage.getItem('username'),
+					fullName: localStorage.getItem('fullName'),
+					isAuthenticated: true
+				};
+			}
+			localStorage.removeItem(COOKIE_SESSION);
+			localStorage.removeItem('isAdmin');
+			localStorage.removeItem('username');
+			localStorage.removeItem('fullName');
+		}
+
 		const token = localStorage.getItem('authToken');
 
 		// If we have a token, decode it to get fresh user info
@@ -65,6 +87,28 @@ function createAuthStore() {
 	return {
 		subscribe,
 		login: (data: LoginResponse) => {
+			if (!data.token) {
+				// Cookie session, the user information comes with the response
+				const user = data.user;
+				if (!user) {
+					return;
+				}
+				if (browser) {
+					localStorage.setItem(COOKIE_SESSION, 'true');
+					localStorage.setItem('isAdmin', String(user.is_admin));
+					localStorage.setItem('username', String(user.id));
+					localStorage.setItem('fullName', user.full_name);
+				}
+				set({
+					token: null,
+					isAdmin: user.is_admin,
+					username: String(user.id),
+					fullName: user.full_name,
+					isAuthenticated: true
+				});
+				return;
+			}
+
 			// Decode JWT to get user information
 			const payload = jwtDecode<JWTPayload>(data.token);
 
@@ -85,6 +129,7 @@ function createAuthStore() {
 		},
 		logout: () => {
 			if (browser) {
+				localStorage.removeItem(COOKIE_SESSION);
 				localStorage.removeItem('authToken');
 				localStorage.removeItem('isAdmin');
 				localStorage.removeItem('username');
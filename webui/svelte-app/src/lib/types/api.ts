@@ -1,3 +1,5 @@
+import type { User } from './user';
+
 export interface ApiError {
 	error: string;
 	details: string;
@@ -7,10 +9,16 @@ export interface ApiError {
 export interface LoginRequest {
 	username: string;
 	password: string;
+	// Asks for a session kept in an HttpOnly cookie. The server returns a
+	// token instead if session cookies are disabled.
+	cookie?: boolean;
 }
 
 export interface LoginResponse {
-	token: string;
+	token?: string;
+	// Set instead of token for cookie sessions
+	csrf_token?: string;
+	user?: User;
 }
 
 export interface JWTPayload {
@@ -5,6 +5,7 @@ export interface User {
 	full_name: string;
 	enabled: boolean;
 	is_admin: boolean;
+	pending_approval?: boolean;
 	created_at: string;
 	updated_at: string;
 }
@@ -18,6 +19,14 @@ export interface UserCreate {
 	is_admin?: boolean;
 }
 
+export interface UserRegister {
+	username: string;
+	email: string;
+	password: string;
+	full_name: string;
+	invite_code?: string;
+}
+
 export interface UserUpdate {
 	username?: string;
 	email?: string;
@@ -15,22 +15,36 @@ var _ = (common.Worker)(&maintenanceWorker{})
 var log = loggo.GetLogger("gopherbin.workers.maintenance")
 
 // NewMaintenanceWorker returns a new maintenance worker
-func NewMaintenanceWorker(cfg config.Database) (common.Worker, error) {
-	mgr, err := admin.GetUserManager(cfg)
+func NewMaintenanceWorker(cfg config.Database, throttleCfg config.LoginThrottle) (common.Worker, error) {
+	// The worker never sets passwords or changes users, so it gets the
+	// default policy and does not record anything in the audit log.
+	mgr, err := admin.GetUserManager(cfg, config.PasswordPolicy{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := admin.GetSessionManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+	throttler, err := admin.GetLoginThrottler(cfg, throttleCfg)
 	if err != nil {
 		return nil, err
 	}
 	return &maintenanceWorker{
-		mgr:     mgr,
-		stop:    make(chan struct{}),
-		stopped: make(chan struct{}),
+		mgr:       mgr,
+		sessions:  sessions,
+		throttler: throttler,
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
 	}, nil
 }
 
 type maintenanceWorker struct {
-	mgr     admCommon.UserManager
-	stop    chan struct{}
-	stopped chan struct{}
+	mgr       admCommon.UserManager
+	sessions  admCommon.SessionManager
+	throttler admCommon.LoginThrottler
+	stop      chan struct{}
+	stopped   chan struct{}
 }
 
 func (m *maintenanceWorker) Start() error {
@@ -56,6 +70,14 @@ func (m *maintenanceWorker) loop() {
 			if err := m.mgr.CleanTokens(); err != nil {
 				log.Warningf("error cleaning tokens: %q", err)
 			}
+			log.Infof("cleaning expired sessions")
+			if err := m.sessions.CleanSessions(); err != nil {
+				log.Warningf("error cleaning sessions: %q", err)
+			}
+			log.Infof("cleaning old failed logins")
+			if err := m.throttler.CleanLoginAttempts(); err != nil {
+				log.Warningf("error cleaning failed logins: %q", err)
+			}
 		case <-m.stop:
 			defer close(m.stopped)
 			return
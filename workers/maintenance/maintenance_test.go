@@ -20,10 +20,10 @@ func testDBConfig(t *testing.T) config.Database {
 
 func TestNewMaintenanceWorker(t *testing.T) {
 	dbCfg := testDBConfig(t)
-	if _, err := pasteSQL.NewPaster(dbCfg); err != nil {
+	if _, err := pasteSQL.NewPaster(dbCfg, nil); err != nil {
 		t.Fatalf("migrations: %v", err)
 	}
-	w, err := maintenance.NewMaintenanceWorker(dbCfg)
+	w, err := maintenance.NewMaintenanceWorker(dbCfg, config.LoginThrottle{})
 	if err != nil {
 		t.Fatalf("NewMaintenanceWorker: %v", err)
 	}
@@ -34,10 +34,10 @@ func TestNewMaintenanceWorker(t *testing.T) {
 
 func TestMaintenanceWorker_StartStop(t *testing.T) {
 	dbCfg := testDBConfig(t)
-	if _, err := pasteSQL.NewPaster(dbCfg); err != nil {
+	if _, err := pasteSQL.NewPaster(dbCfg, nil); err != nil {
 		t.Fatalf("migrations: %v", err)
 	}
-	w, err := maintenance.NewMaintenanceWorker(dbCfg)
+	w, err := maintenance.NewMaintenanceWorker(dbCfg, config.LoginThrottle{})
 	if err != nil {
 		t.Fatalf("NewMaintenanceWorker: %v", err)
 	}